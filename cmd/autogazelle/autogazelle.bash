@@ -20,14 +20,34 @@
 #
 # This script may be installed at tools/bazel in your workspace. It must
 # be executable.
+#
+# If AUTOGAZELLE_BEP_FILE is set to a path relative to the workspace root,
+# build commands write a Build Event Protocol file there, and autogazelle
+# reads it on the next run to update packages that failed to build because
+# of missing dependencies.
 
 set -euo pipefail
 
+bep_file="${AUTOGAZELLE_BEP_FILE:-}"
+
 case "${1:-}" in
   build|coverage|cquery|fetch|mobile-install|print_action|query|run|test)
-    "$BAZEL_REAL" run @bazel_gazelle//cmd/autogazelle -- -gazelle=//:gazelle
+    autogazelle_args=(-gazelle=//:gazelle)
+    if [[ -n "$bep_file" ]]; then
+      autogazelle_args+=("-bep=$bep_file")
+    fi
+    "$BAZEL_REAL" run @bazel_gazelle//cmd/autogazelle -- "${autogazelle_args[@]}"
     echo "done running autogazelle" 1>&2
     ;;
 esac
 
+case "${1:-}" in
+  build|coverage|run|test)
+    if [[ -n "$bep_file" ]]; then
+      workspace_dir="$(cd "$(dirname "$0")/.." && pwd)"
+      exec "$BAZEL_REAL" "$1" "--build_event_json_file=$workspace_dir/$bep_file" "${@:2}"
+    fi
+    ;;
+esac
+
 exec "$BAZEL_REAL" "$@"
@@ -22,7 +22,8 @@ limitations under the License.
 // UNIX socket. When it accepts a connection, it runs gazelle in modified
 // directories and closes the connection without transmitting anything.
 // The client simply connects to the server and waits for the connection
-// to be closed.
+// to be closed. If the server can't be reached, the client runs gazelle
+// in the whole workspace itself.
 //
 // autogazelle is intended to be invoked by autogazelle.bash as a bazel
 // wrapper script. It requires the BUILD_WORKSPACE_DIRECTORY environment
@@ -51,6 +52,9 @@ var (
 	serverTimeout = flag.Duration("timeout", 3600*time.Second, "time in seconds the server will listen for a client before quitting")
 	socketPath    = flag.String("socket", "tools/autogazelle.socket", "path to the UNIX socket where the server will listen, relative to the workspace root")
 	logPath       = flag.String("log", "tools/autogazelle.log", "path to the server's log file, relative to the workspace root")
+	hashesPath    = flag.String("hashes", "tools/autogazelle.hashes", "path to the file where the server saves content hashes of directories, relative to the workspace root. Directories whose contents match are skipped, even after a restart.")
+	fallback      = flag.Bool("fallback", true, "whether the client should run gazelle in the whole workspace if the server can't be reached")
+	bepPath       = flag.String("bep", "", "path to the Build Event Protocol JSON file written by the previous bazel command, relative to the workspace root. Packages with targets that failed because of missing dependencies are updated on the next run.")
 )
 
 func main() {
@@ -0,0 +1,175 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// missingDepMessages are fragments of error messages reported by actions
+// and analysis that fail because build files are missing dependencies or
+// refer to targets that no longer exist. Gazelle can usually fix these.
+var missingDepMessages = []string{
+	"missing strict dependencies",
+	"could not import",
+	"cannot find package",
+	"no such package",
+	"no such target",
+	"undeclared inclusion",
+}
+
+// maxStderrSize is the largest stderr file of a failed action that is read
+// when looking for missing dependency errors.
+const maxStderrSize = 1 << 20
+
+// buildEvent contains the fields of a Build Event Protocol event, written
+// by bazel with --build_event_json_file, that are used to find targets
+// that failed because of missing dependencies.
+type buildEvent struct {
+	ID struct {
+		ActionCompleted *struct {
+			Label string `json:"label"`
+		} `json:"actionCompleted"`
+		TargetCompleted *struct {
+			Label string `json:"label"`
+		} `json:"targetCompleted"`
+	} `json:"id"`
+	Action *struct {
+		Success bool `json:"success"`
+		Stderr  *struct {
+			URI string `json:"uri"`
+		} `json:"stderr"`
+		FailureDetail *struct {
+			Message string `json:"message"`
+		} `json:"failureDetail"`
+	} `json:"action"`
+	Aborted *struct {
+		Reason      string `json:"reason"`
+		Description string `json:"description"`
+	} `json:"aborted"`
+}
+
+// readMissingDepDirs reads a Build Event Protocol JSON file and returns the
+// directories of packages in the main repository with targets that failed
+// because of missing dependencies or failed analysis. Directories are
+// relative to the workspace root and sorted.
+func readMissingDepDirs(bepPath string) ([]string, error) {
+	f, err := os.Open(bepPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMissingDepDirs(f)
+}
+
+func parseMissingDepDirs(r io.Reader) ([]string, error) {
+	dirSet := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var ev buildEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			// The file may be truncated if bazel was interrupted.
+			continue
+		}
+		var label string
+		switch {
+		case ev.ID.ActionCompleted != nil && ev.Action != nil && !ev.Action.Success:
+			var msg string
+			if ev.Action.FailureDetail != nil {
+				msg = ev.Action.FailureDetail.Message
+			}
+			if ev.Action.Stderr != nil {
+				msg += "\n" + readFileURI(ev.Action.Stderr.URI)
+			}
+			if isMissingDepMessage(msg) {
+				label = ev.ID.ActionCompleted.Label
+			}
+		case ev.ID.TargetCompleted != nil && ev.Aborted != nil:
+			if ev.Aborted.Reason == "ANALYSIS_FAILURE" || isMissingDepMessage(ev.Aborted.Description) {
+				label = ev.ID.TargetCompleted.Label
+			}
+		}
+		if dir, ok := labelDir(label); ok {
+			dirSet[dir] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(dirSet))
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+func isMissingDepMessage(msg string) bool {
+	for _, m := range missingDepMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// readFileURI returns the contents of a file:// URI, or "" if the URI
+// refers to something else or the file can't be read. Large files are
+// truncated.
+func readFileURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	f, err := os.Open(filepath.FromSlash(u.Path))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(f, maxStderrSize))
+	return string(data)
+}
+
+// labelDir returns the directory of the package of a label in the main
+// repository, like "a/b" for "//a/b:c", or "." for the root package.
+// false is returned for labels in external repositories.
+func labelDir(label string) (string, bool) {
+	label = strings.TrimPrefix(strings.TrimPrefix(label, "@"), "@")
+	if !strings.HasPrefix(label, "//") {
+		return "", false
+	}
+	pkg := strings.TrimPrefix(label, "//")
+	if i := strings.IndexByte(pkg, ':'); i >= 0 {
+		pkg = pkg[:i]
+	}
+	if pkg == "" {
+		return ".", true
+	}
+	return filepath.FromSlash(pkg), true
+}
@@ -30,32 +30,51 @@ import (
 // to the server via a UNIX-domain socket. If the server is not running,
 // it starts the server and tries again. The server does all the work, so
 // the client just waits for the server to complete, then exits.
+//
+// If the server can't be reached, and -fallback is set, the client runs
+// gazelle itself in the whole workspace, so the build doesn't proceed with
+// stale build files.
 func runClient() error {
 	startTime := time.Now()
-	conn, err := net.Dial("unix", *socketPath)
+	conn, err := dialServer()
 	if err != nil {
-		if err := startServer(); err != nil {
-			return fmt.Errorf("error starting server: %v", err)
+		if !*fallback {
+			return err
 		}
-		for retry := 0; retry < 3; retry++ {
-			conn, err = net.Dial("unix", *socketPath)
-			if err == nil {
-				break
-			}
-			// Wait for server to start listening.
-			time.Sleep(1 * time.Second)
+		log.Printf("warning: %v; running gazelle directly in the whole workspace", err)
+		restoreBuildFilesInRepo()
+		if err := runGazelle(fullMode, nil); err != nil {
+			return err
 		}
-		if err != nil {
-			return fmt.Errorf("failed to connect to server: %v", err)
+	} else {
+		defer conn.Close()
+		if _, err := io.Copy(os.Stderr, conn); err != nil {
+			log.Print(err)
 		}
 	}
-	defer conn.Close()
-
-	if _, err := io.Copy(os.Stderr, conn); err != nil {
-		log.Print(err)
-	}
 
 	elapsedTime := time.Since(startTime)
 	log.Printf("ran gazelle in %.3f s", elapsedTime.Seconds())
 	return nil
 }
+
+// dialServer connects to the server. If the server is not running, or the
+// socket is stale, it starts the server and tries again.
+func dialServer() (net.Conn, error) {
+	conn, err := net.Dial("unix", *socketPath)
+	if err == nil {
+		return conn, nil
+	}
+	if err := startServer(); err != nil {
+		return nil, fmt.Errorf("error starting server: %v", err)
+	}
+	for retry := 0; retry < 3; retry++ {
+		conn, err = net.Dial("unix", *socketPath)
+		if err == nil {
+			return conn, nil
+		}
+		// Wait for server to start listening.
+		time.Sleep(1 * time.Second)
+	}
+	return nil, fmt.Errorf("failed to connect to server: %v", err)
+}
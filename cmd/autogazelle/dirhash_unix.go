@@ -0,0 +1,181 @@
+// +build darwin dragonfly freebsd js,wasm linux nacl netbsd openbsd solaris
+
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dirHashStore records content hashes of directories as of the last time
+// Gazelle ran in them. Directories that are written but end up with the
+// same contents, for example, after switching branches and back, can then
+// be skipped. The hashes are saved to a file, so they survive server
+// restarts.
+type dirHashStore struct {
+	path string
+
+	// recordMu serializes calls to record, which may run in the background.
+	recordMu sync.Mutex
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// loadDirHashes reads hashes saved in the file at path. If the file doesn't
+// exist or can't be parsed, the returned store is empty.
+func loadDirHashes(path string) (*dirHashStore, error) {
+	s := &dirHashStore{path: path, hashes: make(map[string]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.hashes); err != nil {
+		s.hashes = make(map[string]string)
+		return s, err
+	}
+	return s, nil
+}
+
+// save writes the hashes to the store's file. The file is replaced
+// atomically, so a server starting concurrently reads either version.
+func (s *dirHashStore) save() error {
+	s.mu.Lock()
+	data, err := json.Marshal(s.hashes)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// len returns the number of directories with recorded hashes.
+func (s *dirHashStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.hashes)
+}
+
+// record records the current content hashes of dirs. It should be called
+// after Gazelle has updated build files in those directories. Since it
+// reads every file in dirs, it may be called in the background.
+//
+// isWritten reports whether a directory was written after Gazelle ran. The
+// hashes of those directories aren't recorded, since Gazelle hasn't seen
+// their current contents. isWritten may be nil.
+func (s *dirHashStore) record(dirs []string, isWritten func(string) bool) {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+	for _, dir := range dirs {
+		if !shouldHashDir(dir) {
+			continue
+		}
+		h, err := hashDir(dir)
+		if err != nil || isWritten != nil && isWritten(dir) {
+			continue
+		}
+		s.mu.Lock()
+		s.hashes[dir] = h
+		s.mu.Unlock()
+	}
+}
+
+// dropUnchanged returns the directories in dirs whose contents differ
+// from the contents recorded the last time Gazelle ran in them. Directories
+// that have never been recorded or can't be read are kept.
+func (s *dirHashStore) dropUnchanged(dirs []string) []string {
+	changed := dirs[:0]
+	for _, dir := range dirs {
+		s.mu.Lock()
+		old, ok := s.hashes[dir]
+		s.mu.Unlock()
+		if !ok {
+			changed = append(changed, dir)
+			continue
+		}
+		h, err := hashDir(dir)
+		if err != nil || h != old {
+			s.mu.Lock()
+			delete(s.hashes, dir)
+			s.mu.Unlock()
+			changed = append(changed, dir)
+		}
+	}
+	return changed
+}
+
+// shouldHashDir returns whether the contents of dir should be tracked.
+// Directories in .git and directories that shouldIgnore matches are not.
+func shouldHashDir(dir string) bool {
+	slash := filepath.ToSlash(dir)
+	return slash != ".git" && !strings.HasPrefix(slash, ".git/") && !shouldIgnore(dir)
+}
+
+// hashDir returns a hash of the names of the entries in a directory and
+// the contents of regular files. Files that Gazelle writes (BUILD and
+// BUILD.bazel) are not included.
+func hashDir(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, info := range infos {
+		name := info.Name()
+		if shouldIgnore(filepath.Join(dir, name)) {
+			continue
+		}
+		io.WriteString(h, name)
+		h.Write([]byte{0})
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
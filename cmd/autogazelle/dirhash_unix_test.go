@@ -0,0 +1,105 @@
+// +build darwin dragonfly freebsd js,wasm linux nacl netbsd openbsd solaris
+
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDropUnchangedDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autogazelle_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(rel, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rel := range []string{"same/a.go", "build/a.go", "edited/a.go", "added/a.go", "removed/a.go", "removed/b.go", "written/a.go"} {
+		write(rel, "package a\n")
+	}
+	abs := func(rels ...string) []string {
+		var dirs []string
+		for _, rel := range rels {
+			dirs = append(dirs, filepath.Join(dir, rel))
+		}
+		return dirs
+	}
+
+	hashesPath := filepath.Join(dir, "hashes.json")
+	s, err := loadDirHashes(hashesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := filepath.Join(dir, "written")
+	s.record(abs("same", "build", "edited", "added", "removed", "written", "missing"), func(d string) bool { return d == written })
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to build files don't count, since Gazelle writes them.
+	write("build/BUILD.bazel", "# generated\n")
+	write("edited/a.go", "package b\n")
+	write("added/b.go", "package a\n")
+	if err := os.Remove(filepath.Join(dir, "removed", "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	write("new/a.go", "package a\n")
+
+	// Load the hashes again, as a restarted server would.
+	s, err = loadDirHashes(hashesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.dropUnchanged(abs("same", "build", "edited", "added", "removed", "written", "new"))
+	want := abs("edited", "added", "removed", "written", "new")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Hashes of changed directories are forgotten until they're recorded again.
+	if got := s.dropUnchanged(abs("same", "edited")); !reflect.DeepEqual(got, abs("edited")) {
+		t.Errorf("after drop: got %q; want %q", got, abs("edited"))
+	}
+}
+
+func TestLoadDirHashesInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "autogazelle_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("{"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	s, err := loadDirHashes(f.Name())
+	if err == nil || s == nil || s.len() != 0 {
+		t.Errorf("got %v, %v; want empty store and error", s, err)
+	}
+}
@@ -102,9 +102,30 @@ func runServer() error {
 		defer cancelWatch()
 	}
 
+	// Load the hashes of directories recorded by earlier servers.
+	hashes, err := loadDirHashes(*hashesPath)
+	if err != nil {
+		log.Printf("discarding directory hashes: %v", err)
+	}
+
 	// Wait for clients to connect. Each time the client connects, we run
 	// gazelle, either in the whole repository or in changed directories.
 	mode := fullMode
+	if isWatching && hashes.len() > 0 {
+		// Gazelle ran here before. Instead of running in the whole repository,
+		// run only in directories whose contents changed since then, for
+		// example, after switching branches while the server was stopped.
+		dirs, errs := listDirs(".")
+		for _, err := range errs {
+			log.Print(err)
+		}
+		for _, dir := range dirs {
+			if shouldHashDir(dir) {
+				recordWrite(dir)
+			}
+		}
+		mode = fastMode
+	}
 	for {
 		c, err := ln.Accept()
 		if err != nil {
@@ -122,20 +143,87 @@ func runServer() error {
 
 		log.SetOutput(io.MultiWriter(c, logFile))
 		dirs := getAndClearWrittenDirs()
+		if mode == fastMode {
+			n := len(dirs)
+			dirs = hashes.dropUnchanged(dirs)
+			if skipped := n - len(dirs); skipped > 0 {
+				log.Printf("skipping %d unchanged directories", skipped)
+			}
+			dirs = addMissingDepDirs(dirs)
+		}
 		for _, dir := range dirs {
 			restoreBuildFilesInDir(dir)
 		}
-		if err := runGazelle(mode, dirs); err != nil {
+		err = runGazelle(mode, dirs)
+		if err != nil {
 			log.Print(err)
 		}
 		log.SetOutput(logFile)
 		c.Close()
+
+		// Record the contents of directories Gazelle just ran in, so that
+		// directories that are written but end up with the same contents
+		// can be skipped next time. This reads every file in those
+		// directories, so it's done in the background.
+		if err == nil && isWatching {
+			go func(full bool, dirs []string) {
+				if full {
+					var errs []error
+					dirs, errs = listDirs(".")
+					for _, err := range errs {
+						log.Print(err)
+					}
+				}
+				hashes.record(dirs, isWritten)
+				if err := hashes.save(); err != nil {
+					log.Print(err)
+				}
+			}(mode == fullMode, dirs)
+		}
 		if isWatching {
 			mode = fastMode
 		}
 	}
 }
 
+// lastBEPModTime is the modification time of the Build Event Protocol file
+// when it was last read, so the same build isn't considered twice.
+var lastBEPModTime time.Time
+
+// addMissingDepDirs returns dirs with the directories of packages that
+// failed to build because of missing dependencies in the last bazel command
+// added at the front, if -bep is set. These directories are updated even if
+// they haven't changed, since their build files are likely stale.
+func addMissingDepDirs(dirs []string) []string {
+	if *bepPath == "" {
+		return dirs
+	}
+	st, err := os.Stat(*bepPath)
+	if err != nil || !st.ModTime().After(lastBEPModTime) {
+		return dirs
+	}
+	lastBEPModTime = st.ModTime()
+	failed, err := readMissingDepDirs(*bepPath)
+	if err != nil {
+		log.Print(err)
+		return dirs
+	}
+	if len(failed) == 0 {
+		return dirs
+	}
+	log.Printf("updating %d directories with targets that failed in the last build", len(failed))
+	seen := make(map[string]bool)
+	for _, dir := range failed {
+		seen[dir] = true
+	}
+	for _, dir := range dirs {
+		if !seen[dir] {
+			failed = append(failed, dir)
+		}
+	}
+	return failed
+}
+
 // watchDir listens for file system changes in root and its
 // subdirectories. The record function is called with directories whose
 // contents have changed. New directories are watched recursively.
@@ -242,6 +330,14 @@ func recordWrite(path string) {
 	dirSet[path] = true
 }
 
+// isWritten returns whether a directory has been modified since the last
+// time getAndClearWrittenDirs was called.
+func isWritten(path string) bool {
+	dirSetMutex.Lock()
+	defer dirSetMutex.Unlock()
+	return dirSet[path]
+}
+
 // getAndClearWrittenDirs retrieves a list of directories that have been
 // modified since the last time getAndClearWrittenDirs was called.
 func getAndClearWrittenDirs() []string {
@@ -0,0 +1,592 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/bzlmod"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// goDepsExtension is the label of the .bzl file that declares the go_deps
+// module extension.
+const goDepsExtension = "@gazelle//:extensions.bzl"
+
+// gazelleOverrideDirectives maps go_repository attributes to the Gazelle
+// directives go_deps.gazelle_override uses to express them. build_external
+// has no equivalent directive, so it's not carried over.
+var gazelleOverrideDirectives = map[string]string{
+	"build_file_name":       "build_file_name",
+	"build_file_proto_mode": "proto",
+	"build_tags":            "build_tags",
+}
+
+// bazelModules maps Go module paths to the names of modules in the Bazel
+// Central Registry that build the same Go packages. A bazel_dep on one of
+// these provides the module's packages with the same labels go_deps would,
+// so it can be used instead. Modules like google.golang.org/protobuf are
+// not listed: the protobuf Bazel module builds the C++ and Java runtimes,
+// not the Go packages.
+var bazelModules = map[string]string{
+	"github.com/bazelbuild/bazel-gazelle":       "gazelle",
+	"github.com/bazelbuild/buildtools":          "buildtools",
+	"github.com/bazelbuild/rules_go":            "rules_go",
+	"github.com/envoyproxy/protoc-gen-validate": "protoc-gen-validate",
+}
+
+// updateModuleFile updates MODULE.bazel in the repository root with the
+// go_deps module extension, instead of adding go_repository rules to
+// WORKSPACE. gen is the list of go_repository rules that update-repos would
+// have written.
+//
+// A go_deps.from_file tag is added when importing from go.mod. Otherwise,
+// a go_deps.module tag is added or updated for each module. Rules that
+// download archives get go_deps.archive_override tags, and rules with
+// build file attributes get go_deps.gazelle_override tags. The use_repo
+// call for go_deps is updated to list each repository. With -prune,
+// repositories that weren't generated are removed from use_repo unless
+// they're marked with "# keep" comments.
+//
+// Modules that are also published in the Bazel Central Registry (see
+// bazelModules) are reported, since a bazel_dep is usually a better way to
+// depend on them. With -bazel_deps, they're declared with bazel_dep
+// instead of go_deps.
+//
+// Modules replaced with local directories in go.mod are declared with
+// bazel_dep and local_path_override, if the directories are Bazel modules.
+func updateModuleFile(c *config.Config, uc *updateReposConfig, gen []*rule.Rule) error {
+	mf, err := bzlmod.Load(c, c.RepoRoot)
+	if err != nil {
+		return fmt.Errorf("loading MODULE.bazel file: %v", err)
+	}
+	f := mf.File
+	keepCompactCalls(f)
+
+	goDeps := findGoDepsExtension(f)
+	if goDeps == "" {
+		goDeps = "go_deps"
+		f.Stmt = append(f.Stmt, &bzl.AssignExpr{
+			LHS: &bzl.Ident{Name: goDeps},
+			Op:  "=",
+			RHS: &bzl.CallExpr{
+				X: &bzl.Ident{Name: "use_extension"},
+				List: []bzl.Expr{
+					&bzl.StringExpr{Value: goDepsExtension},
+					&bzl.StringExpr{Value: "go_deps"},
+				},
+				ForceCompact: true,
+			},
+		})
+	}
+
+	fromGoMod := uc.repoFilePath != "" && filepath.Base(uc.repoFilePath) == "go.mod"
+	if fromGoMod {
+		goModLabel, err := goModLabel(c, uc.repoFilePath)
+		if err != nil {
+			return err
+		}
+		setTag(f, goDeps, "from_file", "go_mod", goModLabel, nil)
+	}
+
+	var repoNames []string
+	for _, r := range gen {
+		if r.Kind() == "local_repository" {
+			setLocalModule(c, f, r)
+			continue
+		}
+		importPath := r.AttrString("importpath")
+		if name, ok := bazelModules[importPath]; ok {
+			version := bazelModuleVersion(r.AttrString("version"))
+			switch {
+			case !uc.bazelDeps:
+				log.Printf("%s: module is available in the Bazel Central Registry; consider declaring it with bazel_dep(name = %q) or using -bazel_deps", importPath, name)
+			case version == "":
+				log.Printf("%s: module is available in the Bazel Central Registry, but version %q can't be translated to a Bazel module version; declaring it with go_deps", importPath, r.AttrString("version"))
+			default:
+				setBazelDep(f, name, version)
+				removeTags(f, goDeps, "module", "path", importPath)
+				continue
+			}
+		}
+		if urls := r.AttrStrings("urls"); len(urls) > 0 {
+			setTag(f, goDeps, "archive_override", "path", importPath, []*bzl.AssignExpr{
+				kwarg("urls", urls),
+				kwarg("sha256", r.AttrString("sha256")),
+				kwarg("strip_prefix", r.AttrString("strip_prefix")),
+			})
+		} else if !fromGoMod {
+			version := r.AttrString("version")
+			if version == "" {
+				log.Printf("%s: go_deps can only declare modules by version; skipping %s", importPath, r.Name())
+				continue
+			}
+			setTag(f, goDeps, "module", "path", importPath, []*bzl.AssignExpr{
+				kwarg("version", version),
+				kwarg("sum", r.AttrString("sum")),
+			})
+		}
+
+		var directives []string
+		for _, key := range r.AttrKeys() {
+			d, ok := gazelleOverrideDirectives[key]
+			if !ok {
+				continue
+			}
+			value := r.AttrString(key)
+			if value == "" {
+				value = strings.Join(r.AttrStrings(key), ",")
+			}
+			directives = append(directives, fmt.Sprintf("gazelle:%s %s", d, value))
+		}
+		generation := r.AttrString("build_file_generation")
+		extraArgs := r.AttrStrings("build_extra_args")
+		if len(directives) > 0 || generation != "" || len(extraArgs) > 0 {
+			args := []*bzl.AssignExpr{kwarg("build_file_generation", generation)}
+			if len(directives) > 0 {
+				args = append(args, kwarg("directives", directives))
+			}
+			if len(extraArgs) > 0 {
+				args = append(args, kwarg("build_extra_args", extraArgs))
+			}
+			setTag(f, goDeps, "gazelle_override", "path", importPath, args)
+		}
+		repoNames = append(repoNames, r.Name())
+	}
+	setUseRepo(f, goDeps, repoNames, uc.pruneRules)
+
+	return ioutil.WriteFile(mf.Path, bzl.Format(f), 0666)
+}
+
+// pseudoVersionRe matches Go pseudo-versions, like
+// v0.0.0-20200101000000-0123456789ab, which don't correspond to releases.
+var pseudoVersionRe = regexp.MustCompile(`^v[0-9]+\.(0\.0-|\d+\.\d+-([^+]*\.)?0\.)\d{14}-[A-Za-z0-9]+(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// bazelModuleVersion returns the Bazel module version for a released Go
+// module version, like "0.30.0" for "v0.30.0". Bazel modules in the
+// registry are versioned after the same release tags. "" is returned for
+// pseudo-versions and versions that don't look like releases.
+func bazelModuleVersion(goVersion string) string {
+	if !strings.HasPrefix(goVersion, "v") || pseudoVersionRe.MatchString(goVersion) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(goVersion, "v"), "+incompatible")
+}
+
+// setBazelDep adds or updates a call like
+// bazel_dep(name = name, version = version). New calls are added after the
+// last bazel_dep or after the module call. Calls marked with "# keep"
+// comments are not changed. If version is "", no version is set, and the
+// version of an existing call is left alone.
+func setBazelDep(f *bzl.File, name, version string) {
+	insert := 0
+	for i, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		fn, ok := call.X.(*bzl.Ident)
+		if !ok {
+			continue
+		}
+		switch fn.Name {
+		case "module":
+			insert = i + 1
+		case "bazel_dep":
+			insert = i + 1
+			if s, ok := findKwarg(call, "name").(*bzl.StringExpr); !ok || s.Value != name {
+				continue
+			}
+			if rule.ShouldKeep(call) || version == "" {
+				return
+			}
+			for _, arg := range call.List {
+				if a, ok := arg.(*bzl.AssignExpr); ok {
+					if id, ok := a.LHS.(*bzl.Ident); ok && id.Name == "version" {
+						a.RHS = &bzl.StringExpr{Value: version}
+						return
+					}
+				}
+			}
+			call.List = append(call.List, kwarg("version", version))
+			return
+		}
+	}
+	call := &bzl.CallExpr{
+		X:            &bzl.Ident{Name: "bazel_dep"},
+		List:         []bzl.Expr{kwarg("name", name)},
+		ForceCompact: true,
+	}
+	if version != "" {
+		call.List = append(call.List, kwarg("version", version))
+	}
+	f.Stmt = append(f.Stmt[:insert], append([]bzl.Expr{call}, f.Stmt[insert:]...)...)
+}
+
+// setLocalModule declares the module in the directory of the
+// local_repository rule r with bazel_dep and local_path_override. The
+// directory must contain a MODULE.bazel file with a module name.
+func setLocalModule(c *config.Config, f *bzl.File, r *rule.Rule) {
+	dir := filepath.FromSlash(r.AttrString("path"))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.RepoRoot, dir)
+	}
+	name, err := localModuleName(c, dir)
+	if err != nil {
+		log.Printf("%s: can't declare local replacement with local_path_override: %v", r.Name(), err)
+		return
+	}
+	setBazelDep(f, name, "")
+	setLocalPathOverride(f, name, r.AttrString("path"))
+}
+
+// localModuleName returns the name of the Bazel module declared in
+// MODULE.bazel in dir.
+func localModuleName(c *config.Config, dir string) (string, error) {
+	f, err := bzlmod.Load(c, dir)
+	if err != nil {
+		return "", err
+	}
+	for _, stmt := range f.File.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if fn, ok := call.X.(*bzl.Ident); !ok || fn.Name != "module" {
+			continue
+		}
+		if s, ok := findKwarg(call, "name").(*bzl.StringExpr); ok && s.Value != "" {
+			return s.Value, nil
+		}
+	}
+	return "", fmt.Errorf("%s: module name not found", f.Path)
+}
+
+// setLocalPathOverride adds or updates a call like
+// local_path_override(module_name = name, path = path). New calls are added
+// after the bazel_dep for name. Calls marked with "# keep" comments are not
+// changed.
+func setLocalPathOverride(f *bzl.File, name, path string) {
+	insert := len(f.Stmt)
+	for i, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		fn, ok := call.X.(*bzl.Ident)
+		if !ok {
+			continue
+		}
+		switch fn.Name {
+		case "bazel_dep":
+			if s, ok := findKwarg(call, "name").(*bzl.StringExpr); ok && s.Value == name {
+				insert = i + 1
+			}
+		case "local_path_override":
+			if s, ok := findKwarg(call, "module_name").(*bzl.StringExpr); !ok || s.Value != name {
+				continue
+			}
+			if rule.ShouldKeep(call) {
+				return
+			}
+			if p, ok := findKwarg(call, "path").(*bzl.StringExpr); ok {
+				p.Value = path
+			} else {
+				call.List = append(call.List, kwarg("path", path))
+			}
+			return
+		}
+	}
+	call := &bzl.CallExpr{
+		X:              &bzl.Ident{Name: "local_path_override"},
+		List:           []bzl.Expr{kwarg("module_name", name), kwarg("path", path)},
+		ForceMultiLine: true,
+	}
+	f.Stmt = append(f.Stmt[:insert], append([]bzl.Expr{call}, f.Stmt[insert:]...)...)
+}
+
+// removeTags removes tags like ext.tag(key = value, ...) from f, except
+// for those marked with "# keep" comments.
+func removeTags(f *bzl.File, ext, tag, key, value string) {
+	remove := make(map[bzl.Expr]bool)
+	for _, call := range findTags(f, ext, tag) {
+		if s, ok := findKwarg(call, key).(*bzl.StringExpr); ok && s.Value == value && !rule.ShouldKeep(call) {
+			remove[call] = true
+		}
+	}
+	if len(remove) == 0 {
+		return
+	}
+	stmts := f.Stmt[:0]
+	for _, stmt := range f.Stmt {
+		if !remove[stmt] {
+			stmts = append(stmts, stmt)
+		}
+	}
+	f.Stmt = stmts
+}
+
+// keepCompactCalls marks top-level calls that are written on one line, like
+// bazel_dep(name = "foo", version = "1.0"), so they stay on one line when
+// the file is formatted. Calls Gazelle adds are formatted like calls in
+// WORKSPACE.
+func keepCompactCalls(f *bzl.File) {
+	for _, stmt := range f.Stmt {
+		if assign, ok := stmt.(*bzl.AssignExpr); ok {
+			stmt = assign.RHS
+		}
+		if call, ok := stmt.(*bzl.CallExpr); ok {
+			start, end := call.Span()
+			if start.Line == end.Line {
+				call.ForceCompact = true
+			}
+		}
+	}
+}
+
+// findGoDepsExtension returns the name of the variable the go_deps module
+// extension is assigned to with use_extension, or "" if there is none.
+func findGoDepsExtension(f *bzl.File) string {
+	return findExtension(f, "go_deps")
+}
+
+// findExtension returns the name of the variable the module extension
+// named name, declared in an extensions.bzl file, is assigned to with
+// use_extension, or "" if there is none.
+func findExtension(f *bzl.File, name string) string {
+	for _, stmt := range f.Stmt {
+		assign, ok := stmt.(*bzl.AssignExpr)
+		if !ok {
+			continue
+		}
+		lhs, ok := assign.LHS.(*bzl.Ident)
+		if !ok {
+			continue
+		}
+		call, ok := assign.RHS.(*bzl.CallExpr)
+		if !ok || len(call.List) < 2 {
+			continue
+		}
+		if fn, ok := call.X.(*bzl.Ident); !ok || fn.Name != "use_extension" {
+			continue
+		}
+		bzlFile, ok1 := call.List[0].(*bzl.StringExpr)
+		extName, ok2 := call.List[1].(*bzl.StringExpr)
+		if ok1 && ok2 && strings.HasSuffix(bzlFile.Value, ":extensions.bzl") && extName.Value == name {
+			return lhs.Name
+		}
+	}
+	return ""
+}
+
+// goModLabel returns a label for the go.mod file at goModPath, which must
+// be inside the repository.
+func goModLabel(c *config.Config, goModPath string) (string, error) {
+	abs, err := filepath.Abs(goModPath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(c.RepoRoot, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: go.mod must be inside the repository to be used with -bzlmod", goModPath)
+	}
+	pkg := path.Dir(filepath.ToSlash(rel))
+	if pkg == "." {
+		pkg = ""
+	}
+	return "//" + pkg + ":go.mod", nil
+}
+
+// kwarg returns a keyword argument for a tag or function call. value is
+// converted with rule.ExprFromValue.
+func kwarg(key string, value interface{}) *bzl.AssignExpr {
+	return &bzl.AssignExpr{
+		LHS: &bzl.Ident{Name: key},
+		Op:  "=",
+		RHS: rule.ExprFromValue(value),
+	}
+}
+
+// findTags returns calls to ext.tag at the top level of f.
+func findTags(f *bzl.File, ext, tag string) []*bzl.CallExpr {
+	var calls []*bzl.CallExpr
+	for _, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		dot, ok := call.X.(*bzl.DotExpr)
+		if !ok || dot.Name != tag {
+			continue
+		}
+		if x, ok := dot.X.(*bzl.Ident); ok && x.Name == ext {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// findKwarg returns the value of the keyword argument key in call, or nil.
+func findKwarg(call *bzl.CallExpr, key string) bzl.Expr {
+	for _, arg := range call.List {
+		if a, ok := arg.(*bzl.AssignExpr); ok {
+			if id, ok := a.LHS.(*bzl.Ident); ok && id.Name == key {
+				return a.RHS
+			}
+		}
+	}
+	return nil
+}
+
+// setTag adds or updates a tag like ext.tag(key = value, args...). A tag
+// with the same key value is updated in place: args replace arguments with
+// the same names. Arguments with empty string values are removed. Tags
+// marked with "# keep" comments are not changed.
+func setTag(f *bzl.File, ext, tag, key, value string, args []*bzl.AssignExpr) {
+	var call *bzl.CallExpr
+	for _, c := range findTags(f, ext, tag) {
+		if s, ok := findKwarg(c, key).(*bzl.StringExpr); ok && s.Value == value {
+			call = c
+			break
+		}
+	}
+	if call == nil {
+		call = &bzl.CallExpr{
+			X:    &bzl.DotExpr{X: &bzl.Ident{Name: ext}, Name: tag},
+			List: []bzl.Expr{kwarg(key, value)},
+		}
+		// Keep tags together, before use_repo.
+		if i := findUseRepo(f, ext); i >= 0 {
+			f.Stmt = append(f.Stmt[:i], append([]bzl.Expr{call}, f.Stmt[i:]...)...)
+		} else {
+			f.Stmt = append(f.Stmt, call)
+		}
+	} else if rule.ShouldKeep(call) {
+		return
+	}
+
+	for _, arg := range args {
+		name := arg.LHS.(*bzl.Ident).Name
+		empty := false
+		if s, ok := arg.RHS.(*bzl.StringExpr); ok && s.Value == "" {
+			empty = true
+		}
+		replaced := false
+		for i := 0; i < len(call.List); i++ {
+			a, ok := call.List[i].(*bzl.AssignExpr)
+			if !ok {
+				continue
+			}
+			if id, ok := a.LHS.(*bzl.Ident); !ok || id.Name != name {
+				continue
+			}
+			if rule.ShouldKeep(a) {
+				replaced = true
+			} else if empty {
+				call.List = append(call.List[:i], call.List[i+1:]...)
+				i--
+			} else {
+				a.RHS = arg.RHS
+				replaced = true
+			}
+		}
+		if !replaced && !empty {
+			call.List = append(call.List, arg)
+		}
+	}
+	if len(call.List) > 1 {
+		call.ForceMultiLine = true
+	}
+}
+
+// findUseRepo returns the index of the use_repo call for ext in f.Stmt, or
+// -1 if there is none.
+func findUseRepo(f *bzl.File, ext string) int {
+	for i, stmt := range f.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok || len(call.List) == 0 {
+			continue
+		}
+		if fn, ok := call.X.(*bzl.Ident); !ok || fn.Name != "use_repo" {
+			continue
+		}
+		if x, ok := call.List[0].(*bzl.Ident); ok && x.Name == ext {
+			return i
+		}
+	}
+	return -1
+}
+
+// setUseRepo adds names to the use_repo call for ext, creating the call if
+// needed. If prune is true, names not in names are removed, except for
+// those marked with "# keep" comments.
+func setUseRepo(f *bzl.File, ext string, names []string, prune bool) {
+	var call *bzl.CallExpr
+	if i := findUseRepo(f, ext); i >= 0 {
+		call = f.Stmt[i].(*bzl.CallExpr)
+	} else {
+		if len(names) == 0 {
+			return
+		}
+		call = &bzl.CallExpr{
+			X:    &bzl.Ident{Name: "use_repo"},
+			List: []bzl.Expr{&bzl.Ident{Name: ext}},
+		}
+		f.Stmt = append(f.Stmt, call)
+	}
+
+	want := make(map[string]bool)
+	for _, name := range names {
+		want[name] = true
+	}
+	var repos []bzl.Expr
+	var other []bzl.Expr
+	for _, arg := range call.List[1:] {
+		s, ok := arg.(*bzl.StringExpr)
+		if !ok {
+			// Keyword arguments rename repositories; leave them alone.
+			other = append(other, arg)
+			continue
+		}
+		if want[s.Value] {
+			delete(want, s.Value)
+		} else if prune && !rule.ShouldKeep(s) {
+			continue
+		}
+		repos = append(repos, s)
+	}
+	for name := range want {
+		repos = append(repos, &bzl.StringExpr{Value: name})
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		return repos[i].(*bzl.StringExpr).Value < repos[j].(*bzl.StringExpr).Value
+	})
+
+	list := []bzl.Expr{call.List[0]}
+	list = append(list, repos...)
+	list = append(list, other...)
+	call.List = list
+	call.ForceMultiLine = len(list) > 2
+}
@@ -0,0 +1,310 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestUpdateModuleFileFromGoMod(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "go.mod", Content: "module example.com/m\n"},
+		{
+			Path: "MODULE.bazel",
+			Content: `module(name = "m")
+
+bazel_dep(name = "gazelle", version = "0.30.0")
+
+deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+deps.gazelle_override(
+    path = "example.com/a",
+    directives = ["gazelle:proto disable"],
+)
+
+use_repo(
+    deps,
+    "com_example_stale",
+    "com_example_kept",  # keep
+    "com_example_b",
+    renamed = "com_example_renamed",
+)
+`,
+		},
+	})
+	defer cleanup()
+
+	a := rule.NewRule("go_repository", "com_example_a")
+	a.SetAttr("importpath", "example.com/a")
+	a.SetAttr("version", "v1.0.0")
+	a.SetAttr("sum", "h1:a=")
+	a.SetAttr("build_file_proto_mode", "disable_global")
+	a.SetAttr("build_tags", []string{"foo", "bar"})
+	b := rule.NewRule("go_repository", "com_example_b")
+	b.SetAttr("importpath", "example.com/b")
+	b.SetAttr("urls", []string{"https://example.com/b.zip"})
+	b.SetAttr("sha256", "1234")
+	b.SetAttr("strip_prefix", "b-1.0")
+
+	c := &config.Config{RepoRoot: dir}
+	uc := &updateReposConfig{
+		repoFilePath: filepath.Join(dir, "go.mod"),
+		pruneRules:   true,
+	}
+	if err := updateModuleFile(c, uc, []*rule.Rule{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+bazel_dep(name = "gazelle", version = "0.30.0")
+
+deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+deps.gazelle_override(
+    path = "example.com/a",
+    directives = [
+        "gazelle:proto disable_global",
+        "gazelle:build_tags foo,bar",
+    ],
+)
+
+deps.from_file(go_mod = "//:go.mod")
+
+deps.archive_override(
+    path = "example.com/b",
+    urls = ["https://example.com/b.zip"],
+    sha256 = "1234",
+    strip_prefix = "b-1.0",
+)
+
+use_repo(
+    deps,
+    "com_example_a",
+    "com_example_b",
+    "com_example_kept",  # keep
+    renamed = "com_example_renamed",
+)
+`,
+	}})
+}
+
+func TestUpdateModuleFileModules(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+go_deps.module(
+    path = "example.com/a",
+    sum = "h1:old=",
+    version = "v0.9.0",
+)
+
+use_repo(go_deps, "com_example_other")
+`,
+	}})
+	defer cleanup()
+
+	a := rule.NewRule("go_repository", "com_example_a")
+	a.SetAttr("importpath", "example.com/a")
+	a.SetAttr("version", "v1.0.0")
+	a.SetAttr("sum", "h1:a=")
+	b := rule.NewRule("go_repository", "com_example_b")
+	b.SetAttr("importpath", "example.com/b")
+	b.SetAttr("commit", "abcd")
+
+	c := &config.Config{RepoRoot: dir}
+	if err := updateModuleFile(c, &updateReposConfig{}, []*rule.Rule{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+go_deps.module(
+    path = "example.com/a",
+    sum = "h1:a=",
+    version = "v1.0.0",
+)
+
+use_repo(
+    go_deps,
+    "com_example_a",
+    "com_example_other",
+)
+`,
+	}})
+}
+
+func TestUpdateModuleFileAddsExtension(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "go/go.mod", Content: "module example.com/m\n"},
+		{Path: "MODULE.bazel", Content: `module(name = "m")`},
+	})
+	defer cleanup()
+
+	c := &config.Config{RepoRoot: dir}
+	uc := &updateReposConfig{repoFilePath: filepath.Join(dir, "go", "go.mod")}
+	if err := updateModuleFile(c, uc, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+go_deps.from_file(go_mod = "//go:go.mod")
+`,
+	}})
+}
+
+func TestUpdateModuleFileBazelDeps(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+bazel_dep(name = "gazelle", version = "0.30.0")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+go_deps.module(
+    path = "github.com/bazelbuild/rules_go",
+    sum = "h1:old=",
+    version = "v0.39.0",
+)
+
+use_repo(go_deps, "io_bazel_rules_go")
+`,
+	}})
+	defer cleanup()
+
+	rulesGo := rule.NewRule("go_repository", "io_bazel_rules_go")
+	rulesGo.SetAttr("importpath", "github.com/bazelbuild/rules_go")
+	rulesGo.SetAttr("version", "v0.41.0")
+	rulesGo.SetAttr("sum", "h1:rules_go=")
+	gazelle := rule.NewRule("go_repository", "bazel_gazelle")
+	gazelle.SetAttr("importpath", "github.com/bazelbuild/bazel-gazelle")
+	gazelle.SetAttr("version", "v0.32.0")
+	gazelle.SetAttr("sum", "h1:gazelle=")
+	pgv := rule.NewRule("go_repository", "com_github_envoyproxy_protoc_gen_validate")
+	pgv.SetAttr("importpath", "github.com/envoyproxy/protoc-gen-validate")
+	pgv.SetAttr("version", "v0.0.0-20200101000000-0123456789ab")
+	pgv.SetAttr("sum", "h1:pgv=")
+
+	c := &config.Config{RepoRoot: dir}
+	uc := &updateReposConfig{bazelDeps: true, pruneRules: true}
+	if err := updateModuleFile(c, uc, []*rule.Rule{rulesGo, gazelle, pgv}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pseudo-versions have no equivalent Bazel module version, so
+	// protoc-gen-validate is still declared with go_deps.
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+bazel_dep(name = "gazelle", version = "0.32.0")
+
+bazel_dep(name = "rules_go", version = "0.41.0")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+
+go_deps.module(
+    path = "github.com/envoyproxy/protoc-gen-validate",
+    version = "v0.0.0-20200101000000-0123456789ab",
+    sum = "h1:pgv=",
+)
+
+use_repo(go_deps, "com_github_envoyproxy_protoc_gen_validate")
+`,
+	}})
+}
+
+func TestUpdateModuleFileLocalReplace(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "MODULE.bazel",
+			Content: `module(name = "m")
+
+bazel_dep(name = "gazelle", version = "0.30.0")
+`,
+		},
+		{Path: "third_party/local/MODULE.bazel", Content: `module(name = "local_mod")` + "\n"},
+		{Path: "third_party/nomodule/WORKSPACE"},
+	})
+	defer cleanup()
+
+	local := rule.NewRule("local_repository", "com_example_local")
+	local.SetAttr("path", "third_party/local")
+	noModule := rule.NewRule("local_repository", "com_example_nomodule")
+	noModule.SetAttr("path", "third_party/nomodule")
+
+	c := &config.Config{RepoRoot: dir}
+	uc := &updateReposConfig{}
+	if err := updateModuleFile(c, uc, []*rule.Rule{local, noModule}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories without MODULE.bazel files can't be declared with
+	// local_path_override, so they're skipped.
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "m")
+
+bazel_dep(name = "gazelle", version = "0.30.0")
+
+bazel_dep(name = "local_mod")
+
+local_path_override(
+    module_name = "local_mod",
+    path = "third_party/local",
+)
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+`,
+	}})
+}
+
+func TestBazelModuleVersion(t *testing.T) {
+	for _, tc := range []struct {
+		goVersion, want string
+	}{
+		{"v0.41.0", "0.41.0"},
+		{"v1.2.3-rc.1", "1.2.3-rc.1"},
+		{"v2.0.0+incompatible", "2.0.0"},
+		{"v0.0.0-20200101000000-0123456789ab", ""},
+		{"v1.2.4-0.20200101000000-0123456789ab", ""},
+		{"", ""},
+	} {
+		if got := bazelModuleVersion(tc.goVersion); got != tc.want {
+			t.Errorf("bazelModuleVersion(%q): got %q; want %q", tc.goVersion, got, tc.want)
+		}
+	}
+}
@@ -19,23 +19,58 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
-	"path/filepath"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
 	"github.com/pmezard/go-difflib/difflib"
 )
 
 var exitError = fmt.Errorf("encountered changes while running diff")
 
 func diffFile(c *config.Config, f *rule.File) error {
-	rel, err := filepath.Rel(c.RepoRoot, f.Path)
+	diff, err := unifiedDiff(c, f)
 	if err != nil {
-		return fmt.Errorf("error getting old path for file %q: %v", f.Path, err)
+		return err
 	}
-	rel = filepath.ToSlash(rel)
 
+	uc := getUpdateConfig(c)
+	var out io.Writer = os.Stdout
+	if uc.patchPath != "" {
+		out = &uc.patchBuffer
+	}
+	if err := difflib.WriteUnifiedDiff(out, diff); err != nil {
+		return fmt.Errorf("error diffing %s: %v", f.Path, err)
+	}
+	if ds, _ := difflib.GetUnifiedDiffString(diff); ds != "" {
+		return exitError
+	}
+
+	return nil
+}
+
+// reportFrozen logs the changes Gazelle would have made to f, a build file
+// in a directory frozen with # gazelle:frozen. Nothing is written.
+func reportFrozen(c *config.Config, f *rule.File) error {
+	diff, err := unifiedDiff(c, f)
+	if err != nil {
+		return err
+	}
+	ds, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		return fmt.Errorf("error diffing %s: %v", f.Path, err)
+	}
+	if ds != "" {
+		log.Printf("%s: not updating frozen build file; changes would be:\n%s", f.Path, ds)
+	}
+	return nil
+}
+
+// unifiedDiff prepares a diff between the build file on disk (if any) and
+// the formatted content of f.
+func unifiedDiff(c *config.Config, f *rule.File) (difflib.UnifiedDiff, error) {
 	date := "1970-01-01 00:00:00.000000000 +0000"
 	diff := difflib.UnifiedDiff{
 		Context:  3,
@@ -44,46 +79,16 @@ func diffFile(c *config.Config, f *rule.File) error {
 	}
 
 	if oldContent, err := ioutil.ReadFile(f.Path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("error reading original file: %v", err)
+		return diff, fmt.Errorf("error reading original file: %v", err)
 	} else if err != nil {
 		diff.FromFile = "/dev/null"
 	} else if err == nil {
 		diff.A = difflib.SplitLines(string(oldContent))
-		if c.ReadBuildFilesDir == "" {
-			path, err := filepath.Rel(c.RepoRoot, f.Path)
-			if err != nil {
-				return fmt.Errorf("error getting old path for file %q: %v", f.Path, err)
-			}
-			diff.FromFile = filepath.ToSlash(path)
-		} else {
-			diff.FromFile = f.Path
-		}
+		diff.FromFile = displayPath(c, f.Path)
 	}
 
 	newContent := f.Format()
 	diff.B = difflib.SplitLines(string(newContent))
-	outPath := findOutputPath(c, f)
-	if c.WriteBuildFilesDir == "" {
-		path, err := filepath.Rel(c.RepoRoot, f.Path)
-		if err != nil {
-			return fmt.Errorf("error getting new path for file %q: %v", f.Path, err)
-		}
-		diff.ToFile = filepath.ToSlash(path)
-	} else {
-		diff.ToFile = outPath
-	}
-
-	uc := getUpdateConfig(c)
-	var out io.Writer = os.Stdout
-	if uc.patchPath != "" {
-		out = &uc.patchBuffer
-	}
-	if err := difflib.WriteUnifiedDiff(out, diff); err != nil {
-		return fmt.Errorf("error diffing %s: %v", f.Path, err)
-	}
-	if ds, _ := difflib.GetUnifiedDiffString(diff); ds != "" {
-		return exitError
-	}
-
-	return nil
+	diff.ToFile = displayPath(c, runner.OutputPath(c, f))
+	return diff, nil
 }
@@ -150,3 +150,52 @@ func TestDiffReadWriteDir(t *testing.T) {
 	want := append(files, testtools.FileSpec{Path: "p", Content: wantPatch})
 	testtools.CheckFiles(t, dir, want)
 }
+
+func TestDiffBaseDir(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "ws/WORKSPACE"},
+		{
+			Path:    "ws/hello/hello.go",
+			Content: `package hello`,
+		}, {
+			Path:    "read/hello/BUILD.bazel",
+			Content: "# gazelle:prefix example.com/hello",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{
+		"-repo_root=ws",
+		"-base_dir=.",
+		"-mode=diff",
+		"-patch=p",
+		"-experimental_read_build_files_dir=read",
+		"ws/hello",
+	}
+	wantError := "encountered changes while running diff"
+	if err := runGazelle(dir, args); err == nil || err.Error() != wantError {
+		t.Fatalf("got %v; want %q", err, wantError)
+	}
+
+	want := append(files, testtools.FileSpec{
+		Path: "p",
+		Content: `
+--- read/hello/BUILD.bazel	1970-01-01 00:00:00.000000000 +0000
++++ ws/hello/BUILD.bazel	1970-01-01 00:00:00.000000000 +0000
+@@ -1 +1,11 @@
++load("@io_bazel_rules_go//go:def.bzl", "go_library")
++
+ # gazelle:prefix example.com/hello
++
++go_library(
++    name = "go_default_library",
++    srcs = ["hello.go"],
++    importpath = "example.com/hello",
++    visibility = ["//visibility:public"],
++)
++
+`,
+	})
+	testtools.CheckFiles(t, dir, want)
+}
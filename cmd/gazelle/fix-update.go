@@ -25,15 +25,16 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
 	"github.com/bazelbuild/bazel-gazelle/label"
-	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/merger"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
 	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
@@ -48,6 +49,30 @@ type updateConfig struct {
 	walkMode       walk.Mode
 	patchPath      string
 	patchBuffer    bytes.Buffer
+	prompter       *prompter
+
+	// changedPackagesPath is the file where the list of packages whose build
+	// files changed is written. Set with -changed_packages_file.
+	changedPackagesPath string
+
+	// baseDir is the directory that paths in diff headers and log messages
+	// are printed relative to. Set with -base_dir. When empty, paths in the
+	// repository are printed relative to the repository root.
+	baseDir string
+
+	// resolveStats counts how imports were resolved. It's printed after the
+	// run when -resolve_stats is set; otherwise it's nil.
+	resolveStats *resolve.Stats
+
+	// progress reports progress of long runs. It's set when -progress_after
+	// is set; otherwise it's nil.
+	progress *progressReporter
+
+	// recordSnapshotPath and verifySnapshotPath are the files where a
+	// snapshot of the generated build files is written or compared with.
+	// Set with -record_snapshot and -verify_snapshot. When either is set,
+	// no files are written.
+	recordSnapshotPath, verifySnapshotPath string
 }
 
 type emitFunc func(c *config.Config, f *rule.File) error
@@ -65,10 +90,16 @@ func getUpdateConfig(c *config.Config) *updateConfig {
 }
 
 type updateConfigurer struct {
-	mode           string
-	recursive      bool
-	knownImports   []string
-	repoConfigPath string
+	mode            string
+	recursive       bool
+	knownImports    []string
+	repoConfigPath  string
+	interactive     bool
+	yes             bool
+	resolveStats    bool
+	allowOutputBase bool
+	progressAfter   time.Duration
+	progressFile    string
 }
 
 func (ucr *updateConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
@@ -80,8 +111,18 @@ func (ucr *updateConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *conf
 	fs.StringVar(&ucr.mode, "mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
 	fs.BoolVar(&ucr.recursive, "r", true, "when true, gazelle will update subdirectories recursively")
 	fs.StringVar(&uc.patchPath, "patch", "", "when set with -mode=diff, gazelle will write to a file instead of stdout")
+	fs.StringVar(&uc.changedPackagesPath, "changed_packages_file", "", "when set, gazelle will write a list of packages whose build files changed to this file, one target pattern per line")
 	fs.Var(&gzflag.MultiFlag{Values: &ucr.knownImports}, "known_import", "import path for which external resolution is skipped (can specify multiple times)")
 	fs.StringVar(&ucr.repoConfigPath, "repo_config", "", "file where Gazelle should load repository configuration. Defaults to WORKSPACE.")
+	fs.BoolVar(&ucr.interactive, "interactive", false, "when true, gazelle will ask which rule to use for ambiguous imports and whether to delete empty rules")
+	fs.BoolVar(&ucr.yes, "yes", false, "when set with -interactive, gazelle will not ask questions; empty rules are deleted and ambiguous imports are left unresolved")
+	fs.BoolVar(&ucr.resolveStats, "resolve_stats", false, "when true, gazelle prints the number of imports resolved each way for each language to stderr")
+	fs.StringVar(&uc.baseDir, "base_dir", "", "directory that paths in diff headers and log messages are printed relative to. Defaults to the repository root")
+	fs.BoolVar(&ucr.allowOutputBase, "allow_output_base", false, "when true, gazelle may write build files in a Bazel output base, for example, in bazel-out or an external repository")
+	fs.DurationVar(&ucr.progressAfter, "progress_after", 0, "when set, gazelle prints its progress to stderr each time this much time passes (for example, 30s), starting once the run has taken that long")
+	fs.StringVar(&ucr.progressFile, "progress_file", "", "when set with -progress_after, gazelle writes its progress as JSON to this file instead of stderr")
+	fs.StringVar(&uc.recordSnapshotPath, "record_snapshot", "", "when set, gazelle writes fingerprints of the build files it would generate to this file instead of writing them")
+	fs.StringVar(&uc.verifySnapshotPath, "verify_snapshot", "", "when set, gazelle compares the build files it would generate with a snapshot written with -record_snapshot, prints the differences, and fails if there are any. No files are written")
 }
 
 func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
@@ -95,6 +136,59 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	if uc.patchPath != "" && ucr.mode != "diff" {
 		return fmt.Errorf("-patch set but -mode is %s, not diff", ucr.mode)
 	}
+	if uc.recordSnapshotPath != "" && uc.verifySnapshotPath != "" {
+		return fmt.Errorf("-record_snapshot and -verify_snapshot may not both be set")
+	}
+	if uc.recordSnapshotPath != "" || uc.verifySnapshotPath != "" {
+		if uc.patchPath != "" {
+			return fmt.Errorf("-patch may not be set with -record_snapshot or -verify_snapshot")
+		}
+		uc.emit = discardFile
+	}
+	if ucr.yes && !ucr.interactive {
+		return fmt.Errorf("-yes set but -interactive is not set")
+	}
+	if ucr.interactive {
+		if !ucr.yes && !isTerminal(os.Stdin) {
+			return fmt.Errorf("-interactive requires a terminal; use -yes to run without prompts")
+		}
+		uc.prompter = newPrompter(os.Stdin, os.Stderr, ucr.yes)
+		resolve.SetChooser(c, uc.prompter.chooseRule)
+	}
+	if ucr.resolveStats {
+		uc.resolveStats = resolve.NewStats()
+		resolve.SetStats(c, uc.resolveStats)
+	}
+	if ucr.progressAfter < 0 {
+		return fmt.Errorf("-progress_after must not be negative")
+	}
+	if ucr.progressFile != "" && ucr.progressAfter == 0 {
+		return fmt.Errorf("-progress_file set but -progress_after is not set")
+	}
+	if ucr.progressAfter > 0 {
+		uc.progress = newProgressReporter(ucr.progressAfter, ucr.progressFile)
+	}
+
+	if uc.baseDir != "" {
+		baseDir, err := filepath.Abs(uc.baseDir)
+		if err != nil {
+			return fmt.Errorf("-base_dir: failed to find absolute path: %v", err)
+		}
+		baseDir, err = filepath.EvalSymlinks(baseDir)
+		if err != nil {
+			return fmt.Errorf("-base_dir: failed to resolve symlinks: %v", err)
+		}
+		uc.baseDir = baseDir
+		setLogBaseDir(baseDir)
+	} else {
+		setLogBaseDir(c.RepoRoot)
+	}
+
+	if !ucr.allowOutputBase {
+		if err := checkOutputBase(c); err != nil {
+			return err
+		}
+	}
 
 	dirs := fs.Args()
 	if len(dirs) == 0 {
@@ -110,6 +204,11 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 		if err != nil {
 			return fmt.Errorf("%s: failed to resolve symlinks: %v", dirs[i], err)
 		}
+		if !ucr.allowOutputBase {
+			if err := checkDirOutputBase(c, dir); err != nil {
+				return err
+			}
+		}
 		if !isDescendingDir(dir, c.RepoRoot) {
 			return fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, c.RepoRoot)
 		}
@@ -173,7 +272,10 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 		if err != nil {
 			return err
 		}
-		seen := make(map[*rule.File]bool)
+		// WORKSPACE is fixed even if it declares no repositories, since it
+		// may register the Go SDK.
+		uc.workspaceFiles = append(uc.workspaceFiles, workspace)
+		seen := map[*rule.File]bool{workspace: true}
 		for _, f := range repoFileMap {
 			if !seen[f] {
 				uc.workspaceFiles = append(uc.workspaceFiles, f)
@@ -192,72 +294,27 @@ func (ucr *updateConfigurer) KnownDirectives() []string { return nil }
 
 func (ucr *updateConfigurer) Configure(c *config.Config, rel string, f *rule.File) {}
 
-// visitRecord stores information about about a directory visited with
-// packages.Walk.
-type visitRecord struct {
-	// pkgRel is the slash-separated path to the visited directory, relative to
-	// the repository root. "" for the repository root itself.
-	pkgRel string
-
-	// c is the configuration for the directory with directives applied.
-	c *config.Config
-
-	// rules is a list of generated Go rules.
-	rules []*rule.Rule
-
-	// imports contains opaque import information for each rule in rules.
-	imports []interface{}
-
-	// empty is a list of empty Go rules that may be deleted.
-	empty []*rule.Rule
-
-	// file is the build file being processed.
-	file *rule.File
-
-	// mappedKinds are mapped kinds used during this visit.
-	mappedKinds    []config.MappedKind
-	mappedKindInfo map[string]rule.KindInfo
-}
-
-type byPkgRel []visitRecord
-
-func (vs byPkgRel) Len() int           { return len(vs) }
-func (vs byPkgRel) Less(i, j int) bool { return vs[i].pkgRel < vs[j].pkgRel }
-func (vs byPkgRel) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
-
-var genericLoads = []rule.LoadInfo{
-	{
-		Name:    "@bazel_gazelle//:def.bzl",
-		Symbols: []string{"gazelle"},
-	},
-}
-
-func runFixUpdate(cmd command, args []string) (err error) {
-	cexts := make([]config.Configurer, 0, len(languages)+3)
+func runFixUpdate(cmd command, args []string) error {
+	cexts := make([]config.Configurer, 0, len(languages)+4)
 	cexts = append(cexts,
 		&config.CommonConfigurer{},
 		&updateConfigurer{},
 		&walk.Configurer{},
 		&resolve.Configurer{})
-	mrslv := newMetaResolver()
-	kinds := make(map[string]rule.KindInfo)
-	loads := genericLoads
 	for _, lang := range languages {
 		cexts = append(cexts, lang)
-		for kind, info := range lang.Kinds() {
-			mrslv.AddBuiltin(kind, lang)
-			kinds[kind] = info
-		}
-		loads = append(loads, lang.Loads()...)
 	}
-	ruleIndex := resolve.NewRuleIndex(mrslv.Resolver)
 
 	c, err := newFixUpdateConfiguration(cmd, args, cexts)
 	if err != nil {
 		return err
 	}
+	lk, err := runner.CollectKinds(languages, c.KindOwners)
+	if err != nil {
+		return err
+	}
 
-	if err := fixRepoFiles(c, loads); err != nil {
+	if err := fixRepoFiles(c, lk.Loads); err != nil {
 		return err
 	}
 
@@ -268,120 +325,52 @@ func runFixUpdate(cmd command, args []string) (err error) {
 		checkRulesGoVersion(c.RepoRoot)
 	}
 
-	// Visit all directories in the repository.
-	var visits []visitRecord
+	// Generate rules, resolve dependencies, and merge them into build files.
 	uc := getUpdateConfig(c)
-	walk.Walk(c, cexts, uc.dirs, uc.walkMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
-		// If this file is ignored or if Gazelle was not asked to update this
-		// directory, just index the build file and move on.
-		if !update {
-			if c.IndexLibraries && f != nil {
-				for _, r := range f.Rules {
-					ruleIndex.AddRule(c, r, f)
-				}
-			}
-			return
-		}
-
-		// Fix any problems in the file.
-		if f != nil {
-			for _, l := range languages {
-				l.Fix(c, f)
-			}
-		}
-
-		// Generate rules.
-		var empty, gen []*rule.Rule
-		var imports []interface{}
-		for _, l := range languages {
-			res := l.GenerateRules(language.GenerateArgs{
-				Config:       c,
-				Dir:          dir,
-				Rel:          rel,
-				File:         f,
-				Subdirs:      subdirs,
-				RegularFiles: regularFiles,
-				GenFiles:     genFiles,
-				OtherEmpty:   empty,
-				OtherGen:     gen})
-			if len(res.Gen) != len(res.Imports) {
-				log.Panicf("%s: language %s generated %d rules but returned %d imports", rel, l.Name(), len(res.Gen), len(res.Imports))
-			}
-			empty = append(empty, res.Empty...)
-			gen = append(gen, res.Gen...)
-			imports = append(imports, res.Imports...)
-		}
-		if f == nil && len(gen) == 0 {
-			return
-		}
-
-		// Apply and record relevant kind mappings.
-		var (
-			mappedKinds    []config.MappedKind
-			mappedKindInfo = make(map[string]rule.KindInfo)
-		)
-		for _, r := range gen {
-			if repl, ok := c.KindMap[r.Kind()]; ok {
-				mappedKindInfo[repl.KindName] = kinds[r.Kind()]
-				mappedKinds = append(mappedKinds, repl)
-				mrslv.MappedKind(rel, repl)
-				r.SetKind(repl.KindName)
-			}
-		}
-
-		// Insert or merge rules into the build file.
-		if f == nil {
-			f = rule.EmptyFile(filepath.Join(dir, c.DefaultBuildFileName()), rel)
-			for _, r := range gen {
-				r.Insert(f)
-			}
-		} else {
-			merger.MergeFile(f, empty, gen, merger.PreResolve,
-				unionKindInfoMaps(kinds, mappedKindInfo))
-		}
-		visits = append(visits, visitRecord{
-			pkgRel:         rel,
-			c:              c,
-			rules:          gen,
-			imports:        imports,
-			empty:          empty,
-			file:           f,
-			mappedKinds:    mappedKinds,
-			mappedKindInfo: mappedKindInfo,
-		})
-
-		// Add library rules to the dependency resolution table.
-		if c.IndexLibraries {
-			for _, r := range f.Rules {
-				ruleIndex.AddRule(c, r, f)
-			}
-		}
-	})
-
-	// Finish building the index for dependency resolution.
-	ruleIndex.Finish()
+	opts := runner.Options{
+		Config:      c,
+		Configurers: cexts,
+		Languages:   languages,
+		Dirs:        uc.dirs,
+		Mode:        uc.walkMode,
+		Repos:       uc.repos,
+	}
+	if uc.prompter != nil {
+		opts.ConfirmDelete = uc.prompter.confirmDelete
+	}
+	if uc.progress != nil {
+		uc.progress.run()
+		defer uc.progress.stop()
+		opts.ReportProgress = uc.progress.update
+	}
+	files, err := runner.Update(opts)
+	if err != nil {
+		return err
+	}
+	if uc.prompter != nil {
+		uc.prompter.recordDecisions(files)
+	}
 
-	// Resolve dependencies.
-	rc, cleanupRc := repo.NewRemoteCache(uc.repos)
-	defer func() {
-		if cerr := cleanupRc(); err == nil && cerr != nil {
-			err = cerr
-		}
-	}()
-	for _, v := range visits {
-		for i, r := range v.rules {
-			from := label.New(c.RepoName, v.pkgRel, r.Name())
-			mrslv.Resolver(r, v.pkgRel).Resolve(v.c, ruleIndex, rc, r, v.imports[i], from)
-		}
-		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolve,
-			unionKindInfoMaps(kinds, v.mappedKindInfo))
+	// Find changed packages before emitting, since emitting may overwrite
+	// the original files.
+	var changed []string
+	if uc.changedPackagesPath != "" {
+		changed = changedPackages(files)
 	}
 
 	// Emit merged files.
 	var exit error
-	for _, v := range visits {
-		merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, loads))
-		if err := uc.emit(v.c, v.file); err != nil {
+	for i, f := range files {
+		if uc.progress != nil {
+			uc.progress.update(runner.Progress{Phase: phaseWrite, Done: i, Total: len(files)})
+		}
+		if f.Frozen {
+			if err := reportFrozen(f.Config, f.File); err != nil {
+				log.Print(err)
+			}
+			continue
+		}
+		if err := uc.emit(f.Config, f.File); err != nil {
 			if err == exitError {
 				exit = err
 			} else {
@@ -394,10 +383,57 @@ func runFixUpdate(cmd command, args []string) (err error) {
 			return err
 		}
 	}
+	if uc.recordSnapshotPath != "" || uc.verifySnapshotPath != "" {
+		if err := recordOrVerifySnapshot(c, uc, files, os.Stdout); err != nil {
+			return err
+		}
+	}
+	if uc.changedPackagesPath != "" {
+		var buf bytes.Buffer
+		for _, pkg := range changed {
+			fmt.Fprintln(&buf, pkg)
+		}
+		if err := ioutil.WriteFile(uc.changedPackagesPath, buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	if uc.resolveStats != nil {
+		if err := uc.resolveStats.WriteSummary(os.Stderr); err != nil {
+			return err
+		}
+	}
+	if uc.progress != nil {
+		if err := uc.progress.finish(); err != nil {
+			return err
+		}
+	}
+	if n := c.ProblemCount(); n > 0 {
+		return fmt.Errorf("-strict is set, and %d problem(s) were found", n)
+	}
 
 	return exit
 }
 
+// changedPackages returns a sorted list of target patterns (like "//foo:all")
+// for packages whose build files would be created or changed by files. These
+// may be passed to "bazel build" or "bazel test" to build only what
+// Gazelle changed.
+func changedPackages(files []runner.UpdatedFile) []string {
+	var pkgs []string
+	for _, f := range files {
+		if f.Frozen {
+			continue
+		}
+		oldContent, err := ioutil.ReadFile(f.File.Path)
+		if err == nil && bytes.Equal(oldContent, f.File.Format()) {
+			continue
+		}
+		pkgs = append(pkgs, label.New("", f.File.Pkg, "all").String())
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
 func newFixUpdateConfiguration(cmd command, args []string, cexts []config.Configurer) (*config.Config, error) {
 	c := config.New()
 
@@ -409,10 +445,17 @@ func newFixUpdateConfiguration(cmd command, args []string, cexts []config.Config
 	for _, cext := range cexts {
 		cext.RegisterFlags(fs, cmd.String(), c)
 	}
+	schema, err := config.CollectSchema(cexts)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.RegisterFlags(fs, c); err != nil {
+		return nil, err
+	}
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
-			fixUpdateUsage(fs)
+			fixUpdateUsage(fs, schema)
 			return nil, err
 		}
 		// flag already prints the error; don't print it again.
@@ -428,7 +471,7 @@ func newFixUpdateConfiguration(cmd command, args []string, cexts []config.Config
 	return c, nil
 }
 
-func fixUpdateUsage(fs *flag.FlagSet) {
+func fixUpdateUsage(fs *flag.FlagSet, schema config.Schema) {
 	fmt.Fprint(os.Stderr, `usage: gazelle [fix|update] [flags...] [package-dirs...]
 
 The update command creates new build files and update existing BUILD files
@@ -455,11 +498,19 @@ FLAGS:
 
 `)
 	fs.PrintDefaults()
+	if len(schema) > 0 {
+		fmt.Fprint(os.Stderr, `
+DIRECTIVES DECLARED BY EXTENSIONS:
+
+`)
+		schema.WriteDoc(os.Stderr)
+	}
 }
 
 func fixRepoFiles(c *config.Config, loads []rule.LoadInfo) error {
 	uc := getUpdateConfig(c)
 	if !c.ShouldFix {
+		checkGoSDKVersion(c, uc.workspaceFiles, false)
 		return nil
 	}
 	shouldFix := false
@@ -472,6 +523,11 @@ func fixRepoFiles(c *config.Config, loads []rule.LoadInfo) error {
 		return nil
 	}
 
+	if mf := checkGoSDKVersion(c, uc.workspaceFiles, true); mf != nil {
+		if err := uc.emit(c, mf); err != nil {
+			return err
+		}
+	}
 	for _, f := range uc.workspaceFiles {
 		merger.FixLoads(f, loads)
 		if f.Path == filepath.Join(c.RepoRoot, "WORKSPACE") {
@@ -528,73 +584,3 @@ func isDescendingDir(dir, root string) bool {
 	}
 	return !strings.HasPrefix(rel, "..")
 }
-
-func findOutputPath(c *config.Config, f *rule.File) string {
-	if c.ReadBuildFilesDir == "" && c.WriteBuildFilesDir == "" {
-		return f.Path
-	}
-	baseDir := c.WriteBuildFilesDir
-	if c.WriteBuildFilesDir == "" {
-		baseDir = c.RepoRoot
-	}
-	outputDir := filepath.Join(baseDir, filepath.FromSlash(f.Pkg))
-	defaultOutputPath := filepath.Join(outputDir, c.DefaultBuildFileName())
-	files, err := ioutil.ReadDir(outputDir)
-	if err != nil {
-		// Ignore error. Directory probably doesn't exist.
-		return defaultOutputPath
-	}
-	outputPath := rule.MatchBuildFileName(outputDir, c.ValidBuildFileNames, files)
-	if outputPath == "" {
-		return defaultOutputPath
-	}
-	return outputPath
-}
-
-func unionKindInfoMaps(a, b map[string]rule.KindInfo) map[string]rule.KindInfo {
-	if len(a) == 0 {
-		return b
-	}
-	if len(b) == 0 {
-		return a
-	}
-	result := make(map[string]rule.KindInfo, len(a)+len(b))
-	for _, m := range []map[string]rule.KindInfo{a, b} {
-		for k, v := range m {
-			result[k] = v
-		}
-	}
-	return result
-}
-
-// applyKindMappings returns a copy of LoadInfo that includes c.KindMap.
-func applyKindMappings(mappedKinds []config.MappedKind, loads []rule.LoadInfo) []rule.LoadInfo {
-	if len(mappedKinds) == 0 {
-		return loads
-	}
-
-	// Add new RuleInfos or replace existing ones with merged ones.
-	mappedLoads := make([]rule.LoadInfo, len(loads))
-	copy(mappedLoads, loads)
-	for _, mappedKind := range mappedKinds {
-		mappedLoads = appendOrMergeKindMapping(mappedLoads, mappedKind)
-	}
-	return mappedLoads
-}
-
-// appendOrMergeKindMapping adds LoadInfo for the given replacement.
-func appendOrMergeKindMapping(mappedLoads []rule.LoadInfo, mappedKind config.MappedKind) []rule.LoadInfo {
-	// If mappedKind.KindLoad already exists in the list, create a merged copy.
-	for i, load := range mappedLoads {
-		if load.Name == mappedKind.KindLoad {
-			mappedLoads[i].Symbols = append(load.Symbols, mappedKind.KindName)
-			return mappedLoads
-		}
-	}
-
-	// Add a new LoadInfo.
-	return append(mappedLoads, rule.LoadInfo{
-		Name:    mappedKind.KindLoad,
-		Symbols: []string{mappedKind.KindName},
-	})
-}
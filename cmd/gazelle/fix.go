@@ -16,18 +16,11 @@ limitations under the License.
 package main
 
 import (
-	"io/ioutil"
-	"os"
-	"path/filepath"
-
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
 )
 
 func fixFile(c *config.Config, f *rule.File) error {
-	outPath := findOutputPath(c, f)
-	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
-		return err
-	}
-	return ioutil.WriteFile(outPath, f.Format(), 0666)
+	return runner.WriteFile(c, f)
 }
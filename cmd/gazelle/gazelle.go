@@ -30,12 +30,14 @@ const (
 	updateCmd command = iota
 	fixCmd
 	updateReposCmd
+	newCmd
 	helpCmd
 )
 
 var commandFromName = map[string]command{
 	"fix":          fixCmd,
 	"help":         helpCmd,
+	"new":          newCmd,
 	"update":       updateCmd,
 	"update-repos": updateReposCmd,
 }
@@ -45,6 +47,7 @@ var nameFromCommand = []string{
 	"update",
 	"fix",
 	"update-repos",
+	"new",
 	"help",
 }
 
@@ -55,6 +58,8 @@ func (cmd command) String() string {
 func main() {
 	log.SetPrefix("gazelle: ")
 	log.SetFlags(0) // don't print timestamps
+	logOutput = &relPathWriter{w: os.Stderr}
+	log.SetOutput(logOutput)
 
 	if err := run(os.Args[1:]); err != nil && err != flag.ErrHelp {
 		if err == exitError {
@@ -84,6 +89,8 @@ func run(args []string) error {
 		return help()
 	case updateReposCmd:
 		return updateRepos(args)
+	case newCmd:
+		return newPackage(args)
 	default:
 		log.Panicf("unknown command: %v", cmd)
 	}
@@ -109,6 +116,8 @@ Gazelle defaults to "update".
       existing rules.
   update-repos - updates repository rules in the WORKSPACE file. Run with
       -h for details.
+  new - creates a package directory from a template, then generates build
+      files for it. Run with -h for details.
   help - show this message.
 
 For usage information for a specific command, run the command with the -h flag.
@@ -66,6 +66,7 @@ func TestHelp(t *testing.T) {
 		{"fix", "-h"},
 		{"update", "-h"},
 		{"update-repos", "-h"},
+		{"new", "-h"},
 	} {
 		t.Run(args[0], func(t *testing.T) {
 			if err := runGazelle(".", args); err == nil {
@@ -445,7 +446,7 @@ func TestErrorOutsideWorkspace(t *testing.T) {
 			name: "outside workspace",
 			dir:  dir,
 			args: nil,
-			want: "WORKSPACE cannot be found",
+			want: "WORKSPACE or MODULE.bazel cannot be found",
 		}, {
 			name: "outside repo_root",
 			dir:  filepath.Join(dir, "a"),
@@ -2050,6 +2051,84 @@ def foo_repositories():
 	})
 }
 
+func TestPruneRepoRulesReport(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+# gazelle:repo bazel_gazelle
+
+go_repository(
+    name = "org_golang_x_sys",
+    importpath = "golang.org/x/sys",
+)
+
+go_repository(
+    name = "old_errors",
+    importpath = "github.com/pkg/errors",
+)
+
+go_repository(
+    name = "pruneMe",
+    importpath = "pruneMe",
+)
+
+# keep
+go_repository(
+    name = "keepMe",
+    importpath = "keepMe",
+)
+`,
+		}, {
+			Path: "Gopkg.lock",
+			Content: `
+[[projects]]
+  name = "github.com/pkg/errors"
+  packages = ["."]
+  revision = "645ef00459ed84a119197bfb8d8205042c6df63d"
+  version = "v0.8.0"
+
+[[projects]]
+  branch = "master"
+  name = "golang.org/x/sys"
+  packages = ["unix"]
+  revision = "bb24a47a89eac6c1227fbcb2ae37a8b9ed323366"
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"update-repos", "-build_file_generation", "off", "-from_file", "Gopkg.lock", "-prune", "-prune_report", "prune.json"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "prune.json",
+			Content: `
+[
+  {
+    "name": "old_errors",
+    "kind": "go_repository",
+    "file": "WORKSPACE",
+    "reason": "github.com/pkg/errors is now provided by com_github_pkg_errors"
+  },
+  {
+    "name": "pruneMe",
+    "kind": "go_repository",
+    "file": "WORKSPACE",
+    "reason": "pruneMe is not listed in Gopkg.lock, so no remaining dependency requires it"
+  }
+]
+`,
+		},
+	})
+}
+
 func TestDeleteRulesInEmptyDir(t *testing.T) {
 	files := []testtools.FileSpec{
 		{Path: "WORKSPACE"},
@@ -2406,6 +2485,88 @@ go_library(
 	})
 }
 
+func TestVendoredModulesTxt(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+		}, {
+			Path:    "go.mod",
+			Content: "module example.com/m\n",
+		}, {
+			Path: "vendor/modules.txt",
+			Content: `# github.com/bazelbuild/rules_go v0.41.0
+## explicit; go 1.18
+github.com/bazelbuild/rules_go/go/tools/bazel
+# example.com/dep v1.0.0 => ./local/dep
+## explicit
+example.com/dep
+`,
+		}, {
+			Path:    "vendor/github.com/bazelbuild/rules_go/go/tools/bazel/bazel.go",
+			Content: "package bazel\n",
+		}, {
+			Path:    "vendor/example.com/dep/dep.go",
+			Content: "package dep\n",
+		}, {
+			Path:    "vendor/example.com/dep/stale/stale.go",
+			Content: "package stale\n",
+		}, {
+			Path: "app/app.go",
+			Content: `
+package app
+
+import (
+	_ "example.com/dep"
+	_ "example.com/missing"
+	_ "github.com/bazelbuild/rules_go/go/tools/bazel"
+)
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix=example.com/m", "-external=vendored", "-index=false"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "app/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["app.go"],
+    importpath = "example.com/m/app",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//vendor/example.com/dep:go_default_library",
+        "//vendor/github.com/bazelbuild/rules_go/go/tools/bazel:go_default_library",
+    ],
+)
+`,
+		}, {
+			Path: "vendor/example.com/dep/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["dep.go"],
+    importmap = "example.com/m/vendor/example.com/dep",
+    importpath = "example.com/dep",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+	if _, err := os.Stat(filepath.Join(dir, "vendor/example.com/dep/stale/BUILD.bazel")); err == nil {
+		t.Error("build file was generated for package not listed in vendor/modules.txt")
+	}
+}
+
 func TestGoGrpcProtoFlag(t *testing.T) {
 	files := []testtools.FileSpec{
 		{
@@ -2602,9 +2763,11 @@ my_library(
 			Content: `
 load("@io_bazel_rules_go//go:def.bzl", "go_library")
 
-# An existing rule with an unmapped type is preserved
+# An existing rule with an unmapped type is converted to the mapped type
 go_library(
     name = "go_default_library",
+    # Attributes Gazelle doesn't manage are kept
+    testonly = True,
     srcs = ["unmapped_lib.go"],
     importpath = "example.com/mapkind/enabled/existing_rules",
     visibility = ["//visibility:public"],
@@ -2737,13 +2900,15 @@ my_library(
 		{
 			Path: "enabled/existing_rules/unmapped/BUILD.bazel",
 			Content: `
-load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("//tools/go:def.bzl", "my_library")
 
-# An existing rule with an unmapped type is preserved
-go_library(
+# An existing rule with an unmapped type is converted to the mapped type
+my_library(
     name = "go_default_library",
+    # Attributes Gazelle doesn't manage are kept
+    testonly = True,
     srcs = ["unmapped_lib.go"],
-    importpath = "example.com/mapkind/enabled/existing_rules",
+    importpath = "example.com/mapkind/enabled/existing_rules/unmapped",
     visibility = ["//visibility:public"],
 )
 `,
@@ -2896,6 +3061,87 @@ go_test(
 	}})
 }
 
+// TestMajorVersionImportMap checks that libraries in a go_repository that
+// contains another major version of its module in a subdirectory get an
+// importmap when that major version is declared in the repo config, so
+// they don't conflict with libraries in the other go_repository.
+func TestMajorVersionImportMap(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "config/WORKSPACE",
+			Content: `
+go_repository(
+    name = "com_example_mod",
+    importpath = "example.com/mod",
+)
+
+go_repository(
+    name = "com_example_mod_v2",
+    importpath = "example.com/mod/v2",
+)
+`,
+		}, {
+			Path: "repo/WORKSPACE",
+		}, {
+			Path:    "repo/go.mod",
+			Content: "module example.com/mod\n",
+		}, {
+			Path:    "repo/mod.go",
+			Content: "package mod\n",
+		}, {
+			Path:    "repo/v2/go.mod",
+			Content: "module example.com/mod/v2\n",
+		}, {
+			Path:    "repo/v2/mod.go",
+			Content: "package mod\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	repoDir := filepath.Join(dir, "repo")
+	args := []string{
+		"update",
+		"-repo_root", repoDir,
+		"-repo_config", filepath.Join(dir, "config", "WORKSPACE"),
+		"-go_prefix", "example.com/mod",
+		"-go_repository_mode",
+		"-go_repository_module_mode",
+	}
+	if err := runGazelle(repoDir, args); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, repoDir, []testtools.FileSpec{
+		{
+			Path: "BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["mod.go"],
+    importpath = "example.com/mod",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "v2/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["mod.go"],
+    importmap = "com_example_mod/example.com/mod/v2",
+    importpath = "example.com/mod/v2",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
+
 func TestImportCollision(t *testing.T) {
 	files := []testtools.FileSpec{
 		{
@@ -3107,3 +3353,3371 @@ go_library(
 		},
 	})
 }
+
+func TestChangedPackagesFile(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "a/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/foo/a",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "a/a.go",
+			Content: "package a",
+		}, {
+			Path:    "b/b.go",
+			Content: "package b",
+		}, {
+			Path: "c/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/foo/c",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "c/c.go",
+			Content: "package c",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	for _, mode := range []string{"diff", "fix"} {
+		t.Run(mode, func(t *testing.T) {
+			changedPath := filepath.Join(dir, "changed_"+mode+".txt")
+			args := []string{"-go_prefix", "example.com/foo", "-mode", mode, "-changed_packages_file", changedPath}
+			if err := runGazelle(dir, args); err != nil && err != exitError {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(changedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "//b:all\n//c:all\n"
+			if string(got) != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestProgressFileFlag(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "a/a.go", Content: "package a"},
+	})
+	defer cleanup()
+
+	progressPath := filepath.Join(dir, "progress.json")
+	args := []string{"-go_prefix", "example.com/foo", "-progress_file", progressPath}
+	if err := runGazelle(dir, args); err == nil {
+		t.Fatal("got success with -progress_file but no -progress_after; want error")
+	}
+
+	args = append(args, "-progress_after", "1h")
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(progressPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got), `{"phase":"done",`) {
+		t.Errorf("got status %q; want the done phase", got)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	libBuild := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:frozen
+
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/foo/lib",
+    visibility = ["//visibility:public"],
+)
+`
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/BUILD.bazel", Content: libBuild},
+		{Path: "lib/lib.go", Content: "package lib"},
+		{Path: "lib/sub/sub.go", Content: "package sub"},
+		{
+			Path: "bin/main.go",
+			Content: `package main
+
+import _ "example.com/foo/lib"
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "lib/BUILD.bazel", Content: libBuild},
+		{
+			Path: "bin/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/foo/bin",
+    visibility = ["//visibility:private"],
+    deps = ["//lib:go_default_library"],
+)
+
+go_binary(
+    name = "bin",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+	if _, err := os.Stat(filepath.Join(dir, "lib/sub/BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("lib/sub/BUILD.bazel was created in a frozen directory")
+	}
+}
+
+func TestGeneratedSrcs(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "gen/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+genrule(
+    name = "gen_code",
+    cmd = "./gen.sh $(RULEDIR)",
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = [":gen_code"],
+    importpath = "example.com/foo/gen",
+    visibility = ["//visibility:public"],
+    deps = ["@com_example_runtime//:go_default_library"],
+)
+`,
+		}, {
+			Path: "mixed/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "deleted.go",
+        "//gen:gen_code",
+    ],
+    importpath = "example.com/foo/mixed",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//old:go_default_library",
+    ],
+)
+`,
+		}, {
+			Path: "mixed/mixed.go",
+			Content: `package mixed
+
+import _ "example.com/foo/gen"
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "gen/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+genrule(
+    name = "gen_code",
+    cmd = "./gen.sh $(RULEDIR)",
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = [":gen_code"],
+    importpath = "example.com/foo/gen",
+    visibility = ["//visibility:public"],
+    deps = ["@com_example_runtime//:go_default_library"],
+)
+`,
+		}, {
+			Path: "mixed/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "mixed.go",
+        "//gen:gen_code",
+    ],
+    importpath = "example.com/foo/mixed",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//gen:go_default_library",
+        "//old:go_default_library",
+    ],
+)
+`,
+		},
+	})
+}
+
+func TestGoExtraDeps(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:go_extra_deps go_test //testing:testmain
+`,
+		}, {
+			Path: "testing/testmain.go",
+			Content: `package testing
+`,
+		}, {
+			Path: "testing/testmain_test.go",
+			Content: `package testing
+`,
+		}, {
+			Path: "foo/BUILD.bazel",
+			Content: `# gazelle:go_extra_deps go_library :runtime @com_example_tracing//:go_default_library
+`,
+		}, {
+			Path: "foo/foo.go",
+			Content: `package foo
+`,
+		}, {
+			Path: "foo/foo_test.go",
+			Content: `package foo
+`,
+		}, {
+			Path: "foo/bar/bar_test.go",
+			Content: `package bar
+`,
+		}, {
+			Path: "foo/baz/BUILD.bazel",
+			Content: `# gazelle:go_extra_deps go_test
+`,
+		}, {
+			Path: "foo/baz/baz_test.go",
+			Content: `package baz
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "testing/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["testmain.go"],
+    importpath = "example.com/repo/testing",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["testmain_test.go"],
+    embed = [":go_default_library"],
+    deps = [":testmain"],
+)
+`,
+		}, {
+			Path: "foo/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:go_extra_deps go_library :runtime @com_example_tracing//:go_default_library
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+    deps = [
+        ":runtime",
+        "@com_example_tracing//:go_default_library",
+    ],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    embed = [":go_default_library"],
+    deps = ["//testing:testmain"],
+)
+`,
+		}, {
+			Path: "foo/bar/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["bar_test.go"],
+    deps = ["//testing:testmain"],
+)
+`,
+		}, {
+			Path: "foo/baz/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+# gazelle:go_extra_deps go_test
+
+go_test(
+    name = "go_default_test",
+    srcs = ["baz_test.go"],
+)
+`,
+		},
+	})
+}
+
+func TestGoLabelStyleQualified(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path:    "WORKSPACE",
+			Content: `workspace(name = "myrepo")`,
+		}, {
+			Path: "BUILD.bazel",
+			Content: `# gazelle:go_label_style qualified
+# gazelle:go_extra_deps go_test :testmain
+`,
+		}, {
+			Path: "foo/foo.go",
+			Content: `package foo
+
+import _ "example.com/repo/bar"
+`,
+		}, {
+			Path: "bar/bar.go",
+			Content: `package bar
+`,
+		}, {
+			Path: "bar/bar_test.go",
+			Content: `package bar_test
+
+import _ "example.com/repo/foo"
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "foo/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+    deps = ["@myrepo//bar:go_default_library"],
+)
+`,
+		}, {
+			Path: "bar/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["bar.go"],
+    importpath = "example.com/repo/bar",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["bar_test.go"],
+    embed = [":go_default_library"],
+    deps = [
+        "@myrepo//:testmain",
+        "@myrepo//foo:go_default_library",
+    ],
+)
+`,
+		},
+	})
+}
+
+// TestFollowedSymlinkInRepo checks that when a symbolic link to a directory
+// in the repository is followed, rules are only generated in the real
+// directory, and imports through the link resolve there.
+func TestFollowedSymlinkInRepo(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:follow alias\n",
+		}, {
+			Path:    "real/real.go",
+			Content: "package real\n",
+		}, {
+			Path:    "real/sub/sub.go",
+			Content: "package sub\n",
+		}, {
+			Path:    "alias",
+			Symlink: "real",
+		}, {
+			Path: "use/use.go",
+			Content: `package use
+
+import (
+	_ "example.com/repo/alias"
+	_ "example.com/repo/alias/sub"
+)
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "real/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["real.go"],
+    importpath = "example.com/repo/real",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "real/sub/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["sub.go"],
+    importpath = "example.com/repo/real/sub",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "use/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["use.go"],
+    importpath = "example.com/repo/use",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//real:go_default_library",
+        "//real/sub:go_default_library",
+    ],
+)
+`,
+		},
+	})
+}
+
+func TestImportMainPackage(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "cmd/tool/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary")
+
+go_binary(
+    name = "tool",
+    srcs = ["main.go"],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "cmd/tool/main.go",
+			Content: "package main\n\nfunc main() {}\n",
+		}, {
+			Path:    "cmd/tool/main_test.go",
+			Content: "package main\n",
+		}, {
+			Path:    "check/check_test.go",
+			Content: "package check\n\nimport _ \"example.com/repo/cmd/tool\"\n",
+		}, {
+			Path:    "other/other_test.go",
+			Content: "package other\n\nimport _ \"example.com/repo/cmd/tool\"\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"-go_prefix", "example.com/repo"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []testtools.FileSpec{
+		{
+			Path: "cmd/tool/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library", "go_test")
+
+go_binary(
+    name = "tool",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd/tool",
+    visibility = [
+        "//check:__pkg__",
+        "//other:__pkg__",
+    ],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["main_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		}, {
+			Path: "check/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["check_test.go"],
+    deps = ["//cmd/tool:go_default_library"],
+)
+`,
+		},
+	}
+	testtools.CheckFiles(t, dir, want)
+
+	// Running again doesn't change anything.
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, want)
+
+	// Packages that stop importing the library are removed from its
+	// visibility.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other", "other_test.go"), []byte("package other\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "cmd/tool/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library", "go_test")
+
+go_binary(
+    name = "tool",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd/tool",
+    visibility = ["//check:__pkg__"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["main_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+	}})
+}
+
+func TestCheckUnusedDeps(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "a/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:go_default_library",  # keep
+        "//c:go_default_library",  # keep
+        "//d:go_default_library",
+    ],
+)
+`,
+		}, {
+			Path:    "a/a.go",
+			Content: "package a\n\nimport _ \"example.com/repo/b\"\n",
+		}, {
+			Path:    "b/b.go",
+			Content: "package b\n",
+		}, {
+			Path:    "c/c.go",
+			Content: "package c\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	// update only reports the unused dep. Deps without # keep are removed
+	// as usual.
+	args := []string{"-go_prefix", "example.com/repo", "-check_unused_deps"}
+	if err := runGazelle(dir, append([]string{"update"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "a/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:go_default_library",  # keep
+        "//c:go_default_library",  # keep
+    ],
+)
+`,
+	}})
+
+	// fix removes it.
+	if err := runGazelle(dir, append([]string{"fix"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "a/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:go_default_library",  # keep
+    ],
+)
+`,
+	}})
+}
+
+func TestGoRuleTags(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:go_rule_tags team:infra\n",
+		}, {
+			Path: "a/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_rule_tags no-remote-cache
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    tags = [
+        "manual",
+        "team:infra",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "a/a.go",
+			Content: "package a\n",
+		}, {
+			Path:    "a/a_test.go",
+			Content: "package a\n",
+		}, {
+			Path:    "b/BUILD.bazel",
+			Content: "# gazelle:go_rule_tags\n",
+		}, {
+			Path:    "b/b.go",
+			Content: "package b\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	want := []testtools.FileSpec{
+		{
+			Path: "a/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:go_rule_tags no-remote-cache
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    tags = [
+        "manual",
+        "team:infra",
+        "no-remote-cache",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["a_test.go"],
+    embed = [":go_default_library"],
+    tags = [
+        "team:infra",
+        "no-remote-cache",
+    ],
+)
+`,
+		}, {
+			Path: "b/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_rule_tags
+
+go_library(
+    name = "go_default_library",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	}
+
+	// Tags are only added once, so running again changes nothing.
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, want)
+	}
+}
+
+func TestGoPackageAttrs(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:go_package_attrs //.../mocks testonly=true tags=no-coverage\n",
+		}, {
+			Path: "foo/mocks/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["mocks.go"],
+    importpath = "example.com/repo/foo/mocks",
+    tags = ["manual"],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "foo/mocks/mocks.go",
+			Content: "package mocks\n",
+		}, {
+			Path:    "mocks/mocks.go",
+			Content: "package mocks\n",
+		}, {
+			Path:    "foo/foo.go",
+			Content: "package foo\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	// Running again changes nothing.
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{
+				Path: "foo/mocks/BUILD.bazel",
+				Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    testonly = True,
+    srcs = ["mocks.go"],
+    importpath = "example.com/repo/foo/mocks",
+    tags = [
+        "manual",
+        "no-coverage",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+			}, {
+				Path: "mocks/BUILD.bazel",
+				Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    testonly = True,
+    srcs = ["mocks.go"],
+    importpath = "example.com/repo/mocks",
+    tags = ["no-coverage"],
+    visibility = ["//visibility:public"],
+)
+`,
+			}, {
+				Path: "foo/BUILD.bazel",
+				Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+			},
+		})
+	}
+}
+
+func TestProtoGoPackageDirective(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "third_party/foo/BUILD.bazel",
+			Content: "# gazelle:proto_go_package *.proto example.com/foo/pb;foopb\n",
+		}, {
+			Path: "third_party/foo/foo.proto",
+			Content: `syntax = "proto3";
+
+package foo.v1;
+
+option go_package = "github.com/upstream/wrong/foo";
+`,
+		}, {
+			Path:    "app/app.go",
+			Content: "package app\n\nimport _ \"example.com/foo/pb\"\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "third_party/foo/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+# gazelle:proto_go_package *.proto example.com/foo/pb;foopb
+
+proto_library(
+    name = "foopb_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "foopb_go_proto",
+    importpath = "example.com/foo/pb",
+    proto = ":foopb_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foopb_go_proto"],
+    importpath = "example.com/foo/pb",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "app/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["app.go"],
+    importpath = "example.com/repo/app",
+    visibility = ["//visibility:public"],
+    deps = ["//third_party/foo:go_default_library"],
+)
+`,
+		},
+	})
+}
+
+func TestProtoGoPackageDirectiveByPackage(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:proto_go_package package:acme.api example.com/acme/api;acmeapi
+# gazelle:proto_go_package acme/money/... github.com/acme/apis/money/v1;money`,
+		}, {
+			Path: "third_party/acme/api/api.proto",
+			Content: `syntax = "proto3";
+
+package acme.api;
+
+import "acme/money/v1/money.proto";
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "third_party/acme/api/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "acmeapi_proto",
+    srcs = ["api.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//acme/money/v1:v1_proto"],
+)
+
+go_proto_library(
+    name = "acmeapi_go_proto",
+    importpath = "example.com/acme/api",
+    proto = ":acmeapi_proto",
+    visibility = ["//visibility:public"],
+    deps = ["@com_github_acme_apis//money/v1:go_default_library"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":acmeapi_go_proto"],
+    importpath = "example.com/acme/api",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
+func TestGoCrossPlatformsDirective(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:go_cross_platforms @io_bazel_rules_go//go/toolchain:linux_arm64,@io_bazel_rules_go//go/toolchain:windows_amd64\n",
+		}, {
+			Path:    "cmd/main.go",
+			Content: "package main\n\nfunc main() {}\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"-go_prefix", "example.com/repo"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "cmd/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_cross_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd",
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "cmd",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+go_cross_binary(
+    name = "cmd_linux_arm64",
+    platform = "@io_bazel_rules_go//go/toolchain:linux_arm64",
+    target = ":cmd",
+    visibility = ["//visibility:public"],
+)
+
+go_cross_binary(
+    name = "cmd_windows_amd64",
+    platform = "@io_bazel_rules_go//go/toolchain:windows_amd64",
+    target = ":cmd",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte("# gazelle:go_cross_platforms @io_bazel_rules_go//go/toolchain:linux_arm64\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "cmd/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_cross_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd",
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "cmd",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+go_cross_binary(
+    name = "cmd_linux_arm64",
+    platform = "@io_bazel_rules_go//go/toolchain:linux_arm64",
+    target = ":cmd",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGoBinaryNamingCmd(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:go_binary_naming cmd\n",
+		}, {
+			Path:    "cmd/tool/v2/main.go",
+			Content: "package main\n\nfunc main() {}\n",
+		}, {
+			Path: "cmd/old/main/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary")
+
+go_binary(
+    name = "main",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+sh_test(
+    name = "main_test",
+    srcs = ["main_test.sh"],
+    data = [":main"],
+)
+`,
+		}, {
+			Path:    "cmd/old/main/main.go",
+			Content: "package main\n\nfunc main() {}\n",
+		}, {
+			Path: "cmd/taken/v2/BUILD.bazel",
+			Content: `filegroup(
+    name = "taken",
+    srcs = ["README"],
+)
+`,
+		}, {
+			Path:    "cmd/taken/v2/main.go",
+			Content: "package main\n\nfunc main() {}\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"fix", "-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "cmd/tool/v2/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd/tool/v2",
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "tool",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "cmd/old/main/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_binary(
+    name = "old",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+
+sh_test(
+    name = "main_test",
+    srcs = ["main_test.sh"],
+    data = [":old"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd/old/main",
+    visibility = ["//visibility:private"],
+)
+`,
+		}, {
+			Path: "cmd/taken/v2/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+filegroup(
+    name = "taken",
+    srcs = ["README"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd/taken/v2",
+    visibility = ["//visibility:private"],
+)
+
+go_binary(
+    name = "v2",
+    embed = [":go_default_library"],
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
+
+func TestSrcsGlobThreshold(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:srcs_glob_threshold 3\n",
+		},
+		{Path: "big/a.go", Content: "package big\n"},
+		{Path: "big/b.go", Content: "package big\n"},
+		{Path: "big/c.go", Content: "package big\n"},
+		{Path: "big/d.go", Content: "package big\n"},
+		{Path: "big/a_test.go", Content: "package big\n"},
+		{Path: "big/ignore.go", Content: "// +build ignore\n\npackage big\n"},
+		{Path: "small/a.go", Content: "package small\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"-go_prefix", "example.com/repo"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	bigGlob := testtools.FileSpec{
+		Path: "big/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = glob(
+        ["*.go"],
+        exclude = [
+            "*_test.go",
+            "ignore.go",
+        ],
+    ),
+    importpath = "example.com/repo/big",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["a_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		bigGlob,
+		{
+			Path: "small/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/small",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+
+	// Running again doesn't change anything.
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{bigGlob})
+
+	// When the package shrinks below the threshold, the glob is replaced
+	// with a list again.
+	if err := ioutil.WriteFile(filepath.Join(dir, "big", "e_test.go"), []byte("package big\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "big", "d.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "big/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "a.go",
+        "b.go",
+        "c.go",
+    ],
+    importpath = "example.com/repo/big",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = [
+        "a_test.go",
+        "e_test.go",
+    ],
+    embed = [":go_default_library"],
+)
+`,
+	}})
+}
+
+func TestFixRenamedModule(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/new
+`,
+		}, {
+			Path: "a/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/old/a",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "a/a.go",
+			Content: `package a // import "example.com/old/a"`,
+		}, {
+			Path: "b/b.go",
+			Content: `package b
+
+import _ "example.com/new/a"
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	if err := runGazelle(dir, []string{"update", "-mode=diff", "-patch=p"}); err != exitError {
+		t.Fatalf("got %v; want %v", err, exitError)
+	}
+	if want := `go_library "go_default_library" has importpath "example.com/old/a", but "example.com/new/a" is expected`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q\n--begin--\n%s--end--\n", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := runGazelle(dir, []string{"fix"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `import comment "example.com/old/a" does not match importpath "example.com/new/a"`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q\n--begin--\n%s--end--\n", want, buf.String())
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "a/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/new/a",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "b/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["b.go"],
+    importpath = "example.com/new/b",
+    visibility = ["//visibility:public"],
+    deps = ["//a:go_default_library"],
+)
+`,
+		},
+	})
+}
+
+func TestGoInferTestonly(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/m
+# gazelle:go_infer_testonly true
+`,
+		},
+		{Path: "a/a.go", Content: "package a\n\nimport _ \"example.com/m/shared\"\n"},
+		{Path: "a/a_test.go", Content: "package a\n\nimport (\n\t_ \"example.com/m/shared\"\n\t_ \"example.com/m/testutil\"\n)\n"},
+		{Path: "b/b.go", Content: "package b\n\nimport _ \"example.com/m/a\"\n"},
+		{Path: "fakes/fakes.go", Content: "package fakes\n\nimport \"testing\"\n\nvar _ *testing.T\n"},
+		{Path: "shared/shared.go", Content: "package shared\n"},
+		{Path: "testutil/testutil.go", Content: "package testutil\n"},
+		{Path: "unused/unused.go", Content: "package unused\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	want := []testtools.FileSpec{
+		{
+			Path: "fakes/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    testonly = True,
+    srcs = ["fakes.go"],
+    importpath = "example.com/m/fakes",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "shared/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["shared.go"],
+    importpath = "example.com/m/shared",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "testutil/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    testonly = True,
+    srcs = ["testutil.go"],
+    importpath = "example.com/m/testutil",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "unused/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["unused.go"],
+    importpath = "example.com/m/unused",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	}
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, want)
+
+	// Uses in directories that aren't updated are found through deps.
+	if err := runGazelle(dir, []string{"testutil", "shared"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, want)
+}
+
+func TestGoPluginData(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/m\n",
+		},
+		{
+			Path: "loader/BUILD.bazel",
+			Content: `# gazelle:go_plugin_data //plugins/auth:auth.so
+# gazelle:go_plugin_data :libcodec.so @ext//:ext.so
+`,
+		},
+		{Path: "loader/loader.go", Content: "package loader\n\nimport _ \"plugin\"\n"},
+		{Path: "loader/sub/sub.go", Content: "package sub\n"},
+		{
+			Path:    "onlytest/BUILD.bazel",
+			Content: "# gazelle:go_plugin_data //plugins/auth:auth.so\n",
+		},
+		{Path: "onlytest/onlytest_test.go", Content: "package onlytest\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "loader/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_plugin_data //plugins/auth:auth.so
+# gazelle:go_plugin_data :libcodec.so @ext//:ext.so
+
+go_library(
+    name = "go_default_library",
+    srcs = ["loader.go"],
+    data = [
+        "//plugins/auth:auth.so",
+        ":libcodec.so",
+        "@ext//:ext.so",
+    ],
+    importpath = "example.com/m/loader",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			// go_plugin_data is not inherited.
+			Path: "loader/sub/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["sub.go"],
+    importpath = "example.com/m/loader/sub",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "onlytest/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+# gazelle:go_plugin_data //plugins/auth:auth.so
+
+go_test(
+    name = "go_default_test",
+    srcs = ["onlytest_test.go"],
+    data = ["//plugins/auth:auth.so"],
+)
+`,
+		},
+	})
+
+	// Data added by hand is preserved, and missing plugins are added back.
+	if err := ioutil.WriteFile(filepath.Join(dir, "loader", "BUILD.bazel"), []byte(`load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_plugin_data //plugins/auth:auth.so
+# gazelle:go_plugin_data :libcodec.so @ext//:ext.so
+
+go_library(
+    name = "go_default_library",
+    srcs = ["loader.go"],
+    data = [
+        "config.json",
+        ":libcodec.so",
+    ],
+    importpath = "example.com/m/loader",
+    visibility = ["//visibility:public"],
+)
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"loader"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "loader/BUILD.bazel",
+		Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_plugin_data //plugins/auth:auth.so
+# gazelle:go_plugin_data :libcodec.so @ext//:ext.so
+
+go_library(
+    name = "go_default_library",
+    srcs = ["loader.go"],
+    data = [
+        "config.json",
+        ":libcodec.so",
+        "//plugins/auth:auth.so",
+        "@ext//:ext.so",
+    ],
+    importpath = "example.com/m/loader",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGoGrpcGateway(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/m
+# gazelle:go_grpc_gateway true
+# gazelle:go_grpc_gateway_openapi true
+`,
+		},
+		{
+			Path: "echo/echo.proto",
+			Content: `syntax = "proto3";
+
+package echo;
+
+option go_package = "example.com/m/echo";
+
+import "google/api/annotations.proto";
+
+message Msg {
+  string text = 1;
+}
+
+service Echo {
+  rpc Echo(Msg) returns (Msg) {
+    option (google.api.http) = {
+      post: "/v1/echo"
+      body: "*"
+    };
+  }
+}
+`,
+		},
+		{
+			Path: "plain/plain.proto",
+			Content: `syntax = "proto3";
+
+package plain;
+
+option go_package = "example.com/m/plain";
+
+service Plain {
+  rpc Ping(Empty) returns (Empty);
+}
+
+message Empty {}
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "echo/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+load("@grpc_ecosystem_grpc_gateway//protoc-gen-openapiv2:defs.bzl", "protoc_gen_openapiv2")
+
+proto_library(
+    name = "echo_proto",
+    srcs = ["echo.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["@go_googleapis//google/api:annotations_proto"],
+)
+
+go_proto_library(
+    name = "echo_go_proto",
+    compilers = [
+        "@io_bazel_rules_go//proto:go_grpc",
+        "@grpc_ecosystem_grpc_gateway//protoc-gen-grpc-gateway:go_gen_grpc_gateway",
+    ],
+    importpath = "example.com/m/echo",
+    proto = ":echo_proto",
+    visibility = ["//visibility:public"],
+    deps = ["@go_googleapis//google/api:annotations_go_proto"],
+)
+
+protoc_gen_openapiv2(
+    name = "echo_openapiv2",
+    proto = ":echo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":echo_go_proto"],
+    importpath = "example.com/m/echo",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			// Services without HTTP annotations don't get gateway rules.
+			Path: "plain/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "plain_proto",
+    srcs = ["plain.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "plain_go_proto",
+    compilers = ["@io_bazel_rules_go//proto:go_grpc"],
+    importpath = "example.com/m/plain",
+    proto = ":plain_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":plain_go_proto"],
+    importpath = "example.com/m/plain",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+
+	// When the annotations are removed, the gateway compiler and the OpenAPI
+	// rule are removed, too.
+	if err := ioutil.WriteFile(filepath.Join(dir, "echo", "echo.proto"), []byte(`syntax = "proto3";
+
+package echo;
+
+option go_package = "example.com/m/echo";
+
+message Msg {
+  string text = 1;
+}
+
+service Echo {
+  rpc Echo(Msg) returns (Msg);
+}
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"echo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "echo/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "echo_proto",
+    srcs = ["echo.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "echo_go_proto",
+    compilers = ["@io_bazel_rules_go//proto:go_grpc"],
+    importpath = "example.com/m/echo",
+    proto = ":echo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":echo_go_proto"],
+    importpath = "example.com/m/echo",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+// TestGoogleapisDeclared checks that when googleapis is declared in
+// WORKSPACE, common protos are resolved to it for both proto_library and
+// go_proto_library, so they use the same proto_library graph.
+func TestGoogleapisDeclared(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `http_archive(
+    name = "googleapis",
+    urls = ["https://example.com/googleapis.zip"],
+)
+`,
+		}, {
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/m",
+		}, {
+			Path: "status/status.proto",
+			Content: `syntax = "proto3";
+
+package status;
+
+option go_package = "example.com/m/status";
+
+import "google/rpc/status.proto";
+
+message Result {
+  google.rpc.Status status = 1;
+}
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "status/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "status_proto",
+    srcs = ["status.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["@googleapis//google/rpc:status_proto"],
+)
+
+go_proto_library(
+    name = "status_go_proto",
+    importpath = "example.com/m/status",
+    proto = ":status_proto",
+    visibility = ["//visibility:public"],
+    deps = ["@googleapis//google/rpc:status_go_proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":status_go_proto"],
+    importpath = "example.com/m/status",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestProtoFileMode(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/m
+# gazelle:proto file
+`,
+		},
+		{
+			Path: "api/user.proto",
+			Content: `syntax = "proto3";
+
+package api;
+
+option go_package = "example.com/m/api";
+
+import "api/common.proto";
+import "google/protobuf/timestamp.proto";
+
+message User {
+  Id id = 1;
+  google.protobuf.Timestamp created = 2;
+}
+`,
+		},
+		{
+			Path: "api/common.proto",
+			Content: `syntax = "proto3";
+
+package api;
+
+option go_package = "example.com/m/api";
+
+message Id {
+  string value = 1;
+}
+`,
+		},
+		{
+			Path: "api/admin-tools.proto",
+			Content: `syntax = "proto3";
+
+package api.admin;
+
+option go_package = "example.com/m/api/admin";
+
+import "api/user.proto";
+
+message Ban {
+  api.User user = 1;
+}
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	want := []testtools.FileSpec{{
+		Path: "api/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "admin_tools_proto",
+    srcs = ["admin-tools.proto"],
+    visibility = ["//visibility:public"],
+    deps = [":user_proto"],
+)
+
+proto_library(
+    name = "common_proto",
+    srcs = ["common.proto"],
+    visibility = ["//visibility:public"],
+)
+
+proto_library(
+    name = "user_proto",
+    srcs = ["user.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":common_proto",
+        "@com_google_protobuf//:timestamp_proto",
+    ],
+)
+
+go_proto_library(
+    name = "admin_tools_go_proto",
+    importpath = "example.com/m/api/admin",
+    proto = ":admin_tools_proto",
+    visibility = ["//visibility:public"],
+    deps = [":go_default_library"],
+)
+
+go_proto_library(
+    name = "api_go_proto",
+    importpath = "example.com/m/api",
+    protos = [
+        ":common_proto",
+        ":user_proto",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":api_go_proto"],
+    importpath = "example.com/m/api",
+    visibility = ["//visibility:public"],
+)
+`,
+	}}
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, want)
+
+	// Running again doesn't change anything.
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, want)
+}
+
+func TestProtoBindings(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:proto_bindings java,py,descriptor_set`,
+		}, {
+			Path:    "foo/foo.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo;\n\nimport \"bar/bar.proto\";\n",
+		}, {
+			Path:    "bar/bar.proto",
+			Content: "syntax = \"proto3\";\n\npackage bar;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "foo/BUILD.bazel",
+		Content: `
+load("@rules_proto//proto:defs.bzl", "proto_descriptor_set", "proto_library")
+load("@rules_python//python:proto.bzl", "py_proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//bar:bar_proto"],
+)
+
+java_proto_library(
+    name = "foo_java_proto",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+py_proto_library(
+    name = "foo_py_pb2",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+proto_descriptor_set(
+    name = "foo_descriptor_set",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//bar:go_default_library"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	// Bindings are deleted along with the proto_library.
+	if err := os.Remove(filepath.Join(dir, "foo", "foo.proto")); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path:    "foo/BUILD.bazel",
+		Content: "",
+	}})
+}
+
+// TestProtoBindingTemplate checks that bindings defined with
+// -proto_binding_template are generated, loaded, and resolved like built-in
+// bindings.
+func TestProtoBindingTemplate(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:proto_bindings ts",
+		}, {
+			Path:    "foo/foo.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo;\n\nimport \"bar/bar.proto\";\n",
+		}, {
+			Path:    "bar/bar.proto",
+			Content: "syntax = \"proto3\";\n\npackage bar;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{
+		"-go_prefix", "example.com/repo",
+		"-proto_binding_template", "ts ts_proto_library load=@aspect_rules_ts//ts:proto.bzl proto_attr=proto single_proto=true deps_attr=deps",
+	}
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, args); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+			Path: "foo/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@aspect_rules_ts//ts:proto.bzl", "ts_proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//bar:bar_proto"],
+)
+
+ts_proto_library(
+    name = "foo_ts_proto",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//bar:bar_ts_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//bar:go_default_library"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+		}})
+	}
+}
+
+// TestProtoRuleAttr checks that attributes set with proto_rule_attr are
+// added to generated rules, updated in existing rules when the directive
+// changes, and removed when the directive has no value.
+func TestProtoRuleAttr(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:proto_bindings py
+# gazelle:proto_rule_attr py_proto_library tags ["manual"]
+# gazelle:proto_rule_attr proto_library option_deps ["//opts:opts_proto"]
+`,
+		}, {
+			Path: "foo/BUILD.bazel",
+			Content: `# gazelle:proto_rule_attr py_proto_library testonly True
+`,
+		}, {
+			Path:    "foo/foo.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"-go_prefix", "example.com/repo"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "foo/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@rules_python//python:proto.bzl", "py_proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+# gazelle:proto_rule_attr py_proto_library testonly True
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    option_deps = ["//opts:opts_proto"],
+    visibility = ["//visibility:public"],
+)
+
+py_proto_library(
+    name = "foo_py_pb2",
+    testonly = True,
+    tags = ["manual"],
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	// Change the directives in foo. The attributes are updated or removed in
+	// the existing rule.
+	edited := `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@rules_python//python:proto.bzl", "py_proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+# gazelle:proto_rule_attr py_proto_library testonly
+# gazelle:proto_rule_attr py_proto_library tags ["py"]
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    option_deps = ["//opts:opts_proto"],
+    visibility = ["//visibility:public"],
+)
+
+py_proto_library(
+    name = "foo_py_pb2",
+    testonly = True,
+    tags = ["manual"],
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo", "BUILD.bazel"), []byte(edited), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "foo/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@rules_python//python:proto.bzl", "py_proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+# gazelle:proto_rule_attr py_proto_library testonly
+# gazelle:proto_rule_attr py_proto_library tags ["py"]
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    option_deps = ["//opts:opts_proto"],
+    visibility = ["//visibility:public"],
+)
+
+py_proto_library(
+    name = "foo_py_pb2",
+    tags = ["py"],
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+// TestProtoBzlmod checks that labels of the Well Known Types and loads of
+// proto rules refer to the repositories of modules declared in MODULE.bazel.
+func TestProtoBzlmod(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "MODULE.bazel",
+			Content: `bazel_dep(name = "googleapis", version = "0.0.0-20240326-1c8d509c5")
+bazel_dep(name = "protobuf", version = "27.0")
+bazel_dep(name = "rules_proto", version = "6.0.0", repo_name = "my_rules_proto")
+`,
+		}, {
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:proto_bindings descriptor_set",
+		}, {
+			Path:    "foo/foo.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo;\n\nimport \"google/protobuf/any.proto\";\nimport \"google/api/annotations.proto\";\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "foo/BUILD.bazel",
+		Content: `load("@my_rules_proto//proto:defs.bzl", "proto_descriptor_set", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "@googleapis//google/api:annotations_proto",
+        "@protobuf//:any_proto",
+    ],
+)
+
+proto_descriptor_set(
+    name = "foo_descriptor_set",
+    visibility = ["//visibility:public"],
+    deps = [":foo_proto"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+    deps = ["@googleapis//google/api:annotations_go_proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+// TestProtoLite checks that with proto_lite auto, lite variants of bindings
+// are generated for packages built for the lite runtime, and deleted when
+// the option is removed.
+func TestProtoLite(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:proto_bindings java
+# gazelle:proto_lite auto
+`,
+		}, {
+			Path:    "lite/lite.proto",
+			Content: "syntax = \"proto3\";\n\npackage lite;\n\noption optimize_for = LITE_RUNTIME;\n",
+		}, {
+			Path:    "full/full.proto",
+			Content: "syntax = \"proto3\";\n\npackage full;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"-go_prefix", "example.com/repo"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lite/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "lite_proto",
+    srcs = ["lite.proto"],
+    visibility = ["//visibility:public"],
+)
+
+java_proto_library(
+    name = "lite_java_proto",
+    visibility = ["//visibility:public"],
+    deps = [":lite_proto"],
+)
+
+java_lite_proto_library(
+    name = "lite_java_proto_lite",
+    visibility = ["//visibility:public"],
+    deps = [":lite_proto"],
+)
+
+go_proto_library(
+    name = "lite_go_proto",
+    importpath = "example.com/repo/lite",
+    proto = ":lite_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":lite_go_proto"],
+    importpath = "example.com/repo/lite",
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "full/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "full_proto",
+    srcs = ["full.proto"],
+    visibility = ["//visibility:public"],
+)
+
+java_proto_library(
+    name = "full_java_proto",
+    visibility = ["//visibility:public"],
+    deps = [":full_proto"],
+)
+
+go_proto_library(
+    name = "full_go_proto",
+    importpath = "example.com/repo/full",
+    proto = ":full_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":full_go_proto"],
+    importpath = "example.com/repo/full",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "lite", "lite.proto"), []byte("syntax = \"proto3\";\n\npackage lite;\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lite/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "lite_proto",
+    srcs = ["lite.proto"],
+    visibility = ["//visibility:public"],
+)
+
+java_proto_library(
+    name = "lite_java_proto",
+    visibility = ["//visibility:public"],
+    deps = [":lite_proto"],
+)
+
+go_proto_library(
+    name = "lite_go_proto",
+    importpath = "example.com/repo/lite",
+    proto = ":lite_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":lite_go_proto"],
+    importpath = "example.com/repo/lite",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+// TestProtoUnusedImports checks that imports that aren't used are reported
+// without changing .proto files, even by fix.
+func TestProtoUnusedImports(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:proto_unused_imports warn",
+		}, {
+			Path: "a/a.proto",
+			Content: `syntax = "proto3";
+
+package a;
+
+import "b/b.proto";
+import "c/c.proto";
+
+message A {
+  b.B b = 1;
+}
+`,
+		}, {
+			Path:    "b/b.proto",
+			Content: "syntax = \"proto3\";\n\npackage b;\n\nmessage B {}\n",
+		}, {
+			Path:    "c/c.proto",
+			Content: "syntax = \"proto3\";\n\npackage c;\n\nmessage C {}\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{files[2], {
+		Path: "a/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "a_proto",
+    srcs = ["a.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:b_proto",
+        "//c:c_proto",
+    ],
+)
+
+go_proto_library(
+    name = "a_go_proto",
+    importpath = "a",
+    proto = ":a_proto",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:go_default_library",
+        "//c:go_default_library",
+    ],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":a_go_proto"],
+    importpath = "a",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	for _, args := range [][]string{{"fix", "-mode=diff"}, {"fix"}} {
+		if err := runGazelle(dir, args); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{files[2]})
+	}
+}
+
+// TestProtoAnchor checks that proto_anchor groups files in a proto package
+// split across directories into one proto_library in the anchor directory,
+// and deletes rules for the package elsewhere.
+func TestProtoAnchor(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/m
+# gazelle:proto package`,
+		}, {
+			Path:    "api/BUILD.bazel",
+			Content: "# gazelle:proto_anchor .",
+		}, {
+			Path: "api/a/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "v1_proto",
+    srcs = ["foo.proto"],
+)
+`,
+		}, {
+			Path:    "api/a/foo.proto",
+			Content: "syntax = \"proto3\";\n\npackage api.v1;\n\noption go_package = \"example.com/m/api/v1\";\n\nimport \"api/b/bar.proto\";\n",
+		}, {
+			Path:    "api/b/bar.proto",
+			Content: "syntax = \"proto3\";\n\npackage api.v1;\n\noption go_package = \"example.com/m/api/v1\";\n\nimport \"api/other/other.proto\";\n",
+		}, {
+			Path:    "api/other/other.proto",
+			Content: "syntax = \"proto3\";\n\npackage other;\n\noption go_package = \"example.com/m/api/other\";\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	want := []testtools.FileSpec{
+		{
+			// Files in api/b are in the //api package, since there's no build
+			// file there. api/a is its own package.
+			Path: "api/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+# gazelle:proto_anchor .
+
+proto_library(
+    name = "v1_proto",
+    srcs = [
+        "b/bar.proto",
+        "//api/a:foo.proto",
+    ],
+    visibility = ["//visibility:public"],
+    deps = ["//api/other:other_proto"],
+)
+
+go_proto_library(
+    name = "v1_go_proto",
+    importpath = "example.com/m/api/v1",
+    proto = ":v1_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//api/other:other_go_proto"],
+)
+`,
+		}, {
+			Path:    "api/a/BUILD.bazel",
+			Content: "",
+		}, {
+			Path: "api/other/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "other_proto",
+    srcs = ["other.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "other_go_proto",
+    importpath = "example.com/m/api/other",
+    proto = ":other_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, nil); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, want)
+		if _, err := os.Stat(filepath.Join(dir, "api", "b", "BUILD.bazel")); !os.IsNotExist(err) {
+			t.Errorf("api/b/BUILD.bazel: got error %v; want not exist", err)
+		}
+	}
+}
+
+// TestProtoPublicImports checks that rules depend on files re-exported with
+// "import public" by the files they import, including through other public
+// imports.
+func TestProtoPublicImports(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/m",
+		}, {
+			Path:    "a/a.proto",
+			Content: "syntax = \"proto3\";\n\npackage a;\n\nimport \"b/b.proto\";\n\nmessage A { c.C c = 1; }\n",
+		}, {
+			Path:    "b/b.proto",
+			Content: "syntax = \"proto3\";\n\npackage b;\n\nimport public \"c/c.proto\";\n",
+		}, {
+			Path:    "c/c.proto",
+			Content: "syntax = \"proto3\";\n\npackage c;\n\nimport public \"d/d.proto\";\nimport \"e/e.proto\";\n\nmessage C {}\n",
+		}, {
+			Path:    "d/d.proto",
+			Content: "syntax = \"proto3\";\n\npackage d;\n",
+		}, {
+			Path:    "e/e.proto",
+			Content: "syntax = \"proto3\";\n\npackage e;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			// d is re-exported by b through c. e is imported privately by c,
+			// so it's not needed.
+			Path: "a/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "a_proto",
+    srcs = ["a.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//b:b_proto",
+        "//c:c_proto",
+        "//d:d_proto",
+    ],
+)
+
+go_proto_library(
+    name = "a_go_proto",
+    importpath = "example.com/m/a",
+    proto = ":a_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//b:go_default_library"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":a_go_proto"],
+    importpath = "example.com/m/a",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "b/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "b_proto",
+    srcs = ["b.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//c:c_proto",
+        "//d:d_proto",
+    ],
+)
+
+go_proto_library(
+    name = "b_go_proto",
+    importpath = "example.com/m/b",
+    proto = ":b_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//c:go_default_library"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":b_go_proto"],
+    importpath = "example.com/m/b",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
+
+// TestProtoStrictMixedPackages checks that -strict fails the run when a
+// directory contains protos from packages that would be mixed in package
+// mode, after build files are written.
+func TestProtoStrictMixedPackages(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:proto package\n",
+		}, {
+			Path:    "api/foo.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo.v1;\n",
+		}, {
+			Path:    "api/bar.proto",
+			Content: "syntax = \"proto3\";\n\npackage bar.v1;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"-go_prefix", "example.com/repo"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatalf("without -strict: %v", err)
+	}
+	if err := runGazelle(dir, append(args, "-strict")); err == nil {
+		t.Error("with -strict: got success; want error")
+	} else if want := "1 problem(s)"; !strings.Contains(err.Error(), want) {
+		t.Errorf("with -strict: got error %v; want error containing %q", err, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "api/BUILD.bazel")); err != nil {
+		t.Errorf("with -strict: build file not written: %v", err)
+	}
+}
+
+func TestProtoImportRoot(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:proto package
+# gazelle:proto_import_root **/src/main/proto
+# gazelle:proto_import_root api/proto acme/api
+`,
+		}, {
+			Path: "svc/src/main/proto/acme/foo/foo.proto",
+			Content: `syntax = "proto3";
+
+package acme.foo;
+
+import "acme/bar/bar.proto";
+import "acme/api/v1/api.proto";
+`,
+		}, {
+			Path:    "svc/src/main/proto/acme/bar/bar.proto",
+			Content: "syntax = \"proto3\";\n\npackage acme.bar;\n",
+		}, {
+			Path:    "api/proto/v1/api.proto",
+			Content: "syntax = \"proto3\";\n\npackage acme.api.v1;\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "svc/src/main/proto/acme/foo/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    strip_import_prefix = "/svc/src/main/proto",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//api/proto/v1:v1_proto",
+        "//svc/src/main/proto/acme/bar:bar_proto",
+    ],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/svc/src/main/proto/acme/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+    deps = [
+        "//api/proto/v1:v1_go_proto",
+        "//svc/src/main/proto/acme/bar:bar_go_proto",
+    ],
+)
+`,
+		}, {
+			Path: "api/proto/v1/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "v1_proto",
+    srcs = ["api.proto"],
+    import_prefix = "acme/api",
+    strip_import_prefix = "/api/proto",
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "v1_go_proto",
+    importpath = "example.com/repo/api/proto/v1",
+    proto = ":v1_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
+func TestProtoBufWorkspace(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/m
+`,
+		},
+		{
+			Path: "buf.work.yaml",
+			Content: `version: v1
+directories:
+  - proto
+`,
+		},
+		{
+			Path: "proto/buf.yaml",
+			Content: `version: v1
+name: buf.build/acme/weather
+deps:
+  - buf.build/acme/units
+`,
+		},
+		{
+			Path: "proto/buf.lock",
+			Content: `# Generated by buf. DO NOT EDIT.
+version: v1
+deps:
+  - remote: buf.build
+    owner: acme
+    repository: units
+    commit: 7e6f6e774e29406da95bd61cdcdbc8bc
+`,
+		},
+		{
+			Path: "proto/weather/v1/weather.proto",
+			Content: `syntax = "proto3";
+
+package weather.v1;
+
+option go_package = "example.com/m/gen/weather/v1";
+
+import "units/v1/units.proto";
+import "weather/v1/place.proto";
+
+message Forecast {
+  Place place = 1;
+  units.v1.Temperature high = 2;
+}
+`,
+		},
+		{
+			Path: "proto/weather/v1/place.proto",
+			Content: `syntax = "proto3";
+
+package weather.v1;
+
+option go_package = "example.com/m/gen/weather/v1";
+
+message Place {
+  string name = 1;
+}
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "proto/weather/v1/BUILD.bazel",
+		Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "v1_proto",
+    srcs = [
+        "place.proto",
+        "weather.proto",
+    ],
+    strip_import_prefix = "/proto",
+    visibility = ["//visibility:public"],
+    deps = ["@buf_deps//units/v1:v1_proto"],
+)
+
+go_proto_library(
+    name = "v1_go_proto",
+    importpath = "example.com/m/gen/weather/v1",
+    proto = ":v1_proto",
+    visibility = ["//visibility:public"],
+    deps = ["@buf_deps//units/v1:go_default_library"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":v1_go_proto"],
+    importpath = "example.com/m/gen/weather/v1",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGoProtocOutputBoth(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/m
+# gazelle:go_protoc_output both
+`,
+		},
+		{
+			Path: "api/api.proto",
+			Content: `syntax = "proto3";
+
+package api;
+
+option go_package = "example.com/m/api";
+
+message Req {}
+`,
+		},
+		{
+			Path: "api/api.pb.go",
+			Content: `// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: api/api.proto
+
+package api
+`,
+		},
+		{
+			Path: "client/client.go",
+			Content: `package client
+
+import _ "example.com/m/api"
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	want := []testtools.FileSpec{
+		{
+			Path: "api/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "api_proto",
+    srcs = ["api.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "api_go_proto",
+    importpath = "example.com/m/api",
+    proto = ":api_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    srcs = ["api.pb.go"],
+    importpath = "example.com/m/api",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{
+			Path: "client/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["client.go"],
+    importpath = "example.com/m/client",
+    visibility = ["//visibility:public"],
+    deps = ["//api:go_default_library"],
+)
+`,
+		},
+	}
+	// Run twice, so existing rules are indexed the second time. The import
+	// must not be ambiguous either time.
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, nil); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, want)
+	}
+}
+
+func TestGoCgoSetting(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:go_cgo_setting :cgo_on\n",
+		}, {
+			Path: "foo/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "foo",
+    srcs = ["foo.go"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+    deps = select({
+        "//:cgo_on": ["//old"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		}, {
+			Path:    "foo/foo.go",
+			Content: "package foo\n",
+		}, {
+			Path: "foo/cgo.go",
+			Content: `//go:build cgo
+
+package foo
+
+import _ "example.com/repo/bar"
+`,
+		}, {
+			Path: "foo/pure.go",
+			Content: `//go:build !cgo
+
+package foo
+
+import _ "example.com/repo/baz"
+`,
+		}, {
+			Path: "foo/linux_cgo.go",
+			Content: `//go:build linux && cgo
+
+package foo
+
+import _ "example.com/repo/qux"
+`,
+		},
+		{Path: "bar/bar.go", Content: "package bar\n"},
+		{Path: "baz/baz.go", Content: "package baz\n"},
+		{Path: "qux/qux.go", Content: "package qux\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	// Running again changes nothing.
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{
+				Path: "foo/BUILD.bazel",
+				Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "foo",
+    srcs = [
+        "cgo.go",
+        "foo.go",
+        "linux_cgo.go",
+        "pure.go",
+    ],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+    deps = select({
+        "@io_bazel_rules_go//go/platform:android": [
+            "//qux:go_default_library",
+        ],
+        "@io_bazel_rules_go//go/platform:linux": [
+            "//qux:go_default_library",
+        ],
+        "//conditions:default": [],
+    }) + select({
+        "//:cgo_on": [
+            "//bar:go_default_library",
+        ],
+        "//conditions:default": ["//baz:go_default_library"],
+    }),
+)
+`,
+			},
+		})
+	}
+}
+
+func TestGoGrpcKind(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:go_grpc_kind go_grpc_library\n",
+		}, {
+			Path: "svc/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "svc_go_proto",
+    compilers = ["@io_bazel_rules_go//proto:go_grpc"],
+    importpath = "example.com/repo/svc",
+    proto = ":svc_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "svc/svc.proto",
+			Content: `syntax = "proto3";
+
+package svc;
+
+import "msg/msg.proto";
+
+option go_package = "example.com/repo/svc";
+
+service Svc {
+  rpc Get(msg.Msg) returns (msg.Msg);
+}
+`,
+		}, {
+			Path: "msg/msg.proto",
+			Content: `syntax = "proto3";
+
+package msg;
+
+option go_package = "example.com/repo/msg";
+
+message Msg {}
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	// Running again changes nothing.
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{
+				Path: "svc/BUILD.bazel",
+				Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_grpc_library")
+
+go_grpc_library(
+    name = "svc_go_proto",
+    importpath = "example.com/repo/svc",
+    proto = ":svc_proto",
+    visibility = ["//visibility:public"],
+    deps = ["//msg:go_default_library"],
+)
+
+proto_library(
+    name = "svc_proto",
+    srcs = ["svc.proto"],
+    visibility = ["//visibility:public"],
+    deps = ["//msg:msg_proto"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":svc_go_proto"],
+    importpath = "example.com/repo/svc",
+    visibility = ["//visibility:public"],
+)
+`,
+			}, {
+				Path: "msg/BUILD.bazel",
+				Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "msg_proto",
+    srcs = ["msg.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "msg_go_proto",
+    importpath = "example.com/repo/msg",
+    proto = ":msg_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":msg_go_proto"],
+    importpath = "example.com/repo/msg",
+    visibility = ["//visibility:public"],
+)
+`,
+			},
+		})
+	}
+}
+
+func TestProtoImportIndex(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:exclude third_party
+# gazelle:proto_import_index @googleapis third_party/googleapis.csv
+# gazelle:proto_import_index @acme third_party/acme
+`,
+		}, {
+			Path:    "third_party/googleapis.csv",
+			Content: "google/api/annotations.proto,//google/api:annotations_proto\n",
+		}, {
+			Path:    "third_party/acme/acme/units/units.proto",
+			Content: "syntax = \"proto3\";\n\npackage acme.units;\n",
+		}, {
+			Path: "foo/foo.proto",
+			Content: `syntax = "proto3";
+
+package foo;
+
+import "acme/units/units.proto";
+import "google/api/annotations.proto";
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-go_prefix", "example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "foo/BUILD.bazel",
+			Content: `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "foo_proto",
+    srcs = ["foo.proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "@acme//acme/units:units_proto",
+        "@googleapis//google/api:annotations_proto",
+    ],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    importpath = "example.com/repo/foo",
+    proto = ":foo_proto",
+    visibility = ["//visibility:public"],
+    deps = [
+        "@acme//acme/units:units_go_proto",
+        "@googleapis//google/api:annotations_go_proto",
+    ],
+)
+
+go_library(
+    name = "go_default_library",
+    embed = [":foo_go_proto"],
+    importpath = "example.com/repo/foo",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "a/a.go", Content: "package a\n"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+	args := []string{"-go_prefix", "example.com/repo", "-record_snapshot", snapshotPath}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("build file was written with -record_snapshot: %v", err)
+	}
+
+	args = []string{"-go_prefix", "example.com/repo", "-verify_snapshot", snapshotPath}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatalf("verifying unchanged output: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a", "a_test.go"), []byte("package a\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err := runGazelle(dir, args)
+	want := "output differs from snapshot " + snapshotPath + ": 2 difference(s)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v; want %q", err, want)
+	}
+}
@@ -0,0 +1,201 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// prompter asks the user how to resolve ambiguous imports and whether
+// empty rules should be deleted when Gazelle is run with -interactive.
+// Decisions are recorded in build files so they persist across runs:
+// chosen dependencies become gazelle:resolve directives, and rules the user
+// wants to keep are marked with "# keep" comments.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// yes indicates that no questions should be asked. Empty rules are
+	// deleted, and ambiguous imports are left unresolved.
+	yes bool
+
+	// choices maps imports to labels chosen earlier in this run, so the
+	// same question isn't asked more than once.
+	choices map[choiceKey]label.Label
+
+	// decisions is a list of choices that need to be recorded as
+	// directives, in the order they were made.
+	decisions []resolveDecision
+}
+
+type choiceKey struct {
+	imp  resolve.ImportSpec
+	lang string
+}
+
+type resolveDecision struct {
+	imp  resolve.ImportSpec
+	lang string
+	pkg  string
+	dep  label.Label
+}
+
+func newPrompter(in io.Reader, out io.Writer, yes bool) *prompter {
+	return &prompter{
+		in:      bufio.NewReader(in),
+		out:     out,
+		yes:     yes,
+		choices: make(map[choiceKey]label.Label),
+	}
+}
+
+// isTerminal returns whether f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// chooseRule implements resolve.ChooseFunc. It asks the user to pick one of
+// the candidates for an ambiguous import.
+func (p *prompter) chooseRule(imp resolve.ImportSpec, lang string, from label.Label, candidates []label.Label) (label.Label, bool) {
+	if p.yes || len(candidates) == 0 {
+		return label.NoLabel, false
+	}
+	key := choiceKey{imp: imp, lang: lang}
+	if l, ok := p.choices[key]; ok {
+		p.record(key, from, l)
+		return l, true
+	}
+
+	fmt.Fprintf(p.out, "%s imports %q which matches multiple rules:\n", from, imp.Imp)
+	for i, c := range candidates {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, c)
+	}
+	fmt.Fprintf(p.out, "  0) leave unresolved\n")
+	for {
+		fmt.Fprintf(p.out, "choose a rule [0-%d]: ", len(candidates))
+		answer, ok := p.readLine()
+		if !ok {
+			return label.NoLabel, false
+		}
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < 0 || n > len(candidates) {
+			continue
+		}
+		if n == 0 {
+			return label.NoLabel, false
+		}
+		l := candidates[n-1]
+		p.choices[key] = l
+		p.record(key, from, l)
+		return l, true
+	}
+}
+
+func (p *prompter) record(key choiceKey, from label.Label, dep label.Label) {
+	for _, d := range p.decisions {
+		if d.imp == key.imp && d.lang == key.lang && d.pkg == from.Pkg {
+			return
+		}
+	}
+	p.decisions = append(p.decisions, resolveDecision{
+		imp:  key.imp,
+		lang: key.lang,
+		pkg:  from.Pkg,
+		dep:  dep.Rel(from.Repo, from.Pkg),
+	})
+}
+
+// confirmDelete asks the user whether an empty rule should be deleted. If
+// the user declines, the rule is marked with a "# keep" comment.
+func (p *prompter) confirmDelete(c *config.Config, f *rule.File, r *rule.Rule) bool {
+	if p.yes {
+		return true
+	}
+	for {
+		fmt.Fprintf(p.out, "%s: %s %q has no sources left. Delete it? [Y/n]: ", f.Path, r.Kind(), r.Name())
+		answer, ok := p.readLine()
+		if !ok {
+			return true
+		}
+		switch strings.ToLower(answer) {
+		case "", "y", "yes":
+			return true
+		case "n", "no":
+			r.AddComment("# keep")
+			return false
+		}
+	}
+}
+
+// readLine reads a line of input with surrounding space removed. It returns
+// false if no more input is available.
+func (p *prompter) readLine() (string, bool) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(p.out)
+		return "", false
+	}
+	return strings.TrimSpace(line), true
+}
+
+// recordDecisions adds a gazelle:resolve directive to the build file of
+// each package where the user chose a dependency for an ambiguous import.
+func (p *prompter) recordDecisions(files []runner.UpdatedFile) {
+	for _, d := range p.decisions {
+		for _, uf := range files {
+			if uf.File.Pkg != d.pkg {
+				continue
+			}
+			var value string
+			if d.imp.Lang == d.lang {
+				value = fmt.Sprintf("%s %s %s", d.imp.Lang, d.imp.Imp, d.dep)
+			} else {
+				value = fmt.Sprintf("%s %s %s %s", d.imp.Lang, d.lang, d.imp.Imp, d.dep)
+			}
+			addDirective(uf.File, "resolve", value)
+			break
+		}
+	}
+}
+
+// addDirective adds a directive comment at the top of a build file. The file
+// is synced first, so the new comment block doesn't disturb the positions of
+// pending edits.
+func addDirective(f *rule.File, key, value string) {
+	f.Sync()
+	com := bzl.Comment{Token: fmt.Sprintf("# gazelle:%s %s", key, value)}
+	if len(f.File.Stmt) > 0 {
+		if cb, ok := f.File.Stmt[0].(*bzl.CommentBlock); ok {
+			cb.After = append(cb.After, com)
+			return
+		}
+	}
+	cb := &bzl.CommentBlock{Comments: bzl.Comments{After: []bzl.Comment{com}}}
+	f.File.Stmt = append([]bzl.Expr{cb}, f.File.Stmt...)
+}
@@ -0,0 +1,116 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
+)
+
+func TestPrompterChooseRule(t *testing.T) {
+	in := strings.NewReader("x\n3\n2\n")
+	var out bytes.Buffer
+	p := newPrompter(in, &out, false)
+	imp := resolve.ImportSpec{Lang: "go", Imp: "example.com/dep"}
+	candidates := []label.Label{
+		label.New("", "a", "go_default_library"),
+		label.New("", "b", "go_default_library"),
+	}
+	from := label.New("", "c", "go_default_library")
+
+	got, ok := p.chooseRule(imp, "go", from, candidates)
+	if !ok || !got.Equal(candidates[1]) {
+		t.Fatalf("got %s, %v; want %s, true", got, ok, candidates[1])
+	}
+	if n := strings.Count(out.String(), "choose a rule"); n != 3 {
+		t.Errorf("got %d prompts; want 3", n)
+	}
+
+	// The choice is remembered for other packages, and no more input is read.
+	from2 := label.New("", "d", "go_default_library")
+	if got, ok := p.chooseRule(imp, "go", from2, candidates); !ok || !got.Equal(candidates[1]) {
+		t.Errorf("got %s, %v for second package; want %s, true", got, ok, candidates[1])
+	}
+
+	f := rule.EmptyFile("c/BUILD.bazel", "c")
+	rule.NewRule("go_library", "go_default_library").Insert(f)
+	p.recordDecisions([]runner.UpdatedFile{{File: f}})
+	want := `# gazelle:resolve go example.com/dep //b:go_default_library
+
+go_library(name = "go_default_library")
+`
+	if got := string(f.Format()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrompterChooseRuleUnresolved(t *testing.T) {
+	candidates := []label.Label{
+		label.New("", "a", "go_default_library"),
+		label.New("", "b", "go_default_library"),
+	}
+	imp := resolve.ImportSpec{Lang: "go", Imp: "example.com/dep"}
+	from := label.New("", "c", "go_default_library")
+	for _, tc := range []struct {
+		desc, input string
+		yes         bool
+	}{
+		{desc: "skip", input: "0\n"},
+		{desc: "eof", input: ""},
+		{desc: "yes", input: "1\n", yes: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := newPrompter(strings.NewReader(tc.input), &bytes.Buffer{}, tc.yes)
+			if got, ok := p.chooseRule(imp, "go", from, candidates); ok {
+				t.Errorf("got %s; want no choice", got)
+			}
+			if len(p.decisions) > 0 {
+				t.Errorf("got decisions %v; want none", p.decisions)
+			}
+		})
+	}
+}
+
+func TestPrompterConfirmDelete(t *testing.T) {
+	for _, tc := range []struct {
+		desc, input string
+		yes, want   bool
+	}{
+		{desc: "default", input: "\n", want: true},
+		{desc: "yes_answer", input: "y\n", want: true},
+		{desc: "no_answer", input: "maybe\nn\n", want: false},
+		{desc: "yes_flag", input: "n\n", yes: true, want: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := rule.EmptyFile("BUILD.bazel", "")
+			r := rule.NewRule("go_library", "go_default_library")
+			r.Insert(f)
+			p := newPrompter(strings.NewReader(tc.input), &bytes.Buffer{}, tc.yes)
+			if got := p.confirmDelete(nil, f, r); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+			if keep := r.ShouldKeep(); keep == tc.want {
+				t.Errorf("rule marked with keep: %v; want %v", keep, !tc.want)
+			}
+		})
+	}
+}
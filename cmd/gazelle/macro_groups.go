@@ -0,0 +1,118 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// macroGroupHeaders are the comments written above the first rule of each
+// group in a macro organized with -group_macro, in the order groups appear.
+var macroGroupHeaders = []struct {
+	group  language.RepoGroup
+	header string
+}{
+	{language.RepoGroupDirect, "# Direct dependencies"},
+	{language.RepoGroupTest, "# Test-only dependencies"},
+	{language.RepoGroupTransitive, "# Transitive dependencies"},
+}
+
+// groupMacroRules reorders the rules in the macro function f is editing so
+// that rules in the same group are together, sorted by name, with a comment
+// above each group. groups maps rule names to groups. Rules that aren't in
+// any group, and statements that aren't rule calls, are kept in their
+// original order before the groups.
+//
+// Headers from an earlier run are removed first, so rules that move between
+// groups don't leave stale comments behind. f must be synced before this is
+// called.
+func groupMacroRules(f *rule.File, groups map[string]language.RepoGroup) {
+	var def *bzl.DefStmt
+	for _, stmt := range f.File.Stmt {
+		if d, ok := stmt.(*bzl.DefStmt); ok && d.Name == f.DefName {
+			def = d
+			break
+		}
+	}
+	if def == nil {
+		return
+	}
+
+	isHeader := make(map[string]bool)
+	for _, h := range macroGroupHeaders {
+		isHeader[h.header] = true
+	}
+	var other []bzl.Expr
+	grouped := make(map[language.RepoGroup][]*bzl.CallExpr)
+	for _, stmt := range def.Body {
+		com := stmt.Comment()
+		before := com.Before[:0]
+		for _, c := range com.Before {
+			if !isHeader[c.Token] {
+				before = append(before, c)
+			}
+		}
+		com.Before = before
+
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			other = append(other, stmt)
+			continue
+		}
+		g := groups[callName(call)]
+		if g == language.RepoGroupNone {
+			other = append(other, stmt)
+			continue
+		}
+		grouped[g] = append(grouped[g], call)
+	}
+
+	body := other
+	for _, h := range macroGroupHeaders {
+		calls := grouped[h.group]
+		if len(calls) == 0 {
+			continue
+		}
+		sort.SliceStable(calls, func(i, j int) bool {
+			return callName(calls[i]) < callName(calls[j])
+		})
+		com := calls[0].Comment()
+		com.Before = append([]bzl.Comment{{Token: h.header}}, com.Before...)
+		for _, call := range calls {
+			body = append(body, call)
+		}
+	}
+	def.Body = body
+}
+
+// callName returns the value of the name argument of a rule call, or ""
+// if the call has no string name.
+func callName(call *bzl.CallExpr) string {
+	for _, arg := range call.List {
+		if assign, ok := arg.(*bzl.AssignExpr); ok {
+			if key, ok := assign.LHS.(*bzl.Ident); ok && key.Name == "name" {
+				if s, ok := assign.RHS.(*bzl.StringExpr); ok {
+					return s.Value
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestGroupMacroRules(t *testing.T) {
+	old := `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+def go_repositories():
+    go_repository(
+        name = "org_golang_x_tools",
+        importpath = "golang.org/x/tools",
+    )
+    go_repository(
+        name = "com_github_pkg_errors",
+        importpath = "github.com/pkg/errors",
+    )
+    go_repository(
+        name = "org_golang_x_sys",
+        importpath = "golang.org/x/sys",
+    )
+    go_repository(
+        name = "com_github_kr_pretty",
+        importpath = "github.com/kr/pretty",
+    )
+    http_archive(
+        name = "custom",
+        urls = ["https://example.com/custom.tar.gz"],
+    )
+    go_repository(
+        name = "com_github_stretchr_testify",
+        importpath = "github.com/stretchr/testify",
+    )
+`
+	f, err := rule.LoadMacroData("repositories.bzl", "", "go_repositories", []byte(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string]language.RepoGroup{
+		"org_golang_x_tools":          language.RepoGroupTransitive,
+		"com_github_pkg_errors":       language.RepoGroupDirect,
+		"org_golang_x_sys":            language.RepoGroupDirect,
+		"com_github_kr_pretty":        language.RepoGroupTransitive,
+		"com_github_stretchr_testify": language.RepoGroupTest,
+	}
+	groupMacroRules(f, groups)
+
+	want := `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+def go_repositories():
+    http_archive(
+        name = "custom",
+        urls = ["https://example.com/custom.tar.gz"],
+    )
+
+    # Direct dependencies
+    go_repository(
+        name = "com_github_pkg_errors",
+        importpath = "github.com/pkg/errors",
+    )
+    go_repository(
+        name = "org_golang_x_sys",
+        importpath = "golang.org/x/sys",
+    )
+
+    # Test-only dependencies
+    go_repository(
+        name = "com_github_stretchr_testify",
+        importpath = "github.com/stretchr/testify",
+    )
+
+    # Transitive dependencies
+    go_repository(
+        name = "com_github_kr_pretty",
+        importpath = "github.com/kr/pretty",
+    )
+    go_repository(
+        name = "org_golang_x_tools",
+        importpath = "golang.org/x/tools",
+    )
+`
+	got := f.Format()
+	if strings.TrimSpace(string(got)) != strings.TrimSpace(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Grouping a file that's already grouped doesn't change it. Headers are
+	// not duplicated.
+	f, err = rule.LoadMacroData("repositories.bzl", "", "go_repositories", got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupMacroRules(f, groups)
+	if again := string(f.Format()); again != string(got) {
+		t.Errorf("after grouping again, got:\n%s\nwant:\n%s", again, got)
+	}
+}
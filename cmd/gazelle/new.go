@@ -0,0 +1,248 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// templateFile is a file laid down by the new command.
+type templateFile struct {
+	// name is the slash-separated path of the file, relative to the new
+	// package directory. It's expanded as a template.
+	name string
+
+	// content is the content of the file.
+	content string
+
+	// expand indicates whether content should be expanded as a template.
+	expand bool
+}
+
+// builtinTemplates are the templates that ship with Gazelle. Templates may
+// also be loaded from directories with -template_dir.
+var builtinTemplates = map[string][]templateFile{
+	"go_binary": {
+		{name: "main.go", content: "package main\n\nfunc main() {\n}\n", expand: true},
+	},
+	"go_library": {
+		{name: "{{.Name}}.go", content: "package {{.GoPackage}}\n", expand: true},
+		{name: "{{.Name}}_test.go", content: "package {{.GoPackage}}\n", expand: true},
+	},
+	"proto_library": {
+		{name: "{{.Name}}.proto", content: "syntax = \"proto3\";\n\npackage {{.GoPackage}};\n", expand: true},
+	},
+}
+
+// templateData is the data templates are expanded with.
+type templateData struct {
+	// Name is the base name of the new package directory.
+	Name string
+
+	// GoPackage is Name converted to a valid Go package name.
+	GoPackage string
+
+	// Path is the slash-separated path to the new package directory, as
+	// given on the command line.
+	Path string
+}
+
+// newPackage lays down the files in a template in a new directory, then
+// generates build files for it with the update command. Templates may
+// contain build files with directives; generated rules are merged into them.
+func newPackage(args []string) error {
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	fs.Usage = func() {}
+	var templateDir string
+	fs.StringVar(&templateDir, "template_dir", "", "directory containing user-provided templates, one per subdirectory")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			newUsage(fs)
+		}
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("new: expected template name and package directory; try -help for more information")
+	}
+	name, dir := fs.Arg(0), fs.Arg(1)
+	updateArgs := fs.Args()[2:]
+
+	files, err := loadTemplate(templateDir, name)
+	if err != nil {
+		return err
+	}
+	data := templateData{
+		Name:      filepath.Base(filepath.Clean(dir)),
+		GoPackage: goPackageName(filepath.Base(filepath.Clean(dir))),
+		Path:      filepath.ToSlash(filepath.Clean(dir)),
+	}
+	if err := writeTemplate(files, dir, data); err != nil {
+		return err
+	}
+	return runFixUpdate(updateCmd, append(updateArgs, dir))
+}
+
+// loadTemplate returns the files in the template with the given name. If
+// templateDir is set and has a subdirectory with that name, the template is
+// read from there. Otherwise, a built-in template is used.
+func loadTemplate(templateDir, name string) ([]templateFile, error) {
+	if templateDir != "" {
+		root := filepath.Join(templateDir, name)
+		if fi, err := os.Stat(root); err == nil && fi.IsDir() {
+			return readTemplateDir(root)
+		}
+	}
+	if files, ok := builtinTemplates[name]; ok {
+		return files, nil
+	}
+	names := make([]string, 0, len(builtinTemplates))
+	for n := range builtinTemplates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("new: unknown template %q; built-in templates are %s", name, strings.Join(names, ", "))
+}
+
+// readTemplateDir reads the files in a user-provided template directory.
+// Files with a ".tmpl" suffix are expanded as templates, and the suffix is
+// removed. Other files are copied as they are.
+func readTemplateDir(root string) ([]templateFile, error) {
+	var files []templateFile
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		f := templateFile{name: filepath.ToSlash(rel), content: string(content)}
+		if strings.HasSuffix(f.name, ".tmpl") {
+			f.name = strings.TrimSuffix(f.name, ".tmpl")
+			f.expand = true
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("new: template directory %s is empty", root)
+	}
+	return files, nil
+}
+
+// writeTemplate expands files with data and writes them into dir. Nothing
+// is written if any of the files already exist.
+func writeTemplate(files []templateFile, dir string, data templateData) error {
+	paths := make([]string, len(files))
+	contents := make([][]byte, len(files))
+	for i, f := range files {
+		name, err := expandTemplate(f.name, f.name, data)
+		if err != nil {
+			return err
+		}
+		paths[i] = filepath.Join(dir, filepath.FromSlash(string(name)))
+		if _, err := os.Stat(paths[i]); err == nil {
+			return fmt.Errorf("new: %s already exists", paths[i])
+		}
+		contents[i] = []byte(f.content)
+		if f.expand {
+			if contents[i], err = expandTemplate(f.name, f.content, data); err != nil {
+				return err
+			}
+		}
+	}
+	for i, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, contents[i], 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandTemplate(name, text string, data templateData) ([]byte, error) {
+	tpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("new: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("new: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// goPackageName converts a directory name to a valid Go package name by
+// lower-casing it and replacing characters that can't appear in identifiers
+// with underscores.
+func goPackageName(name string) string {
+	var b strings.Builder
+	for i, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func newUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle new [-template_dir=dir] template package-dir [update flags...]
+
+The new command creates a package directory from a template, then generates
+build files for it as the update command would. Flags after package-dir
+are passed to update.
+
+Built-in templates are go_binary, go_library, and proto_library. Templates
+may also be read from subdirectories of -template_dir, which take precedence.
+Files in template directories ending with ".tmpl" are expanded with Go's
+text/template package; other files are copied as they are. File names are
+always expanded. Templates may use {{.Name}} (the base name of the package
+directory), {{.GoPackage}} (Name as a Go package name), and {{.Path}} (the
+package directory as given). A template may include a build file with
+directives; generated rules are merged into it.
+
+Existing files are never overwritten.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
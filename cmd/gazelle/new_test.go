@@ -0,0 +1,116 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestNewBuiltinTemplate(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+	})
+	defer cleanup()
+
+	args := []string{"new", "go_library", "foo/my-lib", "-go_prefix", "example.com/m"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "foo/my-lib/my-lib.go", Content: "package my_lib\n"},
+		{Path: "foo/my-lib/my-lib_test.go", Content: "package my_lib\n"},
+		{
+			Path: "foo/my-lib/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["my-lib.go"],
+    importpath = "example.com/m/foo/my-lib",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["my-lib_test.go"],
+    embed = [":go_default_library"],
+)
+`,
+		},
+	})
+
+	// Existing files are not overwritten.
+	err := runGazelle(dir, args)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got error %v; want an error about existing files", err)
+	}
+}
+
+func TestNewTemplateDir(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "templates/service/BUILD.bazel",
+			Content: `# gazelle:go_default_visibility //svc:__subpackages__
+`,
+		}, {
+			Path:    "templates/service/{{.Name}}.go.tmpl",
+			Content: "// Package {{.GoPackage}} serves {{.Path}}.\npackage {{.GoPackage}}\n",
+		}, {
+			Path:    "templates/service/README.tmpl.md",
+			Content: "{{.Name}} is not expanded.\n",
+		},
+	})
+	defer cleanup()
+
+	args := []string{"new", "-template_dir", "templates", "service", "svc/users", "-go_prefix", "example.com/m"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "svc/users/users.go", Content: "// Package users serves svc/users.\npackage users\n"},
+		{Path: "svc/users/README.tmpl.md", Content: "{{.Name}} is not expanded.\n"},
+		{
+			Path: "svc/users/BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:go_default_visibility //svc:__subpackages__
+
+go_library(
+    name = "go_default_library",
+    srcs = ["users.go"],
+    importpath = "example.com/m/svc/users",
+    visibility = ["//svc:__subpackages__"],
+)
+`,
+		},
+	})
+}
+
+func TestNewUnknownTemplate(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+	})
+	defer cleanup()
+
+	err := runGazelle(dir, []string{"new", "nope", "foo"})
+	if err == nil || !strings.Contains(err.Error(), `unknown template "nope"`) {
+		t.Errorf("got error %v; want unknown template error", err)
+	}
+}
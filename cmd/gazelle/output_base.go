@@ -0,0 +1,126 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// checkOutputBase returns an error if fix or update would write build files
+// in a Bazel output base: in bazel-out, or in an external repository Bazel
+// has fetched. Bazel manages these directories, so build files written
+// there are overwritten or break the build. This usually means Gazelle was
+// run in the wrong directory or given a path under a convenience symlink
+// like bazel-out by mistake. The check is skipped with -allow_output_base;
+// go_repository uses this to generate build files in external
+// repositories.
+func checkOutputBase(c *config.Config) error {
+	if c.WriteBuildFilesDir != "" {
+		return checkNotInOutputBase(c.WriteBuildFilesDir, "-experimental_write_build_files_dir", nil)
+	}
+	return checkNotInOutputBase(c.RepoRoot, "repository root", findOutputBases(c.RepoRoot))
+}
+
+// checkDirOutputBase is like checkOutputBase, but it checks dir, one of
+// the directories Gazelle was asked to update, after symbolic links are
+// evaluated.
+func checkDirOutputBase(c *config.Config, dir string) error {
+	if c.WriteBuildFilesDir != "" {
+		return nil
+	}
+	return checkNotInOutputBase(dir, "directory", findOutputBases(c.RepoRoot))
+}
+
+func checkNotInOutputBase(dir, what string, outputBases []string) error {
+	if outputBase := findEnclosingOutputBase(dir, outputBases); outputBase != "" {
+		return fmt.Errorf("%s %s is in the Bazel output base %s; Gazelle won't write build files there. Run Gazelle in the workspace directory, or use -allow_output_base if this is intended", what, dir, outputBase)
+	}
+	return nil
+}
+
+// findOutputBases returns the output bases that the convenience symlinks
+// in repoRoot (like bazel-out and bazel-<workspace>) point into. These
+// symlinks point into <output_base>/execroot.
+func findOutputBases(repoRoot string) []string {
+	infos, err := ioutil.ReadDir(repoRoot)
+	if err != nil {
+		return nil
+	}
+	var outputBases []string
+	for _, info := range infos {
+		if info.Mode()&os.ModeSymlink == 0 || !strings.HasPrefix(info.Name(), "bazel-") {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(repoRoot, info.Name()))
+		if err != nil {
+			continue
+		}
+		sep := string(filepath.Separator)
+		if i := strings.Index(target, sep+"execroot"+sep); i >= 0 {
+			outputBase := target[:i]
+			if !containsString(outputBases, outputBase) {
+				outputBases = append(outputBases, outputBase)
+			}
+		}
+	}
+	return outputBases
+}
+
+// findEnclosingOutputBase returns the output base containing dir, or "" if
+// dir is not in an output base. dir is in an output base if it's in one of
+// outputBases, if it's in an external repository (a directory in
+// <output_base>/external, next to execroot), or if it's in
+// <output_base>/execroot/<workspace>/bazel-out. Other directories in
+// output bases, like sandboxes and test temporary directories, are allowed.
+func findEnclosingOutputBase(dir string, outputBases []string) string {
+	for _, outputBase := range outputBases {
+		if isDescendingDir(dir, outputBase) {
+			return outputBase
+		}
+	}
+	for d := dir; ; {
+		parent := filepath.Dir(d)
+		if parent == d {
+			return ""
+		}
+		switch filepath.Base(d) {
+		case "external":
+			if st, err := os.Stat(filepath.Join(parent, "execroot")); err == nil && st.IsDir() {
+				return parent
+			}
+		case "bazel-out":
+			if execroot := filepath.Dir(parent); filepath.Base(execroot) == "execroot" {
+				return filepath.Dir(execroot)
+			}
+		}
+		d = parent
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
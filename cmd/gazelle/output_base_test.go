@@ -0,0 +1,67 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestOutputBaseExternalRepo(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "output_base/execroot/ws/"},
+		{Path: "output_base/external/com_example_a/WORKSPACE"},
+		{Path: "output_base/external/com_example_a/a.go", Content: "package a\n"},
+	})
+	defer cleanup()
+
+	repoRoot := filepath.Join(dir, "output_base", "external", "com_example_a")
+	args := []string{"-repo_root", repoRoot, "-go_prefix", "example.com/a", repoRoot}
+	err := runGazelle(dir, args)
+	if err == nil || !strings.Contains(err.Error(), "output base") {
+		t.Fatalf("got error %v; want an error about the output base", err)
+	}
+	if _, err := os.Stat(filepath.Join(repoRoot, "BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("build file was written in an external repository")
+	}
+
+	if err := runGazelle(dir, append([]string{"-allow_output_base"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repoRoot, "BUILD.bazel")); err != nil {
+		t.Errorf("build file was not written with -allow_output_base: %v", err)
+	}
+}
+
+func TestOutputBaseConvenienceSymlink(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "output_base/execroot/ws/bazel-out/k8-fastbuild/bin/pkg/gen.go", Content: "package pkg\n"},
+		{Path: "ws/WORKSPACE"},
+		{Path: "ws/bazel-out", Symlink: "../output_base/execroot/ws/bazel-out"},
+		{Path: "ws/bazel-ws", Symlink: "../output_base/execroot/ws"},
+	})
+	defer cleanup()
+
+	wsDir := filepath.Join(dir, "ws")
+	err := runGazelle(wsDir, []string{"-go_prefix", "example.com/ws", "bazel-out/k8-fastbuild/bin/pkg"})
+	if err == nil || !strings.Contains(err.Error(), "output base") {
+		t.Fatalf("got error %v; want an error about the output base", err)
+	}
+}
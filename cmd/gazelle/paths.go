@@ -0,0 +1,88 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// relPathWriter writes log messages to w, replacing absolute paths in
+// baseDir with paths relative to baseDir. This keeps log output the same
+// no matter where the workspace is checked out.
+type relPathWriter struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	prefix []byte
+}
+
+// logOutput is the writer installed as the standard logger's output by
+// main. It's nil in tests, which capture log output themselves.
+var logOutput *relPathWriter
+
+func (w *relPathWriter) setBaseDir(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if dir == "" {
+		w.prefix = nil
+	} else {
+		w.prefix = []byte(dir + string(filepath.Separator))
+	}
+}
+
+func (w *relPathWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	prefix := w.prefix
+	w.mu.Unlock()
+	if len(prefix) > 0 && bytes.Contains(p, prefix) {
+		if _, err := w.w.Write(bytes.Replace(p, prefix, nil, -1)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// setLogBaseDir sets the directory that paths in log messages are printed
+// relative to.
+func setLogBaseDir(dir string) {
+	if logOutput != nil {
+		logOutput.setBaseDir(dir)
+	}
+}
+
+// displayPath returns path relative to the directory set with -base_dir.
+// Without -base_dir, paths in the repository are relative to the repository
+// root, and other paths (for example, in
+// -experimental_read_build_files_dir) are printed as they are.
+func displayPath(c *config.Config, path string) string {
+	base := getUpdateConfig(c).baseDir
+	if base == "" {
+		if !isDescendingDir(path, c.RepoRoot) {
+			return path
+		}
+		base = c.RepoRoot
+	}
+	if rel, err := filepath.Rel(base, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
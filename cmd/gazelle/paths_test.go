@@ -0,0 +1,62 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelPathWriter(t *testing.T) {
+	base := filepath.FromSlash("/home/user/ws")
+	for _, tc := range []struct {
+		desc, base, msg, want string
+	}{
+		{
+			desc: "no_base",
+			msg:  filepath.Join(base, "a", "BUILD.bazel") + ": error",
+			want: filepath.Join(base, "a", "BUILD.bazel") + ": error",
+		}, {
+			desc: "in_base",
+			base: base,
+			msg:  fmt.Sprintf("%s: error in %s", filepath.Join(base, "a", "BUILD.bazel"), filepath.Join(base, "b")),
+			want: fmt.Sprintf("%s: error in %s", filepath.Join("a", "BUILD.bazel"), "b"),
+		}, {
+			desc: "sibling",
+			base: base,
+			msg:  filepath.Join(base+"2", "a") + ": error",
+			want: filepath.Join(base+"2", "a") + ": error",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &relPathWriter{w: &buf}
+			w.setBaseDir(tc.base)
+			n, err := w.Write([]byte(tc.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tc.msg) {
+				t.Errorf("got %d bytes written; want %d", n, len(tc.msg))
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
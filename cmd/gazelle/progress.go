@@ -0,0 +1,196 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/runner"
+)
+
+// Phases reported after runner.Update returns.
+const (
+	phaseWrite = "write"
+	phaseDone  = "done"
+)
+
+// progressStatus is a snapshot of progress. It's written as JSON to the file
+// named with -progress_file.
+type progressStatus struct {
+	Phase          string `json:"phase"`
+	Done           int    `json:"done"`
+	Total          int    `json:"total,omitempty"`
+	ElapsedSeconds int64  `json:"elapsed_seconds"`
+	ETASeconds     *int64 `json:"eta_seconds,omitempty"`
+}
+
+// progressReporter prints progress of a long run. Once the run has taken
+// longer than the -progress_after duration, the current phase and the
+// number of directories processed are printed to stderr (or written to
+// the -progress_file) every time that duration passes, so a slow run can be
+// told apart from a hung one.
+type progressReporter struct {
+	after time.Duration
+	path  string
+
+	mu         sync.Mutex
+	p          runner.Progress
+	start      time.Time
+	phaseStart time.Time
+
+	stopOnce        sync.Once
+	stopCh, stopped chan struct{}
+}
+
+// newProgressReporter returns a reporter that starts reporting after the
+// given duration. If path is not empty, the status is written to that
+// file instead of being printed.
+func newProgressReporter(after time.Duration, path string) *progressReporter {
+	now := time.Now()
+	return &progressReporter{
+		after:      after,
+		path:       path,
+		start:      now,
+		phaseStart: now,
+	}
+}
+
+// run starts reporting progress in the background. stop or finish must be
+// called to stop it.
+func (pr *progressReporter) run() {
+	pr.stopCh = make(chan struct{})
+	pr.stopped = make(chan struct{})
+	go func() {
+		defer close(pr.stopped)
+		timer := time.NewTimer(pr.after)
+		defer timer.Stop()
+		for {
+			select {
+			case <-pr.stopCh:
+				return
+			case now := <-timer.C:
+				if err := pr.report(now); err != nil {
+					log.Print(err)
+				}
+				timer.Reset(pr.after)
+			}
+		}
+	}()
+}
+
+// update records p as the current progress. It may be passed to
+// runner.Options.ReportProgress.
+func (pr *progressReporter) update(p runner.Progress) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if p.Phase != pr.p.Phase {
+		pr.phaseStart = time.Now()
+	}
+	pr.p = p
+}
+
+// stop stops reporting. It may be called more than once.
+func (pr *progressReporter) stop() {
+	pr.stopOnce.Do(func() {
+		if pr.stopCh != nil {
+			close(pr.stopCh)
+			<-pr.stopped
+		}
+	})
+}
+
+// finish stops reporting after a successful run. If the status is written
+// to a file, a final status with the "done" phase is written, so wrappers
+// can tell the run completed.
+func (pr *progressReporter) finish() error {
+	pr.stop()
+	if pr.path == "" {
+		return nil
+	}
+	pr.update(runner.Progress{Phase: phaseDone})
+	return pr.report(time.Now())
+}
+
+// status returns a snapshot of progress at the time now. The ETA is
+// estimated from the rate directories were processed in the current phase,
+// when the total number of directories is known.
+func (pr *progressReporter) status(now time.Time) progressStatus {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	s := progressStatus{
+		Phase:          pr.p.Phase,
+		Done:           pr.p.Done,
+		Total:          pr.p.Total,
+		ElapsedSeconds: int64(now.Sub(pr.start) / time.Second),
+	}
+	if pr.p.Total > 0 && pr.p.Done > 0 {
+		perDir := now.Sub(pr.phaseStart) / time.Duration(pr.p.Done)
+		eta := int64(perDir * time.Duration(pr.p.Total-pr.p.Done) / time.Second)
+		s.ETASeconds = &eta
+	}
+	return s
+}
+
+// report prints the status at the time now or writes it to the status
+// file. The file is replaced atomically, so readers never see a partial
+// status.
+func (pr *progressReporter) report(now time.Time) error {
+	s := pr.status(now)
+	if pr.path == "" {
+		log.Print(formatProgress(s))
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(pr.path), filepath.Base(pr.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("writing progress: %v", err)
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), pr.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing progress: %v", err)
+	}
+	return nil
+}
+
+// formatProgress formats s as a line of key=value pairs.
+func formatProgress(s progressStatus) string {
+	line := fmt.Sprintf("progress: phase=%s dirs=%d", s.Phase, s.Done)
+	if s.Total > 0 {
+		line += fmt.Sprintf("/%d", s.Total)
+	}
+	line += fmt.Sprintf(" elapsed=%v", time.Duration(s.ElapsedSeconds)*time.Second)
+	if s.ETASeconds != nil {
+		line += fmt.Sprintf(" eta=%v", time.Duration(*s.ETASeconds)*time.Second)
+	}
+	return line
+}
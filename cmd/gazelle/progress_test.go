@@ -0,0 +1,106 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/runner"
+)
+
+func TestProgressStatus(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pr := newProgressReporter(time.Minute, "")
+	pr.start = start
+	for _, tc := range []struct {
+		desc       string
+		p          runner.Progress
+		phaseStart time.Time
+		now        time.Time
+		want       string
+	}{
+		{
+			desc:       "unknown_total",
+			p:          runner.Progress{Phase: runner.PhaseWalk, Done: 1500},
+			phaseStart: start,
+			now:        start.Add(90 * time.Second),
+			want:       "progress: phase=walk dirs=1500 elapsed=1m30s",
+		}, {
+			desc:       "eta",
+			p:          runner.Progress{Phase: runner.PhaseResolve, Done: 100, Total: 400},
+			phaseStart: start.Add(90 * time.Second),
+			now:        start.Add(2 * time.Minute),
+			want:       "progress: phase=resolve dirs=100/400 elapsed=2m0s eta=1m30s",
+		}, {
+			desc:       "not_started",
+			p:          runner.Progress{Phase: runner.PhaseResolve, Total: 400},
+			phaseStart: start.Add(90 * time.Second),
+			now:        start.Add(2 * time.Minute),
+			want:       "progress: phase=resolve dirs=0/400 elapsed=2m0s",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			pr.p = tc.p
+			pr.phaseStart = tc.phaseStart
+			if got := formatProgress(pr.status(tc.now)); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProgressFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "progress.json")
+
+	pr := newProgressReporter(time.Hour, path)
+	pr.run()
+	pr.update(runner.Progress{Phase: runner.PhaseResolve, Done: 2, Total: 4})
+	if err := pr.report(pr.start.Add(3 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	checkProgressFile(t, path, `{"phase":"resolve","done":2,"total":4,"elapsed_seconds":3,"eta_seconds":`)
+
+	if err := pr.finish(); err != nil {
+		t.Fatal(err)
+	}
+	checkProgressFile(t, path, `{"phase":"done","done":0,"elapsed_seconds":`)
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 {
+		t.Errorf("got %d files in %s; want only the progress file", len(files), dir)
+	}
+}
+
+func checkProgressFile(t *testing.T, path, wantPrefix string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); !strings.HasPrefix(got, wantPrefix) {
+		t.Errorf("got %q; want a status starting with %q", got, wantPrefix)
+	}
+}
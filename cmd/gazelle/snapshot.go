@@ -0,0 +1,247 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// snapshotVersion is the version of the snapshot format. Snapshots with
+// other versions can't be verified.
+const snapshotVersion = 1
+
+// snapshot records the build files Gazelle generated in a run. It's written
+// as JSON to the file named with -record_snapshot. A run of another version
+// of Gazelle with -verify_snapshot compares its output with the snapshot, so
+// an upgrade can be checked without changing any files.
+//
+// Only fingerprints are recorded, so snapshots of large repositories stay
+// small. They're enough to tell which rules and attributes changed.
+type snapshot struct {
+	Version int `json:"version"`
+
+	// Files maps slash-separated paths of build files, relative to the
+	// repository root, to their fingerprints.
+	Files map[string]snapshotFile `json:"files"`
+}
+
+type snapshotFile struct {
+	// Hash is a fingerprint of the formatted content of the file.
+	Hash string `json:"hash"`
+
+	// Loads is a fingerprint of the load statements in the file.
+	Loads string `json:"loads,omitempty"`
+
+	// Rules maps rule names to fingerprints of the rules.
+	Rules map[string]snapshotRule `json:"rules,omitempty"`
+}
+
+type snapshotRule struct {
+	Kind string `json:"kind"`
+
+	// Attrs maps attribute names to fingerprints of their formatted values.
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// discardFile is the emitFunc used with -record_snapshot and
+// -verify_snapshot. Nothing is written.
+func discardFile(c *config.Config, f *rule.File) error {
+	return nil
+}
+
+// makeSnapshot records the build files in files. Frozen files, which Gazelle
+// doesn't write, are not included.
+func makeSnapshot(c *config.Config, files []runner.UpdatedFile) (snapshot, error) {
+	s := snapshot{Version: snapshotVersion, Files: make(map[string]snapshotFile)}
+	for _, f := range files {
+		if f.Frozen {
+			continue
+		}
+		rel, err := filepath.Rel(c.RepoRoot, f.File.Path)
+		if err != nil {
+			return snapshot{}, err
+		}
+		s.Files[filepath.ToSlash(rel)] = snapshotBuildFile(f.File)
+	}
+	return s, nil
+}
+
+// snapshotBuildFile returns fingerprints of the content of f.
+func snapshotBuildFile(f *rule.File) snapshotFile {
+	// Format syncs the syntax tree, so deleted rules and loads are removed
+	// before they're recorded below.
+	sf := snapshotFile{Hash: fingerprint(string(f.Format()))}
+
+	loads := make([]string, 0, len(f.Loads))
+	for _, l := range f.Loads {
+		symbols := l.Symbols()
+		sort.Strings(symbols)
+		loads = append(loads, l.Name()+":"+strings.Join(symbols, ","))
+	}
+	if len(loads) > 0 {
+		sort.Strings(loads)
+		sf.Loads = fingerprint(strings.Join(loads, "\n"))
+	}
+
+	for _, r := range f.Rules {
+		if sf.Rules == nil {
+			sf.Rules = make(map[string]snapshotRule)
+		}
+		sr := snapshotRule{Kind: r.Kind()}
+		for _, key := range r.AttrKeys() {
+			if key == "name" {
+				continue
+			}
+			if sr.Attrs == nil {
+				sr.Attrs = make(map[string]string)
+			}
+			sr.Attrs[key] = fingerprint(bzl.FormatString(r.Attr(key)))
+		}
+		sf.Rules[r.Name()] = sr
+	}
+	return sf
+}
+
+// fingerprint returns a short hash of s.
+func fingerprint(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+func writeSnapshot(path string, s snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return snapshot{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return snapshot{}, fmt.Errorf("%s: snapshot has version %d; want %d", path, s.Version, snapshotVersion)
+	}
+	return s, nil
+}
+
+// compareSnapshots returns a sorted list of differences between the build
+// files recorded in old and cur, one per line. Rules are matched by name and
+// attributes by key, so reordering them isn't a difference. A file whose
+// content changed without a difference in its loads, rules, or attributes
+// is reported as a formatting change.
+func compareSnapshots(old, cur snapshot) []string {
+	var diffs []string
+	report := func(format string, args ...interface{}) {
+		diffs = append(diffs, fmt.Sprintf(format, args...))
+	}
+
+	for path, of := range old.Files {
+		cf, ok := cur.Files[path]
+		if !ok {
+			report("%s: file is no longer generated", path)
+			continue
+		}
+		if of.Hash == cf.Hash {
+			continue
+		}
+		n := len(diffs)
+		if of.Loads != cf.Loads {
+			report("%s: loads changed", path)
+		}
+		for name, or := range of.Rules {
+			cr, ok := cf.Rules[name]
+			if !ok {
+				report("%s: %s %s removed", path, or.Kind, name)
+				continue
+			}
+			if or.Kind != cr.Kind {
+				report("%s: %s changed kind from %s to %s", path, name, or.Kind, cr.Kind)
+			}
+			for key, ov := range or.Attrs {
+				if cv, ok := cr.Attrs[key]; !ok {
+					report("%s: %s %s: attribute %s removed", path, cr.Kind, name, key)
+				} else if ov != cv {
+					report("%s: %s %s: attribute %s changed", path, cr.Kind, name, key)
+				}
+			}
+			for key := range cr.Attrs {
+				if _, ok := or.Attrs[key]; !ok {
+					report("%s: %s %s: attribute %s added", path, cr.Kind, name, key)
+				}
+			}
+		}
+		for name, cr := range cf.Rules {
+			if _, ok := of.Rules[name]; !ok {
+				report("%s: %s %s added", path, cr.Kind, name)
+			}
+		}
+		if len(diffs) == n {
+			report("%s: formatting, comments, or order changed", path)
+		}
+	}
+	for path := range cur.Files {
+		if _, ok := old.Files[path]; !ok {
+			report("%s: file is newly generated", path)
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// recordOrVerifySnapshot writes a snapshot of files to the -record_snapshot
+// file, or compares it with the -verify_snapshot file. Differences are
+// printed to w, and an error is returned if there are any.
+func recordOrVerifySnapshot(c *config.Config, uc *updateConfig, files []runner.UpdatedFile, w io.Writer) error {
+	cur, err := makeSnapshot(c, files)
+	if err != nil {
+		return err
+	}
+	if uc.recordSnapshotPath != "" {
+		return writeSnapshot(uc.recordSnapshotPath, cur)
+	}
+	old, err := readSnapshot(uc.verifySnapshotPath)
+	if err != nil {
+		return err
+	}
+	diffs := compareSnapshots(old, cur)
+	for _, d := range diffs {
+		fmt.Fprintln(w, d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("output differs from snapshot %s: %d difference(s)", uc.verifySnapshotPath, len(diffs))
+	}
+	return nil
+}
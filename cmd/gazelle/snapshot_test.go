@@ -0,0 +1,91 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestCompareSnapshots(t *testing.T) {
+	load := func(content string) snapshotFile {
+		f, err := rule.LoadData("BUILD.bazel", "", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snapshotBuildFile(f)
+	}
+	old := snapshot{Version: snapshotVersion, Files: map[string]snapshotFile{
+		"a/BUILD.bazel": load(`load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/a",
+)
+
+go_test(
+    name = "a_test",
+    srcs = ["a_test.go"],
+    embed = [":a"],
+)
+`),
+		"b/BUILD.bazel": load(`go_library(name = "b", srcs = ["b.go"])`),
+		"c/BUILD.bazel": load(`go_library(name = "c", srcs = ["c.go"])`),
+		"d/BUILD.bazel": load(`go_library(name = "d", srcs = ["d.go"])`),
+	}}
+	cur := snapshot{Version: snapshotVersion, Files: map[string]snapshotFile{
+		"a/BUILD.bazel": load(`load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = [
+        "a.go",
+        "a_linux.go",
+    ],
+    importpath = "example.com/a",
+    visibility = ["//visibility:public"],
+)
+
+go_binary(
+    name = "cmd",
+    embed = [":a"],
+)
+`),
+		"c/BUILD.bazel": load(`
+# comment
+go_library(name = "c", srcs = ["c.go"])`),
+		"d/BUILD.bazel": load(`go_library(name = "d", srcs = ["d.go"])`),
+		"e/BUILD.bazel": load(`go_library(name = "e", srcs = ["e.go"])`),
+	}}
+
+	got := compareSnapshots(old, cur)
+	want := []string{
+		"a/BUILD.bazel: go_binary cmd added",
+		"a/BUILD.bazel: go_library a: attribute srcs changed",
+		"a/BUILD.bazel: go_library a: attribute visibility added",
+		"a/BUILD.bazel: go_test a_test removed",
+		"a/BUILD.bazel: loads changed",
+		"b/BUILD.bazel: file is no longer generated",
+		"c/BUILD.bazel: formatting, comments, or order changed",
+		"e/BUILD.bazel: file is newly generated",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
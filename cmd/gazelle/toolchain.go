@@ -0,0 +1,216 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/bzlmod"
+	"github.com/bazelbuild/bazel-gazelle/internal/version"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// goModVersions holds the Go versions declared in a go.mod file.
+type goModVersions struct {
+	// goVersion is the minimum Go version from the go directive, like "1.21".
+	goVersion string
+
+	// toolchain is the version from the toolchain directive without the "go"
+	// prefix, like "1.21.3", or "" if there is none.
+	toolchain string
+}
+
+// readGoModVersions reads the go and toolchain directives from the go.mod
+// file at path.
+func readGoModVersions(path string) (goModVersions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return goModVersions{}, err
+	}
+	var v goModVersions
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "go":
+			v.goVersion = fields[1]
+		case "toolchain":
+			v.toolchain = strings.TrimPrefix(fields[1], "go")
+		}
+	}
+	return v, s.Err()
+}
+
+// wantSDK returns the version the Go SDK registered with Bazel should have,
+// given that it currently has version have (which may be empty if the
+// version isn't set explicitly). ok is false if have is fine.
+//
+// If go.mod has a toolchain directive, the SDK must match it exactly.
+// Otherwise, the SDK must be at least the version in the go directive. An
+// unset SDK version is only changed to match a toolchain directive, since
+// the default version depends on the version of rules_go.
+func (v goModVersions) wantSDK(have string) (want string, ok bool) {
+	if v.toolchain != "" {
+		return v.toolchain, have != v.toolchain
+	}
+	if v.goVersion == "" || have == "" {
+		return "", false
+	}
+	hv, err := version.ParseVersion(have)
+	if err != nil {
+		return "", false
+	}
+	gv, err := version.ParseVersion(v.goVersion)
+	if err != nil {
+		return "", false
+	}
+	return v.goVersion, trimVersionZeros(hv).Compare(trimVersionZeros(gv)) < 0
+}
+
+// trimVersionZeros removes trailing zero components from v, so that "1.21"
+// and "1.21.0" compare as equal.
+func trimVersionZeros(v version.Version) version.Version {
+	for len(v) > 1 && v[len(v)-1] == 0 {
+		v = v[:len(v)-1]
+	}
+	return v
+}
+
+// checkGoSDKVersion compares the Go SDK version registered in the WORKSPACE
+// files and MODULE.bazel with the versions declared in go.mod in the
+// repository root. When fix is true, go_register_toolchains(go_version),
+// go_download_sdk(version), and go_sdk.download(version) are updated to
+// match. WORKSPACE files are modified in place; MODULE.bazel is returned if
+// it was modified, so it can be emitted. Otherwise, mismatches are reported
+// as warnings. Calls marked with "# keep" comments are reported but not
+// changed.
+func checkGoSDKVersion(c *config.Config, workspaceFiles []*rule.File, fix bool) (moduleFile *rule.File) {
+	goModPath := filepath.Join(c.RepoRoot, "go.mod")
+	v, err := readGoModVersions(goModPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return nil
+	}
+
+	for _, f := range workspaceFiles {
+		// go_register_toolchains only accepts go_version when no SDK is
+		// declared with go_download_sdk.
+		var sdks []*rule.Rule
+		for _, r := range f.Rules {
+			if r.Kind() == "go_download_sdk" {
+				sdks = append(sdks, r)
+			}
+		}
+		attr := "version"
+		if len(sdks) == 0 {
+			attr = "go_version"
+			for _, r := range f.Rules {
+				if r.Kind() == "go_register_toolchains" {
+					sdks = append(sdks, r)
+				}
+			}
+		}
+		for _, r := range sdks {
+			have := r.AttrString(attr)
+			if have == "" && r.Attr(attr) != nil {
+				// The version isn't a string literal.
+				continue
+			}
+			want, ok := v.wantSDK(have)
+			if !ok {
+				continue
+			}
+			if !fix || r.ShouldKeep() || rule.ShouldKeep(r.Attr(attr)) {
+				reportGoSDKMismatch(f.Path, r.Kind()+" "+attr, have, goModPath, want, fix)
+				continue
+			}
+			r.SetAttr(attr, want)
+		}
+	}
+
+	mf, err := bzlmod.Load(c, c.RepoRoot)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return nil
+	}
+	keepCompactCalls(mf.File)
+	ext := findExtension(mf.File, "go_sdk")
+	if ext == "" {
+		return nil
+	}
+	changed := false
+	for _, call := range findTags(mf.File, ext, "download") {
+		versionExpr := findKwarg(call, "version")
+		s, _ := versionExpr.(*bzl.StringExpr)
+		if versionExpr != nil && s == nil {
+			// The version isn't a string literal.
+			continue
+		}
+		var have string
+		if s != nil {
+			have = s.Value
+		}
+		want, ok := v.wantSDK(have)
+		if !ok {
+			continue
+		}
+		if !fix || rule.ShouldKeep(call) {
+			reportGoSDKMismatch(mf.Path, ext+".download version", have, goModPath, want, fix)
+			continue
+		}
+		if s != nil {
+			s.Value = want
+		} else {
+			call.List = append(call.List, kwarg("version", want))
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return mf
+}
+
+func reportGoSDKMismatch(path, what, have, goModPath, want string, fix bool) {
+	if have == "" {
+		have = "not set"
+	}
+	msg := fmt.Sprintf("%s: %s is %s, but %s requires Go %s", path, what, have, goModPath, want)
+	if !fix {
+		msg += `; run "gazelle fix" to update it`
+	}
+	log.Print(msg)
+}
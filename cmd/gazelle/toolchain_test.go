@@ -0,0 +1,113 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestWantSDK(t *testing.T) {
+	for _, tc := range []struct {
+		desc                       string
+		goVersion, toolchain, have string
+		want                       string
+		wantOK                     bool
+	}{
+		{desc: "toolchain_match", goVersion: "1.21", toolchain: "1.21.3", have: "1.21.3"},
+		{desc: "toolchain_mismatch", goVersion: "1.21", toolchain: "1.21.3", have: "1.21.0", want: "1.21.3", wantOK: true},
+		{desc: "toolchain_unset", goVersion: "1.21", toolchain: "1.21.3", want: "1.21.3", wantOK: true},
+		{desc: "go_newer_sdk", goVersion: "1.20", have: "1.21.3"},
+		{desc: "go_same_sdk", goVersion: "1.21", have: "1.21.0"},
+		{desc: "go_older_sdk", goVersion: "1.21", have: "1.20.5", want: "1.21", wantOK: true},
+		{desc: "go_unset", goVersion: "1.21"},
+		{desc: "unparsed", goVersion: "1.21", have: "1.22rc1"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			v := goModVersions{goVersion: tc.goVersion, toolchain: tc.toolchain}
+			want, ok := v.wantSDK(tc.have)
+			if ok != tc.wantOK || (ok && want != tc.want) {
+				t.Errorf("got %q, %v; want %q, %v", want, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestFixGoSDKVersion(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "go.mod",
+			Content: `module example.com/m
+
+go 1.21
+
+toolchain go1.21.3
+`,
+		}, {
+			Path: "WORKSPACE",
+			Content: `load("@io_bazel_rules_go//go:deps.bzl", "go_register_toolchains", "go_rules_dependencies")
+
+go_rules_dependencies()
+
+go_register_toolchains(go_version = "1.20.5")
+`,
+		}, {
+			Path: "MODULE.bazel",
+			Content: `module(name = "m")
+
+bazel_dep(name = "rules_go", version = "0.41.0")
+
+go_sdk = use_extension("@rules_go//go:extensions.bzl", "go_sdk")
+
+go_sdk.download(version = "1.20.5")
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	// update only reports mismatches.
+	if err := runGazelle(dir, []string{"update"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, files[1:])
+
+	if err := runGazelle(dir, []string{"fix"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `load("@io_bazel_rules_go//go:deps.bzl", "go_register_toolchains", "go_rules_dependencies")
+
+go_rules_dependencies()
+
+go_register_toolchains(go_version = "1.21.3")
+`,
+		}, {
+			Path: "MODULE.bazel",
+			Content: `module(name = "m")
+
+bazel_dep(name = "rules_go", version = "0.41.0")
+
+go_sdk = use_extension("@rules_go//go:extensions.bzl", "go_sdk")
+
+go_sdk.download(version = "1.21.3")
+`,
+		},
+	})
+}
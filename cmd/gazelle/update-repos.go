@@ -16,9 +16,12 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -29,6 +32,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/merger"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/runner"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
@@ -38,6 +42,10 @@ type updateReposConfig struct {
 	macroFileName string
 	macroDefName  string
 	pruneRules    bool
+	pruneReport   string
+	groupMacro    bool
+	bzlmod        bool
+	bazelDeps     bool
 	workspace     *rule.File
 	repoFileMap   map[string]*rule.File
 }
@@ -75,13 +83,18 @@ func (f macroFlag) String() string {
 func (*updateReposConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	uc := &updateReposConfig{}
 	c.Exts[updateReposName] = uc
-	fs.StringVar(&uc.repoFilePath, "from_file", "", "Gazelle will translate repositories listed in this file into repository rules in WORKSPACE or a .bzl macro function. Gopkg.lock and go.mod files are supported")
+	fs.StringVar(&uc.repoFilePath, "from_file", "", "Gazelle will translate repositories listed in this file into repository rules in WORKSPACE or a .bzl macro function. Gopkg.lock and go.mod files are supported, as are formats registered by extensions")
 	fs.Var(macroFlag{macroFileName: &uc.macroFileName, macroDefName: &uc.macroDefName}, "to_macro", "Tells Gazelle to write repository rules into a .bzl macro function rather than the WORKSPACE file. . The expected format is: macroFile%defName")
 	fs.BoolVar(&uc.pruneRules, "prune", false, "When enabled, Gazelle will remove rules that no longer have equivalent repos in the Gopkg.lock/go.mod file. Can only used with -from_file.")
+	fs.StringVar(&uc.pruneReport, "prune_report", "", "When set with -prune, Gazelle will write a JSON report explaining each removed rule to this file.")
+	fs.BoolVar(&uc.groupMacro, "group_macro", false, "When set with -from_file and -to_macro, Gazelle will organize repository rules in the macro into commented sections for direct, test-only, and transitive dependencies, sorted by name within each section.")
+	fs.BoolVar(&uc.bzlmod, "bzlmod", false, "When enabled, Gazelle will declare modules with the go_deps extension in MODULE.bazel instead of writing repository rules to WORKSPACE.")
+	fs.BoolVar(&uc.bazelDeps, "bazel_deps", false, "When set with -bzlmod, Gazelle will declare modules that are also published in the Bazel Central Registry with bazel_dep instead of go_deps.")
 }
 
 func (*updateReposConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 	uc := getUpdateReposConfig(c)
+	setLogBaseDir(c.RepoRoot)
 	switch {
 	case uc.repoFilePath != "":
 		if len(fs.Args()) != 0 {
@@ -97,10 +110,29 @@ func (*updateReposConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) err
 		}
 		uc.importPaths = fs.Args()
 	}
+	if uc.pruneReport != "" && !uc.pruneRules {
+		return fmt.Errorf("the -prune_report option can only be used with -prune")
+	}
+	if uc.bzlmod && uc.macroFileName != "" {
+		return fmt.Errorf("the -to_macro option can't be used with -bzlmod")
+	}
+	if uc.groupMacro && (uc.repoFilePath == "" || uc.macroFileName == "") {
+		return fmt.Errorf("the -group_macro option can only be used with -from_file and -to_macro")
+	}
+	if uc.bazelDeps && !uc.bzlmod {
+		return fmt.Errorf("the -bazel_deps option can only be used with -bzlmod")
+	}
+	if uc.bzlmod && uc.pruneReport != "" {
+		return fmt.Errorf("the -prune_report option can't be used with -bzlmod")
+	}
 
 	var err error
 	workspacePath := filepath.Join(c.RepoRoot, "WORKSPACE")
 	uc.workspace, err = rule.LoadWorkspaceFile(workspacePath, "")
+	if uc.bzlmod && os.IsNotExist(err) {
+		// Repositories are declared in MODULE.bazel. WORKSPACE is optional.
+		uc.workspace, err = rule.EmptyFile(workspacePath, ""), nil
+	}
 	if err != nil {
 		return fmt.Errorf("loading WORKSPACE file: %v", err)
 	}
@@ -120,19 +152,18 @@ func updateRepos(args []string) (err error) {
 	// Build configuration with all languages.
 	cexts := make([]config.Configurer, 0, len(languages)+2)
 	cexts = append(cexts, &config.CommonConfigurer{}, &updateReposConfigurer{})
-	kinds := make(map[string]rule.KindInfo)
-	loads := []rule.LoadInfo{}
 	for _, lang := range languages {
 		cexts = append(cexts, lang)
-		loads = append(loads, lang.Loads()...)
-		for kind, info := range lang.Kinds() {
-			kinds[kind] = info
-		}
 	}
 	c, err := newUpdateReposConfiguration(args, cexts)
 	if err != nil {
 		return err
 	}
+	lk, err := runner.CollectKinds(languages, c.KindOwners)
+	if err != nil {
+		return err
+	}
+	kinds, loads := lk.Kinds, lk.Loads
 	uc := getUpdateReposConfig(c)
 
 	// TODO(jayconrod): move Go-specific RemoteCache logic to language/go.
@@ -161,14 +192,19 @@ func updateRepos(args []string) (err error) {
 
 	// Generate rules from command language arguments or by importing a file.
 	var gen, empty []*rule.Rule
+	var pruneReasons map[string]string
+	var groups map[string]language.RepoGroup
 	if uc.repoFilePath == "" {
 		gen, err = updateRepoImports(c, rc)
 	} else {
-		gen, empty, err = importRepos(c, rc)
+		gen, empty, pruneReasons, groups, err = importRepos(c, rc)
 	}
 	if err != nil {
 		return err
 	}
+	if uc.bzlmod {
+		return updateModuleFile(c, uc, gen)
+	}
 
 	// Organize generated and empty rules by file. A rule should go into the file
 	// it came from (by name). New rules should go into WORKSPACE or the file
@@ -200,6 +236,10 @@ func updateRepos(args []string) (err error) {
 		}
 		emptyForFiles[f] = append(emptyForFiles[f], r)
 	}
+	pruned := prunedRepos(c, uc, empty, pruneReasons)
+	for _, p := range pruned {
+		log.Printf("pruning %s %s from %s: %s", p.Kind, p.Name, p.File, p.Reason)
+	}
 
 	var newGenFile *rule.File
 	var macroPath string
@@ -263,6 +303,10 @@ func updateRepos(args []string) (err error) {
 	for _, f := range sortedFiles {
 		merger.MergeFile(f, emptyForFiles[f], genForFiles[f], merger.PreResolve, kinds)
 		merger.FixLoads(f, loads)
+		if f == newGenFile && uc.groupMacro {
+			f.Sync()
+			groupMacroRules(f, groups)
+		}
 		if f == uc.workspace {
 			if err := merger.CheckGazelleLoaded(f); err != nil {
 				return err
@@ -286,6 +330,12 @@ func updateRepos(args []string) (err error) {
 		}
 	}
 
+	if uc.pruneReport != "" {
+		if err := writePruneReport(uc.pruneReport, pruned); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -356,7 +406,7 @@ func updateRepoImports(c *config.Config, rc *repo.RemoteCache) (gen []*rule.Rule
 	return res.Gen, res.Error
 }
 
-func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rule, err error) {
+func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rule, pruneReasons map[string]string, groups map[string]language.RepoGroup, err error) {
 	uc := getUpdateReposConfig(c)
 	importSupported := false
 	var importer language.RepoImporter
@@ -371,9 +421,9 @@ func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rul
 	}
 	if importer == nil {
 		if importSupported {
-			return nil, nil, fmt.Errorf("unknown file format: %s", uc.repoFilePath)
+			return nil, nil, nil, nil, fmt.Errorf("unknown file format: %s", uc.repoFilePath)
 		} else {
-			return nil, nil, fmt.Errorf("no supported languages can import configuration files")
+			return nil, nil, nil, nil, fmt.Errorf("no supported languages can import configuration files")
 		}
 	}
 	res := importer.ImportRepos(language.ImportReposArgs{
@@ -381,8 +431,71 @@ func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rul
 		Path:   uc.repoFilePath,
 		Prune:  uc.pruneRules,
 		Cache:  rc,
+		Group:  uc.groupMacro,
+	})
+	return res.Gen, res.Empty, res.PruneReasons, res.Groups, res.Error
+}
+
+// prunedRepo describes a repository rule removed with -prune.
+type prunedRepo struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// prunedRepos returns a description of each rule in empty that will actually
+// be deleted, sorted by name. Rules marked with "# keep" are not included.
+func prunedRepos(c *config.Config, uc *updateReposConfig, empty []*rule.Rule, reasons map[string]string) []prunedRepo {
+	var pruned []prunedRepo
+	for _, r := range empty {
+		f := uc.repoFileMap[r.Name()]
+		var old *rule.Rule
+		for _, fr := range f.Rules {
+			if fr.Name() == r.Name() && fr.Kind() == r.Kind() {
+				old = fr
+				break
+			}
+		}
+		if old == nil || old.ShouldKeep() {
+			continue
+		}
+		file, err := filepath.Rel(c.RepoRoot, f.Path)
+		if err != nil {
+			file = f.Path
+		}
+		file = filepath.ToSlash(file)
+		if f.DefName != "" {
+			file += "%" + f.DefName
+		}
+		reason := reasons[r.Name()]
+		if reason == "" {
+			reason = "not listed in " + filepath.Base(uc.repoFilePath)
+		}
+		pruned = append(pruned, prunedRepo{
+			Name:   r.Name(),
+			Kind:   r.Kind(),
+			File:   file,
+			Reason: reason,
+		})
+	}
+	sort.Slice(pruned, func(i, j int) bool {
+		return pruned[i].Name < pruned[j].Name
 	})
-	return res.Gen, res.Empty, res.Error
+	return pruned
+}
+
+// writePruneReport writes a JSON list of pruned repository rules to path.
+func writePruneReport(path string, pruned []prunedRepo) error {
+	if pruned == nil {
+		pruned = []prunedRepo{}
+	}
+	data, err := json.MarshalIndent(pruned, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return ioutil.WriteFile(path, data, 0666)
 }
 
 // ensureMacroInWorkspace adds a call to the repository macro if the -to_macro
@@ -31,8 +31,11 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
+	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
@@ -80,6 +83,27 @@ type Config struct {
 	// # gazelle:map_kind.
 	KindMap map[string]MappedKind
 
+	// KindOwners maps kind and load symbol names to the names of languages
+	// that should provide them when more than one language does. Set with
+	// -kind_owner.
+	KindOwners map[string]string
+
+	// Frozen indicates that build files in this directory and its
+	// subdirectories should not be modified, as set with # gazelle:frozen.
+	// Rules in frozen build files are still indexed for dependency
+	// resolution. Changes Gazelle would have made are reported instead.
+	Frozen bool
+
+	// SrcsGlobThreshold is the number of files in a srcs attribute above
+	// which Gazelle writes a glob instead of a list of files. 0 means lists
+	// are always written. Set with -srcs_glob_threshold or
+	// # gazelle:srcs_glob_threshold.
+	SrcsGlobThreshold int
+
+	// Strict indicates that problems reported with ReportProblem should fail
+	// the run. Set with -strict.
+	Strict bool
+
 	// Repos is a list of repository rules declared in the main WORKSPACE file
 	// or in macros called by the main WORKSPACE file. This may affect rule
 	// generation and dependency resolution.
@@ -90,6 +114,14 @@ type Config struct {
 	// extensions as well. Values in here may be populated by command line
 	// arguments, directives in build files, or other mechanisms.
 	Exts map[string]interface{}
+
+	// directiveValues holds the values of directives declared with
+	// DirectiveSchema. See DirectiveValue.
+	directiveValues map[string]interface{}
+
+	// problems counts the problems reported with ReportProblem. It's shared
+	// by all copies of the configuration.
+	problems *int32
 }
 
 // MappedKind describes a replacement to use for a built-in kind.
@@ -101,6 +133,7 @@ func New() *Config {
 	return &Config{
 		ValidBuildFileNames: DefaultValidBuildFileNames,
 		Exts:                make(map[string]interface{}),
+		problems:            new(int32),
 	}
 }
 
@@ -117,11 +150,36 @@ func (c *Config) Clone() *Config {
 	for k, v := range c.KindMap {
 		cc.KindMap[k] = v
 	}
+	if c.directiveValues != nil {
+		cc.directiveValues = make(map[string]interface{})
+		for k, v := range c.directiveValues {
+			cc.directiveValues[k] = v
+		}
+	}
 	return &cc
 }
 
 var DefaultValidBuildFileNames = []string{"BUILD.bazel", "BUILD"}
 
+// ReportProblem logs a problem an extension found in the repository that
+// Gazelle can work around but that the user should fix, like an ambiguous
+// set of files. When Strict is set, the problem is counted, and the run
+// fails after build files are written.
+func (c *Config) ReportProblem(err error) {
+	log.Print(err)
+	if c.Strict && c.problems != nil {
+		atomic.AddInt32(c.problems, 1)
+	}
+}
+
+// ProblemCount returns the number of problems counted by ReportProblem.
+func (c *Config) ProblemCount() int {
+	if c.problems == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(c.problems))
+}
+
 // IsValidBuildFileName returns true if a file with the given base name
 // should be treated as a build file.
 func (c *Config) IsValidBuildFileName(name string) bool {
@@ -177,6 +235,9 @@ type Configurer interface {
 type CommonConfigurer struct {
 	repoRoot, buildFileNames, readBuildFilesDir, writeBuildFilesDir string
 	indexLibraries                                                  bool
+	kindOwners                                                      []string
+	srcsGlobThreshold                                               int
+	strict                                                          bool
 }
 
 func (cc *CommonConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Config) {
@@ -185,6 +246,9 @@ func (cc *CommonConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Confi
 	fs.BoolVar(&cc.indexLibraries, "index", true, "when true, gazelle will build an index of libraries in the workspace for dependency resolution")
 	fs.StringVar(&cc.readBuildFilesDir, "experimental_read_build_files_dir", "", "path to a directory where build files should be read from (instead of -repo_root)")
 	fs.StringVar(&cc.writeBuildFilesDir, "experimental_write_build_files_dir", "", "path to a directory where build files should be written to (instead of -repo_root)")
+	fs.Var(&gzflag.MultiFlag{Values: &cc.kindOwners}, "kind_owner", "name=lang: when several languages provide a rule kind or load symbol with this name, use the one from lang (can specify multiple times)")
+	fs.IntVar(&cc.srcsGlobThreshold, "srcs_glob_threshold", 0, "when a srcs attribute would list more than this many files, write a glob instead (0 means never)")
+	fs.BoolVar(&cc.strict, "strict", false, "when true, problems Gazelle would otherwise log and work around, like conflicting proto packages in one directory, cause it to exit with an error")
 }
 
 func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
@@ -192,7 +256,7 @@ func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 	if cc.repoRoot == "" {
 		cc.repoRoot, err = wspace.Find(".")
 		if err != nil {
-			return fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
+			return fmt.Errorf("-repo_root not specified, and WORKSPACE or MODULE.bazel cannot be found: %v", err)
 		}
 	}
 	c.RepoRoot, err = filepath.Abs(cc.repoRoot)
@@ -217,11 +281,26 @@ func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 		}
 	}
 	c.IndexLibraries = cc.indexLibraries
+	if cc.srcsGlobThreshold < 0 {
+		return fmt.Errorf("-srcs_glob_threshold %d: must not be negative", cc.srcsGlobThreshold)
+	}
+	c.SrcsGlobThreshold = cc.srcsGlobThreshold
+	c.Strict = cc.strict
+	if len(cc.kindOwners) > 0 {
+		c.KindOwners = make(map[string]string)
+		for _, v := range cc.kindOwners {
+			i := strings.Index(v, "=")
+			if i <= 0 || i == len(v)-1 {
+				return fmt.Errorf("-kind_owner %q: want name=lang", v)
+			}
+			c.KindOwners[v[:i]] = v[i+1:]
+		}
+	}
 	return nil
 }
 
 func (cc *CommonConfigurer) KnownDirectives() []string {
-	return []string{"build_file_name", "map_kind"}
+	return []string{"build_file_name", "frozen", "map_kind", "srcs_glob_threshold"}
 }
 
 func (cc *CommonConfigurer) Configure(c *Config, rel string, f *rule.File) {
@@ -233,6 +312,30 @@ func (cc *CommonConfigurer) Configure(c *Config, rel string, f *rule.File) {
 		case "build_file_name":
 			c.ValidBuildFileNames = strings.Split(d.Value, ",")
 
+		case "frozen":
+			if d.Value == "" {
+				c.Frozen = true
+				continue
+			}
+			frozen, err := strconv.ParseBool(d.Value)
+			if err != nil {
+				log.Printf("%s: invalid value for frozen: %q; want true or false", f.Path, d.Value)
+				continue
+			}
+			c.Frozen = frozen
+
+		case "srcs_glob_threshold":
+			if d.Value == "" {
+				c.SrcsGlobThreshold = 0
+				continue
+			}
+			n, err := strconv.Atoi(d.Value)
+			if err != nil || n < 0 {
+				log.Printf("%s: invalid value for srcs_glob_threshold: %q; want a non-negative number", f.Path, d.Value)
+				continue
+			}
+			c.SrcsGlobThreshold = n
+
 		case "map_kind":
 			vals := strings.Fields(d.Value)
 			if len(vals) != 3 {
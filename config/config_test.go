@@ -16,8 +16,10 @@ limitations under the License.
 package config
 
 import (
+	"errors"
 	"flag"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -44,7 +46,7 @@ func TestCommonConfigurerFlags(t *testing.T) {
 	cc := &CommonConfigurer{}
 	fs := flag.NewFlagSet("test", flag.ContinueOnError)
 	cc.RegisterFlags(fs, "test", c)
-	args := []string{"-repo_root", dir, "-build_file_name", "x,y"}
+	args := []string{"-repo_root", dir, "-build_file_name", "x,y", "-kind_owner", "go_library=go", "-kind_owner", "x=y=z", "-strict"}
 	if err := fs.Parse(args); err != nil {
 		t.Fatal(err)
 	}
@@ -60,12 +62,39 @@ func TestCommonConfigurerFlags(t *testing.T) {
 	if !reflect.DeepEqual(c.ValidBuildFileNames, wantBuildFileNames) {
 		t.Errorf("for ValidBuildFileNames, got %#v, want %#v", c.ValidBuildFileNames, wantBuildFileNames)
 	}
+
+	wantKindOwners := map[string]string{"go_library": "go", "x": "y=z"}
+	if !reflect.DeepEqual(c.KindOwners, wantKindOwners) {
+		t.Errorf("for KindOwners, got %#v, want %#v", c.KindOwners, wantKindOwners)
+	}
+
+	if !c.Strict {
+		t.Errorf("for Strict, got false, want true")
+	}
+}
+
+func TestReportProblem(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	c := New()
+	c.ReportProblem(errors.New("lenient"))
+	if n := c.ProblemCount(); n != 0 {
+		t.Errorf("without Strict, got %d problems; want 0", n)
+	}
+	c.Strict = true
+	sub := c.Clone()
+	sub.ReportProblem(errors.New("strict"))
+	if n := c.ProblemCount(); n != 1 {
+		t.Errorf("with Strict, got %d problems; want 1", n)
+	}
 }
 
 func TestCommonConfigurerDirectives(t *testing.T) {
 	c := New()
 	cc := &CommonConfigurer{}
-	buildData := []byte(`# gazelle:build_file_name x,y`)
+	buildData := []byte(`# gazelle:build_file_name x,y
+# gazelle:frozen`)
 	f, err := rule.LoadData(filepath.Join("test", "BUILD.bazel"), "", buildData)
 	if err != nil {
 		t.Fatal(err)
@@ -75,4 +104,7 @@ func TestCommonConfigurerDirectives(t *testing.T) {
 	if !reflect.DeepEqual(c.ValidBuildFileNames, want) {
 		t.Errorf("for ValidBuildFileNames, got %#v, want %#v", c.ValidBuildFileNames, want)
 	}
+	if !c.Frozen {
+		t.Errorf("for Frozen, got false, want true")
+	}
 }
@@ -0,0 +1,356 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// ValueType is the type of the value of a directive declared with a
+// DirectiveSchema.
+type ValueType int
+
+const (
+	// StringValue directives accept any string. Values are strings.
+	StringValue ValueType = iota
+
+	// BoolValue directives accept "true" or "false". Values are bools.
+	BoolValue
+
+	// IntValue directives accept decimal integers. Values are ints.
+	IntValue
+
+	// LabelValue directives accept Bazel labels. Values are label.Label.
+	LabelValue
+
+	// EnumValue directives accept one of the strings listed in
+	// DirectiveSchema.Values. Values are strings.
+	EnumValue
+
+	// ListValue directives accept a comma-separated list of strings. Values
+	// are []string.
+	ListValue
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case StringValue:
+		return "string"
+	case BoolValue:
+		return "bool"
+	case IntValue:
+		return "int"
+	case LabelValue:
+		return "label"
+	case EnumValue:
+		return "enum"
+	case ListValue:
+		return "list"
+	default:
+		return fmt.Sprintf("ValueType(%d)", int(t))
+	}
+}
+
+// Inheritance describes whether a directive set in a directory applies to
+// its subdirectories.
+type Inheritance int
+
+const (
+	// Inherited directives apply to the directory where they're set and to
+	// its subdirectories, unless they're set again.
+	Inherited Inheritance = iota
+
+	// NotInherited directives only apply to the directory where they're set.
+	NotInherited
+
+	// Accumulated directives apply to subdirectories, and values set in a
+	// subdirectory are appended to the inherited value. Only ListValue
+	// directives may be accumulated.
+	Accumulated
+)
+
+func (i Inheritance) String() string {
+	switch i {
+	case Inherited:
+		return "inherited"
+	case NotInherited:
+		return "not inherited"
+	case Accumulated:
+		return "accumulated"
+	default:
+		return fmt.Sprintf("Inheritance(%d)", int(i))
+	}
+}
+
+// DirectiveSchema describes a directive that a Configurer accepts. Gazelle
+// parses and validates directives with schemas before calling Configure,
+// so extensions can read typed values with Config.DirectiveValue instead of
+// parsing directives themselves.
+type DirectiveSchema struct {
+	// Name is the directive key, as in "# gazelle:<Name> <value>".
+	Name string
+
+	// Type is the type of the directive's value.
+	Type ValueType
+
+	// Values lists the values accepted by an EnumValue directive.
+	Values []string
+
+	// Inherit describes how the directive applies to subdirectories.
+	Inherit Inheritance
+
+	// Flag indicates that a command-line flag with the same name sets the
+	// directive's value for the repository root.
+	Flag bool
+
+	// Doc is a short description of the directive, printed in help output.
+	Doc string
+}
+
+// SchemaConfigurer is implemented by Configurers that declare schemas for
+// their directives. Directives with schemas don't need to be listed in
+// KnownDirectives. Gazelle logs directives with invalid values and doesn't
+// pass them to Configure.
+type SchemaConfigurer interface {
+	Configurer
+
+	// DirectiveSchema returns the schemas of directives the Configurer
+	// accepts. It's called once when Gazelle starts.
+	DirectiveSchema() []DirectiveSchema
+}
+
+// Parse converts value to the directive's type. An error is returned if
+// value is not valid for the directive.
+func (s DirectiveSchema) Parse(value string) (interface{}, error) {
+	switch s.Type {
+	case StringValue:
+		return value, nil
+	case BoolValue:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", value)
+		}
+		return b, nil
+	case IntValue:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", value)
+		}
+		return n, nil
+	case LabelValue:
+		l, err := label.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	case EnumValue:
+		for _, v := range s.Values {
+			if value == v {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not one of %s", value, strings.Join(s.Values, ", "))
+	case ListValue:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown value type %v", s.Type)
+	}
+}
+
+func (s DirectiveSchema) check() error {
+	if s.Name == "" {
+		return fmt.Errorf("directive schema has no name")
+	}
+	if s.Type == EnumValue && len(s.Values) == 0 {
+		return fmt.Errorf("directive %s: enum has no values", s.Name)
+	}
+	if s.Type != EnumValue && len(s.Values) > 0 {
+		return fmt.Errorf("directive %s: only enum directives may list values", s.Name)
+	}
+	if s.Inherit == Accumulated && s.Type != ListValue {
+		return fmt.Errorf("directive %s: only list directives may be accumulated", s.Name)
+	}
+	return nil
+}
+
+// Schema is the set of directive schemas declared by a list of
+// Configurers, indexed by directive name.
+type Schema map[string]DirectiveSchema
+
+// CollectSchema returns the directive schemas declared by Configurers in
+// cexts that implement SchemaConfigurer. An error is returned if a schema
+// is malformed or if a directive is declared more than once.
+func CollectSchema(cexts []Configurer) (Schema, error) {
+	schema := make(Schema)
+	for _, cext := range cexts {
+		sc, ok := cext.(SchemaConfigurer)
+		if !ok {
+			continue
+		}
+		for _, s := range sc.DirectiveSchema() {
+			if err := s.check(); err != nil {
+				return nil, err
+			}
+			if _, ok := schema[s.Name]; ok {
+				return nil, fmt.Errorf("directive %s is declared more than once", s.Name)
+			}
+			schema[s.Name] = s
+		}
+	}
+	return schema, nil
+}
+
+// Names returns the names of directives in the schema in sorted order.
+func (schema Schema) Names() []string {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterFlags registers a flag for each directive in the schema with
+// Flag set. Flag values are stored in c, the root configuration.
+func (schema Schema) RegisterFlags(fs *flag.FlagSet, c *Config) error {
+	for _, name := range schema.Names() {
+		s := schema[name]
+		if !s.Flag {
+			continue
+		}
+		if fs.Lookup(name) != nil {
+			return fmt.Errorf("directive %s: flag -%s is already defined", name, name)
+		}
+		fs.Var(&schemaFlag{schema: s, c: c}, name, s.Doc)
+	}
+	return nil
+}
+
+type schemaFlag struct {
+	schema DirectiveSchema
+	c      *Config
+	value  string
+}
+
+func (f *schemaFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *schemaFlag) Set(value string) error {
+	v, err := f.schema.Parse(value)
+	if err != nil {
+		return err
+	}
+	f.value = value
+	f.c.setDirectiveValue(f.schema, v)
+	return nil
+}
+
+// Configure applies directives with schemas in f to c, the configuration
+// for the directory rel. Values of directives that aren't inherited are
+// cleared first. Directives with invalid values are logged and removed
+// from f.Directives, so Configurers don't see them.
+func (schema Schema) Configure(c *Config, rel string, f *rule.File) {
+	if rel != "" {
+		for name := range c.directiveValues {
+			if schema[name].Inherit == NotInherited {
+				delete(c.directiveValues, name)
+			}
+		}
+	}
+	if f == nil {
+		return
+	}
+	valid := f.Directives[:0]
+	for _, d := range f.Directives {
+		s, ok := schema[d.Key]
+		if !ok {
+			valid = append(valid, d)
+			continue
+		}
+		if d.Value == "" {
+			delete(c.directiveValues, d.Key)
+			valid = append(valid, d)
+			continue
+		}
+		v, err := s.Parse(d.Value)
+		if err != nil {
+			log.Printf("%s: invalid directive gazelle:%s: %v", f.Path, d.Key, err)
+			continue
+		}
+		c.setDirectiveValue(s, v)
+		valid = append(valid, d)
+	}
+	f.Directives = valid
+}
+
+// WriteDoc writes a description of each directive in the schema to w,
+// in sorted order.
+func (schema Schema) WriteDoc(w io.Writer) {
+	for _, name := range schema.Names() {
+		s := schema[name]
+		typ := s.Type.String()
+		if s.Type == EnumValue {
+			typ = strings.Join(s.Values, "|")
+		}
+		fmt.Fprintf(w, "  # gazelle:%s %s (%s", name, typ, s.Inherit)
+		if s.Flag {
+			fmt.Fprintf(w, ", also -%s", name)
+		}
+		fmt.Fprintf(w, ")\n")
+		if s.Doc != "" {
+			fmt.Fprintf(w, "    \t%s\n", s.Doc)
+		}
+	}
+}
+
+func (c *Config) setDirectiveValue(s DirectiveSchema, v interface{}) {
+	if c.directiveValues == nil {
+		c.directiveValues = make(map[string]interface{})
+	}
+	if s.Inherit == Accumulated {
+		old, _ := c.directiveValues[s.Name].([]string)
+		v = append(append([]string(nil), old...), v.([]string)...)
+	}
+	c.directiveValues[s.Name] = v
+}
+
+// DirectiveValue returns the value of a directive declared with a
+// DirectiveSchema that applies to the current directory, set in a build
+// file or with a flag. The type of the value depends on the directive's
+// ValueType. false is returned if the directive is not set.
+func (c *Config) DirectiveValue(name string) (interface{}, bool) {
+	v, ok := c.directiveValues[name]
+	return v, ok
+}
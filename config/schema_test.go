@@ -0,0 +1,187 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type schemaConfigurer struct {
+	CommonConfigurer
+	schema []DirectiveSchema
+}
+
+func (sc *schemaConfigurer) DirectiveSchema() []DirectiveSchema {
+	return sc.schema
+}
+
+var testSchema = []DirectiveSchema{
+	{Name: "x_enabled", Type: BoolValue, Flag: true, Doc: "enables x"},
+	{Name: "x_count", Type: IntValue},
+	{Name: "x_mode", Type: EnumValue, Values: []string{"a", "b"}},
+	{Name: "x_proto", Type: LabelValue, Inherit: NotInherited},
+	{Name: "x_tags", Type: ListValue, Inherit: Accumulated},
+}
+
+func TestDirectiveSchemaParse(t *testing.T) {
+	for _, tc := range []struct {
+		desc, value string
+		schema      DirectiveSchema
+		want        interface{}
+		wantErr     bool
+	}{
+		{desc: "string", schema: DirectiveSchema{Type: StringValue}, value: "x y", want: "x y"},
+		{desc: "bool", schema: DirectiveSchema{Type: BoolValue}, value: "true", want: true},
+		{desc: "bad_bool", schema: DirectiveSchema{Type: BoolValue}, value: "yes", wantErr: true},
+		{desc: "int", schema: DirectiveSchema{Type: IntValue}, value: "42", want: 42},
+		{desc: "bad_int", schema: DirectiveSchema{Type: IntValue}, value: "many", wantErr: true},
+		{desc: "label", schema: DirectiveSchema{Type: LabelValue}, value: "//a:b", want: label.New("", "a", "b")},
+		{desc: "bad_label", schema: DirectiveSchema{Type: LabelValue}, value: "//a:b:c", wantErr: true},
+		{desc: "enum", schema: DirectiveSchema{Type: EnumValue, Values: []string{"a", "b"}}, value: "b", want: "b"},
+		{desc: "bad_enum", schema: DirectiveSchema{Type: EnumValue, Values: []string{"a", "b"}}, value: "c", wantErr: true},
+		{desc: "list", schema: DirectiveSchema{Type: ListValue}, value: "a, b,,c", want: []string{"a", "b", "c"}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := tc.schema.Parse(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %#v; want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCollectSchema(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		schemas [][]DirectiveSchema
+		wantErr string
+	}{
+		{
+			desc:    "duplicate",
+			schemas: [][]DirectiveSchema{testSchema, {{Name: "x_count", Type: IntValue}}},
+			wantErr: "declared more than once",
+		}, {
+			desc:    "enum_without_values",
+			schemas: [][]DirectiveSchema{{{Name: "y", Type: EnumValue}}},
+			wantErr: "enum has no values",
+		}, {
+			desc:    "accumulated_string",
+			schemas: [][]DirectiveSchema{{{Name: "y", Inherit: Accumulated}}},
+			wantErr: "only list directives may be accumulated",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			cexts := []Configurer{&CommonConfigurer{}}
+			for _, s := range tc.schemas {
+				cexts = append(cexts, &schemaConfigurer{schema: s})
+			}
+			_, err := CollectSchema(cexts)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v; want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaConfigure(t *testing.T) {
+	schema, err := CollectSchema([]Configurer{&schemaConfigurer{schema: testSchema}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := schema.RegisterFlags(fs, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"-x_enabled=true"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	root, err := rule.LoadData("BUILD.bazel", "", []byte(`
+# gazelle:x_count 3
+# gazelle:x_mode c
+# gazelle:x_proto //a:a_proto
+# gazelle:x_tags a,b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.Configure(c, "", root)
+	if len(root.Directives) != 3 {
+		t.Errorf("got %d directives after validation; want 3", len(root.Directives))
+	}
+	if want := "invalid directive gazelle:x_mode"; !strings.Contains(logBuf.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, logBuf.String())
+	}
+
+	sub, err := rule.LoadData(filepath.Join("sub", "BUILD.bazel"), "sub", []byte(`
+# gazelle:x_enabled
+# gazelle:x_tags c
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subc := c.Clone()
+	schema.Configure(subc, "sub", sub)
+
+	for _, tc := range []struct {
+		c    *Config
+		name string
+		want interface{}
+	}{
+		{c, "x_enabled", true},
+		{c, "x_count", 3},
+		{c, "x_mode", nil},
+		{c, "x_proto", label.New("", "a", "a_proto")},
+		{c, "x_tags", []string{"a", "b"}},
+		{subc, "x_enabled", nil},
+		{subc, "x_count", 3},
+		{subc, "x_proto", nil},
+		{subc, "x_tags", []string{"a", "b", "c"}},
+	} {
+		got, ok := tc.c.DirectiveValue(tc.name)
+		if tc.want == nil {
+			if ok {
+				t.Errorf("%s: got %#v; want unset", tc.name, got)
+			}
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v; want %#v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bzlmod reads MODULE.bazel files, which declare a Bazel module and
+// the modules it depends on.
+package bzlmod
+
+import (
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// Load reads and parses the MODULE.bazel file in the directory dir. The file
+// is read through the file system set for c with walk.SetFS. If there is no
+// MODULE.bazel file, the returned error satisfies os.IsNotExist.
+func Load(c *config.Config, dir string) (*rule.File, error) {
+	path := filepath.Join(dir, "MODULE.bazel")
+	data, err := walk.GetFS(c).ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return rule.LoadWorkspaceData(path, "", data)
+}
+
+// RepoNames returns a map from the names of modules declared with bazel_dep
+// in f to the names of their repositories in the declaring module: the
+// repo_name given in the bazel_dep, or the module name if there is none.
+func RepoNames(f *rule.File) map[string]string {
+	repos := make(map[string]string)
+	for _, r := range f.Rules {
+		if r.Kind() != "bazel_dep" || r.Name() == "" {
+			continue
+		}
+		repoName := r.AttrString("repo_name")
+		if repoName == "" {
+			repoName = r.Name()
+		}
+		repos[r.Name()] = repoName
+	}
+	return repos
+}
@@ -0,0 +1,58 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bzlmod
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestLoad(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "example")
+
+bazel_dep(name = "protobuf", version = "27.0")
+bazel_dep(name = "rules_proto", version = "6.0.0", repo_name = "my_rules_proto")
+bazel_dep(name = "rules_go", version = "0.48.0", repo_name = "io_bazel_rules_go")
+`,
+	}})
+	defer cleanup()
+	c := config.New()
+
+	f, err := Load(c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := RepoNames(f)
+	want := map[string]string{
+		"protobuf":    "protobuf",
+		"rules_proto": "my_rules_proto",
+		"rules_go":    "io_bazel_rules_go",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if _, err := Load(c, filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("without MODULE.bazel: got error %v; want a not-exist error", err)
+	}
+}
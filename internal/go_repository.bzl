@@ -152,6 +152,7 @@ def _go_repository_impl(ctx):
         gazelle = ctx.path(Label(_gazelle))
         cmd = [
             gazelle,
+            "-allow_output_base",
             "-go_repository_mode",
             "-go_prefix",
             ctx.attr.importpath,
@@ -4,20 +4,30 @@ GO_REPOSITORY_TOOLS_SRCS = [
 	"@bazel_gazelle//cmd:BUILD.bazel",
 	"@bazel_gazelle//cmd/autogazelle:BUILD.bazel",
 	"@bazel_gazelle//cmd/autogazelle:autogazelle.go",
+	"@bazel_gazelle//cmd/autogazelle:bep.go",
 	"@bazel_gazelle//cmd/autogazelle:client_unix.go",
+	"@bazel_gazelle//cmd/autogazelle:dirhash_unix.go",
 	"@bazel_gazelle//cmd/autogazelle:server_unix.go",
 	"@bazel_gazelle//cmd/fetch_repo:BUILD.bazel",
 	"@bazel_gazelle//cmd/fetch_repo:fetch_repo.go",
 	"@bazel_gazelle//cmd/fetch_repo:module.go",
 	"@bazel_gazelle//cmd/fetch_repo:vcs.go",
 	"@bazel_gazelle//cmd/gazelle:BUILD.bazel",
+	"@bazel_gazelle//cmd/gazelle:bzlmod.go",
 	"@bazel_gazelle//cmd/gazelle:diff.go",
 	"@bazel_gazelle//cmd/gazelle:fix-update.go",
 	"@bazel_gazelle//cmd/gazelle:fix.go",
 	"@bazel_gazelle//cmd/gazelle:gazelle.go",
+	"@bazel_gazelle//cmd/gazelle:interactive.go",
 	"@bazel_gazelle//cmd/gazelle:langs.go",
-	"@bazel_gazelle//cmd/gazelle:metaresolver.go",
+	"@bazel_gazelle//cmd/gazelle:macro_groups.go",
+	"@bazel_gazelle//cmd/gazelle:new.go",
+	"@bazel_gazelle//cmd/gazelle:output_base.go",
+	"@bazel_gazelle//cmd/gazelle:paths.go",
 	"@bazel_gazelle//cmd/gazelle:print.go",
+	"@bazel_gazelle//cmd/gazelle:progress.go",
+	"@bazel_gazelle//cmd/gazelle:snapshot.go",
+	"@bazel_gazelle//cmd/gazelle:toolchain.go",
 	"@bazel_gazelle//cmd/gazelle:update-repos.go",
 	"@bazel_gazelle//cmd/gazelle:version.go",
 	"@bazel_gazelle//cmd/generate_repo_config:BUILD.bazel",
@@ -27,9 +37,12 @@ GO_REPOSITORY_TOOLS_SRCS = [
 	"@bazel_gazelle//config:BUILD.bazel",
 	"@bazel_gazelle//config:config.go",
 	"@bazel_gazelle//config:constants.go",
+	"@bazel_gazelle//config:schema.go",
 	"@bazel_gazelle//flag:BUILD.bazel",
 	"@bazel_gazelle//flag:flag.go",
 	"@bazel_gazelle//internal:BUILD.bazel",
+	"@bazel_gazelle//internal/bzlmod:BUILD.bazel",
+	"@bazel_gazelle//internal/bzlmod:bzlmod.go",
 	"@bazel_gazelle//internal/gazellebinarytest:BUILD.bazel",
 	"@bazel_gazelle//internal/gazellebinarytest:xlang.go",
 	"@bazel_gazelle//internal/language:BUILD.bazel",
@@ -44,26 +57,45 @@ GO_REPOSITORY_TOOLS_SRCS = [
 	"@bazel_gazelle//label:label.go",
 	"@bazel_gazelle//language:BUILD.bazel",
 	"@bazel_gazelle//language/go:BUILD.bazel",
+	"@bazel_gazelle//language/go:auth.go",
 	"@bazel_gazelle//language/go:config.go",
 	"@bazel_gazelle//language/go:constants.go",
 	"@bazel_gazelle//language/go:dep.go",
 	"@bazel_gazelle//language/go:fileinfo.go",
+	"@bazel_gazelle//language/go:filelibs.go",
 	"@bazel_gazelle//language/go:fix.go",
+	"@bazel_gazelle//language/go/gen_known_modules:BUILD.bazel",
+	"@bazel_gazelle//language/go/gen_known_modules:gen_known_modules.go",
 	"@bazel_gazelle//language/go/gen_std_package_list:BUILD.bazel",
 	"@bazel_gazelle//language/go/gen_std_package_list:gen_std_package_list.go",
 	"@bazel_gazelle//language/go:generate.go",
 	"@bazel_gazelle//language/go:godep.go",
 	"@bazel_gazelle//language/go:kinds.go",
 	"@bazel_gazelle//language/go:known_go_imports.go",
+	"@bazel_gazelle//language/go:known_modules.go",
 	"@bazel_gazelle//language/go:known_proto_imports.go",
 	"@bazel_gazelle//language/go:lang.go",
 	"@bazel_gazelle//language/go:modules.go",
+	"@bazel_gazelle//language/go:offline.go",
 	"@bazel_gazelle//language/go:package.go",
+	"@bazel_gazelle//language/go:plugin.go",
+	"@bazel_gazelle//language/go:private.go",
+	"@bazel_gazelle//language/go:replace.go",
 	"@bazel_gazelle//language/go:resolve.go",
 	"@bazel_gazelle//language/go:std_package_list.go",
+	"@bazel_gazelle//language/go:sumdb.go",
+	"@bazel_gazelle//language/go:tags.go",
+	"@bazel_gazelle//language/go:testonly.go",
+	"@bazel_gazelle//language/go:unused.go",
 	"@bazel_gazelle//language/go:update.go",
+	"@bazel_gazelle//language/go:vendor.go",
+	"@bazel_gazelle//language/go:work.go",
 	"@bazel_gazelle//language:lang.go",
 	"@bazel_gazelle//language/proto:BUILD.bazel",
+	"@bazel_gazelle//language/proto:anchor.go",
+	"@bazel_gazelle//language/proto:binding.go",
+	"@bazel_gazelle//language/proto:buf.go",
+	"@bazel_gazelle//language/proto:bzlmod.go",
 	"@bazel_gazelle//language/proto:config.go",
 	"@bazel_gazelle//language/proto:constants.go",
 	"@bazel_gazelle//language/proto:fileinfo.go",
@@ -72,11 +104,15 @@ GO_REPOSITORY_TOOLS_SRCS = [
 	"@bazel_gazelle//language/proto/gen:gen_known_imports.go",
 	"@bazel_gazelle//language/proto/gen:update_proto_csv.go",
 	"@bazel_gazelle//language/proto:generate.go",
+	"@bazel_gazelle//language/proto:googleapis.go",
+	"@bazel_gazelle//language/proto:index.go",
 	"@bazel_gazelle//language/proto:kinds.go",
 	"@bazel_gazelle//language/proto:known_imports.go",
 	"@bazel_gazelle//language/proto:lang.go",
 	"@bazel_gazelle//language/proto:package.go",
 	"@bazel_gazelle//language/proto:resolve.go",
+	"@bazel_gazelle//language/proto:ruleattr.go",
+	"@bazel_gazelle//language/proto:unused.go",
 	"@bazel_gazelle//language:update.go",
 	"@bazel_gazelle//merger:BUILD.bazel",
 	"@bazel_gazelle//merger:fix.go",
@@ -84,25 +120,36 @@ GO_REPOSITORY_TOOLS_SRCS = [
 	"@bazel_gazelle//pathtools:BUILD.bazel",
 	"@bazel_gazelle//pathtools:path.go",
 	"@bazel_gazelle//repo:BUILD.bazel",
+	"@bazel_gazelle//repo:module.go",
 	"@bazel_gazelle//repo:remote.go",
 	"@bazel_gazelle//repo:repo.go",
 	"@bazel_gazelle//resolve:BUILD.bazel",
 	"@bazel_gazelle//resolve:config.go",
 	"@bazel_gazelle//resolve:index.go",
+	"@bazel_gazelle//resolve:stats.go",
 	"@bazel_gazelle//rule:BUILD.bazel",
 	"@bazel_gazelle//rule:directives.go",
 	"@bazel_gazelle//rule:expr.go",
+	"@bazel_gazelle//rule:glob.go",
 	"@bazel_gazelle//rule:merge.go",
 	"@bazel_gazelle//rule:platform.go",
 	"@bazel_gazelle//rule:platform_strings.go",
+	"@bazel_gazelle//rule:rename.go",
 	"@bazel_gazelle//rule:rule.go",
 	"@bazel_gazelle//rule:sort_labels.go",
 	"@bazel_gazelle//rule:types.go",
 	"@bazel_gazelle//rule:value.go",
+	"@bazel_gazelle//runner:BUILD.bazel",
+	"@bazel_gazelle//runner:kinds.go",
+	"@bazel_gazelle//runner:metaresolver.go",
+	"@bazel_gazelle//runner:runner.go",
 	"@bazel_gazelle//testtools:BUILD.bazel",
 	"@bazel_gazelle//testtools:config.go",
 	"@bazel_gazelle//testtools:files.go",
 	"@bazel_gazelle//walk:BUILD.bazel",
+	"@bazel_gazelle//walk:cache.go",
 	"@bazel_gazelle//walk:config.go",
+	"@bazel_gazelle//walk:fs.go",
+	"@bazel_gazelle//walk:gitignore.go",
 	"@bazel_gazelle//walk:walk.go",
 ]
@@ -19,10 +19,13 @@ import (
 	"strings"
 )
 
-const workspaceFile = "WORKSPACE"
+// workspaceFiles are the names of files that mark the root of a workspace.
+// A workspace that only uses Bzlmod may have MODULE.bazel without WORKSPACE.
+var workspaceFiles = []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel"}
 
-// Find searches from the given dir and up for the WORKSPACE file
-// returning the directory containing it, or an error if none found in the tree.
+// Find searches from the given dir and up for a WORKSPACE, WORKSPACE.bazel,
+// or MODULE.bazel file, returning the directory containing it, or an error
+// if none found in the tree.
 func Find(dir string) (string, error) {
 	dir, err := filepath.Abs(dir)
 	if err != nil {
@@ -30,12 +33,14 @@ func Find(dir string) (string, error) {
 	}
 
 	for {
-		_, err = os.Stat(filepath.Join(dir, workspaceFile))
-		if err == nil {
-			return dir, nil
-		}
-		if !os.IsNotExist(err) {
-			return "", err
+		for _, name := range workspaceFiles {
+			_, err = os.Stat(filepath.Join(dir, name))
+			if err == nil {
+				return dir, nil
+			}
+			if !os.IsNotExist(err) {
+				return "", err
+			}
 		}
 		if strings.HasSuffix(dir, string(os.PathSeparator)) { // stop at root dir
 			return "", os.ErrNotExist
@@ -40,17 +40,25 @@ func TestFind(t *testing.T) {
 	if err := os.MkdirAll(filepath.Join(tmp, "base", "sub"), 0755); err != nil {
 		t.Fatal(err)
 	}
-	if err := ioutil.WriteFile(filepath.Join(tmp, "base", workspaceFile), nil, 0755); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(tmp, "base", "WORKSPACE"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, "module", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "module", "MODULE.bazel"), nil, 0755); err != nil {
 		t.Fatal(err)
 	}
 
 	tmpBase := filepath.Join(tmp, "base")
+	tmpModule := filepath.Join(tmp, "module")
 	for _, tc := range []struct {
 		dir, want string // want == "" means an error is expected
 	}{
 		{tmp, ""},
 		{tmpBase, tmpBase},
 		{filepath.Join(tmpBase, "sub"), tmpBase},
+		{filepath.Join(tmpModule, "sub"), tmpModule},
 	} {
 		t.Run(tc.dir, func(t *testing.T) {
 			if got, err := Find(tc.dir); err != nil && tc.want != "" {
@@ -0,0 +1,280 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// authenticate adds credentials to an HTTPS request to a module proxy or
+// checksum database, following the go command's GOAUTH environment
+// variable. GOAUTH is a semicolon-separated list of methods:
+//
+//   - "netrc" adds basic authentication credentials for the request's host
+//     from the .netrc file named by NETRC, or from $HOME/.netrc. This is the
+//     default when GOAUTH is not set.
+//   - "off" disables authentication.
+//   - Any other method, except "git", is a command that prints credentials
+//     for the URL passed as its last argument, as described in
+//     "go help goauth". The headers it prints are added to requests for
+//     the URL prefixes it prints.
+//
+// Credentials are never sent over plain HTTP. When several methods provide
+// the same header, the first one wins.
+func authenticate(req *http.Request) {
+	if req.URL.Scheme != "https" {
+		return
+	}
+	for _, method := range strings.Split(goAuthFromEnv(), ";") {
+		method = strings.TrimSpace(method)
+		switch {
+		case method == "" || method == "off":
+			continue
+		case method == "netrc":
+			if req.Header.Get("Authorization") != "" {
+				continue
+			}
+			if login, password, ok := netrcCredentials(req.URL.Hostname()); ok {
+				req.SetBasicAuth(login, password)
+			}
+		case method == "git" || strings.HasPrefix(method, "git "):
+			authWarnOnce.Do(func() {
+				log.Printf("GOAUTH method %q is not supported by Gazelle; use netrc or a credential command instead", method)
+			})
+		default:
+			for key, values := range authCommandHeaders(method, req.URL.String()) {
+				if req.Header.Get(key) == "" {
+					req.Header[key] = values
+				}
+			}
+		}
+	}
+}
+
+// goAuthFromEnv returns the value of GOAUTH, or "netrc" if it's not set.
+func goAuthFromEnv() string {
+	if goAuth, ok := os.LookupEnv("GOAUTH"); ok {
+		return goAuth
+	}
+	return "netrc"
+}
+
+var authWarnOnce sync.Once
+
+// netrcLine is a machine entry in a .netrc file.
+type netrcLine struct {
+	machine, login, password string
+}
+
+var (
+	netrcOnce  sync.Once
+	netrcLines []netrcLine
+)
+
+// netrcCredentials returns the login and password for host from the .netrc
+// file. The file is read once.
+func netrcCredentials(host string) (login, password string, ok bool) {
+	netrcOnce.Do(func() {
+		path := netrcPath()
+		if path == "" {
+			return
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("reading %s: %v", path, err)
+			}
+			return
+		}
+		netrcLines = parseNetrc(data)
+	})
+	for _, l := range netrcLines {
+		if l.machine == host {
+			return l.login, l.password, true
+		}
+	}
+	return "", "", false
+}
+
+// netrcPath returns the path of the .netrc file named by NETRC, or the
+// default .netrc file in the user's home directory.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name)
+}
+
+// parseNetrc parses the contents of a .netrc file. Only machine entries
+// with both a login and a password are returned. Macro definitions and
+// the default entry, which must come last, are ignored.
+func parseNetrc(data []byte) []netrcLine {
+	var lines []netrcLine
+	var l netrcLine
+	flush := func() {
+		if l.machine != "" && l.login != "" && l.password != "" {
+			lines = append(lines, l)
+		}
+		l = netrcLine{}
+	}
+	inMacro := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if inMacro {
+			// A macro definition ends with an empty line.
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		f := strings.Fields(line)
+		for i := 0; i < len(f); i++ {
+			switch f[i] {
+			case "default":
+				flush()
+				return lines
+			case "macdef":
+				inMacro = true
+				i = len(f)
+			case "machine", "login", "password", "account":
+				if i+1 >= len(f) {
+					continue
+				}
+				switch f[i] {
+				case "machine":
+					flush()
+					l.machine = f[i+1]
+				case "login":
+					l.login = f[i+1]
+				case "password":
+					l.password = f[i+1]
+				}
+				i++
+			}
+		}
+	}
+	flush()
+	return lines
+}
+
+// authCredentialSet is a set of headers a GOAUTH command provides for
+// requests to URLs starting with any of its prefixes.
+type authCredentialSet struct {
+	prefixes []string
+	header   http.Header
+}
+
+var (
+	authCommandMu    sync.Mutex
+	authCommandCache = make(map[string][]authCredentialSet)
+)
+
+// authCommandHeaders returns the headers the GOAUTH command provides for
+// url. Results are cached by URL prefix, so the command is only run again
+// for URLs none of its earlier results cover.
+func authCommandHeaders(command, url string) http.Header {
+	authCommandMu.Lock()
+	defer authCommandMu.Unlock()
+	if h := matchCredentialSets(authCommandCache[command], url); h != nil {
+		return h
+	}
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], append(args[1:], url)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("running GOAUTH command %q: %v", command, err)
+		return nil
+	}
+	sets, err := parseAuthCommandOutput(out)
+	if err != nil {
+		log.Printf("GOAUTH command %q: %v", command, err)
+		return nil
+	}
+	authCommandCache[command] = append(authCommandCache[command], sets...)
+	return matchCredentialSets(sets, url)
+}
+
+// matchCredentialSets returns the headers of the credential set with the
+// longest prefix of url, or nil if there is none.
+func matchCredentialSets(sets []authCredentialSet, url string) http.Header {
+	var best http.Header
+	bestLen := -1
+	for _, s := range sets {
+		for _, p := range s.prefixes {
+			if strings.HasPrefix(url, p) && len(p) > bestLen {
+				best, bestLen = s.header, len(p)
+			}
+		}
+	}
+	return best
+}
+
+// parseAuthCommandOutput parses the output of a GOAUTH command. The output
+// is a list of credential sets. Each set is one or more lines with URL
+// prefixes, a blank line, one or more lines with HTTP headers, and another
+// blank line.
+func parseAuthCommandOutput(data []byte) ([]authCredentialSet, error) {
+	var sets []authCredentialSet
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		var prefixes []string
+		for {
+			line, err := r.ReadLine()
+			if err != nil {
+				if len(prefixes) == 0 {
+					return sets, nil
+				}
+				return nil, fmt.Errorf("missing headers after URL prefixes %s", strings.Join(prefixes, ", "))
+			}
+			if line == "" {
+				break
+			}
+			if !strings.HasPrefix(line, "https://") {
+				return nil, fmt.Errorf("URL prefix %q does not start with https://", line)
+			}
+			prefixes = append(prefixes, line)
+		}
+		if len(prefixes) == 0 {
+			return nil, fmt.Errorf("credential set has no URL prefixes")
+		}
+		header, err := r.ReadMIMEHeader()
+		if err != nil && len(header) == 0 {
+			return nil, fmt.Errorf("reading headers for %s: %v", strings.Join(prefixes, ", "), err)
+		}
+		sets = append(sets, authCredentialSet{prefixes: prefixes, header: http.Header(header)})
+		if err != nil {
+			return sets, nil
+		}
+	}
+}
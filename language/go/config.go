@@ -20,15 +20,18 @@ import (
 	"fmt"
 	"go/build"
 	"log"
-	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
@@ -38,6 +41,11 @@ type goConfig struct {
 	// -build_tags or # gazelle:build_tags. Some tags, like gc, are always on.
 	genericTags map[string]bool
 
+	// dirTags is the set of tags in genericTags that were turned on with
+	// # gazelle:go_build_tags. Unlike build_tags, a go_build_tags directive
+	// replaces the tags set by go_build_tags in parent directories.
+	dirTags map[string]bool
+
 	// prefix is a prefix of an import path, used to generate importpath
 	// attributes. Set with -go_prefix or # gazelle:prefix.
 	prefix string
@@ -74,6 +82,21 @@ type goConfig struct {
 	// goGrpcCompilersSet indicates whether goGrpcCompiler was set explicitly.
 	goGrpcCompilersSet bool
 
+	// grpcKind is the kind of rule generated for protos with services:
+	// go_proto_library with goGrpcCompilers, or go_grpc_library, which sets
+	// its own compilers. Set with # gazelle:go_grpc_kind.
+	grpcKind string
+
+	// grpcGateway indicates whether the grpc-gateway compiler should be added
+	// to go_proto_library rules for protos with google.api.http annotations.
+	// Set with # gazelle:go_grpc_gateway.
+	grpcGateway bool
+
+	// grpcGatewayOpenAPI indicates whether protoc_gen_openapiv2 rules should
+	// be generated for protos with google.api.http annotations. Set with
+	// # gazelle:go_grpc_gateway_openapi.
+	grpcGatewayOpenAPI bool
+
 	// goRepositoryMode is true if Gazelle was invoked by a go_repository rule.
 	// In this mode, we won't go out to the network to resolve external deps.
 	goRepositoryMode bool
@@ -83,6 +106,11 @@ type goConfig struct {
 	// visible to
 	goVisibility []string
 
+	// defaultVisibility is a list of labels set with go_default_visibility.
+	// When non-nil, it's used as the visibility of generated rules instead of
+	// the computed visibility, even in packages with a default_visibility.
+	defaultVisibility []string
+
 	// moduleMode is true if the current directory is intended to be built
 	// as part of a module. Minimal module compatibility won't be supported
 	// if this is true in the root directory. External dependencies may be
@@ -94,12 +122,179 @@ type goConfig struct {
 	// in internal packages.
 	submodules []moduleRepo
 
+	// localModules is a list of modules provided by local_repository rules,
+	// usually generated by update-repos for go.mod replace directives that
+	// point to local directories. Imports from these modules are resolved
+	// to the local repositories.
+	localModules []moduleRepo
+
+	// moduleFallback is true if imports that can't be resolved otherwise
+	// should be looked up as modules with the go command. Set with
+	// -go_module_fallback.
+	moduleFallback bool
+
+	// checkUnusedDeps is true if deps marked with # keep that aren't needed
+	// for any import should be reported, or removed by the fix command. Set
+	// with -check_unused_deps.
+	checkUnusedDeps bool
+
+	// suggestedModules records modules found with moduleFallback that
+	// have already been reported, so each is only reported once. It is
+	// shared by all copies of the configuration.
+	suggestedModules *moduleSuggestions
+
+	// linkedRels maps directories reached through followed symbolic links to
+	// the real directories they point to. Set from goLang.linkedRels in the
+	// repository root and shared by all copies of the configuration.
+	linkedRels map[string]string
+
+	// workModules maps the directories of modules listed in a go.work file
+	// in the repository root to their module paths. Each module's path is
+	// used as the prefix in its directory unless a prefix is set explicitly
+	// there.
+	workModules map[string]string
+
+	// vendorPackages maps the import path of each package listed in
+	// vendor/modules.txt in the repository root to the path of the module
+	// that provides it. With -external=vendored, imports are only resolved
+	// to packages in vendor if they're listed here. nil if there is no
+	// modules.txt file.
+	vendorPackages map[string]string
+
+	// importMappings is a list of import path patterns mapped to labels. Set
+	// with # gazelle:go_import_map. Mappings are checked before the index and
+	// external resolution. Later mappings take precedence over earlier ones.
+	importMappings []goImportMapping
+
+	// modeAttrs maps go_binary and go_test to mode attributes (race, msan,
+	// pure, static, gotags) that should be set on generated rules of that
+	// kind. Set with # gazelle:go_mode.
+	modeAttrs map[string]map[string]string
+
+	// libNameTemplate and testNameTemplate are templates for the names of
+	// generated go_library and go_test rules. Set with
+	// # gazelle:go_naming_template. When empty, go_default_library and
+	// go_default_test are used. See expandNameTemplate.
+	libNameTemplate, testNameTemplate string
+
+	// testMode is testModeSplit if external tests should be generated in a
+	// separate go_test rule, or "" if they're combined with internal tests.
+	// testDefaults maps attributes like size and timeout to values that
+	// should be set on generated go_test rules. Both are set with
+	// # gazelle:go_test.
+	testMode     string
+	testDefaults map[string]string
+
+	// fuzz is true if a go_test rule should be generated for each native fuzz
+	// test. Set with # gazelle:go_fuzz.
+	fuzz bool
+
+	// extraDeps maps kinds of generated rules (go_library, go_binary,
+	// go_test, go_proto_library) to labels added to their deps, in addition
+	// to resolved dependencies. Set with # gazelle:go_extra_deps.
+	extraDeps map[string][]label.Label
+
+	// qualifiedLabels is true if labels in deps and cdeps of generated rules
+	// should name the repository they're in, like @repo//pkg:name, rather
+	// than //pkg:name or :name. Set with # gazelle:go_label_style.
+	qualifiedLabels bool
+
+	// fileLibraries is true if a private go_library should be generated
+	// for each library source file, in addition to the package's library.
+	// Set with # gazelle:go_library_granularity.
+	fileLibraries bool
+
+	// testdataMode controls how go_test rules depend on files in a testdata
+	// directory. It's one of the testdata* constants. "" means testdataGlob.
+	// Set with # gazelle:go_testdata.
+	testdataMode string
+
+	// testAttrs is a list of env, args, and rundir attributes to set on
+	// go_test rules in packages matching a pattern. Set with
+	// # gazelle:go_test_attrs. Later entries take precedence.
+	testAttrs []goTestAttrs
+
+	// packageAttrs is a list of testonly and tags attributes to set on rules
+	// generated in packages matching a pattern. Set with
+	// # gazelle:go_package_attrs.
+	packageAttrs []goPackageAttrs
+
+	// ruleTags is a list of tags added to every rule generated in this
+	// directory and its subdirectories. Set with # gazelle:go_rule_tags.
+	ruleTags []string
+
+	// crossPlatforms is a list of labels of platforms for which a
+	// go_cross_binary is generated next to each go_binary. Set with
+	// # gazelle:go_cross_platforms.
+	crossPlatforms []string
+
+	// cgoSetting is the label of a config_setting that matches when cgo is
+	// enabled. When it's set, strings from files whose build constraints
+	// only depend on cgo are written in a select on it. Set with
+	// # gazelle:go_cgo_setting.
+	cgoSetting string
+
 	// buildExternalAttr, buildFileNamesAttr, buildFileGenerationAttr,
 	// buildTagsAttr, buildFileProtoModeAttr, and buildExtraArgsAttr are
 	// attributes for go_repository rules, set on the command line.
 	buildExternalAttr, buildFileNamesAttr, buildFileGenerationAttr, buildTagsAttr, buildFileProtoModeAttr, buildExtraArgsAttr string
+
+	// wasm controls which WebAssembly platforms sources are included for.
+	// By default, only js/wasm is. "true" adds wasip1/wasm, which needs a
+	// newer rules_go, and "false" leaves out both. Set with -go_wasm or
+	// # gazelle:go_wasm.
+	wasm string
+
+	// platforms is the set of platforms sources are included for, according
+	// to wasm.
+	platforms *goPlatforms
+
+	// protocOutput controls how checked-in .go files generated by protoc
+	// are handled. Set with # gazelle:go_protoc_output. See the
+	// protocOutput* constants.
+	protocOutput string
+
+	// importConcurrency is the maximum number of repository lookups that may
+	// run at the same time when importing repositories from a dep or godep
+	// lock file. Set with -import_concurrency.
+	importConcurrency int
+
+	// sumDBLookup is true if sums that are missing from go.sum should be
+	// looked up in the checksum database instead of downloading modules.
+	// Set with -sumdb_lookup.
+	sumDBLookup bool
+
+	// offline is true if update-repos must not use the network. Versions and
+	// sums are read from go.mod, go.sum, and the module cache. Set with
+	// -offline.
+	offline bool
+
+	// majorVersionNaming controls how major version suffixes like /v3 in
+	// module paths affect the names of go_repository rules. It's one of the
+	// majorVersion* constants. "" means majorVersionSuffix. Set with
+	// -major_version_naming or # gazelle:go_major_version_naming.
+	majorVersionNaming string
+
+	// binaryNaming controls how go_binary rules are named. It's one of the
+	// binaryNaming* constants. "" means binaryNamingDirname. Set with
+	// -go_binary_naming or # gazelle:go_binary_naming.
+	binaryNaming string
+
+	// inferTestonly indicates that go_library rules for packages that only
+	// tests use should be marked testonly. Set with
+	// # gazelle:go_infer_testonly.
+	inferTestonly bool
+
+	// pluginData lists labels of plugins and shared objects the package in
+	// this directory loads at run time. They're added to the data of its
+	// go_library. Set with # gazelle:go_plugin_data. Not inherited.
+	pluginData []label.Label
 }
 
+// defaultImportConcurrency is the default value of the -import_concurrency
+// flag.
+const defaultImportConcurrency = 8
+
 var (
 	defaultGoProtoCompilers = []string{"@io_bazel_rules_go//proto:go_proto"}
 	defaultGoGrpcCompilers  = []string{"@io_bazel_rules_go//proto:go_grpc"}
@@ -109,6 +304,9 @@ func newGoConfig() *goConfig {
 	gc := &goConfig{
 		goProtoCompilers: defaultGoProtoCompilers,
 		goGrpcCompilers:  defaultGoGrpcCompilers,
+		modeAttrs:        make(map[string]map[string]string),
+		suggestedModules: &moduleSuggestions{seen: make(map[string]bool)},
+		platforms:        newGoPlatforms(""),
 	}
 	gc.preprocessTags()
 	return gc
@@ -124,9 +322,35 @@ func (gc *goConfig) clone() *goConfig {
 	for k, v := range gc.genericTags {
 		gcCopy.genericTags[k] = v
 	}
+	gcCopy.dirTags = make(map[string]bool)
+	for k, v := range gc.dirTags {
+		gcCopy.dirTags[k] = v
+	}
 	gcCopy.goProtoCompilers = gc.goProtoCompilers[:len(gc.goProtoCompilers):len(gc.goProtoCompilers)]
 	gcCopy.goGrpcCompilers = gc.goGrpcCompilers[:len(gc.goGrpcCompilers):len(gc.goGrpcCompilers)]
 	gcCopy.submodules = gc.submodules[:len(gc.submodules):len(gc.submodules)]
+	gcCopy.localModules = gc.localModules[:len(gc.localModules):len(gc.localModules)]
+	gcCopy.importMappings = gc.importMappings[:len(gc.importMappings):len(gc.importMappings)]
+	gcCopy.defaultVisibility = gc.defaultVisibility[:len(gc.defaultVisibility):len(gc.defaultVisibility)]
+	gcCopy.testAttrs = gc.testAttrs[:len(gc.testAttrs):len(gc.testAttrs)]
+	gcCopy.packageAttrs = gc.packageAttrs[:len(gc.packageAttrs):len(gc.packageAttrs)]
+	gcCopy.ruleTags = gc.ruleTags[:len(gc.ruleTags):len(gc.ruleTags)]
+	gcCopy.crossPlatforms = gc.crossPlatforms[:len(gc.crossPlatforms):len(gc.crossPlatforms)]
+	gcCopy.extraDeps = make(map[string][]label.Label)
+	for kind, deps := range gc.extraDeps {
+		gcCopy.extraDeps[kind] = deps[:len(deps):len(deps)]
+	}
+	gcCopy.testDefaults = make(map[string]string)
+	for k, v := range gc.testDefaults {
+		gcCopy.testDefaults[k] = v
+	}
+	gcCopy.modeAttrs = make(map[string]map[string]string)
+	for kind, attrs := range gc.modeAttrs {
+		gcCopy.modeAttrs[kind] = make(map[string]string)
+		for k, v := range attrs {
+			gcCopy.modeAttrs[kind][k] = v
+		}
+	}
 	return &gcCopy
 }
 
@@ -151,6 +375,34 @@ func (gc *goConfig) setBuildTags(tags string) error {
 			return fmt.Errorf("build tags can't be negated: %s", t)
 		}
 		gc.genericTags[t] = true
+		delete(gc.dirTags, t)
+	}
+	return nil
+}
+
+// setDirTags replaces the tags turned on by go_build_tags directives in
+// parent directories with tags, a comma separated list. Tags that are on
+// for other reasons, like -build_tags, stay on. An empty list turns off
+// the inherited tags.
+func (gc *goConfig) setDirTags(tags string) error {
+	var newTags []string
+	if tags != "" {
+		newTags = strings.Split(tags, ",")
+	}
+	for _, t := range newTags {
+		if t == "" || strings.HasPrefix(t, "!") || strings.ContainsAny(t, " \t") {
+			return fmt.Errorf("invalid build tag: %q", t)
+		}
+	}
+	for t := range gc.dirTags {
+		delete(gc.genericTags, t)
+	}
+	gc.dirTags = make(map[string]bool)
+	for _, t := range newTags {
+		if !gc.genericTags[t] {
+			gc.genericTags[t] = true
+			gc.dirTags[t] = true
+		}
 	}
 	return nil
 }
@@ -163,6 +415,33 @@ func getProtoMode(c *config.Config) proto.Mode {
 	}
 }
 
+// useVendoredWellKnownTypes returns whether imports of Well Known Types
+// should be resolved to vendored copies in the repository.
+func useVendoredWellKnownTypes(c *config.Config) bool {
+	pc := proto.GetProtoConfig(c)
+	return pc != nil && pc.UseVendoredWellKnownTypes()
+}
+
+// bufDepsRepo returns the name of the repository providing .proto files
+// from Buf dependencies, if there are any.
+func bufDepsRepo(c *config.Config) (string, bool) {
+	pc := proto.GetProtoConfig(c)
+	if pc == nil {
+		return "", false
+	}
+	return pc.BufDepsRepo()
+}
+
+// protoGoImportPath returns the Go import path set with the
+// proto_go_package directive for the imported .proto file imp, if any.
+func protoGoImportPath(c *config.Config, imp string) (string, bool) {
+	pc := proto.GetProtoConfig(c)
+	if pc == nil {
+		return "", false
+	}
+	return pc.GoImportPathOverride(imp)
+}
+
 // dependencyMode determines how imports of packages outside of the prefix
 // are resolved.
 type dependencyMode int
@@ -222,16 +501,168 @@ type moduleRepo struct {
 	repoName, modulePath string
 }
 
+// goImportMapping maps import paths matching a pattern to a label. It is
+// set with the go_import_map directive, for example:
+//
+//	# gazelle:go_import_map github.com/corp/* @corp_go//...
+//
+// A pattern ending with "/*" matches the prefix before "/*" and any import
+// path below it. Other patterns must match the whole import path. Wildcards
+// don't cross a "/"; see matchPathPattern. A label ending with "..." is a
+// template: the part of the import path after the pattern's prefix is
+// appended to the label's package. The target is named by go_naming_template
+// where the mapping is used, or go_default_library, unless a name follows
+// the "...", as in "@corp_go//...:{dirname}".
+type goImportMapping struct {
+	elems  []string
+	prefix bool
+	label  label.Label
+
+	// rest is true if the label is a template. nameTemplate is the name
+	// written after "...", if any, which may contain "{dirname}".
+	rest         bool
+	nameTemplate string
+}
+
+func parseGoImportMapping(value string) (goImportMapping, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return goImportMapping{}, fmt.Errorf("go_import_map: want pattern and label; got %q", value)
+	}
+	pattern, lbl := fields[0], fields[1]
+	var m goImportMapping
+	if strings.HasSuffix(pattern, "/*") {
+		pattern = strings.TrimSuffix(pattern, "/*")
+		m.prefix = true
+	}
+	m.elems = strings.Split(pattern, "/")
+	for _, elem := range m.elems {
+		if _, err := path.Match(elem, ""); err != nil || elem == "..." {
+			return goImportMapping{}, fmt.Errorf("go_import_map: invalid pattern %q", fields[0])
+		}
+	}
+	if i := strings.Index(lbl, "..."); i >= 0 {
+		if !m.prefix {
+			return goImportMapping{}, fmt.Errorf("go_import_map: label %q ending with \"...\" requires a pattern ending with \"/*\"", lbl)
+		}
+		name := lbl[i+len("..."):]
+		if name != "" && !strings.HasPrefix(name, ":") {
+			return goImportMapping{}, fmt.Errorf("go_import_map: label %q must end with \"...\" or \"...:name\"", lbl)
+		}
+		m.rest = true
+		m.nameTemplate = strings.TrimPrefix(name, ":")
+		lbl = lbl[:i]
+		if !strings.HasSuffix(lbl, "//") {
+			lbl = strings.TrimSuffix(lbl, "/")
+		}
+		// Parse with a placeholder name; the name is chosen by match.
+		lbl += ":_"
+	}
+	l, err := label.Parse(lbl)
+	if err != nil {
+		return goImportMapping{}, fmt.Errorf("go_import_map: %v", err)
+	}
+	if m.rest {
+		l.Name = ""
+	}
+	m.label = l
+	return m, nil
+}
+
+// match returns the label an import path is mapped to, if it matches the
+// mapping's pattern. libNameTemplate is the go_naming_template for
+// go_library where the mapping is used, which names targets of template
+// labels without an explicit name.
+func (m goImportMapping) match(imp, libNameTemplate string) (label.Label, bool) {
+	if !matchPathPattern(m.elems, imp, m.prefix) {
+		return label.NoLabel, false
+	}
+	if !m.rest {
+		return m.label, true
+	}
+	rest := strings.Join(strings.Split(imp, "/")[len(m.elems):], "/")
+	tmpl := m.nameTemplate
+	if tmpl == "" {
+		tmpl = libNameTemplate
+	}
+	name := defaultLibName
+	if tmpl != "" {
+		name = expandNameTemplate(tmpl, path.Base(imp))
+	}
+	return label.New(m.label.Repo, path.Join(m.label.Pkg, rest), name), true
+}
+
+// findImportMapping returns the label for the most recently declared mapping
+// that matches imp.
+func (gc *goConfig) findImportMapping(imp string) (label.Label, bool) {
+	for i := len(gc.importMappings) - 1; i >= 0; i-- {
+		if l, ok := gc.importMappings[i].match(imp, gc.libNameTemplate); ok {
+			return l, true
+		}
+	}
+	return label.NoLabel, false
+}
+
 var validBuildExternalAttr = []string{"external", "vendored"}
+
+// Values for # gazelle:go_protoc_output. When the directive is not set,
+// .pb.go files are excluded from go_library only if a .proto file with the
+// same stem is in the same directory.
+const (
+	// protocOutputExclude excludes all checked-in protoc output from
+	// generated rules.
+	protocOutputExclude = "exclude"
+
+	// protocOutputInclude includes checked-in protoc output in go_library
+	// srcs. No go_proto_library rules are generated for proto_library rules
+	// in the same directory, since they would duplicate the library.
+	protocOutputInclude = "include"
+
+	// protocOutputProto excludes checked-in protoc output in directories
+	// with proto_library rules, so go_library embeds the generated
+	// go_proto_library and dependencies resolve to the proto rules.
+	protocOutputProto = "proto"
+
+	// protocOutputBoth includes checked-in protoc output in go_library srcs
+	// and also generates go_proto_library rules, which go_library doesn't
+	// embed. Go imports resolve to the go_library.
+	protocOutputBoth = "both"
+)
+
 var validBuildFileGenerationAttr = []string{"auto", "on", "off"}
 var validBuildFileProtoModeAttr = []string{"default", "legacy", "disable", "disable_global", "package"}
 
 func (*goLang) KnownDirectives() []string {
 	return []string{
 		"build_tags",
+		"go_binary_naming",
+		"go_build_tags",
+		"go_cgo_setting",
+		"go_cross_platforms",
+		"go_default_visibility",
+		"go_extra_deps",
+		"go_fuzz",
 		"go_grpc_compilers",
+		"go_grpc_gateway",
+		"go_grpc_gateway_openapi",
+		"go_grpc_kind",
+		"go_import_map",
+		"go_infer_testonly",
+		"go_label_style",
+		"go_mode",
+		"go_naming_template",
+		"go_plugin_data",
 		"go_proto_compilers",
+		"go_protoc_output",
+		"go_rule_tags",
+		"go_test",
+		"go_test_attrs",
+		"go_package_attrs",
+		"go_library_granularity",
+		"go_major_version_naming",
+		"go_testdata",
 		"go_visibility",
+		"go_wasm",
 		"importmap_prefix",
 		"prefix",
 	}
@@ -271,6 +702,25 @@ func (*goLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 			"go_repository_module_mode",
 			false,
 			"set when gazelle is invoked by go_repository in module mode")
+		fs.BoolVar(
+			&gc.moduleFallback,
+			"go_module_fallback",
+			false,
+			"when an external import can't be resolved, look up the module that provides it\n\twith the go command and suggest a go_repository rule for it")
+		fs.Var(&gzflag.AllowedStringFlag{Value: &gc.majorVersionNaming, Allowed: validMajorVersionNaming},
+			"major_version_naming",
+			"suffix: name go_repository rules for modules like example.com/m/v3 com_example_m_v3\n\tfold: drop the major version suffix from names unless that would cause a collision\n\terror: drop the major version suffix from names, and report collisions as errors")
+		fs.BoolVar(
+			&gc.checkUnusedDeps,
+			"check_unused_deps",
+			false,
+			"report deps of Go rules marked with # keep that no source file imports; the fix command removes them")
+		fs.Var(&gzflag.AllowedStringFlag{Value: &gc.binaryNaming, Allowed: validBinaryNaming},
+			"go_binary_naming",
+			"dirname: name go_binary rules after their directory\n\tcmd: name go_binary rules in cmd/<name> and its subdirectories <name>")
+		fs.Var(&gzflag.AllowedStringFlag{Value: &gc.wasm, Allowed: []string{"true", "false"}},
+			"go_wasm",
+			"true: include sources for js/wasm and wasip1/wasm\n\tfalse: include sources for no WebAssembly platforms\n\tIf unset, only js/wasm is included.")
 
 	case "update-repos":
 		fs.Var(&gzflag.AllowedStringFlag{Value: &gc.buildExternalAttr, Allowed: validBuildExternalAttr},
@@ -294,6 +744,21 @@ func (*goLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 			"build_tags",
 			"",
 			"Sets the build_tags attribute for the generated go_repository rule(s).")
+		fs.IntVar(&gc.importConcurrency,
+			"import_concurrency",
+			defaultImportConcurrency,
+			"maximum number of repositories looked up at the same time when importing from a dep or godep lock file")
+		fs.BoolVar(&gc.sumDBLookup,
+			"sumdb_lookup",
+			false,
+			"when true, sums missing from go.sum are looked up in the checksum database instead of downloading modules")
+		fs.BoolVar(&gc.offline,
+			"offline",
+			false,
+			"when true, versions and sums are read only from go.mod, go.sum, and the module cache, and the network is never used")
+		fs.Var(&gzflag.AllowedStringFlag{Value: &gc.majorVersionNaming, Allowed: validMajorVersionNaming},
+			"major_version_naming",
+			"suffix: name go_repository rules for modules like example.com/m/v3 com_example_m_v3\n\tfold: drop the major version suffix from names unless that would cause a collision\n\terror: drop the major version suffix from names, and report collisions as errors")
 	}
 	c.Exts[goName] = gc
 }
@@ -307,6 +772,10 @@ func (*goLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 	if pc := proto.GetProtoConfig(c); pc != nil {
 		pc.GoPrefix = gc.prefix
 	}
+	if gc.offline && gc.sumDBLookup {
+		return fmt.Errorf("-sumdb_lookup can't be used with -offline")
+	}
+	gc.platforms = newGoPlatforms(gc.wasm)
 
 	// List modules that may refer to internal packages in this module.
 	for _, r := range c.Repos {
@@ -323,11 +792,12 @@ func (*goLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 		}
 		gc.submodules = append(gc.submodules, m)
 	}
+	gc.localModules = readLocalModules(walk.GetFS(c), c.RepoRoot, c.Repos)
 
 	return nil
 }
 
-func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
+func (gl *goLang) Configure(c *config.Config, rel string, f *rule.File) {
 	var gc *goConfig
 	if raw, ok := c.Exts[goName]; !ok {
 		gc = newGoConfig()
@@ -335,14 +805,39 @@ func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
 		gc = raw.(*goConfig).clone()
 	}
 	c.Exts[goName] = gc
+	gc.pluginData = nil
 
 	if !gc.moduleMode {
-		st, err := os.Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(rel), "go.mod"))
+		st, err := walk.GetFS(c).Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(rel), "go.mod"))
 		if err == nil && !st.IsDir() {
 			gc.moduleMode = true
 		}
 	}
 
+	if rel == "" {
+		workModules, err := readWorkModules(walk.GetFS(c), c.RepoRoot)
+		if err != nil {
+			log.Print(err)
+		}
+		gc.workModules = workModules
+
+		vendorPackages, err := readVendorModules(walk.GetFS(c), c.RepoRoot)
+		if err != nil {
+			log.Print(err)
+		}
+		gc.vendorPackages = vendorPackages
+		gc.linkedRels = gl.linkedRels
+	}
+	if modulePath, ok := gc.workModules[rel]; ok && (rel != "" || !gc.prefixSet) {
+		if err := checkPrefix(modulePath); err != nil {
+			log.Print(err)
+		} else {
+			gc.prefix = modulePath
+			gc.prefixSet = true
+			gc.prefixRel = rel
+		}
+	}
+
 	if path.Base(rel) == "vendor" {
 		gc.importMapPrefix = InferImportPath(c, rel)
 		gc.importMapPrefixRel = rel
@@ -360,6 +855,7 @@ func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
 			gc.prefixSet = true
 			gc.prefixRel = rel
 		}
+		defaultVisibilitySet := false
 		for _, d := range f.Directives {
 			switch d.Key {
 			case "build_tags":
@@ -370,6 +866,40 @@ func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
 				gc.preprocessTags()
 				gc.setBuildTags(d.Value)
 
+			case "go_build_tags":
+				if err := gc.setDirTags(strings.TrimSpace(d.Value)); err != nil {
+					log.Printf("%s: invalid go_build_tags directive: %v", f.Path, err)
+				}
+
+			case "go_default_visibility":
+				// Directives in a file replace any inherited visibility. An empty
+				// value restores the computed visibility.
+				if !defaultVisibilitySet {
+					gc.defaultVisibility = nil
+					defaultVisibilitySet = true
+				}
+				if v := strings.TrimSpace(d.Value); v != "" {
+					gc.defaultVisibility = append(gc.defaultVisibility, v)
+				}
+
+			case "go_extra_deps":
+				if err := gc.addExtraDeps(rel, d.Value); err != nil {
+					log.Printf("%s: %v", f.Path, err)
+				}
+
+			case "go_plugin_data":
+				if err := gc.addPluginData(rel, d.Value); err != nil {
+					log.Printf("%s: %v", f.Path, err)
+				}
+
+			case "go_fuzz":
+				enabled, err := strconv.ParseBool(strings.TrimSpace(d.Value))
+				if err != nil {
+					log.Printf("%s: invalid go_fuzz directive %q: want true or false", f.Path, d.Value)
+					continue
+				}
+				gc.fuzz = enabled
+
 			case "go_grpc_compilers":
 				// Special syntax (empty value) to reset directive.
 				if d.Value == "" {
@@ -377,7 +907,83 @@ func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
 					gc.goGrpcCompilers = defaultGoGrpcCompilers
 				} else {
 					gc.goGrpcCompilersSet = true
-					gc.goGrpcCompilers = splitValue(d.Value)
+					gc.goGrpcCompilers = parseCompilers(gc.goGrpcCompilers, d.Value)
+				}
+
+			case "go_grpc_kind":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", "go_proto_library":
+					gc.grpcKind = ""
+				case "go_grpc_library":
+					gc.grpcKind = v
+				default:
+					log.Printf("%s: invalid go_grpc_kind directive %q: want go_proto_library or go_grpc_library", f.Path, d.Value)
+				}
+
+			case "go_grpc_gateway":
+				enabled, err := strconv.ParseBool(strings.TrimSpace(d.Value))
+				if err != nil {
+					log.Printf("%s: invalid go_grpc_gateway directive %q: want true or false", f.Path, d.Value)
+					continue
+				}
+				gc.grpcGateway = enabled
+
+			case "go_grpc_gateway_openapi":
+				enabled, err := strconv.ParseBool(strings.TrimSpace(d.Value))
+				if err != nil {
+					log.Printf("%s: invalid go_grpc_gateway_openapi directive %q: want true or false", f.Path, d.Value)
+					continue
+				}
+				gc.grpcGatewayOpenAPI = enabled
+
+			case "go_import_map":
+				m, err := parseGoImportMapping(d.Value)
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+				gc.importMappings = append(gc.importMappings, m)
+
+			case "go_label_style":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", "relative":
+					gc.qualifiedLabels = false
+				case "qualified":
+					if c.RepoName == "" {
+						log.Printf("%s: go_label_style qualified: repository name is unknown; set it with workspace(name = ...) in the WORKSPACE file", f.Path)
+						continue
+					}
+					gc.qualifiedLabels = true
+				default:
+					log.Printf("%s: invalid go_label_style directive %q: want relative or qualified", f.Path, d.Value)
+				}
+
+			case "go_mode":
+				if err := gc.setModeAttrs(d.Value); err != nil {
+					log.Print(err)
+				}
+
+			case "go_naming_template":
+				fields := strings.Fields(d.Value)
+				if len(fields) == 0 || len(fields) > 2 {
+					log.Printf("%s: go_naming_template: want kind and template; got %q", f.Path, d.Value)
+					continue
+				}
+				var tmpl string
+				if len(fields) == 2 {
+					tmpl = fields[1]
+					if err := checkNameTemplate(tmpl); err != nil {
+						log.Printf("%s: %v", f.Path, err)
+						continue
+					}
+				}
+				switch fields[0] {
+				case "go_library":
+					gc.libNameTemplate = tmpl
+				case "go_test":
+					gc.testNameTemplate = tmpl
+				default:
+					log.Printf("%s: go_naming_template: unsupported kind %q; want go_library or go_test", f.Path, fields[0])
 				}
 
 			case "go_proto_compilers":
@@ -387,12 +993,151 @@ func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
 					gc.goProtoCompilers = defaultGoProtoCompilers
 				} else {
 					gc.goProtoCompilersSet = true
-					gc.goProtoCompilers = splitValue(d.Value)
+					gc.goProtoCompilers = parseCompilers(gc.goProtoCompilers, d.Value)
+				}
+
+			case "go_protoc_output":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", protocOutputExclude, protocOutputInclude, protocOutputProto, protocOutputBoth:
+					gc.protocOutput = v
+				default:
+					log.Printf("%s: invalid go_protoc_output directive %q: want exclude, include, proto, or both", f.Path, d.Value)
+				}
+
+			case "go_cgo_setting":
+				// An empty value clears the setting.
+				v := strings.TrimSpace(d.Value)
+				if v == "" {
+					gc.cgoSetting = ""
+					break
+				}
+				l, err := label.Parse(v)
+				if err != nil {
+					log.Printf("%s: invalid go_cgo_setting directive %q: %v", f.Path, d.Value, err)
+					break
+				}
+				gc.cgoSetting = l.Abs("", rel).String()
+
+			case "go_cross_platforms":
+				// Platforms replace inherited platforms. An empty value clears them.
+				var platforms []string
+				for _, p := range strings.Split(d.Value, ",") {
+					p = strings.TrimSpace(p)
+					if p == "" {
+						continue
+					}
+					if l, err := label.Parse(p); err != nil || l.Relative {
+						log.Printf("%s: invalid platform for go_cross_platforms: %q; want an absolute label", f.Path, p)
+						continue
+					}
+					if !containsString(platforms, p) {
+						platforms = append(platforms, p)
+					}
+				}
+				gc.crossPlatforms = platforms
+
+			case "go_rule_tags":
+				// Tags are added to inherited tags. An empty value clears them.
+				if strings.TrimSpace(d.Value) == "" {
+					gc.ruleTags = nil
+					continue
+				}
+				for _, tag := range strings.Split(d.Value, ",") {
+					tag = strings.TrimSpace(tag)
+					if tag != "" && !containsString(gc.ruleTags, tag) {
+						gc.ruleTags = append(gc.ruleTags, tag)
+					}
+				}
+
+			case "go_test":
+				if err := gc.setTestDefaults(d.Value); err != nil {
+					log.Printf("%s: %v", f.Path, err)
+				}
+
+			case "go_test_attrs":
+				if strings.TrimSpace(d.Value) == "" {
+					gc.testAttrs = nil
+					continue
+				}
+				a, err := parseGoTestAttrs(rel, d.Value)
+				if err != nil {
+					log.Printf("%s: %v", f.Path, err)
+					continue
+				}
+				gc.testAttrs = append(gc.testAttrs, a)
+
+			case "go_package_attrs":
+				if strings.TrimSpace(d.Value) == "" {
+					gc.packageAttrs = nil
+					continue
+				}
+				a, err := parseGoPackageAttrs(rel, d.Value)
+				if err != nil {
+					log.Printf("%s: %v", f.Path, err)
+					continue
+				}
+				gc.packageAttrs = append(gc.packageAttrs, a)
+
+			case "go_library_granularity":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", "package":
+					gc.fileLibraries = false
+				case "file":
+					gc.fileLibraries = true
+				default:
+					log.Printf("%s: invalid go_library_granularity directive %q: want package or file", f.Path, d.Value)
+				}
+
+			case "go_binary_naming":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", binaryNamingDirname:
+					gc.binaryNaming = ""
+				case binaryNamingCmd:
+					gc.binaryNaming = v
+				default:
+					log.Printf("%s: invalid go_binary_naming directive %q: want dirname or cmd", f.Path, d.Value)
+				}
+
+			case "go_major_version_naming":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", majorVersionSuffix:
+					gc.majorVersionNaming = ""
+				case majorVersionFold, majorVersionError:
+					gc.majorVersionNaming = v
+				default:
+					log.Printf("%s: invalid go_major_version_naming directive %q: want suffix, fold, or error", f.Path, d.Value)
+				}
+
+			case "go_testdata":
+				switch v := strings.TrimSpace(d.Value); v {
+				case "", testdataGlob:
+					gc.testdataMode = ""
+				case testdataFilegroup, testdataOff:
+					gc.testdataMode = v
+				default:
+					log.Printf("%s: invalid go_testdata directive %q: want glob, filegroup, or off", f.Path, d.Value)
 				}
 
 			case "go_visibility":
 				gc.goVisibility = append(gc.goVisibility, strings.TrimSpace(d.Value))
 
+			case "go_infer_testonly":
+				enabled, err := strconv.ParseBool(strings.TrimSpace(d.Value))
+				if err != nil {
+					log.Printf("%s: invalid go_infer_testonly directive %q: want true or false", f.Path, d.Value)
+					continue
+				}
+				gc.inferTestonly = enabled
+
+			case "go_wasm":
+				enabled, err := strconv.ParseBool(strings.TrimSpace(d.Value))
+				if err != nil {
+					log.Printf("%s: invalid go_wasm directive %q: want true or false", f.Path, d.Value)
+					continue
+				}
+				gc.wasm = strconv.FormatBool(enabled)
+				gc.platforms = newGoPlatforms(gc.wasm)
+
 			case "importmap_prefix":
 				gc.importMapPrefix = d.Value
 				gc.importMapPrefixRel = rel
@@ -425,6 +1170,472 @@ func (*goLang) Configure(c *config.Config, rel string, f *rule.File) {
 	}
 }
 
+var (
+	modeAttrKinds = []string{"go_binary", "go_test"}
+
+	// validModeAttrs lists mode attributes that may be set with go_mode and
+	// the values they accept. A nil slice means any value is accepted.
+	validModeAttrs = map[string][]string{
+		"gotags": nil,
+		"msan":   {"auto", "on", "off"},
+		"pure":   {"auto", "on", "off"},
+		"race":   {"auto", "on", "off"},
+		"static": {"auto", "on", "off"},
+	}
+)
+
+// setModeAttrs parses the value of a go_mode directive. The value is an
+// optional rule kind (go_binary or go_test) followed by key=value pairs.
+// A pair with an empty value clears that attribute. An empty directive
+// clears all attributes.
+func (gc *goConfig) setModeAttrs(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		gc.modeAttrs = make(map[string]map[string]string)
+		return nil
+	}
+	kinds := modeAttrKinds
+	if !strings.Contains(fields[0], "=") {
+		kind := fields[0]
+		if kind != "go_binary" && kind != "go_test" {
+			return fmt.Errorf("go_mode: unsupported kind %q; want go_binary or go_test", kind)
+		}
+		kinds = []string{kind}
+		fields = fields[1:]
+	}
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return fmt.Errorf("go_mode: invalid attribute %q; want key=value", field)
+		}
+		key, val := field[:i], field[i+1:]
+		allowed, ok := validModeAttrs[key]
+		if !ok {
+			return fmt.Errorf("go_mode: unsupported attribute %q", key)
+		}
+		if val != "" && allowed != nil {
+			valid := false
+			for _, a := range allowed {
+				valid = valid || a == val
+			}
+			if !valid {
+				return fmt.Errorf("go_mode: invalid value %q for %s; want one of %s", val, key, strings.Join(allowed, ", "))
+			}
+		}
+		for _, kind := range kinds {
+			if gc.modeAttrs[kind] == nil {
+				gc.modeAttrs[kind] = make(map[string]string)
+			}
+			if val == "" {
+				delete(gc.modeAttrs[kind], key)
+			} else {
+				gc.modeAttrs[kind][key] = val
+			}
+		}
+	}
+	return nil
+}
+
+// libName returns the name of the go_library rule in the package rel.
+func (gc *goConfig) libName(rel, repoRoot string) string {
+	if gc.libNameTemplate == "" {
+		return defaultLibName
+	}
+	return expandNameTemplate(gc.libNameTemplate, pathtools.RelBaseName(rel, gc.prefix, repoRoot))
+}
+
+// testName returns the name of the go_test rule in the package rel.
+func (gc *goConfig) testName(rel, repoRoot string) string {
+	if gc.testNameTemplate == "" {
+		return defaultTestName
+	}
+	return expandNameTemplate(gc.testNameTemplate, pathtools.RelBaseName(rel, gc.prefix, repoRoot))
+}
+
+// expandNameTemplate replaces "{dirname}" in a rule name template with the
+// base name of the package directory.
+func expandNameTemplate(tmpl, dirname string) string {
+	return strings.Replace(tmpl, "{dirname}", dirname, -1)
+}
+
+// checkNameTemplate reports an error if tmpl contains placeholders other
+// than "{dirname}" or characters that aren't allowed in rule names.
+func checkNameTemplate(tmpl string) error {
+	name := expandNameTemplate(tmpl, "x")
+	if strings.ContainsAny(name, "{}") {
+		return fmt.Errorf("go_naming_template: unsupported placeholder in %q; only {dirname} is supported", tmpl)
+	}
+	if strings.ContainsAny(name, ":/") || name == "." || name == ".." {
+		return fmt.Errorf("go_naming_template: %q is not a valid rule name", tmpl)
+	}
+	return nil
+}
+
+// Values for the mode attribute of # gazelle:go_test.
+const (
+	// testModeCombined generates one go_test rule for internal and external
+	// test files. This is the default.
+	testModeCombined = "combined"
+
+	// testModeSplit generates a separate go_test rule for external test
+	// files (in a package with the "_test" suffix).
+	testModeSplit = "split"
+)
+
+// Values for # gazelle:go_testdata.
+const (
+	// testdataGlob sets the data attribute of go_test rules to a glob of
+	// the testdata directory. This is the default.
+	testdataGlob = "glob"
+
+	// testdataFilegroup generates a filegroup named testdataFilegroupName
+	// that globs the testdata directory, and lists it in the data attribute
+	// of go_test rules.
+	testdataFilegroup = "filegroup"
+
+	// testdataOff doesn't set the data attribute of go_test rules.
+	testdataOff = "off"
+)
+
+// Values for -major_version_naming and # gazelle:go_major_version_naming.
+const (
+	// majorVersionSuffix keeps major version suffixes in repository names,
+	// so example.com/m/v3 is provided by com_example_m_v3. This is the
+	// default.
+	majorVersionSuffix = "suffix"
+
+	// majorVersionFold drops major version suffixes from repository names,
+	// so example.com/m/v3 is provided by com_example_m, unless another
+	// repository already has that name.
+	majorVersionFold = "fold"
+
+	// majorVersionError drops major version suffixes from repository names
+	// like majorVersionFold, but update-repos reports an error instead of
+	// keeping the suffix when names collide.
+	majorVersionError = "error"
+)
+
+var validMajorVersionNaming = []string{majorVersionSuffix, majorVersionFold, majorVersionError}
+
+const (
+	// binaryNamingDirname names go_binary rules after the base name of their
+	// directory. This is the default.
+	binaryNamingDirname = "dirname"
+
+	// binaryNamingCmd names go_binary rules in cmd/<name> and its
+	// subdirectories <name>, so commands in directories like cmd/<name>/v2
+	// or cmd/<name>/main are named after the command. Binaries outside cmd
+	// directories are named with binaryNamingDirname.
+	binaryNamingCmd = "cmd"
+)
+
+var validBinaryNaming = []string{binaryNamingDirname, binaryNamingCmd}
+
+// validTestDefaults lists attributes that may be set with # gazelle:go_test
+// and their allowed values. A nil list means any value is allowed.
+var validTestDefaults = map[string][]string{
+	"rundir":      nil,
+	"shard_count": nil,
+	"size":        {"small", "medium", "large", "enormous"},
+	"timeout":     {"short", "moderate", "long", "eternal"},
+}
+
+// setTestDefaults parses the value of a go_test directive. The value is a
+// list of key=value pairs. "mode" may be "combined" or "split"; other keys
+// are attributes of generated go_test rules. An empty value for a key
+// removes it. An empty directive removes all settings.
+func (gc *goConfig) setTestDefaults(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		gc.testMode = ""
+		gc.testDefaults = make(map[string]string)
+		return nil
+	}
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return fmt.Errorf("go_test: invalid attribute %q; want key=value", field)
+		}
+		key, val := field[:i], field[i+1:]
+		if key == "mode" {
+			switch val {
+			case "", testModeCombined:
+				gc.testMode = ""
+			case testModeSplit:
+				gc.testMode = testModeSplit
+			default:
+				return fmt.Errorf("go_test: invalid mode %q; want combined or split", val)
+			}
+			continue
+		}
+		allowed, ok := validTestDefaults[key]
+		if !ok {
+			return fmt.Errorf("go_test: unsupported attribute %q", key)
+		}
+		if val == "" {
+			delete(gc.testDefaults, key)
+			continue
+		}
+		if key == "shard_count" {
+			if n, err := strconv.Atoi(val); err != nil || n <= 0 {
+				return fmt.Errorf("go_test: invalid shard_count %q; want a positive integer", val)
+			}
+		}
+		if allowed != nil {
+			valid := false
+			for _, a := range allowed {
+				valid = valid || a == val
+			}
+			if !valid {
+				return fmt.Errorf("go_test: invalid value %q for %s; want one of %s", val, key, strings.Join(allowed, ", "))
+			}
+		}
+		if gc.testDefaults == nil {
+			gc.testDefaults = make(map[string]string)
+		}
+		gc.testDefaults[key] = val
+	}
+	return nil
+}
+
+// extraDepsKinds lists the kinds that may be named in a go_extra_deps
+// directive.
+var extraDepsKinds = map[string]bool{
+	"go_binary":        true,
+	"go_library":       true,
+	"go_proto_library": true,
+	"go_test":          true,
+}
+
+// addExtraDeps parses the value of a go_extra_deps directive in the
+// directory rel. The value is a kind followed by labels:
+//
+//	# gazelle:go_extra_deps kind label...
+//
+// The labels are added to those inherited for the kind. Relative labels
+// are relative to rel. A kind with no labels clears the labels for that
+// kind, and an empty value clears all of them.
+func (gc *goConfig) addExtraDeps(rel, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		gc.extraDeps = make(map[string][]label.Label)
+		return nil
+	}
+	kind := fields[0]
+	if !extraDepsKinds[kind] {
+		return fmt.Errorf("go_extra_deps: unsupported kind %q; want go_library, go_binary, go_test, or go_proto_library", kind)
+	}
+	if len(fields) == 1 {
+		delete(gc.extraDeps, kind)
+		return nil
+	}
+	deps := gc.extraDeps[kind]
+	for _, s := range fields[1:] {
+		l, err := label.Parse(s)
+		if err != nil {
+			return fmt.Errorf("go_extra_deps: %v", err)
+		}
+		deps = append(deps, l.Abs("", rel))
+	}
+	if gc.extraDeps == nil {
+		gc.extraDeps = make(map[string][]label.Label)
+	}
+	gc.extraDeps[kind] = deps
+	return nil
+}
+
+// packagePattern matches packages by their paths, like a Bazel target
+// pattern. It's parsed from directives like go_test_attrs.
+type packagePattern struct {
+	// elems are the slash-separated elements of the pattern, relative to the
+	// repository root. Each may contain path.Match wildcards, and "..."
+	// matches any number of directories, including none.
+	elems []string
+}
+
+// parsePackagePattern parses a package pattern written in a directive in
+// the directory rel. The pattern is relative to rel, or to the repository
+// root if it starts with "//". "..." matches any number of directories, so
+// a pattern ending with "..." matches subpackages, and "//.../mocks"
+// matches every package named mocks.
+func parsePackagePattern(rel, pattern string) (packagePattern, error) {
+	orig := pattern
+	if strings.HasPrefix(pattern, "//") {
+		pattern = strings.TrimPrefix(pattern, "//")
+	} else {
+		pattern = path.Join(rel, pattern)
+	}
+	var p packagePattern
+	if pattern == "." || pattern == "" {
+		return p, nil
+	}
+	p.elems = strings.Split(pattern, "/")
+	for _, elem := range p.elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return packagePattern{}, fmt.Errorf("invalid pattern %q: %v", orig, err)
+		}
+	}
+	return p, nil
+}
+
+// matches returns whether the package at rel matches the pattern.
+func (p packagePattern) matches(rel string) bool {
+	return matchPathPattern(p.elems, rel, false)
+}
+
+// matchPathPattern returns whether the slash-separated path p matches the
+// pattern, given as its slash-separated elements. Each element may contain
+// path.Match wildcards, which don't cross a "/", and a "..." element
+// matches any number of elements, including none. If prefix is true, the
+// pattern only needs to match the leading elements of p.
+//
+// This is used for all path patterns in this package: package patterns in
+// directives, go_import_map, and GOPRIVATE-style lists.
+func matchPathPattern(pattern []string, p string, prefix bool) bool {
+	var elems []string
+	if p != "" {
+		elems = strings.Split(p, "/")
+	}
+	if prefix {
+		pattern = append(pattern[:len(pattern):len(pattern)], "...")
+	}
+	return matchPatternElems(pattern, elems)
+}
+
+func matchPatternElems(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "..." {
+		for i := 0; i <= len(elems); i++ {
+			if matchPatternElems(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+		return false
+	}
+	return matchPatternElems(pattern[1:], elems[1:])
+}
+
+// goTestAttrs is a set of attributes to set on go_test rules in packages
+// matching a pattern, parsed from a go_test_attrs directive.
+type goTestAttrs struct {
+	packagePattern
+
+	env    map[string]string
+	args   []string
+	rundir string
+}
+
+// parseGoTestAttrs parses the value of a go_test_attrs directive in the
+// directory rel. The value is a package pattern followed by attributes:
+//
+//	# gazelle:go_test_attrs pattern env=KEY=VALUE args=ARG rundir=DIR ...
+//
+// See parsePackagePattern for the pattern syntax. env and args may be
+// repeated.
+func parseGoTestAttrs(rel, value string) (goTestAttrs, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return goTestAttrs{}, fmt.Errorf("go_test_attrs: want pattern and key=value attributes; got %q", value)
+	}
+	var a goTestAttrs
+	var err error
+	if a.packagePattern, err = parsePackagePattern(rel, fields[0]); err != nil {
+		return goTestAttrs{}, fmt.Errorf("go_test_attrs: %v", err)
+	}
+
+	for _, field := range fields[1:] {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return goTestAttrs{}, fmt.Errorf("go_test_attrs: invalid attribute %q; want key=value", field)
+		}
+		key, val := field[:i], field[i+1:]
+		switch key {
+		case "env":
+			j := strings.Index(val, "=")
+			if j <= 0 {
+				return goTestAttrs{}, fmt.Errorf("go_test_attrs: invalid env %q; want env=NAME=VALUE", field)
+			}
+			if a.env == nil {
+				a.env = make(map[string]string)
+			}
+			a.env[val[:j]] = val[j+1:]
+		case "args":
+			a.args = append(a.args, val)
+		case "rundir":
+			if val == "" {
+				return goTestAttrs{}, fmt.Errorf("go_test_attrs: rundir must not be empty")
+			}
+			a.rundir = val
+		default:
+			return goTestAttrs{}, fmt.Errorf("go_test_attrs: unsupported attribute %q; want env, args, or rundir", key)
+		}
+	}
+	return a, nil
+}
+
+// goPackageAttrs is a set of attributes to set on rules generated in
+// packages matching a pattern, parsed from a go_package_attrs directive.
+// It's used, for example, to mark generated mocks as testonly and tag them
+// so they're excluded from coverage.
+type goPackageAttrs struct {
+	packagePattern
+
+	// testonly is nil if the directive doesn't set testonly.
+	testonly *bool
+	tags     []string
+}
+
+// parseGoPackageAttrs parses the value of a go_package_attrs directive in
+// the directory rel. The value is a package pattern followed by attributes:
+//
+//	# gazelle:go_package_attrs pattern testonly=true tags=TAG,TAG ...
+//
+// See parsePackagePattern for the pattern syntax. tags may be repeated.
+func parseGoPackageAttrs(rel, value string) (goPackageAttrs, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return goPackageAttrs{}, fmt.Errorf("go_package_attrs: want pattern and key=value attributes; got %q", value)
+	}
+	var a goPackageAttrs
+	var err error
+	if a.packagePattern, err = parsePackagePattern(rel, fields[0]); err != nil {
+		return goPackageAttrs{}, fmt.Errorf("go_package_attrs: %v", err)
+	}
+
+	for _, field := range fields[1:] {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return goPackageAttrs{}, fmt.Errorf("go_package_attrs: invalid attribute %q; want key=value", field)
+		}
+		key, val := field[:i], field[i+1:]
+		switch key {
+		case "testonly":
+			testonly, err := strconv.ParseBool(val)
+			if err != nil {
+				return goPackageAttrs{}, fmt.Errorf("go_package_attrs: invalid testonly %q; want true or false", val)
+			}
+			a.testonly = &testonly
+		case "tags":
+			for _, tag := range strings.Split(val, ",") {
+				if tag != "" && !containsString(a.tags, tag) {
+					a.tags = append(a.tags, tag)
+				}
+			}
+		default:
+			return goPackageAttrs{}, fmt.Errorf("go_package_attrs: unsupported attribute %q; want testonly or tags", key)
+		}
+	}
+	return a, nil
+}
+
 // checkPrefix checks that a string may be used as a prefix. We forbid local
 // (relative) imports and those beginning with "/". We allow the empty string,
 // but generated rules must not have an empty importpath.
@@ -437,6 +1648,32 @@ func checkPrefix(prefix string) error {
 
 // splitDirective splits a comma-separated directive value into its component
 // parts, trimming each of any whitespace characters.
+// parseCompilers returns the list of compilers set by a go_proto_compilers
+// or go_grpc_compilers directive. If every label in value starts with "+",
+// the labels are appended to the inherited list instead of replacing it.
+// Labels already in the inherited list are not added again.
+func parseCompilers(inherited []string, value string) []string {
+	values := splitValue(value)
+	for _, v := range values {
+		if !strings.HasPrefix(v, "+") {
+			return values
+		}
+	}
+	compilers := append([]string(nil), inherited...)
+	seen := make(map[string]bool)
+	for _, c := range compilers {
+		seen[c] = true
+	}
+	for _, v := range values {
+		v = strings.TrimSpace(strings.TrimPrefix(v, "+"))
+		if v != "" && !seen[v] {
+			seen[v] = true
+			compilers = append(compilers, v)
+		}
+	}
+	return compilers
+}
+
 func splitValue(value string) []string {
 	parts := strings.Split(value, ",")
 	values := make([]string, 0, len(parts))
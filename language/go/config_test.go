@@ -19,6 +19,7 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -127,6 +128,29 @@ func TestDirectives(t *testing.T) {
 		t.Errorf("got goProtoCompilers %v; want [foo bar]", gc.goProtoCompilers)
 	}
 
+	appendContent := []byte(`
+# gazelle:go_grpc_compilers +ghi, +abc
+# gazelle:go_proto_compilers +baz
+`)
+	f, err = rule.LoadData(filepath.FromSlash("test/append/BUILD.bazel"), "append", appendContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendConfig := c.Clone()
+	for _, cext := range cexts {
+		cext.Configure(appendConfig, "test/append", f)
+	}
+	agc := getGoConfig(appendConfig)
+	if !reflect.DeepEqual(agc.goGrpcCompilers, []string{"abc", "def", "ghi"}) {
+		t.Errorf("got appended goGrpcCompilers %v; want [abc def ghi]", agc.goGrpcCompilers)
+	}
+	if !reflect.DeepEqual(agc.goProtoCompilers, []string{"foo", "bar", "baz"}) {
+		t.Errorf("got appended goProtoCompilers %v; want [foo bar baz]", agc.goProtoCompilers)
+	}
+	if !reflect.DeepEqual(gc.goProtoCompilers, []string{"foo", "bar"}) {
+		t.Errorf("appending modified parent goProtoCompilers: got %v; want [foo bar]", gc.goProtoCompilers)
+	}
+
 	subContent := []byte(`
 # gazelle:go_grpc_compilers
 # gazelle:go_proto_compilers
@@ -261,6 +285,64 @@ load("@io_bazel_rules_go//proto:go_proto_library.bzl", "go_proto_library")
 	}
 }
 
+func TestGoBuildTagsDirective(t *testing.T) {
+	c, _, cexts := testConfig(t, "-build_tags=base")
+	for _, tc := range []struct {
+		rel, content string
+		want         []string
+		wantOff      []string
+	}{
+		{
+			rel:     "a",
+			content: "# gazelle:go_build_tags integration,base",
+			want:    []string{"base", "integration"},
+		}, {
+			rel:     "a/b",
+			content: "# gazelle:go_build_tags e2e",
+			want:    []string{"base", "e2e"},
+			wantOff: []string{"integration"},
+		}, {
+			rel:  "a/b/c",
+			want: []string{"base", "e2e"},
+		}, {
+			rel:     "a/b/c/d",
+			content: "# gazelle:go_build_tags",
+			want:    []string{"base"},
+			wantOff: []string{"integration", "e2e"},
+		}, {
+			rel:     "a/b/c/d/e",
+			content: "# gazelle:go_build_tags !neg",
+			want:    []string{"base"},
+		},
+	} {
+		t.Run(tc.rel, func(t *testing.T) {
+			var f *rule.File
+			if tc.content != "" {
+				var err error
+				f, err = rule.LoadData(path.Join(tc.rel, "BUILD.bazel"), tc.rel, []byte(tc.content))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			c = c.Clone()
+			for _, cext := range cexts {
+				cext.Configure(c, tc.rel, f)
+			}
+			gc := getGoConfig(c)
+			for _, tag := range tc.want {
+				if !gc.genericTags[tag] {
+					t.Errorf("tag %q not set", tag)
+				}
+			}
+			for _, tag := range tc.wantOff {
+				if gc.genericTags[tag] {
+					t.Errorf("tag %q unexpectedly set", tag)
+				}
+			}
+		})
+	}
+}
+
 func TestPreprocessTags(t *testing.T) {
 	gc := newGoConfig()
 	expectedTags := []string{"gc"}
@@ -321,6 +403,72 @@ gazelle(
 	}
 }
 
+func TestWorkModules(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "go.work",
+			Content: `
+go 1.18
+
+use ./a // comment
+use (
+	./b/c
+	"../outside"
+)
+`,
+		}, {
+			Path:    "a/go.mod",
+			Content: "module example.com/a\n",
+		}, {
+			Path:    "b/c/go.mod",
+			Content: "module example.com/c // comment\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	c, _, cexts := testConfig(t, "-repo_root="+dir, "-go_prefix=example.com/root")
+	for _, tc := range []struct {
+		rel, wantPrefix, wantPrefixRel string
+	}{
+		{rel: "", wantPrefix: "example.com/root", wantPrefixRel: ""},
+		{rel: "a", wantPrefix: "example.com/a", wantPrefixRel: "a"},
+		{rel: "a/x", wantPrefix: "example.com/a", wantPrefixRel: "a"},
+		{rel: "b", wantPrefix: "example.com/root", wantPrefixRel: ""},
+		{rel: "b/c", wantPrefix: "example.com/c", wantPrefixRel: "b/c"},
+	} {
+		t.Run(tc.rel, func(t *testing.T) {
+			c := c.Clone()
+			for _, rel := range pathPrefixes(tc.rel) {
+				for _, cext := range cexts {
+					cext.Configure(c, rel, nil)
+				}
+			}
+			gc := getGoConfig(c)
+			if gc.prefix != tc.wantPrefix {
+				t.Errorf("prefix: got %q; want %q", gc.prefix, tc.wantPrefix)
+			}
+			if gc.prefixRel != tc.wantPrefixRel {
+				t.Errorf("prefixRel: got %q; want %q", gc.prefixRel, tc.wantPrefixRel)
+			}
+		})
+	}
+}
+
+// pathPrefixes returns rel and each of its parent directories, starting
+// with the repository root ("").
+func pathPrefixes(rel string) []string {
+	prefixes := []string{""}
+	if rel == "" {
+		return prefixes
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefixes = append(prefixes, strings.Join(parts[:i+1], "/"))
+	}
+	return prefixes
+}
+
 func TestSplitValue(t *testing.T) {
 	for _, tc := range []struct {
 		value string
@@ -341,3 +489,97 @@ func TestSplitValue(t *testing.T) {
 		}
 	}
 }
+
+func TestGoTestAttrsMatches(t *testing.T) {
+	for _, tc := range []struct {
+		rel, value string
+		pkgs       map[string]bool
+	}{
+		{
+			rel:   "a",
+			value: "b args=-v",
+			pkgs:  map[string]bool{"a/b": true, "a": false, "a/b/c": false},
+		}, {
+			rel:   "a",
+			value: "./... args=-v",
+			pkgs:  map[string]bool{"a": true, "a/b/c": true, "ab": false, "": false},
+		}, {
+			rel:   "a",
+			value: "//... args=-v",
+			pkgs:  map[string]bool{"": true, "x/y": true},
+		}, {
+			rel:   "",
+			value: "*/b/... args=-v",
+			pkgs:  map[string]bool{"x/b": true, "x/b/c": true, "x/c": false, "x/y/b": false},
+		}, {
+			rel:   "a",
+			value: "//.../mocks args=-v",
+			pkgs:  map[string]bool{"mocks": true, "a/mocks": true, "a/b/mocks": true, "a/mocks/x": false, "a/mocksx": false},
+		}, {
+			rel:   "a",
+			value: ".../mocks/... args=-v",
+			pkgs:  map[string]bool{"a/mocks": true, "a/b/mocks/x": true, "mocks": false},
+		},
+	} {
+		a, err := parseGoTestAttrs(tc.rel, tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for pkg, want := range tc.pkgs {
+			if got := a.matches(pkg); got != want {
+				t.Errorf("%q in %q: matches(%q) = %v; want %v", tc.value, tc.rel, pkg, got, want)
+			}
+		}
+	}
+}
+
+func TestParseGoPackageAttrs(t *testing.T) {
+	for _, tc := range []struct {
+		value, wantErr string
+		wantTestonly   string
+		wantTags       []string
+	}{
+		{
+			value:        "mocks/... testonly=true tags=no-coverage,mock tags=mock",
+			wantTestonly: "true",
+			wantTags:     []string{"no-coverage", "mock"},
+		}, {
+			value:        "mocks testonly=false",
+			wantTestonly: "false",
+		}, {
+			value:    "mocks tags=a",
+			wantTags: []string{"a"},
+		}, {
+			value:   "mocks",
+			wantErr: "go_package_attrs: want pattern and key=value attributes",
+		}, {
+			value:   "mocks testonly=maybe",
+			wantErr: "go_package_attrs: invalid testonly",
+		}, {
+			value:   "mocks visibility=//visibility:private",
+			wantErr: "go_package_attrs: unsupported attribute",
+		},
+	} {
+		a, err := parseGoPackageAttrs("", tc.value)
+		if tc.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+				t.Errorf("%q: got error %v; want %q", tc.value, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.value, err)
+			continue
+		}
+		gotTestonly := ""
+		if a.testonly != nil {
+			gotTestonly = strconv.FormatBool(*a.testonly)
+		}
+		if gotTestonly != tc.wantTestonly {
+			t.Errorf("%q: got testonly %q; want %q", tc.value, gotTestonly, tc.wantTestonly)
+		}
+		if !reflect.DeepEqual(a.tags, tc.wantTags) {
+			t.Errorf("%q: got tags %q; want %q", tc.value, a.tags, tc.wantTags)
+		}
+	}
+}
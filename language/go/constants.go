@@ -27,6 +27,15 @@ const (
 	// just needs to be unique in the Bazel package
 	defaultTestName = "go_default_test"
 
+	// defaultXTestName is the name of an external test (in a package with
+	// the "_test" suffix), when external tests are generated separately with
+	// # gazelle:go_test mode=split.
+	defaultXTestName = "go_default_xtest"
+
+	// testdataFilegroupName is the name of the filegroup generated for a
+	// testdata directory with # gazelle:go_testdata filegroup.
+	testdataFilegroupName = "testdata"
+
 	// legacyProtoFilegroupName is the anme of a filegroup created in legacy
 	// mode for libraries that contained .pb.go files and .proto files.
 	legacyProtoFilegroupName = "go_default_library_protos"
@@ -35,10 +44,49 @@ const (
 	// "compilers" attribute of go_proto_library rules.
 	grpcCompilerLabel = "@io_bazel_rules_go//proto:go_grpc"
 
+	// grpcGatewayCompilerLabel is the label for the grpc-gateway compiler
+	// plugin, added to the "compilers" attribute of go_proto_library rules
+	// for protos with google.api.http annotations.
+	grpcGatewayCompilerLabel = "@grpc_ecosystem_grpc_gateway//protoc-gen-grpc-gateway:go_gen_grpc_gateway"
+
+	// openAPIRuleSuffix is appended to the base name of a proto_library to
+	// name the protoc_gen_openapiv2 rule generated for it.
+	openAPIRuleSuffix = "_openapiv2"
+
 	// wellKnownTypesGoPrefix is the import path for the Go repository containing
 	// pre-generated code for the Well Known Types.
 	wellKnownTypesGoPrefix = "github.com/golang/protobuf"
 
 	// wellKnownTypesPkg is the package name for the predefined WKTs in rules_go.
 	wellKnownTypesPkg = "proto/wkt"
+
+	// cgoIncludesKey is the private attribute key for the set of C headers
+	// included by cgo packages. These are resolved to cc_library rules and
+	// written to "cdeps".
+	cgoIncludesKey = "_gazelle_cgo_includes"
+
+	// generatedSrcsDepsKey is the private attribute key for deps of an
+	// existing go_library whose srcs include targets that generate code at
+	// build time. Gazelle can't see imports in generated code, so these deps
+	// are kept in addition to the resolved ones.
+	generatedSrcsDepsKey = "_gazelle_generated_srcs_deps"
+
+	// existingRuleKey is the private attribute key for the rule in the
+	// existing build file that a generated rule will be merged into. It's
+	// only set with -check_unused_deps, so unused deps can be found after
+	// dependency resolution.
+	existingRuleKey = "_gazelle_existing_rule"
+
+	// mainLibraryKey is the private attribute key for the rule in the build
+	// file whose visibility Resolve sets, on a go_library generated for a
+	// main package.
+	mainLibraryKey = "_gazelle_main_library"
+
+	// ccImportLang is the import language for C and C++ headers provided by
+	// cc_library rules.
+	ccImportLang = "cc"
+
+	// protoGoImportLang is the import language for Go packages generated from
+	// proto_library rules, named by their go_package options.
+	protoGoImportLang = "proto_go"
 )
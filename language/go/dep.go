@@ -16,11 +16,17 @@ limitations under the License.
 package golang
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	toml "github.com/pelletier/go-toml"
 )
@@ -45,19 +51,34 @@ func importReposFromDep(args language.ImportReposArgs) language.ImportReposResul
 		return language.ImportReposResult{Error: err}
 	}
 
+	// Resolve source directives. These may require network lookups, so
+	// they're done concurrently. Errors are reported for all projects
+	// that failed, not just the first.
+	remotes := make([]depRemote, len(file.Projects))
+	errs := forEachLimited(len(file.Projects), getGoConfig(args.Config).importConcurrency, func(i int) error {
+		p := file.Projects[i]
+		if p.Source == "" {
+			return nil
+		}
+		remote, vcs, err := depSourceRemote(args.Cache, p.Source)
+		if err != nil {
+			return fmt.Errorf("%s: could not resolve source %q: %v", p.Name, p.Source, err)
+		}
+		remotes[i] = depRemote{remote: remote, vcs: vcs}
+		return nil
+	})
+	if err := importError(errs); err != nil {
+		return language.ImportReposResult{Error: err}
+	}
+
 	gen := make([]*rule.Rule, len(file.Projects))
 	for i, p := range file.Projects {
 		gen[i] = rule.NewRule("go_repository", label.ImportPathToBazelRepoName(p.Name))
 		gen[i].SetAttr("importpath", p.Name)
 		gen[i].SetAttr("commit", p.Revision)
 		if p.Source != "" {
-			// TODO(#411): Handle source directives correctly. It may be an import
-			// path, or a URL. In the case of an import path, we should resolve it
-			// to the correct remote and vcs. In the case of a URL, we should
-			// correctly determine what VCS to use (the URL will usually start
-			// with "https://", which is used by multiple VCSs).
-			gen[i].SetAttr("remote", p.Source)
-			gen[i].SetAttr("vcs", "git")
+			gen[i].SetAttr("remote", remotes[i].remote)
+			gen[i].SetAttr("vcs", remotes[i].vcs)
 		}
 	}
 	sort.SliceStable(gen, func(i, j int) bool {
@@ -66,3 +87,64 @@ func importReposFromDep(args language.ImportReposArgs) language.ImportReposResul
 
 	return language.ImportReposResult{Gen: gen}
 }
+
+type depRemote struct {
+	remote, vcs string
+}
+
+// scpLikeURLRe matches scp-like URLs, such as "git@github.com:user/repo.git".
+// These are only understood by git.
+var scpLikeURLRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+@[A-Za-z0-9_.-]+:`)
+
+// depSourceRemote returns the remote URL and version control system for the
+// "source" of a project in a dep lock file. The source may be a URL or an
+// import path. Import paths are resolved with go-import meta tags (or known
+// hosting sites) like "go get" does. For URLs, the VCS is inferred from the
+// scheme or the path suffix. For http and https URLs without such hints, the
+// host and path are looked up as an import path. If that fails, git is
+// assumed, since dep tries git first.
+func depSourceRemote(cache *repo.RemoteCache, source string) (remote, vcs string, err error) {
+	if scpLikeURLRe.MatchString(source) {
+		return source, "git", nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme == "" {
+		// Not a URL; resolve it as an import path.
+		return cache.Remote(strings.TrimSuffix(source, "/"))
+	}
+
+	if vcs := vcsFromURL(u); vcs != "" {
+		return source, vcs, nil
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		root := path.Join(u.Host, strings.TrimSuffix(u.Path, "/"))
+		if _, vcs, err := cache.Remote(root); err == nil {
+			return source, vcs, nil
+		}
+	}
+	return source, "git", nil
+}
+
+// vcsFromURL infers a version control system from a URL's scheme or path
+// suffix. It returns "" if there are no hints.
+func vcsFromURL(u *url.URL) string {
+	switch u.Scheme {
+	case "git", "git+ssh":
+		return "git"
+	case "svn", "svn+ssh":
+		return "svn"
+	case "bzr", "bzr+ssh":
+		return "bzr"
+	}
+	switch path.Ext(strings.TrimSuffix(u.Path, "/")) {
+	case ".git":
+		return "git"
+	case ".hg":
+		return "hg"
+	case ".bzr":
+		return "bzr"
+	case ".svn":
+		return "svn"
+	}
+	return ""
+}
@@ -24,9 +24,9 @@ import (
 	"go/parser"
 	"go/token"
 	"log"
-	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
@@ -35,6 +35,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 // fileInfo holds information used to decide how to build a file. This
@@ -61,6 +62,14 @@ type fileInfo struct {
 	// ends with "_test.go". This is never true for non-Go files.
 	isTest bool
 
+	// isExternalTest is true for test files in a package with the "_test"
+	// suffix.
+	isExternalTest bool
+
+	// fuzzTests is a list of native fuzz tests (functions named FuzzXxx
+	// that accept a *testing.F) declared in a test file.
+	fuzzTests []string
+
 	// imports is a list of packages imported by a file. It does not include
 	// "C" or anything from the standard library.
 	imports []string
@@ -80,21 +89,44 @@ type fileInfo struct {
 	// CXXFLAGS, and LDFLAGS directives in cgo comments.
 	copts, clinkopts []taggedOpts
 
+	// includes is a list of headers named in #include lines in the cgo
+	// preamble of .go files, or in C, C++, and header files.
+	includes []string
+
 	// hasServices indicates whether a .proto file has service definitions.
 	hasServices bool
+
+	// hasHTTPRules indicates whether a .proto file has methods with
+	// google.api.http annotations.
+	hasHTTPRules bool
 }
 
+// cgoMode determines how the "cgo" build tag is evaluated.
+type cgoMode int
+
+const (
+	// cgoAny means the "cgo" tag is ignored, like release tags. rules_go
+	// filters sources by their constraints when it builds, so files are
+	// included whether they need cgo or not.
+	cgoAny cgoMode = iota
+
+	// cgoOn and cgoOff mean the "cgo" tag is true or false. These are used
+	// when cgo is a select dimension, set with go_cgo_setting.
+	cgoOn
+	cgoOff
+)
+
 // tagLine represents the space-separated disjunction of build tag groups
 // in a line comment.
 type tagLine []tagGroup
 
 // check returns true if at least one of the tag groups is satisfied.
-func (l tagLine) check(c *config.Config, os, arch string) bool {
+func (l tagLine) check(c *config.Config, os, arch string, cgo cgoMode) bool {
 	if len(l) == 0 {
 		return false
 	}
 	for _, g := range l {
-		if g.check(c, os, arch) {
+		if g.check(c, os, arch, cgo) {
 			return true
 		}
 	}
@@ -108,7 +140,8 @@ type tagGroup []string
 // "!" are negated (but "!!") is not allowed. Go release tags (e.g., "go1.8")
 // are ignored. If the group contains an os or arch tag, but the os or arch
 // parameters are empty, check returns false even if the tag is negated.
-func (g tagGroup) check(c *config.Config, os, arch string) bool {
+// The "cgo" tag is ignored unless cgo is cgoOn or cgoOff.
+func (g tagGroup) check(c *config.Config, os, arch string, cgo cgoMode) bool {
 	goConf := getGoConfig(c)
 	for _, t := range g {
 		if strings.HasPrefix(t, "!!") { // bad syntax, reject always
@@ -118,18 +151,19 @@ func (g tagGroup) check(c *config.Config, os, arch string) bool {
 		if not {
 			t = t[1:]
 		}
-		if isIgnoredTag(t) {
+		var match bool
+		if t == "cgo" && cgo != cgoAny {
+			match = cgo == cgoOn
+		} else if isIgnoredTag(t) {
 			// Release tags are treated as "unknown" and are considered true,
 			// whether or not they are negated.
 			continue
-		}
-		var match bool
-		if _, ok := rule.KnownOSSet[t]; ok {
+		} else if isKnownOS(t) {
 			if os == "" {
 				return false
 			}
 			match = matchesOS(os, t)
-		} else if _, ok := rule.KnownArchSet[t]; ok {
+		} else if isKnownArch(t) {
 			if arch == "" {
 				return false
 			}
@@ -229,12 +263,12 @@ func fileNameInfo(path_ string) fileInfo {
 		l = l[:len(l)-1]
 	}
 	switch {
-	case len(l) >= 3 && rule.KnownOSSet[l[len(l)-2]] && rule.KnownArchSet[l[len(l)-1]]:
+	case len(l) >= 3 && isKnownOS(l[len(l)-2]) && isKnownArch(l[len(l)-1]):
 		goos = l[len(l)-2]
 		goarch = l[len(l)-1]
-	case len(l) >= 2 && rule.KnownOSSet[l[len(l)-1]]:
+	case len(l) >= 2 && isKnownOS(l[len(l)-1]):
 		goos = l[len(l)-1]
-	case len(l) >= 2 && rule.KnownArchSet[l[len(l)-1]]:
+	case len(l) >= 2 && isKnownArch(l[len(l)-1]):
 		goarch = l[len(l)-1]
 	}
 
@@ -251,18 +285,26 @@ func fileNameInfo(path_ string) fileInfo {
 // otherFileInfo returns information about a non-.go file. It will parse
 // part of the file to determine build tags. If the file can't be read, an
 // error will be logged, and partial information will be returned.
-func otherFileInfo(path string) fileInfo {
+func otherFileInfo(fs walk.FS, path string) fileInfo {
 	info := fileNameInfo(path)
 	if info.ext == unknownExt {
 		return info
 	}
 
-	tags, err := readTags(info.path)
+	tags, err := readTags(fs, info.path)
 	if err != nil {
 		log.Printf("%s: error reading file: %v", info.path, err)
 		return info
 	}
 	info.tags = tags
+	if info.ext == cExt || info.ext == hExt || info.ext == csExt {
+		includes, err := readIncludes(fs, info.path)
+		if err != nil {
+			log.Printf("%s: error reading file: %v", info.path, err)
+			return info
+		}
+		info.includes = includes
+	}
 	return info
 }
 
@@ -272,19 +314,31 @@ func otherFileInfo(path string) fileInfo {
 // will be returned.
 // This function is intended to match go/build.Context.Import.
 // TODD(#53): extract canonical import path
-func goFileInfo(path, rel string) fileInfo {
+func goFileInfo(fs walk.FS, path, rel string) fileInfo {
 	info := fileNameInfo(path)
+	src, err := fs.ReadFile(info.path)
+	if err != nil {
+		log.Printf("%s: error reading go file: %v", info.path, err)
+		return info
+	}
 	fset := token.NewFileSet()
-	pf, err := parser.ParseFile(fset, info.path, nil, parser.ImportsOnly|parser.ParseComments)
+	pf, err := parser.ParseFile(fset, info.path, src, parser.ImportsOnly|parser.ParseComments)
 	if err != nil {
 		log.Printf("%s: error reading go file: %v", info.path, err)
 		return info
 	}
+	if info.isTest {
+		for _, m := range fuzzTestRe.FindAllSubmatch(src, -1) {
+			info.fuzzTests = append(info.fuzzTests, string(m[1]))
+		}
+	}
 
 	info.packageName = pf.Name.Name
 	if info.isTest && strings.HasSuffix(info.packageName, "_test") {
 		info.packageName = info.packageName[:len(info.packageName)-len("_test")]
+		info.isExternalTest = true
 	}
+	info.importPath = readImportComment(fset, pf)
 
 	for _, decl := range pf.Decls {
 		d, ok := decl.(*ast.GenDecl)
@@ -323,7 +377,7 @@ func goFileInfo(path, rel string) fileInfo {
 		}
 	}
 
-	tags, err := readTags(info.path)
+	tags, err := readTags(fs, info.path)
 	if err != nil {
 		log.Printf("%s: error reading go file: %v", info.path, err)
 		return info
@@ -333,6 +387,42 @@ func goFileInfo(path, rel string) fileInfo {
 	return info
 }
 
+// readImportComment returns the path in an import comment on the package
+// clause of pf, like `package foo // import "example.com/foo"`, or "" if
+// there is none.
+func readImportComment(fset *token.FileSet, pf *ast.File) string {
+	line := fset.Position(pf.Name.End()).Line
+	for _, cg := range pf.Comments {
+		if cg.Pos() < pf.Name.End() {
+			continue
+		}
+		if fset.Position(cg.Pos()).Line != line {
+			return ""
+		}
+		text := cg.List[0].Text
+		if strings.HasPrefix(text, "//") {
+			text = text[len("//"):]
+		} else {
+			text = strings.TrimSuffix(text[len("/*"):], "*/")
+		}
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, "import ") && !strings.HasPrefix(text, "import\t") {
+			return ""
+		}
+		importPath, err := strconv.Unquote(strings.TrimSpace(text[len("import"):]))
+		if err != nil {
+			return ""
+		}
+		return importPath
+	}
+	return ""
+}
+
+// fuzzTestRe matches declarations of native fuzz tests. Like "go test", it
+// accepts "Fuzz" followed by a name that doesn't start with a lower case
+// letter. The declaration must start at the beginning of a line.
+var fuzzTestRe = regexp.MustCompile(`(?m)^func (Fuzz(?:[^\p{Ll}(\s]\w*)?)\(\s*\w+\s+\*testing\.F\s*\)`)
+
 // saveCgo extracts CFLAGS, CPPFLAGS, CXXFLAGS, and LDFLAGS directives
 // from a comment above a "C" import. This is intended to match logic in
 // go/build.Context.saveCgo.
@@ -341,6 +431,11 @@ func saveCgo(info *fileInfo, rel string, cg *ast.CommentGroup) error {
 	for _, line := range strings.Split(text, "\n") {
 		orig := line
 
+		if inc, ok := parseInclude(line); ok {
+			info.includes = append(info.includes, inc)
+			continue
+		}
+
 		// Line is
 		//	#cgo [GOOS/GOARCH...] LDFLAGS: stuff
 		//
@@ -394,6 +489,55 @@ func saveCgo(info *fileInfo, rel string, cg *ast.CommentGroup) error {
 	return nil
 }
 
+// readIncludes returns the headers named in #include lines in a C, C++, or
+// assembly file.
+func readIncludes(fs walk.FS, path string) ([]string, error) {
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var includes []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if inc, ok := parseInclude(scanner.Text()); ok {
+			includes = append(includes, inc)
+		}
+	}
+	return includes, scanner.Err()
+}
+
+// parseInclude returns the header named by an #include line, which may use
+// either the quoted or the angle bracket form.
+func parseInclude(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	line = strings.TrimSpace(line[1:])
+	if !strings.HasPrefix(line, "include") {
+		return "", false
+	}
+	line = strings.TrimSpace(line[len("include"):])
+	if len(line) < 2 {
+		return "", false
+	}
+	var end byte
+	switch line[0] {
+	case '"':
+		end = '"'
+	case '<':
+		end = '>'
+	default:
+		return "", false
+	}
+	i := strings.IndexByte(line[1:], end)
+	if i <= 0 {
+		return "", false
+	}
+	return line[1 : i+1], true
+}
+
 // splitQuoted splits the string s around each instance of one or more consecutive
 // white space characters while taking into account quotes and escaping, and
 // returns an array of substrings of s or an empty list if s contains only white space.
@@ -515,13 +659,12 @@ func safeCgoName(s string, spaces bool) bool {
 // rest of the file by a blank line. Each string in the returned slice
 // is the trimmed text of a line after a "+build" prefix.
 // Based on go/build.Context.shouldBuild.
-func readTags(path string) ([]tagLine, error) {
-	f, err := os.Open(path)
+func readTags(fs walk.FS, path string) ([]tagLine, error) {
+	content, err := fs.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
 
 	// Pass 1: Identify leading run of // comments and blank lines,
 	// which must be followed by a blank line.
@@ -544,9 +687,17 @@ func readTags(path string) ([]tagLine, error) {
 	}
 	lines = lines[:end]
 
-	// Pass 2: Process each line in the run.
+	// Pass 2: Process each line in the run. A //go:build line takes
+	// precedence over +build lines, as it does in the go command.
 	var tagLines []tagLine
 	for _, line := range lines {
+		if strings.HasPrefix(line, "go:build ") || strings.HasPrefix(line, "go:build\t") {
+			l, err := parseGoBuildExpr(strings.TrimSpace(line[len("go:build"):]))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			return []tagLine{l}, nil
+		}
 		fields := strings.Fields(line)
 		if len(fields) > 0 && fields[0] == "+build" {
 			tagLines = append(tagLines, parseTagsInGroups(fields[1:]))
@@ -555,6 +706,31 @@ func readTags(path string) ([]tagLine, error) {
 	return tagLines, nil
 }
 
+// isProtocOutput returns whether the Go file at path was generated by
+// protoc-gen-go or a similar plugin (like protoc-gen-go-grpc). These files
+// start with a "Code generated by protoc-gen-go..." comment before the
+// package clause.
+func isProtocOutput(fs walk.FS, path string) bool {
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line[len("//"):]), "Code generated by protoc-gen-go") {
+			return true
+		}
+	}
+	return false
+}
+
 func parseTagsInGroups(groups []string) tagLine {
 	var l tagLine
 	for _, g := range groups {
@@ -563,6 +739,137 @@ func parseTagsInGroups(groups []string) tagLine {
 	return l
 }
 
+// parseGoBuildExpr parses the expression of a //go:build line, like
+// "(linux || darwin) && !purego", into a tagLine: a disjunction of groups
+// of tags that must all be true. Negated subexpressions are rewritten
+// with De Morgan's laws.
+func parseGoBuildExpr(expr string) (tagLine, error) {
+	p := goBuildParser{s: expr}
+	l, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.i < len(p.s) {
+		return nil, fmt.Errorf("invalid //go:build expression %q: unexpected %q", expr, p.s[p.i:])
+	}
+	return l, nil
+}
+
+// goBuildParser is a recursive descent parser for //go:build expressions.
+type goBuildParser struct {
+	s string
+	i int
+}
+
+func (p *goBuildParser) skipSpace() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *goBuildParser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.i:], tok) {
+		p.i += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *goBuildParser) parseOr() (tagLine, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, r...)
+	}
+	return l, nil
+}
+
+func (p *goBuildParser) parseAnd() (tagLine, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = andTagLines(l, r)
+	}
+	return l, nil
+}
+
+func (p *goBuildParser) parseNot() (tagLine, error) {
+	if p.consume("!") {
+		l, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notTagLine(l), nil
+	}
+	if p.consume("(") {
+		l, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("invalid //go:build expression %q: missing )", p.s)
+		}
+		return l, nil
+	}
+	p.skipSpace()
+	start := p.i
+	for p.i < len(p.s) && isIdentChar(p.s[p.i]) {
+		p.i++
+	}
+	if p.i == start {
+		return nil, fmt.Errorf("invalid //go:build expression %q: expected tag at %q", p.s, p.s[p.i:])
+	}
+	return tagLine{{p.s[start:p.i]}}, nil
+}
+
+func isIdentChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.'
+}
+
+// andTagLines returns a tagLine that is true when both a and b are true.
+func andTagLines(a, b tagLine) tagLine {
+	var l tagLine
+	for _, ga := range a {
+		for _, gb := range b {
+			g := make(tagGroup, 0, len(ga)+len(gb))
+			g = append(g, ga...)
+			g = append(g, gb...)
+			l = append(l, g)
+		}
+	}
+	return l
+}
+
+// notTagLine returns a tagLine that is true when l is false.
+func notTagLine(l tagLine) tagLine {
+	result := tagLine{{}}
+	for _, g := range l {
+		var neg tagLine
+		for _, tag := range g {
+			if strings.HasPrefix(tag, "!") {
+				neg = append(neg, tagGroup{tag[1:]})
+			} else {
+				neg = append(neg, tagGroup{"!" + tag})
+			}
+		}
+		result = andTagLines(result, neg)
+	}
+	return result
+}
+
 func isOSArchSpecific(info fileInfo, cgoTags tagLine) (osSpecific, archSpecific bool) {
 	if info.goos != "" {
 		osSpecific = true
@@ -580,12 +887,10 @@ func isOSArchSpecific(info fileInfo, cgoTags tagLine) (osSpecific, archSpecific
 				if strings.HasPrefix(tag, "!") {
 					tag = tag[1:]
 				}
-				_, osOk := rule.KnownOSSet[tag]
-				if osOk {
+				if isKnownOS(tag) {
 					osSpecific = true
 				}
-				_, archOk := rule.KnownArchSet[tag]
-				if archOk {
+				if isKnownArch(tag) {
 					archSpecific = true
 				}
 			}
@@ -594,6 +899,53 @@ func isOSArchSpecific(info fileInfo, cgoTags tagLine) (osSpecific, archSpecific
 	return osSpecific, archSpecific
 }
 
+// isKnownOS returns whether name is an operating system that Go supports.
+// Operating systems in rule.OptionalPlatforms are included, so files for
+// them are recognized even when rules don't have select() arms for them.
+func isKnownOS(name string) bool {
+	if rule.KnownOSSet[name] {
+		return true
+	}
+	for _, p := range rule.OptionalPlatforms {
+		if p.OS == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownArch is like isKnownOS for architectures.
+func isKnownArch(name string) bool {
+	if rule.KnownArchSet[name] {
+		return true
+	}
+	for _, p := range rule.OptionalPlatforms {
+		if p.Arch == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isCgoSpecific returns whether the "cgo" tag appears in a file's build
+// constraints or in the constraints of a #cgo directive.
+func isCgoSpecific(info fileInfo, cgoTags tagLine) bool {
+	lines := info.tags
+	if len(cgoTags) > 0 {
+		lines = append(lines[:len(lines):len(lines)], cgoTags)
+	}
+	for _, line := range lines {
+		for _, group := range line {
+			for _, tag := range group {
+				if strings.TrimPrefix(tag, "!") == "cgo" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // matchesOS checks if a value is equal to either an OS value or to any of its
 // aliases.
 func matchesOS(os, value string) bool {
@@ -620,17 +972,18 @@ func matchesOS(os, value string) bool {
 // The remaining arguments describe the file being tested. All of these may
 // be empty or nil. osSuffix and archSuffix are filename suffixes. fileTags
 // is a list tags from +build comments found near the top of the file. cgoTags
-// is an extra set of tags in a #cgo directive.
-func checkConstraints(c *config.Config, os, arch, osSuffix, archSuffix string, fileTags []tagLine, cgoTags tagLine) bool {
+// is an extra set of tags in a #cgo directive. cgo determines how the "cgo"
+// tag is evaluated.
+func checkConstraints(c *config.Config, os, arch string, cgo cgoMode, osSuffix, archSuffix string, fileTags []tagLine, cgoTags tagLine) bool {
 	if osSuffix != "" && !matchesOS(os, osSuffix) || archSuffix != "" && archSuffix != arch {
 		return false
 	}
 	for _, l := range fileTags {
-		if !l.check(c, os, arch) {
+		if !l.check(c, os, arch, cgo) {
 			return false
 		}
 	}
-	if len(cgoTags) > 0 && !cgoTags.check(c, os, arch) {
+	if len(cgoTags) > 0 && !cgoTags.check(c, os, arch, cgo) {
 		return false
 	}
 	return true
@@ -691,5 +1044,6 @@ func protoFileInfo(path_ string, protoInfo proto.FileInfo) fileInfo {
 
 	info.imports = protoInfo.Imports
 	info.hasServices = protoInfo.HasServices
+	info.hasHTTPRules = protoInfo.HasHTTPRules
 	return info
 }
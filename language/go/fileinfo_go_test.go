@@ -22,6 +22,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 func TestGoFileInfo(t *testing.T) {
@@ -55,6 +57,32 @@ func TestGoFileInfo(t *testing.T) {
 				isTest:      false,
 			},
 		},
+		{
+			"import comment",
+			"foo.go",
+			"package foo // import \"example.com/foo\"\n",
+			fileInfo{
+				packageName: "foo",
+				importPath:  "example.com/foo",
+			},
+		},
+		{
+			"block import comment",
+			"foo.go",
+			"package foo /* import \"example.com/foo\" */\n",
+			fileInfo{
+				packageName: "foo",
+				importPath:  "example.com/foo",
+			},
+		},
+		{
+			"comment after package clause",
+			"foo.go",
+			"package foo\n\n// import \"example.com/foo\"\n",
+			fileInfo{
+				packageName: "foo",
+			},
+		},
 		{
 			"single import",
 			"foo.go",
@@ -148,10 +176,11 @@ package route
 				t.Fatal(err)
 			}
 
-			got := goFileInfo(path, "")
+			got := goFileInfo(walk.OSFS{}, path, "")
 			// Clear fields we don't care about for testing.
 			got = fileInfo{
 				packageName: got.packageName,
+				importPath:  got.importPath,
 				isTest:      got.isTest,
 				imports:     got.imports,
 				isCgo:       got.isCgo,
@@ -177,7 +206,7 @@ func TestGoFileInfoFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got := goFileInfo(path, "")
+	got := goFileInfo(walk.OSFS{}, path, "")
 	want := fileInfo{
 		path:   path,
 		name:   name,
@@ -283,6 +312,26 @@ import ("C")
 				},
 			},
 		},
+		{
+			"includes",
+			`package foo
+
+/*
+#cgo CFLAGS: -O0
+#include <stdlib.h>
+# include "foo/bar.h"
+#include_next "ignored.h"
+*/
+import "C"
+`,
+			fileInfo{
+				isCgo: true,
+				copts: []taggedOpts{
+					{opts: "-O0"},
+				},
+				includes: []string{"stdlib.h", "foo/bar.h"},
+			},
+		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestCgo")
@@ -296,10 +345,10 @@ import ("C")
 				t.Fatal(err)
 			}
 
-			got := goFileInfo(path, "")
+			got := goFileInfo(walk.OSFS{}, path, "")
 
 			// Clear fields we don't care about for testing.
-			got = fileInfo{isCgo: got.isCgo, copts: got.copts, clinkopts: got.clinkopts}
+			got = fileInfo{isCgo: got.isCgo, copts: got.copts, clinkopts: got.clinkopts, includes: got.includes}
 
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
@@ -365,7 +414,7 @@ import "C"
 		t,
 		"-repo_root="+repo,
 		"-go_prefix=example.com/repo")
-	pkgs, _ := buildPackages(c, sub, "sub", []string{"sub.go"}, false)
+	pkgs, _ := buildPackages(c, walk.OSFS{}, sub, "sub", []string{"sub.go"}, false)
 	got, ok := pkgs["sub"]
 	if !ok {
 		t.Fatal("did not build package 'sub'")
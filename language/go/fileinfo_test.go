@@ -21,6 +21,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 func TestOtherFileInfo(t *testing.T) {
@@ -51,7 +53,7 @@ func TestOtherFileInfo(t *testing.T) {
 			}
 			defer os.Remove(tc.name)
 
-			got := otherFileInfo(filepath.Join(dir, tc.name))
+			got := otherFileInfo(walk.OSFS{}, filepath.Join(dir, tc.name))
 
 			// Only check that we can extract tags. Everything else is covered
 			// by other tests.
@@ -314,6 +316,21 @@ package main`,
 			"/* +build foo */\n\n",
 			nil,
 		},
+		{
+			"go:build",
+			"//go:build (linux || darwin) && !purego\n\n",
+			[]tagLine{{{"linux", "!purego"}, {"darwin", "!purego"}}},
+		},
+		{
+			"go:build takes precedence",
+			"//go:build amd64\n// +build arm64\n\npackage main",
+			[]tagLine{{{"amd64"}}},
+		},
+		{
+			"go:build negated group",
+			"//go:build !(amd64 && gc)\n\n",
+			[]tagLine{{{"!amd64"}, {"!gc"}}},
+		},
 	} {
 		f, err := ioutil.TempFile(".", "TestReadTags")
 		if err != nil {
@@ -328,7 +345,7 @@ package main`,
 			t.Fatal(err)
 		}
 
-		if got, err := readTags(path); err != nil {
+		if got, err := readTags(walk.OSFS{}, path); err != nil {
 			t.Fatal(err)
 		} else if !reflect.DeepEqual(got, tc.want) {
 			t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
@@ -346,6 +363,7 @@ func TestCheckConstraints(t *testing.T) {
 		desc                        string
 		genericTags                 map[string]bool
 		os, arch, filename, content string
+		cgo                         cgoMode
 		want                        bool
 	}{
 		{
@@ -492,6 +510,26 @@ import "C"
 			desc:    "cgo tag negated",
 			content: "// +build !cgo",
 			want:    true,
+		}, {
+			desc:    "cgo tag with cgo on",
+			content: "// +build cgo\n\npackage foo",
+			cgo:     cgoOn,
+			want:    true,
+		}, {
+			desc:    "cgo tag with cgo off",
+			content: "// +build cgo\n\npackage foo",
+			cgo:     cgoOff,
+			want:    false,
+		}, {
+			desc:    "cgo tag negated with cgo on",
+			content: "// +build !cgo\n\npackage foo",
+			cgo:     cgoOn,
+			want:    false,
+		}, {
+			desc:    "cgo tag negated with cgo off",
+			content: "// +build !cgo\n\npackage foo",
+			cgo:     cgoOff,
+			want:    true,
 		}, {
 			desc:    "race msan tags",
 			content: "// +build msan race",
@@ -523,13 +561,13 @@ import "C"
 				t.Fatal(err)
 			}
 
-			fi := goFileInfo(path, "")
+			fi := goFileInfo(walk.OSFS{}, path, "")
 			var cgoTags tagLine
 			if len(fi.copts) > 0 {
 				cgoTags = fi.copts[0].tags
 			}
 
-			got := checkConstraints(c, tc.os, tc.arch, fi.goos, fi.goarch, fi.tags, cgoTags)
+			got := checkConstraints(c, tc.os, tc.arch, tc.cgo, fi.goos, fi.goarch, fi.tags, cgoTags)
 			if got != tc.want {
 				t.Errorf("got %v ; want %v", got, tc.want)
 			}
@@ -0,0 +1,271 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// generateFileLibs generates a private go_library for each group of library
+// files in pkg when # gazelle:go_library_granularity file is set. lib is the
+// package's library, which still contains all files and is what other
+// packages depend on.
+//
+// Files in a Go package may refer to each other's declarations, so each
+// generated library includes the file it's named after, plus the files
+// that declare what it refers to, transitively. Files that refer to each
+// other share a library. This lets each library be built on its own, and
+// only be rebuilt when one of its files changes.
+//
+// Per-file libraries aren't generated for packages with cgo, non-Go
+// sources, an embedded go_proto_library, or files that can't be parsed
+// (for example, generated files), since references can't be found. Existing
+// per-file libraries that are no longer needed are returned as empty rules.
+func (g *generator) generateFileLibs(pkg *goPackage, lib *rule.Rule, protoEmbed string) []*rule.Rule {
+	var rules []*rule.Rule
+	if getGoConfig(g.c).fileLibraries && protoEmbed == "" && !pkg.library.cgo && onlyGoFiles(pkg.libraryFiles) {
+		groups := fileLibGroups(pkg.libraryFiles)
+		if len(groups) > 1 {
+			for _, grp := range groups {
+				r := rule.NewRule("go_library", fileLibName(lib.Name(), grp.name))
+				var target goTarget
+				for _, info := range grp.files {
+					target.addFile(g.c, info)
+				}
+				g.setCommonAttrs(r, pkg.rel, []string{"//visibility:private"}, target, "")
+				rules = append(rules, r)
+			}
+		}
+	}
+
+	// Delete per-file libraries that weren't generated.
+	if g.file != nil {
+		generated := make(map[string]bool)
+		for _, r := range rules {
+			generated[r.Name()] = true
+		}
+		for _, r := range g.file.Rules {
+			if isFileLib(r, lib.Name()) && !generated[r.Name()] {
+				rules = append(rules, rule.NewRule("go_library", r.Name()))
+			}
+		}
+	}
+	return rules
+}
+
+func onlyGoFiles(infos []fileInfo) bool {
+	for _, info := range infos {
+		if info.ext != goExt {
+			return false
+		}
+	}
+	return true
+}
+
+// fileLibName returns the name of the per-file library named after the
+// file with the given name.
+func fileLibName(libName, fileName string) string {
+	return libName + "_" + strings.TrimSuffix(fileName, ".go")
+}
+
+// isFileLib returns whether r looks like a per-file library generated for
+// the library named libName.
+func isFileLib(r *rule.Rule, libName string) bool {
+	if r.Kind() != "go_library" || !strings.HasPrefix(r.Name(), libName+"_") || r.Attr("importpath") != nil {
+		return false
+	}
+	vis := r.AttrStrings("visibility")
+	return len(vis) == 1 && vis[0] == "//visibility:private"
+}
+
+// fileLibGroup is a set of files that are built together in a per-file
+// library.
+type fileLibGroup struct {
+	// name is the name of the first file the library is for. Other files
+	// are included because name refers to them.
+	name string
+
+	// files is the set of files in the library, sorted by name.
+	files []fileInfo
+}
+
+// fileLibGroups returns a group for each set of files in a package that
+// refer to each other, including the files they refer to transitively.
+// Groups are sorted by name. If any file can't be parsed, or has a method
+// receiver that isn't understood, nil is returned.
+func fileLibGroups(infos []fileInfo) []fileLibGroup {
+	infos = append([]fileInfo(nil), infos...)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+
+	// Find top-level declarations and the names each file refers to.
+	fset := token.NewFileSet()
+	declFiles := make(map[string][]int)
+	methodFiles := make(map[string][]int)
+	refs := make([][]string, len(infos))
+	for i, info := range infos {
+		f, err := parser.ParseFile(fset, info.path, nil, 0)
+		if err != nil {
+			return nil
+		}
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					addDeclFile(declFiles, decl.Name.Name, i)
+				} else if len(decl.Recv.List) > 0 {
+					recv := receiverTypeName(decl.Recv.List[0].Type)
+					if recv == "" {
+						return nil
+					}
+					addDeclFile(methodFiles, recv, i)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						addDeclFile(declFiles, spec.Name.Name, i)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							addDeclFile(declFiles, name.Name, i)
+						}
+					}
+				}
+			}
+		}
+		refs[i] = referencedNames(f)
+	}
+
+	// Build a graph of files. A file depends on the files that declare names
+	// it refers to. Since methods can't be found without type checking, a
+	// file that refers to a type also depends on files that declare methods
+	// on that type.
+	edges := make([]map[int]bool, len(infos))
+	for i, names := range refs {
+		edges[i] = make(map[int]bool)
+		for _, name := range names {
+			for _, j := range declFiles[name] {
+				edges[i][j] = true
+			}
+			for _, j := range methodFiles[name] {
+				edges[i][j] = true
+			}
+		}
+	}
+
+	// Find the files each file needs, transitively. Files with the same set
+	// share a group.
+	var groups []fileLibGroup
+	groupIndex := make(map[string]int)
+	for i := range infos {
+		reached := map[int]bool{i: true}
+		stack := []int{i}
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for k := range edges[j] {
+				if !reached[k] {
+					reached[k] = true
+					stack = append(stack, k)
+				}
+			}
+		}
+		closure := make([]int, 0, len(reached))
+		for j := range reached {
+			closure = append(closure, j)
+		}
+		sort.Ints(closure)
+		keyParts := make([]string, len(closure))
+		for n, j := range closure {
+			keyParts[n] = infos[j].name
+		}
+		key := strings.Join(keyParts, "\x00")
+		if _, ok := groupIndex[key]; ok {
+			continue
+		}
+		groupIndex[key] = len(groups)
+		grp := fileLibGroup{name: infos[i].name}
+		for _, j := range closure {
+			grp.files = append(grp.files, infos[j])
+		}
+		groups = append(groups, grp)
+	}
+	return groups
+}
+
+func addDeclFile(m map[string][]int, name string, i int) {
+	if name == "_" || name == "init" || name == "" {
+		return
+	}
+	files := m[name]
+	if len(files) > 0 && files[len(files)-1] == i {
+		return
+	}
+	m[name] = append(files, i)
+}
+
+// receiverTypeName returns the name of the type in a method receiver,
+// like "T" in "(t *T)" or "(t T[K])", or "" if the receiver isn't
+// understood.
+func receiverTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// referencedNames returns the identifiers in the declarations of f that
+// might refer to top-level declarations in the same package. Selected
+// names, like y in x.y, are skipped, since they refer to fields, methods, or
+// declarations in other packages. Local names that shadow top-level names
+// are included; this only adds unneeded files to a group.
+func referencedNames(f *ast.File) []string {
+	seen := make(map[string]bool)
+	var names []string
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.Ident:
+			if !seen[n.Name] {
+				seen[n.Name] = true
+				names = append(names, n.Name)
+			}
+		}
+		return true
+	}
+	for _, decl := range f.Decls {
+		ast.Inspect(decl, visit)
+	}
+	return names
+}
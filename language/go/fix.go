@@ -17,9 +17,12 @@ package golang
 
 import (
 	"log"
+	"path"
+	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
@@ -30,6 +33,9 @@ func (_ *goLang) Fix(c *config.Config, f *rule.File) {
 	flattenSrcs(c, f)
 	squashCgoLibrary(c, f)
 	squashXtest(c, f)
+	migrateTestdata(c, f)
+	renameCmdBinary(c, f)
+	migrateImportPaths(c, f)
 	removeLegacyProto(c, f)
 	removeLegacyGazelle(c, f)
 }
@@ -52,8 +58,12 @@ func migrateLibraryEmbed(c *config.Config, f *rule.File) {
 }
 
 // migrateGrpcCompilers converts "go_grpc_library" rules into "go_proto_library"
-// rules with a "compilers" attribute.
+// rules with a "compilers" attribute, unless go_grpc_library rules are
+// generated with # gazelle:go_grpc_kind.
 func migrateGrpcCompilers(c *config.Config, f *rule.File) {
+	if getGoConfig(c).grpcKind == "go_grpc_library" {
+		return
+	}
 	for _, r := range f.Rules {
 		if r.Kind() != "go_grpc_library" || r.ShouldKeep() || r.Attr("compilers") != nil {
 			continue
@@ -101,7 +111,7 @@ func squashCgoLibrary(c *config.Config, f *rule.File) {
 
 	if goLibrary == nil {
 		cgoLibrary.SetKind("go_library")
-		cgoLibrary.SetName(defaultLibName)
+		rule.RenameRule(c.RepoName, f, cgoLibrary, defaultLibName, []*rule.File{f})
 		cgoLibrary.SetAttr("cgo", true)
 		return
 	}
@@ -128,7 +138,7 @@ func squashXtest(c *config.Config, f *rule.File) {
 		}
 		if r.Name() == defaultTestName {
 			itest = r
-		} else if r.Name() == "go_default_xtest" {
+		} else if r.Name() == defaultXTestName {
 			xtest = r
 		}
 	}
@@ -136,6 +146,9 @@ func squashXtest(c *config.Config, f *rule.File) {
 	if xtest == nil || xtest.ShouldKeep() || (itest != nil && itest.ShouldKeep()) {
 		return
 	}
+	if getGoConfig(c).testMode == testModeSplit {
+		return
+	}
 	if !c.ShouldFix {
 		if itest == nil {
 			log.Printf("%s: go_default_xtest is no longer necessary. Run 'gazelle fix' to rename to go_default_test.", f.Path)
@@ -159,6 +172,120 @@ func squashXtest(c *config.Config, f *rule.File) {
 	xtest.Delete()
 }
 
+// renameCmdBinary renames a go_binary named after its directory in a cmd
+// directory to the name chosen by # gazelle:go_binary_naming cmd. Without
+// this, the existing binary would keep its name, since go_binary rules
+// match regardless of name. References within the same file are updated.
+func renameCmdBinary(c *config.Config, f *rule.File) {
+	gc := getGoConfig(c)
+	if gc.binaryNaming != binaryNamingCmd {
+		return
+	}
+	name, ok := cmdBinName(f.Pkg, gc.prefix)
+	if !ok {
+		return
+	}
+	dirName := pathtools.RelBaseName(f.Pkg, gc.prefix, c.RepoRoot)
+	if name == dirName {
+		return
+	}
+	var bin *rule.Rule
+	for _, r := range f.Rules {
+		if r.Name() == name {
+			// Already renamed, or the name is taken.
+			return
+		}
+		if r.Kind() == "go_binary" && r.Name() == dirName && !r.ShouldKeep() {
+			bin = r
+		}
+	}
+	if bin == nil {
+		return
+	}
+	if !c.ShouldFix {
+		log.Printf("%s: go_binary %q is named after its directory. Run 'gazelle fix' to rename it to %q.", f.Path, dirName, name)
+		return
+	}
+	rule.RenameRule(c.RepoName, f, bin, name, []*rule.File{f})
+}
+
+// migrateImportPaths updates go_library rules whose importpath no longer
+// matches the path inferred for their directory, usually because the
+// module or prefix was renamed. Only rules whose old importpath ends with
+// the directory's path below the prefix are updated; other mismatches may
+// be deliberate. importmap and x_defs keys in the same file that refer to
+// packages under the old prefix are updated too. Other packages import the
+// library by its new path, so their deps are resolved to it later in the
+// same run.
+func migrateImportPaths(c *config.Config, f *rule.File) {
+	gc := getGoConfig(c)
+	if !gc.prefixSet {
+		return
+	}
+	newPath := InferImportPath(c, f.Pkg)
+	suffix := pathtools.TrimPrefix(f.Pkg, gc.prefixRel)
+	oldPrefixes := make(map[string]bool)
+	for _, r := range f.Rules {
+		if r.Kind() != "go_library" || r.ShouldKeep() {
+			continue
+		}
+		oldPath := r.AttrString("importpath")
+		if oldPath == "" || oldPath == newPath || r.ShouldKeepAttr("importpath") {
+			continue
+		}
+		oldPrefix := oldPath
+		if suffix != "" {
+			if !strings.HasSuffix(oldPath, "/"+suffix) {
+				continue
+			}
+			oldPrefix = strings.TrimSuffix(oldPath, "/"+suffix)
+		}
+		if !c.ShouldFix {
+			log.Printf("%s: go_library %q has importpath %q, but %q is expected in this directory. Run 'gazelle fix' to update it.", f.Path, r.Name(), oldPath, newPath)
+			continue
+		}
+		r.SetAttr("importpath", newPath)
+		if importMap := r.AttrString("importmap"); strings.HasSuffix(importMap, oldPath) {
+			r.SetAttr("importmap", strings.TrimSuffix(importMap, oldPath)+newPath)
+		}
+		oldPrefixes[oldPrefix] = true
+	}
+	if len(oldPrefixes) == 0 {
+		return
+	}
+
+	for _, r := range f.Rules {
+		if !isGoRule(r.Kind()) {
+			continue
+		}
+		xDefs, ok := r.Attr("x_defs").(*bzl.DictExpr)
+		if !ok || r.ShouldKeepAttr("x_defs") {
+			continue
+		}
+		for _, e := range xDefs.List {
+			kv, ok := e.(*bzl.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, ok := kv.Key.(*bzl.StringExpr)
+			if !ok {
+				continue
+			}
+			i := strings.LastIndexByte(key.Value, '.')
+			if i < 0 {
+				continue
+			}
+			pkgPath := key.Value[:i]
+			for oldPrefix := range oldPrefixes {
+				if pathtools.HasPrefix(pkgPath, oldPrefix) {
+					key.Value = path.Join(gc.prefix, pathtools.TrimPrefix(pkgPath, oldPrefix)) + key.Value[i:]
+					break
+				}
+			}
+		}
+	}
+}
+
 // flattenSrcs transforms srcs attributes structured as concatenations of
 // lists and selects (generated from PlatformStrings; see
 // extractPlatformStringsExprs for matching details) into a sorted,
@@ -180,6 +307,47 @@ func flattenSrcs(c *config.Config, f *rule.File) {
 	}
 }
 
+// migrateTestdata rewrites the data attribute of go_test rules when
+// # gazelle:go_testdata changes. Only values Gazelle generates for the
+// testdata directory are rewritten (a glob or the testdata filegroup);
+// data written by hand is left alone. Since data isn't a mergeable
+// attribute, the new value wouldn't replace the old one otherwise.
+func migrateTestdata(c *config.Config, f *rule.File) {
+	mode := getGoConfig(c).testdataMode
+	for _, r := range f.Rules {
+		if r.Kind() != "go_test" || r.ShouldKeep() {
+			continue
+		}
+		data := r.Attr("data")
+		if data == nil || rule.ShouldKeep(data) {
+			continue
+		}
+		isGlob := isTestdataGlob(data)
+		isFilegroup := bzl.FormatString(data) == `[":`+testdataFilegroupName+`"]`
+		if !isGlob && !isFilegroup {
+			continue
+		}
+		switch mode {
+		case testdataFilegroup:
+			if isGlob {
+				r.SetAttr("data", []string{":" + testdataFilegroupName})
+			}
+		case testdataOff:
+			r.DelAttr("data")
+		default:
+			if isFilegroup {
+				r.SetAttr("data", rule.GlobValue{Patterns: []string{"testdata/**"}})
+			}
+		}
+	}
+}
+
+// isTestdataGlob returns whether e is the glob of the testdata directory
+// Gazelle sets on go_test and filegroup rules.
+func isTestdataGlob(e bzl.Expr) bool {
+	return e != nil && bzl.FormatString(e) == `glob(["testdata/**"])`
+}
+
 // removeLegacyProto removes uses of the old proto rules. It deletes loads
 // from go_proto_library.bzl. It deletes proto filegroups. It removes
 // go_proto_library attributes which are no longer recognized. New rules
@@ -247,6 +247,35 @@ go_library(
     deps = ["deps"],
 )
 # after comment
+`,
+		}, {
+			desc: "cgo_library renamed with references",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_test")
+
+cgo_library(
+    name = "cgo_default_library",
+    srcs = ["foo.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    library = ":cgo_default_library",
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "cgo_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    cgo = True,
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    embed = [":go_default_library"],
+)
 `,
 		}, {
 			desc: "cgo_library merged with go_library",
@@ -643,3 +672,149 @@ func testFix(t *testing.T, tc fixTestCase, fix func(*rule.File)) {
 		t.Fatalf("%s: got %s; want %s", tc.desc, got, want)
 	}
 }
+
+func TestMigrateTestdata(t *testing.T) {
+	const (
+		globData = `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    data = glob(["testdata/**"]),
+)
+
+go_test(
+    name = "custom_test",
+    srcs = ["custom_test.go"],
+    data = ["testdata/custom.txt"],
+)
+`
+		filegroupData = `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    data = [":testdata"],
+)
+
+go_test(
+    name = "custom_test",
+    srcs = ["custom_test.go"],
+    data = ["testdata/custom.txt"],
+)
+`
+		noData = `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+
+go_test(
+    name = "custom_test",
+    srcs = ["custom_test.go"],
+    data = ["testdata/custom.txt"],
+)
+`
+	)
+	for _, tc := range []struct {
+		mode string
+		fixTestCase
+	}{
+		{testdataFilegroup, fixTestCase{desc: "glob to filegroup", old: globData, want: filegroupData}},
+		{"", fixTestCase{desc: "filegroup to glob", old: filegroupData, want: globData}},
+		{testdataOff, fixTestCase{desc: "glob to off", old: globData, want: noData}},
+		{testdataOff, fixTestCase{desc: "filegroup to off", old: filegroupData, want: noData}},
+		{"", fixTestCase{desc: "glob unchanged", old: globData, want: globData}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			testFix(t, tc.fixTestCase, func(f *rule.File) {
+				c, _, _ := testConfig(t)
+				getGoConfig(c).testdataMode = tc.mode
+				migrateTestdata(c, f)
+			})
+		})
+	}
+}
+
+func TestMigrateImportPaths(t *testing.T) {
+	for _, tc := range []fixTestCase{
+		{
+			desc: "renamed",
+			old: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "bar",
+    srcs = ["bar.go"],
+    importmap = "vendored/example.com/old/foo/bar",
+    importpath = "example.com/old/foo/bar",
+)
+
+go_binary(
+    name = "bar_bin",
+    embed = [":bar"],
+    x_defs = {
+        "example.com/old/foo/bar.Version": "1.0",
+        "example.com/old/version.Commit": "abc",
+        "example.com/other.Name": "x",
+    },
+)
+`,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "bar",
+    srcs = ["bar.go"],
+    importmap = "vendored/example.com/new/foo/bar",
+    importpath = "example.com/new/foo/bar",
+)
+
+go_binary(
+    name = "bar_bin",
+    embed = [":bar"],
+    x_defs = {
+        "example.com/new/foo/bar.Version": "1.0",
+        "example.com/new/version.Commit": "abc",
+        "example.com/other.Name": "x",
+    },
+)
+`,
+		}, {
+			desc: "kept",
+			old: `go_library(
+    name = "bar",
+    importpath = "example.com/old/foo/bar",  # keep
+)
+`,
+			want: `go_library(
+    name = "bar",
+    importpath = "example.com/old/foo/bar",  # keep
+)
+`,
+		}, {
+			desc: "unrelated path",
+			old: `go_library(
+    name = "bar",
+    importpath = "example.com/custom",
+)
+`,
+			want: `go_library(
+    name = "bar",
+    importpath = "example.com/custom",
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			testFix(t, tc, func(f *rule.File) {
+				c, _, _ := testConfig(t)
+				c.ShouldFix = true
+				gc := getGoConfig(c)
+				gc.prefix = "example.com/new"
+				gc.prefixSet = true
+				f.Pkg = "foo/bar"
+				migrateImportPaths(c, f)
+			})
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// gen_known_modules generates a .go file with a map from the paths of
+// well-known Go modules to the names of the go_repository rules that
+// provide them. The module paths are listed in a text file, one per line.
+//
+// With -refresh, each module is looked up on the module proxy first.
+// Modules the proxy doesn't know about are dropped from both the text file
+// and the generated file. This accesses the network, so it's only done
+// when the table is updated by hand, not in the build.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+var progName = filepath.Base(os.Args[0])
+
+var knownModulesTpl = template.Must(template.New("known_modules.go").Parse(`
+// Generated by language/go/gen_known_modules/gen_known_modules.go
+// From {{.ModulesTxt}}
+
+package golang
+
+// knownModules maps paths of well-known modules to the names of the
+// go_repository rules that provide them.
+var knownModules = map[string]string{
+{{range .Modules}}
+	{{printf "%q: %q" .Path .Name}},
+{{- end}}
+}
+`))
+
+type data struct {
+	ModulesTxt string
+	Modules    []module
+}
+
+type module struct {
+	Path, Name string
+}
+
+func main() {
+	log.SetPrefix(progName + ": ")
+	log.SetFlags(0)
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet(progName, flag.ExitOnError)
+	var modulesTxtPath, knownModulesPath, proxy string
+	var refresh bool
+	fs.StringVar(&modulesTxtPath, "modules", "", "path to known_modules.txt input file")
+	fs.StringVar(&knownModulesPath, "known_modules", "", "path to known_modules.go output file")
+	fs.BoolVar(&refresh, "refresh", false, "look up modules on the module proxy, and drop modules that don't exist from the input and output files")
+	fs.StringVar(&proxy, "proxy", defaultProxy(), "module proxy URL used with -refresh")
+	fs.Parse(args)
+	if modulesTxtPath == "" {
+		return fmt.Errorf("-modules not set")
+	}
+	if knownModulesPath == "" {
+		return fmt.Errorf("-known_modules not set")
+	}
+
+	header, paths, err := readModules(modulesTxtPath)
+	if err != nil {
+		return err
+	}
+	if refresh {
+		var found []string
+		for _, p := range paths {
+			if ok, err := proxyHasModule(proxy, p); err != nil {
+				return err
+			} else if !ok {
+				log.Printf("dropping %s: not found on %s", p, proxy)
+				continue
+			}
+			found = append(found, p)
+		}
+		paths = found
+		if err := writeModules(modulesTxtPath, header, paths); err != nil {
+			return err
+		}
+	}
+
+	data := data{ModulesTxt: modulesTxtPath}
+	for _, p := range paths {
+		data.Modules = append(data.Modules, module{p, label.ImportPathToBazelRepoName(p)})
+	}
+	buf := &bytes.Buffer{}
+	if err := knownModulesTpl.Execute(buf, data); err != nil {
+		return err
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(knownModulesPath, out, 0666)
+}
+
+// readModules reads a list of module paths from a file. Lines starting with
+// "#" are comments; those at the beginning of the file are returned as a
+// header, so they can be written back. Paths are returned sorted, without
+// duplicates.
+func readModules(path string) (header []string, paths []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "#") {
+			if len(paths) == 0 {
+				header = append(header, s.Text())
+			}
+			continue
+		}
+		if line == "" || seen[line] {
+			continue
+		}
+		seen[line] = true
+		paths = append(paths, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	return header, paths, nil
+}
+
+func writeModules(path string, header, paths []string) error {
+	buf := &bytes.Buffer{}
+	for _, line := range header {
+		fmt.Fprintln(buf, line)
+	}
+	for _, p := range paths {
+		fmt.Fprintln(buf, p)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0666)
+}
+
+// defaultProxy returns the first proxy in GOPROXY, or proxy.golang.org
+// if GOPROXY doesn't name one.
+func defaultProxy() string {
+	for _, p := range strings.FieldsFunc(os.Getenv("GOPROXY"), func(r rune) bool { return r == ',' || r == '|' }) {
+		if p != "direct" && p != "off" {
+			return p
+		}
+	}
+	return "https://proxy.golang.org"
+}
+
+// proxyHasModule returns whether the module proxy has any version of the
+// module with the given path.
+func proxyHasModule(proxy, modPath string) (bool, error) {
+	url := strings.TrimSuffix(proxy, "/") + "/" + escapePath(modPath) + "/@latest"
+	resp, err := http.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound, http.StatusGone:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+}
+
+// escapePath escapes a module path for use in a module proxy URL. Upper
+// case letters are replaced with "!" followed by the lower case letter.
+func escapePath(modPath string) string {
+	var b strings.Builder
+	for _, r := range modPath {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
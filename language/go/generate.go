@@ -22,20 +22,44 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	c := args.Config
+	fs := walk.GetFS(c)
+
+	// If this directory is reached through a symbolic link that was followed
+	// to another directory in the repository, rules are only generated in
+	// the real directory. Generating them in both places would produce
+	// conflicting rules in the same build file. Imports of packages here
+	// are resolved to the real directory (see canonicalImportPath). Links
+	// into the repository are only followed when listed with
+	// # gazelle:follow, so other directories aren't checked.
+	if walk.IsFollowed(c, args.Rel) {
+		if realRel, ok := canonicalRel(c, args.Dir); ok && realRel != args.Rel {
+			gl.linkedRels[args.Rel] = realRel
+			return language.GenerateResult{}
+		}
+	}
+
+	gc := getGoConfig(c)
+	if isUnvendoredDir(gc, args.Rel) {
+		return language.GenerateResult{}
+	}
+
 	// Extract information about proto files. We need this to exclude .pb.go
 	// files and generate go_proto_library rules.
-	c := args.Config
 	pcMode := getProtoMode(c)
 
 	// This is a collection of proto_library rule names that have a corresponding
@@ -46,7 +70,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	protoPackages := make(map[string]proto.Package)
 	protoFileInfo := make(map[string]proto.FileInfo)
 	for _, r := range args.OtherGen {
-		if r.Kind() == "go_proto_library" {
+		if isGoProtoLibrary(r.Kind()) {
 			if proto := r.AttrString("proto"); proto != "" {
 				goProtoRules[proto] = struct{}{}
 			}
@@ -68,6 +92,10 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 		protoRuleNames = append(protoRuleNames, r.Name())
 	}
 	sort.Strings(protoRuleNames)
+	var protoGroups map[string][]string
+	if pcMode == proto.FileMode {
+		protoRuleNames, protoGroups = groupFileModeProtos(c, args.Rel, protoRuleNames, protoPackages)
+	}
 	var emptyProtoRuleNames []string
 	for _, r := range args.OtherEmpty {
 		if r.Kind() == "proto_library" {
@@ -76,20 +104,52 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	}
 
 	// If proto rule generation is enabled, exclude .pb.go files that correspond
-	// to any .proto files present.
+	// to any .proto files present. The go_protoc_output directive may also
+	// exclude other files generated by protoc, or keep all of them.
 	regularFiles := append([]string{}, args.RegularFiles...)
 	genFiles := append([]string{}, args.GenFiles...)
-	if !pcMode.ShouldIncludePregeneratedFiles() {
+	isCheckedInOutput := func(f string) bool {
+		for _, p := range proto.ProtosForGoFile(f) {
+			if _, ok := protoFileInfo[p]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	keepProtocOutput := gc.protocOutput == protocOutputInclude || gc.protocOutput == protocOutputBoth
+	excludeProtocOutput := gc.protocOutput == protocOutputExclude ||
+		gc.protocOutput == protocOutputProto && len(protoRuleNames) > 0
+	if excludeProtocOutput || !keepProtocOutput && !pcMode.ShouldIncludePregeneratedFiles() {
 		keep := func(f string) bool {
-			if strings.HasSuffix(f, ".pb.go") {
-				_, ok := protoFileInfo[strings.TrimSuffix(f, ".pb.go")+".proto"]
-				return !ok
+			if isCheckedInOutput(f) {
+				return false
+			}
+			if excludeProtocOutput && strings.HasSuffix(f, ".go") {
+				return !isProtocOutput(fs, filepath.Join(args.Dir, f))
 			}
 			return true
 		}
 		filterFiles(&regularFiles, keep)
 		filterFiles(&genFiles, keep)
 	}
+	if gc.protocOutput == protocOutputInclude {
+		// Checked-in protoc output is the Go library for these protos. Don't
+		// generate go_proto_library rules that would duplicate it.
+		for _, name := range protoRuleNames {
+			goProtoRules[":"+name] = struct{}{}
+			emptyProtoRuleNames = append(emptyProtoRuleNames, name)
+		}
+		protoRuleNames = nil
+	}
+	hasCheckedInOutput := false
+	if gc.protocOutput == protocOutputBoth {
+		for _, f := range regularFiles {
+			if isCheckedInOutput(f) {
+				hasCheckedInOutput = true
+				break
+			}
+		}
+	}
 
 	// Split regular files into files which can determine the package name and
 	// import path and other files.
@@ -113,7 +173,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	}
 
 	// Build a set of packages from files in this directory.
-	goPackageMap, goFilesWithUnknownPackage := buildPackages(c, args.Dir, args.Rel, goFiles, hasTestdata)
+	goPackageMap, goFilesWithUnknownPackage := buildPackages(c, fs, args.Dir, args.Rel, goFiles, hasTestdata)
 
 	// Select a package to generate rules for. If there is no package, create
 	// an empty package so we can generate empty rules.
@@ -133,7 +193,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 					pkg = &goPackage{
 						name:       goProtoPackageName(ppkg),
 						importPath: goProtoImportPath(c, ppkg, args.Rel),
-						proto:      protoTargetFromProtoPackage(name, ppkg),
+						proto:      protoTargetFromProtoPackage(name, ppkg, protoGroups[name]),
 					}
 					protoName = name
 					break
@@ -153,11 +213,14 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 				inferImportPathErrorOnce.Do(func() { log.Print(err) })
 			}
 		}
+		if c.ShouldFix && pkg.importPath != "" {
+			pkg.checkImportComments()
+		}
 		for _, name := range protoRuleNames {
 			ppkg := protoPackages[name]
 			if pkg.importPath == goProtoImportPath(c, ppkg, args.Rel) {
 				protoName = name
-				pkg.proto = protoTargetFromProtoPackage(name, ppkg)
+				pkg.proto = protoTargetFromProtoPackage(name, ppkg, protoGroups[name])
 				break
 			}
 		}
@@ -168,7 +231,8 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	g := &generator{
 		c:                   c,
 		rel:                 args.Rel,
-		shouldSetVisibility: args.File == nil || !args.File.HasDefaultVisibility(),
+		shouldSetVisibility: args.File == nil || !args.File.HasDefaultVisibility() || getGoConfig(c).defaultVisibility != nil,
+		file:                args.File,
 	}
 	var res language.GenerateResult
 	var rules []*rule.Rule
@@ -186,7 +250,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 		if name == protoName {
 			protoEmbed, rs = g.generateProto(pcMode, pkg.proto, pkg.importPath)
 		} else {
-			target := protoTargetFromProtoPackage(name, ppkg)
+			target := protoTargetFromProtoPackage(name, ppkg, protoGroups[name])
 			importPath := goProtoImportPath(c, ppkg, args.Rel)
 			_, rs = g.generateProto(pcMode, target, importPath)
 		}
@@ -195,6 +259,16 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	for _, name := range emptyProtoRuleNames {
 		goProtoName := strings.TrimSuffix(name, "_proto") + "_go_proto"
 		res.Empty = append(res.Empty, rule.NewRule("go_proto_library", goProtoName))
+		if getGoConfig(c).grpcGatewayOpenAPI {
+			openAPIName := strings.TrimSuffix(name, "_proto") + openAPIRuleSuffix
+			res.Empty = append(res.Empty, rule.NewRule("protoc_gen_openapiv2", openAPIName))
+		}
+	}
+	if hasCheckedInOutput {
+		// The checked-in protoc output is the Go library, and the generated
+		// go_proto_library is a separate target. Embedding it would
+		// duplicate declarations.
+		protoEmbed = ""
 	}
 	if pkg != nil && pcMode == proto.PackageMode && pkg.firstGoFile() == "" {
 		// In proto package mode, don't generate a go_library embedding a
@@ -216,7 +290,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 
 		// Process the other static files.
 		for _, file := range otherFiles {
-			info := otherFileInfo(filepath.Join(args.Dir, file))
+			info := otherFileInfo(fs, filepath.Join(args.Dir, file))
 			if err := pkg.addFile(c, info, cgo); err != nil {
 				log.Print(err)
 			}
@@ -256,14 +330,40 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 			rules = append(rules, rs...)
 		}
 		lib := g.generateLib(pkg, protoEmbed)
+		g.preserveGeneratedSrcs(lib, pkg)
+		if pkg.isCommand() {
+			// Resolve makes the library visible to packages that import it.
+			// Visibility isn't merged, so it's set on the existing rule.
+			target := lib
+			if args.File != nil {
+				if old := existingRule(c, args.File, lib); old != nil {
+					target = old
+				}
+			}
+			lib.SetPrivateAttr(mainLibraryKey, target)
+		}
 		var libName string
 		if !lib.IsEmpty(goKinds[lib.Kind()]) {
 			libName = lib.Name()
 		}
 		rules = append(rules, lib)
-		rules = append(rules,
-			g.generateBin(pkg, libName),
-			g.generateTest(pkg, libName))
+		rules = append(rules, g.generateFileLibs(pkg, lib, protoEmbed)...)
+		var bins []*rule.Rule
+		if len(pkg.taggedBinaries) > 0 {
+			bins = g.generateTaggedBins(pkg, libName)
+		} else {
+			bins = []*rule.Rule{g.generateBin(pkg, libName)}
+		}
+		rules = append(rules, bins...)
+		rules = append(rules, g.generateCrossBins(bins)...)
+		rules = append(rules, g.generateTest(pkg, libName))
+		if getGoConfig(c).testMode == testModeSplit {
+			rules = append(rules, g.generateXTest(pkg))
+		}
+		rules = append(rules, g.generateFuzzTests(pkg, libName)...)
+		if fg := g.generateTestdata(pkg, rules); fg != nil {
+			rules = append(rules, fg)
+		}
 	}
 
 	for _, r := range rules {
@@ -272,8 +372,15 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 		} else {
 			res.Gen = append(res.Gen, r)
 			res.Imports = append(res.Imports, r.PrivateAttr(config.GazelleImportsKey))
+			gl.uses.recordGenerated(args.Rel, r)
 		}
 	}
+	gl.uses.recordPackage(args.Rel)
+
+	recordExistingRules(c, args.File, res.Gen)
+	addRuleTags(c, args.File, args.Rel, res.Gen)
+	setPackageTestonly(c, args.Rel, res.Gen)
+	setPluginData(c, args.Rel, args.File, res.Gen)
 
 	if args.File != nil || len(res.Gen) > 0 {
 		gl.goPkgRels[args.Rel] = true
@@ -301,12 +408,15 @@ func filterFiles(files *[]string, pred func(string) bool) {
 	*files = (*files)[:w]
 }
 
-func buildPackages(c *config.Config, dir, rel string, goFiles []string, hasTestdata bool) (packageMap map[string]*goPackage, goFilesWithUnknownPackage []fileInfo) {
+func buildPackages(c *config.Config, fs walk.FS, dir, rel string, goFiles []string, hasTestdata bool) (packageMap map[string]*goPackage, goFilesWithUnknownPackage []fileInfo) {
 	// Process .go and .proto files first, since these determine the package name.
 	packageMap = make(map[string]*goPackage)
+	// Main files guarded by build tags are added after the other files, once
+	// we know which sets of tags they need.
+	mainFiles := make(map[string][]fileInfo)
 	for _, f := range goFiles {
 		path := filepath.Join(dir, f)
-		info := goFileInfo(path, rel)
+		info := goFileInfo(fs, path, rel)
 		if info.packageName == "" {
 			goFilesWithUnknownPackage = append(goFilesWithUnknownPackage, info)
 			continue
@@ -324,13 +434,31 @@ func buildPackages(c *config.Config, dir, rel string, goFiles []string, hasTestd
 				hasTestdata: hasTestdata,
 			}
 		}
+		if info.packageName == "main" && !info.isTest {
+			if tags, ok := binaryTags(info); ok && !containsString(tags, "ignore") {
+				mainFiles[info.packageName] = append(mainFiles[info.packageName], info)
+				continue
+			}
+		}
 		if err := packageMap[info.packageName].addFile(c, info, false); err != nil {
 			log.Print(err)
 		}
 	}
+	for name, infos := range mainFiles {
+		packageMap[name].addMainFiles(c, infos)
+	}
 	return packageMap, goFilesWithUnknownPackage
 }
 
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
 var inferImportPathErrorOnce sync.Once
 
 // selectPackages selects one Go packages out of the buildable packages found
@@ -389,6 +517,9 @@ type generator struct {
 	c                   *config.Config
 	rel                 string
 	shouldSetVisibility bool
+
+	// file is the existing build file in the directory, if any.
+	file *rule.File
 }
 
 func (g *generator) generateProto(mode proto.Mode, target protoTarget, importPath string) (string, []*rule.Rule) {
@@ -406,6 +537,11 @@ func (g *generator) generateProto(mode proto.Mode, target protoTarget, importPat
 		protoName = proto.RuleName(importPath)
 	}
 	goProtoName := strings.TrimSuffix(protoName, "_proto") + "_go_proto"
+	if len(target.protos) > 1 {
+		// Several proto_library rules, one per file, are compiled into this
+		// package. Name the go_proto_library after the package instead.
+		goProtoName = strings.TrimSuffix(proto.RuleName(importPath), "_proto") + "_go_proto"
+	}
 	visibility := g.commonVisibility(importPath)
 
 	if mode == proto.LegacyMode {
@@ -420,18 +556,42 @@ func (g *generator) generateProto(mode proto.Mode, target protoTarget, importPat
 		return "", []*rule.Rule{filegroup}
 	}
 
+	openAPIName := strings.TrimSuffix(protoName, "_proto") + openAPIRuleSuffix
 	if target.sources.isEmpty() {
-		return "", []*rule.Rule{
+		rules := []*rule.Rule{
 			rule.NewRule("filegroup", filegroupName),
 			rule.NewRule("go_proto_library", goProtoName),
 		}
+		if gc.grpcGatewayOpenAPI {
+			rules = append(rules, rule.NewRule("protoc_gen_openapiv2", openAPIName))
+		}
+		return "", rules
 	}
 
 	goProtoLibrary := rule.NewRule("go_proto_library", goProtoName)
-	goProtoLibrary.SetAttr("proto", ":"+protoName)
+	if len(target.protos) > 1 {
+		protos := make([]string, len(target.protos))
+		for i, name := range target.protos {
+			protos[i] = ":" + name
+		}
+		goProtoLibrary.SetAttr("protos", protos)
+	} else {
+		goProtoLibrary.SetAttr("proto", ":"+protoName)
+	}
 	g.setImportAttrs(goProtoLibrary, importPath)
 	if target.hasServices {
-		goProtoLibrary.SetAttr("compilers", gc.goGrpcCompilers)
+		gateway := target.hasHTTPRules && gc.grpcGateway
+		if gc.grpcKind == "go_grpc_library" && !gc.goGrpcCompilersSet && !gateway {
+			// go_grpc_library sets its own compilers. Compilers set explicitly
+			// or needed for grpc-gateway still need go_proto_library.
+			goProtoLibrary.SetKind(gc.grpcKind)
+		} else {
+			compilers := gc.goGrpcCompilers
+			if gateway {
+				compilers = appendGatewayCompiler(compilers)
+			}
+			goProtoLibrary.SetAttr("compilers", compilers)
+		}
 	} else if gc.goProtoCompilersSet {
 		goProtoLibrary.SetAttr("compilers", gc.goProtoCompilers)
 	}
@@ -439,11 +599,37 @@ func (g *generator) generateProto(mode proto.Mode, target protoTarget, importPat
 		goProtoLibrary.SetAttr("visibility", visibility)
 	}
 	goProtoLibrary.SetPrivateAttr(config.GazelleImportsKey, target.imports.build())
-	return goProtoName, []*rule.Rule{goProtoLibrary}
+
+	if !gc.grpcGatewayOpenAPI {
+		return goProtoName, []*rule.Rule{goProtoLibrary}
+	}
+
+	// Generate an OpenAPI definition for protos annotated for grpc-gateway.
+	// The rule is empty otherwise, so an existing rule is deleted when the
+	// annotations are removed.
+	openAPI := rule.NewRule("protoc_gen_openapiv2", openAPIName)
+	if target.hasHTTPRules && len(target.protos) <= 1 {
+		openAPI.SetAttr("proto", ":"+protoName)
+		if g.shouldSetVisibility {
+			openAPI.SetAttr("visibility", visibility)
+		}
+	}
+	return goProtoName, []*rule.Rule{goProtoLibrary, openAPI}
+}
+
+// appendGatewayCompiler returns compilers with the grpc-gateway compiler
+// added, unless it's already in the list.
+func appendGatewayCompiler(compilers []string) []string {
+	for _, c := range compilers {
+		if c == grpcGatewayCompilerLabel {
+			return compilers
+		}
+	}
+	return append(compilers[:len(compilers):len(compilers)], grpcGatewayCompilerLabel)
 }
 
 func (g *generator) generateLib(pkg *goPackage, embed string) *rule.Rule {
-	goLibrary := rule.NewRule("go_library", defaultLibName)
+	goLibrary := rule.NewRule("go_library", g.libName(pkg))
 	if !pkg.library.sources.hasGo() && embed == "" {
 		return goLibrary // empty
 	}
@@ -459,31 +645,455 @@ func (g *generator) generateLib(pkg *goPackage, embed string) *rule.Rule {
 	return goLibrary
 }
 
+// preserveGeneratedSrcs copies srcs that name targets, rather than files,
+// from an existing go_library with the same name as lib. These are usually
+// genrules or other rules that generate Go code at build time. Gazelle
+// can't find that code on disk, so without this, it would remove the srcs,
+// or delete the library if it has no other sources. The existing library's
+// deps are kept, too, since Gazelle can't see imports in generated code.
+func (g *generator) preserveGeneratedSrcs(lib *rule.Rule, pkg *goPackage) {
+	if g.file == nil {
+		return
+	}
+	var old *rule.Rule
+	for _, r := range g.file.Rules {
+		if r.Kind() == "go_library" && r.Name() == lib.Name() {
+			old = r
+			break
+		}
+	}
+	if old == nil {
+		return
+	}
+	srcs := lib.AttrStrings("srcs")
+	srcSet := make(map[string]bool)
+	for _, src := range srcs {
+		srcSet[src] = true
+	}
+	targets := make(map[string]bool)
+	for _, r := range g.file.Rules {
+		// Rules with outputs already in srcs were found with the other
+		// generated files. Their names don't need to be listed, too.
+		outs := r.AttrStrings("outs")
+		if out := r.AttrString("out"); out != "" {
+			outs = append(outs, out)
+		}
+		found := false
+		for _, out := range outs {
+			if srcSet[out] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			targets[r.Name()] = true
+		}
+	}
+	var genSrcs []string
+	for _, src := range old.AttrStrings("srcs") {
+		if isTargetSrc(src, g.rel, targets) {
+			genSrcs = append(genSrcs, src)
+		}
+	}
+	if len(genSrcs) == 0 {
+		return
+	}
+
+	if len(srcs) == 0 {
+		// The library has no sources on disk, so generateLib returned an
+		// empty rule. Fill in the attributes it would have set.
+		importPath := old.AttrString("importpath")
+		if importPath == "" {
+			importPath = pkg.importPath
+		}
+		if g.shouldSetVisibility {
+			lib.SetAttr("visibility", g.commonVisibility(importPath))
+		}
+		g.setImportAttrs(lib, importPath)
+		if _, ok := lib.PrivateAttr(config.GazelleImportsKey).(rule.PlatformStrings); !ok {
+			lib.SetPrivateAttr(config.GazelleImportsKey, rule.PlatformStrings{})
+		}
+	}
+	lib.SetAttr("srcs", append(srcs, genSrcs...))
+	if deps := old.AttrStrings("deps"); len(deps) > 0 {
+		lib.SetPrivateAttr(generatedSrcsDepsKey, deps)
+	}
+}
+
+// isTargetSrc returns whether src, an element of srcs in the package rel,
+// is a label that names a target rather than a source file. Labels in
+// other packages are assumed to name targets, since Gazelle never generates
+// them. Labels in the same package must match the name of a rule in
+// targets.
+func isTargetSrc(src, rel string, targets map[string]bool) bool {
+	l, err := label.Parse(src)
+	if err != nil {
+		return false
+	}
+	if l.Repo != "" || !l.Relative && l.Pkg != rel {
+		return true
+	}
+	return targets[l.Name]
+}
+
 func (g *generator) generateBin(pkg *goPackage, library string) *rule.Rule {
-	name := pathtools.RelBaseName(pkg.rel, getGoConfig(g.c).prefix, g.c.RepoRoot)
+	name := g.binName(pkg, library)
 	goBinary := rule.NewRule("go_binary", name)
 	if !pkg.isCommand() || pkg.binary.sources.isEmpty() && library == "" {
 		return goBinary // empty
 	}
 	visibility := g.commonVisibility(pkg.importPath)
 	g.setCommonAttrs(goBinary, pkg.rel, visibility, pkg.binary, library)
+	g.setModeAttrs(goBinary)
 	return goBinary
 }
 
+// binName returns the name of the go_binary rule for pkg, following
+// # gazelle:go_binary_naming. If the cmd naming rule gives a name used by
+// the library, the test, or another rule in the build file, the directory
+// name is used instead.
+func (g *generator) binName(pkg *goPackage, library string) string {
+	gc := getGoConfig(g.c)
+	dirName := pathtools.RelBaseName(pkg.rel, gc.prefix, g.c.RepoRoot)
+	if gc.binaryNaming != binaryNamingCmd {
+		return dirName
+	}
+	name, ok := cmdBinName(pkg.rel, gc.prefix)
+	if !ok || name == dirName {
+		return dirName
+	}
+	collision := ""
+	if name == library || name == g.testName() {
+		collision = name
+	} else if g.file != nil {
+		for _, r := range g.file.Rules {
+			if r.Name() == name && r.Kind() != "go_binary" {
+				collision = r.Name()
+				break
+			}
+		}
+	}
+	if collision != "" {
+		log.Printf("%s: go_binary name %q from go_binary_naming cmd is already used by another rule; using %q", pkg.rel, name, dirName)
+		return dirName
+	}
+	return name
+}
+
+// cmdBinName returns the name of the command in the directory rel, which is
+// the name of the directory after the last "cmd" directory in rel. If rel
+// is a "cmd" directory itself, the command is named after its parent
+// directory, or after the module if the parent is the repository root.
+// false is returned if rel isn't in a "cmd" directory.
+func cmdBinName(rel, prefix string) (string, bool) {
+	if rel == "" {
+		return "", false
+	}
+	parts := strings.Split(rel, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] != "cmd" {
+			continue
+		}
+		if i+1 < len(parts) {
+			return parts[i+1], true
+		}
+		if i > 0 {
+			return parts[i-1], true
+		}
+		modPath := prefix
+		if p := pathWithoutSemver(modPath); p != "" {
+			modPath = p
+		}
+		if modPath == "" {
+			return "", false
+		}
+		return path.Base(modPath), true
+	}
+	return "", false
+}
+
+// generateTaggedBins generates a go_binary for each set of build tags
+// required by main files in pkg. The binary for main files that don't require
+// any tags gets the usual name; other binaries have the tags appended to the
+// name. An empty rule with the usual name is included if no binary takes it,
+// so an old binary can be deleted.
+func (g *generator) generateTaggedBins(pkg *goPackage, library string) []*rule.Rule {
+	baseName := g.binName(pkg, library)
+	visibility := g.commonVisibility(pkg.importPath)
+	var rules []*rule.Rule
+	haveBase := false
+	for _, tb := range pkg.taggedBinaries {
+		name := baseName
+		if len(tb.tags) > 0 {
+			name = baseName + "_" + strings.Join(tb.tags, "_")
+		} else {
+			haveBase = true
+		}
+		goBinary := rule.NewRule("go_binary", name)
+		rules = append(rules, goBinary)
+		if tb.target.sources.isEmpty() {
+			continue // empty
+		}
+		g.setCommonAttrs(goBinary, pkg.rel, visibility, tb.target, library)
+		g.setModeAttrs(goBinary)
+		if len(tb.tags) > 0 {
+			tags := goBinary.AttrStrings("gotags")
+			for _, t := range tb.tags {
+				if !containsString(tags, t) {
+					tags = append(tags, t)
+				}
+			}
+			goBinary.SetAttr("gotags", tags)
+		}
+	}
+	if !haveBase {
+		rules = append(rules, rule.NewRule("go_binary", baseName))
+	}
+	return rules
+}
+
+// generateCrossBins generates a go_cross_binary for each non-empty go_binary
+// in bins and each platform set with # gazelle:go_cross_platforms. Rules are
+// named after the binary and the platform, like "cmd_linux_arm64". Empty
+// rules are included for existing go_cross_binary rules named this way for
+// binaries or platforms that are no longer generated, so they're deleted.
+func (g *generator) generateCrossBins(bins []*rule.Rule) []*rule.Rule {
+	platforms := getGoConfig(g.c).crossPlatforms
+	var rules []*rule.Rule
+	generated := make(map[string]bool)
+	binNames := make(map[string]bool)
+	for _, bin := range bins {
+		binNames[bin.Name()] = true
+		if bin.IsEmpty(goKinds["go_binary"]) {
+			continue
+		}
+		for _, platform := range platforms {
+			r := rule.NewRule("go_cross_binary", crossBinaryName(bin.Name(), platform))
+			r.SetAttr("platform", platform)
+			r.SetAttr("target", ":"+bin.Name())
+			if visibility := bin.AttrStrings("visibility"); len(visibility) > 0 {
+				r.SetAttr("visibility", visibility)
+			}
+			rules = append(rules, r)
+			generated[r.Name()] = true
+		}
+	}
+	if g.file != nil {
+		for _, r := range g.file.Rules {
+			if r.Kind() != "go_cross_binary" || generated[r.Name()] {
+				continue
+			}
+			target := strings.TrimPrefix(r.AttrString("target"), ":")
+			if binNames[target] && r.Name() == crossBinaryName(target, r.AttrString("platform")) {
+				rules = append(rules, rule.NewRule("go_cross_binary", r.Name()))
+			}
+		}
+	}
+	return rules
+}
+
+// crossBinaryName returns the name of the go_cross_binary that builds the
+// go_binary bin for platform, a label.
+func crossBinaryName(bin, platform string) string {
+	name := platform
+	if l, err := label.Parse(platform); err == nil {
+		name = l.Name
+	}
+	return bin + "_" + name
+}
+
 func (g *generator) generateTest(pkg *goPackage, library string) *rule.Rule {
-	goTest := rule.NewRule("go_test", defaultTestName)
+	goTest := rule.NewRule("go_test", g.testName())
 	if !pkg.test.sources.hasGo() {
 		return goTest // empty
 	}
-	g.setCommonAttrs(goTest, pkg.rel, nil, pkg.test, library)
-	if pkg.hasTestdata {
-		goTest.SetAttr("data", rule.GlobValue{Patterns: []string{"testdata/**"}})
+	g.setCommonAttrs(goTest, pkg.rel, getGoConfig(g.c).defaultVisibility, pkg.test, library)
+	g.setTestdata(goTest, pkg)
+	g.setModeAttrs(goTest)
+	g.setTestDefaults(goTest)
+	g.setTestAttrs(goTest, pkg.rel)
+	return goTest
+}
+
+// setTestdata sets the data attribute of a go_test rule to the files in
+// pkg's testdata directory, as configured with # gazelle:go_testdata.
+func (g *generator) setTestdata(r *rule.Rule, pkg *goPackage) {
+	if !pkg.hasTestdata {
+		return
+	}
+	switch getGoConfig(g.c).testdataMode {
+	case testdataFilegroup:
+		r.SetAttr("data", []string{":" + testdataFilegroupName})
+	case testdataOff:
+	default:
+		r.SetAttr("data", rule.GlobValue{Patterns: []string{"testdata/**"}})
+	}
+}
+
+// generateTestdata generates a filegroup for the testdata directory in
+// # gazelle:go_testdata filegroup mode, if any go_test rule in rules
+// depends on it. Otherwise, if the existing file has a filegroup that
+// Gazelle generated earlier, an empty rule is returned so it's deleted.
+// A filegroup with the same name written by hand is left alone.
+func (g *generator) generateTestdata(pkg *goPackage, rules []*rule.Rule) *rule.Rule {
+	fg := rule.NewRule("filegroup", testdataFilegroupName)
+	if pkg.hasTestdata && getGoConfig(g.c).testdataMode == testdataFilegroup {
+		for _, r := range rules {
+			if r.Kind() == "go_test" && !r.IsEmpty(goKinds[r.Kind()]) {
+				fg.SetAttr("srcs", rule.GlobValue{Patterns: []string{"testdata/**"}})
+				return fg
+			}
+		}
+	}
+	if g.file != nil {
+		for _, r := range g.file.Rules {
+			if r.Kind() == "filegroup" && r.Name() == testdataFilegroupName && isTestdataGlob(r.Attr("srcs")) {
+				return fg // empty
+			}
+		}
 	}
+	return nil
+}
+
+// generateXTest generates a go_test rule for external test files, when
+// they're generated separately with # gazelle:go_test mode=split. External
+// tests import the library instead of embedding it.
+func (g *generator) generateXTest(pkg *goPackage) *rule.Rule {
+	goTest := rule.NewRule("go_test", g.xtestName())
+	if !pkg.xtest.sources.hasGo() {
+		return goTest // empty
+	}
+	g.setCommonAttrs(goTest, pkg.rel, getGoConfig(g.c).defaultVisibility, pkg.xtest, "")
+	g.setTestdata(goTest, pkg)
+	g.setModeAttrs(goTest)
+	g.setTestDefaults(goTest)
+	g.setTestAttrs(goTest, pkg.rel)
 	return goTest
 }
 
+// generateFuzzTests generates a go_test rule for each native fuzz test in
+// pkg when enabled with # gazelle:go_fuzz. Each rule runs one fuzz test
+// with "bazel run". Rules are tagged "manual", since fuzzing doesn't stop on
+// its own. Rules generated earlier for fuzz tests that no longer exist are
+// returned as empty rules, so they're deleted.
+func (g *generator) generateFuzzTests(pkg *goPackage, library string) []*rule.Rule {
+	var rules []*rule.Rule
+	generated := make(map[string]bool)
+	if getGoConfig(g.c).fuzz {
+		targets := []goTarget{pkg.test, pkg.xtest}
+		embeds := []string{library, ""}
+		for i, target := range targets {
+			for _, name := range target.fuzzTests {
+				if generated[name] {
+					continue
+				}
+				generated[name] = true
+				r := rule.NewRule("go_test", name)
+				g.setCommonAttrs(r, pkg.rel, getGoConfig(g.c).defaultVisibility, target, embeds[i])
+				g.setTestdata(r, pkg)
+				r.SetAttr("args", fuzzTestArgs(name))
+				r.SetAttr("gc_goopts", []string{"-d=libfuzzer"})
+				r.SetAttr("tags", []string{"fuzz", "manual"})
+				rules = append(rules, r)
+			}
+		}
+	}
+	if g.file != nil {
+		for _, r := range g.file.Rules {
+			if r.Kind() == "go_test" && !generated[r.Name()] && isFuzzTestRule(r) {
+				rules = append(rules, rule.NewRule("go_test", r.Name()))
+			}
+		}
+	}
+	return rules
+}
+
+// fuzzTestArgs returns the args attribute of a go_test rule generated for
+// the fuzz test name.
+func fuzzTestArgs(name string) []string {
+	return []string{"-test.fuzz=^" + name + "$", "-test.run=^" + name + "$"}
+}
+
+// isFuzzTestRule returns whether r looks like a rule generated by
+// generateFuzzTests.
+func isFuzzTestRule(r *rule.Rule) bool {
+	args := r.AttrStrings("args")
+	return len(args) > 0 && args[0] == fuzzTestArgs(r.Name())[0]
+}
+
+// xtestName returns the name of the go_test rule for external tests. It's
+// go_default_xtest, or the internal test's name with an "_xtest" suffix
+// instead of "_test" if a naming template is set.
+func (g *generator) xtestName() string {
+	name := g.testName()
+	if name == defaultTestName {
+		return defaultXTestName
+	}
+	return strings.TrimSuffix(name, "_test") + "_xtest"
+}
+
+// setTestDefaults sets attributes like size and timeout configured with the
+// go_test directive.
+func (g *generator) setTestDefaults(r *rule.Rule) {
+	for key, value := range getGoConfig(g.c).testDefaults {
+		if key == "shard_count" {
+			n, _ := strconv.Atoi(value)
+			r.SetAttr(key, n)
+		} else {
+			r.SetAttr(key, value)
+		}
+	}
+}
+
+// libName returns the name of the go_library rule for pkg. When a naming
+// template is set, an existing go_library with the same import path keeps
+// its name, so rules don't need to be renamed to adopt the template.
+func (g *generator) libName(pkg *goPackage) string {
+	gc := getGoConfig(g.c)
+	name := gc.libName(g.rel, g.c.RepoRoot)
+	if gc.libNameTemplate == "" || g.file == nil {
+		return name
+	}
+	for _, r := range g.file.Rules {
+		if r.Kind() == "go_library" && r.AttrString("importpath") == pkg.importPath {
+			return r.Name()
+		}
+	}
+	return name
+}
+
+// testName returns the name of the go_test rule. When a naming template is
+// set, an existing go_test keeps its name: either one named with the
+// template or go_default_test, or the only go_test in the file.
+func (g *generator) testName() string {
+	gc := getGoConfig(g.c)
+	name := gc.testName(g.rel, g.c.RepoRoot)
+	if gc.testNameTemplate == "" || g.file == nil {
+		return name
+	}
+	var tests []string
+	for _, r := range g.file.Rules {
+		if r.Kind() != "go_test" {
+			continue
+		}
+		if r.Name() == name || r.Name() == defaultTestName {
+			return r.Name()
+		}
+		tests = append(tests, r.Name())
+	}
+	if len(tests) == 1 {
+		return tests[0]
+	}
+	return name
+}
+
 func (g *generator) setCommonAttrs(r *rule.Rule, pkgRel string, visibility []string, target goTarget, embed string) {
-	if !target.sources.isEmpty() {
+	if target.sources.hasArchAsm() {
+		// Assembly is written for specific architectures, usually with Go
+		// declarations in files for the same architectures. Group sources
+		// by platform so each branch lists what's built there.
+		r.SetAttr("srcs", target.sources.build())
+	} else if !target.sources.isEmpty() {
 		r.SetAttr("srcs", target.sources.buildFlat())
 	}
 	if target.cgo {
@@ -502,6 +1112,59 @@ func (g *generator) setCommonAttrs(r *rule.Rule, pkgRel string, visibility []str
 		r.SetAttr("embed", []string{":" + embed})
 	}
 	r.SetPrivateAttr(config.GazelleImportsKey, target.imports.build())
+	if cgoSetting := getGoConfig(g.c).cgoSetting; cgoSetting != "" {
+		// Lets the merger replace selects on the setting in existing rules.
+		r.SetPrivateAttr(rule.ConditionsKey, []string{cgoSetting})
+	}
+	if target.cgo && !target.includes.isEmpty() {
+		r.SetPrivateAttr(cgoIncludesKey, target.includes.build())
+	}
+}
+
+// setModeAttrs sets mode attributes like race and pure configured with
+// the go_mode directive.
+func (g *generator) setModeAttrs(r *rule.Rule) {
+	for key, value := range getGoConfig(g.c).modeAttrs[r.Kind()] {
+		if key == "gotags" {
+			r.SetAttr(key, splitValue(value))
+		} else {
+			r.SetAttr(key, value)
+		}
+	}
+}
+
+// setTestAttrs sets env, args, and rundir attributes on a go_test rule in
+// the package rel, as configured with the go_test_attrs directive.
+func (g *generator) setTestAttrs(r *rule.Rule, rel string) {
+	var env map[string]string
+	var args []string
+	var rundir string
+	for _, a := range getGoConfig(g.c).testAttrs {
+		if !a.matches(rel) {
+			continue
+		}
+		for k, v := range a.env {
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[k] = v
+		}
+		if a.args != nil {
+			args = a.args
+		}
+		if a.rundir != "" {
+			rundir = a.rundir
+		}
+	}
+	if env != nil {
+		r.SetAttr("env", env)
+	}
+	if args != nil {
+		r.SetAttr("args", args)
+	}
+	if rundir != "" {
+		r.SetAttr("rundir", rundir)
+	}
 }
 
 func (g *generator) setImportAttrs(r *rule.Rule, importPath string) {
@@ -512,7 +1175,7 @@ func (g *generator) setImportAttrs(r *rule.Rule, importPath string) {
 	// If a package is part of a module with a v2+ semantic import version
 	// suffix, packages that are not part of modules may import it without
 	// the suffix.
-	if gc.goRepositoryMode && gc.moduleMode && pathtools.HasPrefix(importPath, gc.prefix) && gc.prefixRel == "" {
+	if gc.goRepositoryMode && gc.moduleMode && pathtools.HasPrefix(importPath, gc.prefix) && gc.prefixRel == "" && !gc.inOtherMajorVersion(importPath) {
 		if mmcImportPath := pathWithoutSemver(importPath); mmcImportPath != "" {
 			r.SetAttr("importpath_aliases", []string{mmcImportPath})
 		}
@@ -524,10 +1187,42 @@ func (g *generator) setImportAttrs(r *rule.Rule, importPath string) {
 		if importMap != importPath {
 			r.SetAttr("importmap", importMap)
 		}
+	} else if gc.inOtherMajorVersion(importPath) {
+		// This repository contains a copy of another major version of its
+		// module, which is also declared as a go_repository. Both copies may
+		// be linked into the same binary, so give this one a different
+		// importmap.
+		importMap := path.Join(label.ImportPathToBazelRepoName(gc.prefix), importPath)
+		r.SetAttr("importmap", importMap)
 	}
 }
 
+// inOtherMajorVersion returns whether importPath is in a module declared in
+// the repo config that is a different major version of the module being
+// generated in go_repository mode. For example, a repository for
+// example.com/mod fetched from version control may contain example.com/mod/v2
+// in its v2 subdirectory.
+func (gc *goConfig) inOtherMajorVersion(importPath string) bool {
+	if !gc.goRepositoryMode {
+		return false
+	}
+	base := gc.prefix
+	if b := pathWithoutSemver(base); b != "" {
+		base = b
+	}
+	for _, m := range gc.submodules {
+		if pathWithoutSemver(m.modulePath) == base && pathtools.HasPrefix(importPath, m.modulePath) {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *generator) commonVisibility(importPath string) []string {
+	if vis := getGoConfig(g.c).defaultVisibility; vis != nil {
+		return vis
+	}
+
 	// If the Bazel package name (rel) contains "internal", add visibility for
 	// subpackages of the parent.
 	// If the import path contains "internal" but rel does not, this is
@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
@@ -135,6 +136,84 @@ go_test(name = "go_default_test")
 	}
 }
 
+func TestGenerateRulesEmptyFileLibs(t *testing.T) {
+	c, langs, _ := testConfig(t)
+	goLang := langs[1].(*goLang)
+	f, err := rule.LoadData("foo/BUILD.bazel", "foo", []byte(`
+go_library(
+    name = "go_default_library_old",
+    srcs = ["old.go"],
+    visibility = ["//visibility:private"],
+)
+
+go_library(
+    name = "go_default_library_other",
+    srcs = ["other.go"],
+    importpath = "example.com/other",
+    visibility = ["//visibility:private"],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := goLang.GenerateRules(language.GenerateArgs{
+		Config: c,
+		Dir:    "./foo",
+		Rel:    "foo",
+		File:   f,
+	})
+	var got []string
+	for _, r := range res.Empty {
+		if r.Kind() == "go_library" {
+			got = append(got, r.Name())
+		}
+	}
+	want := []string{"go_default_library", "go_default_library_old"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got empty go_library rules %v; want %v", got, want)
+	}
+}
+
+func TestGenerateRulesReadsFS(t *testing.T) {
+	c, langs, _ := testConfig(t, "-go_prefix=example.com/repo")
+	goLang := langs[1].(*goLang)
+	fs := mapFS{
+		filepath.Join("foo", "foo.go"): "package foo\n\nimport _ \"example.com/bar\"\n",
+	}
+	walk.SetFS(c, fs)
+	res := goLang.GenerateRules(language.GenerateArgs{
+		Config:       c,
+		Dir:          "./foo",
+		Rel:          "foo",
+		RegularFiles: []string{"foo.go"},
+	})
+	if len(res.Gen) != 1 || res.Gen[0].Kind() != "go_library" {
+		t.Fatalf("got %d generated rules; want one go_library", len(res.Gen))
+	}
+	got := res.Imports[0].(rule.PlatformStrings).Generic
+	want := []string{"example.com/bar"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got imports %v; want %v", got, want)
+	}
+}
+
+// mapFS is a walk.FS that serves file contents from a map, keyed by path.
+// Other operations use the real file system.
+type mapFS map[string]string
+
+func (fs mapFS) ReadDir(name string) ([]os.FileInfo, error) { return walk.OSFS{}.ReadDir(name) }
+
+func (fs mapFS) Stat(name string) (os.FileInfo, error) { return walk.OSFS{}.Stat(name) }
+
+func (fs mapFS) ReadFile(name string) ([]byte, error) {
+	if content, ok := fs[name]; ok {
+		return []byte(content), nil
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs mapFS) EvalSymlinks(name string) (string, error) { return walk.OSFS{}.EvalSymlinks(name) }
+
 func TestGenerateRulesEmptyLegacyProto(t *testing.T) {
 	c, langs, _ := testConfig(t, "-proto=legacy")
 	goLang := langs[len(langs)-1].(*goLang)
@@ -247,6 +326,7 @@ func TestConsumedGenFiles(t *testing.T) {
 
 	gl := goLang{
 		goPkgRels: make(map[string]bool),
+		uses:      newPackageUses(),
 	}
 	gl.Configure(args.Config, "", nil)
 	res := gl.GenerateRules(args)
@@ -292,3 +372,25 @@ func convertImportsAttrs(f *rule.File) {
 		}
 	}
 }
+
+func TestCmdBinName(t *testing.T) {
+	for _, tc := range []struct {
+		rel, prefix, want string
+		ok                bool
+	}{
+		{rel: "cmd/foo", want: "foo", ok: true},
+		{rel: "cmd/foo/v2", want: "foo", ok: true},
+		{rel: "cmd/foo/main", want: "foo", ok: true},
+		{rel: "tools/cmd/bar", want: "bar", ok: true},
+		{rel: "tools/cmd", want: "tools", ok: true},
+		{rel: "cmd", prefix: "example.com/tool/v3", want: "tool", ok: true},
+		{rel: "cmd", ok: false},
+		{rel: "foo/v2", ok: false},
+		{rel: "", prefix: "example.com/tool", ok: false},
+	} {
+		got, ok := cmdBinName(tc.rel, tc.prefix)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("cmdBinName(%q, %q): got %q, %v; want %q, %v", tc.rel, tc.prefix, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -23,7 +23,6 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
-	"golang.org/x/sync/errgroup"
 )
 
 type goDepLockFile struct {
@@ -50,21 +49,16 @@ func importReposFromGodep(args language.ImportReposArgs) language.ImportReposRes
 		return language.ImportReposResult{Error: err}
 	}
 
-	var eg errgroup.Group
 	roots := make([]string, len(file.Deps))
-	for i := range file.Deps {
-		i := i
-		eg.Go(func() error {
-			p := file.Deps[i]
-			repoRoot, _, err := args.Cache.Root(p.ImportPath)
-			if err != nil {
-				return err
-			}
-			roots[i] = repoRoot
-			return nil
-		})
-	}
-	if err := eg.Wait(); err != nil {
+	errs := forEachLimited(len(file.Deps), getGoConfig(args.Config).importConcurrency, func(i int) error {
+		repoRoot, _, err := args.Cache.Root(file.Deps[i].ImportPath)
+		if err != nil {
+			return err
+		}
+		roots[i] = repoRoot
+		return nil
+	})
+	if err := importError(errs); err != nil {
 		return language.ImportReposResult{Error: err}
 	}
 
@@ -39,6 +39,13 @@ var goKinds = map[string]rule.KindInfo{
 		},
 		ResolveAttrs: map[string]bool{"deps": true},
 	},
+	"go_cross_binary": {
+		NonEmptyAttrs: map[string]bool{"target": true},
+		MergeableAttrs: map[string]bool{
+			"platform": true,
+			"target":   true,
+		},
+	},
 	"go_library": {
 		MatchAttrs: []string{"importpath"},
 		NonEmptyAttrs: map[string]bool{
@@ -60,15 +67,47 @@ var goKinds = map[string]rule.KindInfo{
 		},
 		ResolveAttrs: map[string]bool{"deps": true},
 	},
+	"go_grpc_library": {
+		MatchAttrs: []string{"importpath"},
+		NonEmptyAttrs: map[string]bool{
+			"deps":   true,
+			"embed":  true,
+			"proto":  true,
+			"protos": true,
+			"srcs":   true,
+		},
+		SubstituteAttrs: map[string]bool{
+			"proto":  true,
+			"protos": true,
+		},
+		MergeableAttrs: map[string]bool{
+			"srcs":       true,
+			"importpath": true,
+			"importmap":  true,
+			"cgo":        true,
+			"clinkopts":  true,
+			"copts":      true,
+			"embed":      true,
+			"proto":      true,
+			"protos":     true,
+			"compilers":  true,
+		},
+		ResolveAttrs:  map[string]bool{"deps": true},
+		ReplacesKinds: []string{"go_proto_library"},
+	},
 	"go_proto_library": {
 		MatchAttrs: []string{"importpath"},
 		NonEmptyAttrs: map[string]bool{
-			"deps":  true,
-			"embed": true,
-			"proto": true,
-			"srcs":  true,
+			"deps":   true,
+			"embed":  true,
+			"proto":  true,
+			"protos": true,
+			"srcs":   true,
+		},
+		SubstituteAttrs: map[string]bool{
+			"proto":  true,
+			"protos": true,
 		},
-		SubstituteAttrs: map[string]bool{"proto": true},
 		MergeableAttrs: map[string]bool{
 			"srcs":       true,
 			"importpath": true,
@@ -78,9 +117,11 @@ var goKinds = map[string]rule.KindInfo{
 			"copts":      true,
 			"embed":      true,
 			"proto":      true,
+			"protos":     true,
 			"compilers":  true,
 		},
-		ResolveAttrs: map[string]bool{"deps": true},
+		ResolveAttrs:  map[string]bool{"deps": true},
+		ReplacesKinds: []string{"go_grpc_library"},
 	},
 	"go_repository": {
 		MatchAttrs: []string{"importpath"},
@@ -118,6 +159,19 @@ var goKinds = map[string]rule.KindInfo{
 		},
 		ResolveAttrs: map[string]bool{"deps": true},
 	},
+	// local_repository rules are generated by update-repos for modules
+	// replaced with local directories in go.mod.
+	"local_repository": {
+		NonEmptyAttrs:  map[string]bool{"path": true},
+		MergeableAttrs: map[string]bool{"path": true},
+	},
+	// protoc_gen_openapiv2 rules are generated with
+	// # gazelle:go_grpc_gateway_openapi for protos with google.api.http
+	// annotations.
+	"protoc_gen_openapiv2": {
+		NonEmptyAttrs:  map[string]bool{"proto": true},
+		MergeableAttrs: map[string]bool{"proto": true},
+	},
 }
 
 var goLoads = []rule.LoadInfo{
@@ -126,6 +180,7 @@ var goLoads = []rule.LoadInfo{
 		Symbols: []string{
 			"cgo_library",
 			"go_binary",
+			"go_cross_binary",
 			"go_library",
 			"go_prefix",
 			"go_repository",
@@ -137,6 +192,11 @@ var goLoads = []rule.LoadInfo{
 			"go_grpc_library",
 			"go_proto_library",
 		},
+	}, {
+		Name: "@grpc_ecosystem_grpc_gateway//protoc-gen-openapiv2:defs.bzl",
+		Symbols: []string{
+			"protoc_gen_openapiv2",
+		},
 	}, {
 		Name: "@bazel_gazelle//:deps.bzl",
 		Symbols: []string{
@@ -0,0 +1,79 @@
+// Generated by language/go/gen_known_modules/gen_known_modules.go
+// From language/go/known_modules.txt
+
+package golang
+
+// knownModules maps paths of well-known modules to the names of the
+// go_repository rules that provide them.
+var knownModules = map[string]string{
+
+	"cloud.google.com/go":                    "com_google_cloud_go",
+	"cloud.google.com/go/bigquery":           "com_google_cloud_go_bigquery",
+	"cloud.google.com/go/bigtable":           "com_google_cloud_go_bigtable",
+	"cloud.google.com/go/compute/metadata":   "com_google_cloud_go_compute_metadata",
+	"cloud.google.com/go/datastore":          "com_google_cloud_go_datastore",
+	"cloud.google.com/go/firestore":          "com_google_cloud_go_firestore",
+	"cloud.google.com/go/iam":                "com_google_cloud_go_iam",
+	"cloud.google.com/go/logging":            "com_google_cloud_go_logging",
+	"cloud.google.com/go/pubsub":             "com_google_cloud_go_pubsub",
+	"cloud.google.com/go/spanner":            "com_google_cloud_go_spanner",
+	"cloud.google.com/go/storage":            "com_google_cloud_go_storage",
+	"github.com/bazelbuild/buildtools":       "com_github_bazelbuild_buildtools",
+	"github.com/bazelbuild/rules_go":         "com_github_bazelbuild_rules_go",
+	"github.com/davecgh/go-spew":             "com_github_davecgh_go_spew",
+	"github.com/fsnotify/fsnotify":           "com_github_fsnotify_fsnotify",
+	"github.com/gogo/protobuf":               "com_github_gogo_protobuf",
+	"github.com/golang/glog":                 "com_github_golang_glog",
+	"github.com/golang/mock":                 "com_github_golang_mock",
+	"github.com/golang/protobuf":             "com_github_golang_protobuf",
+	"github.com/google/go-cmp":               "com_github_google_go_cmp",
+	"github.com/google/uuid":                 "com_github_google_uuid",
+	"github.com/gorilla/mux":                 "com_github_gorilla_mux",
+	"github.com/grpc-ecosystem/grpc-gateway": "com_github_grpc_ecosystem_grpc_gateway",
+	"github.com/hashicorp/errwrap":           "com_github_hashicorp_errwrap",
+	"github.com/hashicorp/go-multierror":     "com_github_hashicorp_go_multierror",
+	"github.com/pelletier/go-toml":           "com_github_pelletier_go_toml",
+	"github.com/pkg/errors":                  "com_github_pkg_errors",
+	"github.com/pmezard/go-difflib":          "com_github_pmezard_go_difflib",
+	"github.com/prometheus/client_golang":    "com_github_prometheus_client_golang",
+	"github.com/prometheus/client_model":     "com_github_prometheus_client_model",
+	"github.com/prometheus/common":           "com_github_prometheus_common",
+	"github.com/prometheus/procfs":           "com_github_prometheus_procfs",
+	"github.com/sirupsen/logrus":             "com_github_sirupsen_logrus",
+	"github.com/spf13/cobra":                 "com_github_spf13_cobra",
+	"github.com/spf13/pflag":                 "com_github_spf13_pflag",
+	"github.com/spf13/viper":                 "com_github_spf13_viper",
+	"github.com/stretchr/objx":               "com_github_stretchr_objx",
+	"github.com/stretchr/testify":            "com_github_stretchr_testify",
+	"go.etcd.io/bbolt":                       "io_etcd_go_bbolt",
+	"go.opencensus.io":                       "io_opencensus_go",
+	"go.uber.org/atomic":                     "org_uber_go_atomic",
+	"go.uber.org/multierr":                   "org_uber_go_multierr",
+	"go.uber.org/zap":                        "org_uber_go_zap",
+	"golang.org/x/arch":                      "org_golang_x_arch",
+	"golang.org/x/crypto":                    "org_golang_x_crypto",
+	"golang.org/x/exp":                       "org_golang_x_exp",
+	"golang.org/x/exp/typeparams":            "org_golang_x_exp_typeparams",
+	"golang.org/x/image":                     "org_golang_x_image",
+	"golang.org/x/lint":                      "org_golang_x_lint",
+	"golang.org/x/mobile":                    "org_golang_x_mobile",
+	"golang.org/x/mod":                       "org_golang_x_mod",
+	"golang.org/x/net":                       "org_golang_x_net",
+	"golang.org/x/oauth2":                    "org_golang_x_oauth2",
+	"golang.org/x/sync":                      "org_golang_x_sync",
+	"golang.org/x/sys":                       "org_golang_x_sys",
+	"golang.org/x/term":                      "org_golang_x_term",
+	"golang.org/x/text":                      "org_golang_x_text",
+	"golang.org/x/time":                      "org_golang_x_time",
+	"golang.org/x/tools":                     "org_golang_x_tools",
+	"golang.org/x/tools/gopls":               "org_golang_x_tools_gopls",
+	"golang.org/x/xerrors":                   "org_golang_x_xerrors",
+	"google.golang.org/api":                  "org_golang_google_api",
+	"google.golang.org/appengine":            "org_golang_google_appengine",
+	"google.golang.org/genproto":             "org_golang_google_genproto",
+	"google.golang.org/grpc":                 "org_golang_google_grpc",
+	"google.golang.org/protobuf":             "org_golang_google_protobuf",
+	"gopkg.in/check.v1":                      "in_gopkg_check_v1",
+	"gopkg.in/yaml.v2":                       "in_gopkg_yaml_v2",
+	"gopkg.in/yaml.v3":                       "in_gopkg_yaml_v3",
+}
@@ -61,10 +61,25 @@ type goLang struct {
 	// goPkgDirs is a set of relative paths to directories containing buildable
 	// Go code, including in subdirectories.
 	goPkgRels map[string]bool
+
+	// uses records which packages are used by tests and which are used by
+	// other rules. Resolve uses this to infer testonly for libraries and to
+	// make main package libraries visible to their importers.
+	uses *packageUses
+
+	// linkedRels maps directories reached through symbolic links listed with
+	// # gazelle:follow to the real directories they point to in the
+	// repository. It's shared with goConfig.linkedRels so imports of packages
+	// in linked directories can be resolved to the real directories.
+	linkedRels map[string]string
 }
 
 func (_ *goLang) Name() string { return goName }
 
 func NewLanguage() language.Language {
-	return &goLang{goPkgRels: make(map[string]bool)}
+	return &goLang{
+		goPkgRels:  make(map[string]bool),
+		uses:       newPackageUses(),
+		linkedRels: make(map[string]string),
+	}
 }
@@ -18,8 +18,10 @@ package golang
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"go/build"
-	"io"
+	"go/parser"
+	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
@@ -27,10 +29,12 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
@@ -54,8 +58,15 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 	}
 	// path@version can be used as a unique identifier for looking up sums
 	pathToModule := map[string]*module{}
-	data, err := goListModules(tempDir)
+	// Modules replaced with local directories are provided by
+	// local_repository rules. They don't have versions or sums.
+	var localMods []*module
+	gc := getGoConfig(args.Config)
+	data, err := goListModules(tempDir, gc.offline)
 	if err != nil {
+		if gc.offline {
+			err = fmt.Errorf("listing modules offline: %v\ngo.mod files of some modules may be missing from the module cache; run 'go mod download' with network access first", err)
+		}
 		return language.ImportReposResult{Error: err}
 	}
 	dec := json.NewDecoder(bytes.NewReader(data))
@@ -69,8 +80,8 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 		}
 		if mod.Replace != nil {
 			if filepath.IsAbs(mod.Replace.Path) || build.IsLocalImport(mod.Replace.Path) {
-				log.Printf("go_repository does not support file path replacements for %s -> %s", mod.Path,
-					mod.Replace.Path)
+				// Relative paths were made absolute when go.mod was copied.
+				localMods = append(localMods, mod)
 				continue
 			}
 			pathToModule[mod.Replace.Path+"@"+mod.Replace.Version] = mod
@@ -80,32 +91,77 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 	}
 	// Load sums from go.sum. Ideally, they're all there.
 	goSumPath := filepath.Join(filepath.Dir(args.Path), "go.sum")
-	data, _ = ioutil.ReadFile(goSumPath)
-	lines := bytes.Split(data, []byte("\n"))
-	for _, line := range lines {
-		line = bytes.TrimSpace(line)
-		fields := bytes.Fields(line)
-		if len(fields) != 3 {
-			continue
-		}
-		path, version, sum := string(fields[0]), string(fields[1]), string(fields[2])
-		if strings.HasSuffix(version, "/go.mod") {
-			continue
-		}
-		if mod, ok := pathToModule[path+"@"+version]; ok {
+	for pathVer, sum := range readGoSum(goSumPath) {
+		if mod, ok := pathToModule[pathVer]; ok {
 			mod.Sum = sum
 		}
 	}
-	// If sums are missing, run go mod download to get them.
+	// If sums are missing, look them up in the checksum database if that's
+	// enabled. Otherwise, or if the lookup fails, run go mod download to
+	// get them. That downloads each module, which may be slow. In offline
+	// mode, sums may only come from the module cache.
 	var missingSumArgs []string
 	for pathVer, mod := range pathToModule {
 		if mod.Sum == "" {
 			missingSumArgs = append(missingSumArgs, pathVer)
 		}
 	}
+	if gc.offline {
+		var missing []string
+		for _, pathVer := range missingSumArgs {
+			i := strings.LastIndex(pathVer, "@")
+			if sum, ok := moduleCacheSum(pathVer[:i], pathVer[i+1:]); ok {
+				pathToModule[pathVer].Sum = sum
+			} else {
+				missing = append(missing, pathVer)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return language.ImportReposResult{Error: fmt.Errorf("sums are missing from go.sum and the module cache for modules, which can't be downloaded offline: %s", strings.Join(missing, ", "))}
+		}
+		missingSumArgs = nil
+	}
+	if gc.sumDBLookup && len(missingSumArgs) > 0 {
+		sumDBURL := sumDBURLFromEnv()
+		errs := forEachLimited(len(missingSumArgs), gc.importConcurrency, func(i int) error {
+			pathVer := missingSumArgs[i]
+			at := strings.LastIndex(pathVer, "@")
+			modPath, version := pathVer[:at], pathVer[at+1:]
+			if sumDBURL == "" || isNoSumDBModule(modPath) {
+				return nil
+			}
+			sum, err := sumDBLookup(sumDBURL, modPath, version)
+			if err != nil {
+				return err
+			}
+			pathToModule[pathVer].Sum = sum
+			return nil
+		})
+		for _, err := range errs {
+			log.Printf("%v; downloading module instead", err)
+		}
+		stillMissing := missingSumArgs[:0]
+		for _, pathVer := range missingSumArgs {
+			if pathToModule[pathVer].Sum == "" {
+				stillMissing = append(stillMissing, pathVer)
+			}
+		}
+		missingSumArgs = stillMissing
+	}
 	if len(missingSumArgs) > 0 {
 		data, err := goModDownload(tempDir, missingSumArgs)
 		if err != nil {
+			var private []string
+			for _, pathVer := range missingSumArgs {
+				if isNoSumDBModule(pathToModule[pathVer].Path) {
+					private = append(private, pathVer)
+				}
+			}
+			if len(private) > 0 {
+				sort.Strings(private)
+				err = fmt.Errorf("%v\nsums are missing from go.sum for private modules, which can't be verified with the checksum database: %s", err, strings.Join(private, ", "))
+			}
 			return language.ImportReposResult{Error: err}
 		}
 		dec = json.NewDecoder(bytes.NewReader(data))
@@ -121,7 +177,8 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 	}
 
 	// Translate to repository rules.
-	gen := make([]*rule.Rule, 0, len(pathToModule))
+	gen := make([]*rule.Rule, 0, len(pathToModule)+len(localMods))
+	genModPaths := make(map[*rule.Rule]string)
 	for pathVer, mod := range pathToModule {
 		if mod.Sum == "" {
 			log.Printf("could not determine sum for module %s", pathVer)
@@ -137,19 +194,194 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 			r.SetAttr("version", mod.Replace.Version)
 		}
 		gen = append(gen, r)
+		genModPaths[r] = mod.Path
+	}
+	for _, mod := range localMods {
+		r := localRepositoryRule(args.Config, mod.Path, filepath.Clean(mod.Replace.Path))
+		gen = append(gen, r)
+		genModPaths[r] = mod.Path
 	}
 	sort.Slice(gen, func(i, j int) bool {
 		return gen[i].Name() < gen[j].Name()
 	})
-	return language.ImportReposResult{Gen: gen}
+	res := language.ImportReposResult{Gen: gen}
+	if args.Group {
+		modPaths := make([]string, 0, len(gen))
+		for _, r := range gen {
+			modPaths = append(modPaths, genModPaths[r])
+		}
+		groups, err := groupModules(args.Path, modPaths)
+		if err != nil {
+			return language.ImportReposResult{Error: err}
+		}
+		res.Groups = make(map[string]language.RepoGroup)
+		for _, r := range gen {
+			res.Groups[r.Name()] = groups[genModPaths[r]]
+		}
+	}
+	return res
+}
+
+// groupModules sorts the modules in modPaths into groups by how the main
+// module uses them. A module is a direct dependency if a non-test source
+// in the main module imports one of its packages, or if go.mod requires it
+// without an "// indirect" comment and no source imports it (for example,
+// when it's only imported in files with build constraints). A module is a
+// test dependency if only tests import it. All other modules are transitive
+// dependencies.
+func groupModules(goModPath string, modPaths []string) (map[string]language.RepoGroup, error) {
+	data, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
+	required := goModRequires(data)
+	imports, err := moduleImports(filepath.Dir(goModPath))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]language.RepoGroup)
+	for imp, fromTest := range imports {
+		modPath := ""
+		for _, p := range modPaths {
+			if pathtools.HasPrefix(imp, p) && len(p) > len(modPath) {
+				modPath = p
+			}
+		}
+		if modPath == "" {
+			continue
+		}
+		if !fromTest {
+			groups[modPath] = language.RepoGroupDirect
+		} else if groups[modPath] == language.RepoGroupNone {
+			groups[modPath] = language.RepoGroupTest
+		}
+	}
+	for _, p := range modPaths {
+		if groups[p] != language.RepoGroupNone {
+			continue
+		}
+		if required[p] {
+			groups[p] = language.RepoGroupDirect
+		} else {
+			groups[p] = language.RepoGroupTransitive
+		}
+	}
+	return groups, nil
+}
+
+// goModRequire is a requirement in a go.mod file.
+type goModRequire struct {
+	path, version string
+	indirect      bool
+}
+
+// parseGoModRequires returns the requirements listed in a go.mod file.
+func parseGoModRequires(data []byte) []goModRequire {
+	var reqs []goModRequire
+	inBlock := false
+	for _, line := range strings.Split(string(data), "\n") {
+		var comment string
+		if i := strings.Index(line, "//"); i >= 0 {
+			line, comment = line[:i], strings.TrimSpace(line[i+len("//"):])
+		}
+		line = strings.TrimSpace(line)
+		if inBlock {
+			if line == ")" {
+				inBlock = false
+				continue
+			}
+		} else if strings.HasPrefix(line, "require") {
+			line = strings.TrimSpace(line[len("require"):])
+			if line == "(" {
+				inBlock = true
+				continue
+			}
+		} else {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		reqs = append(reqs, goModRequire{
+			path:     strings.Trim(fields[0], "\"`"),
+			version:  strings.Trim(fields[1], "\"`"),
+			indirect: comment == "indirect" || strings.HasPrefix(comment, "indirect;"),
+		})
+	}
+	return reqs
+}
+
+// goModRequires returns the set of module paths required in a go.mod file
+// without an "// indirect" comment.
+func goModRequires(data []byte) map[string]bool {
+	required := make(map[string]bool)
+	for _, req := range parseGoModRequires(data) {
+		if !req.indirect {
+			required[req.path] = true
+		}
+	}
+	return required
+}
+
+// moduleImports lists packages imported by Go files in the module rooted at
+// dir. The returned map is true for packages that are only imported by
+// tests. Vendor and testdata directories and nested modules are skipped.
+func moduleImports(dir string) (map[string]bool, error) {
+	imports := make(map[string]bool)
+	fset := token.NewFileSet()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		base := info.Name()
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if base == "vendor" || base == "testdata" || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(base, ".go") {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		isTest := strings.HasSuffix(base, "_test.go")
+		for _, spec := range f.Imports {
+			imp, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			if fromTest, ok := imports[imp]; !ok || fromTest {
+				imports[imp] = isTest
+			}
+		}
+		return nil
+	})
+	return imports, err
 }
 
 // goListModules invokes "go list" in a directory containing a go.mod file.
-var goListModules = func(dir string) ([]byte, error) {
+// If offline is true, the go command may only read modules from the module
+// cache.
+var goListModules = func(dir string, offline bool) ([]byte, error) {
 	goTool := findGoTool()
 	cmd := exec.Command(goTool, "list", "-m", "-json", "all")
 	cmd.Stderr = os.Stderr
 	cmd.Dir = dir
+	if offline {
+		cmd.Env = append(os.Environ(), "GOPROXY=off", "GOFLAGS=-mod=mod")
+	}
 	return cmd.Output()
 }
 
@@ -166,35 +398,28 @@ var goModDownload = func(dir string, args []string) ([]byte, error) {
 
 // copyGoModToTemp copies to given go.mod file to a temporary directory.
 // go list tends to mutate go.mod files, but gazelle shouldn't do that.
+// Replacements with relative directories are made absolute in the copy,
+// so they still point to the same directories.
 func copyGoModToTemp(filename string) (tempDir string, err error) {
-	goModOrig, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return "", err
 	}
-	defer goModOrig.Close()
-
-	tempDir, err = ioutil.TempDir("", "gazelle-temp-gomod")
+	dir, err := filepath.Abs(filepath.Dir(filename))
 	if err != nil {
 		return "", err
 	}
+	data = absReplacePaths(data, dir)
 
-	goModCopy, err := os.Create(filepath.Join(tempDir, "go.mod"))
+	tempDir, err = ioutil.TempDir("", "gazelle-temp-gomod")
 	if err != nil {
-		os.Remove(tempDir)
 		return "", err
 	}
-	defer func() {
-		if cerr := goModCopy.Close(); err == nil && cerr != nil {
-			err = cerr
-		}
-	}()
-
-	_, err = io.Copy(goModCopy, goModOrig)
-	if err != nil {
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "go.mod"), data, 0666); err != nil {
 		os.RemoveAll(tempDir)
 		return "", err
 	}
-	return tempDir, err
+	return tempDir, nil
 }
 
 // findGoTool attempts to locate the go executable. If GOROOT is set, we'll
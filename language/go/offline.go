@@ -0,0 +1,124 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+)
+
+// readGoSum reads sums of module zip files from a go.sum file. The returned
+// map is keyed by "path@version". Sums of go.mod files are not included.
+// An empty map is returned if the file can't be read.
+func readGoSum(goSumPath string) map[string]string {
+	sums := make(map[string]string)
+	data, _ := ioutil.ReadFile(goSumPath)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		path, version, sum := string(fields[0]), string(fields[1]), string(fields[2])
+		if strings.HasSuffix(version, "/go.mod") {
+			continue
+		}
+		sums[path+"@"+version] = sum
+	}
+	return sums
+}
+
+// goModCacheDir returns the directory of the module cache: $GOMODCACHE if
+// it's set, or pkg/mod in the first directory in $GOPATH.
+func goModCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := filepath.SplitList(build.Default.GOPATH)
+	if len(gopath) == 0 || gopath[0] == "" {
+		return ""
+	}
+	return filepath.Join(gopath[0], "pkg", "mod")
+}
+
+// moduleCacheSum returns the sum of a module that was downloaded into the
+// module cache, read from the .ziphash file the go command writes next to
+// the module's zip file. false is returned if the module isn't in the
+// cache.
+func moduleCacheSum(modPath, version string) (string, bool) {
+	cacheDir := goModCacheDir()
+	if cacheDir == "" {
+		return "", false
+	}
+	ziphash := filepath.Join(cacheDir, "cache", "download", filepath.FromSlash(repo.EscapeModulePath(modPath)), "@v", repo.EscapeModulePath(version)+".ziphash")
+	data, err := ioutil.ReadFile(ziphash)
+	if err != nil {
+		return "", false
+	}
+	sum := strings.TrimSpace(string(data))
+	return sum, sum != ""
+}
+
+// offlineModVersion finds the version and sum of a module requested on the
+// update-repos command line without using the network. If query is
+// "latest", the version required in go.mod in the repository root is used.
+// Otherwise, query must be a version. Sums are read from go.sum in the
+// repository root or from the module cache.
+func offlineModVersion(c *config.Config, modPath, query string) (name, version, sum string, err error) {
+	version = query
+	if query == "latest" {
+		data, err := ioutil.ReadFile(filepath.Join(c.RepoRoot, "go.mod"))
+		if err != nil {
+			return "", "", "", fmt.Errorf("finding version of %s offline: %v", modPath, err)
+		}
+		version = ""
+		for _, req := range parseGoModRequires(data) {
+			if req.path == modPath {
+				version = req.version
+			}
+		}
+		if version == "" {
+			return "", "", "", fmt.Errorf("finding version of %s offline: module is not required in go.mod; specify a version with %s@version", modPath, modPath)
+		}
+	} else if !strings.HasPrefix(query, "v") || strings.ContainsAny(query, "<>") {
+		return "", "", "", fmt.Errorf("finding version of %s offline: %q is not a version; queries can't be resolved without the network", modPath, query)
+	}
+
+	sum = readGoSum(filepath.Join(c.RepoRoot, "go.sum"))[modPath+"@"+version]
+	if sum == "" {
+		var ok bool
+		if sum, ok = moduleCacheSum(modPath, version); !ok {
+			return "", "", "", fmt.Errorf("finding sum of %s@%s offline: sum is not in go.sum or the module cache", modPath, version)
+		}
+	}
+
+	name = label.ImportPathToBazelRepoName(modPath)
+	for _, r := range c.Repos {
+		if r.Kind() == "go_repository" && r.AttrString("importpath") == modPath {
+			name = r.Name()
+			break
+		}
+	}
+	return name, version, sum, nil
+}
@@ -33,24 +33,56 @@ import (
 type goPackage struct {
 	name, dir, rel        string
 	library, binary, test goTarget
+	taggedBinaries        []taggedBinary
 	proto                 protoTarget
 	hasTestdata           bool
 	importPath            string
+
+	// xtest contains external test files when they're generated separately
+	// with # gazelle:go_test mode=split. Otherwise, they're in test.
+	xtest goTarget
+
+	// libraryFiles lists the files added to library when per-file libraries
+	// are generated with # gazelle:go_library_granularity file.
+	libraryFiles []fileInfo
+
+	// importComments lists library files with import comments, like
+	// `package foo // import "example.com/foo"`.
+	importComments []fileInfo
+}
+
+// taggedBinary contains main files of a command package that are only built
+// when a particular set of build tags is enabled. When main files in a
+// directory require different sets of tags, a separate go_binary is
+// generated for each set.
+type taggedBinary struct {
+	tags   []string
+	target goTarget
 }
 
 // goTarget contains information used to generate an individual Go rule
 // (library, binary, or test).
 type goTarget struct {
-	sources, imports, copts, clinkopts platformStringsBuilder
-	cgo                                bool
+	sources, imports, copts, clinkopts, includes platformStringsBuilder
+	cgo                                          bool
+
+	// fuzzTests is a list of native fuzz tests declared in test sources.
+	fuzzTests []string
 }
 
 // protoTarget contains information used to generate a go_proto_library rule.
 type protoTarget struct {
-	name        string
-	sources     platformStringsBuilder
-	imports     platformStringsBuilder
-	hasServices bool
+	name string
+
+	// protos lists the names of proto_library rules compiled together into
+	// one Go package. It's set in proto file mode when several .proto files
+	// have the same go_package. name is the first of them.
+	protos []string
+
+	sources      platformStringsBuilder
+	imports      platformStringsBuilder
+	hasServices  bool
+	hasHTTPRules bool
 }
 
 // platformStringsBuilder is used to construct rule.PlatformStrings. Bazel
@@ -59,6 +91,11 @@ type protoTarget struct {
 // to build these carefully.
 type platformStringsBuilder struct {
 	strs map[string]platformStringInfo
+
+	// cgoSetting, cgoStrs, and pureStrs hold strings from files that are
+	// only built when cgo is enabled or disabled. See go_cgo_setting.
+	cgoSetting        string
+	cgoStrs, pureStrs map[string]bool
 }
 
 // platformStringInfo contains information about a single string (source,
@@ -103,9 +140,19 @@ func (pkg *goPackage) addFile(c *config.Config, info fileInfo, cgo bool) error {
 		if info.isCgo {
 			return fmt.Errorf("%s: use of cgo in test not supported", info.path)
 		}
-		pkg.test.addFile(c, info)
+		if info.isExternalTest && getGoConfig(c).testMode == testModeSplit {
+			pkg.xtest.addFile(c, info)
+		} else {
+			pkg.test.addFile(c, info)
+		}
 	default:
 		pkg.library.addFile(c, info)
+		if info.importPath != "" {
+			pkg.importComments = append(pkg.importComments, info)
+		}
+		if getGoConfig(c).fileLibraries {
+			pkg.libraryFiles = append(pkg.libraryFiles, info)
+		}
 	}
 
 	return nil
@@ -130,6 +177,10 @@ func (pkg *goPackage) firstGoFile() string {
 		pkg.library.sources,
 		pkg.binary.sources,
 		pkg.test.sources,
+		pkg.xtest.sources,
+	}
+	for _, tb := range pkg.taggedBinaries {
+		goSrcs = append(goSrcs, tb.target.sources)
 	}
 	for _, sb := range goSrcs {
 		if sb.strs != nil {
@@ -144,9 +195,113 @@ func (pkg *goPackage) firstGoFile() string {
 }
 
 func (pkg *goPackage) haveCgo() bool {
+	for _, tb := range pkg.taggedBinaries {
+		if tb.target.cgo {
+			return true
+		}
+	}
 	return pkg.library.cgo || pkg.binary.cgo || pkg.test.cgo
 }
 
+// addMainFiles adds main files guarded by build tags to a command package.
+// infos must be non-test .go files. If the files require more than one set
+// of tags, each set gets its own taggedBinary, evaluated as if those tags
+// were enabled. Otherwise, the files are added to the library like any
+// other file.
+func (pkg *goPackage) addMainFiles(c *config.Config, infos []fileInfo) {
+	groups := make(map[string][]fileInfo)
+	tagsForKey := make(map[string][]string)
+	var keys []string
+	for _, info := range infos {
+		tags, _ := binaryTags(info)
+		key := strings.Join(tags, ",")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			tagsForKey[key] = tags
+		}
+		groups[key] = append(groups[key], info)
+	}
+	if len(keys) < 2 {
+		for _, info := range infos {
+			if err := pkg.addFile(c, info, false); err != nil {
+				log.Print(err)
+			}
+		}
+		return
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		tb := taggedBinary{tags: tagsForKey[key]}
+		tc := c
+		if len(tb.tags) > 0 {
+			tc = c.Clone()
+			gc := getGoConfig(c).clone()
+			for _, t := range tb.tags {
+				gc.genericTags[t] = true
+			}
+			tc.Exts[goName] = gc
+		}
+		for _, info := range groups[key] {
+			tb.target.addFile(tc, info)
+		}
+		pkg.taggedBinaries = append(pkg.taggedBinaries, tb)
+	}
+}
+
+// binaryTags returns a sorted list of build tags that must be enabled for
+// a file to be built, not counting OS, architecture, and other tags Gazelle
+// handles on its own. The second result is false if the file has no such
+// constraints. Tags are taken from the first group of each constraint
+// line; negated tags are left out, since they are satisfied by default.
+func binaryTags(info fileInfo) ([]string, bool) {
+	var tags []string
+	constrained := false
+	for _, line := range info.tags {
+		if !isCustomTagLine(line) {
+			continue
+		}
+		constrained = true
+		for _, t := range line[0] {
+			if strings.HasPrefix(t, "!") || !isCustomTag(t) {
+				continue
+			}
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	w := 0
+	for r, t := range tags {
+		if r == 0 || t != tags[w-1] {
+			tags[w] = t
+			w++
+		}
+	}
+	return tags[:w], constrained
+}
+
+// isCustomTagLine returns whether a build constraint line mentions any tag
+// accepted by isCustomTag.
+func isCustomTagLine(line tagLine) bool {
+	for _, group := range line {
+		for _, t := range group {
+			if isCustomTag(strings.TrimPrefix(t, "!")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCustomTag returns whether t is a build tag that users would enable
+// with -tags, as opposed to an OS, architecture, compiler, or release tag.
+func isCustomTag(t string) bool {
+	if isKnownOS(t) || isKnownArch(t) {
+		return false
+	}
+	return !isIgnoredTag(t) && t != "gc" && t != "gccgo"
+}
+
 func (pkg *goPackage) inferImportPath(c *config.Config) error {
 	if pkg.importPath != "" {
 		log.Panic("importPath already set")
@@ -166,6 +321,18 @@ func (pkg *goPackage) inferImportPath(c *config.Config) error {
 	return nil
 }
 
+// checkImportComments logs a warning for each library file with an import
+// comment that doesn't match the package's importpath. The go command
+// ignores import comments in module mode, so they go stale unnoticed when
+// a module is renamed.
+func (pkg *goPackage) checkImportComments() {
+	for _, info := range pkg.importComments {
+		if info.importPath != pkg.importPath {
+			log.Printf("%s: import comment %q does not match importpath %q; it may be stale", info.path, info.importPath, pkg.importPath)
+		}
+	}
+}
+
 func InferImportPath(c *config.Config, rel string) string {
 	gc := getGoConfig(c)
 	if rel == gc.prefixRel {
@@ -208,7 +375,9 @@ func (t *goTarget) addFile(c *config.Config, info fileInfo) {
 	t.cgo = t.cgo || info.isCgo
 	add := getPlatformStringsAddFunction(c, info, nil)
 	add(&t.sources, info.name)
+	t.fuzzTests = append(t.fuzzTests, info.fuzzTests...)
 	add(&t.imports, info.imports...)
+	add(&t.includes, info.includes...)
 	for _, copts := range info.copts {
 		optAdd := add
 		if len(copts.tags) > 0 {
@@ -225,8 +394,61 @@ func (t *goTarget) addFile(c *config.Config, info fileInfo) {
 	}
 }
 
-func protoTargetFromProtoPackage(name string, pkg proto.Package) protoTarget {
-	target := protoTarget{name: name}
+// groupFileModeProtos groups proto_library rules generated in proto file
+// mode by the Go package they're compiled into. Each .proto file has its
+// own proto_library, but files with the same go_package must be compiled
+// by one go_proto_library. For each group of several rules, the package of
+// the first rule in protoPackages is replaced with one that has the files,
+// imports, and options of the whole group, and the other rules are removed
+// from names. The returned map lists the rules in each group by the name
+// of the first one.
+func groupFileModeProtos(c *config.Config, rel string, names []string, protoPackages map[string]proto.Package) ([]string, map[string][]string) {
+	var importPaths []string
+	groups := make(map[string][]string)
+	for _, name := range names {
+		imp := goProtoImportPath(c, protoPackages[name], rel)
+		if _, ok := groups[imp]; !ok {
+			importPaths = append(importPaths, imp)
+		}
+		groups[imp] = append(groups[imp], name)
+	}
+
+	var grouped []string
+	protoGroups := make(map[string][]string)
+	for _, imp := range importPaths {
+		group := groups[imp]
+		first := group[0]
+		grouped = append(grouped, first)
+		if len(group) == 1 {
+			continue
+		}
+		merged := protoPackages[first]
+		merged.Files = make(map[string]proto.FileInfo)
+		merged.Imports = make(map[string]bool)
+		merged.Options = make(map[string]string)
+		for _, name := range group {
+			ppkg := protoPackages[name]
+			for f, info := range ppkg.Files {
+				merged.Files[f] = info
+			}
+			for i := range ppkg.Imports {
+				merged.Imports[i] = true
+			}
+			for k, v := range ppkg.Options {
+				merged.Options[k] = v
+			}
+			merged.HasServices = merged.HasServices || ppkg.HasServices
+			merged.HasHTTPRules = merged.HasHTTPRules || ppkg.HasHTTPRules
+		}
+		protoPackages[first] = merged
+		protoGroups[first] = group
+	}
+	sort.Strings(grouped)
+	return grouped, protoGroups
+}
+
+func protoTargetFromProtoPackage(name string, pkg proto.Package, protos []string) protoTarget {
+	target := protoTarget{name: name, protos: protos}
 	for f := range pkg.Files {
 		target.sources.addGenericString(f)
 	}
@@ -234,6 +456,7 @@ func protoTargetFromProtoPackage(name string, pkg proto.Package) protoTarget {
 		target.imports.addGenericString(i)
 	}
 	target.hasServices = pkg.HasServices
+	target.hasHTTPRules = pkg.HasHTTPRules
 	return target
 }
 
@@ -243,6 +466,7 @@ func (t *protoTarget) addFile(c *config.Config, info fileInfo) {
 		t.imports.addGenericString(imp)
 	}
 	t.hasServices = t.hasServices || info.hasServices
+	t.hasHTTPRules = t.hasHTTPRules || info.hasHTTPRules
 }
 
 // getPlatformStringsAddFunction returns a function used to add strings to
@@ -250,10 +474,18 @@ func (t *protoTarget) addFile(c *config.Config, info fileInfo) {
 // performance optimization to avoid evaluating constraints repeatedly.
 func getPlatformStringsAddFunction(c *config.Config, info fileInfo, cgoTags tagLine) func(sb *platformStringsBuilder, ss ...string) {
 	isOSSpecific, isArchSpecific := isOSArchSpecific(info, cgoTags)
+	gc := getGoConfig(c)
+	gp := gc.platforms
+
+	if gc.cgoSetting != "" && isCgoSpecific(info, cgoTags) {
+		if add := getCgoStringsAddFunction(c, info, cgoTags); add != nil {
+			return add
+		}
+	}
 
 	switch {
 	case !isOSSpecific && !isArchSpecific:
-		if checkConstraints(c, "", "", info.goos, info.goarch, info.tags, cgoTags) {
+		if checkConstraints(c, "", "", cgoAny, info.goos, info.goarch, info.tags, cgoTags) {
 			return func(sb *platformStringsBuilder, ss ...string) {
 				for _, s := range ss {
 					sb.addGenericString(s)
@@ -263,45 +495,45 @@ func getPlatformStringsAddFunction(c *config.Config, info fileInfo, cgoTags tagL
 
 	case isOSSpecific && !isArchSpecific:
 		var osMatch []string
-		for _, os := range rule.KnownOSs {
-			if checkConstraints(c, os, "", info.goos, info.goarch, info.tags, cgoTags) {
+		for _, os := range gp.oss {
+			if checkConstraints(c, os, "", cgoAny, info.goos, info.goarch, info.tags, cgoTags) {
 				osMatch = append(osMatch, os)
 			}
 		}
 		if len(osMatch) > 0 {
 			return func(sb *platformStringsBuilder, ss ...string) {
 				for _, s := range ss {
-					sb.addOSString(s, osMatch)
+					sb.addOSString(s, osMatch, gp)
 				}
 			}
 		}
 
 	case !isOSSpecific && isArchSpecific:
 		var archMatch []string
-		for _, arch := range rule.KnownArchs {
-			if checkConstraints(c, "", arch, info.goos, info.goarch, info.tags, cgoTags) {
+		for _, arch := range gp.archs {
+			if checkConstraints(c, "", arch, cgoAny, info.goos, info.goarch, info.tags, cgoTags) {
 				archMatch = append(archMatch, arch)
 			}
 		}
 		if len(archMatch) > 0 {
 			return func(sb *platformStringsBuilder, ss ...string) {
 				for _, s := range ss {
-					sb.addArchString(s, archMatch)
+					sb.addArchString(s, archMatch, gp)
 				}
 			}
 		}
 
 	default:
 		var platformMatch []rule.Platform
-		for _, platform := range rule.KnownPlatforms {
-			if checkConstraints(c, platform.OS, platform.Arch, info.goos, info.goarch, info.tags, cgoTags) {
+		for _, platform := range gp.platforms {
+			if checkConstraints(c, platform.OS, platform.Arch, cgoAny, info.goos, info.goarch, info.tags, cgoTags) {
 				platformMatch = append(platformMatch, platform)
 			}
 		}
 		if len(platformMatch) > 0 {
 			return func(sb *platformStringsBuilder, ss ...string) {
 				for _, s := range ss {
-					sb.addPlatformString(s, platformMatch)
+					sb.addPlatformString(s, platformMatch, gp)
 				}
 			}
 		}
@@ -310,12 +542,97 @@ func getPlatformStringsAddFunction(c *config.Config, info fileInfo, cgoTags tagL
 	return func(_ *platformStringsBuilder, _ ...string) {}
 }
 
+// getCgoStringsAddFunction returns a function that adds strings to the cgo
+// or pure strings of a *platformStringsBuilder, if the file's constraints
+// are satisfied on every platform with cgo enabled and on none with cgo
+// disabled, or vice versa. Otherwise, the constraints also depend on the
+// platform, which can't be expressed with concatenated selects, and nil
+// is returned so the strings are added as if cgo were not a dimension.
+func getCgoStringsAddFunction(c *config.Config, info fileInfo, cgoTags tagLine) func(sb *platformStringsBuilder, ss ...string) {
+	gc := getGoConfig(c)
+	onlyCgo, onlyPure := true, true
+	for _, platform := range gc.platforms.platforms {
+		on := checkConstraints(c, platform.OS, platform.Arch, cgoOn, info.goos, info.goarch, info.tags, cgoTags)
+		off := checkConstraints(c, platform.OS, platform.Arch, cgoOff, info.goos, info.goarch, info.tags, cgoTags)
+		onlyCgo = onlyCgo && on && !off
+		onlyPure = onlyPure && off && !on
+	}
+	switch {
+	case onlyCgo:
+		return func(sb *platformStringsBuilder, ss ...string) {
+			for _, s := range ss {
+				sb.addCgoString(gc.cgoSetting, s, true)
+			}
+		}
+	case onlyPure:
+		return func(sb *platformStringsBuilder, ss ...string) {
+			for _, s := range ss {
+				sb.addCgoString(gc.cgoSetting, s, false)
+			}
+		}
+	default:
+		return nil
+	}
+}
+
+// goPlatforms is the set of platforms that sources are included for in a
+// directory: rule.KnownPlatforms, adjusted by the go_wasm directive.
+type goPlatforms struct {
+	oss, archs       []string
+	platforms        []rule.Platform
+	osArchs, archOSs map[string][]string
+}
+
+// newGoPlatforms returns the platforms for a value of the go_wasm directive.
+// rule.OptionalPlatforms are only included if wasm is "true", and no
+// WebAssembly platforms are included if it's "false".
+func newGoPlatforms(wasm string) *goPlatforms {
+	platforms := rule.KnownPlatforms
+	if wasm == "true" {
+		platforms = append(platforms[:len(platforms):len(platforms)], rule.OptionalPlatforms...)
+		sort.Slice(platforms, func(i, j int) bool {
+			if platforms[i].OS != platforms[j].OS {
+				return platforms[i].OS < platforms[j].OS
+			}
+			return platforms[i].Arch < platforms[j].Arch
+		})
+	}
+	gp := &goPlatforms{
+		osArchs: make(map[string][]string),
+		archOSs: make(map[string][]string),
+	}
+	for _, p := range platforms {
+		if wasm == "false" && isWasmPlatform(p.OS, p.Arch) {
+			continue
+		}
+		gp.platforms = append(gp.platforms, p)
+		if gp.osArchs[p.OS] == nil {
+			gp.oss = append(gp.oss, p.OS)
+		}
+		if gp.archOSs[p.Arch] == nil {
+			gp.archs = append(gp.archs, p.Arch)
+		}
+		gp.osArchs[p.OS] = append(gp.osArchs[p.OS], p.Arch)
+		gp.archOSs[p.Arch] = append(gp.archOSs[p.Arch], p.OS)
+	}
+	sort.Strings(gp.oss)
+	sort.Strings(gp.archs)
+	return gp
+}
+
+// isWasmPlatform returns whether os or arch names a WebAssembly platform.
+// js and wasip1 only run on wasm, so they count as WebAssembly platforms
+// by themselves.
+func isWasmPlatform(os, arch string) bool {
+	return arch == "wasm" || os == "js" || os == "wasip1"
+}
+
 func (sb *platformStringsBuilder) isEmpty() bool {
-	return sb.strs == nil
+	return sb.strs == nil && sb.cgoStrs == nil && sb.pureStrs == nil
 }
 
 func (sb *platformStringsBuilder) hasGo() bool {
-	for s := range sb.strs {
+	for _, s := range sb.buildFlat() {
 		if strings.HasSuffix(s, ".go") {
 			return true
 		}
@@ -323,6 +640,17 @@ func (sb *platformStringsBuilder) hasGo() bool {
 	return false
 }
 
+// hasArchAsm returns whether sb contains an assembly file that is only
+// built on some architectures.
+func (sb *platformStringsBuilder) hasArchAsm() bool {
+	for s, si := range sb.strs {
+		if (si.set == archSet || si.set == platformSet) && (strings.HasSuffix(s, ".s") || strings.HasSuffix(s, ".S")) {
+			return true
+		}
+	}
+	return false
+}
+
 func (sb *platformStringsBuilder) addGenericString(s string) {
 	if sb.strs == nil {
 		sb.strs = make(map[string]platformStringInfo)
@@ -330,7 +658,25 @@ func (sb *platformStringsBuilder) addGenericString(s string) {
 	sb.strs[s] = platformStringInfo{set: genericSet}
 }
 
-func (sb *platformStringsBuilder) addOSString(s string, oss []string) {
+// addCgoString adds a string used only when cgo is enabled (if cgo is
+// true) or disabled (if cgo is false). cgoSetting is the label of the
+// config_setting that matches when cgo is enabled.
+func (sb *platformStringsBuilder) addCgoString(cgoSetting, s string, cgo bool) {
+	sb.cgoSetting = cgoSetting
+	if cgo {
+		if sb.cgoStrs == nil {
+			sb.cgoStrs = make(map[string]bool)
+		}
+		sb.cgoStrs[s] = true
+	} else {
+		if sb.pureStrs == nil {
+			sb.pureStrs = make(map[string]bool)
+		}
+		sb.pureStrs[s] = true
+	}
+}
+
+func (sb *platformStringsBuilder) addOSString(s string, oss []string, gp *goPlatforms) {
 	if sb.strs == nil {
 		sb.strs = make(map[string]platformStringInfo)
 	}
@@ -347,9 +693,9 @@ func (sb *platformStringsBuilder) addOSString(s string, oss []string) {
 			si.oss[os] = true
 		}
 	default:
-		si.convertToPlatforms()
+		si.convertToPlatforms(gp)
 		for _, os := range oss {
-			for _, arch := range rule.KnownOSArchs[os] {
+			for _, arch := range gp.osArchs[os] {
 				si.platforms[rule.Platform{OS: os, Arch: arch}] = true
 			}
 		}
@@ -357,7 +703,7 @@ func (sb *platformStringsBuilder) addOSString(s string, oss []string) {
 	sb.strs[s] = si
 }
 
-func (sb *platformStringsBuilder) addArchString(s string, archs []string) {
+func (sb *platformStringsBuilder) addArchString(s string, archs []string, gp *goPlatforms) {
 	if sb.strs == nil {
 		sb.strs = make(map[string]platformStringInfo)
 	}
@@ -374,9 +720,9 @@ func (sb *platformStringsBuilder) addArchString(s string, archs []string) {
 			si.archs[arch] = true
 		}
 	default:
-		si.convertToPlatforms()
+		si.convertToPlatforms(gp)
 		for _, arch := range archs {
-			for _, os := range rule.KnownArchOSs[arch] {
+			for _, os := range gp.archOSs[arch] {
 				si.platforms[rule.Platform{OS: os, Arch: arch}] = true
 			}
 		}
@@ -384,7 +730,7 @@ func (sb *platformStringsBuilder) addArchString(s string, archs []string) {
 	sb.strs[s] = si
 }
 
-func (sb *platformStringsBuilder) addPlatformString(s string, platforms []rule.Platform) {
+func (sb *platformStringsBuilder) addPlatformString(s string, platforms []rule.Platform, gp *goPlatforms) {
 	if sb.strs == nil {
 		sb.strs = make(map[string]platformStringInfo)
 	}
@@ -397,7 +743,7 @@ func (sb *platformStringsBuilder) addPlatformString(s string, platforms []rule.P
 	case genericSet:
 		return
 	default:
-		si.convertToPlatforms()
+		si.convertToPlatforms(gp)
 		for _, p := range platforms {
 			si.platforms[p] = true
 		}
@@ -450,19 +796,53 @@ func (sb *platformStringsBuilder) build() rule.PlatformStrings {
 			sort.Strings(ss)
 		}
 	}
+
+	// Strings also added without a cgo constraint don't need to be in the
+	// cgo select. Strings used with and without cgo are generic.
+	for s := range sb.cgoStrs {
+		if _, ok := sb.strs[s]; ok {
+			continue
+		}
+		if sb.pureStrs[s] {
+			ps.Generic = append(ps.Generic, s)
+		} else {
+			ps.Cgo = append(ps.Cgo, s)
+		}
+	}
+	for s := range sb.pureStrs {
+		if _, ok := sb.strs[s]; !ok && !sb.cgoStrs[s] {
+			ps.Pure = append(ps.Pure, s)
+		}
+	}
+	if ps.Cgo != nil || ps.Pure != nil {
+		ps.CgoSetting = sb.cgoSetting
+		sort.Strings(ps.Cgo)
+		sort.Strings(ps.Pure)
+	}
+	sort.Strings(ps.Generic)
 	return ps
 }
 
 func (sb *platformStringsBuilder) buildFlat() []string {
-	strs := make([]string, 0, len(sb.strs))
+	strs := make([]string, 0, len(sb.strs)+len(sb.cgoStrs)+len(sb.pureStrs))
 	for s := range sb.strs {
 		strs = append(strs, s)
 	}
+	for s := range sb.cgoStrs {
+		if _, ok := sb.strs[s]; !ok {
+			strs = append(strs, s)
+		}
+	}
+	for s := range sb.pureStrs {
+		if _, ok := sb.strs[s]; !ok && !sb.cgoStrs[s] {
+			strs = append(strs, s)
+		}
+	}
 	sort.Strings(strs)
 	return strs
 }
 
-func (si *platformStringInfo) convertToPlatforms() {
+func (si *platformStringInfo) convertToPlatforms(gp *goPlatforms) {
 	switch si.set {
 	case genericSet:
 		log.Panic("cannot convert generic string to platforms")
@@ -472,7 +852,7 @@ func (si *platformStringInfo) convertToPlatforms() {
 		si.set = platformSet
 		si.platforms = make(map[rule.Platform]bool)
 		for os := range si.oss {
-			for _, arch := range rule.KnownOSArchs[os] {
+			for _, arch := range gp.osArchs[os] {
 				si.platforms[rule.Platform{OS: os, Arch: arch}] = true
 			}
 		}
@@ -481,7 +861,7 @@ func (si *platformStringInfo) convertToPlatforms() {
 		si.set = platformSet
 		si.platforms = make(map[rule.Platform]bool)
 		for arch := range si.archs {
-			for _, os := range rule.KnownArchOSs[arch] {
+			for _, os := range gp.archOSs[arch] {
 				si.platforms[rule.Platform{OS: os, Arch: arch}] = true
 			}
 		}
@@ -0,0 +1,109 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// addPluginData parses the value of a go_plugin_data directive in the
+// directory rel. The value is a list of labels of Go plugins or shared
+// objects the package loads at run time with plugin.Open or dlopen.
+// Relative labels are relative to rel. An empty value clears the list.
+func (gc *goConfig) addPluginData(rel, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		gc.pluginData = nil
+		return nil
+	}
+	for _, s := range fields {
+		l, err := label.Parse(s)
+		if err != nil {
+			return fmt.Errorf("go_plugin_data: %v", err)
+		}
+		gc.pluginData = append(gc.pluginData, l.Abs("", rel))
+	}
+	return nil
+}
+
+// setPluginData adds the labels set with # gazelle:go_plugin_data to the
+// data attribute of the package's go_library in gen, or of its go_test if
+// there is no library. Data of a library is included in the runfiles of
+// binaries and tests that depend on it.
+//
+// Like tags, data is not a mergeable attribute, so labels added by hand are
+// never removed. If a rule in f will be replaced by the generated rule and
+// already has a data list, missing labels are appended to it directly.
+// Rules and data lists marked with # keep are not changed.
+func setPluginData(c *config.Config, rel string, f *rule.File, gen []*rule.Rule) {
+	pluginData := getGoConfig(c).pluginData
+	if len(pluginData) == 0 {
+		return
+	}
+	var target *rule.Rule
+	for _, r := range gen {
+		if r.Kind() == "go_library" {
+			target = r
+			break
+		}
+		if r.Kind() == "go_test" && target == nil {
+			target = r
+		}
+	}
+	if target == nil {
+		return
+	}
+	from := label.New(c.RepoName, rel, target.Name())
+	var data []string
+	for _, l := range pluginData {
+		data = append(data, relLabel(c, l, from))
+	}
+
+	var old *rule.Rule
+	if f != nil {
+		old = existingRule(c, f, target)
+	}
+	if old == nil || old.Attr("data") == nil {
+		if existing := target.AttrStrings("data"); len(existing) > 0 || target.Attr("data") == nil {
+			target.SetAttr("data", append(existing, data...))
+		}
+		return
+	}
+	list, ok := old.Attr("data").(*bzl.ListExpr)
+	if !ok || old.ShouldKeep() || rule.ShouldKeep(list) {
+		return
+	}
+	have := make(map[string]bool)
+	for _, s := range listStrings(list) {
+		have[s.Value] = true
+	}
+	added := false
+	for _, d := range data {
+		if !have[d] {
+			list.List = append(list.List, &bzl.StringExpr{Value: d})
+			added = true
+		}
+	}
+	if added {
+		old.SetAttr("data", list)
+	}
+}
@@ -0,0 +1,78 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// isNoSumDBModule returns whether the checksum database should not be
+// consulted for the module path modPath, according to GONOSUMDB or, if that's
+// not set, GOPRIVATE. Sums for these modules can only come from go.sum or
+// from downloading the module.
+func isNoSumDBModule(modPath string) bool {
+	patterns, ok := os.LookupEnv("GONOSUMDB")
+	if !ok {
+		patterns = os.Getenv("GOPRIVATE")
+	}
+	return matchPrefixPatterns(patterns, modPath)
+}
+
+// matchPrefixPatterns reports whether any path prefix of target matches one
+// of the glob patterns in the comma-separated list globs, as with the
+// GOPRIVATE, GONOPROXY, and GONOSUMDB environment variables. Each pattern
+// is matched with path.Match against the leading elements of target, as
+// many as the pattern has, so "*" never matches across a slash and "..."
+// has no special meaning. For example, "github.com/corp/*,gitlab.corp.com"
+// matches "github.com/corp/repo/sub" and "gitlab.corp.com/team/repo".
+// Empty patterns and malformed patterns are ignored.
+func matchPrefixPatterns(globs, target string) bool {
+	for globs != "" {
+		var glob string
+		if i := strings.Index(globs, ","); i >= 0 {
+			glob, globs = globs[:i], globs[i+1:]
+		} else {
+			glob, globs = globs, ""
+		}
+		glob = strings.TrimSuffix(strings.TrimSpace(glob), "/")
+		if glob == "" {
+			continue
+		}
+
+		// A pattern with n elements matches the first n elements of target.
+		n := strings.Count(glob, "/")
+		prefix := target
+		for i := 0; i < len(target); i++ {
+			if target[i] == '/' {
+				if n == 0 {
+					prefix = target[:i]
+					break
+				}
+				n--
+			}
+		}
+		if n > 0 {
+			// target has fewer elements than the pattern.
+			continue
+		}
+		if ok, _ := path.Match(glob, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"go/build"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// absReplacePaths rewrites replace directives in the go.mod file data that
+// point to relative directories, like "replace example.com/foo => ../foo",
+// so they point to absolute directories instead. dir is the directory
+// containing the go.mod file. This lets the go command load the module
+// graph from a copy of go.mod in another directory.
+func absReplacePaths(data []byte, dir string) []byte {
+	var buf bytes.Buffer
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 2 && fields[0] == "replace" && fields[1] == "(":
+			inBlock = true
+		case inBlock && len(fields) > 0 && fields[0] == ")":
+			inBlock = false
+		case inBlock || len(fields) > 0 && fields[0] == "replace":
+			line = absReplacePath(line, dir)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// absReplacePath rewrites the target of a single replace directive, if it's
+// a relative directory.
+func absReplacePath(line, dir string) string {
+	i := strings.Index(line, "=>")
+	if i < 0 {
+		return line
+	}
+	rest := line[i+len("=>"):]
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return line
+	}
+	target, err := unquoteModField(fields[0])
+	if err != nil || !build.IsLocalImport(target) {
+		return line
+	}
+	j := strings.Index(rest, fields[0])
+	abs := filepath.Join(dir, filepath.FromSlash(target))
+	return line[:i+len("=>")] + rest[:j] + strconv.Quote(abs) + rest[j+len(fields[0]):]
+}
+
+// localRepositoryRule returns a local_repository rule for the module
+// modPath, replaced in go.mod with the directory dir. The rule's path is
+// relative to the repository root when dir is inside it. The directory
+// must be a Bazel repository with its own build files; Gazelle can
+// generate them there.
+func localRepositoryRule(c *config.Config, modPath, dir string) *rule.Rule {
+	path := dir
+	if rel, err := filepath.Rel(c.RepoRoot, dir); c.RepoRoot != "" && err == nil {
+		path = filepath.ToSlash(rel)
+	}
+	if !isBazelRepository(walk.GetFS(c), dir) {
+		log.Printf("%s: replacement directory %s has no WORKSPACE or MODULE.bazel file; add one to use it with local_repository", modPath, dir)
+	}
+	r := rule.NewRule("local_repository", label.ImportPathToBazelRepoName(modPath))
+	r.SetAttr("path", path)
+	return r
+}
+
+// isBazelRepository returns whether dir contains a file that marks the
+// root of a Bazel repository.
+func isBazelRepository(fs walk.FS, dir string) bool {
+	for _, name := range []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel"} {
+		if _, err := fs.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readLocalModules returns the modules provided by local_repository rules
+// in repos that point to directories containing go.mod files. Rules whose
+// directories can't be read are ignored.
+func readLocalModules(fs walk.FS, repoRoot string, repos []*rule.Rule) []moduleRepo {
+	var mods []moduleRepo
+	for _, r := range repos {
+		if r.Kind() != "local_repository" {
+			continue
+		}
+		dir := filepath.FromSlash(r.AttrString("path"))
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(repoRoot, dir)
+		}
+		data, err := fs.ReadFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			continue
+		}
+		modPath, err := parseModulePath(data)
+		if err != nil {
+			continue
+		}
+		mods = append(mods, moduleRepo{repoName: r.Name(), modulePath: modPath})
+	}
+	return mods
+}
+
+// resolveLocalModule resolves imp to a package in a module provided by a
+// local_repository rule. The module with the longest matching path is used.
+func resolveLocalModule(gc *goConfig, imp string) (label.Label, bool) {
+	var best moduleRepo
+	for _, m := range gc.localModules {
+		if pathtools.HasPrefix(imp, m.modulePath) && len(m.modulePath) > len(best.modulePath) {
+			best = m
+		}
+	}
+	if best.modulePath == "" {
+		return label.NoLabel, false
+	}
+	pkg := pathtools.TrimPrefix(imp, best.modulePath)
+	return label.New(best.repoName, pkg, defaultLibName), true
+}
@@ -21,23 +21,33 @@ import (
 	"go/build"
 	"log"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
-func (_ *goLang) Imports(_ *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+func (gl *goLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	gl.uses.recordIndexed(c, r, f)
 	if !isGoLibrary(r.Kind()) {
 		return nil
 	}
 	if importPath := r.AttrString("importpath"); importPath == "" {
 		return []resolve.ImportSpec{}
+	} else if isShadowedProtoLibrary(c, r, f) {
+		// Not importable. An empty list would still let the rule be imported
+		// with the Go package indexed for its proto_library.
+		return nil
 	} else {
 		return []resolve.ImportSpec{{
 			Lang: goName,
@@ -46,10 +56,46 @@ func (_ *goLang) Imports(_ *config.Config, r *rule.Rule, f *rule.File) []resolve
 	}
 }
 
+// RemovePackage forgets what was recorded about the package pkg while
+// generating and indexing its rules.
+func (gl *goLang) RemovePackage(pkg string) {
+	delete(gl.goPkgRels, pkg)
+	gl.uses.removePackage(pkg)
+	delete(gl.linkedRels, pkg)
+}
+
+// isShadowedProtoLibrary returns whether r is a go_proto_library with the
+// same import path as a go_library in f that doesn't embed it. With
+// # gazelle:go_protoc_output both, the go_library is built from checked-in
+// protoc output, and only it should be indexed, so imports aren't ambiguous.
+func isShadowedProtoLibrary(c *config.Config, r *rule.Rule, f *rule.File) bool {
+	if getGoConfig(c).protocOutput != protocOutputBoth || !isGoProtoLibrary(r.Kind()) || f == nil {
+		return false
+	}
+	importPath := r.AttrString("importpath")
+	for _, other := range f.Rules {
+		if other.Kind() != "go_library" || other.AttrString("importpath") != importPath {
+			continue
+		}
+		embedsProto := false
+		for _, e := range other.AttrStrings("embed") {
+			if e == ":"+r.Name() {
+				embedsProto = true
+				break
+			}
+		}
+		if !embedsProto {
+			return true
+		}
+	}
+	return false
+}
+
 func (_ *goLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
 	embedStrings := r.AttrStrings("embed")
 	if isGoProtoLibrary(r.Kind()) {
 		embedStrings = append(embedStrings, r.AttrString("proto"))
+		embedStrings = append(embedStrings, r.AttrStrings("protos")...)
 	}
 	embedLabels := make([]label.Label, 0, len(embedStrings))
 	for _, s := range embedStrings {
@@ -63,6 +109,46 @@ func (_ *goLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
 	return embedLabels
 }
 
+// relLabel formats l for use in an attribute of the rule from. Normally, l
+// is relative to from's repository and package. With
+// # gazelle:go_label_style qualified, labels in from's repository also name
+// it, so they can be copied to other repositories unchanged.
+func relLabel(c *config.Config, l, from label.Label) string {
+	if !getGoConfig(c).qualifiedLabels || l.Relative {
+		return l.Rel(from.Repo, from.Pkg).String()
+	}
+	if l.Repo == "" {
+		l.Repo = c.RepoName
+	}
+	return l.String()
+}
+
+// extraDeps returns labels set with # gazelle:go_extra_deps for rules of
+// the given kind, relative to from. Labels for kinds mapped to kind with
+// # gazelle:map_kind are included. A rule never depends on itself.
+func extraDeps(c *config.Config, kind string, from label.Label) []string {
+	gc := getGoConfig(c)
+	if len(gc.extraDeps) == 0 {
+		return nil
+	}
+	kinds := []string{kind}
+	for fromKind, mapped := range c.KindMap {
+		if mapped.KindName == kind {
+			kinds = append(kinds, fromKind)
+		}
+	}
+	var deps []string
+	for _, k := range kinds {
+		for _, l := range gc.extraDeps[k] {
+			if l.Equal(from) {
+				continue
+			}
+			deps = append(deps, relLabel(c, l, from))
+		}
+	}
+	return deps
+}
+
 func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, importsRaw interface{}, from label.Label) {
 	if importsRaw == nil {
 		// may not be set in tests.
@@ -70,30 +156,62 @@ func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remo
 	}
 	imports := importsRaw.(rule.PlatformStrings)
 	r.DelAttr("deps")
-	resolve := ResolveGo
-	if r.Kind() == "go_proto_library" {
-		resolve = resolveProto
+	resolveFunc := resolveGo
+	if isGoProtoLibrary(r.Kind()) {
+		resolveFunc = resolveProtoOutcome
 	}
 	deps, errs := imports.Map(func(imp string) (string, error) {
-		l, err := resolve(c, ix, rc, imp, from)
+		l, outcome, err := resolveFunc(c, ix, rc, imp, from)
 		if err == skipImportError {
+			if outcome != "" {
+				resolve.RecordOutcome(c, "go", outcome)
+			}
 			return "", nil
 		} else if err != nil {
+			resolve.RecordOutcome(c, "go", resolve.OutcomeUnresolved)
 			return "", err
 		}
 		for _, embed := range gl.Embeds(r, from) {
 			if embed.Equal(l) {
+				resolve.RecordOutcome(c, "go", resolve.OutcomeSelfImport)
 				return "", nil
 			}
 		}
-		l = l.Rel(from.Repo, from.Pkg)
-		return l.String(), nil
+		resolve.RecordOutcome(c, "go", outcome)
+		return relLabel(c, l, from), nil
 	})
 	for _, err := range errs {
 		log.Print(err)
 	}
+	var extra []string
+	if kept, ok := r.PrivateAttr(generatedSrcsDepsKey).([]string); ok {
+		extra = append(extra, kept...)
+	}
+	extra = append(extra, extraDeps(c, r.Kind(), from)...)
+	if len(extra) > 0 {
+		have := make(map[string]bool)
+		for _, dep := range deps.Flat() {
+			have[dep] = true
+		}
+		for _, dep := range extra {
+			if !have[dep] {
+				deps.Generic = append(deps.Generic, dep)
+				have[dep] = true
+			}
+		}
+		sort.Strings(deps.Generic)
+	}
+	if includes, ok := r.PrivateAttr(cgoIncludesKey).(rule.PlatformStrings); ok {
+		r.DelAttr("cdeps")
+		cdeps, _ := includes.MapSlice(func(incs []string) ([]string, error) {
+			return resolveCgoIncludes(c, ix, incs, from), nil
+		})
+		if !cdeps.IsEmpty() {
+			r.SetAttr("cdeps", cdeps)
+		}
+	}
 	if !deps.IsEmpty() {
-		if r.Kind() == "go_proto_library" {
+		if isGoProtoLibrary(r.Kind()) {
 			// protos may import the same library multiple times by different names,
 			// so we need to de-duplicate them. Protos are not platform-specific,
 			// so it's safe to just flatten them.
@@ -102,6 +220,15 @@ func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remo
 			r.SetAttr("deps", deps)
 		}
 	}
+	if old, ok := r.PrivateAttr(existingRuleKey).(*rule.Rule); ok {
+		checkUnusedDeps(c, old, r, from)
+	}
+	if r.Kind() == "go_library" && getGoConfig(c).inferTestonly && gl.uses.isTestOnly(r, imports, from) {
+		r.SetAttr("testonly", true)
+	}
+	if lib, ok := r.PrivateAttr(mainLibraryKey).(*rule.Rule); ok {
+		gl.setMainLibraryVisibility(c, lib, r.AttrString("importpath"), from)
+	}
 }
 
 var (
@@ -116,25 +243,42 @@ var (
 // This may be used directly by other language extensions related to Go
 // (gomock). Gazelle calls Language.Resolve instead.
 func ResolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	l, _, err := resolveGo(c, ix, rc, imp, from)
+	return l, err
+}
+
+// resolveGo is like ResolveGo, but it also returns how imp was resolved.
+// The outcome is empty for standard library imports, and it's only
+// meaningful when the error is nil or skipImportError.
+func resolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, resolve.Outcome, error) {
 	gc := getGoConfig(c)
 	pcMode := getProtoMode(c)
 	if build.IsLocalImport(imp) {
 		cleanRel := path.Clean(path.Join(from.Pkg, imp))
 		if build.IsLocalImport(cleanRel) {
-			return label.NoLabel, fmt.Errorf("relative import path %q from %q points outside of repository", imp, from.Pkg)
+			return label.NoLabel, resolve.OutcomeUnresolved, fmt.Errorf("relative import path %q from %q points outside of repository", imp, from.Pkg)
 		}
 		imp = path.Join(gc.prefix, cleanRel)
 	}
 
 	if IsStandard(imp) {
-		return label.NoLabel, skipImportError
+		return label.NoLabel, "", skipImportError
 	}
 
 	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: "go", Imp: imp}, "go"); ok {
-		return l, nil
+		return l, resolve.OutcomeOverride, nil
 	}
 
-	if pcMode.ShouldUseKnownImports() {
+	if l, ok := gc.findImportMapping(imp); ok {
+		return l, resolve.OutcomeOverride, nil
+	}
+
+	// Packages listed in vendor/modules.txt are resolved to vendor, even if
+	// they'd usually be resolved to a well-known external repository.
+	_, vendored := gc.vendorPackages[imp]
+	vendored = vendored && gc.depMode == vendorMode
+
+	if pcMode.ShouldUseKnownImports() && !vendored {
 		// These are commonly used libraries that depend on Well Known Types.
 		// They depend on the generated versions of these protos to avoid conflicts.
 		// However, since protoc-gen-go depends on these libraries, we generate
@@ -143,39 +287,49 @@ func ResolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, im
 		// pre-generated versions of the proto libraries.
 		switch imp {
 		case "github.com/golang/protobuf/proto":
-			return label.New("com_github_golang_protobuf", "proto", "go_default_library"), nil
+			return label.New("com_github_golang_protobuf", "proto", "go_default_library"), resolve.OutcomeExternal, nil
 		case "github.com/golang/protobuf/jsonpb":
-			return label.New("com_github_golang_protobuf", "jsonpb", "go_default_library_gen"), nil
+			return label.New("com_github_golang_protobuf", "jsonpb", "go_default_library_gen"), resolve.OutcomeExternal, nil
 		case "github.com/golang/protobuf/descriptor":
-			return label.New("com_github_golang_protobuf", "descriptor", "go_default_library_gen"), nil
+			return label.New("com_github_golang_protobuf", "descriptor", "go_default_library_gen"), resolve.OutcomeExternal, nil
 		case "github.com/golang/protobuf/ptypes":
-			return label.New("com_github_golang_protobuf", "ptypes", "go_default_library_gen"), nil
+			return label.New("com_github_golang_protobuf", "ptypes", "go_default_library_gen"), resolve.OutcomeExternal, nil
 		case "github.com/golang/protobuf/protoc-gen-go/generator":
-			return label.New("com_github_golang_protobuf", "protoc-gen-go/generator", "go_default_library_gen"), nil
+			return label.New("com_github_golang_protobuf", "protoc-gen-go/generator", "go_default_library_gen"), resolve.OutcomeExternal, nil
 		case "google.golang.org/grpc":
-			return label.New("org_golang_google_grpc", "", "go_default_library"), nil
+			return label.New("org_golang_google_grpc", "", "go_default_library"), resolve.OutcomeExternal, nil
 		}
 		if l, ok := knownGoProtoImports[imp]; ok {
-			return l, nil
+			return l, resolve.OutcomeExternal, nil
 		}
 	}
 
-	if l, err := resolveWithIndexGo(ix, imp, from); err == nil || err == skipImportError {
-		return l, err
+	if l, err := resolveWithIndexGo(c, ix, imp, from); err == nil || err == skipImportError {
+		return l, indexOutcome(err), err
 	} else if err != notFoundError {
-		return label.NoLabel, err
+		return label.NoLabel, resolve.OutcomeUnresolved, err
+	} else if realImp, ok := canonicalImportPath(c, imp); ok {
+		return resolveGo(c, ix, rc, realImp, from)
+	}
+
+	if pcMode.ShouldGenerateRules() {
+		if l, err := resolveWithProtoIndexGo(c, ix, imp, from); err == nil || err == skipImportError {
+			return l, indexOutcome(err), err
+		} else if err != notFoundError {
+			return label.NoLabel, resolve.OutcomeUnresolved, err
+		}
 	}
 
 	// Special cases for rules_go and bazel_gazelle.
 	// These have names that don't following conventions and they're
 	// typeically declared with http_archive, not go_repository, so Gazelle
-	// won't recognize them.
-	if pathtools.HasPrefix(imp, "github.com/bazelbuild/rules_go") {
+	// won't recognize them. Vendored copies are used instead if present.
+	if !vendored && pathtools.HasPrefix(imp, "github.com/bazelbuild/rules_go") {
 		pkg := pathtools.TrimPrefix(imp, "github.com/bazelbuild/rules_go")
-		return label.New("io_bazel_rules_go", pkg, "go_default_library"), nil
-	} else if pathtools.HasPrefix(imp, "github.com/bazelbuild/bazel-gazelle") {
+		return label.New("io_bazel_rules_go", pkg, "go_default_library"), resolve.OutcomeExternal, nil
+	} else if !vendored && pathtools.HasPrefix(imp, "github.com/bazelbuild/bazel-gazelle") {
 		pkg := pathtools.TrimPrefix(imp, "github.com/bazelbuild/bazel-gazelle")
-		return label.New("bazel_gazelle", pkg, "go_default_library"), nil
+		return label.New("bazel_gazelle", pkg, "go_default_library"), resolve.OutcomeExternal, nil
 	}
 
 	if !c.IndexLibraries {
@@ -183,15 +337,81 @@ func ResolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, im
 		// current repo
 		if pathtools.HasPrefix(imp, gc.prefix) {
 			pkg := path.Join(gc.prefixRel, pathtools.TrimPrefix(imp, gc.prefix))
-			return label.New("", pkg, defaultLibName), nil
+			return label.New("", pkg, gc.libName(pkg, c.RepoRoot)), resolve.OutcomeLocal, nil
 		}
 	}
 
 	if gc.depMode == externalMode {
-		return resolveExternal(gc.moduleMode, rc, imp)
+		if l, ok := resolveLocalModule(gc, imp); ok {
+			return l, resolve.OutcomeExternal, nil
+		}
+		l, err := resolveExternal(gc.moduleMode, gc.majorVersionNaming, rc, imp)
+		if err != nil && gc.moduleFallback {
+			if l, ferr := resolveModuleFallback(gc, rc, imp, from); ferr == nil {
+				return l, resolve.OutcomeExternal, nil
+			}
+		}
+		return l, resolve.OutcomeExternal, err
 	} else {
-		return resolveVendored(rc, imp)
+		l, err := resolveVendored(c, rc, imp, from)
+		if err != nil {
+			return l, resolve.OutcomeUnresolved, err
+		}
+		return l, resolve.OutcomeVendored, nil
+	}
+}
+
+// setMainLibraryVisibility makes lib, the library generated for a main
+// package, visible to the other packages that import it or depend on it by
+// label. Gazelle makes these libraries private when it generates them, so
+// without this, the dependencies could not be built. Packages are listed one
+// at a time with __pkg__, and packages that no longer use the library are
+// removed. Entries for packages that weren't visited in this run are kept
+// if their directories still exist. Visibility that doesn't look like this
+// (for example, set by hand) is not changed.
+func (gl *goLang) setMainLibraryVisibility(c *config.Config, lib *rule.Rule, importPath string, from label.Label) {
+	seen := make(map[string]bool)
+	var vis []string
+	add := func(pkg string) {
+		if v := label.New("", pkg, "__pkg__").String(); !seen[v] {
+			seen[v] = true
+			vis = append(vis, v)
+		}
+	}
+	for _, v := range lib.AttrStrings("visibility") {
+		if v == "//visibility:private" {
+			continue
+		}
+		l, err := label.Parse(v)
+		if err != nil || l.Repo != "" || l.Relative || l.Name != "__pkg__" {
+			return
+		}
+		if gl.uses.isRecorded(l.Pkg) {
+			continue
+		}
+		if _, err := walk.GetFS(c).Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(l.Pkg))); err == nil {
+			add(l.Pkg)
+		}
+	}
+	for _, pkg := range gl.uses.importers(importPath, from) {
+		if pkg != from.Pkg {
+			add(pkg)
+		}
+	}
+	if len(vis) == 0 {
+		vis = []string{"//visibility:private"}
 	}
+	sort.Strings(vis)
+	lib.SetAttr("visibility", vis)
+}
+
+// indexOutcome returns the outcome of a successful lookup in the index.
+// err is nil or skipImportError.
+func indexOutcome(err error) resolve.Outcome {
+	if err == skipImportError {
+		return resolve.OutcomeSelfImport
+	}
+	return resolve.OutcomeIndex
 }
 
 // IsStandard returns whether a package is in the standard library.
@@ -199,12 +419,14 @@ func IsStandard(imp string) bool {
 	return stdPackages[imp]
 }
 
-func resolveWithIndexGo(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
-	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: "go", Imp: imp}, "go")
+func resolveWithIndexGo(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	spec := resolve.ImportSpec{Lang: "go", Imp: imp}
+	matches := ix.FindRulesByImport(spec, "go")
 	var bestMatch resolve.FindResult
 	var bestMatchIsVendored bool
 	var bestMatchVendorRoot string
 	var matchError error
+	var ambiguous []label.Label
 
 	for _, m := range matches {
 		// Apply vendoring logic for Go libraries. A library in a vendor directory
@@ -233,15 +455,23 @@ func resolveWithIndexGo(ix *resolve.RuleIndex, imp string, from label.Label) (la
 			bestMatchIsVendored = isVendored
 			bestMatchVendorRoot = vendorRoot
 			matchError = nil
+			ambiguous = nil
 		} else if (!isVendored && bestMatchIsVendored) || (isVendored && len(vendorRoot) < len(bestMatchVendorRoot)) {
 			// Current match is worse
 		} else {
 			// Match is ambiguous
 			// TODO: consider listing all the ambiguous rules here.
 			matchError = fmt.Errorf("rule %s imports %q which matches multiple rules: %s and %s. # gazelle:resolve may be used to disambiguate", from, imp, bestMatch.Label, m.Label)
+			if len(ambiguous) == 0 {
+				ambiguous = append(ambiguous, bestMatch.Label)
+			}
+			ambiguous = append(ambiguous, m.Label)
 		}
 	}
 	if matchError != nil {
+		if l, ok := resolve.ChooseRule(c, spec, "go", from, ambiguous); ok {
+			return l, nil
+		}
 		return label.NoLabel, matchError
 	}
 	if bestMatch.Label.Equal(label.NoLabel) {
@@ -253,9 +483,48 @@ func resolveWithIndexGo(ix *resolve.RuleIndex, imp string, from label.Label) (la
 	return bestMatch.Label, nil
 }
 
+// resolveWithProtoIndexGo looks for a proto_library whose generated Go
+// package has the import path imp. proto_library rules are indexed by the
+// import paths in their go_package options, so this works even when no
+// .pb.go files are checked in and the go_proto_library hasn't been indexed
+// under imp. The returned label names the go_proto_library Gazelle generates
+// for the proto_library.
+func resolveWithProtoIndexGo(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: protoGoImportLang, Imp: imp}, goName)
+	if len(matches) == 0 {
+		return label.NoLabel, notFoundError
+	}
+	if len(matches) > 1 {
+		return label.NoLabel, fmt.Errorf("rule %s imports %q which matches multiple proto rules: %s and %s. # gazelle:resolve may be used to disambiguate", from, imp, matches[0].Label, matches[1].Label)
+	}
+	m := matches[0].Label
+	l := label.New(m.Repo, m.Pkg, strings.TrimSuffix(m.Name, "_proto")+"_go_proto")
+	if l.Equal(from) {
+		return label.NoLabel, skipImportError
+	}
+	return l, nil
+}
+
+// goPackageImportPath returns the Go import path of the .pb.go files that
+// will be generated for pkg, according to its go_package option. "" is
+// returned if the option is not set or only names the package.
+func goPackageImportPath(pkg proto.Package) string {
+	opt, ok := pkg.Options["go_package"]
+	if !ok {
+		return ""
+	}
+	if i := strings.IndexByte(opt, ';'); i >= 0 {
+		opt = opt[:i]
+	}
+	if !strings.Contains(opt, "/") {
+		return ""
+	}
+	return opt
+}
+
 var modMajorRex = regexp.MustCompile(`/v\d+(?:/|$)`)
 
-func resolveExternal(moduleMode bool, rc *repo.RemoteCache, imp string) (label.Label, error) {
+func resolveExternal(moduleMode bool, naming string, rc *repo.RemoteCache, imp string) (label.Label, error) {
 	// If we're in module mode, use "go list" to find the module path and
 	// repository name. Otherwise, use special cases (for github.com, golang.org)
 	// or send a GET with ?go-get=1 to find the root. If the path contains
@@ -268,15 +537,29 @@ func resolveExternal(moduleMode bool, rc *repo.RemoteCache, imp string) (label.L
 		moduleMode = pathWithoutSemver(imp) != ""
 	}
 
-	var prefix, repo string
-	var err error
-	if moduleMode {
-		prefix, repo, err = rc.Mod(imp)
-	} else {
-		prefix, repo, err = rc.Root(imp)
+	// Repositories declared in WORKSPACE take precedence over well-known
+	// modules, since they may have custom names. Well-known modules are
+	// resolved without accessing the network.
+	prefix, repo, declared := rc.Known(imp)
+	ok := declared
+	if !ok {
+		prefix, repo, ok = lookupKnownModule(imp)
 	}
-	if err != nil {
-		return label.NoLabel, err
+	if !ok {
+		var err error
+		if moduleMode {
+			prefix, repo, err = rc.Mod(imp)
+		} else {
+			prefix, repo, err = rc.Root(imp)
+		}
+		if err != nil {
+			return label.NoLabel, err
+		}
+	}
+	if !declared && repo == label.ImportPathToBazelRepoName(prefix) {
+		// The repository isn't declared, so its name follows the
+		// -major_version_naming policy update-repos would use.
+		repo = repoNameForModule(naming, prefix)
 	}
 
 	var pkg string
@@ -291,33 +574,167 @@ func resolveExternal(moduleMode bool, rc *repo.RemoteCache, imp string) (label.L
 	return label.New(repo, pkg, defaultLibName), nil
 }
 
-func resolveVendored(rc *repo.RemoteCache, imp string) (label.Label, error) {
-	return label.New("", path.Join("vendor", imp), defaultLibName), nil
+// canonicalImportPath returns the import path of the package in the
+// directory a symbolic link points to, if imp is the import path of a
+// package in this repository that's reached through a followed link to
+// another directory in the repository. Rules are only generated in the real
+// directory. ok is false if imp isn't reached through such a link.
+func canonicalImportPath(c *config.Config, imp string) (realImp string, ok bool) {
+	gc := getGoConfig(c)
+	if gc.prefix == "" || !pathtools.HasPrefix(imp, gc.prefix) {
+		return "", false
+	}
+	rel := path.Join(gc.prefixRel, pathtools.TrimPrefix(imp, gc.prefix))
+	realRel, ok := gc.linkedRels[rel]
+	if !ok || !pathtools.HasPrefix(realRel, gc.prefixRel) {
+		return "", false
+	}
+	return path.Join(gc.prefix, pathtools.TrimPrefix(realRel, gc.prefixRel)), true
+}
+
+// canonicalRel returns the slash-separated path of dir relative to the
+// repository root after evaluating symbolic links in dir. c.RepoRoot is
+// already canonical. ok is false if dir doesn't exist or isn't in the
+// repository after evaluating links.
+func canonicalRel(c *config.Config, dir string) (rel string, ok bool) {
+	realDir, err := walk.GetFS(c).EvalSymlinks(dir)
+	if err != nil {
+		return "", false
+	}
+	rel, err = filepath.Rel(c.RepoRoot, realDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	return rel, true
+}
+
+// lookupKnownModule returns the path and repository name of the well-known
+// module that provides imp, according to the knownModules table. If more
+// than one module could provide imp, the one with the longest path is
+// returned.
+func lookupKnownModule(imp string) (modPath, name string, ok bool) {
+	for prefix := imp; prefix != "." && prefix != "/"; prefix = path.Dir(prefix) {
+		if name, ok := knownModules[prefix]; ok {
+			return prefix, name, true
+		}
+	}
+	return "", "", false
+}
+
+// resolveModuleFallback looks for a module that provides imp by querying
+// the latest version of each prefix of imp with the go command, starting
+// with the longest. This is used when resolveExternal fails, for example,
+// because the import path doesn't follow a known pattern and the repository
+// root can't be found with a ?go-get=1 request. When a module is found,
+// a message is logged suggesting how to declare a go_repository for it.
+func resolveModuleFallback(gc *goConfig, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	for prefix := imp; strings.Contains(prefix, "/"); prefix = path.Dir(prefix) {
+		name, version, _, err := rc.ModVersion(prefix, "latest")
+		if err != nil {
+			continue
+		}
+		if gc.suggestedModules.add(prefix) {
+			log.Printf("%s: import %q is provided by module %s@%s, which may not be declared. To add a go_repository rule for it, run:\n\tgazelle update-repos %s@%s", from, imp, prefix, version, prefix, version)
+		}
+		return label.New(name, pathtools.TrimPrefix(imp, prefix), defaultLibName), nil
+	}
+	return label.NoLabel, fmt.Errorf("could not find a module providing %q", imp)
+}
+
+// moduleSuggestions is a set of module paths that have been reported by
+// resolveModuleFallback. It may be shared by concurrent callers.
+type moduleSuggestions struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// add records modPath and returns true if it had not been recorded before.
+func (s *moduleSuggestions) add(modPath string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[modPath] {
+		return false
+	}
+	s.seen[modPath] = true
+	return true
+}
+
+// resolveVendored resolves imp to a library in the vendor directory in the
+// repository root. If vendor/modules.txt is present, imp must be listed
+// there. The remote cache is not used.
+func resolveVendored(c *config.Config, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	if pkgs := getGoConfig(c).vendorPackages; pkgs != nil {
+		if _, ok := pkgs[imp]; !ok {
+			return label.NoLabel, fmt.Errorf("%s: import %q is not provided by any package in vendor/modules.txt; try running 'go mod vendor'", from, imp)
+		}
+	}
+	pkg := path.Join("vendor", imp)
+	return label.New("", pkg, getGoConfig(c).libName(pkg, c.RepoRoot)), nil
 }
 
 func resolveProto(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	l, _, err := resolveProtoOutcome(c, ix, rc, imp, from)
+	return l, err
+}
+
+// resolveProtoOutcome is like resolveProto, but it also returns how imp
+// was resolved. The outcome is empty for well known types that don't need
+// a dependency.
+func resolveProtoOutcome(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, resolve.Outcome, error) {
 	pcMode := getProtoMode(c)
+	useVendoredWKT := wellKnownProtos[imp] && useVendoredWellKnownTypes(c)
 
-	if wellKnownProtos[imp] {
-		return label.NoLabel, skipImportError
+	if wellKnownProtos[imp] && !useVendoredWKT {
+		return label.NoLabel, "", skipImportError
 	}
 
 	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: "proto", Imp: imp}, "go"); ok {
-		return l, nil
+		return l, resolve.OutcomeOverride, nil
+	}
+
+	protoLabel, indexed := proto.ExternalImportLabel(c, imp)
+
+	// Common protos in googleapis are resolved to the go_proto_library next
+	// to the proto_library the proto extension chose, so both refer to the
+	// same repository.
+	if l, ok := proto.GoogleapisLabel(c, imp); ok && !indexed {
+		l.Name = strings.TrimSuffix(l.Name, "_proto") + "_go_proto"
+		if l.Equal(from) {
+			return label.NoLabel, resolve.OutcomeSelfImport, skipImportError
+		}
+		return l, resolve.OutcomeExternal, nil
 	}
 
-	if l, ok := knownProtoImports[imp]; ok && pcMode.ShouldUseKnownImports() {
+	if l, ok := knownProtoImports[imp]; ok && !indexed && pcMode.ShouldUseKnownImports() && !useVendoredWKT {
 		if l.Equal(from) {
-			return label.NoLabel, skipImportError
+			return label.NoLabel, resolve.OutcomeSelfImport, skipImportError
 		} else {
-			return l, nil
+			return l, resolve.OutcomeExternal, nil
 		}
 	}
 
-	if l, err := resolveWithIndexProto(ix, imp, from); err == nil || err == skipImportError {
-		return l, err
+	if l, err := resolveWithIndexProto(c, ix, imp, from); err == nil || err == skipImportError {
+		return l, indexOutcome(err), err
 	} else if err != notFoundError {
-		return label.NoLabel, err
+		return label.NoLabel, resolve.OutcomeUnresolved, err
+	}
+
+	// If the Go package of the imported file was set with proto_go_package,
+	// resolve it like a Go import. The file is likely in another repository,
+	// where the guess below would be wrong.
+	if goImp, ok := protoGoImportPath(c, imp); ok {
+		return resolveGo(c, ix, rc, goImp, from)
+	}
+
+	// If the file is in another repository listed in a proto_import_index,
+	// assume the go_proto_library is named after the proto_library there.
+	if indexed {
+		name := strings.TrimSuffix(protoLabel.Name, "_proto") + "_go_proto"
+		return label.New(protoLabel.Repo, protoLabel.Pkg, name), resolve.OutcomeExternal, nil
 	}
 
 	// As a fallback, guess the label based on the proto file name. We assume
@@ -330,8 +747,11 @@ func resolveProto(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache,
 	}
 	if from.Pkg == "vendor" || strings.HasPrefix(from.Pkg, "vendor/") {
 		rel = path.Join("vendor", rel)
+		return label.New("", rel, getGoConfig(c).libName(rel, c.RepoRoot)), resolve.OutcomeVendored, nil
+	} else if repo, ok := bufDepsRepo(c); ok {
+		return label.New(repo, rel, getGoConfig(c).libName(rel, c.RepoRoot)), resolve.OutcomeExternal, nil
 	}
-	return label.New("", rel, defaultLibName), nil
+	return label.New("", rel, getGoConfig(c).libName(rel, c.RepoRoot)), resolve.OutcomeLocal, nil
 }
 
 // wellKnownProtos is the set of proto sets for which we don't need to add
@@ -353,12 +773,16 @@ var wellKnownProtos = map[string]bool{
 	"google/protobuf/wrappers.proto":        true,
 }
 
-func resolveWithIndexProto(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
-	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: "proto", Imp: imp}, "go")
+func resolveWithIndexProto(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	spec := resolve.ImportSpec{Lang: "proto", Imp: imp}
+	matches := ix.FindRulesByImport(spec, "go")
 	if len(matches) == 0 {
 		return label.NoLabel, notFoundError
 	}
 	if len(matches) > 1 {
+		if l, ok := resolve.ChooseRule(c, spec, "go", from, findResultLabels(matches)); ok {
+			return l, nil
+		}
 		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
 	}
 	if matches[0].IsSelfImport(from) {
@@ -367,6 +791,108 @@ func resolveWithIndexProto(ix *resolve.RuleIndex, imp string, from label.Label)
 	return matches[0].Label, nil
 }
 
+// findResultLabels returns the labels of rules in matches.
+func findResultLabels(matches []resolve.FindResult) []label.Label {
+	labels := make([]label.Label, len(matches))
+	for i, m := range matches {
+		labels[i] = m.Label
+	}
+	return labels
+}
+
+// ForeignImports indexes rules owned by other extensions that Go rules may
+// depend on. cc_library rules are indexed by the headers they provide, which
+// lets cgo packages resolve #include lines to "cdeps". The Go extension
+// doesn't own the cc_library kind, so this works whether a C++ extension
+// owns it or nothing does. proto_library rules generated in this run are
+// indexed by the import paths in their go_package options.
+func (*goLang) ForeignImports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if r.Kind() == "cc_library" {
+		return ccLibraryImports(r, f)
+	}
+	if pkg, ok := r.PrivateAttr(proto.PackageKey).(proto.Package); ok {
+		if imp := goPackageImportPath(pkg); imp != "" {
+			return []resolve.ImportSpec{{Lang: protoGoImportLang, Imp: imp}}
+		}
+	}
+	return nil
+}
+
+// ccLibraryImports returns the paths that headers in a cc_library's "hdrs"
+// may be included with. By default, headers are included with their paths
+// relative to the repository root. The "strip_include_prefix",
+// "include_prefix", and "includes" attributes are also taken into account.
+func ccLibraryImports(r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	stripPrefix := r.AttrString("strip_include_prefix")
+	if strings.HasPrefix(stripPrefix, "/") {
+		stripPrefix = strings.TrimPrefix(stripPrefix, "/")
+	} else if stripPrefix != "" {
+		stripPrefix = path.Join(f.Pkg, stripPrefix)
+	}
+	includePrefix := r.AttrString("include_prefix")
+	virtual := r.Attr("strip_include_prefix") != nil || includePrefix != ""
+	if virtual && r.Attr("strip_include_prefix") == nil {
+		stripPrefix = f.Pkg
+	}
+
+	var imps []resolve.ImportSpec
+	for _, hdr := range r.AttrStrings("hdrs") {
+		if strings.HasPrefix(hdr, "//") || strings.HasPrefix(hdr, "@") {
+			continue
+		}
+		hdr = path.Join(f.Pkg, strings.TrimPrefix(hdr, ":"))
+		if virtual {
+			if stripPrefix != "" && !pathtools.HasPrefix(hdr, stripPrefix) {
+				continue
+			}
+			imp := path.Join(includePrefix, pathtools.TrimPrefix(hdr, stripPrefix))
+			imps = append(imps, resolve.ImportSpec{Lang: ccImportLang, Imp: imp})
+			continue
+		}
+		imps = append(imps, resolve.ImportSpec{Lang: ccImportLang, Imp: hdr})
+		for _, dir := range r.AttrStrings("includes") {
+			dir = path.Join(f.Pkg, dir)
+			if dir != hdr && pathtools.HasPrefix(hdr, dir) {
+				imps = append(imps, resolve.ImportSpec{Lang: ccImportLang, Imp: pathtools.TrimPrefix(hdr, dir)})
+			}
+		}
+	}
+	return imps
+}
+
+// resolveCgoIncludes resolves headers included by a cgo package to
+// cc_library rules. Headers are looked up relative to the including package
+// first, then relative to the repository root. Headers that can't be
+// resolved (for example, system headers) are ignored. The returned labels
+// are sorted and de-duplicated.
+func resolveCgoIncludes(c *config.Config, ix *resolve.RuleIndex, includes []string, from label.Label) []string {
+	seen := make(map[string]bool)
+	var cdeps []string
+	for _, inc := range includes {
+		for _, imp := range []string{path.Join(from.Pkg, inc), path.Clean(inc)} {
+			l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: ccImportLang, Imp: imp}, goName)
+			if !ok {
+				matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: ccImportLang, Imp: imp}, goName)
+				if len(matches) != 1 {
+					if len(matches) > 1 {
+						log.Printf("%s: multiple cc_library rules provide header %q; add a \"# gazelle:resolve go cc %s label\" directive to choose one", from, imp, imp)
+					}
+					continue
+				}
+				l = matches[0].Label
+			}
+			s := relLabel(c, l, from)
+			if !seen[s] {
+				seen[s] = true
+				cdeps = append(cdeps, s)
+			}
+			break
+		}
+	}
+	sort.Strings(cdeps)
+	return cdeps
+}
+
 func isGoLibrary(kind string) bool {
 	return kind == "go_library" || isGoProtoLibrary(kind)
 }
@@ -16,16 +16,21 @@ limitations under the License.
 package golang
 
 import (
+	"bytes"
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/bazelbuild/bazel-gazelle/walk"
 	bzl "github.com/bazelbuild/buildtools/build"
 	"golang.org/x/tools/go/vcs"
 )
@@ -131,6 +136,139 @@ go_library(
     importpath = "a",
     deps = ["//:good"],
 )
+`,
+		}, {
+			desc: "import_map",
+			index: []buildFile{{
+				content: `
+# gazelle:go_import_map github.com/corp/* @corp_go//...
+# gazelle:go_import_map github.com/corp/special //third_party:special
+# gazelle:go_import_map example.com/*/api/* @apis//:all
+go_library(
+    name = "bad",
+    importpath = "github.com/corp/foo",
+)
+`,
+			}},
+			old: buildFile{
+				rel: "test",
+				content: `
+go_library(
+    name = "a",
+    importpath = "a",
+    _imports = [
+        "example.com/x/api/v1",
+        "github.com/corp/foo",
+        "github.com/corp/foo/bar",
+        "github.com/corp/special",
+    ],
+)
+`,
+			},
+			want: `
+go_library(
+    name = "a",
+    importpath = "a",
+    deps = [
+        "//third_party:special",
+        "@apis//:all",
+        "@corp_go//foo:go_default_library",
+        "@corp_go//foo/bar:go_default_library",
+    ],
+)
+`,
+		}, {
+			desc: "import_map_naming",
+			index: []buildFile{{
+				content: `
+# gazelle:go_naming_template go_library {dirname}
+# gazelle:go_import_map github.com/corp/* @corp_go//...
+# gazelle:go_import_map github.com/corp/legacy/* @legacy//...:go_default_library
+# gazelle:go_import_map example.com/*/api/* @apis//...:{dirname}_lib
+`,
+			}},
+			old: buildFile{
+				rel: "test",
+				content: `
+go_library(
+    name = "a",
+    importpath = "a",
+    _imports = [
+        "example.com/x/api/v1",
+        "github.com/corp/foo/bar",
+        "github.com/corp/legacy/baz",
+    ],
+)
+`,
+			},
+			want: `
+go_library(
+    name = "a",
+    importpath = "a",
+    deps = [
+        "@apis//v1:v1_lib",
+        "@corp_go//foo/bar",
+        "@legacy//baz:go_default_library",
+    ],
+)
+`,
+		}, {
+			desc: "cgo_includes",
+			index: []buildFile{{
+				rel: "c/foo",
+				content: `
+cc_library(
+    name = "foo",
+    hdrs = ["foo.h"],
+)
+`,
+			}, {
+				rel: "c/bar",
+				content: `
+cc_library(
+    name = "bar",
+    hdrs = ["include/bar/bar.h"],
+    strip_include_prefix = "include",
+)
+`,
+			}, {
+				rel: "c/baz",
+				content: `
+cc_library(
+    name = "baz",
+    hdrs = ["inc/baz.h"],
+    includes = ["inc"],
+)
+`,
+			}},
+			old: buildFile{
+				rel: "c",
+				content: `
+go_library(
+    name = "go_default_library",
+    cgo = True,
+    importpath = "example.com/repo/resolve/c",
+    _cgo_includes = [
+        "bar/bar.h",
+        "baz.h",
+        "c/foo/foo.h",
+        "foo/foo.h",
+        "stdio.h",
+    ],
+)
+`,
+			},
+			want: `
+go_library(
+    name = "go_default_library",
+    cdeps = [
+        "//c/bar",
+        "//c/baz",
+        "//c/foo",
+    ],
+    cgo = True,
+    importpath = "example.com/repo/resolve/c",
+)
 `,
 		}, {
 			desc: "same_package",
@@ -533,6 +671,23 @@ go_binary(
     name = "bin",
     deps = ["//vendor/example.com/outside/prefix:go_default_library"],
 )
+`,
+		}, {
+			desc: "vendor_naming_template",
+			index: []buildFile{{
+				content: "# gazelle:go_naming_template go_library {dirname}_lib",
+			}},
+			old: buildFile{content: `
+go_binary(
+    name = "bin",
+    _imports = ["example.com/outside/prefix"],
+)
+`},
+			want: `
+go_binary(
+    name = "bin",
+    deps = ["//vendor/example.com/outside/prefix:prefix_lib"],
+)
 `,
 		}, {
 			desc: "test_and_library_not_indexed",
@@ -931,7 +1086,11 @@ go_proto_library(
 					mrslv[kind] = lang
 				}
 			}
-			ix := resolve.NewRuleIndex(mrslv.Resolver)
+			exts := make([]interface{}, len(langs))
+			for i, lang := range langs {
+				exts[i] = lang
+			}
+			ix := resolve.NewRuleIndex(mrslv.Resolver, exts...)
 			rc := testRemoteCache(nil)
 
 			for _, bf := range tc.index {
@@ -1069,6 +1228,7 @@ func TestResolveExternal(t *testing.T) {
 		desc, importpath string
 		repos            []repo.Repo
 		moduleMode       bool
+		naming           string
 		want             string
 	}{
 		{
@@ -1140,10 +1300,50 @@ func TestResolveExternal(t *testing.T) {
 			},
 			moduleMode: true,
 			want:       "@com_example_foo//:go_default_library",
+		}, {
+			desc:       "well_known_module",
+			importpath: "golang.org/x/tools/go/packages",
+			moduleMode: true,
+			want:       "@org_golang_x_tools//go/packages:go_default_library",
+		}, {
+			desc:       "well_known_nested_module",
+			importpath: "golang.org/x/tools/gopls/internal/lsp",
+			moduleMode: true,
+			want:       "@org_golang_x_tools_gopls//internal/lsp:go_default_library",
+		}, {
+			desc:       "well_known_module_gopath",
+			importpath: "cloud.google.com/go/storage/internal",
+			want:       "@com_google_cloud_go_storage//internal:go_default_library",
+		}, {
+			desc:       "well_known_module_declared",
+			importpath: "golang.org/x/tools/go/packages",
+			repos: []repo.Repo{{
+				Name:     "x_tools",
+				GoPrefix: "golang.org/x/tools",
+			}},
+			moduleMode: true,
+			want:       "@x_tools//go/packages:go_default_library",
+		}, {
+			desc:       "major_version_fold",
+			importpath: "example.com/repo/v2/foo",
+			moduleMode: true,
+			naming:     majorVersionFold,
+			want:       "@com_example_repo//foo:go_default_library",
+		}, {
+			desc:       "major_version_fold_declared",
+			importpath: "example.com/repo/v2/foo",
+			repos: []repo.Repo{{
+				Name:     "com_example_repo_v2",
+				GoPrefix: "example.com/repo/v2",
+			}},
+			moduleMode: true,
+			naming:     majorVersionFold,
+			want:       "@com_example_repo_v2//foo:go_default_library",
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			gc.moduleMode = tc.moduleMode
+			gc.majorVersionNaming = tc.naming
 			rc := testRemoteCache(tc.repos)
 			r := rule.NewRule("go_library", "x")
 			imports := rule.PlatformStrings{Generic: []string{tc.importpath}}
@@ -1159,6 +1359,222 @@ func TestResolveExternal(t *testing.T) {
 	}
 }
 
+func TestResolveModuleFallback(t *testing.T) {
+	c, langs, _ := testConfig(
+		t,
+		"-go_prefix=example.com/local",
+		"-go_module_fallback")
+	ix := resolve.NewRuleIndex(nil)
+	ix.Finish()
+	gl := langs[1].(*goLang)
+	rc := testRemoteCache(nil)
+	rc.ModVersionInfo = func(modPath, query string) (string, string, error) {
+		if modPath == "corp.test/mods/foo" && query == "latest" {
+			return "v1.2.3", "h1:abc", nil
+		}
+		return "", "", fmt.Errorf("module %s not found", modPath)
+	}
+
+	r := rule.NewRule("go_library", "x")
+	imports := rule.PlatformStrings{Generic: []string{
+		"corp.test/mods/foo/bar",
+		"corp.test/missing",
+	}}
+	gl.Resolve(c, ix, rc, r, imports, label.New("", "", "x"))
+	got := r.AttrStrings("deps")
+	want := []string{"@test_corp_mods_foo//bar:go_default_library"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestResolveLocalModule(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "third_party/local/go.mod", Content: "module example.com/local\n"},
+		{Path: "third_party/local/sub/go.mod", Content: "module example.com/local/sub\n"},
+	})
+	defer cleanup()
+
+	c, langs, _ := testConfig(t, "-go_prefix=example.com/repo")
+	local := rule.NewRule("local_repository", "local")
+	local.SetAttr("path", "third_party/local")
+	sub := rule.NewRule("local_repository", "local_sub")
+	sub.SetAttr("path", filepath.Join(dir, "third_party", "local", "sub"))
+	missing := rule.NewRule("local_repository", "missing")
+	missing.SetAttr("path", "third_party/missing")
+	getGoConfig(c).localModules = readLocalModules(walk.OSFS{}, dir, []*rule.Rule{local, sub, missing})
+	ix := resolve.NewRuleIndex(nil)
+	ix.Finish()
+	gl := langs[1].(*goLang)
+
+	r := rule.NewRule("go_library", "x")
+	imports := rule.PlatformStrings{Generic: []string{
+		"example.com/local",
+		"example.com/local/a",
+		"example.com/local/sub/b",
+	}}
+	gl.Resolve(c, ix, testRemoteCache(nil), r, imports, label.New("", "", "x"))
+	got := r.AttrStrings("deps")
+	want := []string{
+		"@local//:go_default_library",
+		"@local//a:go_default_library",
+		"@local_sub//b:go_default_library",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestResolveProtoGoPackage(t *testing.T) {
+	c, langs, _ := testConfig(t, "-go_prefix=example.com/repo")
+	mrslv := make(mapResolver)
+	for _, lang := range langs {
+		for kind := range lang.Kinds() {
+			mrslv[kind] = lang
+		}
+	}
+	exts := make([]interface{}, len(langs))
+	for i, lang := range langs {
+		exts[i] = lang
+	}
+	ix := resolve.NewRuleIndex(mrslv.Resolver, exts...)
+
+	// The proto_library was generated in this run, so it carries its package
+	// info. Its go_package doesn't match the directory, and no .pb.go files
+	// exist, so no Go rule is indexed with that import path.
+	protoFile := rule.EmptyFile("protos/BUILD.bazel", "protos")
+	pr := rule.NewRule("proto_library", "foo_proto")
+	pr.SetAttr("srcs", []string{"foo.proto"})
+	pr.SetPrivateAttr(proto.PackageKey, proto.Package{
+		Name:    "foo",
+		Options: map[string]string{"go_package": "example.com/gen/foo;foopb"},
+	})
+	pr.Insert(protoFile)
+	ix.AddRule(c, pr, protoFile)
+	ix.Finish()
+
+	gl := langs[1].(*goLang)
+	r := rule.NewRule("go_library", "go_default_library")
+	imports := rule.PlatformStrings{Generic: []string{"example.com/gen/foo"}}
+	gl.Resolve(c, ix, testRemoteCache(nil), r, imports, label.New("", "cmd", r.Name()))
+	got := r.AttrStrings("deps")
+	want := []string{"//protos:foo_go_proto"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestResolveAmbiguousWithChooser(t *testing.T) {
+	c, langs, _ := testConfig(t, "-go_prefix=example.com/repo")
+	mrslv := make(mapResolver)
+	for _, lang := range langs {
+		for kind := range lang.Kinds() {
+			mrslv[kind] = lang
+		}
+	}
+	ix := resolve.NewRuleIndex(mrslv.Resolver)
+	for _, pkg := range []string{"a", "b"} {
+		f := rule.EmptyFile(pkg+"/BUILD.bazel", pkg)
+		r := rule.NewRule("go_library", "go_default_library")
+		r.SetAttr("importpath", "example.com/dup")
+		r.Insert(f)
+		ix.AddRule(c, r, f)
+	}
+	ix.Finish()
+
+	var gotCandidates []label.Label
+	resolve.SetChooser(c, func(imp resolve.ImportSpec, lang string, from label.Label, candidates []label.Label) (label.Label, bool) {
+		gotCandidates = candidates
+		return candidates[1], true
+	})
+
+	gl := langs[1].(*goLang)
+	r := rule.NewRule("go_library", "go_default_library")
+	imports := rule.PlatformStrings{Generic: []string{"example.com/dup"}}
+	gl.Resolve(c, ix, testRemoteCache(nil), r, imports, label.New("", "cmd", r.Name()))
+	if len(gotCandidates) != 2 {
+		t.Errorf("got candidates %v; want 2", gotCandidates)
+	}
+	got := r.AttrStrings("deps")
+	want := []string{"//b:go_default_library"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestResolveStats(t *testing.T) {
+	c, langs, cexts := testConfig(t, "-go_prefix=example.com/repo")
+	f, err := rule.LoadData("BUILD.bazel", "", []byte("# gazelle:resolve go example.com/over //over"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cext := range cexts {
+		cext.Configure(c, "", f)
+	}
+	stats := resolve.NewStats()
+	resolve.SetStats(c, stats)
+
+	mrslv := make(mapResolver)
+	for _, lang := range langs {
+		for kind := range lang.Kinds() {
+			mrslv[kind] = lang
+		}
+	}
+	ix := resolve.NewRuleIndex(mrslv.Resolver)
+	libFile := rule.EmptyFile("lib/BUILD.bazel", "lib")
+	lib := rule.NewRule("go_library", "go_default_library")
+	lib.SetAttr("importpath", "example.com/repo/lib")
+	lib.Insert(libFile)
+	ix.AddRule(c, lib, libFile)
+	ix.Finish()
+
+	gl := langs[1].(*goLang)
+	r := rule.NewRule("go_library", "go_default_library")
+	imports := rule.PlatformStrings{Generic: []string{
+		"fmt",
+		"example.com/repo/lib",
+		"example.com/ext",
+		"example.com/over",
+		"unknown.test/x",
+	}}
+	gl.Resolve(c, ix, testRemoteCache(nil), r, imports, label.New("", "cmd", r.Name()))
+	test := rule.NewRule("go_test", "go_default_test")
+	imports = rule.PlatformStrings{Generic: []string{"example.com/repo/lib"}}
+	gl.Resolve(c, ix, testRemoteCache(nil), test, imports, label.New("", "lib", lib.Name()))
+
+	// Without the index, imports with the prefix are resolved by convention.
+	// In vendored mode, other imports are resolved to vendor.
+	c.IndexLibraries = false
+	getGoConfig(c).depMode = vendorMode
+	imports = rule.PlatformStrings{Generic: []string{"example.com/repo/other", "example.com/vend"}}
+	gl.Resolve(c, ix, testRemoteCache(nil), r, imports, label.New("", "cmd", r.Name()))
+
+	for _, tc := range []struct {
+		outcome resolve.Outcome
+		want    int
+	}{
+		{resolve.OutcomeSelfImport, 1},
+		{resolve.OutcomeIndex, 1},
+		{resolve.OutcomeLocal, 1},
+		{resolve.OutcomeVendored, 1},
+		{resolve.OutcomeExternal, 1},
+		{resolve.OutcomeOverride, 1},
+		{resolve.OutcomeUnresolved, 1},
+	} {
+		if got := stats.Count("go", tc.outcome); got != tc.want {
+			t.Errorf("%s: got %d; want %d", tc.outcome, got, tc.want)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := stats.WriteSummary(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "go: 7 imports, 1 self-import, 1 index, 1 local, 1 vendored, 1 external, 1 override, 1 unresolved\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got summary %q; want %q", got, want)
+	}
+}
+
 func testRemoteCache(knownRepos []repo.Repo) *repo.RemoteCache {
 	rc, _ := repo.NewRemoteCache(knownRepos)
 	rc.RepoRootForImportPath = stubRepoRootForImportPath
@@ -1213,6 +1629,10 @@ func convertImportsAttr(r *rule.Rule) interface{} {
 	kind := r.Kind()
 	value := r.AttrStrings("_imports")
 	r.DelAttr("_imports")
+	if includes := r.AttrStrings("_cgo_includes"); includes != nil {
+		r.DelAttr("_cgo_includes")
+		r.SetPrivateAttr(cgoIncludesKey, rule.PlatformStrings{Generic: includes})
+	}
 	if _, ok := goKinds[kind]; ok {
 		return rule.PlatformStrings{Generic: value}
 	} else {
@@ -21,7 +21,7 @@ func init() {
 	goModDownload = goModDownloadStub
 }
 
-func goListModulesStub(dir string) ([]byte, error) {
+func goListModulesStub(dir string, offline bool) ([]byte, error) {
 	return []byte(`{
 	"Path": "github.com/bazelbuild/bazel-gazelle",
 	"Main": true,
@@ -0,0 +1,97 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/repo"
+)
+
+// sumDBURLFromEnv returns the base URL of the checksum database named by the
+// GOSUMDB environment variable, or "" if the checksum database is turned off.
+// GOSUMDB may be a database name with an optional public key, followed by an
+// optional URL, for example, "sum.golang.org" or
+// "sum.example.com+<key> https://sum.example.com/db".
+func sumDBURLFromEnv() string {
+	fields := strings.Fields(os.Getenv("GOSUMDB"))
+	switch {
+	case len(fields) == 0:
+		return "https://sum.golang.org"
+	case fields[0] == "off":
+		return ""
+	case len(fields) > 1:
+		return strings.TrimSuffix(fields[1], "/")
+	default:
+		name := fields[0]
+		if i := strings.Index(name, "+"); i >= 0 {
+			name = name[:i]
+		}
+		return "https://" + name
+	}
+}
+
+var sumDBClient = &http.Client{Timeout: 30 * time.Second}
+
+// sumDBLookup asks the checksum database at sumDBURL for the sum of the zip
+// file of a module at a version. The sum has the same form as sums in go.sum
+// files ("h1:..."), and it's verified by go_repository when the module
+// is downloaded.
+//
+// The signed tree head included in the response is not checked, so the
+// result is only as trustworthy as the connection to the database.
+// Credentials from .netrc or GOAUTH are sent with the request, so private
+// databases and proxies that serve the database protocol can be used.
+var sumDBLookup = func(sumDBURL, modPath, version string) (string, error) {
+	url := fmt.Sprintf("%s/lookup/%s@%s", sumDBURL, repo.EscapeModulePath(modPath), repo.EscapeModulePath(version))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("looking up sum for %s@%s: %v", modPath, version, err)
+	}
+	authenticate(req)
+	resp, err := sumDBClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("looking up sum for %s@%s: %v", modPath, version, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("looking up sum for %s@%s: %s", modPath, version, resp.Status)
+	}
+	return parseSumDBLookup(resp.Body, modPath, version)
+}
+
+// parseSumDBLookup finds the sum for a module zip in the body of a response
+// from a checksum database lookup. The body starts with a record number,
+// followed by go.sum lines for the module and its go.mod file.
+func parseSumDBLookup(r io.Reader, modPath, version string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == modPath && fields[1] == version {
+			return fields[2], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("looking up sum for %s@%s: %v", modPath, version, err)
+	}
+	return "", fmt.Errorf("looking up sum for %s@%s: sum not found in checksum database response", modPath, version)
+}
@@ -0,0 +1,98 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// addRuleTags adds the tags set with # gazelle:go_rule_tags to each rule in
+// gen, along with tags set with # gazelle:go_package_attrs for packages
+// matching rel.
+//
+// tags is not a mergeable attribute, so tags added by hand are never
+// removed. If a rule in f will be replaced by a rule in gen and already has
+// a tags attribute, missing tags are appended to it directly. Otherwise,
+// tags are set on the generated rule and copied when it's merged. Rules and
+// tags lists marked with # keep are not changed.
+func addRuleTags(c *config.Config, f *rule.File, rel string, gen []*rule.Rule) {
+	gc := getGoConfig(c)
+	tags := gc.ruleTags
+	for _, a := range gc.packageAttrs {
+		if !a.matches(rel) {
+			continue
+		}
+		for _, tag := range a.tags {
+			if !containsString(tags, tag) {
+				tags = append(tags[:len(tags):len(tags)], tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return
+	}
+	for _, r := range gen {
+		var old *rule.Rule
+		if f != nil {
+			old = existingRule(c, f, r)
+		}
+		if old == nil || old.Attr("tags") == nil {
+			r.SetAttr("tags", tags)
+			continue
+		}
+		list, ok := old.Attr("tags").(*bzl.ListExpr)
+		if !ok || old.ShouldKeep() || rule.ShouldKeep(list) {
+			continue
+		}
+		have := make(map[string]bool)
+		for _, s := range listStrings(list) {
+			have[s.Value] = true
+		}
+		added := false
+		for _, tag := range tags {
+			if !have[tag] {
+				list.List = append(list.List, &bzl.StringExpr{Value: tag})
+				added = true
+			}
+		}
+		if added {
+			old.SetAttr("tags", list)
+		}
+	}
+}
+
+// setPackageTestonly sets testonly on rules in gen, other than tests, when
+// it's set with # gazelle:go_package_attrs for packages matching rel. Later
+// directives take precedence. testonly is not a mergeable attribute, so it's
+// only added to existing rules that don't set it.
+func setPackageTestonly(c *config.Config, rel string, gen []*rule.Rule) {
+	var testonly *bool
+	for _, a := range getGoConfig(c).packageAttrs {
+		if a.testonly != nil && a.matches(rel) {
+			testonly = a.testonly
+		}
+	}
+	if testonly == nil || !*testonly {
+		return
+	}
+	for _, r := range gen {
+		if r.Kind() != "go_test" {
+			r.SetAttr("testonly", true)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+//go:build arm64 && !purego
+
+package asm
+
+func add(a, b int) int
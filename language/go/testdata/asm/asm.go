@@ -0,0 +1,6 @@
+package asm
+
+// Add returns a + b.
+func Add(a, b int) int {
+	return add(a, b)
+}
@@ -0,0 +1,3 @@
+package lib
+
+func b() string { return "b" }
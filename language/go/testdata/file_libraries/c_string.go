@@ -0,0 +1,3 @@
+package lib
+
+func (c *C) String() string { return "c" }
@@ -0,0 +1,5 @@
+package lib
+
+import "example.com/repo/lib/deep"
+
+func D() string { return (&C{}).String() + deep.Thought() }
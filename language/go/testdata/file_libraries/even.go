@@ -0,0 +1,3 @@
+package lib
+
+func even(n int) bool { return n == 0 || odd(n-1) }
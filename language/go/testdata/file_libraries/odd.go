@@ -0,0 +1,3 @@
+package lib
+
+func odd(n int) bool { return n != 0 && even(n-1) }
@@ -0,0 +1,13 @@
+package fuzz
+
+import "testing"
+
+func FuzzParse(f *testing.F) {}
+
+func FuzzURL(f *testing.F) {}
+
+// Not fuzz tests.
+
+func Fuzzy(f *testing.F) {}
+
+func FuzzTest(t *testing.T) {}
@@ -0,0 +1,3 @@
+package generated_srcs
+
+import _ "fmt"
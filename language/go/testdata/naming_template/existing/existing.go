@@ -0,0 +1 @@
+package existing
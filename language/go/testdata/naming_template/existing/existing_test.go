@@ -0,0 +1,3 @@
+package existing
+
+import "testing"
@@ -0,0 +1 @@
+package naming_template
@@ -0,0 +1,3 @@
+package naming_template
+
+import "testing"
@@ -0,0 +1,3 @@
+package mocks
+
+import _ "example.com/repo/package_attrs/foo"
@@ -0,0 +1,5 @@
+package mocks
+
+import "testing"
+
+func TestMock(t *testing.T) {}
@@ -0,0 +1 @@
+package protoc_output_both
@@ -0,0 +1 @@
+package subtree_visibility
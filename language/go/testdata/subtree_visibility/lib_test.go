@@ -0,0 +1,5 @@
+package subtree_visibility
+
+import "testing"
+
+func TestLib(t *testing.T) {}
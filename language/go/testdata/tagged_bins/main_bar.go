@@ -0,0 +1,7 @@
+// +build bar
+
+package main
+
+func main() {
+	greet(name())
+}
@@ -0,0 +1,7 @@
+// +build !foo,!bar
+
+package main
+
+func main() {
+	greet("default")
+}
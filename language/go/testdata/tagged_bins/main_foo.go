@@ -0,0 +1,9 @@
+// +build foo
+
+package main
+
+import "example.com/repo/lib"
+
+func main() {
+	greet(lib.Answer())
+}
@@ -0,0 +1,9 @@
+// +build bar
+
+package main
+
+import "os"
+
+func name() string {
+	return os.Getenv("USER")
+}
@@ -0,0 +1,7 @@
+// +build bar,!linux
+
+package main
+
+func name() string {
+	return "bar"
+}
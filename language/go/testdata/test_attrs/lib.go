@@ -0,0 +1 @@
+package test_attrs
@@ -0,0 +1,5 @@
+package test_attrs
+
+import "testing"
+
+func TestMain(m *testing.M) {}
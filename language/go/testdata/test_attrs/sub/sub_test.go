@@ -0,0 +1,5 @@
+package sub
+
+import "testing"
+
+func TestMain(m *testing.M) {}
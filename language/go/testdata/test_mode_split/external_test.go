@@ -0,0 +1,7 @@
+package test_mode_split_test
+
+import (
+	"testing"
+
+	"example.com/repo/test_mode_split"
+)
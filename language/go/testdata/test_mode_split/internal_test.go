@@ -0,0 +1,3 @@
+package test_mode_split
+
+import "testing"
@@ -0,0 +1 @@
+package test_mode_split
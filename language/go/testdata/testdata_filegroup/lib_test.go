@@ -0,0 +1,5 @@
+package lib
+
+import "testing"
+
+func TestData(t *testing.T) {}
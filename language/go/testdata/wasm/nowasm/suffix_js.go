@@ -0,0 +1,3 @@
+package nowasm
+
+import _ "example.com/repo/js"
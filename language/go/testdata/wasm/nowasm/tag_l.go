@@ -0,0 +1,6 @@
+//go:build linux || js
+// +build linux js
+
+package nowasm
+
+import _ "example.com/repo/linuxorjs"
@@ -0,0 +1,3 @@
+package wasm
+
+import _ "example.com/repo/wasip1"
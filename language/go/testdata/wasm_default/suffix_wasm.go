@@ -0,0 +1,3 @@
+package wasm_default
+
+import _ "example.com/repo/wasm"
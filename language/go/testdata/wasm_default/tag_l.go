@@ -0,0 +1,6 @@
+//go:build linux || js
+// +build linux js
+
+package wasm_default
+
+import _ "example.com/repo/linuxorjs"
@@ -0,0 +1,215 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// packageUses records which Go packages are used by test rules and which
+// are used by other rules. Packages are recorded by import path, from the
+// imports of rules generated in this run, and by label, from the deps and
+// embed attributes of indexed rules, which covers directories that aren't
+// being updated. Uses are recorded per using package, so they can be
+// forgotten when that package is removed from the index.
+type packageUses struct {
+	// imports and labels map used packages to the packages using them.
+	imports map[string]map[string]useKind
+	labels  map[label.Label]map[string]useKind
+
+	// pkgImports and pkgLabels list the keys recorded for each using package.
+	pkgImports map[string][]string
+	pkgLabels  map[string][]label.Label
+
+	// pkgs is the set of packages whose uses have been recorded, whether or
+	// not they use anything.
+	pkgs map[string]bool
+
+	// generated maps packages to the names of rules generated in them. The
+	// deps of these rules in the build file may be stale, so they aren't
+	// recorded when the rules are indexed.
+	generated map[string]map[string]bool
+}
+
+// useKind is a set of flags describing how a package is used.
+type useKind int
+
+const (
+	testUse useKind = 1 << iota
+	otherUse
+)
+
+func newPackageUses() *packageUses {
+	return &packageUses{
+		imports:    make(map[string]map[string]useKind),
+		labels:     make(map[label.Label]map[string]useKind),
+		pkgImports: make(map[string][]string),
+		pkgLabels:  make(map[string][]label.Label),
+		pkgs:       make(map[string]bool),
+		generated:  make(map[string]map[string]bool),
+	}
+}
+
+// recordPackage records that the rules in the package pkg were generated,
+// even if none of them use other packages.
+func (u *packageUses) recordPackage(pkg string) {
+	u.pkgs[pkg] = true
+}
+
+// recordGenerated records the packages imported by r, a rule generated in
+// this run in the package pkg.
+func (u *packageUses) recordGenerated(pkg string, r *rule.Rule) {
+	if !isGoRule(r.Kind()) {
+		return
+	}
+	if u.generated[pkg] == nil {
+		u.generated[pkg] = make(map[string]bool)
+	}
+	u.generated[pkg][r.Name()] = true
+	imports, ok := r.PrivateAttr(config.GazelleImportsKey).(rule.PlatformStrings)
+	if !ok {
+		return
+	}
+	kind := ruleUseKind(r)
+	for _, imp := range imports.Flat() {
+		users := u.imports[imp]
+		if users == nil {
+			users = make(map[string]useKind)
+			u.imports[imp] = users
+		}
+		if _, ok := users[pkg]; !ok {
+			u.pkgImports[pkg] = append(u.pkgImports[pkg], imp)
+		}
+		users[pkg] |= kind
+	}
+}
+
+// recordIndexed records the labels r, a rule in f, depends on or embeds,
+// unless r was generated in this run.
+func (u *packageUses) recordIndexed(c *config.Config, r *rule.Rule, f *rule.File) {
+	if !isGoRule(r.Kind()) {
+		return
+	}
+	u.pkgs[f.Pkg] = true
+	if u.generated[f.Pkg][r.Name()] {
+		return
+	}
+	kind := ruleUseKind(r)
+	for _, key := range []string{"deps", "embed"} {
+		for _, s := range r.AttrStrings(key) {
+			l, err := label.Parse(s)
+			if err != nil {
+				continue
+			}
+			l = l.Abs(c.RepoName, f.Pkg)
+			if l.Repo == "" {
+				l.Repo = c.RepoName
+			}
+			users := u.labels[l]
+			if users == nil {
+				users = make(map[string]useKind)
+				u.labels[l] = users
+			}
+			if _, ok := users[f.Pkg]; !ok {
+				u.pkgLabels[f.Pkg] = append(u.pkgLabels[f.Pkg], l)
+			}
+			users[f.Pkg] |= kind
+		}
+	}
+}
+
+// removePackage forgets the uses recorded for rules in the package pkg.
+func (u *packageUses) removePackage(pkg string) {
+	for _, imp := range u.pkgImports[pkg] {
+		delete(u.imports[imp], pkg)
+		if len(u.imports[imp]) == 0 {
+			delete(u.imports, imp)
+		}
+	}
+	for _, l := range u.pkgLabels[pkg] {
+		delete(u.labels[l], pkg)
+		if len(u.labels[l]) == 0 {
+			delete(u.labels, l)
+		}
+	}
+	delete(u.pkgImports, pkg)
+	delete(u.pkgLabels, pkg)
+	delete(u.pkgs, pkg)
+	delete(u.generated, pkg)
+}
+
+// isRecorded returns whether uses have been recorded for the package pkg.
+func (u *packageUses) isRecorded(pkg string) bool {
+	return u.pkgs[pkg]
+}
+
+// importers returns the sorted list of packages that use the Go package
+// with the given import path and label.
+func (u *packageUses) importers(importPath string, l label.Label) []string {
+	seen := make(map[string]bool)
+	var pkgs []string
+	for _, users := range []map[string]useKind{u.imports[importPath], u.labels[l]} {
+		for pkg := range users {
+			if !seen[pkg] {
+				seen[pkg] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// isTestOnly returns whether the go_library r, with the given imports and
+// label, should be marked testonly: either it imports "testing", or tests
+// use it and nothing else does.
+func (u *packageUses) isTestOnly(r *rule.Rule, imports rule.PlatformStrings, from label.Label) bool {
+	for _, imp := range imports.Flat() {
+		if imp == "testing" {
+			return true
+		}
+	}
+	var kind useKind
+	for _, k := range u.imports[r.AttrString("importpath")] {
+		kind |= k
+	}
+	for _, k := range u.labels[from] {
+		kind |= k
+	}
+	return kind&otherUse == 0 && kind&testUse != 0
+}
+
+// ruleUseKind returns how r uses the packages it depends on.
+func ruleUseKind(r *rule.Rule) useKind {
+	if isTestRule(r) {
+		return testUse
+	}
+	return otherUse
+}
+
+// isTestRule returns whether r is a go_test rule or is marked testonly.
+func isTestRule(r *rule.Rule) bool {
+	if r.Kind() == "go_test" {
+		return true
+	}
+	ident, ok := r.Attr("testonly").(*bzl.Ident)
+	return ok && ident.Name == "True"
+}
@@ -0,0 +1,135 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"log"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// recordExistingRules sets existingRuleKey on each generated go_library,
+// go_binary, and go_test in gen that has a rule with the same name and kind
+// in f. Resolve uses these to find unused deps.
+func recordExistingRules(c *config.Config, f *rule.File, gen []*rule.Rule) {
+	if f == nil || !getGoConfig(c).checkUnusedDeps {
+		return
+	}
+	for _, r := range gen {
+		switch r.Kind() {
+		case "go_library", "go_binary", "go_test":
+		default:
+			continue
+		}
+		if old := existingRule(c, f, r); old != nil {
+			r.SetPrivateAttr(existingRuleKey, old)
+		}
+	}
+}
+
+// existingRule returns the rule in f with the same name and kind as r, the
+// rule generated to replace it, or nil if there is none. Kinds mapped with
+// # gazelle:map_kind are matched.
+func existingRule(c *config.Config, f *rule.File, r *rule.Rule) *rule.Rule {
+	kind := r.Kind()
+	if repl, ok := c.KindMap[kind]; ok {
+		kind = repl.KindName
+	}
+	for _, old := range f.Rules {
+		if old.Name() == r.Name() && (old.Kind() == r.Kind() || old.Kind() == kind) {
+			return old
+		}
+	}
+	return nil
+}
+
+// checkUnusedDeps reports deps of old that are marked with # keep, but that
+// are not among the deps resolved for r, the rule generated from the same
+// sources. No source file imports these, so they were probably left
+// behind by manual edits. Merging keeps them, so they're reported here. In
+// fix mode, they're removed from old before merging.
+//
+// Rules marked with # keep are reported but not changed.
+func checkUnusedDeps(c *config.Config, old, r *rule.Rule, from label.Label) {
+	deps := old.Attr("deps")
+	if deps == nil {
+		return
+	}
+	used := make(map[string]bool)
+	if resolved := r.Attr("deps"); resolved != nil {
+		for _, s := range listStrings(resolved) {
+			used[normalizeDep(s.Value, from)] = true
+		}
+	}
+	keepRule := old.ShouldKeep()
+	unused := make(map[*bzl.StringExpr]bool)
+	for _, s := range listStrings(deps) {
+		if used[normalizeDep(s.Value, from)] || !keepRule && !rule.ShouldKeep(s) {
+			continue
+		}
+		unused[s] = true
+		if c.ShouldFix && !keepRule {
+			log.Printf("%s: removed dep %s, which is not imported by any source file", from, s.Value)
+		} else {
+			log.Printf("%s: dep %s is not imported by any source file", from, s.Value)
+		}
+	}
+	if len(unused) == 0 || !c.ShouldFix || keepRule {
+		return
+	}
+	bzl.Edit(deps, func(e bzl.Expr, stk []bzl.Expr) bzl.Expr {
+		if list, ok := e.(*bzl.ListExpr); ok {
+			kept := list.List[:0]
+			for _, elem := range list.List {
+				if s, ok := elem.(*bzl.StringExpr); !ok || !unused[s] {
+					kept = append(kept, elem)
+				}
+			}
+			list.List = kept
+		}
+		return nil
+	})
+	old.SetAttr("deps", deps)
+}
+
+// listStrings returns the string elements of lists in e, which may be a
+// list, a select expression, or a list combined with a select expression.
+func listStrings(e bzl.Expr) []*bzl.StringExpr {
+	var strs []*bzl.StringExpr
+	bzl.Walk(e, func(e bzl.Expr, stk []bzl.Expr) {
+		if list, ok := e.(*bzl.ListExpr); ok {
+			for _, elem := range list.List {
+				if s, ok := elem.(*bzl.StringExpr); ok {
+					strs = append(strs, s)
+				}
+			}
+		}
+	})
+	return strs
+}
+
+// normalizeDep converts a dep label to an absolute form, so labels written
+// differently may be compared.
+func normalizeDep(dep string, from label.Label) string {
+	l, err := label.Parse(dep)
+	if err != nil {
+		return dep
+	}
+	return l.Abs(from.Repo, from.Pkg).String()
+}
@@ -16,11 +16,18 @@ limitations under the License.
 package golang
 
 import (
+	"errors"
+	"fmt"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	"golang.org/x/sync/errgroup"
 )
@@ -40,14 +47,22 @@ func (*goLang) UpdateRepos(args language.UpdateReposArgs) language.UpdateReposRe
 			if i := strings.IndexByte(arg, '@'); i >= 0 {
 				modPath, query = arg[:i], arg[i+1:]
 			}
-			name, version, sum, err := args.Cache.ModVersion(modPath, query)
+			modVersion := args.Cache.ModVersion
+			if getGoConfig(args.Config).offline {
+				modVersion = func(modPath, query string) (string, string, string, error) {
+					return offlineModVersion(args.Config, modPath, query)
+				}
+			}
+			name, version, sum, err := modVersion(modPath, query)
 			if err != nil {
 				return err
 			}
-			gen[i] = rule.NewRule("go_repository", name)
-			gen[i].SetAttr("importpath", modPath)
-			gen[i].SetAttr("version", version)
-			gen[i].SetAttr("sum", sum)
+			gen[i] = repo.GoRepositoryAttrs{
+				Name:       name,
+				ImportPath: modPath,
+				Version:    version,
+				Sum:        sum,
+			}.Rule()
 			setBuildAttrs(getGoConfig(args.Config), gen[i])
 			return nil
 		})
@@ -55,39 +70,218 @@ func (*goLang) UpdateRepos(args language.UpdateReposArgs) language.UpdateReposRe
 	if err := eg.Wait(); err != nil {
 		return language.UpdateReposResult{Error: err}
 	}
+	if err := applyMajorVersionNaming(args.Config, gen); err != nil {
+		return language.UpdateReposResult{Error: err}
+	}
 	return language.UpdateReposResult{Gen: gen}
 }
 
-var repoImportFuncs = map[string]func(args language.ImportReposArgs) language.ImportReposResult{
-	"Gopkg.lock":  importReposFromDep,
-	"go.mod":      importReposFromModules,
-	"Godeps.json": importReposFromGodep,
+// RepoImportFunc reads the lock file args.Path and returns repository rules
+// for the Go modules or repositories it lists. See RegisterRepoImporter.
+type RepoImportFunc func(args language.ImportReposArgs) language.ImportReposResult
+
+// repoImporter is a RepoImportFunc, with the pattern that selects the files
+// it imports.
+type repoImporter struct {
+	pattern    string
+	importFunc RepoImportFunc
+}
+
+// repoImporters is the list of lock file formats update-repos -from_file can
+// import. More may be added with RegisterRepoImporter.
+var repoImporters = []repoImporter{
+	{pattern: "Gopkg.lock", importFunc: importReposFromDep},
+	{pattern: "go.mod", importFunc: importReposFromModules},
+	{pattern: "Godeps.json", importFunc: importReposFromGodep},
+}
+
+// RegisterRepoImporter makes f available to update-repos -from_file for lock
+// files with base names matching pattern, as with path.Match. It replaces an
+// importer registered earlier with the same pattern. When several patterns
+// match, the importer registered first is used, so the built-in importers
+// for Gopkg.lock, go.mod, and Godeps.json can't be shadowed with wildcards.
+// RegisterRepoImporter should be called in an init function in a package
+// linked into a gazelle_binary.
+//
+// Rules returned by f are processed like imported go.mod rules:
+// -major_version_naming is applied, build attributes from flags are set on
+// go_repository rules, and go_repository rules that aren't returned are
+// pruned with -prune. f should not prune them itself.
+func RegisterRepoImporter(pattern string, f RepoImportFunc) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("RegisterRepoImporter: invalid pattern %q: %v", pattern, err))
+	}
+	for i := range repoImporters {
+		if repoImporters[i].pattern == pattern {
+			repoImporters[i].importFunc = f
+			return
+		}
+	}
+	repoImporters = append(repoImporters, repoImporter{pattern: pattern, importFunc: f})
+}
+
+// findRepoImporter returns the function that imports the lock file
+// filename, or nil if none is registered for its base name.
+func findRepoImporter(filename string) RepoImportFunc {
+	base := filepath.Base(filename)
+	for _, i := range repoImporters {
+		if ok, _ := path.Match(i.pattern, base); ok {
+			return i.importFunc
+		}
+	}
+	return nil
 }
 
 func (*goLang) CanImport(path string) bool {
-	return repoImportFuncs[filepath.Base(path)] != nil
+	return findRepoImporter(path) != nil
 }
 
 func (*goLang) ImportRepos(args language.ImportReposArgs) language.ImportReposResult {
-	res := repoImportFuncs[filepath.Base(args.Path)](args)
-	for _, r := range res.Gen {
-		setBuildAttrs(getGoConfig(args.Config), r)
+	if base := filepath.Base(args.Path); getGoConfig(args.Config).offline && (base == "Gopkg.lock" || base == "Godeps.json") {
+		// Repositories in these lock files are looked up with the network.
+		return language.ImportReposResult{Error: fmt.Errorf("%s: only go.mod files can be imported with -offline", args.Path)}
 	}
-	if args.Prune {
-		genNamesSet := make(map[string]bool)
-		for _, r := range res.Gen {
-			genNamesSet[r.Name()] = true
+	res := findRepoImporter(args.Path)(args)
+	if res.Error == nil {
+		res.Error = applyMajorVersionNaming(args.Config, res.Gen)
+	}
+	if res.Error != nil {
+		return res
+	}
+	genKinds := make(map[string]string)
+	genImportPaths := make(map[string]string)
+	for _, r := range res.Gen {
+		genKinds[r.Name()] = r.Kind()
+		if r.Kind() == "go_repository" {
+			setBuildAttrs(getGoConfig(args.Config), r)
+			genImportPaths[r.AttrString("importpath")] = r.Name()
 		}
-		for _, r := range args.Config.Repos {
-			if name := r.Name(); r.Kind() == "go_repository" && !genNamesSet[name] {
-				res.Empty = append(res.Empty, rule.NewRule("go_repository", name))
-			}
+	}
+
+	// When a module is replaced with a local directory or the replacement
+	// is removed, the old rule has the same name as the new one, but a
+	// different kind. It must be deleted, even without -prune, since the
+	// rules can't be merged.
+	res.PruneReasons = make(map[string]string)
+	base := filepath.Base(args.Path)
+	for _, r := range args.Config.Repos {
+		name, kind := r.Name(), r.Kind()
+		genKind, ok := genKinds[name]
+		switch {
+		case kind == "go_repository" && genKind == "local_repository":
+			res.Empty = append(res.Empty, rule.NewRule(kind, name))
+			res.PruneReasons[name] = fmt.Sprintf("%s is replaced with a local directory in %s", r.AttrString("importpath"), base)
+		case kind == "local_repository" && genKind == "go_repository":
+			res.Empty = append(res.Empty, rule.NewRule(kind, name))
+			res.PruneReasons[name] = fmt.Sprintf("%s is no longer replaced with a local directory in %s", name, base)
+		case kind == "go_repository" && !ok && args.Prune:
+			res.Empty = append(res.Empty, rule.NewRule(kind, name))
+			res.PruneReasons[name] = pruneReason(args.Path, r.AttrString("importpath"), genImportPaths)
 		}
 	}
 	sortRules(res.Gen)
 	return res
 }
 
+// pruneReason explains why a go_repository with the given importpath is no
+// longer needed after importing repositories from path. genImportPaths maps
+// import paths of imported repositories to rule names.
+func pruneReason(path, importPath string, genImportPaths map[string]string) string {
+	base := filepath.Base(path)
+	if name, ok := genImportPaths[importPath]; ok {
+		return fmt.Sprintf("%s is now provided by %s", importPath, name)
+	}
+	if importPath == "" {
+		return fmt.Sprintf("rule has no importpath and is not listed in %s", base)
+	}
+	if base == "go.mod" {
+		return fmt.Sprintf("%s is not in the build list of %s, so no remaining module requires it", importPath, base)
+	}
+	return fmt.Sprintf("%s is not listed in %s, so no remaining dependency requires it", importPath, base)
+}
+
+// repoNameForModule returns the name of the go_repository rule that should
+// provide the module modPath, according to naming, one of the majorVersion*
+// constants. With majorVersionFold or majorVersionError, a major version
+// suffix at the end of modPath is left out of the name.
+func repoNameForModule(naming, modPath string) string {
+	if naming == majorVersionFold || naming == majorVersionError {
+		if base := pathWithoutSemver(modPath); base != "" && path.Dir(modPath) == base {
+			modPath = base
+		}
+	}
+	return label.ImportPathToBazelRepoName(modPath)
+}
+
+// applyMajorVersionNaming renames generated go_repository rules for modules
+// with major version suffixes, according to -major_version_naming. Only
+// rules with default names are renamed; rules named after existing
+// repository rules keep their names.
+//
+// A rule can't be renamed if another module already has the new name,
+// either in gen or in an existing repository rule. For example, this happens
+// when example.com/m and example.com/m/v2 are both required. With
+// majorVersionFold, such rules keep their suffixed names. With
+// majorVersionError, an error listing each collision is returned.
+func applyMajorVersionNaming(c *config.Config, gen []*rule.Rule) error {
+	naming := getGoConfig(c).majorVersionNaming
+	if naming != majorVersionFold && naming != majorVersionError {
+		return nil
+	}
+
+	// taken maps names that won't change to the modules they provide.
+	taken := make(map[string]string)
+	for _, r := range c.Repos {
+		if r.Kind() == "go_repository" {
+			taken[r.Name()] = r.AttrString("importpath")
+		}
+	}
+	renames := make(map[string][]*rule.Rule)
+	for _, r := range gen {
+		if r.Kind() != "go_repository" {
+			continue
+		}
+		modPath := r.AttrString("importpath")
+		name := repoNameForModule(naming, modPath)
+		if r.Name() != label.ImportPathToBazelRepoName(modPath) || name == r.Name() {
+			taken[r.Name()] = modPath
+			continue
+		}
+		renames[name] = append(renames[name], r)
+	}
+
+	names := make([]string, 0, len(renames))
+	for name := range renames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []string
+	for _, name := range names {
+		rs := renames[name]
+		modPath := rs[0].AttrString("importpath")
+		if other, ok := taken[name]; (!ok || other == modPath) && len(rs) == 1 {
+			rs[0].SetName(name)
+			continue
+		}
+		if naming != majorVersionError {
+			continue
+		}
+		var mods []string
+		if other, ok := taken[name]; ok && other != modPath {
+			mods = append(mods, other)
+		}
+		for _, r := range rs {
+			mods = append(mods, r.AttrString("importpath"))
+		}
+		sort.Strings(mods)
+		errs = append(errs, fmt.Sprintf("modules %s would all be provided by repository %s; use -major_version_naming=suffix or fold to keep major version suffixes in names", strings.Join(mods, ", "), name))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
 func setBuildAttrs(gc *goConfig, r *rule.Rule) {
 	if gc.buildExternalAttr != "" {
 		r.SetAttr("build_external", gc.buildExternalAttr)
@@ -111,6 +305,57 @@ func setBuildAttrs(gc *goConfig, r *rule.Rule) {
 	}
 }
 
+// forEachLimited calls f for each integer in [0, n), running at most limit
+// calls concurrently. If limit is not positive, defaultImportConcurrency is
+// used. forEachLimited returns an error for each call that failed, in order
+// of i.
+func forEachLimited(n, limit int, f func(i int) error) []error {
+	if limit <= 0 {
+		limit = defaultImportConcurrency
+	}
+	errs := make([]error, n)
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < limit && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// importError combines errors from importing several projects into one
+// error. nil is returned if there are no errors.
+func importError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("errors importing %d projects:\n\t%s", len(errs), strings.Join(msgs, "\n\t"))
+}
+
 func sortRules(rules []*rule.Rule) {
 	sort.SliceStable(rules, func(i, j int) bool {
 		if cmp := strings.Compare(rules[i].Name(), rules[j].Name()); cmp != 0 {
@@ -16,15 +16,25 @@ limitations under the License.
 package golang
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"golang.org/x/tools/go/vcs"
 )
 
 func TestImports(t *testing.T) {
@@ -341,3 +351,815 @@ go_repository(
 		})
 	}
 }
+
+func TestRegisterRepoImporter(t *testing.T) {
+	saved := repoImporters
+	defer func() { repoImporters = saved }()
+	repoImporters = append([]repoImporter(nil), repoImporters...)
+
+	RegisterRepoImporter("deps.*.json", func(args language.ImportReposArgs) language.ImportReposResult {
+		r := rule.NewRule("go_repository", "com_example_m")
+		r.SetAttr("importpath", "example.com/m")
+		r.SetAttr("version", "v1.0.0")
+		return language.ImportReposResult{Gen: []*rule.Rule{r}}
+	})
+
+	c := &config.Config{Exts: map[string]interface{}{}}
+	c.Repos = []*rule.Rule{rule.NewRule("go_repository", "com_example_old")}
+	c.Repos[0].SetAttr("importpath", "example.com/old")
+	gl := NewLanguage()
+	gl.Configure(c, "", nil)
+	importer := gl.(language.RepoImporter)
+	for _, name := range []string{"go.mod", "Gopkg.lock", "deps.prod.json"} {
+		if !importer.CanImport(filepath.Join("dir", name)) {
+			t.Errorf("CanImport(%q) = false; want true", name)
+		}
+	}
+	if importer.CanImport("deps.json") {
+		t.Errorf("CanImport(%q) = true; want false", "deps.json")
+	}
+
+	result := importer.ImportRepos(language.ImportReposArgs{
+		Config: c,
+		Path:   "deps.prod.json",
+		Prune:  true,
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if len(result.Gen) != 1 || result.Gen[0].Name() != "com_example_m" {
+		t.Errorf("got generated rules %v; want com_example_m", result.Gen)
+	}
+	if len(result.Empty) != 1 || result.Empty[0].Name() != "com_example_old" {
+		t.Errorf("got empty rules %v; want com_example_old", result.Empty)
+	}
+	wantReason := "example.com/old is not listed in deps.prod.json, so no remaining dependency requires it"
+	if got := result.PruneReasons["com_example_old"]; got != wantReason {
+		t.Errorf("got prune reason %q; want %q", got, wantReason)
+	}
+}
+
+func TestDepSourceRemote(t *testing.T) {
+	rc := testRemoteCache(nil)
+	rc.RepoRootForImportPath = func(importPath string, verbose bool) (*vcs.RepoRoot, error) {
+		if importPath == "example.com/hg/repo" {
+			return &vcs.RepoRoot{
+				VCS:  vcs.ByCmd("hg"),
+				Repo: "https://example.com/hg/repo",
+				Root: "example.com/hg/repo",
+			}, nil
+		}
+		return stubRepoRootForImportPath(importPath, verbose)
+	}
+
+	for _, tc := range []struct {
+		desc, source, wantRemote, wantVCS string
+	}{
+		{
+			desc:       "import_path",
+			source:     "example.com/repo",
+			wantRemote: "https://example.com/repo.git",
+			wantVCS:    "git",
+		}, {
+			desc:       "import_path_hg",
+			source:     "example.com/hg/repo",
+			wantRemote: "https://example.com/hg/repo",
+			wantVCS:    "hg",
+		}, {
+			desc:       "url_suffix",
+			source:     "https://example.com/fork/semver.git",
+			wantRemote: "https://example.com/fork/semver.git",
+			wantVCS:    "git",
+		}, {
+			desc:       "url_scheme",
+			source:     "svn+ssh://example.com/svn/repo",
+			wantRemote: "svn+ssh://example.com/svn/repo",
+			wantVCS:    "svn",
+		}, {
+			desc:       "url_lookup",
+			source:     "https://example.com/hg/repo",
+			wantRemote: "https://example.com/hg/repo",
+			wantVCS:    "hg",
+		}, {
+			desc:       "url_unknown",
+			source:     "https://unknown.example.org/repo",
+			wantRemote: "https://unknown.example.org/repo",
+			wantVCS:    "git",
+		}, {
+			desc:       "scp_like",
+			source:     "git@example.com:fork/repo",
+			wantRemote: "git@example.com:fork/repo",
+			wantVCS:    "git",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			remote, vcs, err := depSourceRemote(rc, tc.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remote != tc.wantRemote || vcs != tc.wantVCS {
+				t.Errorf("got %q, %q; want %q, %q", remote, vcs, tc.wantRemote, tc.wantVCS)
+			}
+		})
+	}
+
+	if _, _, err := depSourceRemote(rc, "unknown.example.org/repo"); err == nil {
+		t.Error("unresolvable import path: got success; want error")
+	}
+}
+
+func TestForEachLimited(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	errs := forEachLimited(20, 3, func(i int) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if i%5 == 0 {
+			return fmt.Errorf("error %d", i)
+		}
+		return nil
+	})
+	if maxRunning > 3 {
+		t.Errorf("got %d concurrent calls; want at most 3", maxRunning)
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{"error 0", "error 5", "error 10", "error 15"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %q; want %q", got, want)
+	}
+}
+
+func TestImportDepErrors(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "Gopkg.lock",
+		Content: `
+[[projects]]
+  name = "example.com/a"
+  revision = "aaaa"
+  source = "unknown.example.org/a"
+
+[[projects]]
+  name = "example.com/b"
+  revision = "bbbb"
+  source = "unknown.example.org/b"
+
+[[projects]]
+  name = "example.com/c"
+  revision = "cccc"
+  source = "example.com/repo"
+`,
+	}})
+	defer cleanup()
+
+	c := &config.Config{Exts: map[string]interface{}{}}
+	gl := NewLanguage()
+	gl.Configure(c, "", nil)
+	result := gl.(language.RepoImporter).ImportRepos(language.ImportReposArgs{
+		Config: c,
+		Path:   filepath.Join(dir, "Gopkg.lock"),
+		Cache:  testRemoteCache(nil),
+	})
+	if result.Error == nil {
+		t.Fatal("got success; want error")
+	}
+	msg := result.Error.Error()
+	for _, want := range []string{"2 projects", "example.com/a:", "example.com/b:"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not contain %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "example.com/c") {
+		t.Errorf("error %q mentions project that was resolved", msg)
+	}
+}
+
+func TestMatchPrefixPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		globs, target string
+		want          bool
+	}{
+		{globs: "", target: "example.com/a", want: false},
+		{globs: "example.com", target: "example.com", want: true},
+		{globs: "example.com", target: "example.com/a/b", want: true},
+		{globs: "example.com", target: "example.community/a", want: false},
+		{globs: "example.com/a", target: "example.com", want: false},
+		{globs: "github.com/corp/*,gitlab.corp.com/*", target: "github.com/corp/repo/sub", want: true},
+		{globs: "github.com/corp/*,gitlab.corp.com/*", target: "gitlab.corp.com/team/repo", want: true},
+		{globs: "github.com/corp/*,gitlab.corp.com/*", target: "github.com/other/repo", want: false},
+		{globs: "*.corp.com", target: "git.corp.com/repo", want: true},
+		{globs: "example.com/...", target: "example.com/a/b", want: false},
+		{globs: "example.com/*", target: "example.com/a/b", want: true},
+		{globs: ",, example.com/a/ ,", target: "example.com/a/b", want: true},
+		{globs: "[,example.com", target: "example.com/a", want: true},
+	} {
+		if got := matchPrefixPatterns(tc.globs, tc.target); got != tc.want {
+			t.Errorf("matchPrefixPatterns(%q, %q): got %v; want %v", tc.globs, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestImportModulesSumDB(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "go.mod", Content: "module example.com/m\n"},
+		{Path: "go.sum", Content: "example.com/a v1.0.0 h1:a=\n"},
+	})
+	defer cleanup()
+
+	oldGoListModules, oldGoModDownload := goListModules, goModDownload
+	defer func() { goListModules, goModDownload = oldGoListModules, oldGoModDownload }()
+	goListModules = func(dir string, offline bool) ([]byte, error) {
+		return []byte(`{"Path": "example.com/m", "Main": true}
+{"Path": "example.com/a", "Version": "v1.0.0"}
+{"Path": "example.com/Upper", "Version": "v1.1.0"}
+{"Path": "corp.example.com/private", "Version": "v1.2.0"}
+`), nil
+	}
+	var downloaded []string
+	goModDownload = func(dir string, args []string) ([]byte, error) {
+		downloaded = append(downloaded, args...)
+		return []byte(`{"Path": "corp.example.com/private", "Version": "v1.2.0", "Sum": "h1:private="}`), nil
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lookup/example.com/!upper@v1.1.0" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `123
+example.com/Upper v1.1.0 h1:upper=
+example.com/Upper v1.1.0/go.mod h1:uppermod=
+
+go.sum database tree
+`)
+	}))
+	defer srv.Close()
+	for key, value := range map[string]string{
+		"GOSUMDB":   "sum.example.com " + srv.URL,
+		"GONOSUMDB": "corp.example.com",
+	} {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		if ok {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	c := &config.Config{Exts: map[string]interface{}{}}
+	gl := NewLanguage()
+	gl.Configure(c, "", nil)
+	getGoConfig(c).sumDBLookup = true
+	result := gl.(language.RepoImporter).ImportRepos(language.ImportReposArgs{
+		Config: c,
+		Path:   filepath.Join(dir, "go.mod"),
+		Cache:  testRemoteCache(nil),
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	sums := make(map[string]string)
+	for _, r := range result.Gen {
+		sums[r.AttrString("importpath")] = r.AttrString("sum")
+	}
+	wantSums := map[string]string{
+		"example.com/a":            "h1:a=",
+		"example.com/Upper":        "h1:upper=",
+		"corp.example.com/private": "h1:private=",
+	}
+	if !reflect.DeepEqual(sums, wantSums) {
+		t.Errorf("got sums %v; want %v", sums, wantSums)
+	}
+	if want := []string{"corp.example.com/private@v1.2.0"}; !reflect.DeepEqual(downloaded, want) {
+		t.Errorf("got downloaded modules %v; want %v", downloaded, want)
+	}
+}
+
+func TestImportModulesOffline(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "go.mod", Content: "module example.com/m\n\nrequire example.com/a v1.0.0\n"},
+		{Path: "go.sum", Content: "example.com/a v1.0.0 h1:a=\n"},
+		{Path: "modcache/cache/download/example.com/!upper/@v/v1.1.0.ziphash", Content: "h1:upper=\n"},
+	})
+	defer cleanup()
+
+	oldGoListModules, oldGoModDownload := goListModules, goModDownload
+	defer func() { goListModules, goModDownload = oldGoListModules, oldGoModDownload }()
+	var listModules string
+	goListModules = func(dir string, offline bool) ([]byte, error) {
+		if !offline {
+			t.Error("go list was not run offline")
+		}
+		return []byte(listModules), nil
+	}
+	goModDownload = func(dir string, args []string) ([]byte, error) {
+		t.Errorf("go mod download was run for %v", args)
+		return nil, fmt.Errorf("offline")
+	}
+	old, ok := os.LookupEnv("GOMODCACHE")
+	os.Setenv("GOMODCACHE", filepath.Join(dir, "modcache"))
+	if ok {
+		defer os.Setenv("GOMODCACHE", old)
+	} else {
+		defer os.Unsetenv("GOMODCACHE")
+	}
+
+	c := &config.Config{RepoRoot: dir, Exts: map[string]interface{}{}}
+	gl := NewLanguage()
+	gl.Configure(c, "", nil)
+	getGoConfig(c).offline = true
+
+	t.Run("import", func(t *testing.T) {
+		listModules = `{"Path": "example.com/m", "Main": true}
+{"Path": "example.com/a", "Version": "v1.0.0"}
+{"Path": "example.com/Upper", "Version": "v1.1.0"}
+`
+		result := gl.(language.RepoImporter).ImportRepos(language.ImportReposArgs{
+			Config: c,
+			Path:   filepath.Join(dir, "go.mod"),
+			Cache:  testRemoteCache(nil),
+		})
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		sums := make(map[string]string)
+		for _, r := range result.Gen {
+			sums[r.AttrString("importpath")] = r.AttrString("sum")
+		}
+		wantSums := map[string]string{
+			"example.com/a":     "h1:a=",
+			"example.com/Upper": "h1:upper=",
+		}
+		if !reflect.DeepEqual(sums, wantSums) {
+			t.Errorf("got sums %v; want %v", sums, wantSums)
+		}
+	})
+
+	t.Run("import_missing", func(t *testing.T) {
+		listModules = `{"Path": "example.com/m", "Main": true}
+{"Path": "example.com/a", "Version": "v1.0.0"}
+{"Path": "example.com/b", "Version": "v1.2.0"}
+`
+		result := gl.(language.RepoImporter).ImportRepos(language.ImportReposArgs{
+			Config: c,
+			Path:   filepath.Join(dir, "go.mod"),
+			Cache:  testRemoteCache(nil),
+		})
+		if result.Error == nil || !strings.Contains(result.Error.Error(), "example.com/b@v1.2.0") {
+			t.Errorf("got error %v; want error mentioning example.com/b@v1.2.0", result.Error)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		result := gl.(language.RepoUpdater).UpdateRepos(language.UpdateReposArgs{
+			Config:  c,
+			Imports: []string{"example.com/a", "example.com/Upper@v1.1.0"},
+			Cache:   testRemoteCache(nil),
+		})
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		var got []string
+		for _, r := range result.Gen {
+			got = append(got, fmt.Sprintf("%s %s %s", r.AttrString("importpath"), r.AttrString("version"), r.AttrString("sum")))
+		}
+		want := []string{"example.com/a v1.0.0 h1:a=", "example.com/Upper v1.1.0 h1:upper="}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	})
+
+	t.Run("update_errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			imp, wantErr string
+		}{
+			{imp: "example.com/b", wantErr: "not required in go.mod"},
+			{imp: "example.com/a@master", wantErr: "is not a version"},
+			{imp: "example.com/a@v1.1.0", wantErr: "not in go.sum or the module cache"},
+		} {
+			result := gl.(language.RepoUpdater).UpdateRepos(language.UpdateReposArgs{
+				Config:  c,
+				Imports: []string{tc.imp},
+				Cache:   testRemoteCache(nil),
+			})
+			if result.Error == nil || !strings.Contains(result.Error.Error(), tc.wantErr) {
+				t.Errorf("%s: got error %v; want error containing %q", tc.imp, result.Error, tc.wantErr)
+			}
+		}
+	})
+}
+
+func TestImportModulesGroups(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "go.mod",
+			Content: `module example.com/m
+
+require (
+	example.com/lib v1.0.0
+	example.com/lib/v2 v2.0.0
+	example.com/check v1.0.0
+	example.com/tool v1.0.0 // tools.go
+	example.com/sub v1.0.0 // indirect
+)
+
+require example.com/only v1.0.0
+`,
+		}, {
+			Path: "go.sum",
+			Content: `example.com/lib v1.0.0 h1:lib=
+example.com/lib/v2 v2.0.0 h1:lib2=
+example.com/check v1.0.0 h1:check=
+example.com/tool v1.0.0 h1:tool=
+example.com/sub v1.0.0 h1:sub=
+example.com/only v1.0.0 h1:only=
+example.com/nested v1.0.0 h1:nested=
+`,
+		}, {
+			Path:    "a.go",
+			Content: "package a\n\nimport _ \"example.com/lib/v2/x\"\n",
+		}, {
+			Path:    "a_test.go",
+			Content: "package a\n\nimport (\n\t_ \"example.com/check\"\n\t_ \"example.com/lib/v2\"\n)\n",
+		}, {
+			Path:    "b/b_test.go",
+			Content: "package b\n\nimport _ \"example.com/only/y\"\n",
+		}, {
+			Path:    "vendor/example.com/v/v.go",
+			Content: "package v\n\nimport _ \"example.com/sub\"\n",
+		}, {
+			Path:    "nested/go.mod",
+			Content: "module example.com/m/nested\n",
+		}, {
+			Path:    "nested/n.go",
+			Content: "package n\n\nimport _ \"example.com/nested\"\n",
+		},
+	})
+	defer cleanup()
+
+	oldGoListModules := goListModules
+	defer func() { goListModules = oldGoListModules }()
+	goListModules = func(dir string, offline bool) ([]byte, error) {
+		return []byte(`{"Path": "example.com/m", "Main": true}
+{"Path": "example.com/lib", "Version": "v1.0.0"}
+{"Path": "example.com/lib/v2", "Version": "v2.0.0"}
+{"Path": "example.com/check", "Version": "v1.0.0"}
+{"Path": "example.com/tool", "Version": "v1.0.0"}
+{"Path": "example.com/sub", "Version": "v1.0.0", "Indirect": true}
+{"Path": "example.com/only", "Version": "v1.0.0"}
+{"Path": "example.com/nested", "Version": "v1.0.0"}
+`), nil
+	}
+
+	c := &config.Config{Exts: map[string]interface{}{}}
+	gl := NewLanguage()
+	gl.Configure(c, "", nil)
+	result := gl.(language.RepoImporter).ImportRepos(language.ImportReposArgs{
+		Config: c,
+		Path:   filepath.Join(dir, "go.mod"),
+		Cache:  testRemoteCache(nil),
+		Group:  true,
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	want := map[string]language.RepoGroup{
+		"com_example_lib":    language.RepoGroupDirect,
+		"com_example_lib_v2": language.RepoGroupDirect,
+		"com_example_check":  language.RepoGroupTest,
+		"com_example_tool":   language.RepoGroupDirect,
+		"com_example_sub":    language.RepoGroupTransitive,
+		"com_example_only":   language.RepoGroupTest,
+		"com_example_nested": language.RepoGroupTransitive,
+	}
+	if !reflect.DeepEqual(result.Groups, want) {
+		t.Errorf("got groups %v; want %v", result.Groups, want)
+	}
+}
+
+func TestParseSumDBLookup(t *testing.T) {
+	body := `123
+example.com/a v1.0.0 h1:a=
+example.com/a v1.0.0/go.mod h1:amod=
+
+go.sum database tree
+`
+	if got, err := parseSumDBLookup(strings.NewReader(body), "example.com/a", "v1.0.0"); err != nil {
+		t.Error(err)
+	} else if got != "h1:a=" {
+		t.Errorf("got %q; want %q", got, "h1:a=")
+	}
+	if _, err := parseSumDBLookup(strings.NewReader(body), "example.com/b", "v1.0.0"); err == nil {
+		t.Error("got success for missing module; want error")
+	}
+}
+
+func TestApplyMajorVersionNaming(t *testing.T) {
+	newRepo := func(name, modPath string) *rule.Rule {
+		r := rule.NewRule("go_repository", name)
+		r.SetAttr("importpath", modPath)
+		return r
+	}
+	for _, tc := range []struct {
+		desc, naming string
+		existing     []*rule.Rule
+		gen          []*rule.Rule
+		want         []string
+		wantErr      string
+	}{
+		{
+			desc:   "suffix",
+			naming: majorVersionSuffix,
+			gen:    []*rule.Rule{newRepo("com_example_a_v2", "example.com/a/v2")},
+			want:   []string{"com_example_a_v2"},
+		}, {
+			desc:   "fold",
+			naming: majorVersionFold,
+			gen: []*rule.Rule{
+				newRepo("com_example_a_v2", "example.com/a/v2"),
+				newRepo("com_example_b", "example.com/b"),
+				newRepo("in_gopkg_yaml_v2", "gopkg.in/yaml.v2"),
+				newRepo("custom", "example.com/c/v3"),
+			},
+			want: []string{"com_example_a", "com_example_b", "in_gopkg_yaml_v2", "custom"},
+		}, {
+			desc:   "fold_collision",
+			naming: majorVersionFold,
+			gen: []*rule.Rule{
+				newRepo("com_example_a", "example.com/a"),
+				newRepo("com_example_a_v2", "example.com/a/v2"),
+				newRepo("com_example_b_v2", "example.com/b/v2"),
+				newRepo("com_example_b_v3", "example.com/b/v3"),
+			},
+			want: []string{"com_example_a", "com_example_a_v2", "com_example_b_v2", "com_example_b_v3"},
+		}, {
+			desc:     "fold_existing",
+			naming:   majorVersionFold,
+			existing: []*rule.Rule{newRepo("com_example_a", "example.com/a"), newRepo("com_example_b", "example.com/b/v2")},
+			gen: []*rule.Rule{
+				newRepo("com_example_a_v2", "example.com/a/v2"),
+				newRepo("com_example_b_v2", "example.com/b/v2"),
+			},
+			want: []string{"com_example_a_v2", "com_example_b"},
+		}, {
+			desc:   "error_collision",
+			naming: majorVersionError,
+			gen: []*rule.Rule{
+				newRepo("com_example_a", "example.com/a"),
+				newRepo("com_example_a_v2", "example.com/a/v2"),
+				newRepo("com_example_b_v2", "example.com/b/v2"),
+			},
+			wantErr: "modules example.com/a, example.com/a/v2 would all be provided by repository com_example_a",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := config.New()
+			gc := newGoConfig()
+			gc.majorVersionNaming = tc.naming
+			c.Exts[goName] = gc
+			c.Repos = tc.existing
+			err := applyMajorVersionNaming(c, tc.gen)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range tc.gen {
+				got = append(got, r.Name())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImportModulesLocalReplace(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "go.mod",
+			Content: `module example.com/m
+
+require (
+	example.com/a v1.0.0
+	example.com/local v1.0.0
+)
+
+replace example.com/local => ./third_party/local
+`,
+		},
+		{Path: "go.sum", Content: "example.com/a v1.0.0 h1:a=\n"},
+		{Path: "third_party/local/WORKSPACE"},
+		{Path: "third_party/local/go.mod", Content: "module example.com/local\n"},
+	})
+	defer cleanup()
+
+	localDir := filepath.Join(dir, "third_party", "local")
+	oldGoListModules := goListModules
+	defer func() { goListModules = oldGoListModules }()
+	goListModules = func(tempDir string, offline bool) ([]byte, error) {
+		data, err := ioutil.ReadFile(filepath.Join(tempDir, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+		if want := "replace example.com/local => " + strconv.Quote(localDir); !strings.Contains(string(data), want) {
+			return nil, fmt.Errorf("copied go.mod does not contain %q:\n%s", want, data)
+		}
+		return []byte(fmt.Sprintf(`{"Path": "example.com/m", "Main": true}
+{"Path": "example.com/a", "Version": "v1.0.0"}
+{"Path": "example.com/local", "Version": "v1.0.0", "Replace": {"Path": %q}}
+`, localDir)), nil
+	}
+
+	c := &config.Config{RepoRoot: dir, Exts: map[string]interface{}{}}
+	old := rule.NewRule("go_repository", "com_example_local")
+	old.SetAttr("importpath", "example.com/local")
+	c.Repos = []*rule.Rule{old}
+	gl := NewLanguage()
+	gl.Configure(c, "", nil)
+	result := gl.(language.RepoImporter).ImportRepos(language.ImportReposArgs{
+		Config: c,
+		Path:   filepath.Join(dir, "go.mod"),
+		Cache:  testRemoteCache(nil),
+	})
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	f := rule.EmptyFile("test", "")
+	for _, r := range result.Gen {
+		r.Insert(f)
+	}
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+go_repository(
+    name = "com_example_a",
+    importpath = "example.com/a",
+    sum = "h1:a=",
+    version = "v1.0.0",
+)
+
+local_repository(
+    name = "com_example_local",
+    path = "third_party/local",
+)
+`)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The go_repository for the replaced module can't be merged with the
+	// local_repository, so it's deleted, even without -prune.
+	if len(result.Empty) != 1 || result.Empty[0].Kind() != "go_repository" || result.Empty[0].Name() != "com_example_local" {
+		t.Errorf("got empty rules %v; want go_repository com_example_local", result.Empty)
+	}
+}
+
+func TestAbsReplacePaths(t *testing.T) {
+	dir := filepath.FromSlash("/src/m")
+	got := string(absReplacePaths([]byte(`module example.com/m
+
+replace example.com/a => ../a
+
+replace (
+	example.com/b v1.0.0 => "./b" // local
+	example.com/c => example.com/d v1.0.0
+	example.com/e => /abs/e
+)
+`), dir))
+	want := fmt.Sprintf(`module example.com/m
+
+replace example.com/a => %s
+
+replace (
+	example.com/b v1.0.0 => %s // local
+	example.com/c => example.com/d v1.0.0
+	example.com/e => /abs/e
+)
+`, strconv.Quote(filepath.Join(dir, "..", "a")), strconv.Quote(filepath.Join(dir, "b")))
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	data := []byte(`machine proxy.example.com login alice password secret
+machine incomplete.example.com login bob
+macdef init
+machine macro.example.com login m password m
+
+machine sum.example.com
+  login carol
+  password hunter2
+default login anon password anon
+machine after.example.com login x password x
+`)
+	got := parseNetrc(data)
+	want := []netrcLine{
+		{machine: "proxy.example.com", login: "alice", password: "secret"},
+		{machine: "sum.example.com", login: "carol", password: "hunter2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestAuthenticateNetrc(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path:    ".netrc",
+		Content: "machine sum.example.com login alice password secret\n",
+	}})
+	defer cleanup()
+	for key, value := range map[string]string{
+		"NETRC":  filepath.Join(dir, ".netrc"),
+		"GOAUTH": "netrc",
+	} {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		if ok {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+	netrcOnce, netrcLines = sync.Once{}, nil
+	defer func() { netrcOnce, netrcLines = sync.Once{}, nil }()
+
+	for _, tc := range []struct {
+		desc, url, goAuth string
+		wantAuth          bool
+	}{
+		{desc: "https", url: "https://sum.example.com/lookup/m@v1.0.0", goAuth: "netrc", wantAuth: true},
+		{desc: "http", url: "http://sum.example.com/lookup/m@v1.0.0", goAuth: "netrc"},
+		{desc: "other_host", url: "https://other.example.com/lookup/m@v1.0.0", goAuth: "netrc"},
+		{desc: "off", url: "https://sum.example.com/lookup/m@v1.0.0", goAuth: "off"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			os.Setenv("GOAUTH", tc.goAuth)
+			req, err := http.NewRequest("GET", tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			authenticate(req)
+			login, password, ok := req.BasicAuth()
+			if ok != tc.wantAuth {
+				t.Fatalf("got basic auth %v; want %v", ok, tc.wantAuth)
+			}
+			if ok && (login != "alice" || password != "secret") {
+				t.Errorf("got credentials %s:%s; want alice:secret", login, password)
+			}
+		})
+	}
+}
+
+func TestParseAuthCommandOutput(t *testing.T) {
+	out := []byte(`https://proxy.example.com
+https://sum.example.com
+
+Authorization: Bearer token
+X-Custom: yes
+
+https://proxy.example.com/private
+
+Authorization: Bearer private
+
+`)
+	sets, err := parseAuthCommandOutput(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		url, wantAuth, wantCustom string
+	}{
+		{url: "https://sum.example.com/lookup/m@v1.0.0", wantAuth: "Bearer token", wantCustom: "yes"},
+		{url: "https://proxy.example.com/private/@v/list", wantAuth: "Bearer private"},
+		{url: "https://other.example.com/"},
+	} {
+		h := matchCredentialSets(sets, tc.url)
+		if got := h.Get("Authorization"); got != tc.wantAuth {
+			t.Errorf("%s: got Authorization %q; want %q", tc.url, got, tc.wantAuth)
+		}
+		if got := h.Get("X-Custom"); got != tc.wantCustom {
+			t.Errorf("%s: got X-Custom %q; want %q", tc.url, got, tc.wantCustom)
+		}
+	}
+
+	if _, err := parseAuthCommandOutput([]byte("http://insecure.example.com\n\nAuthorization: x\n\n")); err == nil {
+		t.Error("got success for http:// prefix; want error")
+	}
+}
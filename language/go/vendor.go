@@ -0,0 +1,94 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// readVendorModules reads vendor/modules.txt in the repository root
+// directory, written by "go mod vendor", and returns a map from the import
+// path of each vendored package to the path of the module that provides
+// it. If there is no modules.txt file, nil is returned.
+func readVendorModules(fs walk.FS, repoRoot string) (map[string]string, error) {
+	txtPath := filepath.Join(repoRoot, "vendor", "modules.txt")
+	data, err := fs.ReadFile(txtPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pkgs, err := parseVendorModules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", txtPath, err)
+	}
+	return pkgs, nil
+}
+
+// parseVendorModules parses the contents of a vendor/modules.txt file.
+// Lines starting with "# " name a module, optionally followed by its
+// version and a replacement. Lines starting with "## " annotate the
+// preceding module and are ignored. Other lines are the import paths of
+// packages vendored from the preceding module.
+func parseVendorModules(data []byte) (map[string]string, error) {
+	pkgs := make(map[string]string)
+	modulePath := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "##"):
+			continue
+		case strings.HasPrefix(line, "#"):
+			fields := strings.Fields(strings.TrimPrefix(line, "#"))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("%d: missing module path", lineNum)
+			}
+			modulePath = fields[0]
+		default:
+			if modulePath == "" {
+				return nil, fmt.Errorf("%d: package %s is not in a module", lineNum, line)
+			}
+			pkgs[line] = modulePath
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// isUnvendoredDir returns whether rel is a directory in the vendor
+// directory in the repository root that doesn't contain a package listed in
+// vendor/modules.txt. The go command won't build these packages in module
+// mode, so no rules are generated for them. This is only checked with
+// -external=vendored when modules.txt is present.
+func isUnvendoredDir(gc *goConfig, rel string) bool {
+	if gc.depMode != vendorMode || gc.vendorPackages == nil || !strings.HasPrefix(rel, "vendor/") {
+		return false
+	}
+	_, ok := gc.vendorPackages[strings.TrimPrefix(rel, "vendor/")]
+	return !ok
+}
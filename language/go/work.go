@@ -0,0 +1,140 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// readWorkModules reads the go.work file in the repository root directory
+// and returns a map from the slash-separated, repository-relative directory
+// of each module in the workspace to its module path. Modules outside the
+// repository are ignored. If there is no go.work file, nil is returned.
+func readWorkModules(fs walk.FS, repoRoot string) (map[string]string, error) {
+	workPath := filepath.Join(repoRoot, "go.work")
+	data, err := fs.ReadFile(workPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dirs, err := parseWorkUses(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", workPath, err)
+	}
+
+	modules := make(map[string]string)
+	for _, dir := range dirs {
+		rel := path.Clean(filepath.ToSlash(dir))
+		if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if rel == "." {
+			rel = ""
+		}
+		modPath := filepath.Join(repoRoot, filepath.FromSlash(rel), "go.mod")
+		data, err := fs.ReadFile(modPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: module %s: %v", workPath, dir, err)
+		}
+		modulePath, err := parseModulePath(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", modPath, err)
+		}
+		modules[rel] = modulePath
+	}
+	return modules, nil
+}
+
+// parseWorkUses returns the directories named in "use" directives in the
+// contents of a go.work file.
+func parseWorkUses(data []byte) ([]string, error) {
+	var dirs []string
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if inBlock {
+			if fields[0] == ")" {
+				inBlock = false
+				continue
+			}
+		} else if fields[0] == "use" {
+			fields = fields[1:]
+			if len(fields) == 1 && fields[0] == "(" {
+				inBlock = true
+				continue
+			}
+		} else {
+			continue
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("line %d: invalid use directive", lineNum)
+		}
+		dir, err := unquoteModField(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		dirs = append(dirs, dir)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// parseModulePath returns the module path declared in the contents of
+// a go.mod file.
+func parseModulePath(data []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return unquoteModField(fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("module directive not found")
+}
+
+func unquoteModField(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "`") {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
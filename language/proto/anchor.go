@@ -0,0 +1,190 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// protoAnchor groups .proto files by proto package across the directories
+// under a root, set with the proto_anchor directive. Each package with files
+// in more than one directory gets a single proto_library in the anchor
+// directory, which lists files from other directories by label.
+type protoAnchor struct {
+	// dir is the directory where rules for the grouped packages are
+	// generated, relative to the repository root.
+	dir string
+
+	// packages maps the names of grouped proto packages to their files, as
+	// sorted, slash-separated paths relative to the repository root.
+	packages map[string][]string
+
+	// files is the set of files in packages.
+	files map[string]bool
+
+	// buildDirs is the set of directories under the root with build files,
+	// which are Bazel packages.
+	buildDirs map[string]bool
+}
+
+// scanAnchor reads the .proto files in the directory root and its
+// subdirectories and finds the proto packages split across directories.
+// Hidden directories and Bazel output directories are skipped.
+func scanAnchor(c *config.Config, root, dir string) (*protoAnchor, error) {
+	repoRoot := c.RepoRoot
+	pkgDirs := make(map[string]map[string]bool)
+	pkgFiles := make(map[string][]string)
+	buildDirs := map[string]bool{root: true}
+	fs := walk.GetFS(c)
+	var visit func(dir string) error
+	visit = func(dir string) error {
+		files, err := fs.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range files {
+			base := fi.Name()
+			p := filepath.Join(dir, base)
+			if fi.IsDir() {
+				if strings.HasPrefix(base, ".") || strings.HasPrefix(base, "bazel-") {
+					continue
+				}
+				if err := visit(p); err != nil {
+					return err
+				}
+				continue
+			}
+			relPath, err := filepath.Rel(repoRoot, p)
+			if err != nil {
+				return err
+			}
+			rel := filepath.ToSlash(relPath)
+			if c.IsValidBuildFileName(base) {
+				buildDir := path.Dir(rel)
+				if buildDir == "." {
+					buildDir = ""
+				}
+				buildDirs[buildDir] = true
+			}
+			if !strings.HasSuffix(base, ".proto") {
+				continue
+			}
+			info := protoFileInfo(fs, dir, base)
+			if info.PackageName == "" {
+				continue
+			}
+			if pkgDirs[info.PackageName] == nil {
+				pkgDirs[info.PackageName] = make(map[string]bool)
+			}
+			pkgDirs[info.PackageName][path.Dir(rel)] = true
+			pkgFiles[info.PackageName] = append(pkgFiles[info.PackageName], rel)
+		}
+		return nil
+	}
+	err := visit(filepath.Join(repoRoot, filepath.FromSlash(root)))
+	if err != nil {
+		return nil, err
+	}
+
+	a := &protoAnchor{
+		dir:       dir,
+		packages:  make(map[string][]string),
+		files:     make(map[string]bool),
+		buildDirs: buildDirs,
+	}
+	for name, dirs := range pkgDirs {
+		if len(dirs) < 2 {
+			continue
+		}
+		files := pkgFiles[name]
+		sort.Strings(files)
+		a.packages[name] = files
+		for _, f := range files {
+			a.files[f] = true
+		}
+	}
+	return a, nil
+}
+
+// filter returns the files in the directory rel that aren't in a package
+// grouped in the anchor directory.
+func (a *protoAnchor) filter(rel string, files []string) []string {
+	var kept []string
+	for _, f := range files {
+		if !a.files[path.Join(rel, f)] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// buildPackages returns a Package for each grouped proto package. Files are
+// named as they appear in srcs of a rule in the anchor directory. See src.
+func (a *protoAnchor) buildPackages(fs walk.FS, repoRoot string) []*Package {
+	names := make([]string, 0, len(a.packages))
+	for name := range a.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pkgs := make([]*Package, 0, len(names))
+	for _, name := range names {
+		pkg := newPackage(name)
+		for _, f := range a.packages[name] {
+			info := protoFileInfo(fs, filepath.Join(repoRoot, filepath.FromSlash(path.Dir(f))), path.Base(f))
+			info.Name = a.src(f)
+			pkg.addFile(info)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// srcs returns the srcs of rules generated in the anchor directory.
+func (a *protoAnchor) srcs() []string {
+	srcs := make([]string, 0, len(a.files))
+	for f := range a.files {
+		srcs = append(srcs, a.src(f))
+	}
+	return srcs
+}
+
+// src returns the string that refers to the file f, a path relative to the
+// repository root, in srcs of a rule in the anchor directory. If f is in
+// the anchor directory's Bazel package, because there are no build files in
+// the directories between them, f is named by its path relative to the
+// anchor directory. Otherwise, f is named by a label in the package of the
+// closest directory with a build file.
+func (a *protoAnchor) src(f string) string {
+	for dir := path.Dir(f); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		if dir == a.dir {
+			return pathtools.TrimPrefix(f, dir)
+		}
+		if a.buildDirs[dir] || dir == "" {
+			return label.New("", dir, pathtools.TrimPrefix(f, dir)).String()
+		}
+	}
+}
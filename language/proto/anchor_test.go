@@ -0,0 +1,67 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestScanAnchor(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "proto/BUILD.old"},
+		{Path: "proto/common/common.proto", Content: "package foo.v1;"},
+		{Path: "proto/a/a.proto", Content: "package foo.v1;"},
+		{Path: "proto/b/BUILD.old"},
+		{Path: "proto/b/x/b.proto", Content: "package foo.v1;"},
+		{Path: "proto/c/c.proto", Content: "package bar;"},
+		{Path: "proto/c/d.proto", Content: "package bar;"},
+		{Path: "proto/.hidden/e.proto", Content: "package bar;"},
+		{Path: "other/f.proto", Content: "package foo.v1;"},
+	})
+	defer cleanup()
+	c, _, _ := testConfig(t, dir)
+
+	a, err := scanAnchor(c, "proto", "proto/common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPackages := map[string][]string{
+		"foo.v1": {"proto/a/a.proto", "proto/b/x/b.proto", "proto/common/common.proto"},
+	}
+	if !reflect.DeepEqual(a.packages, wantPackages) {
+		t.Errorf("got packages %v; want %v", a.packages, wantPackages)
+	}
+
+	var srcs []string
+	for _, f := range a.packages["foo.v1"] {
+		srcs = append(srcs, a.src(f))
+	}
+	wantSrcs := []string{"//proto:a/a.proto", "//proto/b:x/b.proto", "common.proto"}
+	if !reflect.DeepEqual(srcs, wantSrcs) {
+		t.Errorf("got srcs %q; want %q", srcs, wantSrcs)
+	}
+
+	got := a.filter("proto/c", []string{"c.proto", "d.proto"})
+	if want := []string{"c.proto", "d.proto"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got filtered files %q; want %q", got, want)
+	}
+	if got := a.filter("proto/a", []string{"a.proto"}); len(got) != 0 {
+		t.Errorf("got filtered files %q; want none", got)
+	}
+}
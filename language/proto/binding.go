@@ -0,0 +1,381 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Binding describes a rule that generates code for another language from a
+// proto_library, like py_proto_library or java_proto_library, or another
+// output, like proto_descriptor_set. When a binding is enabled with the
+// proto_bindings directive, Gazelle generates a rule of its kind next to each
+// proto_library.
+//
+// Bindings are built in, registered with RegisterBinding, or defined with
+// the -proto_binding_template flag. Go bindings are generated by the Go
+// extension and are not described with Binding.
+type Binding struct {
+	// Lang is the name of the binding in the proto_bindings directive, for
+	// example, "py".
+	Lang string
+
+	// Kind is the kind of rule generated, for example, "py_proto_library".
+	Kind string
+
+	// Load is the .bzl file Kind is loaded from. If empty, Kind is a native
+	// rule and no load is written for it.
+	Load string
+
+	// Suffix replaces the "_proto" suffix of the proto_library name to form
+	// the name of the generated rule. For example, with the suffix
+	// "_py_pb2", "foo_py_pb2" is generated for "foo_proto".
+	Suffix string
+
+	// ProtoAttr is the attribute that names the proto_library. If empty,
+	// "deps" is used.
+	ProtoAttr string
+
+	// SingleProto indicates that ProtoAttr takes a single label, like the
+	// proto attribute of go_proto_library, instead of a list.
+	SingleProto bool
+
+	// DepsAttr, if set, is an attribute that lists the rules generated for
+	// this binding in the packages of the proto_library's dependencies. Their
+	// names are formed with Suffix, so the attribute mirrors the
+	// proto_library's deps. Rules that follow proto_library deps with an
+	// aspect don't need this.
+	DepsAttr string
+
+	// LiteKind, if set, is the kind of rule generated for the lite runtime,
+	// for example, "java_lite_proto_library". Lite variants are generated
+	// next to the regular rules when enabled with the proto_lite directive.
+	LiteKind string
+
+	// LiteSuffix is the suffix used to name lite variants, like Suffix. If
+	// empty, "_lite" is appended to Suffix.
+	LiteSuffix string
+}
+
+// bindings is the list of bindings that may be enabled with the
+// proto_bindings directive. More may be added with RegisterBinding.
+var bindings = []Binding{
+	{
+		Lang:   "cc",
+		Kind:   "cc_proto_library",
+		Suffix: "_cc_proto",
+	}, {
+		Lang:     "java",
+		Kind:     "java_proto_library",
+		Suffix:   "_java_proto",
+		LiteKind: "java_lite_proto_library",
+	}, {
+		Lang:   "py",
+		Kind:   "py_proto_library",
+		Load:   "@rules_python//python:proto.bzl",
+		Suffix: "_py_pb2",
+	}, {
+		// proto_descriptor_set collects the descriptors of the proto_library
+		// and its transitive deps, so it doesn't need DepsAttr.
+		Lang:   "descriptor_set",
+		Kind:   "proto_descriptor_set",
+		Load:   "@rules_proto//proto:defs.bzl",
+		Suffix: "_descriptor_set",
+	},
+}
+
+// RegisterBinding makes b available to the proto_bindings directive. It
+// replaces a binding registered earlier with the same Lang. Since the kinds
+// Gazelle knows about are collected when it starts, RegisterBinding must be
+// called before then, for example, in an init function in a package linked
+// into a gazelle_binary.
+func RegisterBinding(b Binding) {
+	for i := range bindings {
+		if bindings[i].Lang == b.Lang {
+			bindings[i] = b
+			return
+		}
+	}
+	bindings = append(bindings, b)
+}
+
+// findBinding returns the binding named lang. Bindings defined with
+// -proto_binding_template take precedence over registered bindings.
+func findBinding(pc *ProtoConfig, lang string) (Binding, bool) {
+	for _, bs := range [][]Binding{pc.bindingTemplates, bindings} {
+		for _, b := range bs {
+			if b.Lang == lang {
+				return b, true
+			}
+		}
+	}
+	return Binding{}, false
+}
+
+// findBindingByKind returns the binding that generates rules of kind. If
+// kind is the LiteKind of a binding, its lite variant is returned.
+func findBindingByKind(pc *ProtoConfig, kind string) (Binding, bool) {
+	for _, bs := range [][]Binding{pc.bindingTemplates, bindings} {
+		for _, b := range bs {
+			if b.Kind == kind {
+				return b, true
+			}
+			if lb, ok := b.lite(); ok && lb.Kind == kind {
+				return lb, true
+			}
+		}
+	}
+	return Binding{}, false
+}
+
+// isBindingKind returns whether kind is the kind of a registered binding or
+// one defined with -proto_binding_template.
+func isBindingKind(pc *ProtoConfig, kind string) bool {
+	_, ok := findBindingByKind(pc, kind)
+	return ok
+}
+
+// parseBindingTemplate parses the value of the -proto_binding_template flag,
+// which defines a binding without a Go extension. The value has the form
+//
+//	lang kind [load=file] [suffix=suffix] [proto_attr=attr] [single_proto=bool] [deps_attr=attr] [lite_kind=kind] [lite_suffix=suffix]
+//
+// For example, "ts ts_proto_library load=@aspect_rules_ts//ts:proto.bzl
+// proto_attr=proto single_proto=true". If suffix isn't set, "_<lang>_proto"
+// is used.
+func parseBindingTemplate(value string) (Binding, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return Binding{}, fmt.Errorf("invalid binding template %q: want lang kind [key=value...]", value)
+	}
+	b := Binding{
+		Lang:   fields[0],
+		Kind:   fields[1],
+		Suffix: "_" + fields[0] + "_proto",
+	}
+	for _, field := range fields[2:] {
+		i := strings.IndexByte(field, '=')
+		if i < 0 {
+			return Binding{}, fmt.Errorf("invalid binding template %q: %q is not of the form key=value", value, field)
+		}
+		key, val := field[:i], field[i+1:]
+		switch key {
+		case "load":
+			b.Load = val
+		case "suffix":
+			b.Suffix = val
+		case "proto_attr":
+			b.ProtoAttr = val
+		case "single_proto":
+			single, err := strconv.ParseBool(val)
+			if err != nil {
+				return Binding{}, fmt.Errorf("invalid binding template %q: single_proto must be true or false", value)
+			}
+			b.SingleProto = single
+		case "deps_attr":
+			b.DepsAttr = val
+		case "lite_kind":
+			b.LiteKind = val
+		case "lite_suffix":
+			b.LiteSuffix = val
+		default:
+			return Binding{}, fmt.Errorf("invalid binding template %q: unknown key %q; want load, suffix, proto_attr, single_proto, deps_attr, lite_kind, or lite_suffix", value, key)
+		}
+	}
+	if b.Suffix == "" || b.Suffix == "_proto" {
+		return Binding{}, fmt.Errorf("invalid binding template %q: suffix must not be empty or %q", value, "_proto")
+	}
+	if b.SingleProto && b.DepsAttr == b.protoAttr() {
+		return Binding{}, fmt.Errorf("invalid binding template %q: deps_attr must differ from proto_attr when single_proto is set", value)
+	}
+	return b, nil
+}
+
+// bindingKey is the private attribute that holds the Binding of a generated
+// binding rule.
+const bindingKey = "_proto_binding"
+
+func (b Binding) protoAttr() string {
+	if b.ProtoAttr == "" {
+		return "deps"
+	}
+	return b.ProtoAttr
+}
+
+// lite returns the variant of b that generates rules for the lite runtime.
+// false is returned if b has no lite variant.
+func (b Binding) lite() (Binding, bool) {
+	if b.LiteKind == "" {
+		return Binding{}, false
+	}
+	lb := b
+	lb.Kind = b.LiteKind
+	lb.Suffix = b.LiteSuffix
+	if lb.Suffix == "" {
+		lb.Suffix = b.Suffix + "_lite"
+	}
+	lb.LiteKind, lb.LiteSuffix = "", ""
+	return lb, true
+}
+
+// ruleName returns the name of the rule generated for the proto_library
+// named protoName.
+func (b Binding) ruleName(protoName string) string {
+	return strings.TrimSuffix(protoName, "_proto") + b.Suffix
+}
+
+// kindInfo returns information about the kind of rule b generates.
+func (b Binding) kindInfo() rule.KindInfo {
+	info := rule.KindInfo{
+		MatchAttrs:     []string{b.protoAttr()},
+		NonEmptyAttrs:  map[string]bool{b.protoAttr(): true},
+		MergeableAttrs: map[string]bool{b.protoAttr(): true},
+	}
+	if b.DepsAttr != "" {
+		info.ResolveAttrs = map[string]bool{b.DepsAttr: true}
+	}
+	return info
+}
+
+// generateBindings returns rules for the bindings enabled in pc, generated
+// for the proto_library r, including lite variants enabled with proto_lite.
+// If empty is true, the rules have no attributes and may be used to delete
+// stale bindings.
+func generateBindings(pc *ProtoConfig, r *rule.Rule, empty bool) []*rule.Rule {
+	var gen []*rule.Rule
+	for _, lang := range pc.bindings {
+		b, ok := findBinding(pc, lang)
+		if !ok {
+			continue // reported in Configure
+		}
+		gen = append(gen, generateBinding(b, r, empty))
+		if lb, ok := b.lite(); ok && (empty && pc.lite != liteOff || !empty && wantsLite(pc, r)) {
+			gen = append(gen, generateBinding(lb, r, empty))
+		}
+	}
+	return gen
+}
+
+// staleLiteBindings returns empty lite variants of the bindings enabled in
+// pc for the proto_library r, when proto_lite is auto and r doesn't need
+// them. They may be used to delete lite variants generated earlier.
+func staleLiteBindings(pc *ProtoConfig, r *rule.Rule) []*rule.Rule {
+	if pc.lite != liteAuto || wantsLite(pc, r) {
+		return nil
+	}
+	var empty []*rule.Rule
+	for _, lang := range pc.bindings {
+		if b, ok := findBinding(pc, lang); ok {
+			if lb, ok := b.lite(); ok {
+				empty = append(empty, generateBinding(lb, r, true))
+			}
+		}
+	}
+	return empty
+}
+
+// wantsLite returns whether lite variants of bindings should be generated
+// for the proto_library r.
+func wantsLite(pc *ProtoConfig, r *rule.Rule) bool {
+	switch pc.lite {
+	case liteAll:
+		return true
+	case liteAuto:
+		pkg, ok := r.PrivateAttr(PackageKey).(Package)
+		return ok && pkg.LiteRuntime
+	default:
+		return false
+	}
+}
+
+// generateBinding returns a rule for the binding b, generated for the
+// proto_library r. If empty is true, the rule has no attributes.
+func generateBinding(b Binding, r *rule.Rule, empty bool) *rule.Rule {
+	br := rule.NewRule(b.Kind, b.ruleName(r.Name()))
+	if empty {
+		return br
+	}
+	if b.SingleProto {
+		br.SetAttr(b.protoAttr(), ":"+r.Name())
+	} else {
+		br.SetAttr(b.protoAttr(), []string{":" + r.Name()})
+	}
+	if vis := r.AttrStrings("visibility"); vis != nil {
+		br.SetAttr("visibility", vis)
+	}
+	br.SetPrivateAttr(bindingKey, b)
+	br.SetPrivateAttr(config.GazelleImportsKey, r.PrivateAttr(config.GazelleImportsKey))
+	return br
+}
+
+// Values of the proto_lite directive.
+const (
+	liteOff  = "off"
+	liteAuto = "auto"
+	liteAll  = "all"
+)
+
+// resolveBinding sets the DepsAttr attribute of the binding rule r, if its
+// binding has one. Imports are resolved to proto_library rules, then mapped
+// to the rules generated for the binding next to them.
+func resolveBinding(c *config.Config, ix *resolve.RuleIndex, b Binding, r *rule.Rule, imports []string, from label.Label) {
+	if b.DepsAttr == "" {
+		return
+	}
+	protos := r.AttrStrings(b.protoAttr())
+	if b.SingleProto {
+		protos = []string{r.AttrString(b.protoAttr())}
+	}
+	protoFrom := from
+	if len(protos) == 1 {
+		if l, err := label.Parse(protos[0]); err == nil {
+			protoFrom = l.Abs(from.Repo, from.Pkg)
+		}
+	}
+	depSet := make(map[string]bool)
+	if b.DepsAttr == b.protoAttr() {
+		// The proto_library and the mirrored deps share an attribute.
+		for _, p := range protos {
+			depSet[p] = true
+		}
+	}
+	for _, imp := range imports {
+		l, _, err := resolveProto(c, ix, r, imp, protoFrom)
+		if err != nil {
+			// Errors are reported when resolving the proto_library.
+			continue
+		}
+		l.Name = b.ruleName(l.Name)
+		depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+	}
+	r.DelAttr(b.DepsAttr)
+	if len(depSet) > 0 {
+		deps := make([]string, 0, len(depSet))
+		for dep := range depSet {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		r.SetAttr(b.DepsAttr, deps)
+	}
+}
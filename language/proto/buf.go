@@ -0,0 +1,414 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// defaultBufDepsRepo is the name of the repository that provides .proto
+// files from Buf modules the workspace depends on. This is the name
+// rules_buf's buf_dependencies rule is usually given.
+const defaultBufDepsRepo = "buf_deps"
+
+// bufModule describes a Buf module in the repository: a directory that
+// .proto files in it are imported relative to.
+type bufModule struct {
+	// root is the slash-separated path of the module's directory, relative
+	// to the repository root.
+	root string
+
+	// name is the module's name on the Buf Schema Registry, like
+	// "buf.build/acme/weather". It may be empty.
+	name string
+
+	// deps lists names of modules this module depends on, without versions
+	// or commits.
+	deps []string
+}
+
+// readBufConfig reads buf.work.yaml, buf.yaml, and buf.lock in the
+// directory rel, if they're present, and returns the Buf modules they
+// declare. For buf.work.yaml, each module is read from the buf.yaml and
+// buf.lock files in its directory.
+func readBufConfig(fs walk.FS, repoRoot, rel string) ([]bufModule, error) {
+	dir := filepath.Join(repoRoot, filepath.FromSlash(rel))
+	work, err := readYAMLFile(fs, filepath.Join(dir, "buf.work.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if work != nil {
+		var mods []bufModule
+		for _, d := range yamlStrings(yamlLookup(work, "directories")) {
+			modRel := path.Join(rel, d)
+			mod := bufModule{root: modRel}
+			modYAML, err := readYAMLFile(fs, filepath.Join(repoRoot, filepath.FromSlash(modRel), "buf.yaml"))
+			if err != nil {
+				return nil, err
+			}
+			if modYAML != nil {
+				mod.name = yamlString(yamlLookup(modYAML, "name"))
+				mod.deps = yamlStrings(yamlLookup(modYAML, "deps"))
+			}
+			if err := readBufLock(fs, filepath.Join(repoRoot, filepath.FromSlash(modRel)), &mod.deps); err != nil {
+				return nil, err
+			}
+			mods = append(mods, mod)
+		}
+		return mods, nil
+	}
+
+	cfg, err := readYAMLFile(fs, filepath.Join(dir, "buf.yaml"))
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	var mods []bufModule
+	deps := yamlStrings(yamlLookup(cfg, "deps"))
+	if err := readBufLock(fs, dir, &deps); err != nil {
+		return nil, err
+	}
+	switch yamlString(yamlLookup(cfg, "version")) {
+	case "v2":
+		// A v2 buf.yaml lists modules in subdirectories. Dependencies are
+		// shared by all of them.
+		for _, m := range yamlList(yamlLookup(cfg, "modules")) {
+			mods = append(mods, bufModule{
+				root: path.Join(rel, yamlString(yamlLookup(m, "path"))),
+				name: yamlString(yamlLookup(m, "name")),
+				deps: deps,
+			})
+		}
+		if len(mods) == 0 {
+			mods = append(mods, bufModule{root: rel, deps: deps})
+		}
+	case "v1beta1":
+		// Old modules may list import roots within the module directory.
+		name := yamlString(yamlLookup(cfg, "name"))
+		for _, r := range yamlStrings(yamlLookup(yamlLookup(cfg, "build"), "roots")) {
+			mods = append(mods, bufModule{root: path.Join(rel, r), name: name, deps: deps})
+		}
+		if len(mods) == 0 {
+			mods = append(mods, bufModule{root: rel, name: name, deps: deps})
+		}
+	default:
+		mods = append(mods, bufModule{root: rel, name: yamlString(yamlLookup(cfg, "name")), deps: deps})
+	}
+	return mods, nil
+}
+
+// readBufLock reads the names of pinned dependencies from buf.lock in dir,
+// if it's present, and adds them to deps. buf.lock lists dependencies
+// resolved transitively, so it may name modules buf.yaml doesn't.
+func readBufLock(fs walk.FS, dir string, deps *[]string) error {
+	lock, err := readYAMLFile(fs, filepath.Join(dir, "buf.lock"))
+	if err != nil || lock == nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for i, d := range *deps {
+		(*deps)[i] = bufModuleName(d)
+		have[(*deps)[i]] = true
+	}
+	for _, d := range yamlList(yamlLookup(lock, "deps")) {
+		var name string
+		if n := yamlString(yamlLookup(d, "name")); n != "" {
+			// buf.lock v2
+			name = n
+		} else {
+			remote := yamlString(yamlLookup(d, "remote"))
+			owner := yamlString(yamlLookup(d, "owner"))
+			repo := yamlString(yamlLookup(d, "repository"))
+			if remote == "" || owner == "" || repo == "" {
+				continue
+			}
+			name = remote + "/" + owner + "/" + repo
+		}
+		if !have[name] {
+			*deps = append(*deps, name)
+			have[name] = true
+		}
+	}
+	return nil
+}
+
+// bufModuleName strips the version, label, or commit from a module
+// reference in buf.yaml, like "buf.build/acme/units:v1.2.0".
+func bufModuleName(ref string) string {
+	if i := strings.IndexByte(ref, ':'); i >= 0 {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// readYAMLFile parses the YAML file at path. nil is returned without an
+// error if the file doesn't exist.
+func readYAMLFile(fs walk.FS, path string) (interface{}, error) {
+	data, err := fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	return v, nil
+}
+
+// parseYAML parses the subset of YAML used in Buf configuration files:
+// block mappings and sequences, plain and quoted scalars, flow sequences of
+// scalars, and comments. Mappings are returned as map[string]interface{},
+// sequences as []interface{}, and scalars as strings.
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.parseNode(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num, indent int
+	text        string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseNode parses a block sequence or mapping starting at the current line,
+// which has the given indentation.
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isYAMLSeqItem(p.lines[p.i].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	var seq []interface{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isYAMLSeqItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		content := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		switch {
+		case content == "":
+			p.i++
+			if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+				v, err := p.parseNode(p.lines[p.i].indent)
+				if err != nil {
+					return nil, err
+				}
+				seq = append(seq, v)
+			} else {
+				seq = append(seq, nil)
+			}
+		case yamlKeyEnd(content) >= 0:
+			// A mapping starts on the same line as the item. Its keys are
+			// indented to the same column as the first key.
+			p.lines[p.i] = yamlLine{num: l.num, indent: l.indent + len(l.text) - len(content), text: content}
+			v, err := p.parseMap(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		default:
+			v, err := parseYAMLScalar(content)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", l.num, err)
+			}
+			seq = append(seq, v)
+			p.i++
+		}
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && !isYAMLSeqItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		end := yamlKeyEnd(l.text)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: expected key: value", l.num)
+		}
+		key, err := parseYAMLScalar(l.text[:end])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", l.num, err)
+		}
+		value := strings.TrimSpace(l.text[end+1:])
+		p.i++
+		if value != "" {
+			v, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", l.num, err)
+			}
+			m[key.(string)] = v
+			continue
+		}
+		// The value is a block on the following lines. Sequences may have the
+		// same indentation as their key.
+		if p.i < len(p.lines) && (p.lines[p.i].indent > indent || p.lines[p.i].indent == indent && isYAMLSeqItem(p.lines[p.i].text)) {
+			v, err := p.parseNode(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key.(string)] = v
+		} else {
+			m[key.(string)] = nil
+		}
+	}
+	return m, nil
+}
+
+// yamlKeyEnd returns the index of the colon that ends the mapping key at
+// the start of text, or -1 if text doesn't start with a key.
+func yamlKeyEnd(text string) int {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return -1
+	}
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		if i := strings.IndexByte(text[1:], text[0]); i >= 0 {
+			start = i + 2
+		}
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLScalar parses a plain or quoted scalar, or a flow sequence of
+// scalars like "[a, b]".
+func parseYAMLScalar(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence %s", s)
+		}
+		var seq []interface{}
+		for _, e := range strings.Split(s[1:len(s)-1], ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			v, err := parseYAMLScalar(e)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	}
+	if strings.HasPrefix(s, "{") {
+		return nil, fmt.Errorf("flow mappings are not supported")
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strconv.Unquote(s)
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	return s, nil
+}
+
+// stripYAMLComment removes a comment from the end of a line. A comment
+// starts with '#' at the beginning of the line or after a space, outside
+// of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlLookup returns the value of key in v if v is a mapping.
+func yamlLookup(v interface{}, key string) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m[key]
+	}
+	return nil
+}
+
+func yamlString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func yamlList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+// yamlStrings returns the scalar elements of v if v is a sequence.
+func yamlStrings(v interface{}) []string {
+	var strs []string
+	for _, e := range yamlList(v) {
+		if s, ok := e.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,221 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+func TestParseYAML(t *testing.T) {
+	for _, tc := range []struct {
+		desc, data string
+		want       interface{}
+		wantErr    bool
+	}{
+		{
+			desc: "empty",
+			data: "# just a comment\n",
+		}, {
+			desc: "scalars",
+			data: `version: v1
+name: "buf.build/acme/weather" # comment
+other: 'it''s'
+`,
+			want: map[string]interface{}{
+				"version": "v1",
+				"name":    "buf.build/acme/weather",
+				"other":   "it's",
+			},
+		}, {
+			desc: "sequences",
+			data: `directories:
+- proto
+-   vendor/proto
+deps:
+  - buf.build/googleapis/googleapis
+flow: [a, "b"]
+`,
+			want: map[string]interface{}{
+				"directories": []interface{}{"proto", "vendor/proto"},
+				"deps":        []interface{}{"buf.build/googleapis/googleapis"},
+				"flow":        []interface{}{"a", "b"},
+			},
+		}, {
+			desc: "nested",
+			data: `build:
+  roots:
+    - a
+modules:
+  - path: x
+    name: buf.build/acme/x
+  -
+    path: y
+lint:
+`,
+			want: map[string]interface{}{
+				"build": map[string]interface{}{
+					"roots": []interface{}{"a"},
+				},
+				"modules": []interface{}{
+					map[string]interface{}{"path": "x", "name": "buf.build/acme/x"},
+					map[string]interface{}{"path": "y"},
+				},
+				"lint": nil,
+			},
+		}, {
+			desc:    "bad_indent",
+			data:    "a:\n    b: c\n  d: e\n",
+			wantErr: true,
+		}, {
+			desc:    "flow_mapping",
+			data:    "a: {b: c}\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseYAML([]byte(tc.data))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %#v; want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadBufConfig(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		files map[string]string
+		want  []bufModule
+	}{
+		{
+			desc: "none",
+		}, {
+			desc: "v1",
+			files: map[string]string{
+				"buf.yaml": `version: v1
+name: buf.build/acme/weather
+deps:
+  - buf.build/googleapis/googleapis:v1
+`,
+				"buf.lock": `version: v1
+deps:
+  - remote: buf.build
+    owner: googleapis
+    repository: googleapis
+    commit: 62f35d8aed1149c291d606d958a7ce32
+  - remote: buf.build
+    owner: acme
+    repository: units
+`,
+			},
+			want: []bufModule{{
+				root: "",
+				name: "buf.build/acme/weather",
+				deps: []string{"buf.build/googleapis/googleapis", "buf.build/acme/units"},
+			}},
+		}, {
+			desc: "v1beta1_roots",
+			files: map[string]string{
+				"buf.yaml": `version: v1beta1
+build:
+  roots:
+    - proto
+    - vendor
+`,
+			},
+			want: []bufModule{{root: "proto"}, {root: "vendor"}},
+		}, {
+			desc: "workspace",
+			files: map[string]string{
+				"buf.work.yaml": `version: v1
+directories:
+  - proto
+  - third_party
+`,
+				"proto/buf.yaml": `version: v1
+name: buf.build/acme/weather
+deps: [buf.build/acme/units]
+`,
+			},
+			want: []bufModule{
+				{root: "proto", name: "buf.build/acme/weather", deps: []string{"buf.build/acme/units"}},
+				{root: "third_party"},
+			},
+		}, {
+			desc: "v2",
+			files: map[string]string{
+				"buf.yaml": `version: v2
+modules:
+  - path: proto
+    name: buf.build/acme/weather
+deps:
+  - buf.build/acme/units
+`,
+				"buf.lock": `version: v2
+deps:
+  - name: buf.build/acme/units
+    commit: 0123
+  - name: buf.build/acme/si
+    commit: 4567
+`,
+			},
+			want: []bufModule{{
+				root: "proto",
+				name: "buf.build/acme/weather",
+				deps: []string{"buf.build/acme/units", "buf.build/acme/si"},
+			}},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "buf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range tc.files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := readBufConfig(walk.OSFS{}, dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
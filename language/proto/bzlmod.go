@@ -0,0 +1,83 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/bzlmod"
+)
+
+// legacyRepoModules maps the names of repositories the proto extension
+// refers to, as they're usually declared in WORKSPACE, to the names of the
+// Bazel modules that provide them. With Bzlmod, a module is visible in the
+// main repository under its name, or the repo_name given in its bazel_dep,
+// so the WORKSPACE names may not exist.
+var legacyRepoModules = map[string]string{
+	"com_google_protobuf": "protobuf",
+	"googleapis":          "googleapis",
+	"rules_proto":         "rules_proto",
+	"rules_python":        "rules_python",
+}
+
+// readModuleRepos reads the bazel_dep calls in MODULE.bazel in the
+// repository root. It returns a map from the names of the modules to the
+// names of their repositories in the main repository. nil is returned if
+// there is no MODULE.bazel file.
+func readModuleRepos(c *config.Config) (map[string]string, error) {
+	f, err := bzlmod.Load(c, c.RepoRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return bzlmod.RepoNames(f), nil
+}
+
+// apparentRepo returns the name that refers to the repository repo, named
+// as it would be in WORKSPACE, from the main repository. If the module that
+// provides it is declared with bazel_dep, the module's repository name is
+// returned. Otherwise, repo is returned unchanged.
+func apparentRepo(moduleRepos map[string]string, repo string) string {
+	module, ok := legacyRepoModules[repo]
+	if !ok {
+		return repo
+	}
+	if apparent, ok := moduleRepos[module]; ok {
+		return apparent
+	}
+	return repo
+}
+
+// apparentLoad returns the label of a .bzl file, like
+// "@rules_proto//proto:defs.bzl", with its repository renamed by
+// apparentRepo.
+func apparentLoad(moduleRepos map[string]string, load string) string {
+	if !strings.HasPrefix(load, "@") {
+		return load
+	}
+	i := strings.Index(load, "//")
+	if i < 0 {
+		return load
+	}
+	repo := load[1:i]
+	if apparent := apparentRepo(moduleRepos, repo); apparent != repo {
+		return "@" + apparent + load[i:]
+	}
+	return load
+}
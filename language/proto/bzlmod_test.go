@@ -0,0 +1,74 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestReadModuleRepos(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "MODULE.bazel",
+		Content: `module(name = "example")
+
+bazel_dep(name = "protobuf", version = "27.0")
+bazel_dep(name = "rules_proto", version = "6.0.0", repo_name = "my_rules_proto")
+bazel_dep(name = "rules_go", version = "0.48.0", repo_name = "io_bazel_rules_go")
+`,
+	}})
+	defer cleanup()
+
+	c := config.New()
+	c.RepoRoot = dir
+	repos, err := readModuleRepos(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"protobuf":    "protobuf",
+		"rules_proto": "my_rules_proto",
+		"rules_go":    "io_bazel_rules_go",
+	}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("got %v; want %v", repos, want)
+	}
+
+	for _, tc := range []struct {
+		repo, want string
+	}{
+		{repo: "com_google_protobuf", want: "protobuf"},
+		{repo: "rules_proto", want: "my_rules_proto"},
+		{repo: "googleapis", want: "googleapis"},
+		{repo: "other", want: "other"},
+	} {
+		if got := apparentRepo(repos, tc.repo); got != tc.want {
+			t.Errorf("apparentRepo(%q): got %q; want %q", tc.repo, got, tc.want)
+		}
+	}
+	if got, want := apparentLoad(repos, "@rules_proto//proto:defs.bzl"), "@my_rules_proto//proto:defs.bzl"; got != want {
+		t.Errorf("apparentLoad: got %q; want %q", got, want)
+	}
+
+	c.RepoRoot = filepath.Join(dir, "sub")
+	if repos, err := readModuleRepos(c); err != nil || repos != nil {
+		t.Errorf("without MODULE.bazel: got %v, %v; want nil, nil", repos, err)
+	}
+}
@@ -20,10 +20,16 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+	"github.com/bmatcuk/doublestar"
 )
 
 // ProtoConfig contains configuration values related to protos.
@@ -57,6 +63,187 @@ type ProtoConfig struct {
 	// If set, Gazelle will apply this value to the import_prefix attribute
 	// within the proto_library_rule.
 	ImportPrefix string
+
+	// vendoredWKT determines how .proto files that look like vendored copies
+	// of the Well Known Types are handled. It may be "skip", "use", or "" if
+	// unset, in which case Gazelle warns about them.
+	vendoredWKT string
+
+	// genSrcs is a list of labels of generated .proto files declared with
+	// the proto_gen_src directive. These are not inherited by subdirectories.
+	genSrcs []string
+
+	// goPackages is a list of go_package options set with the
+	// proto_go_package directive for .proto files matching patterns. Later
+	// entries take precedence.
+	goPackages []goPackageOverride
+
+	// wktRepo is the name of the repository providing proto_library rules for
+	// the Well Known Types, set with the proto_wkt_repo directive. If empty,
+	// com_google_protobuf is used.
+	wktRepo string
+
+	// googleapisRepo is the name of the repository providing proto_library
+	// rules for the common protos in googleapis, set with the
+	// proto_googleapis_repo directive. If empty, googleapis is used. If
+	// googleapisVendored is set, the rules are in a vendored copy in the
+	// directory googleapisPkg of the main repository instead.
+	googleapisRepo     string
+	googleapisPkg      string
+	googleapisVendored bool
+
+	// googleapisLegacy is true if common protos should be resolved to
+	// go_googleapis when proto_googleapis_repo isn't set. See
+	// useLegacyGoogleapis.
+	googleapisLegacy bool
+
+	// bufRoots is a list of import roots of Buf modules declared in buf.yaml
+	// or buf.work.yaml files in this directory or its parents. When Gazelle
+	// visits a root, StripImportPrefix is set to it unless it was set
+	// explicitly.
+	bufRoots []string
+
+	// bufDeps is a list of names of Buf modules that modules in this
+	// directory depend on, read from buf.lock or buf.yaml. Imports that
+	// can't be resolved in the repository are resolved in bufDepsRepo when
+	// this is set.
+	bufDeps []string
+
+	// bufDepsRepo is the name of the repository that provides the .proto
+	// files of bufDeps, set with the proto_buf_deps_repo directive.
+	bufDepsRepo string
+
+	// bindings is a list of names of bindings for other languages that are
+	// generated next to each proto_library, set with the proto_bindings
+	// directive. See Binding.
+	bindings []string
+
+	// bindingTemplates are bindings defined with the -proto_binding_template
+	// flag. They're not inherited from a directive, since the kinds of rules
+	// Gazelle generates must be known before build files are read.
+	bindingTemplates []Binding
+
+	// moduleRepos maps the names of modules declared with bazel_dep in
+	// MODULE.bazel to the names of their repositories, so labels and loads
+	// refer to repositories that are visible with Bzlmod. It's nil if there
+	// is no MODULE.bazel file.
+	moduleRepos map[string]string
+
+	// lite is the mode set with the proto_lite directive: liteOff, liteAuto,
+	// or liteAll. It controls whether lite variants of bindings are
+	// generated.
+	lite string
+
+	// ruleAttrs are attributes set on generated rules with the
+	// proto_rule_attr directive. Later entries take precedence.
+	ruleAttrs []ruleAttr
+
+	// importRoots are directories, declared with the proto_import_root
+	// directive, that .proto files are imported relative to. When Gazelle
+	// visits a directory matching one, StripImportPrefix and ImportPrefix are
+	// set for it, unless they're set explicitly there.
+	importRoots []importRoot
+
+	// strict indicates that problems with how .proto files are arranged,
+	// like files from several proto packages in one directory in package
+	// mode, are reported. Set with the proto_strict directive. Problems are
+	// also reported when -strict is set.
+	strict bool
+
+	// importIndex maps imports of .proto files in other repositories to the
+	// labels of the proto_library rules that provide them. Entries are loaded
+	// with the proto_import_index directive. Imports that can't be resolved in
+	// the repository are looked up here. The map is shared with parent
+	// directories and must be copied before it's modified.
+	importIndex map[string]label.Label
+
+	// unusedImports determines what Gazelle does with imports in .proto files
+	// that aren't referenced by anything in the file: "ignore" (or empty) or
+	// "warn". Set with the proto_unused_imports directive or the
+	// -proto_unused_imports flag.
+	unusedImports string
+
+	// anchor groups .proto files in the same proto package from several
+	// directories into one proto_library, set with the proto_anchor
+	// directive. Only used in package mode. nil if not set.
+	anchor *protoAnchor
+}
+
+// goPackageOverride sets the go_package option of .proto files matching
+// pattern to value. If protoPackage is true, pattern is matched against the
+// proto package name of each file; otherwise, it's matched against the
+// repository-relative path.
+type goPackageOverride struct {
+	pattern, value string
+	protoPackage   bool
+}
+
+// importRoot is a directory pattern declared with the proto_import_root
+// directive. Directories matching pattern are import roots, and .proto files
+// in them are imported with importPrefix, if hasImportPrefix is set.
+type importRoot struct {
+	pattern, importPrefix string
+	hasImportPrefix       bool
+}
+
+// protoPackagePatternPrefix marks proto_go_package patterns that match proto
+// package names instead of file paths.
+const protoPackagePatternPrefix = "package:"
+
+// UseVendoredWellKnownTypes returns whether imports of Well Known Types should
+// be resolved to vendored copies in the repository instead of the copies in
+// @com_google_protobuf. This is set with the proto_vendored_wkt directive.
+func (pc *ProtoConfig) UseVendoredWellKnownTypes() bool {
+	return pc.vendoredWKT == "use"
+}
+
+// goPackageOverride returns the go_package option set with the
+// proto_go_package directive for the .proto file at the repository-relative
+// path rel, declaring the proto package pkg. Patterns are matched with
+// path.Match, except that a path pattern ending with "/..." matches any file
+// in that directory or below it.
+func (pc *ProtoConfig) goPackageOverride(rel, pkg string) (string, bool) {
+	for i := len(pc.goPackages) - 1; i >= 0; i-- {
+		o := pc.goPackages[i]
+		if o.protoPackage {
+			if ok, _ := path.Match(o.pattern, pkg); ok && pkg != "" {
+				return o.value, true
+			}
+		} else if matchGoPackagePath(o.pattern, rel) {
+			return o.value, true
+		}
+	}
+	return "", false
+}
+
+// matchGoPackagePath reports whether the path pattern from a
+// proto_go_package directive matches rel.
+func matchGoPackagePath(pattern, rel string) bool {
+	if dir := strings.TrimSuffix(pattern, "..."); dir != pattern {
+		return dir == "" || strings.HasPrefix(rel, dir)
+	}
+	ok, _ := path.Match(pattern, rel)
+	return ok
+}
+
+// GoImportPathOverride returns the Go import path set with the
+// proto_go_package directive for imp, the import path of a .proto file.
+// Only path patterns are considered, since the proto package of a file
+// isn't known from its import path. This lets the Go extension resolve
+// imports of .proto files that aren't in the repository.
+func (pc *ProtoConfig) GoImportPathOverride(imp string) (string, bool) {
+	for i := len(pc.goPackages) - 1; i >= 0; i-- {
+		o := pc.goPackages[i]
+		if o.protoPackage || !matchGoPackagePath(o.pattern, imp) {
+			continue
+		}
+		goImp := o.value
+		if j := strings.IndexByte(goImp, ';'); j >= 0 {
+			goImp = goImp[:j]
+		}
+		return goImp, goImp != ""
+	}
+	return "", false
 }
 
 // GetProtoConfig returns the proto language configuration. If the proto
@@ -96,6 +283,11 @@ const (
 	// PackageMode generates a proto_library for each set of .proto files with
 	// the same package name in each directory.
 	PackageMode
+
+	// FileMode generates a proto_library for each .proto file. Imports of
+	// other files in the same directory are resolved to their rules, so
+	// dependencies between files are explicit.
+	FileMode
 )
 
 func ModeFromString(s string) (Mode, error) {
@@ -110,6 +302,8 @@ func ModeFromString(s string) (Mode, error) {
 		return LegacyMode, nil
 	case "package":
 		return PackageMode, nil
+	case "file":
+		return FileMode, nil
 	default:
 		return 0, fmt.Errorf("unrecognized proto mode: %q", s)
 	}
@@ -127,6 +321,8 @@ func (m Mode) String() string {
 		return "legacy"
 	case PackageMode:
 		return "package"
+	case FileMode:
+		return "file"
 	default:
 		log.Panicf("unknown mode %d", m)
 		return ""
@@ -176,30 +372,63 @@ func (f *modeFlag) String() string {
 	return mode.String()
 }
 
+type bindingTemplateFlag func(string) error
+
+func (f bindingTemplateFlag) Set(value string) error {
+	return f(value)
+}
+
+func (f bindingTemplateFlag) String() string {
+	return ""
+}
+
 func (_ *protoLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
-	pc := &ProtoConfig{}
+	pc := &ProtoConfig{lite: liteOff}
 	c.Exts[protoName] = pc
 
 	// Note: the -proto flag does not set the ModeExplicit flag. We want to
 	// be able to switch to DisableMode in vendor directories, even when
 	// this is set for compatibility with older versions.
-	fs.Var(&modeFlag{&pc.Mode}, "proto", "default: generates a proto_library rule for one package\n\tpackage: generates a proto_library rule for for each package\n\tdisable: does not touch proto rules\n\tdisable_global: does not touch proto rules and does not use special cases for protos in dependency resolution")
+	fs.Var(&modeFlag{&pc.Mode}, "proto", "default: generates a proto_library rule for one package\n\tpackage: generates a proto_library rule for for each package\n\tfile: generates a proto_library rule for each .proto file\n\tdisable: does not touch proto rules\n\tdisable_global: does not touch proto rules and does not use special cases for protos in dependency resolution")
 	fs.StringVar(&pc.groupOption, "proto_group", "", "option name used to group .proto files into proto_library rules")
 	fs.StringVar(&pc.ImportPrefix, "proto_import_prefix", "", "When set, .proto source files in the srcs attribute of the rule are accessible at their path with this prefix appended on.")
+	fs.Var(bindingTemplateFlag(func(value string) error {
+		b, err := parseBindingTemplate(value)
+		if err != nil {
+			return err
+		}
+		pc.bindingTemplates = append(pc.bindingTemplates, b)
+		return nil
+	}), "proto_binding_template", "defines a binding that may be enabled with proto_bindings, as 'lang kind [load=file] [suffix=suffix] [proto_attr=attr] [single_proto=bool] [deps_attr=attr] [lite_kind=kind] [lite_suffix=suffix]'. May be repeated.")
+	fs.StringVar(&pc.unusedImports, "proto_unused_imports", "", "ignore: does not check for unused imports in .proto files\n\twarn: reports unused imports")
 }
 
-func (_ *protoLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
-	return nil
+func (pl *protoLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	pc := GetProtoConfig(c)
+	pl.bindingTemplates = pc.bindingTemplates
+	moduleRepos, err := readModuleRepos(c)
+	if err != nil {
+		log.Printf("reading MODULE.bazel: %v", err)
+	}
+	pc.moduleRepos = moduleRepos
+	pl.moduleRepos = moduleRepos
+	pc.googleapisLegacy = useLegacyGoogleapis(c, moduleRepos)
+	return checkUnusedImportsMode(pc.unusedImports)
 }
 
 func (_ *protoLang) KnownDirectives() []string {
-	return []string{"proto", "proto_group", "proto_strip_import_prefix", "proto_import_prefix"}
+	return []string{"proto", "proto_group", "proto_strip_import_prefix", "proto_import_prefix", "proto_vendored_wkt", "proto_gen_src", "proto_go_package", "proto_buf_deps_repo", "proto_wkt_repo", "proto_googleapis_repo", "proto_bindings", "proto_strict", "proto_import_root", "proto_import_index", "proto_unused_imports", "proto_anchor", "proto_rule_attr", "proto_lite"}
 }
 
 func (_ *protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 	pc := &ProtoConfig{}
 	*pc = *GetProtoConfig(c)
+	pc.genSrcs = nil
+	pc.goPackages = pc.goPackages[:len(pc.goPackages):len(pc.goPackages)]
+	pc.importRoots = pc.importRoots[:len(pc.importRoots):len(pc.importRoots)]
+	pc.ruleAttrs = pc.ruleAttrs[:len(pc.ruleAttrs):len(pc.ruleAttrs)]
 	c.Exts[protoName] = pc
+	stripImportPrefixSet, importPrefixSet := false, false
 	if f != nil {
 		for _, d := range f.Directives {
 			switch d.Key {
@@ -215,6 +444,7 @@ func (_ *protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 				pc.groupOption = d.Value
 			case "proto_strip_import_prefix":
 				pc.StripImportPrefix = d.Value
+				stripImportPrefixSet = true
 				if rel != "" {
 					if err := checkStripImportPrefix(pc.StripImportPrefix, rel); err != nil {
 						log.Print(err)
@@ -222,12 +452,233 @@ func (_ *protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 				}
 			case "proto_import_prefix":
 				pc.ImportPrefix = d.Value
+				importPrefixSet = true
+			case "proto_import_root":
+				fields := strings.Fields(d.Value)
+				switch len(fields) {
+				case 0:
+					pc.importRoots = nil
+				case 1, 2:
+					root := importRoot{pattern: path.Join(rel, fields[0])}
+					if _, err := doublestar.Match(root.pattern, ""); err != nil {
+						log.Printf("%s: invalid pattern in proto_import_root: %q: %v", f.Path, fields[0], err)
+						continue
+					}
+					if len(fields) == 2 {
+						root.importPrefix = fields[1]
+						root.hasImportPrefix = true
+					}
+					pc.importRoots = append(pc.importRoots, root)
+				default:
+					log.Printf("%s: invalid value for proto_import_root: %q; want a directory pattern and an optional import prefix", f.Path, d.Value)
+				}
+			case "proto_vendored_wkt":
+				switch d.Value {
+				case "", "skip", "use":
+					pc.vendoredWKT = d.Value
+				default:
+					log.Printf("invalid value for proto_vendored_wkt: %q; want skip or use", d.Value)
+				}
+			case "proto_gen_src":
+				l, err := label.Parse(strings.TrimSpace(d.Value))
+				if err != nil || !strings.HasSuffix(l.Name, ".proto") {
+					log.Printf("%s: invalid value for proto_gen_src: %q; want a label of a generated .proto file", f.Path, d.Value)
+					continue
+				}
+				pc.genSrcs = append(pc.genSrcs, strings.TrimSpace(d.Value))
+			case "proto_go_package":
+				fields := strings.Fields(d.Value)
+				switch len(fields) {
+				case 0:
+					pc.goPackages = nil
+				case 2:
+					o := goPackageOverride{value: fields[1]}
+					if pkg := strings.TrimPrefix(fields[0], protoPackagePatternPrefix); pkg != fields[0] {
+						o.pattern = pkg
+						o.protoPackage = true
+					} else {
+						o.pattern = path.Join(rel, fields[0])
+					}
+					pc.goPackages = append(pc.goPackages, o)
+				default:
+					log.Printf("%s: invalid value for proto_go_package: %q; want a .proto file pattern or package:pattern and a go_package value", f.Path, d.Value)
+				}
+			case "proto_buf_deps_repo":
+				pc.bufDepsRepo = d.Value
+			case "proto_wkt_repo":
+				pc.wktRepo = strings.TrimPrefix(strings.TrimSpace(d.Value), "@")
+			case "proto_googleapis_repo":
+				pc.googleapisRepo, pc.googleapisPkg, pc.googleapisVendored = parseGoogleapisRepo(d.Value)
+			case "proto_bindings":
+				pc.bindings = nil
+				for _, lang := range strings.FieldsFunc(d.Value, func(r rune) bool { return r == ',' || r == ' ' }) {
+					if _, ok := findBinding(pc, lang); !ok {
+						log.Printf("%s: unknown binding in proto_bindings: %q", f.Path, lang)
+						continue
+					}
+					pc.bindings = append(pc.bindings, lang)
+				}
+			case "proto_lite":
+				switch mode := strings.TrimSpace(d.Value); mode {
+				case liteOff, liteAuto, liteAll:
+					pc.lite = mode
+				default:
+					log.Printf("%s: invalid proto_lite mode %q; want off, auto, or all", f.Path, mode)
+				}
+			case "proto_rule_attr":
+				ra, err := parseRuleAttr(pc, d.Value)
+				if err != nil {
+					log.Printf("%s: %v", f.Path, err)
+					continue
+				}
+				pc.ruleAttrs = append(pc.ruleAttrs, ra)
+			case "proto_import_index":
+				fields := strings.Fields(d.Value)
+				switch len(fields) {
+				case 0:
+					pc.importIndex = nil
+				case 2:
+					repo := strings.TrimPrefix(fields[0], "@")
+					p := filepath.Join(c.RepoRoot, filepath.FromSlash(rel), filepath.FromSlash(fields[1]))
+					imports, err := loadImportIndex(repo, p)
+					if err != nil {
+						log.Printf("%s: could not load proto_import_index: %v", f.Path, err)
+						continue
+					}
+					index := make(map[string]label.Label, len(pc.importIndex)+len(imports))
+					for imp, l := range pc.importIndex {
+						index[imp] = l
+					}
+					for imp, l := range imports {
+						index[imp] = l
+					}
+					pc.importIndex = index
+				default:
+					log.Printf("%s: invalid value for proto_import_index: %q; want a repository name and a file or directory", f.Path, d.Value)
+				}
+			case "proto_strict":
+				strict, err := strconv.ParseBool(d.Value)
+				if err != nil {
+					log.Printf("%s: invalid value for proto_strict: %q; want true or false", f.Path, d.Value)
+					continue
+				}
+				pc.strict = strict
+			case "proto_anchor":
+				value := strings.TrimSpace(d.Value)
+				if value == "" {
+					pc.anchor = nil
+					continue
+				}
+				dir := path.Join(rel, value)
+				if dir == "." {
+					dir = ""
+				}
+				if !pathtools.HasPrefix(dir, rel) || strings.HasPrefix(dir, "..") {
+					log.Printf("%s: invalid value for proto_anchor: %q; want a directory in %q", f.Path, d.Value, rel)
+					continue
+				}
+				if fi, err := walk.GetFS(c).Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(dir))); err != nil || !fi.IsDir() {
+					log.Printf("%s: proto_anchor directory %q does not exist", f.Path, dir)
+					continue
+				}
+				anchor, err := scanAnchor(c, rel, dir)
+				if err != nil {
+					log.Printf("%s: could not read .proto files for proto_anchor: %v", f.Path, err)
+					continue
+				}
+				pc.anchor = anchor
+			case "proto_unused_imports":
+				if err := checkUnusedImportsMode(d.Value); err != nil {
+					log.Printf("%s: %v", f.Path, err)
+					continue
+				}
+				pc.unusedImports = d.Value
 			}
 		}
 	}
+	configureImportRoot(pc, rel, stripImportPrefixSet, importPrefixSet)
+	configureBuf(c, rel, stripImportPrefixSet)
 	inferProtoMode(c, rel, f)
 }
 
+// configureImportRoot sets the prefixes of .proto files in the directory rel
+// if it matches a pattern declared with proto_import_root. Files there and
+// in subdirectories are imported relative to rel. Prefixes set explicitly in
+// this directory take precedence.
+func configureImportRoot(pc *ProtoConfig, rel string, stripImportPrefixSet, importPrefixSet bool) {
+	if rel == "" {
+		return
+	}
+	for i := len(pc.importRoots) - 1; i >= 0; i-- {
+		root := pc.importRoots[i]
+		if ok, _ := doublestar.Match(root.pattern, rel); !ok {
+			continue
+		}
+		if !stripImportPrefixSet {
+			pc.StripImportPrefix = "/" + rel
+		}
+		if root.hasImportPrefix && !importPrefixSet {
+			pc.ImportPrefix = root.importPrefix
+		}
+		return
+	}
+}
+
+// configureBuf reads Buf configuration files in the directory rel and
+// records the import roots and dependencies of the modules they declare.
+// If rel is the root of a Buf module, and proto_strip_import_prefix was
+// not set in this directory, .proto files are imported relative to it.
+func configureBuf(c *config.Config, rel string, stripImportPrefixSet bool) {
+	pc := GetProtoConfig(c)
+	mods, err := readBufConfig(walk.GetFS(c), c.RepoRoot, rel)
+	if err != nil {
+		log.Print(err)
+	}
+	if len(mods) > 0 {
+		names := make(map[string]bool)
+		for _, m := range mods {
+			if m.name != "" {
+				names[m.name] = true
+			}
+		}
+		pc.bufRoots = pc.bufRoots[:len(pc.bufRoots):len(pc.bufRoots)]
+		var deps []string
+		seen := make(map[string]bool)
+		for _, m := range mods {
+			pc.bufRoots = append(pc.bufRoots, m.root)
+			for _, d := range m.deps {
+				if !names[d] && !seen[d] {
+					deps = append(deps, d)
+					seen[d] = true
+				}
+			}
+		}
+		pc.bufDeps = deps
+	}
+	if stripImportPrefixSet || rel == "" {
+		return
+	}
+	for _, root := range pc.bufRoots {
+		if root == rel {
+			pc.StripImportPrefix = "/" + rel
+			break
+		}
+	}
+}
+
+// BufDepsRepo returns the name of the repository that provides .proto files
+// from Buf modules the current directory depends on. false is returned if
+// there are no Buf dependencies.
+func (pc *ProtoConfig) BufDepsRepo() (string, bool) {
+	if len(pc.bufDeps) == 0 {
+		return "", false
+	}
+	if pc.bufDepsRepo == "" {
+		return defaultBufDepsRepo, true
+	}
+	return pc.bufDepsRepo, true
+}
+
 // inferProtoMode sets ProtoConfig.Mode based on the directory name and the
 // contents of f. If the proto mode is set explicitly, this function does not
 // change it. If this is a vendor directory, or go_proto_library is loaded from
@@ -277,7 +728,7 @@ outer:
 }
 
 func checkStripImportPrefix(prefix, rel string) error {
-	if !strings.HasPrefix(prefix, "/") || !strings.HasPrefix(rel, prefix[1:]) {
+	if !strings.HasPrefix(prefix, "/") || !pathtools.HasPrefix(rel, strings.Trim(prefix, "/")) {
 		return fmt.Errorf("invalid proto_strip_import_prefix %q at %s", prefix, rel)
 	}
 	return nil
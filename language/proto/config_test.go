@@ -15,7 +15,10 @@ limitations under the License.
 
 package proto
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestCheckStripImportPrefix(t *testing.T) {
 	e := checkStripImportPrefix("/example.com/idl", "example.com")
@@ -23,4 +26,103 @@ func TestCheckStripImportPrefix(t *testing.T) {
 	if e == nil || e.Error() != wantErr {
 		t.Errorf("got:\n%v\n\nwant:\n%s\n", e, wantErr)
 	}
-}
\ No newline at end of file
+}
+
+func TestGoPackageOverride(t *testing.T) {
+	pc := &ProtoConfig{goPackages: []goPackageOverride{
+		{pattern: "...", value: "example.com/all"},
+		{pattern: "third_party/...", value: "example.com/third_party"},
+		{pattern: "third_party/foo/*.proto", value: "example.com/foo"},
+		{pattern: "google.api", value: "example.com/googleapis/api;api", protoPackage: true},
+		{pattern: "google.rpc.*", value: "example.com/googleapis/rpc", protoPackage: true},
+	}}
+	for _, tc := range []struct {
+		rel, pkg, want string
+	}{
+		{rel: "a.proto", want: "example.com/all"},
+		{rel: "third_party/b/b.proto", want: "example.com/third_party"},
+		{rel: "third_party/foo/foo.proto", want: "example.com/foo"},
+		{rel: "third_party/foo/sub/sub.proto", want: "example.com/third_party"},
+		{rel: "third_party/foo/foo.proto", pkg: "google.api", want: "example.com/googleapis/api;api"},
+		{rel: "a.proto", pkg: "google.rpc.context", want: "example.com/googleapis/rpc"},
+		{rel: "a.proto", pkg: "google.rpc", want: "example.com/all"},
+	} {
+		if got, _ := pc.goPackageOverride(tc.rel, tc.pkg); got != tc.want {
+			t.Errorf("%s (%s): got %q; want %q", tc.rel, tc.pkg, got, tc.want)
+		}
+	}
+	if _, ok := (&ProtoConfig{}).goPackageOverride("a.proto", "a"); ok {
+		t.Error("got override with no directives; want none")
+	}
+}
+
+func TestGoImportPathOverride(t *testing.T) {
+	pc := &ProtoConfig{goPackages: []goPackageOverride{
+		{pattern: "google/api/...", value: "example.com/googleapis/api;api"},
+		{pattern: "google.api", value: "example.com/other", protoPackage: true},
+		{pattern: "google/type/date.proto", value: "example.com/googleapis/type"},
+	}}
+	for _, tc := range []struct {
+		imp, want string
+	}{
+		{imp: "google/api/annotations.proto", want: "example.com/googleapis/api"},
+		{imp: "google/type/date.proto", want: "example.com/googleapis/type"},
+		{imp: "google/type/money.proto"},
+	} {
+		if got, _ := pc.GoImportPathOverride(tc.imp); got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.imp, got, tc.want)
+		}
+	}
+}
+
+func TestParseBindingTemplate(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    Binding
+		wantErr bool
+	}{
+		{
+			value: "swift swift_proto_library",
+			want:  Binding{Lang: "swift", Kind: "swift_proto_library", Suffix: "_swift_proto"},
+		}, {
+			value: "ts ts_proto_library load=@aspect_rules_ts//ts:proto.bzl suffix=_ts proto_attr=proto single_proto=true deps_attr=deps",
+			want: Binding{
+				Lang:        "ts",
+				Kind:        "ts_proto_library",
+				Load:        "@aspect_rules_ts//ts:proto.bzl",
+				Suffix:      "_ts",
+				ProtoAttr:   "proto",
+				SingleProto: true,
+				DepsAttr:    "deps",
+			},
+		}, {
+			value: "cc cc_proto_library lite_kind=cc_lite_proto_library lite_suffix=_cc_lite",
+			want: Binding{
+				Lang:       "cc",
+				Kind:       "cc_proto_library",
+				Suffix:     "_cc_proto",
+				LiteKind:   "cc_lite_proto_library",
+				LiteSuffix: "_cc_lite",
+			},
+		},
+		{value: "ts", wantErr: true},
+		{value: "ts ts_proto_library load", wantErr: true},
+		{value: "ts ts_proto_library visibility=public", wantErr: true},
+		{value: "ts ts_proto_library suffix=_proto", wantErr: true},
+		{value: "ts ts_proto_library single_proto=yes", wantErr: true},
+		{value: "ts ts_proto_library proto_attr=deps single_proto=true deps_attr=deps", wantErr: true},
+	} {
+		got, err := parseBindingTemplate(tc.value)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: got %#v; want error", tc.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.value, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %#v; want %#v", tc.value, got, tc.want)
+		}
+	}
+}
@@ -17,13 +17,15 @@ package proto
 
 import (
 	"bytes"
-	"io/ioutil"
 	"log"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 // FileInfo contains metadata extracted from a .proto file.
@@ -35,7 +37,23 @@ type FileInfo struct {
 	Options []Option
 	Imports []string
 
+	// PublicImports are the imports declared with "import public". They're
+	// also included in Imports. Files that import this one can use
+	// definitions from publicly imported files, so they need those files as
+	// dependencies, too.
+	PublicImports []string
+
 	HasServices bool
+
+	// HasHTTPRules indicates whether any method in the file has a
+	// google.api.http annotation, which grpc-gateway uses to map HTTP
+	// requests to gRPC methods.
+	HasHTTPRules bool
+
+	// LiteRuntime indicates whether the file sets
+	// "option optimize_for = LITE_RUNTIME", so code generated from it only
+	// needs the lite runtime.
+	LiteRuntime bool
 }
 
 // Option represents a top-level option statement in a .proto file. Only
@@ -46,12 +64,12 @@ type Option struct {
 
 var protoRe = buildProtoRegexp()
 
-func protoFileInfo(dir, name string) FileInfo {
+func protoFileInfo(fs walk.FS, dir, name string) FileInfo {
 	info := FileInfo{
 		Path: filepath.Join(dir, name),
 		Name: name,
 	}
-	content, err := ioutil.ReadFile(info.Path)
+	content, err := fs.ReadFile(info.Path)
 	if err != nil {
 		log.Printf("%s: error reading proto file: %v", info.Path, err)
 		return info
@@ -60,8 +78,11 @@ func protoFileInfo(dir, name string) FileInfo {
 	for _, match := range protoRe.FindAllSubmatch(content, -1) {
 		switch {
 		case match[importSubexpIndex] != nil:
-			imp := unquoteProtoString(match[importSubexpIndex])
+			imp := normalizeProtoImport(unquoteProtoString(match[importSubexpIndex]))
 			info.Imports = append(info.Imports, imp)
+			if string(match[importKindSubexpIndex]) == "public" {
+				info.PublicImports = append(info.PublicImports, imp)
+			}
 
 		case match[packageSubexpIndex] != nil:
 			pkg := string(match[packageSubexpIndex])
@@ -77,24 +98,79 @@ func protoFileInfo(dir, name string) FileInfo {
 		case match[serviceSubexpIndex] != nil:
 			info.HasServices = true
 
+		case match[httpRuleSubexpIndex] != nil:
+			info.HasHTTPRules = true
+
+		case match[liteSubexpIndex] != nil:
+			info.LiteRuntime = true
+
 		default:
 			// Comment matched. Nothing to extract.
 		}
 	}
-	sort.Strings(info.Imports)
+	info.Imports = sortedUnique(info.Imports)
+	info.PublicImports = sortedUnique(info.PublicImports)
 
 	return info
 }
 
+// packageLine returns the line number of the package statement in the
+// .proto file at path, or 0 if the file can't be read or has no package
+// statement.
+func packageLine(fs walk.FS, path string) int {
+	content, err := fs.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, match := range protoRe.FindAllSubmatchIndex(content, -1) {
+		if start := match[2*packageSubexpIndex]; start >= 0 {
+			return 1 + bytes.Count(content[:start], []byte{'\n'})
+		}
+	}
+	return 0
+}
+
+// normalizeProtoImport converts an import path to the canonical form used
+// to index and resolve imports. protoc accepts paths like "./foo/bar.proto"
+// and "foo//bar.proto" that name the same file as "foo/bar.proto"; without
+// normalization, each spelling would resolve separately and could produce
+// duplicate dependencies.
+func normalizeProtoImport(imp string) string {
+	if imp == "" {
+		return imp
+	}
+	return path.Clean(imp)
+}
+
+// sortedUnique sorts strs and removes duplicates in place.
+func sortedUnique(strs []string) []string {
+	sort.Strings(strs)
+	j := 0
+	for i, s := range strs {
+		if i > 0 && s == strs[j-1] {
+			continue
+		}
+		strs[j] = s
+		j++
+	}
+	return strs[:j]
+}
+
 const (
-	importSubexpIndex  = 1
-	packageSubexpIndex = 2
-	optkeySubexpIndex  = 3
-	optvalSubexpIndex  = 4
-	serviceSubexpIndex = 5
+	importKindSubexpIndex = 1
+	importSubexpIndex     = 2
+	packageSubexpIndex    = 3
+	optkeySubexpIndex     = 4
+	optvalSubexpIndex     = 5
+	serviceSubexpIndex    = 6
+	httpRuleSubexpIndex   = 7
+	liteSubexpIndex       = 8
 )
 
 // Based on https://developers.google.com/protocol-buffers/docs/reference/proto3-spec
+// and https://protobuf.dev/reference/protobuf/edition-2023-spec/. Statements
+// that only appear in editions, like edition declarations and features, don't
+// affect the extracted metadata and are skipped.
 func buildProtoRegexp() *regexp.Regexp {
 	hexEscape := `\\[xX][0-9a-fA-f]{2}`
 	octEscape := `\\[0-7]{3}`
@@ -103,12 +179,17 @@ func buildProtoRegexp() *regexp.Regexp {
 	strLit := `'(?:` + charValue + `|")*'|"(?:` + charValue + `|')*"`
 	ident := `[A-Za-z][A-Za-z0-9_]*`
 	fullIdent := ident + `(?:\.` + ident + `)*`
-	importStmt := `\bimport\s*(?:public|weak)?\s*(?P<import>` + strLit + `)\s*;`
+	// Option imports ("import option"), added in edition 2024, are only
+	// needed for custom options, but protoc still needs the files, so they're
+	// treated like other imports.
+	importStmt := `\bimport\s*(?P<importkind>public|weak|option)?\s*(?P<import>` + strLit + `)\s*;`
 	packageStmt := `\bpackage\s*(?P<package>` + fullIdent + `)\s*;`
 	optionStmt := `\boption\s*(?P<optkey>` + fullIdent + `)\s*=\s*(?P<optval>` + strLit + `)\s*;`
 	serviceStmt := `(?P<service>service\s*`+ ident +`\s*{)`
+	httpRuleStmt := `(?P<httprule>\boption\s*\(\s*google\.api\.http\s*\)\s*=)`
+	liteStmt := `(?P<lite>\boption\s*optimize_for\s*=\s*LITE_RUNTIME\s*;)`
 	comment := `//[^\n]*`
-	protoReSrc := strings.Join([]string{importStmt, packageStmt, optionStmt, serviceStmt, comment}, "|")
+	protoReSrc := strings.Join([]string{importStmt, packageStmt, optionStmt, serviceStmt, httpRuleStmt, liteStmt, comment}, "|")
 	return regexp.MustCompile(protoReSrc)
 }
 
@@ -136,3 +217,26 @@ func unquoteProtoString(q []byte) string {
 	}
 	return s
 }
+
+// goOutputSuffixes are suffixes protoc plugins add to the stem of a .proto
+// file to name the Go files they generate from it.
+var goOutputSuffixes = []string{
+	".pb.go",      // protoc-gen-go
+	"_grpc.pb.go", // protoc-gen-go-grpc
+	".pb.gw.go",   // protoc-gen-grpc-gateway
+}
+
+// ProtosForGoFile returns the names of the .proto files that a Go file with
+// the given name could have been generated from by protoc, for example,
+// "foo.proto" for "foo.pb.go", or "foo_grpc.proto" and "foo.proto" for
+// "foo_grpc.pb.go". The Go extension uses this to recognize checked-in
+// generated code next to its source.
+func ProtosForGoFile(name string) []string {
+	var protos []string
+	for _, suffix := range goOutputSuffixes {
+		if stem := strings.TrimSuffix(name, suffix); stem != name && stem != "" {
+			protos = append(protos, stem+".proto")
+		}
+	}
+	return protos
+}
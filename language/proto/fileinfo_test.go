@@ -21,16 +21,21 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 func TestProtoRegexpGroupNames(t *testing.T) {
 	names := protoRe.SubexpNames()
 	nameMap := map[string]int{
-		"import":  importSubexpIndex,
-		"package": packageSubexpIndex,
-		"optkey":  optkeySubexpIndex,
-		"optval":  optvalSubexpIndex,
-		"service": serviceSubexpIndex,
+		"importkind": importKindSubexpIndex,
+		"import":     importSubexpIndex,
+		"package":    packageSubexpIndex,
+		"optkey":     optkeySubexpIndex,
+		"optval":     optvalSubexpIndex,
+		"service":    serviceSubexpIndex,
+		"httprule":   httpRuleSubexpIndex,
+		"lite":       liteSubexpIndex,
 	}
 	for name, index := range nameMap {
 		if names[index] != name {
@@ -97,6 +102,27 @@ import "second.proto";`,
 			want: FileInfo{
 				Imports: []string{"first.proto", "second.proto"},
 			},
+		}, {
+			desc: "import normalized",
+			name: "normalized.proto",
+			proto: `import "foo/bar.proto";
+import "./foo/bar.proto";
+import "foo//bar.proto";
+import "foo/../foo/bar.proto";
+import "foo/baz.proto";`,
+			want: FileInfo{
+				Imports: []string{"foo/bar.proto", "foo/baz.proto"},
+			},
+		}, {
+			desc: "import public",
+			name: "public.proto",
+			proto: `import public "foo/bar.proto";
+import weak "foo/weak.proto";
+import "foo/baz.proto";`,
+			want: FileInfo{
+				Imports:       []string{"foo/bar.proto", "foo/baz.proto", "foo/weak.proto"},
+				PublicImports: []string{"foo/bar.proto"},
+			},
 		}, {
 			desc:  "go_package",
 			name:  "gopkg.proto",
@@ -118,6 +144,77 @@ import "second.proto";`,
 			want: FileInfo{
 				HasServices: false,
 			},
+		}, {
+			desc: "http rule",
+			name: "gateway.proto",
+			proto: `service Echo {
+  rpc Echo(Msg) returns (Msg) {
+    option (google.api.http) = {
+      post: "/v1/echo"
+      body: "*"
+    };
+  }
+}`,
+			want: FileInfo{
+				HasServices:  true,
+				HasHTTPRules: true,
+			},
+		}, {
+			desc: "editions",
+			name: "editions.proto",
+			proto: `edition = "2024";
+
+package foo.v1;
+
+import "google/api/annotations.proto";
+import option "google/protobuf/cpp_features.proto";
+
+option features.field_presence = IMPLICIT;
+option features.(pb.cpp).string_type = VIEW;
+option go_package = "example.com/foo/v1;foopb";
+
+export message Msg {
+  reserved foo, bar;
+  string name = 1 [features.field_presence = EXPLICIT];
+}
+
+local enum Kind {
+  option features.enum_type = CLOSED;
+  KIND_UNSPECIFIED = 0;
+}
+
+service Foo {
+  rpc Get(Msg) returns (Msg) {
+    option (google.api.http) = { get: "/v1/foo" };
+  }
+}`,
+			want: FileInfo{
+				PackageName:  "foo.v1",
+				Imports:      []string{"google/api/annotations.proto", "google/protobuf/cpp_features.proto"},
+				Options:      []Option{{Key: "go_package", Value: "example.com/foo/v1;foopb"}},
+				HasServices:  true,
+				HasHTTPRules: true,
+			},
+		}, {
+			desc: "lite runtime",
+			name: "lite.proto",
+			proto: `option optimize_for = LITE_RUNTIME;
+option java_package = "com.example";`,
+			want: FileInfo{
+				Options:     []Option{{Key: "java_package", Value: "com.example"}},
+				LiteRuntime: true,
+			},
+		}, {
+			desc:  "optimize for speed",
+			name:  "speed.proto",
+			proto: `option optimize_for = SPEED;`,
+			want:  FileInfo{},
+		}, {
+			desc: "http rule in comment",
+			name: "comment.proto",
+			proto: `// option (google.api.http) = { get: "/v1/echo" };
+message Msg {}`,
+			want: FileInfo{},
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -130,14 +227,17 @@ import "second.proto";`,
 				t.Fatal(err)
 			}
 
-			got := protoFileInfo(dir, tc.name)
+			got := protoFileInfo(walk.OSFS{}, dir, tc.name)
 
 			// Clear fields we don't care about for testing.
 			got = FileInfo{
-				PackageName: got.PackageName,
-				Imports:     got.Imports,
-				Options:     got.Options,
-				HasServices: got.HasServices,
+				PackageName:  got.PackageName,
+				Imports:       got.Imports,
+				PublicImports: got.PublicImports,
+				Options:       got.Options,
+				HasServices:   got.HasServices,
+				HasHTTPRules:  got.HasHTTPRules,
+				LiteRuntime:   got.LiteRuntime,
 			}
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("got %#v; want %#v", got, tc.want)
@@ -145,3 +245,20 @@ import "second.proto";`,
 		})
 	}
 }
+
+func TestProtosForGoFile(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{name: "foo.go"},
+		{name: ".pb.go"},
+		{name: "foo.pb.go", want: []string{"foo.proto"}},
+		{name: "foo_grpc.pb.go", want: []string{"foo_grpc.proto", "foo.proto"}},
+		{name: "foo.pb.gw.go", want: []string{"foo.proto"}},
+	} {
+		if got := ProtosForGoFile(tc.name); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q; want %q", tc.name, got, tc.want)
+		}
+	}
+}
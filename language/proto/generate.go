@@ -16,18 +16,24 @@ limitations under the License.
 package proto
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
 func (_ *protoLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
 	c := args.Config
+	fs := walk.GetFS(c)
 	pc := GetProtoConfig(c)
 	if !pc.Mode.ShouldGenerateRules() {
 		// Don't create or delete proto rules in this mode. Any existing rules
@@ -47,7 +53,26 @@ func (_ *protoLang) GenerateRules(args language.GenerateArgs) language.GenerateR
 			genProtoFiles = append(genProtoFiles, name)
 		}
 	}
-	pkgs := buildPackages(pc, args.Dir, args.Rel, regularProtoFiles, genProtoFiles)
+	regularProtoFiles = filterVendoredWKTs(pc, args.Rel, regularProtoFiles)
+	if pc.Mode == PackageMode && pc.anchor != nil {
+		// Files in packages split across directories are grouped in the
+		// anchor directory.
+		regularProtoFiles = pc.anchor.filter(args.Rel, regularProtoFiles)
+	}
+	if pc.unusedImports != "" && pc.unusedImports != unusedImportsIgnore {
+		checkUnusedImports(c, fs, pc, args.Dir, args.Rel, regularProtoFiles)
+	}
+	declared := declaredGenSrcs(fs, c.RepoRoot, args.Rel, pc.genSrcs)
+	pkgs := buildPackages(pc, fs, args.Dir, args.Rel, regularProtoFiles, genProtoFiles, declared)
+	isAnchor := pc.Mode == PackageMode && pc.anchor != nil && args.Rel == pc.anchor.dir
+	if isAnchor {
+		pkgs = append(pkgs, pc.anchor.buildPackages(fs, c.RepoRoot)...)
+	}
+	if pc.Mode == PackageMode && (pc.strict || c.Strict) {
+		for _, err := range mixedPackageErrors(pc, fs, args.Dir, args.Rel, pkgs) {
+			c.ReportProblem(err)
+		}
+	}
 	shouldSetVisibility := args.File == nil || !args.File.HasDefaultVisibility()
 	var res language.GenerateResult
 	for _, pkg := range pkgs {
@@ -61,14 +86,105 @@ func (_ *protoLang) GenerateRules(args language.GenerateArgs) language.GenerateR
 	sort.SliceStable(res.Gen, func(i, j int) bool {
 		return res.Gen[i].Name() < res.Gen[j].Name()
 	})
+	if len(pc.bindings) > 0 {
+		// Each binding rule follows the proto_library it's generated for.
+		gen := make([]*rule.Rule, 0, len(res.Gen)*(len(pc.bindings)+1))
+		for _, r := range res.Gen {
+			gen = append(gen, r)
+			gen = append(gen, generateBindings(pc, r, false)...)
+			res.Empty = append(res.Empty, staleLiteBindings(pc, r)...)
+		}
+		res.Gen = gen
+	}
+	setRuleAttrs(args.Config, args.File, res.Gen)
 	res.Imports = make([]interface{}, len(res.Gen))
 	for i, r := range res.Gen {
 		res.Imports[i] = r.PrivateAttr(config.GazelleImportsKey)
 	}
-	res.Empty = append(res.Empty, generateEmpty(args.File, regularProtoFiles, genProtoFiles)...)
+	knownGenFiles := genProtoFiles
+	for _, d := range declared {
+		knownGenFiles = append(knownGenFiles, d.src)
+	}
+	if isAnchor {
+		knownGenFiles = append(knownGenFiles, pc.anchor.srcs()...)
+	}
+	res.Empty = append(res.Empty, generateEmpty(args.File, regularProtoFiles, knownGenFiles)...)
+	for _, r := range res.Empty[:len(res.Empty):len(res.Empty)] {
+		if r.Kind() == "proto_library" {
+			res.Empty = append(res.Empty, generateBindings(pc, r, true)...)
+		}
+	}
 	return res
 }
 
+// declaredGenSrc is a generated .proto file declared with the proto_gen_src
+// directive. The file doesn't need to exist in the source tree.
+type declaredGenSrc struct {
+	// src is the string that appears in srcs: a file name for a file in the
+	// same package, or a label otherwise.
+	src string
+
+	// info is metadata read from a copy of the file in bazel-bin, left by a
+	// previous build. nil if there's no such copy.
+	info *FileInfo
+}
+
+// declaredGenSrcs returns information about generated .proto files declared
+// in the package rel with proto_gen_src directives. If a file was generated
+// by a previous build, its package, options, and imports are read from
+// bazel-bin, so dependencies can be resolved.
+func declaredGenSrcs(fs walk.FS, repoRoot, rel string, labels []string) []declaredGenSrc {
+	var srcs []declaredGenSrc
+	for _, s := range labels {
+		l, err := label.Parse(s)
+		if err != nil {
+			continue // reported in Configure
+		}
+		l = l.Abs("", rel)
+		d := declaredGenSrc{src: l.Rel("", rel).String()}
+		if l.Repo == "" && l.Pkg == rel {
+			d.src = l.Name
+		}
+		outDir := filepath.Join(repoRoot, "bazel-bin", filepath.FromSlash(l.Pkg))
+		if l.Repo != "" {
+			outDir = filepath.Join(repoRoot, "bazel-bin", "external", l.Repo, filepath.FromSlash(l.Pkg))
+		}
+		if _, err := fs.Stat(filepath.Join(outDir, filepath.FromSlash(l.Name))); err == nil {
+			info := protoFileInfo(fs, outDir, l.Name)
+			info.Name = d.src
+			d.info = &info
+		}
+		srcs = append(srcs, d)
+	}
+	return srcs
+}
+
+// filterVendoredWKTs handles .proto files that look like vendored copies of
+// the Well Known Types. Rules generated for these would conflict with the
+// ones in @com_google_protobuf. Depending on the proto_vendored_wkt
+// directive, these files are dropped ("skip") or kept ("use"). If the
+// directive isn't set, they're kept with a warning.
+func filterVendoredWKTs(pc *ProtoConfig, rel string, files []string) []string {
+	prefix, ok := importPrefix(pc, rel)
+	if !ok {
+		return files
+	}
+	var kept, wkts []string
+	for _, f := range files {
+		if isWellKnownType(path.Join(prefix, f)) {
+			wkts = append(wkts, f)
+			if pc.vendoredWKT == "skip" {
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	if len(wkts) > 0 && pc.vendoredWKT == "" {
+		log.Printf("%s: found vendored copies of well known types (%s), which may conflict with @com_google_protobuf. Add '# gazelle:proto_vendored_wkt skip' to ignore them or '# gazelle:proto_vendored_wkt use' to resolve imports to them.", rel, strings.Join(wkts, ", "))
+	}
+	return kept
+}
+
 // RuleName returns a name for a proto_library derived from the given strings.
 // For each string, RuleName will look for a non-empty suffix of identifier
 // characters and then append "_proto" to that.
@@ -95,10 +211,26 @@ func RuleName(names ...string) string {
 // buildPackage extracts metadata from the .proto files in a directory and
 // constructs possibly several packages, then selects a package to generate
 // a proto_library rule for.
-func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []string) []*Package {
-	packageMap := make(map[string]*Package)
+func buildPackages(pc *ProtoConfig, fs walk.FS, dir, rel string, protoFiles, genFiles []string, declared []declaredGenSrc) []*Package {
+	infos := make([]FileInfo, 0, len(protoFiles)+len(declared))
 	for _, name := range protoFiles {
-		info := protoFileInfo(dir, name)
+		info := protoFileInfo(fs, dir, name)
+		if value, ok := pc.goPackageOverride(path.Join(rel, name), info.PackageName); ok {
+			setGoPackageOption(&info, value)
+		}
+		infos = append(infos, info)
+	}
+	var unknownSrcs []string
+	for _, d := range declared {
+		if d.info != nil {
+			infos = append(infos, *d.info)
+		} else {
+			unknownSrcs = append(unknownSrcs, d.src)
+		}
+	}
+
+	packageMap := make(map[string]*Package)
+	for _, info := range infos {
 		key := info.PackageName
 		if pc.groupOption != "" {
 			for _, opt := range info.Options {
@@ -121,11 +253,17 @@ func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []stri
 			log.Print(err)
 		}
 		if pkg == nil {
-			return nil // empty rule created in generateEmpty
+			if len(unknownSrcs) == 0 {
+				return nil // empty rule created in generateEmpty
+			}
+			pkg = newPackage("")
 		}
 		for _, name := range genFiles {
 			pkg.addGenFile(dir, name)
 		}
+		for _, src := range unknownSrcs {
+			pkg.addGenFile(dir, src)
+		}
 		return []*Package{pkg}
 
 	case PackageMode:
@@ -133,6 +271,40 @@ func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []stri
 		for _, pkg := range packageMap {
 			pkgs = append(pkgs, pkg)
 		}
+		if len(unknownSrcs) > 0 {
+			// Without the generated files, their packages aren't known. They
+			// can only be grouped if there's no choice.
+			switch len(pkgs) {
+			case 0:
+				pkgs = append(pkgs, newPackage(""))
+			case 1:
+			default:
+				log.Printf("%s: can't tell which proto_library should include generated sources %s. Build them first so Gazelle can read them from bazel-bin.", dir, strings.Join(unknownSrcs, ", "))
+				return pkgs
+			}
+			for _, src := range unknownSrcs {
+				pkgs[0].addGenFile(dir, src)
+			}
+		}
+		return pkgs
+
+	case FileMode:
+		pkgs := make([]*Package, 0, len(infos)+len(genFiles)+len(unknownSrcs))
+		for _, info := range infos {
+			pkg := newPackage(info.PackageName)
+			pkg.addFile(info)
+			pkgs = append(pkgs, pkg)
+		}
+		for _, name := range genFiles {
+			pkg := newPackage("")
+			pkg.addGenFile(dir, name)
+			pkgs = append(pkgs, pkg)
+		}
+		for _, src := range unknownSrcs {
+			pkg := newPackage("")
+			pkg.addGenFile(dir, src)
+			pkgs = append(pkgs, pkg)
+		}
 		return pkgs
 
 	default:
@@ -140,6 +312,22 @@ func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []stri
 	}
 }
 
+// fileRuleName returns the name of the proto_library generated for the
+// .proto file src in file mode. src may be a file name or a label. For
+// example, "foo-bar.proto" becomes "foo_bar_proto".
+func fileRuleName(src string) string {
+	if i := strings.LastIndexAny(src, ":/"); i >= 0 {
+		src = src[i+1:]
+	}
+	base := strings.Map(func(c rune) rune {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' {
+			return c
+		}
+		return '_'
+	}, strings.TrimSuffix(src, ".proto"))
+	return base + "_proto"
+}
+
 // selectPackage chooses a package to generate rules for.
 func selectPackage(dir, rel string, packageMap map[string]*Package) (*Package, error) {
 	if len(packageMap) == 0 {
@@ -159,6 +347,61 @@ func selectPackage(dir, rel string, packageMap map[string]*Package) (*Package, e
 	return nil, fmt.Errorf("%s: directory contains multiple proto packages. Gazelle can only generate a proto_library for one package.", dir)
 }
 
+// mixedPackageErrors returns an error for each proto_library that would be
+// generated in package mode with files from more than one proto package.
+// This happens when files from several packages are grouped with the
+// proto_group option, or when packages with the same last component (like
+// foo.v1 and bar.v1) would get rules with the same name. Each error lists
+// the package statements involved and suggests how to split the files.
+func mixedPackageErrors(pc *ProtoConfig, fs walk.FS, dir, rel string, pkgs []*Package) []error {
+	// For each rule name, find the first file of each proto package.
+	ruleFiles := make(map[string]map[string]FileInfo)
+	for _, pkg := range pkgs {
+		name := RuleName(pkg.Options[pc.groupOption], pkg.Name, rel)
+		if ruleFiles[name] == nil {
+			ruleFiles[name] = make(map[string]FileInfo)
+		}
+		for _, info := range pkg.Files {
+			if info.PackageName == "" {
+				// Generated files that couldn't be read, or files without a
+				// package statement.
+				continue
+			}
+			if first, ok := ruleFiles[name][info.PackageName]; !ok || info.Name < first.Name {
+				ruleFiles[name][info.PackageName] = info
+			}
+		}
+	}
+
+	names := make([]string, 0, len(ruleFiles))
+	for name, files := range ruleFiles {
+		if len(files) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		infos := make([]FileInfo, 0, len(ruleFiles[name]))
+		for _, info := range ruleFiles[name] {
+			infos = append(infos, info)
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s: proto_library %s would include files from %d proto packages:", dir, name, len(infos))
+		for _, info := range infos {
+			loc := info.Path
+			if line := packageLine(fs, info.Path); line > 0 {
+				loc = fmt.Sprintf("%s:%d", loc, line)
+			}
+			fmt.Fprintf(&sb, "\n\t%s: package %s", loc, info.PackageName)
+		}
+		sb.WriteString("\nMove each package to its own directory, or add '# gazelle:proto file' to generate a proto_library for each file.")
+		errs = append(errs, errors.New(sb.String()))
+	}
+	return errs
+}
+
 // goPackageName guesses the identifier in package declarations at the top of
 // the .pb.go files that will be generated for this package. "" is returned
 // if the package name cannot be determined.
@@ -186,13 +429,30 @@ func goPackageName(pkg *Package) string {
 	return ""
 }
 
+// setGoPackageOption sets the go_package option in info to value, replacing
+// the value from the .proto file if there is one.
+func setGoPackageOption(info *FileInfo, value string) {
+	for i := range info.Options {
+		if info.Options[i].Key == "go_package" {
+			info.Options[i].Value = value
+			return
+		}
+	}
+	info.Options = append(info.Options, Option{Key: "go_package", Value: value})
+}
+
 // generateProto creates a new proto_library rule for a package. The rule may
 // be empty if there are no sources.
 func generateProto(pc *ProtoConfig, rel string, pkg *Package, shouldSetVisibility bool) *rule.Rule {
 	var name string
-	if pc.Mode == DefaultMode {
+	switch pc.Mode {
+	case DefaultMode:
 		name = RuleName(goPackageName(pkg), pc.GoPrefix, rel)
-	} else {
+	case FileMode:
+		for f := range pkg.Files {
+			name = fileRuleName(f)
+		}
+	default:
 		name = RuleName(pkg.Options[pc.groupOption], pkg.Name, rel)
 	}
 	r := rule.NewRule("proto_library", name)
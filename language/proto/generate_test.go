@@ -139,6 +139,79 @@ proto_library(
 	}
 }
 
+func TestGenerateRulesGenSrc(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "gen/BUILD.old",
+			Content: `
+# gazelle:proto_gen_src :local.proto
+# gazelle:proto_gen_src //other:remote.proto
+
+proto_library(
+    name = "gen_proto",
+    srcs = [
+        ":local.proto",
+        "//other:remote.proto",
+    ],
+)
+`,
+		},
+		{
+			Path: "bazel-bin/gen/local.proto",
+			Content: `
+syntax = "proto3";
+
+package gen;
+
+import "google/protobuf/any.proto";
+`,
+		},
+	})
+	defer cleanup()
+
+	c, lang, cexts := testConfig(t, dir)
+	var got string
+	var empty []*rule.Rule
+	walk.Walk(c, cexts, []string{dir}, walk.VisitAllUpdateSubdirsMode, func(dir, rel string, c *config.Config, update bool, oldFile *rule.File, subdirs, regularFiles, genFiles []string) {
+		if rel != "gen" {
+			return
+		}
+		res := lang.GenerateRules(language.GenerateArgs{
+			Config:       c,
+			Dir:          dir,
+			Rel:          rel,
+			File:         oldFile,
+			Subdirs:      subdirs,
+			RegularFiles: regularFiles,
+			GenFiles:     genFiles})
+		empty = res.Empty
+		f := rule.EmptyFile("test", "")
+		for i, r := range res.Gen {
+			r.Insert(f)
+			if imports := res.Imports[i].([]string); !reflect.DeepEqual(imports, []string{"google/protobuf/any.proto"}) {
+				t.Errorf("got imports %q; want the imports of bazel-bin/gen/local.proto", imports)
+			}
+		}
+		f.Sync()
+		got = strings.TrimSpace(string(bzl.Format(f.File)))
+	})
+
+	if len(empty) > 0 {
+		t.Errorf("got %d empty rules; want 0", len(empty))
+	}
+	want := `proto_library(
+    name = "gen_proto",
+    srcs = [
+        "local.proto",
+        "//other:remote.proto",
+    ],
+    visibility = ["//visibility:public"],
+)`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestGeneratePackage(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		// TODO(jayconrod): set up testdata directory on windows before running test
@@ -188,6 +261,19 @@ func TestGeneratePackage(t *testing.T) {
 	}
 }
 
+func TestFileRuleName(t *testing.T) {
+	for src, want := range map[string]string{
+		"foo.proto":            "foo_proto",
+		"foo-bar.v1.proto":     "foo_bar_v1_proto",
+		"//a/b:gen.proto":      "gen_proto",
+		"sub/dir/nested.proto": "nested_proto",
+	} {
+		if got := fileRuleName(src); got != want {
+			t.Errorf("fileRuleName(%q): got %q; want %q", src, got, want)
+		}
+	}
+}
+
 func testConfig(t *testing.T, repoRoot string) (*config.Config, language.Language, []config.Configurer) {
 	cexts := []config.Configurer{
 		&config.CommonConfigurer{},
@@ -202,3 +288,53 @@ func testConfig(t *testing.T, repoRoot string) (*config.Config, language.Languag
 	cexts = append(cexts, lang)
 	return c, lang, cexts
 }
+
+func TestMixedPackageErrors(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "a.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo.v1;\n",
+		}, {
+			Path:    "b.proto",
+			Content: "syntax = \"proto3\";\n\n// b\npackage bar.v1;\n",
+		}, {
+			Path:    "c.proto",
+			Content: "syntax = \"proto3\";\n\npackage baz;\n",
+		},
+	})
+	defer cleanup()
+
+	pc := &ProtoConfig{Mode: PackageMode}
+	pkgs := buildPackages(pc, walk.OSFS{}, dir, "x", []string{"a.proto", "b.proto", "c.proto"}, nil, nil)
+	errs := mixedPackageErrors(pc, walk.OSFS{}, dir, "x", pkgs)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors; want 1: %v", len(errs), errs)
+	}
+	got := errs[0].Error()
+	for _, want := range []string{
+		"proto_library v1_proto would include files from 2 proto packages",
+		filepath.Join(dir, "a.proto") + ":3: package foo.v1",
+		filepath.Join(dir, "b.proto") + ":4: package bar.v1",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("error does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "baz") {
+		t.Errorf("error mentions package baz, which has its own rule:\n%s", got)
+	}
+
+	// Files from several packages grouped by an option.
+	pc.groupOption = "java_package"
+	for _, name := range []string{"a.proto", "c.proto"} {
+		content := "syntax = \"proto3\";\n\npackage " + strings.TrimSuffix(name, ".proto") + ";\noption java_package = \"com.example\";\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkgs = buildPackages(pc, walk.OSFS{}, dir, "x", []string{"a.proto", "c.proto"}, nil, nil)
+	errs = mixedPackageErrors(pc, walk.OSFS{}, dir, "x", pkgs)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "proto_library example_proto would include files from 2 proto packages") {
+		t.Errorf("with proto_group, got %v", errs)
+	}
+}
@@ -0,0 +1,138 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+const (
+	// defaultGoogleapisRepo is the name of the repository that provides the
+	// common protos, unless it's changed with proto_googleapis_repo.
+	defaultGoogleapisRepo = "googleapis"
+
+	// legacyGoogleapisRepo is the repository generated by rules_go from
+	// googleapis. Its rules group files by Go package, so imports are
+	// resolved with proto.csv instead of commonProtos.
+	legacyGoogleapisRepo = "go_googleapis"
+)
+
+// commonProtos lists the .proto files in googleapis that are widely
+// imported by other APIs, by directory. In googleapis, each of these files
+// has its own proto_library, named after the file, for example,
+// //google/api:annotations_proto.
+var commonProtos = map[string][]string{
+	"google/api": {
+		"annotations", "auth", "backend", "billing", "client", "config_change",
+		"consumer", "context", "control", "distribution", "documentation",
+		"endpoint", "error_reason", "field_behavior", "field_info", "http",
+		"httpbody", "label", "launch_stage", "log", "logging", "metric",
+		"monitored_resource", "monitoring", "policy", "quota", "resource",
+		"routing", "service", "source_info", "system_parameter", "usage",
+		"visibility",
+	},
+	"google/geo/type":    {"viewport"},
+	"google/iam/v1":      {"iam_policy", "options", "policy"},
+	"google/longrunning": {"operations"},
+	"google/rpc":         {"code", "error_details", "http", "status"},
+	"google/rpc/context": {"attribute_context", "audit_context"},
+	"google/type": {
+		"calendar_period", "color", "date", "datetime", "dayofweek", "decimal",
+		"expr", "fraction", "interval", "latlng", "localized_text", "money",
+		"month", "phone_number", "postal_address", "quaternion", "timeofday",
+	},
+}
+
+// commonProtoImports maps import paths of the files in commonProtos to the
+// labels of their rules in googleapis.
+var commonProtoImports = func() map[string]label.Label {
+	imports := make(map[string]label.Label)
+	for dir, names := range commonProtos {
+		for _, name := range names {
+			imports[path.Join(dir, name+".proto")] = label.New(defaultGoogleapisRepo, dir, name+"_proto")
+		}
+	}
+	return imports
+}()
+
+// GoogleapisLabel returns the label of the proto_library rule for imp, the
+// import path of one of the common protos in googleapis, like
+// "google/api/annotations.proto". Labels refer to the repository or vendored
+// copy named by the proto_googleapis_repo directive. If it isn't set, they
+// refer to @googleapis, or its repo_name in MODULE.bazel, when googleapis is
+// declared and go_googleapis isn't.
+//
+// false is returned if imp is not a common proto, if it should be resolved
+// to go_googleapis with proto.csv instead, or if known imports are disabled
+// by the proto mode.
+func GoogleapisLabel(c *config.Config, imp string) (label.Label, bool) {
+	pc := GetProtoConfig(c)
+	if pc == nil || !pc.Mode.ShouldUseKnownImports() {
+		return label.NoLabel, false
+	}
+	l, ok := commonProtoImports[imp]
+	if !ok {
+		return label.NoLabel, false
+	}
+	switch {
+	case pc.googleapisVendored:
+		l.Repo = ""
+		l.Pkg = path.Join(pc.googleapisPkg, l.Pkg)
+	case pc.googleapisRepo == legacyGoogleapisRepo:
+		return label.NoLabel, false
+	case pc.googleapisRepo != "":
+		l.Repo = pc.googleapisRepo
+	case pc.googleapisLegacy:
+		return label.NoLabel, false
+	default:
+		l.Repo = apparentRepo(pc.moduleRepos, l.Repo)
+	}
+	return l, true
+}
+
+// useLegacyGoogleapis returns whether common protos should be resolved to
+// go_googleapis by default, as they were before googleapis was supported.
+// This is the case when go_googleapis is declared in WORKSPACE, or when
+// googleapis is declared neither in WORKSPACE nor with bazel_dep in
+// MODULE.bazel, since the repository may not exist.
+func useLegacyGoogleapis(c *config.Config, moduleRepos map[string]string) bool {
+	_, declared := moduleRepos[defaultGoogleapisRepo]
+	for _, r := range c.Repos {
+		switch r.Name() {
+		case legacyGoogleapisRepo:
+			return true
+		case defaultGoogleapisRepo:
+			declared = true
+		}
+	}
+	return !declared
+}
+
+// parseGoogleapisRepo parses the value of the proto_googleapis_repo
+// directive. A repository name, with an optional leading "@", is returned
+// as repo. A directory in the main repository with a vendored copy, like
+// "//third_party/googleapis", is returned as pkg, with vendored set.
+func parseGoogleapisRepo(value string) (repo, pkg string, vendored bool) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "//") {
+		return "", strings.Trim(value, "/"), true
+	}
+	return strings.TrimPrefix(value, "@"), "", false
+}
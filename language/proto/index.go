@@ -0,0 +1,131 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// ExternalImportLabel returns the label of the proto_library rule in another
+// repository that provides the .proto file imp, if imp is listed in an index
+// loaded with the proto_import_index directive. Indexed imports take
+// precedence over Gazelle's known imports.
+func ExternalImportLabel(c *config.Config, imp string) (label.Label, bool) {
+	pc := GetProtoConfig(c)
+	if pc == nil {
+		return label.NoLabel, false
+	}
+	l, ok := pc.importIndex[imp]
+	return l, ok
+}
+
+// loadImportIndex reads the .proto files provided by the external
+// repository repo, for the proto_import_index directive. It returns a map
+// from import paths to labels of the proto_library rules that provide them.
+//
+// If p is a directory, like a copy of the repository's sources, it's scanned
+// for .proto files. Each file is assumed to be in a proto_library named
+// after its directory, as Gazelle generates them by default.
+//
+// Otherwise, p is a file in the same format as proto.csv: each line has an
+// import path and a label separated by a comma, and other fields are
+// ignored. Labels without a repository name refer to targets in repo.
+func loadImportIndex(repo, p string) (map[string]label.Label, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return scanImportIndex(repo, p)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readImportIndex(repo, p, f)
+}
+
+// readImportIndex reads an index of imports from r. name is
+// the name of the file, used in errors.
+func readImportIndex(repo, name string, r io.Reader) (map[string]label.Label, error) {
+	imports := make(map[string]label.Label)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: want an import path and a label", name, line)
+		}
+		imp := strings.TrimSpace(fields[0])
+		if !strings.HasSuffix(imp, ".proto") {
+			return nil, fmt.Errorf("%s:%d: import %q is not a .proto file", name, line, imp)
+		}
+		l, err := label.Parse(strings.TrimSpace(fields[1]))
+		if err != nil || l.Relative {
+			return nil, fmt.Errorf("%s:%d: invalid label %q; want an absolute label", name, line, fields[1])
+		}
+		if l.Repo == "" {
+			l.Repo = repo
+		}
+		imports[imp] = l
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return imports, nil
+}
+
+// scanImportIndex indexes the .proto files in the directory dir and its
+// subdirectories.
+func scanImportIndex(repo, dir string) (map[string]label.Label, error) {
+	imports := make(map[string]label.Label)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".proto") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		imp := filepath.ToSlash(rel)
+		pkg := path.Dir(imp)
+		if pkg == "." {
+			pkg = ""
+		}
+		imports[imp] = label.New(repo, pkg, RuleName(pkg))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
@@ -0,0 +1,97 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestReadImportIndex(t *testing.T) {
+	for _, tc := range []struct {
+		desc, content, wantErr string
+		want                   map[string]label.Label
+	}{
+		{
+			desc: "labels",
+			content: `# comment
+
+google/api/annotations.proto,//google/api:annotations_proto,google.golang.org/genproto/googleapis/api/annotations
+google/protobuf/any.proto, @com_google_protobuf//:any_proto
+`,
+			want: map[string]label.Label{
+				"google/api/annotations.proto": label.New("googleapis", "google/api", "annotations_proto"),
+				"google/protobuf/any.proto":    label.New("com_google_protobuf", "", "any_proto"),
+			},
+		}, {
+			desc:    "missing label",
+			content: "# comment\ngoogle/api/annotations.proto\n",
+			wantErr: "index.csv:2: want an import path and a label",
+		}, {
+			desc:    "not proto",
+			content: "google/api/annotations,//google/api:annotations_proto\n",
+			wantErr: `index.csv:1: import "google/api/annotations" is not a .proto file`,
+		}, {
+			desc:    "relative label",
+			content: "google/api/annotations.proto,:annotations_proto\n",
+			wantErr: `index.csv:1: invalid label ":annotations_proto"; want an absolute label`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := readImportIndex("googleapis", "index.csv", strings.NewReader(tc.content))
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("got error %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScanImportIndex(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "googleapis/root.proto"},
+		{Path: "googleapis/google/api/annotations.proto"},
+		{Path: "googleapis/google/api/http.proto"},
+		{Path: "googleapis/google/api/README.md"},
+	})
+	defer cleanup()
+
+	got, err := loadImportIndex("googleapis", filepath.Join(dir, "googleapis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]label.Label{
+		"root.proto":                   label.New("googleapis", "", "root_proto"),
+		"google/api/annotations.proto": label.New("googleapis", "google/api", "api_proto"),
+		"google/api/http.proto":        label.New("googleapis", "google/api", "api_proto"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
@@ -37,5 +37,60 @@ var protoLoads = []rule.LoadInfo{
 	},
 }
 
-func (_ *protoLang) Kinds() map[string]rule.KindInfo { return protoKinds }
-func (_ *protoLang) Loads() []rule.LoadInfo          { return protoLoads }
+func (pl *protoLang) Kinds() map[string]rule.KindInfo {
+	kinds := make(map[string]rule.KindInfo, len(protoKinds)+len(bindings)+len(pl.bindingTemplates))
+	for kind, info := range protoKinds {
+		kinds[kind] = info
+	}
+	for _, b := range pl.allBindings() {
+		kinds[b.Kind] = b.kindInfo()
+		if lb, ok := b.lite(); ok {
+			kinds[lb.Kind] = lb.kindInfo()
+		}
+	}
+	return kinds
+}
+
+func (pl *protoLang) Loads() []rule.LoadInfo {
+	loads := make([]rule.LoadInfo, 0, len(protoLoads)+len(bindings)+len(pl.bindingTemplates))
+	loadIndex := make(map[string]int)
+	for _, l := range protoLoads {
+		name := apparentLoad(pl.moduleRepos, l.Name)
+		loadIndex[name] = len(loads)
+		loads = append(loads, rule.LoadInfo{Name: name, Symbols: append([]string(nil), l.Symbols...)})
+	}
+	for _, b := range pl.allBindings() {
+		if b.Load == "" {
+			continue
+		}
+		symbols := []string{b.Kind}
+		if b.LiteKind != "" {
+			symbols = append(symbols, b.LiteKind)
+		}
+		name := apparentLoad(pl.moduleRepos, b.Load)
+		if i, ok := loadIndex[name]; ok {
+			loads[i].Symbols = append(loads[i].Symbols, symbols...)
+			continue
+		}
+		loadIndex[name] = len(loads)
+		loads = append(loads, rule.LoadInfo{Name: name, Symbols: symbols})
+	}
+	return loads
+}
+
+// allBindings returns the registered bindings and the bindings defined with
+// -proto_binding_template. Templates replace registered bindings with the
+// same Lang.
+func (pl *protoLang) allBindings() []Binding {
+	defined := make(map[string]bool, len(pl.bindingTemplates))
+	for _, b := range pl.bindingTemplates {
+		defined[b.Lang] = true
+	}
+	all := make([]Binding, 0, len(bindings)+len(pl.bindingTemplates))
+	for _, b := range bindings {
+		if !defined[b.Lang] {
+			all = append(all, b)
+		}
+	}
+	return append(all, pl.bindingTemplates...)
+}
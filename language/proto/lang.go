@@ -47,7 +47,9 @@ limitations under the License.
 // to resolve proto imports (e.g., import foo/bar/bar.proto) to the
 // proto_library that contains the named source file
 // (e.g., //foo/bar:bar_proto). If no indexed proto_library provides the source
-// file, Gazelle will guess a label, following conventions.
+// file, Gazelle will guess a label, following conventions. Files re-exported
+// by an imported file with "import public" are resolved as if they were
+// imported directly.
 //
 // No attempt is made to resolve protos to rules in external repositories,
 // since there's no indication that a proto import comes from an external
@@ -63,10 +65,33 @@ import "github.com/bazelbuild/bazel-gazelle/language"
 
 const protoName = "proto"
 
-type protoLang struct{}
+type protoLang struct {
+	// publicImports maps the import paths of indexed .proto files to the
+	// files they import with "import public". Resolve adds dependencies on
+	// publicly imported files to rules that import these files, since
+	// they're re-exported.
+	publicImports map[string][]string
+
+	// publicImportPkgs maps packages to the import paths recorded for them
+	// in publicImports, so they can be forgotten by RemovePackage.
+	publicImportPkgs map[string][]string
+
+	// bindingTemplates are bindings defined with the -proto_binding_template
+	// flag. Kinds and Loads include them, since they're called after flags
+	// are parsed.
+	bindingTemplates []Binding
+
+	// moduleRepos maps modules declared with bazel_dep to their repository
+	// names. Loads uses this to load rules from the right repositories with
+	// Bzlmod. See ProtoConfig.moduleRepos.
+	moduleRepos map[string]string
+}
 
 func (_ *protoLang) Name() string { return protoName }
 
 func NewLanguage() language.Language {
-	return &protoLang{}
+	return &protoLang{
+		publicImports:    make(map[string][]string),
+		publicImportPkgs: make(map[string][]string),
+	}
 }
@@ -20,11 +20,16 @@ import "path/filepath"
 // Package contains metadata for a set of .proto files that have the
 // same package name. This translates to a proto_library rule.
 type Package struct {
-	Name        string
-	Files       map[string]FileInfo
-	Imports     map[string]bool
-	Options     map[string]string
-	HasServices bool
+	Name         string
+	Files        map[string]FileInfo
+	Imports      map[string]bool
+	Options      map[string]string
+	HasServices  bool
+	HasHTTPRules bool
+
+	// LiteRuntime indicates whether any file in the package sets
+	// "option optimize_for = LITE_RUNTIME".
+	LiteRuntime bool
 }
 
 func newPackage(name string) *Package {
@@ -45,6 +50,8 @@ func (p *Package) addFile(info FileInfo) {
 		p.Options[opt.Key] = opt.Value
 	}
 	p.HasServices = p.HasServices || info.HasServices
+	p.HasHTTPRules = p.HasHTTPRules || info.HasHTTPRules
+	p.LiteRuntime = p.LiteRuntime || info.LiteRuntime
 }
 
 func (p *Package) addGenFile(dir, name string) {
@@ -25,55 +25,190 @@ import (
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-func (_ *protoLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
-	rel := f.Pkg
+func (pl *protoLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if isBindingKind(GetProtoConfig(c), r.Kind()) {
+		return nil
+	}
 	srcs := r.AttrStrings("srcs")
-	imports := make([]resolve.ImportSpec, len(srcs))
-	pc := GetProtoConfig(c)
+	imports := make([]resolve.ImportSpec, 0, len(srcs))
+	prefix, ok := importPrefix(GetProtoConfig(c), f.Pkg)
+	if !ok {
+		return nil
+	}
+	pkg, hasPkg := r.PrivateAttr(PackageKey).(Package)
+	for _, src := range srcs {
+		var imp string
+		if l, err := label.Parse(src); err == nil && !l.Relative {
+			// A file in another package, grouped with proto_anchor or
+			// declared with proto_gen_src. It's imported by its path in the
+			// repository, with prefixes applied. Files in other repositories
+			// aren't indexed.
+			srcPrefix, ok := importPrefix(GetProtoConfig(c), l.Pkg)
+			if !ok || l.Repo != "" {
+				continue
+			}
+			imp = path.Join(srcPrefix, l.Name)
+		} else {
+			imp = path.Join(prefix, src)
+		}
+		imports = append(imports, resolve.ImportSpec{Lang: "proto", Imp: imp})
+
+		// Remember which files are imported publicly, so rules that import
+		// this file can depend on them, too. This is only known for
+		// generated rules.
+		if hasPkg && len(pkg.Files[src].PublicImports) > 0 {
+			pl.publicImports[imp] = pkg.Files[src].PublicImports
+			pl.publicImportPkgs[f.Pkg] = append(pl.publicImportPkgs[f.Pkg], imp)
+		}
+	}
+	return imports
+}
+
+// RemovePackage forgets the public imports recorded for files indexed in
+// the package pkg.
+func (pl *protoLang) RemovePackage(pkg string) {
+	for _, imp := range pl.publicImportPkgs[pkg] {
+		delete(pl.publicImports, imp)
+	}
+	delete(pl.publicImportPkgs, pkg)
+}
+
+// publicImportClosure returns the files that the files imported with
+// imports re-export with "import public", directly or through other public
+// imports. Files in imports aren't included.
+func (pl *protoLang) publicImportClosure(imports []string) []string {
+	seen := make(map[string]bool, len(imports))
+	for _, imp := range imports {
+		seen[imp] = true
+	}
+	var closure []string
+	queue := append([]string(nil), imports...)
+	for len(queue) > 0 {
+		imp := queue[0]
+		queue = queue[1:]
+		for _, pub := range pl.publicImports[imp] {
+			if seen[pub] {
+				continue
+			}
+			seen[pub] = true
+			closure = append(closure, pub)
+			queue = append(queue, pub)
+		}
+	}
+	sort.Strings(closure)
+	return closure
+}
+
+// importPrefix returns the directory that .proto files in the package rel
+// are imported from, after applying proto_strip_import_prefix and
+// proto_import_prefix. false is returned if rel is not under the stripped
+// prefix.
+func importPrefix(pc *ProtoConfig, rel string) (string, bool) {
 	prefix := rel
 	if pc.StripImportPrefix != "" {
-		prefix = strings.TrimPrefix(rel, pc.StripImportPrefix[1:])
-		if rel == prefix {
-			return nil
+		strip := stripImportDir(pc)
+		if !pathtools.HasPrefix(rel, strip) {
+			return "", false
 		}
+		prefix = pathtools.TrimPrefix(rel, strip)
 	}
 	if pc.ImportPrefix != "" {
 		prefix = path.Join(pc.ImportPrefix, prefix)
 	}
-	for i, src := range srcs {
-		imports[i] = resolve.ImportSpec{Lang: "proto", Imp: path.Join(prefix, src)}
+	return prefix, true
+}
+
+// isWellKnownType returns whether imp is the import path of one of the
+// Well Known Types provided by @com_google_protobuf.
+func isWellKnownType(imp string) bool {
+	l, ok := knownImports[imp]
+	return ok && l.Repo == "com_google_protobuf"
+}
+
+// WellKnownTypeLabel returns the label of the proto_library rule for imp,
+// the import path of one of the Well Known Types, like
+// "google/protobuf/any.proto". The repository may be changed with the
+// proto_wkt_repo directive. Otherwise, if protobuf is declared with
+// bazel_dep in MODULE.bazel, its repository name is used. false is returned
+// if imp is not a Well Known Type, or if imports of them are resolved to
+// vendored copies.
+func WellKnownTypeLabel(c *config.Config, imp string) (label.Label, bool) {
+	pc := GetProtoConfig(c)
+	if pc == nil || !isWellKnownType(imp) || pc.UseVendoredWellKnownTypes() || !pc.Mode.ShouldUseKnownImports() {
+		return label.NoLabel, false
+	}
+	l := knownImports[imp]
+	if pc.wktRepo != "" {
+		l.Repo = pc.wktRepo
+	} else {
+		l.Repo = apparentRepo(pc.moduleRepos, l.Repo)
 	}
-	return imports
+	return l, true
+}
+
+// CrossResolve resolves imports of the Well Known Types and the common
+// protos in googleapis for rules in other languages, so extensions that
+// generate rules for .proto files don't need their own copy of the mapping.
+func (_ *protoLang) CrossResolve(c *config.Config, ix *resolve.RuleIndex, imp resolve.ImportSpec, lang string) []resolve.FindResult {
+	if imp.Lang != "proto" || lang == "proto" {
+		return nil
+	}
+	if l, ok := WellKnownTypeLabel(c, imp.Imp); ok {
+		return []resolve.FindResult{{Label: l}}
+	}
+	if l, ok := GoogleapisLabel(c, imp.Imp); ok {
+		return []resolve.FindResult{{Label: l}}
+	}
+	return nil
 }
 
 func (_ *protoLang) Embeds(r *rule.Rule, from label.Label) []label.Label {
 	return nil
 }
 
-func (_ *protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, importsRaw interface{}, from label.Label) {
+func (pl *protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, importsRaw interface{}, from label.Label) {
 	if importsRaw == nil {
 		// may not be set in tests.
 		return
 	}
 	imports := importsRaw.([]string)
+	public := pl.publicImportClosure(imports)
+	if b, ok := r.PrivateAttr(bindingKey).(Binding); ok {
+		resolveBinding(c, ix, b, r, append(imports[:len(imports):len(imports)], public...), from)
+		return
+	}
 	r.DelAttr("deps")
 	depSet := make(map[string]bool)
 	for _, imp := range imports {
-		l, err := resolveProto(c, ix, r, imp, from)
+		l, outcome, err := resolveProto(c, ix, r, imp, from)
 		if err == skipImportError {
+			resolve.RecordOutcome(c, "proto", outcome)
 			continue
 		} else if err != nil {
+			resolve.RecordOutcome(c, "proto", resolve.OutcomeUnresolved)
 			log.Print(err)
 		} else {
+			resolve.RecordOutcome(c, "proto", outcome)
 			l = l.Rel(from.Repo, from.Pkg)
 			depSet[l.String()] = true
 		}
 	}
+	for _, imp := range public {
+		// Files imported publicly by imported files are re-exported, so
+		// protoc needs them, and strict deps checking requires direct
+		// dependencies on them. Errors were already reported for the rules
+		// that import them directly, and outcomes aren't recorded, since
+		// these aren't imports of this rule's files.
+		if l, _, err := resolveProto(c, ix, r, imp, from); err == nil {
+			depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+		}
+	}
 	if len(depSet) > 0 {
 		deps := make([]string, 0, len(depSet))
 		for dep := range depSet {
@@ -89,44 +224,100 @@ var (
 	notFoundError   = errors.New("not found")
 )
 
-func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp string, from label.Label) (label.Label, error) {
+func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp string, from label.Label) (label.Label, resolve.Outcome, error) {
 	pc := GetProtoConfig(c)
 	if !strings.HasSuffix(imp, ".proto") {
-		return label.NoLabel, fmt.Errorf("can't import non-proto: %q", imp)
+		return label.NoLabel, resolve.OutcomeUnresolved, fmt.Errorf("can't import non-proto: %q", imp)
 	}
 
 	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Imp: imp, Lang: "proto"}, "proto"); ok {
-		return l, nil
+		return l, resolve.OutcomeOverride, nil
 	}
 
-	if l, ok := knownImports[imp]; ok && pc.Mode.ShouldUseKnownImports() {
+	if l, ok := WellKnownTypeLabel(c, imp); ok {
 		if l.Equal(from) {
-			return label.NoLabel, skipImportError
+			return label.NoLabel, resolve.OutcomeSelfImport, skipImportError
+		}
+		return l, resolve.OutcomeExternal, nil
+	}
+
+	_, indexed := pc.importIndex[imp]
+	if l, ok := GoogleapisLabel(c, imp); ok && !indexed {
+		if l.Equal(from) {
+			return label.NoLabel, resolve.OutcomeSelfImport, skipImportError
+		}
+		return l, resolve.OutcomeExternal, nil
+	}
+
+	if l, ok := knownImports[imp]; ok && !indexed && pc.Mode.ShouldUseKnownImports() && !(pc.UseVendoredWellKnownTypes() && isWellKnownType(imp)) {
+		if l.Equal(from) {
+			return label.NoLabel, resolve.OutcomeSelfImport, skipImportError
 		} else {
-			return l, nil
+			return l, resolve.OutcomeExternal, nil
 		}
 	}
 
-	if l, err := resolveWithIndex(ix, imp, from); err == nil || err == skipImportError {
-		return l, err
+	if l, err := resolveWithIndex(c, ix, imp, from); err == nil {
+		return l, resolve.OutcomeIndex, nil
+	} else if err == skipImportError {
+		return label.NoLabel, resolve.OutcomeSelfImport, err
 	} else if err != notFoundError {
-		return label.NoLabel, err
+		return label.NoLabel, resolve.OutcomeUnresolved, err
+	}
+
+	if l, ok := pc.importIndex[imp]; ok {
+		return l, resolve.OutcomeExternal, nil
 	}
 
 	rel := path.Dir(imp)
 	if rel == "." {
 		rel = ""
 	}
-	name := RuleName(rel)
-	return label.New("", rel, name), nil
+	if repo, ok := pc.BufDepsRepo(); ok {
+		// The import is probably provided by one of the Buf modules this
+		// module depends on.
+		return label.New(repo, rel, RuleName(rel)), resolve.OutcomeExternal, nil
+	}
+	rel = packageForImport(pc, from.Pkg, rel)
+	return label.New("", rel, RuleName(rel)), resolve.OutcomeExternal, nil
+}
+
+// packageForImport returns the package that .proto files imported from the
+// directory dir are expected to be in, when the import isn't indexed. It
+// inverts importPrefix: if the importing package from is under an import
+// root, and dir has the root's import prefix, dir is assumed to be under the
+// same root.
+func packageForImport(pc *ProtoConfig, from, dir string) string {
+	if pc.StripImportPrefix == "" {
+		return dir
+	}
+	root := stripImportDir(pc)
+	if !pathtools.HasPrefix(from, root) || !pathtools.HasPrefix(dir, pc.ImportPrefix) {
+		return dir
+	}
+	return path.Join(root, pathtools.TrimPrefix(dir, pc.ImportPrefix))
+}
+
+// stripImportDir returns the directory named by the absolute
+// strip_import_prefix in pc, relative to the repository root.
+func stripImportDir(pc *ProtoConfig) string {
+	return strings.Trim(pc.StripImportPrefix, "/")
 }
 
-func resolveWithIndex(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
-	matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: "proto", Imp: imp}, "proto")
+func resolveWithIndex(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+	spec := resolve.ImportSpec{Lang: "proto", Imp: imp}
+	matches := ix.FindRulesByImport(spec, "proto")
 	if len(matches) == 0 {
 		return label.NoLabel, notFoundError
 	}
 	if len(matches) > 1 {
+		candidates := make([]label.Label, len(matches))
+		for i, m := range matches {
+			candidates[i] = m.Label
+		}
+		if l, ok := resolve.ChooseRule(c, spec, "proto", from, candidates); ok {
+			return l, nil
+		}
 		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
 	}
 	if matches[0].IsSelfImport(from) {
@@ -17,9 +17,11 @@ package proto
 
 import (
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
@@ -33,6 +35,7 @@ func TestResolveProto(t *testing.T) {
 	}
 	type testCase struct {
 		desc      string
+		repos     []string
 		index     []buildFile
 		old, want string
 	}
@@ -71,7 +74,8 @@ proto_library(
 )
 `,
 		}, {
-			desc: "known",
+			desc:  "known",
+			repos: []string{"googleapis"},
 			index: []buildFile{{
 				rel: "google/rpc",
 				content: `
@@ -82,6 +86,27 @@ proto_library(
 `,
 			}},
 			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = [
+        "google/cloud/language/v1/language_service.proto",
+        "google/rpc/status.proto",
+    ],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = [
+        "@go_googleapis//google/cloud/language/v1:language_proto",
+        "@googleapis//google/rpc:status_proto",
+    ],
+)
+`,
+		}, {
+			desc:  "googleapis_with_go_googleapis",
+			repos: []string{"googleapis", "go_googleapis"},
+			old: `
 proto_library(
     name = "dep_proto",
     _imports = ["google/rpc/status.proto"],
@@ -92,6 +117,72 @@ proto_library(
     name = "dep_proto",
     deps = ["@go_googleapis//google/rpc:status_proto"],
 )
+`,
+		}, {
+			desc: "googleapis_repo",
+			index: []buildFile{{
+				rel:     "",
+				content: "# gazelle:proto_googleapis_repo @com_google_googleapis",
+			}},
+			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = [
+        "google/api/annotations.proto",
+        "google/longrunning/operations.proto",
+    ],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = [
+        "@com_google_googleapis//google/api:annotations_proto",
+        "@com_google_googleapis//google/longrunning:operations_proto",
+    ],
+)
+`,
+		}, {
+			desc: "googleapis_vendored",
+			index: []buildFile{{
+				rel:     "",
+				content: "# gazelle:proto_googleapis_repo //third_party/googleapis",
+			}},
+			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = ["google/api/annotations.proto"],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = ["//third_party/googleapis/google/api:annotations_proto"],
+)
+`,
+		}, {
+			desc: "googleapis_legacy",
+			index: []buildFile{{
+				rel:     "",
+				content: "# gazelle:proto_googleapis_repo go_googleapis",
+			}},
+			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = [
+        "google/api/http.proto",
+        "google/longrunning/operations.proto",
+    ],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = [
+        "@go_googleapis//google/api:annotations_proto",
+        "@go_googleapis//google/longrunning:longrunning_proto",
+    ],
+)
 `,
 		}, {
 			desc: "override",
@@ -252,6 +343,60 @@ proto_library(
     name = "dep_proto",
     deps = ["//foo/bar:bar_proto"],
 )
+`,
+		}, {
+			desc: "vendored_wkt",
+			index: []buildFile{{
+				rel: "",
+				content: `
+# gazelle:proto_vendored_wkt use
+`,
+			}, {
+				rel: "google/protobuf",
+				content: `
+proto_library(
+    name = "any_proto",
+    srcs = ["any.proto"],
+)
+`,
+			}},
+			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = [
+        "google/protobuf/any.proto",
+        "google/protobuf/empty.proto",
+    ],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = [
+        "//google/protobuf:any_proto",
+        "//google/protobuf:protobuf_proto",
+    ],
+)
+`,
+		}, {
+			desc: "wkt_repo",
+			index: []buildFile{{
+				rel: "",
+				content: `
+# gazelle:proto_wkt_repo @protobuf
+`,
+			}},
+			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = ["google/protobuf/any.proto"],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = ["@protobuf//:any_proto"],
+)
 `,
 		}, {
 			desc: "strip_import_prefix",
@@ -281,6 +426,26 @@ proto_library(
     name = "dep_proto",
     deps = ["//foo/bar/sub:foo_proto"],
 )
+`,
+		}, {
+			desc: "strip_import_prefix unindexed",
+			index: []buildFile{{
+				rel: "",
+				content: `
+# gazelle:proto_strip_import_prefix /test
+`,
+			}},
+			old: `
+proto_library(
+    name = "dep_proto",
+    _imports = ["sub/foo.proto"],
+)
+`,
+			want: `
+proto_library(
+    name = "dep_proto",
+    deps = ["//test/sub:sub_proto"],
+)
 `,
 		}, {
 			desc: "skip bad strip_import_prefix",
@@ -374,6 +539,10 @@ proto_library(
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			c, lang, cexts := testConfig(t, ".")
+			for _, name := range tc.repos {
+				c.Repos = append(c.Repos, rule.NewRule("http_archive", name))
+			}
+			GetProtoConfig(c).googleapisLegacy = useLegacyGoogleapis(c, nil)
 			mrslv := make(mapResolver)
 			mrslv["proto_library"] = lang
 			ix := resolve.NewRuleIndex(mrslv.Resolver)
@@ -415,6 +584,151 @@ proto_library(
 	}
 }
 
+func TestUseLegacyGoogleapis(t *testing.T) {
+	for _, tc := range []struct {
+		desc        string
+		repos       []string
+		moduleRepos map[string]string
+		want        bool
+	}{
+		{desc: "undeclared", want: true},
+		{desc: "workspace", repos: []string{"googleapis"}},
+		{desc: "module", moduleRepos: map[string]string{"googleapis": "com_google_googleapis"}},
+		{desc: "go_googleapis", repos: []string{"googleapis", "go_googleapis"}, want: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := config.New()
+			for _, name := range tc.repos {
+				c.Repos = append(c.Repos, rule.NewRule("http_archive", name))
+			}
+			if got := useLegacyGoogleapis(c, tc.moduleRepos); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCrossResolveWellKnownTypes(t *testing.T) {
+	c, lang, cexts := testConfig(t, ".")
+	ix := resolve.NewRuleIndex(nil, lang)
+	for _, tc := range []struct {
+		desc, directives string
+		imp              resolve.ImportSpec
+		lang             string
+		want             []resolve.FindResult
+	}{
+		{
+			desc: "wkt",
+			imp:  resolve.ImportSpec{Lang: "proto", Imp: "google/protobuf/any.proto"},
+			lang: "java",
+			want: []resolve.FindResult{{Label: label.New("com_google_protobuf", "", "any_proto")}},
+		}, {
+			desc:       "wkt_repo",
+			directives: "# gazelle:proto_wkt_repo protobuf",
+			imp:        resolve.ImportSpec{Lang: "proto", Imp: "google/protobuf/any.proto"},
+			lang:       "py",
+			want:       []resolve.FindResult{{Label: label.New("protobuf", "", "any_proto")}},
+		}, {
+			desc:       "vendored",
+			directives: "# gazelle:proto_vendored_wkt use",
+			imp:        resolve.ImportSpec{Lang: "proto", Imp: "google/protobuf/any.proto"},
+			lang:       "java",
+		}, {
+			desc: "not_wkt",
+			imp:  resolve.ImportSpec{Lang: "proto", Imp: "foo/foo.proto"},
+			lang: "java",
+		}, {
+			desc:       "googleapis",
+			directives: "# gazelle:proto_googleapis_repo googleapis",
+			imp:        resolve.ImportSpec{Lang: "proto", Imp: "google/api/http.proto"},
+			lang:       "java",
+			want:       []resolve.FindResult{{Label: label.New("googleapis", "google/api", "http_proto")}},
+		}, {
+			desc: "googleapis_legacy",
+			imp:  resolve.ImportSpec{Lang: "proto", Imp: "google/api/http.proto"},
+			lang: "java",
+		}, {
+			desc: "proto_lang",
+			imp:  resolve.ImportSpec{Lang: "proto", Imp: "google/protobuf/any.proto"},
+			lang: "proto",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := rule.LoadData("BUILD.bazel", "", []byte(tc.directives))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc := c.Clone()
+			for _, cext := range cexts {
+				cext.Configure(cc, "", f)
+			}
+			got := ix.FindRulesByImportWithConfig(cc, tc.imp, tc.lang)
+			if len(got) == 0 && len(tc.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveBindingDeps(t *testing.T) {
+	saved := bindings
+	defer func() { bindings = saved }()
+	bindings = append([]Binding(nil), bindings...)
+	RegisterBinding(Binding{
+		Lang:      "ts",
+		Kind:      "ts_proto_library",
+		Suffix:    "_ts_proto",
+		ProtoAttr: "proto",
+		DepsAttr:  "deps",
+	})
+
+	c, lang, cexts := testConfig(t, ".")
+	f, err := rule.LoadData("foo/BUILD.bazel", "foo", []byte("# gazelle:proto_bindings ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cext := range cexts {
+		cext.Configure(c, "foo", f)
+	}
+	mrslv := make(mapResolver)
+	mrslv["proto_library"] = lang
+	ix := resolve.NewRuleIndex(mrslv.Resolver)
+	barFile, err := rule.LoadData("bar/BUILD.bazel", "bar", []byte(`
+proto_library(
+    name = "bar_proto",
+    srcs = ["bar.proto"],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.AddRule(c, barFile.Rules[0], barFile)
+	fooProto := rule.NewRule("proto_library", "foo_proto")
+	fooProto.SetAttr("srcs", []string{"a.proto", "b.proto"})
+	fooProto.SetPrivateAttr(config.GazelleImportsKey, []string{"bar/bar.proto", "foo/b.proto", "google/protobuf/any.proto"})
+	ix.AddRule(c, fooProto, f)
+	ix.Finish()
+
+	gen := generateBindings(GetProtoConfig(c), fooProto, false)
+	if len(gen) != 1 {
+		t.Fatalf("got %d binding rules; want 1", len(gen))
+	}
+	r := gen[0]
+	lang.Resolve(c, ix, nil, r, r.PrivateAttr(config.GazelleImportsKey), label.New("", "foo", r.Name()))
+	if got, want := r.Name(), "foo_ts_proto"; got != want {
+		t.Errorf("got name %q; want %q", got, want)
+	}
+	if got, want := r.AttrStrings("proto"), []string{":foo_proto"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got proto %q; want %q", got, want)
+	}
+	if got, want := r.AttrStrings("deps"), []string{"//bar:bar_ts_proto", "@com_google_protobuf//:any_ts_proto"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got deps %q; want %q", got, want)
+	}
+}
+
 func convertImportsAttr(r *rule.Rule) interface{} {
 	value := r.AttrStrings("_imports")
 	if value == nil {
@@ -429,3 +743,17 @@ type mapResolver map[string]resolve.Resolver
 func (mr mapResolver) Resolver(r *rule.Rule, f string) resolve.Resolver {
 	return mr[r.Kind()]
 }
+
+func TestPublicImportClosure(t *testing.T) {
+	pl := NewLanguage().(*protoLang)
+	pl.publicImports = map[string][]string{
+		"a.proto": {"b.proto", "c.proto"},
+		"b.proto": {"d.proto"},
+		"d.proto": {"a.proto", "e.proto"},
+	}
+	got := pl.publicImportClosure([]string{"a.proto", "c.proto", "x.proto"})
+	want := []string{"b.proto", "d.proto", "e.proto"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
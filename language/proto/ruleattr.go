@@ -0,0 +1,136 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// ruleAttr is an attribute set on generated rules of one kind with the
+// proto_rule_attr directive, for example, options for a protoc plugin.
+type ruleAttr struct {
+	kind, attr string
+
+	// value is the attribute's value. If nil, the attribute is removed.
+	value bzl.Expr
+}
+
+// parseRuleAttr parses the value of a proto_rule_attr directive, which has
+// the form "kind attr [value]". The value is a Starlark expression, like
+// "True" or ["paths=source_relative"], and may contain spaces.
+func parseRuleAttr(pc *ProtoConfig, value string) (ruleAttr, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return ruleAttr{}, fmt.Errorf("proto_rule_attr: got %q; want kind attr [value]", value)
+	}
+	ra := ruleAttr{kind: fields[0], attr: fields[1]}
+	var info rule.KindInfo
+	if ra.kind == "proto_library" {
+		info = protoKinds[ra.kind]
+	} else if b, ok := findBindingByKind(pc, ra.kind); ok {
+		info = b.kindInfo()
+	} else {
+		return ruleAttr{}, fmt.Errorf("proto_rule_attr: %q is not a kind of rule generated for .proto files", ra.kind)
+	}
+	if ra.attr == "name" || info.MergeableAttrs[ra.attr] || info.ResolveAttrs[ra.attr] {
+		return ruleAttr{}, fmt.Errorf("proto_rule_attr: attribute %q of %s is set by Gazelle", ra.attr, ra.kind)
+	}
+
+	expr := strings.TrimSpace(value)
+	expr = strings.TrimSpace(strings.TrimPrefix(expr, ra.kind))
+	expr = strings.TrimSpace(strings.TrimPrefix(expr, ra.attr))
+	if expr == "" {
+		return ra, nil
+	}
+	f, err := bzl.ParseBuild("proto_rule_attr", []byte("_ = "+expr))
+	if err != nil || len(f.Stmt) != 1 {
+		return ruleAttr{}, fmt.Errorf("proto_rule_attr: value of %s is not a valid expression: %s", ra.attr, expr)
+	}
+	assign, ok := f.Stmt[0].(*bzl.AssignExpr)
+	if !ok {
+		return ruleAttr{}, fmt.Errorf("proto_rule_attr: value of %s is not a valid expression: %s", ra.attr, expr)
+	}
+	ra.value = assign.RHS
+	return ra, nil
+}
+
+// setRuleAttrs sets attributes from proto_rule_attr directives on the rules
+// in gen. Later directives take precedence.
+//
+// Gazelle doesn't merge these attributes, since they're not known when
+// kinds are collected, so a value set by hand would otherwise be kept. If
+// a rule in f will be merged with a generated rule, its attribute is changed
+// or removed directly, unless the rule or attribute is marked with # keep.
+func setRuleAttrs(c *config.Config, f *rule.File, gen []*rule.Rule) {
+	pc := GetProtoConfig(c)
+	if len(pc.ruleAttrs) == 0 {
+		return
+	}
+	for _, r := range gen {
+		attrs := make(map[string]bzl.Expr)
+		var keys []string
+		for _, ra := range pc.ruleAttrs {
+			if ra.kind != r.Kind() {
+				continue
+			}
+			if _, ok := attrs[ra.attr]; !ok {
+				keys = append(keys, ra.attr)
+			}
+			attrs[ra.attr] = ra.value
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		var old *rule.Rule
+		if f != nil {
+			old = existingRule(c, f, r)
+		}
+		for _, key := range keys {
+			value := attrs[key]
+			if value != nil {
+				r.SetAttr(key, value)
+			}
+			if old == nil || old.ShouldKeep() || rule.ShouldKeep(old.Attr(key)) {
+				continue
+			}
+			if value == nil {
+				old.DelAttr(key)
+			} else {
+				old.SetAttr(key, value)
+			}
+		}
+	}
+}
+
+// existingRule returns the rule in f with the same name as r and the same
+// kind, or the kind r's kind is mapped to with map_kind.
+func existingRule(c *config.Config, f *rule.File, r *rule.Rule) *rule.Rule {
+	kind := r.Kind()
+	if repl, ok := c.KindMap[kind]; ok {
+		kind = repl.KindName
+	}
+	for _, old := range f.Rules {
+		if old.Name() == r.Name() && (old.Kind() == r.Kind() || old.Kind() == kind) {
+			return old
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestParseRuleAttr(t *testing.T) {
+	pc := &ProtoConfig{}
+	for _, tc := range []struct {
+		value, kind, attr, want string
+		wantErr                 bool
+	}{
+		{
+			value: "py_proto_library testonly True",
+			kind:  "py_proto_library",
+			attr:  "testonly",
+			want:  "True",
+		}, {
+			value: `proto_library tags [ "b c" ]`,
+			kind:  "proto_library",
+			attr:  "tags",
+			want:  `["b c"]`,
+		}, {
+			value: "java_proto_library tags",
+			kind:  "java_proto_library",
+			attr:  "tags",
+		},
+		{value: "proto_library", wantErr: true},
+		{value: "go_library tags []", wantErr: true},
+		{value: "proto_library srcs []", wantErr: true},
+		{value: "py_proto_library deps []", wantErr: true},
+		{value: "py_proto_library name \"x\"", wantErr: true},
+		{value: "py_proto_library tags [", wantErr: true},
+		{value: "py_proto_library tags 1; 2", wantErr: true},
+	} {
+		ra, err := parseRuleAttr(pc, tc.value)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: got success; want error", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.value, err)
+			continue
+		}
+		var got string
+		if ra.value != nil {
+			got = bzl.FormatString(ra.value)
+		}
+		if ra.kind != tc.kind || ra.attr != tc.attr || got != tc.want {
+			t.Errorf("%q: got %s %s %q; want %s %s %q", tc.value, ra.kind, ra.attr, got, tc.kind, tc.attr, tc.want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// Values of the proto_unused_imports directive and flag.
+const (
+	unusedImportsIgnore = "ignore"
+	unusedImportsWarn   = "warn"
+)
+
+func checkUnusedImportsMode(value string) error {
+	switch value {
+	case "", unusedImportsIgnore, unusedImportsWarn:
+		return nil
+	default:
+		return fmt.Errorf("invalid value for proto_unused_imports: %q; want %s or %s", value, unusedImportsIgnore, unusedImportsWarn)
+	}
+}
+
+// checkUnusedImports reports imports in the .proto files in the directory
+// rel that aren't referenced by anything in the importing file. They add
+// dependencies to the proto_library without being needed. Source files are
+// never changed; the imports must be deleted by hand.
+//
+// Only imports of files in this repository are checked, since other files
+// can't be read. Public imports are never reported, since they may be used
+// by files that import this one.
+func checkUnusedImports(c *config.Config, fs walk.FS, pc *ProtoConfig, dir, rel string, files []string) {
+	for _, name := range files {
+		p := filepath.Join(dir, name)
+		content, err := fs.ReadFile(p)
+		if err != nil {
+			continue // reported when the file is read for generation
+		}
+		for _, imp := range findUnusedImports(c, fs, pc, rel, content) {
+			line := 1 + bytes.Count(content[:imp.start], []byte{'\n'})
+			c.ReportProblem(fmt.Errorf("%s:%d: import %q is not used", p, line, imp.path))
+		}
+	}
+}
+
+// findUnusedImports returns the imports in a .proto file in the directory
+// rel with the given content that aren't used.
+func findUnusedImports(c *config.Config, fs walk.FS, pc *ProtoConfig, rel string, content []byte) []protoImport {
+	syms := parseProtoSymbols(content)
+	var unused []protoImport
+	for _, imp := range syms.imports {
+		if imp.public {
+			continue
+		}
+		impSyms, ok := readImportedProto(c, fs, pc, rel, imp.path)
+		if !ok || isImportUsed(syms, impSyms) {
+			continue
+		}
+		unused = append(unused, imp)
+	}
+	return unused
+}
+
+// readImportedProto reads the definitions in the .proto file imported with
+// imp from the package rel. false is returned if the file isn't in this
+// repository.
+func readImportedProto(c *config.Config, fs walk.FS, pc *ProtoConfig, rel, imp string) (protoSymbols, bool) {
+	impDir := path.Dir(imp)
+	if impDir == "." {
+		impDir = ""
+	}
+	pkg := packageForImport(pc, rel, impDir)
+	content, err := fs.ReadFile(filepath.Join(c.RepoRoot, filepath.FromSlash(pkg), path.Base(imp)))
+	if err != nil {
+		return protoSymbols{}, false
+	}
+	return parseProtoSymbols(content), true
+}
+
+// isImportUsed returns whether any identifier in the importing file, with
+// symbols syms, may refer to a definition in the imported file, with symbols
+// impSyms. Identifiers are resolved in each enclosing scope of the importing
+// file's package, as protoc does, so the check errs on the side of keeping
+// imports.
+func isImportUsed(syms, impSyms protoSymbols) bool {
+	if impSyms.hasPublicImports() {
+		// Definitions from publicly imported files can be referenced
+		// through this import.
+		return true
+	}
+	defs := make(map[string]bool, len(impSyms.defs))
+	for _, d := range impSyms.defs {
+		defs[joinProtoName(impSyms.pkg, d)] = true
+	}
+	isDefined := func(name string) bool {
+		for {
+			if defs[name] {
+				return true
+			}
+			i := strings.LastIndexByte(name, '.')
+			if i < 0 {
+				return false
+			}
+			name = name[:i]
+		}
+	}
+	for _, ref := range syms.refs {
+		if strings.HasPrefix(ref, ".") {
+			if isDefined(ref[1:]) {
+				return true
+			}
+			continue
+		}
+		scope := syms.pkg
+		for {
+			if isDefined(joinProtoName(scope, ref)) {
+				return true
+			}
+			if scope == "" {
+				break
+			}
+			if i := strings.LastIndexByte(scope, '.'); i >= 0 {
+				scope = scope[:i]
+			} else {
+				scope = ""
+			}
+		}
+	}
+	return false
+}
+
+func joinProtoName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// protoSymbols are the names declared and referenced in a .proto file.
+type protoSymbols struct {
+	pkg string
+
+	// defs are the names of top-level messages, enums, services, and
+	// extensions, relative to pkg.
+	defs []string
+
+	imports []protoImport
+
+	// refs are identifiers that appear in the file outside of package and
+	// import statements, like field types and option names. They may be
+	// qualified, and fully qualified identifiers start with ".".
+	refs []string
+}
+
+type protoImport struct {
+	path   string
+	public bool
+
+	// start is the offset of the import statement in the file.
+	start int
+}
+
+func (s protoSymbols) hasPublicImports() bool {
+	for _, imp := range s.imports {
+		if imp.public {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProtoSymbols extracts the names declared and referenced in a .proto
+// file. Like the metadata in FileInfo, this isn't a full parse, but it's
+// enough to tell whether an import may be used.
+func parseProtoSymbols(content []byte) protoSymbols {
+	var syms protoSymbols
+	toks := tokenizeProto(content)
+	depth := 0
+	extendDepth := -1 // depth of the body of the current extend block
+	pendingExtend := false
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		next := func(n int) protoToken {
+			if i+n < len(toks) {
+				return toks[i+n]
+			}
+			return protoToken{}
+		}
+		switch {
+		case tok.text == "{":
+			depth++
+			if pendingExtend {
+				extendDepth = depth
+				pendingExtend = false
+			}
+		case tok.text == "}":
+			if depth == extendDepth {
+				extendDepth = -1
+			}
+			depth--
+		case tok.kind != tokenIdent:
+		case depth == 0 && tok.text == "package" && next(1).kind == tokenIdent:
+			syms.pkg = next(1).text
+			i++
+		case depth == 0 && tok.text == "import":
+			imp := protoImport{start: tok.start}
+			j := i + 1
+			if t := next(1); t.kind == tokenIdent && (t.text == "public" || t.text == "weak" || t.text == "option") {
+				imp.public = t.text == "public"
+				j++
+			}
+			if j >= len(toks) || toks[j].kind != tokenString || strings.ContainsRune(toks[j].text, '\\') {
+				// Imports with escapes are rare and aren't checked.
+				continue
+			}
+			quoted := toks[j].text
+			imp.path = normalizeProtoImport(quoted[1 : len(quoted)-1])
+			if j+1 < len(toks) && toks[j+1].text == ";" {
+				j++
+			}
+			syms.imports = append(syms.imports, imp)
+			i = j
+		case depth == 0 && (tok.text == "message" || tok.text == "enum" || tok.text == "service") && next(1).kind == tokenIdent:
+			syms.defs = append(syms.defs, next(1).text)
+			i++
+		case tok.text == "extend":
+			pendingExtend = true
+		case depth == 1 && depth == extendDepth && next(1).text == "=":
+			// The name of an extension field.
+			syms.defs = append(syms.defs, tok.text)
+		default:
+			syms.refs = append(syms.refs, tok.text)
+		}
+	}
+	return syms
+}
+
+type tokenKind int
+
+const (
+	tokenPunct tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+)
+
+type protoToken struct {
+	kind  tokenKind
+	text  string
+	start int
+}
+
+// tokenizeProto splits the content of a .proto file into tokens. Comments
+// and whitespace are skipped. Identifiers include qualifiers, with a leading
+// "." if they're fully qualified.
+func tokenizeProto(content []byte) []protoToken {
+	isIdentChar := func(c byte) bool {
+		return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '.'
+	}
+	isLetter := func(c byte) bool {
+		return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || c == '_'
+	}
+	var toks []protoToken
+	for i := 0; i < len(content); {
+		c := content[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+		case bytes.HasPrefix(content[i:], []byte("//")):
+			if j := bytes.IndexByte(content[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(content)
+			}
+			continue
+		case bytes.HasPrefix(content[i:], []byte("/*")):
+			if j := bytes.Index(content[i+2:], []byte("*/")); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(content)
+			}
+			continue
+		case c == '"' || c == '\'':
+			i++
+			for i < len(content) && content[i] != c && content[i] != '\n' {
+				if content[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(content) || content[i] != c {
+				// Unterminated string. Skip it.
+				continue
+			}
+			i++
+			toks = append(toks, protoToken{kind: tokenString, text: string(content[start:i]), start: start})
+		case isLetter(c) || c == '.' && i+1 < len(content) && isLetter(content[i+1]):
+			i++
+			for i < len(content) && isIdentChar(content[i]) {
+				i++
+			}
+			toks = append(toks, protoToken{kind: tokenIdent, text: string(content[start:i]), start: start})
+		case '0' <= c && c <= '9':
+			for i < len(content) && (isIdentChar(content[i]) || content[i] == '-' || content[i] == '+') {
+				i++
+			}
+			toks = append(toks, protoToken{kind: tokenNumber, text: string(content[start:i]), start: start})
+		default:
+			i++
+			toks = append(toks, protoToken{kind: tokenPunct, text: string(c), start: start})
+		}
+	}
+	return toks
+}
@@ -0,0 +1,125 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+func TestFindUnusedImports(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "foo/bar/msg.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo.bar;\n\nmessage Msg {\n  message Inner {}\n}\n",
+		}, {
+			Path:    "foo/enum.proto",
+			Content: "syntax = \"proto3\";\n\npackage foo;\n\nenum Kind { KIND_UNSPECIFIED = 0; }\n",
+		}, {
+			Path: "opts/opts.proto",
+			Content: `syntax = "proto3";
+
+package opts;
+
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions {
+  string label = 50000;
+}
+`,
+		}, {
+			Path:    "pub/pub.proto",
+			Content: "syntax = \"proto3\";\n\npackage pub;\n\nimport public \"foo/enum.proto\";\n",
+		}, {
+			Path:    "empty/empty.proto",
+			Content: "syntax = \"proto3\";\n\npackage empty;\n",
+		},
+	})
+	defer cleanup()
+	c, _, _ := testConfig(t, dir)
+	pc := GetProtoConfig(c)
+
+	for _, tc := range []struct {
+		desc, content string
+		want          []string
+	}{
+		{
+			desc: "qualified",
+			content: `package other;
+import "foo/bar/msg.proto";
+import "foo/enum.proto";
+message M { foo.bar.Msg.Inner m = 1; }
+`,
+			want: []string{"foo/enum.proto"},
+		}, {
+			desc: "fully qualified",
+			content: `package other;
+import "foo/bar/msg.proto";
+message M { .foo.bar.Msg m = 1; }
+`,
+		}, {
+			desc: "enclosing scope",
+			content: `package foo.bar.baz;
+import "foo/bar/msg.proto";
+import "foo/enum.proto";
+message M {
+  bar.Msg m = 1;
+  Kind k = 2;
+}
+`,
+		}, {
+			desc: "extension",
+			content: `package other;
+import "opts/opts.proto";
+message M { string s = 1 [(opts.label) = "x"]; }
+`,
+		}, {
+			desc: "comments and strings",
+			content: `package other;
+import "foo/bar/msg.proto";
+// foo.bar.Msg
+/* foo.bar.Msg */
+option java_package = "foo.bar.Msg";
+`,
+			want: []string{"foo/bar/msg.proto"},
+		}, {
+			desc: "public",
+			content: `package other;
+import public "empty/empty.proto";
+import "pub/pub.proto";
+message M { foo.Kind k = 1; }
+`,
+		}, {
+			desc: "unknown",
+			content: `package other;
+import "google/api/annotations.proto";
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			for _, imp := range findUnusedImports(c, walk.OSFS{}, pc, "other", []byte(tc.content)) {
+				got = append(got, imp.path)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
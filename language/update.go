@@ -100,6 +100,11 @@ type ImportReposArgs struct {
 	// Cache stores information fetched from the network and ensures that
 	// the same request isn't made multiple times.
 	Cache *repo.RemoteCache
+
+	// Group indicates whether imported repositories should be sorted into
+	// groups by how the main module uses them. This means the Groups map in
+	// the result should be filled in, if the importer supports it.
+	Group bool
 }
 
 // ImportReposResult contains return values for RepoImporter.ImportRepos.
@@ -115,6 +120,39 @@ type ImportReposResult struct {
 	// be set if ImportReposArgs.Prune is true.
 	Empty []*rule.Rule
 
+	// PruneReasons optionally maps the names of rules in Empty to short
+	// explanations of why they may be deleted. This is used to report
+	// pruned repositories.
+	PruneReasons map[string]string
+
+	// Groups optionally maps the names of rules in Gen to the groups they
+	// belong to. This should only be set if ImportReposArgs.Group is true.
+	// Rules that are missing from the map are not in any group.
+	Groups map[string]RepoGroup
+
 	// Error is any fatal error that occurred. Non-fatal errors should be logged.
 	Error error
 }
+
+// RepoGroup describes how the main module depends on an imported repository.
+// update-repos uses groups to organize repository rules in macro files.
+//
+// EXPERIMENTAL: this may change or be removed.
+type RepoGroup int
+
+const (
+	// RepoGroupNone means the repository's group is unknown.
+	RepoGroupNone RepoGroup = iota
+
+	// RepoGroupDirect is for repositories imported by non-test sources in
+	// the main module.
+	RepoGroupDirect
+
+	// RepoGroupTest is for repositories only imported by tests in the main
+	// module.
+	RepoGroupTest
+
+	// RepoGroupTransitive is for repositories that are only needed by other
+	// repositories.
+	RepoGroupTransitive
+)
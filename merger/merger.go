@@ -42,6 +42,7 @@ import (
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 // Phase indicates which attributes should be merged in matching rules.
@@ -97,6 +98,14 @@ const (
 // If a rule is marked with a "# keep" comment, the whole rule will not
 // be modified.
 func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo) {
+	MergeFileConfirm(oldFile, emptyRules, genRules, phase, kinds, nil)
+}
+
+// MergeFileConfirm is like MergeFile, but it calls confirmDelete before
+// deleting an existing rule that became empty. If confirmDelete returns
+// false, the rule is left as it was. confirmDelete may be nil, in which case
+// empty rules are always deleted.
+func MergeFileConfirm(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo, confirmDelete func(f *rule.File, r *rule.Rule) bool) {
 	getMergeAttrs := func(r *rule.Rule) map[string]bool {
 		if phase == PreResolve {
 			return kinds[r.Kind()].MergeableAttrs
@@ -108,12 +117,31 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 	// Merge empty rules into the file and delete any rules which become empty.
 	for _, emptyRule := range emptyRules {
 		if oldRule, _ := Match(oldFile.Rules, emptyRule, kinds[emptyRule.Kind()]); oldRule != nil {
-			if oldRule.ShouldKeep() {
+			if oldRule.ShouldKeep() || oldRule.Kind() != emptyRule.Kind() {
 				continue
 			}
+			var saved map[string]bzl.Expr
+			if confirmDelete != nil {
+				saved = make(map[string]bzl.Expr)
+				for _, key := range oldRule.AttrKeys() {
+					saved[key] = oldRule.Attr(key)
+				}
+			}
 			rule.MergeRules(emptyRule, oldRule, getMergeAttrs(emptyRule), oldFile.Path)
 			if oldRule.IsEmpty(kinds[oldRule.Kind()]) {
-				oldRule.Delete()
+				if confirmDelete == nil || confirmDelete(oldFile, oldRule) {
+					oldRule.Delete()
+				} else {
+					// Restore attributes removed or changed by the merge.
+					for _, key := range oldRule.AttrKeys() {
+						if _, ok := saved[key]; !ok {
+							oldRule.DelAttr(key)
+						}
+					}
+					for key, value := range saved {
+						oldRule.SetAttr(key, value)
+					}
+				}
 			}
 		}
 	}
@@ -155,6 +183,11 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 		if matchRules[i] == nil {
 			genRule.Insert(oldFile)
 		} else {
+			if oldRule := matchRules[i]; oldRule.Kind() != genRule.Kind() && !oldRule.ShouldKeep() {
+				// The generated rule replaces a rule of another kind. Change the
+				// kind in place, so attributes and comments are kept.
+				oldRule.SetKind(genRule.Kind())
+			}
 			rule.MergeRules(genRule, matchRules[i], getMergeAttrs(genRule), oldFile.Path)
 		}
 	}
@@ -184,11 +217,12 @@ func substituteRule(r *rule.Rule, substitutions map[string]string, info rule.Kin
 //
 // A rule is considered a match if its kind is equal to x's kind AND either its
 // name is equal OR at least one of the attributes in matchAttrs is equal.
+// Rules with kinds in info.ReplacesKinds are matched as if they had x's kind.
 //
 // If there are no matches, nil and nil are returned.
 //
-// If a rule has the same name but a different kind, nill and an error
-// are returned.
+// If a rule has the same name but a different kind that x doesn't replace,
+// nil and an error are returned.
 //
 // If there is exactly one match, the rule and nil are returned.
 //
@@ -201,18 +235,29 @@ func Match(rules []*rule.Rule, x *rule.Rule, info rule.KindInfo) (*rule.Rule, er
 	xkind := x.Kind()
 	var nameMatches []*rule.Rule
 	var kindMatches []*rule.Rule
+	sameKind := func(y *rule.Rule) bool {
+		if y.Kind() == xkind {
+			return true
+		}
+		for _, k := range info.ReplacesKinds {
+			if y.Kind() == k {
+				return true
+			}
+		}
+		return false
+	}
 	for _, y := range rules {
 		if xname == y.Name() {
 			nameMatches = append(nameMatches, y)
 		}
-		if xkind == y.Kind() {
+		if sameKind(y) {
 			kindMatches = append(kindMatches, y)
 		}
 	}
 
 	if len(nameMatches) == 1 {
 		y := nameMatches[0]
-		if xkind != y.Kind() {
+		if !sameKind(y) {
 			return nil, fmt.Errorf("could not merge %s(%s): a rule of the same name has kind %s", xkind, xname, y.Kind())
 		}
 		return y, nil
@@ -899,6 +899,236 @@ func TestMergeFile(t *testing.T) {
 	}
 }
 
+func TestMergeFileConfirmDelete(t *testing.T) {
+	previous := `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+)
+`
+	empty := `
+go_library(name = "go_default_library")
+`
+	for _, tc := range []struct {
+		desc    string
+		confirm bool
+		want    string
+	}{
+		{
+			desc:    "confirmed",
+			confirm: true,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+`,
+		}, {
+			desc:    "declined",
+			confirm: false,
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := rule.LoadData(filepath.Join("previous", "BUILD.bazel"), "", []byte(previous))
+			if err != nil {
+				t.Fatal(err)
+			}
+			emptyFile, err := rule.LoadData(filepath.Join("empty", "BUILD.bazel"), "", []byte(empty))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var asked []string
+			confirm := func(_ *rule.File, r *rule.Rule) bool {
+				asked = append(asked, r.Name())
+				return tc.confirm
+			}
+			merger.MergeFileConfirm(f, emptyFile.Rules, nil, merger.PreResolve, testKinds, confirm)
+			if len(asked) != 1 || asked[0] != "go_default_library" {
+				t.Errorf("confirm called for %v; want [go_default_library]", asked)
+			}
+			if got := string(f.Format()); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeFileReplacesKind(t *testing.T) {
+	kinds := make(map[string]rule.KindInfo)
+	for k, v := range testKinds {
+		kinds[k] = v
+	}
+	info := testKinds["go_library"]
+	info.ReplacesKinds = []string{"go_library"}
+	kinds["my_library"] = info
+
+	for _, tc := range []struct {
+		desc, previous, current, want string
+	}{
+		{
+			desc: "name",
+			previous: `
+# comment on the rule
+go_library(
+    name = "go_default_library",
+    testonly = True,  # comment on the attribute
+    srcs = ["old.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+			current: `
+my_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+			want: `# comment on the rule
+my_library(
+    name = "go_default_library",
+    testonly = True,  # comment on the attribute
+    srcs = ["new.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+		}, {
+			desc: "attr",
+			previous: `
+go_library(
+    name = "lib",
+    srcs = ["old.go"],
+    importpath = "example.com/repo/lib",
+    tags = ["manual"],
+)
+`,
+			current: `
+my_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+			want: `my_library(
+    name = "lib",
+    srcs = ["new.go"],
+    importpath = "example.com/repo/lib",
+    tags = ["manual"],
+)
+`,
+		}, {
+			desc: "keep",
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/repo/lib",
+)  # keep
+`,
+			current: `
+my_library(
+    name = "go_default_library",
+    srcs = ["new.go"],
+    importpath = "example.com/repo/lib",
+)
+`,
+			want: `go_library(
+    name = "go_default_library",
+    srcs = ["old.go"],
+    importpath = "example.com/repo/lib",
+)  # keep
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := rule.LoadData(filepath.Join("previous", "BUILD.bazel"), "", []byte(tc.previous))
+			if err != nil {
+				t.Fatal(err)
+			}
+			genFile, err := rule.LoadData(filepath.Join("current", "BUILD.bazel"), "", []byte(tc.current))
+			if err != nil {
+				t.Fatal(err)
+			}
+			merger.MergeFile(f, nil, genFile.Rules, merger.PreResolve, kinds)
+			if got := string(f.Format()); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeFileConditions(t *testing.T) {
+	previous := `
+go_library(
+    name = "go_default_library",
+    copts = ["-a"] + select({
+        "//:cgo_on": ["-old"],
+        "//conditions:default": [],
+    }),
+)
+`
+	current := `
+go_library(
+    name = "go_default_library",
+    copts = select({
+        "//:cgo_on": [],
+        "//conditions:default": ["-new"],
+    }),
+)
+`
+	for _, tc := range []struct {
+		desc       string
+		conditions []string
+		want       string
+	}{
+		{
+			desc:       "known",
+			conditions: []string{"//:cgo_on"},
+			want: `go_library(
+    name = "go_default_library",
+    copts = select({
+        "//conditions:default": ["-new"],
+    }),
+)
+`,
+		}, {
+			desc: "unknown",
+			want: `go_library(
+    name = "go_default_library",
+    copts = ["-a"] + select({
+        "//:cgo_on": ["-old"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := rule.LoadData(filepath.Join("previous", "BUILD.bazel"), "", []byte(previous))
+			if err != nil {
+				t.Fatal(err)
+			}
+			genFile, err := rule.LoadData(filepath.Join("current", "BUILD.bazel"), "", []byte(current))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.conditions != nil {
+				genFile.Rules[0].SetPrivateAttr(rule.ConditionsKey, tc.conditions)
+			}
+			merger.MergeFile(f, nil, genFile.Rules, merger.PreResolve, testKinds)
+			if got := string(f.Format()); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
 var (
 	testKinds map[string]rule.KindInfo
 	testLoads []rule.LoadInfo
@@ -0,0 +1,113 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// GoRepositoryAttrs holds the attributes of a go_repository rule that
+// provides a Go module, as Gazelle's update-repos command generates them.
+type GoRepositoryAttrs struct {
+	// Name is the name of the repository. If a known repository already
+	// provides the module, its name is used. Otherwise, the name is derived
+	// from the module path with label.ImportPathToBazelRepoName.
+	Name string
+
+	// ImportPath is the module path.
+	ImportPath string
+
+	// Version is the canonical version of the module, and Sum is the hash of
+	// its contents from go.sum. The go_repository rule downloads the module
+	// with the go command using these, unless URLs is set.
+	Version, Sum string
+
+	// URLs, StripPrefix, and Type are set when a module proxy is given to
+	// ModuleRepository. The go_repository rule downloads the module's zip
+	// file from the proxy directly, without the go command.
+	URLs        []string
+	StripPrefix string
+	Type        string
+}
+
+// ModuleRepository returns the attributes of the go_repository rule Gazelle
+// would generate for the module modPath at the version matching query.
+// query may be a canonical version or a query like "latest", as with
+// ModVersion. If proxy is not empty, it's the URL of a module proxy, and the
+// attributes download the module's zip file from it.
+//
+// This lets other tools generate and check repository rules that are
+// consistent with the ones Gazelle writes. Naming conventions that depend
+// on configuration, like -major_version_naming, are not applied.
+func (r *RemoteCache) ModuleRepository(modPath, query, proxy string) (GoRepositoryAttrs, error) {
+	name, version, sum, err := r.ModVersion(modPath, query)
+	if err != nil {
+		return GoRepositoryAttrs{}, err
+	}
+	attrs := GoRepositoryAttrs{
+		Name:       name,
+		ImportPath: modPath,
+		Version:    version,
+		Sum:        sum,
+	}
+	if proxy != "" {
+		zip := strings.TrimSuffix(proxy, "/") + "/" + EscapeModulePath(modPath) + "/@v/" + EscapeModulePath(version) + ".zip"
+		attrs.URLs = []string{zip}
+		attrs.StripPrefix = modPath + "@" + version
+		attrs.Type = "zip"
+	}
+	return attrs, nil
+}
+
+// Rule returns a go_repository rule with the attributes in a. When URLs is
+// set, Version and Sum are not written, since go_repository downloads the
+// module one way or the other.
+func (a GoRepositoryAttrs) Rule() *rule.Rule {
+	r := rule.NewRule("go_repository", a.Name)
+	r.SetAttr("importpath", a.ImportPath)
+	if len(a.URLs) > 0 {
+		r.SetAttr("urls", a.URLs)
+		if a.StripPrefix != "" {
+			r.SetAttr("strip_prefix", a.StripPrefix)
+		}
+		if a.Type != "" {
+			r.SetAttr("type", a.Type)
+		}
+		return r
+	}
+	r.SetAttr("version", a.Version)
+	r.SetAttr("sum", a.Sum)
+	return r
+}
+
+// EscapeModulePath escapes a module path or version for use in a URL or
+// file path, as the go command does for module proxies, checksum databases,
+// and the module cache. Upper case letters are replaced with '!' followed
+// by the lower case letter.
+func EscapeModulePath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			b.WriteRune(r + ('a' - 'A'))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
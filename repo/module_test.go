@@ -0,0 +1,114 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestModuleRepository(t *testing.T) {
+	for _, tc := range []struct {
+		desc, modPath, proxy string
+		repos                []Repo
+		want                 GoRepositoryAttrs
+		wantRule             string
+	}{
+		{
+			desc:    "version",
+			modPath: "example.com/unknown",
+			want: GoRepositoryAttrs{
+				Name:       "com_example_unknown",
+				ImportPath: "example.com/unknown",
+				Version:    "v1.2.3",
+				Sum:        "h1:abcdef",
+			},
+			wantRule: `
+go_repository(
+    name = "com_example_unknown",
+    importpath = "example.com/unknown",
+    sum = "h1:abcdef",
+    version = "v1.2.3",
+)
+`,
+		}, {
+			desc:    "known",
+			modPath: "example.com/known",
+			repos:   []Repo{{Name: "known", GoPrefix: "example.com/known"}},
+			want: GoRepositoryAttrs{
+				Name:       "known",
+				ImportPath: "example.com/known",
+				Version:    "v1.2.3",
+				Sum:        "h1:abcdef",
+			},
+			wantRule: `
+go_repository(
+    name = "known",
+    importpath = "example.com/known",
+    sum = "h1:abcdef",
+    version = "v1.2.3",
+)
+`,
+		}, {
+			desc:    "proxy",
+			modPath: "example.com/unknown",
+			proxy:   "https://proxy.example.com/",
+			want: GoRepositoryAttrs{
+				Name:        "com_example_unknown",
+				ImportPath:  "example.com/unknown",
+				Version:     "v1.2.3",
+				Sum:         "h1:abcdef",
+				URLs:        []string{"https://proxy.example.com/example.com/unknown/@v/v1.2.3.zip"},
+				StripPrefix: "example.com/unknown@v1.2.3",
+				Type:        "zip",
+			},
+			wantRule: `
+go_repository(
+    name = "com_example_unknown",
+    importpath = "example.com/unknown",
+    strip_prefix = "example.com/unknown@v1.2.3",
+    type = "zip",
+    urls = ["https://proxy.example.com/example.com/unknown/@v/v1.2.3.zip"],
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			rc := NewStubRemoteCache(tc.repos)
+			got, err := rc.ModuleRepository(tc.modPath, "latest", tc.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+			f := rule.EmptyFile("WORKSPACE", "")
+			got.Rule().Insert(f)
+			if gotRule, wantRule := strings.TrimSpace(string(f.Format())), strings.TrimSpace(tc.wantRule); gotRule != wantRule {
+				t.Errorf("got rule:\n%s\nwant:\n%s", gotRule, wantRule)
+			}
+		})
+	}
+}
+
+func TestEscapeModulePath(t *testing.T) {
+	if got, want := EscapeModulePath("github.com/Azure/go-autorest"), "github.com/!azure/go-autorest"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
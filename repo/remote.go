@@ -409,6 +409,23 @@ func (r *RemoteCache) Mod(importPath string) (modPath, name string, err error) {
 	return value.path, value.name, nil
 }
 
+// Known returns the path and repository name of a module that could provide
+// importPath, using only the repositories RemoteCache was initialized with.
+// Unlike Mod, Known never accesses the network. ok is false if no known
+// repository could provide importPath.
+func (r *RemoteCache) Known(importPath string) (modPath, name string, ok bool) {
+	for prefix := importPath; prefix != "." && prefix != "/"; prefix = path.Dir(prefix) {
+		v, found, err := r.mod.get(prefix)
+		if !found || err != nil {
+			continue
+		}
+		if value := v.(modValue); value.known {
+			return value.path, value.name, true
+		}
+	}
+	return "", "", false
+}
+
 func defaultModInfo(rc *RemoteCache, importPath string) (modPath string, err error) {
 	rc.initTmp()
 	if rc.tmpErr != nil {
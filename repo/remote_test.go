@@ -220,6 +220,32 @@ func TestMod(t *testing.T) {
 	}
 }
 
+func TestKnown(t *testing.T) {
+	rc := NewStubRemoteCache([]Repo{{
+		Name:     "known",
+		GoPrefix: "example.com/known/v2",
+	}})
+	for _, tc := range []struct {
+		importPath, wantModPath, wantName string
+	}{
+		{"example.com/known/v2/foo", "example.com/known/v2", "known"},
+		{"example.com/known/foo", "example.com/known/v2", "known"},
+		{"example.com/stub/foo", "", ""},
+	} {
+		modPath, name, ok := rc.Known(tc.importPath)
+		if ok != (tc.wantModPath != "") || modPath != tc.wantModPath || name != tc.wantName {
+			t.Errorf("Known(%q): got %q, %q, %v; want %q, %q", tc.importPath, modPath, name, ok, tc.wantModPath, tc.wantName)
+		}
+	}
+	// Modules found by looking them up aren't known.
+	if _, _, err := rc.Mod("example.com/stub/v2/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := rc.Known("example.com/stub/v2/foo"); ok {
+		t.Error("Known: got ok for a module that was looked up; want only initial repositories")
+	}
+}
+
 func TestModVersion(t *testing.T) {
 	for _, tc := range []struct {
 		desc, modPath, query           string
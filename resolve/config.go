@@ -40,6 +40,29 @@ func FindRuleWithOverride(c *config.Config, imp ImportSpec, lang string) (label.
 	return label.NoLabel, false
 }
 
+// ChooseFunc picks one of several rules that may be imported with the same
+// import string. It's called when dependency resolution would otherwise fail
+// because an import is ambiguous. candidates are absolute labels. ChooseFunc
+// returns false if no choice was made.
+type ChooseFunc func(imp ImportSpec, lang string, from label.Label, candidates []label.Label) (label.Label, bool)
+
+// SetChooser sets the function ChooseRule will call in c and in
+// configurations derived from c.
+func SetChooser(c *config.Config, choose ChooseFunc) {
+	getResolveConfig(c).choose = choose
+}
+
+// ChooseRule asks the function set with SetChooser to pick a rule for an
+// ambiguous import. If no function was set or no choice was made,
+// label.NoLabel and false are returned.
+func ChooseRule(c *config.Config, imp ImportSpec, lang string, from label.Label, candidates []label.Label) (label.Label, bool) {
+	rc := getResolveConfig(c)
+	if rc.choose == nil {
+		return label.NoLabel, false
+	}
+	return rc.choose(imp, lang, from, candidates)
+}
+
 type overrideSpec struct {
 	imp  ImportSpec
 	lang string
@@ -54,6 +77,8 @@ func (o overrideSpec) matches(imp ImportSpec, lang string) bool {
 
 type resolveConfig struct {
 	overrides []overrideSpec
+	choose    ChooseFunc
+	stats     *Stats
 }
 
 const resolveName = "_resolve"
@@ -78,6 +103,8 @@ func (_ *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	rc := getResolveConfig(c)
 	rcCopy := &resolveConfig{
 		overrides: rc.overrides[:],
+		choose:    rc.choose,
+		stats:     rc.stats,
 	}
 
 	if f != nil {
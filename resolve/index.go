@@ -61,13 +61,69 @@ type Resolver interface {
 	Resolve(c *config.Config, ix *RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label)
 }
 
+// CrossResolver is an interface that language extensions can implement to
+// resolve imports in other languages. For example, the proto extension
+// knows the labels of the Well Known Types, which rules generated by other
+// languages may depend on.
+type CrossResolver interface {
+	// CrossResolve attempts to resolve an import string to a rule for a rule
+	// of the language lang. imp.Lang is the language of the import string,
+	// which may differ from lang. nil is returned if the import can't be
+	// resolved.
+	CrossResolve(c *config.Config, ix *RuleIndex, imp ImportSpec, lang string) []FindResult
+}
+
+// ForeignImporter is an interface that language extensions can implement to
+// index rules of kinds they don't own. For example, the Go extension indexes
+// cc_library rules by the headers they provide, so cgo packages can depend
+// on them, but the kind may belong to a C++ extension or to no extension.
+type ForeignImporter interface {
+	// Name returns the name of the language, as in Resolver.Name. Rules
+	// indexed by ForeignImports are only found by FindRulesByImport when
+	// its lang argument matches.
+	Name() string
+
+	// ForeignImports returns a list of ImportSpecs that can be used to import
+	// the rule r from this language. It's called for every rule added to the
+	// index, whichever extension owns its kind. nil means the rule isn't
+	// importable from this language.
+	ForeignImports(c *config.Config, r *rule.Rule, f *rule.File) []ImportSpec
+}
+
+// PackageRemover is an interface that language extensions can implement to
+// forget state recorded for a package, for example, while indexing its
+// rules. RemovePackage is called by RuleIndex.RemovePackage, before the
+// package is indexed again or after it's deleted.
+type PackageRemover interface {
+	RemovePackage(pkg string)
+}
+
 // RuleIndex is a table of rules in a workspace, indexed by label and by
 // import path. Used by Resolver to map import paths to labels.
 type RuleIndex struct {
-	rules     []*ruleRecord
-	labelMap  map[label.Label]*ruleRecord
-	importMap map[ImportSpec][]*ruleRecord
-	mrslv     func(r *rule.Rule, pkgRel string) Resolver
+	rules          []*ruleRecord
+	labelMap       map[label.Label]*ruleRecord
+	importMap      map[ImportSpec][]*ruleRecord
+	mrslv          func(r *rule.Rule, pkgRel string) Resolver
+	crossResolvers []CrossResolver
+
+	// foreignImporters, foreignRules and foreignMap index rules for
+	// extensions that don't own their kinds. See ForeignImporter.
+	foreignImporters []ForeignImporter
+	foreignRules     []*foreignRecord
+	foreignMap       map[ImportSpec][]*foreignRecord
+
+	// packageRemovers are notified when a package is removed from the index.
+	packageRemovers []PackageRemover
+
+	// files is a list of files containing rules passed to AddRule, whether
+	// or not the rules were indexed. It's used to find references to renamed
+	// rules.
+	files   []*rule.File
+	fileSet map[*rule.File]bool
+
+	// pkgs is the set of packages containing files in files.
+	pkgs map[string]bool
 }
 
 // ruleRecord contains information about a rule relevant to import indexing.
@@ -76,8 +132,12 @@ type ruleRecord struct {
 	label label.Label
 	file  *rule.File
 
-	// importedAs is a list of ImportSpecs by which this rule may be imported.
-	// Used to build a map from ImportSpecs to ruleRecords.
+	// imports is the list of ImportSpecs returned by Resolver.Imports.
+	imports []ImportSpec
+
+	// importedAs is a list of ImportSpecs by which this rule may be imported,
+	// including imports of rules it embeds. Used to build a map from
+	// ImportSpecs to ruleRecords.
 	importedAs []ImportSpec
 
 	// embeds is the transitive closure of labels for rules that this rule embeds
@@ -93,14 +153,46 @@ type ruleRecord struct {
 	didCollectEmbeds bool
 }
 
+// foreignRecord is a rule indexed by a ForeignImporter. Foreign records don't
+// embed other rules.
+type foreignRecord struct {
+	rule    *rule.Rule
+	label   label.Label
+	file    *rule.File
+	lang    string
+	imports []ImportSpec
+}
+
 // NewRuleIndex creates a new index.
 //
-// kindToResolver is a map from rule kinds (for example, "go_library") to
-// Resolvers that support those kinds.
-func NewRuleIndex(mrslv func(r *rule.Rule, pkgRel string) Resolver) *RuleIndex {
+// mrslv returns the Resolver for a rule (for example, the Go extension for
+// "go_library"). exts may contain language extensions; those that implement
+// CrossResolver are consulted by FindRulesByImportWithConfig, those that
+// implement ForeignImporter are asked to index every rule, and those that
+// implement PackageRemover are notified by RemovePackage.
+func NewRuleIndex(mrslv func(r *rule.Rule, pkgRel string) Resolver, exts ...interface{}) *RuleIndex {
+	var crossResolvers []CrossResolver
+	var foreignImporters []ForeignImporter
+	var packageRemovers []PackageRemover
+	for _, e := range exts {
+		if cr, ok := e.(CrossResolver); ok {
+			crossResolvers = append(crossResolvers, cr)
+		}
+		if fi, ok := e.(ForeignImporter); ok {
+			foreignImporters = append(foreignImporters, fi)
+		}
+		if pr, ok := e.(PackageRemover); ok {
+			packageRemovers = append(packageRemovers, pr)
+		}
+	}
 	return &RuleIndex{
-		labelMap: make(map[label.Label]*ruleRecord),
-		mrslv:    mrslv,
+		labelMap:         make(map[label.Label]*ruleRecord),
+		mrslv:            mrslv,
+		crossResolvers:   crossResolvers,
+		foreignImporters: foreignImporters,
+		packageRemovers:  packageRemovers,
+		fileSet:          make(map[*rule.File]bool),
+		pkgs:             make(map[string]bool),
 	}
 }
 
@@ -108,8 +200,29 @@ func NewRuleIndex(mrslv func(r *rule.Rule, pkgRel string) Resolver) *RuleIndex {
 // is a known resolver for the rule's kind and Resolver.Imports returns a
 // non-nil slice.
 //
-// AddRule may only be called before Finish.
+// Rules may be added after Finish to update the index, for example, in a
+// long-running process that re-reads changed build files. Finish must be
+// called again before the index is used. RemovePackage should be called
+// first to remove rules from an old version of the file.
 func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
+	if !ix.fileSet[f] {
+		ix.fileSet[f] = true
+		ix.files = append(ix.files, f)
+		ix.pkgs[f.Pkg] = true
+	}
+
+	for _, fi := range ix.foreignImporters {
+		if imps := fi.ForeignImports(c, r, f); imps != nil {
+			ix.foreignRules = append(ix.foreignRules, &foreignRecord{
+				rule:    r,
+				label:   label.New(c.RepoName, f.Pkg, r.Name()),
+				file:    f,
+				lang:    fi.Name(),
+				imports: imps,
+			})
+		}
+	}
+
 	var imps []ImportSpec
 	if rslv := ix.mrslv(r, f.Pkg); rslv != nil {
 		imps = rslv.Imports(c, r, f)
@@ -124,6 +237,7 @@ func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
 		rule:       r,
 		label:      label.New(c.RepoName, f.Pkg, r.Name()),
 		file:       f,
+		imports:    imps,
 		importedAs: imps,
 	}
 	if _, ok := ix.labelMap[record.label]; ok {
@@ -140,13 +254,75 @@ func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
 //
 // Finish must be called after all AddRule calls and before any
 // FindRulesByImport calls.
+//
+// Finish may be called again after rules are added or removed. Embeds and
+// imports are collected again for all rules, which is much cheaper than
+// reading every build file again.
 func (ix *RuleIndex) Finish() {
+	for _, r := range ix.rules {
+		r.importedAs = r.imports[:len(r.imports):len(r.imports)]
+		r.embeds = nil
+		r.embedded = false
+		r.didCollectEmbeds = false
+	}
 	for _, r := range ix.rules {
 		ix.collectEmbeds(r)
 	}
 	ix.buildImportIndex()
 }
 
+// RemovePackage removes rules in the package pkg from the index, along with
+// the files that contained them. It's used to update the index after the
+// package's build file changes or is deleted. Extensions that implement
+// PackageRemover are notified, even if no rules were indexed for pkg.
+// Finish must be called afterward, before the index is used.
+func (ix *RuleIndex) RemovePackage(pkg string) {
+	for _, pr := range ix.packageRemovers {
+		pr.RemovePackage(pkg)
+	}
+	if !ix.pkgs[pkg] {
+		return
+	}
+	delete(ix.pkgs, pkg)
+
+	rules := ix.rules[:0]
+	for _, r := range ix.rules {
+		if r.file.Pkg == pkg {
+			delete(ix.labelMap, r.label)
+		} else {
+			rules = append(rules, r)
+		}
+	}
+	for i := len(rules); i < len(ix.rules); i++ {
+		ix.rules[i] = nil
+	}
+	ix.rules = rules
+
+	foreignRules := ix.foreignRules[:0]
+	for _, r := range ix.foreignRules {
+		if r.file.Pkg != pkg {
+			foreignRules = append(foreignRules, r)
+		}
+	}
+	for i := len(foreignRules); i < len(ix.foreignRules); i++ {
+		ix.foreignRules[i] = nil
+	}
+	ix.foreignRules = foreignRules
+
+	files := ix.files[:0]
+	for _, f := range ix.files {
+		if f.Pkg == pkg {
+			delete(ix.fileSet, f)
+		} else {
+			files = append(files, f)
+		}
+	}
+	for i := len(files); i < len(ix.files); i++ {
+		ix.files[i] = nil
+	}
+	ix.files = files
+}
+
 func (ix *RuleIndex) collectEmbeds(r *ruleRecord) {
 	if r.didCollectEmbeds {
 		return
@@ -185,6 +361,53 @@ func (ix *RuleIndex) buildImportIndex() {
 			ix.importMap[imp] = append(ix.importMap[imp], r)
 		}
 	}
+
+	ix.foreignMap = make(map[ImportSpec][]*foreignRecord)
+	for _, r := range ix.foreignRules {
+		indexed := make(map[ImportSpec]bool)
+		for _, imp := range r.imports {
+			if indexed[imp] {
+				continue
+			}
+			indexed[imp] = true
+			ix.foreignMap[imp] = append(ix.foreignMap[imp], r)
+		}
+	}
+}
+
+// RenameRule changes the name of r, a rule declared in f, to newName, and
+// rewrites labels that refer to r in all files with rules added to the index.
+// If r was indexed, it may be found by its new label. See rule.RenameRule for
+// details on which references are rewritten. The number of rewritten labels
+// is returned.
+//
+// RenameRule may be called before or after Finish.
+func (ix *RuleIndex) RenameRule(c *config.Config, f *rule.File, r *rule.Rule, newName string) int {
+	oldLabel := label.New(c.RepoName, f.Pkg, r.Name())
+	newLabel := label.New(c.RepoName, f.Pkg, newName)
+	n := rule.RenameRule(c.RepoName, f, r, newName, ix.files)
+	if oldLabel.Equal(newLabel) {
+		return n
+	}
+
+	if record, ok := ix.labelMap[oldLabel]; ok && record.rule == r {
+		delete(ix.labelMap, oldLabel)
+		record.label = newLabel
+		ix.labelMap[newLabel] = record
+	}
+	for _, record := range ix.foreignRules {
+		if record.rule == r {
+			record.label = newLabel
+		}
+	}
+	for _, record := range ix.rules {
+		for i, e := range record.embeds {
+			if e.Equal(oldLabel) {
+				record.embeds[i] = newLabel
+			}
+		}
+	}
+	return n
 }
 
 func (ix *RuleIndex) findRuleByLabel(label label.Label, from label.Label) (*ruleRecord, bool) {
@@ -226,6 +449,23 @@ func (ix *RuleIndex) FindRulesByImport(imp ImportSpec, lang string) []FindResult
 			Embeds: m.embeds,
 		})
 	}
+	for _, m := range ix.foreignMap[imp] {
+		if m.lang != lang {
+			continue
+		}
+		results = append(results, FindResult{Label: m.label})
+	}
+	return results
+}
+
+// FindRulesByImportWithConfig is like FindRulesByImport, but it also asks
+// language extensions that implement CrossResolver to resolve imp. Results
+// from the index come first.
+func (ix *RuleIndex) FindRulesByImportWithConfig(c *config.Config, imp ImportSpec, lang string) []FindResult {
+	results := ix.FindRulesByImport(imp, lang)
+	for _, cr := range ix.crossResolvers {
+		results = append(results, cr.CrossResolve(c, ix, imp, lang)...)
+	}
 	return results
 }
 
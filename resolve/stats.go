@@ -0,0 +1,139 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// Outcome describes how an import was resolved to a dependency.
+type Outcome string
+
+const (
+	// OutcomeSelfImport means the import was provided by the rule itself or
+	// by a rule it embeds, so no dependency was added.
+	OutcomeSelfImport Outcome = "self-import"
+
+	// OutcomeIndex means the import was resolved to a rule found in the
+	// dependency resolution index.
+	OutcomeIndex Outcome = "index"
+
+	// OutcomeLocal means the import was resolved by convention to a rule in
+	// the current repository, without consulting the index, for example,
+	// because indexing is disabled.
+	OutcomeLocal Outcome = "local"
+
+	// OutcomeVendored means the import was resolved by convention to a rule
+	// in the vendor directory.
+	OutcomeVendored Outcome = "vendored"
+
+	// OutcomeExternal means the index had no rule for the import, and it was
+	// resolved by convention or by looking up an external repository.
+	OutcomeExternal Outcome = "external"
+
+	// OutcomeOverride means the import was resolved with a user-provided
+	// mapping, like a # gazelle:resolve directive.
+	OutcomeOverride Outcome = "override"
+
+	// OutcomeUnresolved means the import could not be resolved, for example,
+	// because it was ambiguous or no repository provides it.
+	OutcomeUnresolved Outcome = "unresolved"
+)
+
+// outcomes lists outcomes in the order they're reported.
+var outcomes = []Outcome{OutcomeSelfImport, OutcomeIndex, OutcomeLocal, OutcomeVendored, OutcomeExternal, OutcomeOverride, OutcomeUnresolved}
+
+// Stats counts resolution outcomes for each language. Language extensions
+// record outcomes with RecordOutcome. Stats is safe for concurrent use.
+type Stats struct {
+	mu     sync.Mutex
+	counts map[string]map[Outcome]int
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats {
+	return &Stats{counts: make(map[string]map[Outcome]int)}
+}
+
+// Record counts one import of the given language resolved with outcome o.
+func (s *Stats) Record(lang string, o Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.counts[lang]
+	if !ok {
+		m = make(map[Outcome]int)
+		s.counts[lang] = m
+	}
+	m[o]++
+}
+
+// Count returns the number of imports of the given language that were
+// resolved with outcome o.
+func (s *Stats) Count(lang string, o Outcome) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[lang][o]
+}
+
+// WriteSummary writes a line for each language with the number of imports
+// resolved with each outcome, sorted by language name.
+func (s *Stats) WriteSummary(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	langs := make([]string, 0, len(s.counts))
+	for lang := range s.counts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		total := 0
+		for _, n := range s.counts[lang] {
+			total += n
+		}
+		if _, err := fmt.Fprintf(w, "%s: %d imports", lang, total); err != nil {
+			return err
+		}
+		for _, o := range outcomes {
+			if _, err := fmt.Fprintf(w, ", %d %s", s.counts[lang][o], o); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStats sets the Stats RecordOutcome counts outcomes in for c and for
+// configurations derived from c.
+func SetStats(c *config.Config, s *Stats) {
+	getResolveConfig(c).stats = s
+}
+
+// RecordOutcome records how an import of the given language was resolved
+// in the Stats set with SetStats. If no Stats was set, RecordOutcome does
+// nothing.
+func RecordOutcome(c *config.Config, lang string, o Outcome) {
+	if s := getResolveConfig(c).stats; s != nil {
+		s.Record(lang, o)
+	}
+}
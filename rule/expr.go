@@ -116,7 +116,7 @@ func MapExprStrings(e bzl.Expr, f func(string) string) bzl.Expr {
 // expressions. If the expression could not have been generted by
 // PlatformStrings, the expression will be returned unmodified.
 func FlattenExpr(e bzl.Expr) bzl.Expr {
-	ps, err := extractPlatformStringsExprs(e)
+	ps, err := extractPlatformStringsExprs(e, nil)
 	if err != nil {
 		return e
 	}
@@ -156,7 +156,7 @@ func FlattenExpr(e bzl.Expr) bzl.Expr {
 			return e
 		}
 	}
-	for _, d := range []*bzl.DictExpr{ps.os, ps.arch, ps.platform} {
+	for _, d := range []*bzl.DictExpr{ps.os, ps.arch, ps.platform, ps.condition} {
 		if d == nil {
 			continue
 		}
@@ -208,21 +208,24 @@ func stringValue(e bzl.Expr) string {
 //
 // The matched expression has the form:
 //
-// [] + select({}) + select({}) + select({})
+// [] + select({}) + select({}) + select({}) + select({})
 //
-// The four collections may appear in any order, and some or all of them may
-// be omitted (all fields are nil for a nil expression).
+// The collections may appear in any order, and some or all of them may be
+// omitted (all fields are nil for a nil expression). The last select is on
+// one of the conditions listed in the ConditionsKey attribute of the
+// generated rule, like whether cgo is enabled.
 type platformStringsExprs struct {
-	generic            *bzl.ListExpr
-	os, arch, platform *bzl.DictExpr
+	generic                       *bzl.ListExpr
+	os, arch, platform, condition *bzl.DictExpr
 }
 
 // extractPlatformStringsExprs matches an expression and attempts to extract
 // sub-expressions in platformStringsExprs. The sub-expressions can then be
 // merged with corresponding sub-expressions. Any field in the returned
 // structure may be nil. An error is returned if the given expression does
-// not follow the pattern described by platformStringsExprs.
-func extractPlatformStringsExprs(expr bzl.Expr) (platformStringsExprs, error) {
+// not follow the pattern described by platformStringsExprs. conditions is a
+// set of labels, other than platforms, that selects may be keyed by.
+func extractPlatformStringsExprs(expr bzl.Expr, conditions map[string]bool) (platformStringsExprs, error) {
 	var ps platformStringsExprs
 	if expr == nil {
 		return ps, nil
@@ -268,20 +271,24 @@ func extractPlatformStringsExprs(expr bzl.Expr) (platformStringsExprs, error) {
 				if k.Value == "//conditions:default" {
 					continue
 				}
+				if conditions[k.Value] {
+					dict = &ps.condition
+					break
+				}
 				key, err := label.Parse(k.Value)
 				if err != nil {
 					return platformStringsExprs{}, fmt.Errorf("expression could not be matched: dict key is not label: %q", k.Value)
 				}
-				if KnownOSSet[key.Name] {
+				if KnownOSSet[key.Name] || optionalOSSet[key.Name] {
 					dict = &ps.os
 					break
 				}
-				if KnownArchSet[key.Name] {
+				if KnownArchSet[key.Name] || optionalArchSet[key.Name] {
 					dict = &ps.arch
 					break
 				}
 				osArch := strings.Split(key.Name, "_")
-				if len(osArch) != 2 || !KnownOSSet[osArch[0]] || !KnownArchSet[osArch[1]] {
+				if len(osArch) != 2 || !(KnownOSSet[osArch[0]] || optionalOSSet[osArch[0]]) || !(KnownArchSet[osArch[1]] || optionalArchSet[osArch[1]]) {
 					return platformStringsExprs{}, fmt.Errorf("expression could not be matched: dict key contains unknown platform: %q", k.Value)
 				}
 				dict = &ps.platform
@@ -294,7 +301,7 @@ func extractPlatformStringsExprs(expr bzl.Expr) (platformStringsExprs, error) {
 				dict = &ps.platform
 			}
 			if *dict != nil {
-				return platformStringsExprs{}, fmt.Errorf("expression could not be matched: multiple selects that are either os-specific, arch-specific, or platform-specific")
+				return platformStringsExprs{}, fmt.Errorf("expression could not be matched: multiple selects that are either os-specific, arch-specific, platform-specific, or on the same condition")
 			}
 			*dict = arg
 		}
@@ -333,6 +340,9 @@ func makePlatformStringsExpr(ps platformStringsExprs) bzl.Expr {
 	if ps.platform != nil {
 		parts = append(parts, makeSelect(ps.platform))
 	}
+	if ps.condition != nil {
+		parts = append(parts, makeSelect(ps.condition))
+	}
 
 	if len(parts) == 0 {
 		return nil
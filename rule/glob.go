@@ -0,0 +1,186 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// FilesGlob returns a glob that matches exactly the files in files, when
+// evaluated in a directory containing dirFiles. dirFiles must list every
+// file in the directory, including files Gazelle ignores, since the glob
+// will match them too. Patterns match files by extension. Excluded files
+// are replaced with a single pattern when every file with a suffix starting
+// with "_", like "_test.go", is excluded.
+//
+// false is returned if a file is not in dirFiles (for example, a generated
+// file or a label) or is in a subdirectory.
+func FilesGlob(files, dirFiles []string) (GlobValue, bool) {
+	inDir := make(map[string]bool)
+	for _, f := range dirFiles {
+		inDir[f] = true
+	}
+	included := make(map[string]bool)
+	patternSet := make(map[string]bool)
+	for _, f := range files {
+		if !inDir[f] || strings.Contains(f, "/") {
+			return GlobValue{}, false
+		}
+		included[f] = true
+		if ext := path.Ext(f); ext != "" && ext != f {
+			patternSet["*"+ext] = true
+		} else {
+			patternSet[f] = true
+		}
+	}
+	if len(files) == 0 {
+		return GlobValue{}, false
+	}
+	patterns := sortedKeys(patternSet)
+	g := GlobValue{Patterns: patterns}
+
+	var excluded []string
+	for _, f := range dirFiles {
+		if !included[f] && g.matches(f) {
+			excluded = append(excluded, f)
+		}
+	}
+
+	// Replace excluded files that share a suffix with a pattern, if no
+	// included file has that suffix.
+	excludeSet := make(map[string]bool)
+	for _, f := range excluded {
+		suffix := ""
+		if i := strings.LastIndex(f, "_"); i > 0 {
+			suffix = f[i:]
+		}
+		if suffix == "" {
+			excludeSet[f] = true
+			continue
+		}
+		suffixPattern := "*" + suffix
+		ok := true
+		for _, inc := range files {
+			if m, _ := path.Match(suffixPattern, inc); m {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			excludeSet[suffixPattern] = true
+		} else {
+			excludeSet[f] = true
+		}
+	}
+	g.Excludes = sortedKeys(excludeSet)
+	return g, true
+}
+
+// Match returns the files in dirFiles matched by the glob. Patterns are
+// matched with path.Match, so recursive patterns like "**" are not
+// supported; see ParseGlobExpr.
+func (g GlobValue) Match(dirFiles []string) []string {
+	var files []string
+	for _, f := range dirFiles {
+		if g.matches(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (g GlobValue) matches(f string) bool {
+	for _, x := range g.Excludes {
+		if m, _ := path.Match(x, f); m {
+			return false
+		}
+	}
+	for _, p := range g.Patterns {
+		if m, _ := path.Match(p, f); m {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGlobExpr returns the GlobValue for e, if e is a call to glob with
+// literal lists of patterns and excludes. false is returned for other
+// expressions, and for globs with patterns that may match files in
+// subdirectories, which GlobValue.Match can't evaluate.
+func ParseGlobExpr(e bzl.Expr) (GlobValue, bool) {
+	call, ok := e.(*bzl.CallExpr)
+	if !ok {
+		return GlobValue{}, false
+	}
+	if x, ok := call.X.(*bzl.Ident); !ok || x.Name != "glob" {
+		if x, ok := call.X.(*bzl.LiteralExpr); !ok || x.Token != "glob" {
+			return GlobValue{}, false
+		}
+	}
+	var g GlobValue
+	for i, arg := range call.List {
+		var key string
+		value := arg
+		if a, ok := arg.(*bzl.AssignExpr); ok {
+			lhs, ok := a.LHS.(*bzl.Ident)
+			if !ok {
+				return GlobValue{}, false
+			}
+			key, value = lhs.Name, a.RHS
+		} else if i == 0 {
+			key = "include"
+		} else if i == 1 {
+			key = "exclude"
+		}
+		switch key {
+		case "include", "exclude":
+			list, ok := value.(*bzl.ListExpr)
+			if !ok {
+				return GlobValue{}, false
+			}
+			for _, elem := range list.List {
+				s, ok := elem.(*bzl.StringExpr)
+				if !ok || strings.Contains(s.Value, "/") || strings.Contains(s.Value, "**") {
+					return GlobValue{}, false
+				}
+				if key == "include" {
+					g.Patterns = append(g.Patterns, s.Value)
+				} else {
+					g.Excludes = append(g.Excludes, s.Value)
+				}
+			}
+		case "exclude_directories", "allow_empty":
+			// These don't affect which files in the directory match.
+		default:
+			return GlobValue{}, false
+		}
+	}
+	return g, len(g.Patterns) > 0
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestFilesGlob(t *testing.T) {
+	dirFiles := []string{"BUILD.bazel", "a.go", "b.go", "b_test.go", "c.go", "c_test.go", "gen.go", "x.s"}
+	for _, tc := range []struct {
+		desc   string
+		files  []string
+		want   GlobValue
+		wantOk bool
+	}{
+		{
+			desc:   "suffix excluded",
+			files:  []string{"a.go", "b.go", "c.go", "gen.go", "x.s"},
+			want:   GlobValue{Patterns: []string{"*.go", "*.s"}, Excludes: []string{"*_test.go"}},
+			wantOk: true,
+		}, {
+			desc:   "files excluded",
+			files:  []string{"a.go", "b.go", "b_test.go"},
+			want:   GlobValue{Patterns: []string{"*.go"}, Excludes: []string{"c.go", "c_test.go", "gen.go"}},
+			wantOk: true,
+		}, {
+			desc:  "generated file",
+			files: []string{"a.go", "missing.go"},
+		}, {
+			desc:  "subdirectory",
+			files: []string{"a.go", "sub/a.go"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok := FilesGlob(tc.files, dirFiles)
+			if ok != tc.wantOk {
+				t.Fatalf("got ok %v; want %v", ok, tc.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+			if match := got.Match(dirFiles); !reflect.DeepEqual(match, tc.files) {
+				t.Errorf("glob matches %q; want %q", match, tc.files)
+			}
+		})
+	}
+}
+
+func TestParseGlobExpr(t *testing.T) {
+	for _, tc := range []struct {
+		desc, src string
+		want      GlobValue
+		wantOk    bool
+	}{
+		{
+			desc:   "positional",
+			src:    `glob(["*.go"], ["*_test.go"])`,
+			want:   GlobValue{Patterns: []string{"*.go"}, Excludes: []string{"*_test.go"}},
+			wantOk: true,
+		}, {
+			desc:   "keywords",
+			src:    `glob(include = ["*.go"], exclude = ["a.go"], allow_empty = True)`,
+			want:   GlobValue{Patterns: []string{"*.go"}, Excludes: []string{"a.go"}},
+			wantOk: true,
+		}, {
+			desc: "recursive",
+			src:  `glob(["**/*.go"])`,
+		}, {
+			desc: "not a glob",
+			src:  `["a.go"]`,
+		}, {
+			desc: "variable",
+			src:  `glob(SRCS)`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			f, err := bzl.ParseBuild("BUILD", []byte("x = "+tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := f.Stmt[0].(*bzl.AssignExpr).RHS
+			got, ok := ParseGlobExpr(e)
+			if ok != tc.wantOk {
+				t.Fatalf("got ok %v; want %v", ok, tc.wantOk)
+			}
+			if ok && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGlobValueExpr(t *testing.T) {
+	e := ExprFromValue(GlobValue{Patterns: []string{"*.go"}, Excludes: []string{"*_test.go"}})
+	got := string(bzl.Format(&bzl.File{Stmt: []bzl.Expr{e}}))
+	want := "glob(\n    [\"*.go\"],\n    exclude = [\"*_test.go\"],\n)\n"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -24,6 +24,30 @@ import (
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
+// ConditionsKey is the name of a private attribute of generated rules. Its
+// value is a []string of labels of config_settings, other than the rules_go
+// platforms, that selects in the rule's attributes may be keyed by. When
+// MergeRules or SquashRules finds a select keyed by one of these labels in
+// an existing rule, it's merged like a platform select instead of being
+// reported as an expression that can't be merged.
+const ConditionsKey = "_gazelle_conditions"
+
+// conditions returns the set of labels listed in the ConditionsKey
+// attributes of rules.
+func conditions(rules ...*Rule) map[string]bool {
+	var m map[string]bool
+	for _, r := range rules {
+		labels, _ := r.PrivateAttr(ConditionsKey).([]string)
+		for _, l := range labels {
+			if m == nil {
+				m = make(map[string]bool)
+			}
+			m[l] = true
+		}
+	}
+	return m
+}
+
 // MergeRules copies information from src into dst, usually discarding
 // information in dst when they have the same attributes.
 //
@@ -46,13 +70,15 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 		return
 	}
 
+	conds := conditions(src)
+
 	// Process attributes that are in dst but not in src.
 	for key, dstAttr := range dst.attrs {
 		if _, ok := src.attrs[key]; ok || !mergeable[key] || ShouldKeep(dstAttr) {
 			continue
 		}
 		dstValue := dstAttr.RHS
-		if mergedValue, err := mergeExprs(nil, dstValue); err != nil {
+		if mergedValue, err := mergeExprs(nil, dstValue, conds); err != nil {
 			start, end := dstValue.Span()
 			log.Printf("%s:%d.%d-%d.%d: could not merge expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
 		} else if mergedValue == nil {
@@ -69,7 +95,7 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 			dst.SetAttr(key, srcValue)
 		} else if mergeable[key] && !ShouldKeep(dstAttr) {
 			dstValue := dstAttr.RHS
-			if mergedValue, err := mergeExprs(srcValue, dstValue); err != nil {
+			if mergedValue, err := mergeExprs(srcValue, dstValue, conds); err != nil {
 				start, end := dstValue.Span()
 				log.Printf("%s:%d.%d-%d.%d: could not merge expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
 			} else {
@@ -95,7 +121,7 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 //
 // An error is returned if the expressions can't be merged, for example
 // because they are not in one of the above formats.
-func mergeExprs(src, dst bzl.Expr) (bzl.Expr, error) {
+func mergeExprs(src, dst bzl.Expr, conditions map[string]bool) (bzl.Expr, error) {
 	if ShouldKeep(dst) {
 		return nil, nil
 	}
@@ -106,11 +132,11 @@ func mergeExprs(src, dst bzl.Expr) (bzl.Expr, error) {
 		return src, nil
 	}
 
-	srcExprs, err := extractPlatformStringsExprs(src)
+	srcExprs, err := extractPlatformStringsExprs(src, conditions)
 	if err != nil {
 		return nil, err
 	}
-	dstExprs, err := extractPlatformStringsExprs(dst)
+	dstExprs, err := extractPlatformStringsExprs(dst, conditions)
 	if err != nil {
 		return nil, err
 	}
@@ -134,6 +160,9 @@ func mergePlatformStringsExprs(src, dst platformStringsExprs) (platformStringsEx
 	if ps.platform, err = mergeDict(src.platform, dst.platform); err != nil {
 		return platformStringsExprs{}, err
 	}
+	if ps.condition, err = mergeDict(src.condition, dst.condition); err != nil {
+		return platformStringsExprs{}, err
+	}
 	return ps, nil
 }
 
@@ -274,13 +303,14 @@ func SquashRules(src, dst *Rule, filename string) error {
 		return nil
 	}
 
+	conds := conditions(src, dst)
 	for key, srcAttr := range src.attrs {
 		srcValue := srcAttr.RHS
 		if dstAttr, ok := dst.attrs[key]; !ok {
 			dst.SetAttr(key, srcValue)
 		} else if !ShouldKeep(dstAttr) {
 			dstValue := dstAttr.RHS
-			if squashedValue, err := squashExprs(srcValue, dstValue); err != nil {
+			if squashedValue, err := squashExprs(srcValue, dstValue, conds); err != nil {
 				start, end := dstValue.Span()
 				return fmt.Errorf("%s:%d.%d-%d.%d: could not squash expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
 			} else {
@@ -294,7 +324,7 @@ func SquashRules(src, dst *Rule, filename string) error {
 	return nil
 }
 
-func squashExprs(src, dst bzl.Expr) (bzl.Expr, error) {
+func squashExprs(src, dst bzl.Expr, conditions map[string]bool) (bzl.Expr, error) {
 	if ShouldKeep(dst) {
 		return dst, nil
 	}
@@ -302,11 +332,11 @@ func squashExprs(src, dst bzl.Expr) (bzl.Expr, error) {
 		// may lose src, but they should always be the same.
 		return dst, nil
 	}
-	srcExprs, err := extractPlatformStringsExprs(src)
+	srcExprs, err := extractPlatformStringsExprs(src, conditions)
 	if err != nil {
 		return nil, err
 	}
-	dstExprs, err := extractPlatformStringsExprs(dst)
+	dstExprs, err := extractPlatformStringsExprs(dst, conditions)
 	if err != nil {
 		return nil, err
 	}
@@ -332,6 +362,9 @@ func squashPlatformStringsExprs(x, y platformStringsExprs) (platformStringsExprs
 	if ps.platform, err = squashDict(x.platform, y.platform); err != nil {
 		return platformStringsExprs{}, err
 	}
+	if ps.condition, err = squashDict(x.condition, y.condition); err != nil {
+		return platformStringsExprs{}, err
+	}
 	return ps, nil
 }
 
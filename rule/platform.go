@@ -101,6 +101,16 @@ var KnownPlatforms = []Platform{
 	{"windows", "arm"},
 }
 
+// OptionalPlatforms is the set of target platforms that Go supports, but
+// that older versions of rules_go don't have config_settings for. They
+// aren't in KnownPlatforms, so Gazelle doesn't generate select() arms for
+// them unless asked to, but they're recognized in existing build files.
+//
+// DEPRECATED: do not use outside language/go.
+var OptionalPlatforms = []Platform{
+	{"wasip1", "wasm"},
+}
+
 var OSAliases = map[string][]string{
 	"android": []string{"linux"},
 	"ios":     []string{"darwin"},
@@ -147,4 +157,15 @@ func init() {
 	}
 	sort.Strings(KnownOSs)
 	sort.Strings(KnownArchs)
+
+	optionalOSSet = make(map[string]bool)
+	optionalArchSet = make(map[string]bool)
+	for _, p := range OptionalPlatforms {
+		optionalOSSet[p.OS] = true
+		optionalArchSet[p.Arch] = true
+	}
 }
+
+// optionalOSSet and optionalArchSet are the operating systems and
+// architectures in OptionalPlatforms.
+var optionalOSSet, optionalArchSet map[string]bool
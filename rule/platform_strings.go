@@ -49,6 +49,13 @@ type PlatformStrings struct {
 
 	// Platform is a map from platforms to OS and architecture-specific strings.
 	Platform map[Platform][]string
+
+	// CgoSetting is the label of a config_setting that matches when cgo is
+	// enabled. When it's set, Cgo is a list of strings only used when cgo is
+	// enabled, and Pure is a list of strings only used when it's disabled.
+	// These are written in a select on CgoSetting.
+	CgoSetting string
+	Cgo, Pure  []string
 }
 
 // HasExt returns whether this set contains a file with the given extension.
@@ -57,7 +64,7 @@ func (ps *PlatformStrings) HasExt(ext string) bool {
 }
 
 func (ps *PlatformStrings) IsEmpty() bool {
-	return len(ps.Generic) == 0 && len(ps.OS) == 0 && len(ps.Arch) == 0 && len(ps.Platform) == 0
+	return len(ps.Generic) == 0 && len(ps.OS) == 0 && len(ps.Arch) == 0 && len(ps.Platform) == 0 && len(ps.Cgo) == 0 && len(ps.Pure) == 0
 }
 
 // Flat returns all the strings in the set, sorted and de-duplicated.
@@ -81,6 +88,11 @@ func (ps *PlatformStrings) Flat() []string {
 			unique[s] = struct{}{}
 		}
 	}
+	for _, ss := range [][]string{ps.Cgo, ps.Pure} {
+		for _, s := range ss {
+			unique[s] = struct{}{}
+		}
+	}
 	flat := make([]string, 0, len(unique))
 	for s := range unique {
 		flat = append(flat, s)
@@ -116,6 +128,13 @@ func (ps *PlatformStrings) firstExtFile(ext string) string {
 			}
 		}
 	}
+	for _, fs := range [][]string{ps.Cgo, ps.Pure} {
+		for _, f := range fs {
+			if strings.HasSuffix(f, ext) {
+				return f
+			}
+		}
+	}
 	return ""
 }
 
@@ -193,6 +212,11 @@ func (ps *PlatformStrings) MapSlice(f func([]string) ([]string, error)) (Platfor
 		Arch:     mapStringMap(ps.Arch),
 		Platform: mapPlatformMap(ps.Platform),
 	}
+	if ps.CgoSetting != "" {
+		result.CgoSetting = ps.CgoSetting
+		result.Cgo = mapSlice(ps.Cgo)
+		result.Pure = mapSlice(ps.Pure)
+	}
 	return result, errors
 }
 
@@ -210,6 +234,13 @@ func (ps PlatformStrings) BzlExpr() bzl.Expr {
 	if len(ps.Platform) > 0 {
 		pieces = append(pieces, platformStringsPlatformDictExpr(ps.Platform))
 	}
+	if ps.CgoSetting != "" && (len(ps.Cgo) > 0 || len(ps.Pure) > 0) {
+		s := SelectStringListValue{
+			ps.CgoSetting:          ps.Cgo,
+			"//conditions:default": ps.Pure,
+		}
+		pieces = append(pieces, s.BzlExpr())
+	}
 	if len(pieces) == 0 {
 		return &bzl.ListExpr{}
 	} else if len(pieces) == 1 {
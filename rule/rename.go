@@ -0,0 +1,86 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rule
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/label"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// RenameRule changes the name of r, a rule declared in f, to newName. Labels
+// that refer to r in attributes of rules in files (for example, in deps,
+// embed, or data) are rewritten to refer to the new name. f should normally be
+// included in files so that references within the same package are updated.
+//
+// repo is the name of the repository that contains f. Labels that name
+// another repository are not changed. Rules and values marked with "# keep"
+// comments are not changed either.
+//
+// RenameRule returns the number of labels that were rewritten.
+func RenameRule(repo string, f *File, r *Rule, newName string, files []*File) int {
+	oldLabel := label.New(repo, f.Pkg, r.Name())
+	r.SetName(newName)
+	if oldLabel.Name == newName {
+		return 0
+	}
+
+	n := 0
+	for _, g := range files {
+		for _, other := range g.Rules {
+			if other.ShouldKeep() {
+				continue
+			}
+			for key, attr := range other.attrs {
+				if key == "name" || ShouldKeep(attr) {
+					continue
+				}
+				n += renameLabelsInExpr(attr.RHS, repo, g.Pkg, oldLabel, newName)
+			}
+		}
+	}
+	return n
+}
+
+// renameLabelsInExpr rewrites strings within e that are labels referring to
+// oldLabel, when evaluated in the package pkg. The strings are modified in
+// place. The number of rewritten strings is returned.
+func renameLabelsInExpr(e bzl.Expr, repo, pkg string, oldLabel label.Label, newName string) int {
+	if e == nil || ShouldKeep(e) {
+		return 0
+	}
+	n := 0
+	bzl.Walk(e, func(x bzl.Expr, stk []bzl.Expr) {
+		s, ok := x.(*bzl.StringExpr)
+		if !ok || ShouldKeep(s) {
+			return
+		}
+		l, err := label.Parse(s.Value)
+		if err != nil {
+			return
+		}
+		abs := l.Abs(repo, pkg)
+		if abs.Repo == "" {
+			abs.Repo = repo
+		}
+		if abs.Repo != oldLabel.Repo || abs.Pkg != oldLabel.Pkg || abs.Name != oldLabel.Name {
+			return
+		}
+		l.Name = newName
+		s.Value = l.String()
+		n++
+	})
+	return n
+}
@@ -40,6 +40,13 @@ import (
 // File provides editing functionality for a build file. You can create a
 // new file with EmptyFile or load an existing file with LoadFile. After
 // changes have been made, call Save to write changes back to a file.
+//
+// A File is not safe for concurrent modification. Once a File has been
+// synced (with Sync, Format, or Save) after its last change, it may be read
+// from multiple goroutines at once: reading its fields, reading its rules
+// and loads with their methods (like Kind, Name, Attr, and AttrStrings),
+// and calling Sync, Format, and Save don't modify a synced File. Files
+// returned by LoadFile and similar functions are already synced.
 type File struct {
 	// File is the underlying build file syntax tree. Some editing operations
 	// may modify this, but editing is not complete until Sync() is called.
@@ -270,7 +277,13 @@ func (f *File) MacroName() string {
 
 // Sync writes all changes back to the wrapped syntax tree. This should be
 // called after editing operations, before reading the syntax tree again.
+// Sync does nothing if there are no changes, so it may be called on a synced
+// File from multiple goroutines.
 func (f *File) Sync() {
+	if f.isSynced() {
+		return
+	}
+
 	var loadInserts, loadDeletes, loadStmts []*stmt
 	var r, w int
 	for r, w = 0, 0; r < len(f.Loads); r++ {
@@ -332,6 +345,30 @@ func (f *File) Sync() {
 	}
 }
 
+// isSynced returns whether f has no changes that need to be written back to
+// the syntax tree. This includes statements inserted or removed directly
+// in the syntax tree, which move other statements. isSynced only reads f.
+func (f *File) isSynced() bool {
+	ruleStmts := f.File.Stmt
+	if f.function != nil {
+		if !f.function.inserted {
+			return false
+		}
+		ruleStmts = f.function.stmt.Body
+	}
+	for _, l := range f.Loads {
+		if !l.isSynced(f.File.Stmt) {
+			return false
+		}
+	}
+	for _, r := range f.Rules {
+		if !r.isSynced(ruleStmts) {
+			return false
+		}
+	}
+	return true
+}
+
 func updateStmt(oldStmt *[]bzl.Expr, inserts, deletes, stmts []*stmt) {
 	sort.Stable(byIndex(deletes))
 	sort.Stable(byIndex(inserts))
@@ -404,6 +441,12 @@ func (s *stmt) Index() int { return s.index }
 // syntax tree when File.Sync is called.
 func (s *stmt) Delete() { s.deleted = true }
 
+// isSynced returns whether the statement has no pending changes and is
+// still at its index in stmts.
+func (s *stmt) isSynced(stmts []bzl.Expr) bool {
+	return !s.deleted && !s.inserted && !s.updated && s.index < len(stmts) && stmts[s.index] == s.expr
+}
+
 type byIndex []*stmt
 
 func (s byIndex) Len() int {
@@ -616,6 +659,21 @@ func (r *Rule) ShouldKeep() bool {
 	return ShouldKeep(r.expr)
 }
 
+// ShouldKeepAttr returns whether the named attribute or its value is marked
+// with a "# keep" comment.
+func (r *Rule) ShouldKeepAttr(key string) bool {
+	attr, ok := r.attrs[key]
+	return ok && (ShouldKeep(attr) || ShouldKeep(attr.RHS))
+}
+
+// AddComment adds a comment line above the rule. token should include the
+// leading "#". For example, r.AddComment("# keep") marks the rule so Gazelle
+// won't modify it.
+func (r *Rule) AddComment(token string) {
+	com := r.expr.Comment()
+	com.Before = append(com.Before, bzl.Comment{Token: token})
+}
+
 // Kind returns the kind of rule this is (for example, "go_library").
 func (r *Rule) Kind() string {
 	return r.kind
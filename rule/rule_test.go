@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	bzl "github.com/bazelbuild/buildtools/build"
@@ -178,6 +179,77 @@ x_library(name = "bar")
 	}
 }
 
+func TestSyncAfterDirectEdit(t *testing.T) {
+	f, err := LoadData(filepath.Join("old", "BUILD.bazel"), "", []byte(`
+x_library(name = "foo")
+
+x_library(name = "bar")
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Statements added directly to the syntax tree move the rules, so Sync
+	// must still update their indices, even though no rules changed.
+	cb := &bzl.CommentBlock{Comments: bzl.Comments{After: []bzl.Comment{{Token: "# top"}}}}
+	f.File.Stmt = append([]bzl.Expr{cb}, f.File.Stmt...)
+	f.Sync()
+	f.Rules[1].Delete()
+	baz := NewRule("x_library", "baz")
+	baz.Insert(f)
+	got := strings.TrimSpace(string(f.Format()))
+	want := strings.TrimSpace(`
+# top
+
+x_library(name = "foo")
+
+x_library(name = "baz")
+`)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConcurrentReadSyncedFile(t *testing.T) {
+	f, err := LoadData(filepath.Join("old", "BUILD.bazel"), "", []byte(`
+load("a.bzl", "x_library")
+
+x_library(name = "foo")
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Rules[0].SetAttr("srcs", []string{"foo.go"})
+	NewRule("x_library", "bar").Insert(f)
+	want := string(f.Format())
+
+	// The file is synced, so reads, including Format, don't modify it. This
+	// is checked by the race detector when enabled.
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := string(f.Format()); got != want {
+				errs <- got
+			}
+			for _, r := range f.Rules {
+				_ = r.Kind()
+				_ = r.AttrStrings("srcs")
+			}
+			for _, l := range f.Loads {
+				_ = l.Symbols()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for got := range errs {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestSymbolsReturnsKeys(t *testing.T) {
 	f, err := LoadData(filepath.Join("load", "BUILD.bazel"), "", []byte(`load("a.bzl", "y", z = "a")`))
 	if err != nil {
@@ -346,3 +418,108 @@ func TestShouldKeepExpr(t *testing.T) {
 		})
 	}
 }
+
+func TestShouldKeepAttr(t *testing.T) {
+	f, err := LoadData("BUILD.bazel", "", []byte(`
+x_library(
+    name = "x",
+    a = "a",  # keep
+    # keep
+    b = "b",
+    c = [
+        "c",
+    ],  # keep
+    d = "d",
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := f.Rules[0]
+	for key, want := range map[string]bool{"a": true, "b": true, "c": true, "d": false, "e": false} {
+		if got := r.ShouldKeepAttr(key); got != want {
+			t.Errorf("%s: got %v; want %v", key, got, want)
+		}
+	}
+}
+
+func TestRenameRule(t *testing.T) {
+	lib, err := LoadData(filepath.Join("lib", "BUILD.bazel"), "lib", []byte(`
+go_library(
+    name = "old",
+    srcs = ["old.go"],
+)
+
+go_test(
+    name = "old_test",
+    embed = [":old"],
+    data = select({
+        "//conditions:default": ["old"],
+    }),
+)
+
+go_binary(
+    name = "keep",
+    embed = [":old"],  # keep
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := LoadData(filepath.Join("bin", "BUILD.bazel"), "bin", []byte(`
+go_binary(
+    name = "bin",
+    deps = [
+        "//lib:old",
+        "@my_repo//lib:old",
+        "@other//lib:old",
+        "//other:old",
+    ],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := RenameRule("my_repo", lib, lib.Rules[0], "new", []*File{lib, bin})
+	if n != 4 {
+		t.Errorf("got %d rewritten labels; want 4", n)
+	}
+
+	wantLib := strings.TrimSpace(`
+go_library(
+    name = "new",
+    srcs = ["old.go"],
+)
+
+go_test(
+    name = "old_test",
+    embed = [":new"],
+    data = select({
+        "//conditions:default": [":new"],
+    }),
+)
+
+go_binary(
+    name = "keep",
+    embed = [":old"],  # keep
+)
+`)
+	if got := strings.TrimSpace(string(lib.Format())); got != wantLib {
+		t.Errorf("lib: got:\n%s\nwant:\n%s", got, wantLib)
+	}
+	wantBin := strings.TrimSpace(`
+go_binary(
+    name = "bin",
+    deps = [
+        "//lib:new",
+        "@my_repo//lib:new",
+        "@other//lib:old",
+        "//other:old",
+    ],
+)
+`)
+	if got := strings.TrimSpace(string(bin.Format())); got != wantBin {
+		t.Errorf("bin: got:\n%s\nwant:\n%s", got, wantBin)
+	}
+}
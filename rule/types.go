@@ -53,4 +53,12 @@ type KindInfo struct {
 	// ResolveAttrs is a set of attributes that should be merged after
 	// dependency resolution. See rule.Merge.
 	ResolveAttrs map[string]bool
+
+	// ReplacesKinds is a list of kinds that rules of this kind may replace.
+	// A generated rule may be matched with an existing rule of one of these
+	// kinds, by name or by MatchAttrs. The existing rule's kind is changed
+	// before merging, so attributes that aren't mergeable and comments are
+	// kept. For example, when a kind is mapped with # gazelle:map_kind,
+	// rules of the mapped kind replace rules of the original kind.
+	ReplacesKinds []string
 }
@@ -0,0 +1,224 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// LanguageKinds holds the rule kinds and load statements provided by a set
+// of languages, with conflicts between languages resolved.
+type LanguageKinds struct {
+	// Kinds maps kind names to information about them.
+	Kinds map[string]rule.KindInfo
+
+	// Owners maps kind names to the languages that provide them.
+	Owners map[string]language.Language
+
+	// Loads is a list of load statements, starting with DefaultLoads. Each
+	// file appears once.
+	Loads []rule.LoadInfo
+}
+
+// CollectKinds gathers the kinds and loads provided by langs, for example,
+// the languages compiled into a gazelle_binary.
+//
+// Languages may provide the same kind with the same KindInfo, or load the
+// same symbol from the same file; these are merged. It's an error for
+// languages to provide a kind with different KindInfo values, or to load a
+// symbol from different files, unless owners names the language that
+// should win. owners maps kind and symbol names to language names (see
+// config.Config.KindOwners). The returned error lists every conflict and
+// the languages involved.
+func CollectKinds(langs []language.Language, owners map[string]string) (*LanguageKinds, error) {
+	var errs []string
+
+	kindProviders := make(map[string][]language.Language)
+	for _, lang := range langs {
+		for kind := range lang.Kinds() {
+			kindProviders[kind] = append(kindProviders[kind], lang)
+		}
+	}
+	lk := &LanguageKinds{
+		Kinds:  make(map[string]rule.KindInfo),
+		Owners: make(map[string]language.Language),
+	}
+	for _, kind := range sortedKeys(kindProviders) {
+		providers := kindProviders[kind]
+		same := func(i int) bool {
+			return reflect.DeepEqual(providers[i].Kinds()[kind], providers[0].Kinds()[kind])
+		}
+		i, err := chooseOwner("kind", kind, providers, owners, same)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		lk.Owners[kind] = providers[i]
+		lk.Kinds[kind] = providers[i].Kinds()[kind]
+	}
+
+	// Find the file each language loads each symbol from. If a language
+	// lists a symbol in more than one file, the last one is used, as in
+	// merger.FixLoads.
+	symbolProviders := make(map[string][]language.Language)
+	symbolProviderIndex := make(map[string][]int)
+	symbolProviderFiles := make(map[string][]string)
+	for li, lang := range langs {
+		files := make(map[string]string)
+		var syms []string
+		for _, load := range lang.Loads() {
+			for _, sym := range load.Symbols {
+				if _, ok := files[sym]; !ok {
+					syms = append(syms, sym)
+				}
+				files[sym] = load.Name
+			}
+		}
+		for _, sym := range syms {
+			symbolProviders[sym] = append(symbolProviders[sym], lang)
+			symbolProviderIndex[sym] = append(symbolProviderIndex[sym], li)
+			symbolProviderFiles[sym] = append(symbolProviderFiles[sym], files[sym])
+		}
+	}
+	// dropped records symbols that languages shouldn't load because another
+	// language was chosen to provide them.
+	dropped := make(map[int]map[string]bool)
+	for _, sym := range sortedKeys(symbolProviders) {
+		files := symbolProviderFiles[sym]
+		same := func(i int) bool { return files[i] == files[0] }
+		i, err := chooseOwner("load symbol", sym, symbolProviders[sym], owners, same)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for j, li := range symbolProviderIndex[sym] {
+			if files[j] != files[i] {
+				if dropped[li] == nil {
+					dropped[li] = make(map[string]bool)
+				}
+				dropped[li][sym] = true
+			}
+		}
+	}
+
+	for name := range owners {
+		if _, ok := kindProviders[name]; ok {
+			continue
+		}
+		if _, ok := symbolProviders[name]; ok {
+			continue
+		}
+		errs = append(errs, fmt.Sprintf("-kind_owner %s: no language provides a kind or load symbol named %q", name, name))
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	// Merge loads of the same file, leaving out symbols languages shouldn't
+	// load.
+	loadIndex := make(map[string]int)
+	addLoad := func(load rule.LoadInfo, keep func(string) bool) {
+		i, ok := loadIndex[load.Name]
+		if !ok {
+			i = len(lk.Loads)
+			loadIndex[load.Name] = i
+			lk.Loads = append(lk.Loads, rule.LoadInfo{Name: load.Name})
+		}
+		merged := &lk.Loads[i]
+		for _, sym := range load.Symbols {
+			if keep(sym) && !containsString(merged.Symbols, sym) {
+				merged.Symbols = append(merged.Symbols, sym)
+			}
+		}
+		for _, after := range load.After {
+			if !containsString(merged.After, after) {
+				merged.After = append(merged.After, after)
+			}
+		}
+	}
+	for _, load := range DefaultLoads {
+		addLoad(load, func(string) bool { return true })
+	}
+	for li, lang := range langs {
+		for _, load := range lang.Loads() {
+			addLoad(load, func(sym string) bool { return !dropped[li][sym] })
+		}
+	}
+	loads := lk.Loads[:0]
+	for _, load := range lk.Loads {
+		if len(load.Symbols) > 0 {
+			loads = append(loads, load)
+		}
+	}
+	lk.Loads = loads
+	return lk, nil
+}
+
+// chooseOwner returns the index of the language in providers that should
+// provide a kind or load symbol. If there is more than one provider and
+// owners names a language for it, that language is chosen. Otherwise, the
+// first provider is chosen if all providers are the same, according to
+// same. An error is returned if providers conflict.
+func chooseOwner(what, name string, providers []language.Language, owners map[string]string, same func(int) bool) (int, error) {
+	if ownerName, ok := owners[name]; ok && len(providers) > 1 {
+		for i, lang := range providers {
+			if lang.Name() == ownerName {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("-kind_owner %s=%s: %s %q is not provided by language %q; it's provided by %s", name, ownerName, what, name, ownerName, languageNames(providers))
+	}
+	for i := 1; i < len(providers); i++ {
+		if !same(i) {
+			return -1, fmt.Errorf("%s %q is provided differently by languages %s; choose one with -kind_owner=%s=<lang>", what, name, languageNames(providers), name)
+		}
+	}
+	return 0, nil
+}
+
+func languageNames(langs []language.Language) string {
+	names := make([]string, len(langs))
+	for i, lang := range langs {
+		names[i] = lang.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+func sortedKeys(m map[string][]language.Language) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(strs []string, s string) bool {
+	for _, t := range strs {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
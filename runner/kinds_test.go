@@ -0,0 +1,120 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// kindsLang is a language that only provides kinds and loads. Other methods
+// panic if called.
+type kindsLang struct {
+	language.Language
+	name  string
+	kinds map[string]rule.KindInfo
+	loads []rule.LoadInfo
+}
+
+func (l *kindsLang) Name() string                    { return l.name }
+func (l *kindsLang) Kinds() map[string]rule.KindInfo { return l.kinds }
+func (l *kindsLang) Loads() []rule.LoadInfo          { return l.loads }
+
+func TestCollectKinds(t *testing.T) {
+	libInfo := rule.KindInfo{NonEmptyAttrs: map[string]bool{"srcs": true}}
+	otherInfo := rule.KindInfo{NonEmptyAttrs: map[string]bool{"deps": true}}
+	a := &kindsLang{
+		name: "a",
+		kinds: map[string]rule.KindInfo{
+			"a_library": libInfo,
+			"shared":    libInfo,
+			"clash":     libInfo,
+		},
+		loads: []rule.LoadInfo{
+			{Name: "@a//:def.bzl", Symbols: []string{"a_library", "clash"}},
+			{Name: "@shared//:def.bzl", Symbols: []string{"shared"}},
+		},
+	}
+	b := &kindsLang{
+		name: "b",
+		kinds: map[string]rule.KindInfo{
+			"shared": libInfo,
+			"clash":  otherInfo,
+		},
+		loads: []rule.LoadInfo{
+			{Name: "@shared//:def.bzl", Symbols: []string{"shared"}},
+			{Name: "@b//:def.bzl", Symbols: []string{"clash"}},
+		},
+	}
+	langs := []language.Language{a, b}
+
+	t.Run("conflict", func(t *testing.T) {
+		_, err := CollectKinds(langs, nil)
+		if err == nil {
+			t.Fatal("got success; want error")
+		}
+		for _, want := range []string{
+			`kind "clash" is provided differently by languages a, b`,
+			`load symbol "clash" is provided differently by languages a, b`,
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("got error:\n%v\nwant it to contain %q", err, want)
+			}
+		}
+		if strings.Contains(err.Error(), `"shared"`) {
+			t.Errorf("got error:\n%v\nwant no conflict for identical kind \"shared\"", err)
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		lk, err := CollectKinds(langs, map[string]string{"clash": "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lk.Owners["clash"]; got != b {
+			t.Errorf("got owner %q for clash; want b", got.Name())
+		}
+		if !reflect.DeepEqual(lk.Kinds["clash"], otherInfo) {
+			t.Errorf("got KindInfo %#v for clash; want %#v", lk.Kinds["clash"], otherInfo)
+		}
+		wantLoads := append(append([]rule.LoadInfo{}, DefaultLoads...),
+			rule.LoadInfo{Name: "@a//:def.bzl", Symbols: []string{"a_library"}},
+			rule.LoadInfo{Name: "@shared//:def.bzl", Symbols: []string{"shared"}},
+			rule.LoadInfo{Name: "@b//:def.bzl", Symbols: []string{"clash"}})
+		if !reflect.DeepEqual(lk.Loads, wantLoads) {
+			t.Errorf("got loads %#v; want %#v", lk.Loads, wantLoads)
+		}
+	})
+
+	t.Run("bad_owner", func(t *testing.T) {
+		_, err := CollectKinds(langs, map[string]string{"clash": "c", "missing": "a"})
+		if err == nil {
+			t.Fatal("got success; want error")
+		}
+		for _, want := range []string{
+			`kind "clash" is not provided by language "c"`,
+			`no language provides a kind or load symbol named "missing"`,
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("got error:\n%v\nwant it to contain %q", err, want)
+			}
+		}
+	})
+}
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package runner
 
 import (
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -48,6 +48,12 @@ func (mr *metaResolver) MappedKind(pkgRel string, kind config.MappedKind) {
 	mr.mappedKinds[pkgRel] = append(mr.mappedKinds[pkgRel], kind)
 }
 
+// ClearMappedKinds forgets mappings recorded for the given package, before
+// the package is processed again.
+func (mr *metaResolver) ClearMappedKinds(pkgRel string) {
+	delete(mr.mappedKinds, pkgRel)
+}
+
 // Resolver returns a resolver for the given rule and package, and a bool
 // indicating whether one was found. Empty string may be passed for pkgRel,
 // which results in consulting the builtin kinds only.
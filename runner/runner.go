@@ -0,0 +1,603 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runner provides Gazelle's build file generation pipeline as a
+// library. Programs that embed Gazelle can use it to build a configuration,
+// generate and update build files, and write them out without running the
+// gazelle binary and parsing its output.
+//
+// A typical caller builds a configuration with NewConfig, calls Update,
+// then writes the returned files with WriteFile. The "update" and "fix"
+// commands of the gazelle binary are implemented the same way.
+//
+// This API is EXPERIMENTAL and may change.
+package runner
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// Options contains the inputs to Update.
+type Options struct {
+	// Config is the root configuration, usually built with NewConfig.
+	Config *config.Config
+
+	// Configurers is the list of configuration extensions used to build
+	// Config. It must include Languages. walk.Walk calls each Configurer's
+	// Configure method in every directory.
+	Configurers []config.Configurer
+
+	// Languages is the list of language extensions that generate rules.
+	Languages []language.Language
+
+	// Dirs is a list of absolute paths to directories to update. If empty,
+	// the repository root is updated.
+	Dirs []string
+
+	// Mode determines which directories are visited and updated. The
+	// default is walk.VisitAllUpdateSubdirsMode.
+	Mode walk.Mode
+
+	// Repos is a list of known repositories, used to resolve external
+	// dependencies without accessing the network.
+	Repos []repo.Repo
+
+	// FS, if set, is the file system directories, build files, and source
+	// files are read from. See walk.FS. If nil, the file system set in the
+	// configuration is used.
+	FS walk.FS
+
+	// Index, if set, is a dependency resolution index kept between calls to
+	// Update in a long-running process. Rules in packages visited by Update
+	// replace the ones recorded earlier for those packages; rules in other
+	// packages are kept. This lets a process that watches for changes update
+	// a few directories at a time (for example, with walk.UpdateDirsMode)
+	// without reading every build file again. The same Languages must be
+	// used with an Index each time.
+	Index *Index
+
+	// ConfirmDelete, if set, is called before an existing rule is deleted
+	// because it became empty. If it returns false, the rule is kept.
+	ConfirmDelete func(c *config.Config, f *rule.File, r *rule.Rule) bool
+
+	// ReportProgress, if set, is called as Update visits each directory in
+	// each phase. It's called on the goroutine running Update and should
+	// return quickly.
+	ReportProgress func(p Progress)
+}
+
+// Phases of Update reported in Progress.
+const (
+	// PhaseWalk is the phase where directories are visited, and rules are
+	// generated and merged into build files.
+	PhaseWalk = "walk"
+
+	// PhaseResolve is the phase where dependencies of generated rules are
+	// resolved.
+	PhaseResolve = "resolve"
+)
+
+// Progress describes how far Update has gotten. See Options.ReportProgress.
+type Progress struct {
+	// Phase is PhaseWalk or PhaseResolve. Callers may define their own
+	// phases for work done after Update.
+	Phase string
+
+	// Done is the number of directories processed so far in this phase.
+	Done int
+
+	// Total is the number of directories that will be processed in this
+	// phase, or 0 if it's not known. The number of directories visited
+	// isn't known until the walk is finished.
+	Total int
+}
+
+// UpdatedFile is a build file created or updated by Update.
+type UpdatedFile struct {
+	// Config is the configuration for the directory containing the file.
+	Config *config.Config
+
+	// File is the updated build file. It has not been written.
+	File *rule.File
+
+	// Frozen indicates the file is in a directory frozen with
+	// # gazelle:frozen. It should not be written; any difference from the
+	// file on disk should be reported instead.
+	Frozen bool
+}
+
+// DefaultLoads are load statements Gazelle knows about regardless of which
+// languages are enabled.
+var DefaultLoads = []rule.LoadInfo{
+	{
+		Name:    "@bazel_gazelle//:def.bzl",
+		Symbols: []string{"gazelle"},
+	},
+}
+
+// DefaultConfigurers returns the configuration extensions that are not
+// tied to a language, in the order Gazelle uses them.
+func DefaultConfigurers() []config.Configurer {
+	return []config.Configurer{
+		&config.CommonConfigurer{},
+		&walk.Configurer{},
+		&resolve.Configurer{},
+	}
+}
+
+// NewConfig builds a root configuration from command-line style arguments,
+// for example "-go_prefix=example.com/repo" or "-repo_root=/path/to/repo".
+// cmd is the command flags are registered for ("update" or "fix"). cexts
+// should include DefaultConfigurers and the languages that will be run.
+// Positional arguments are returned.
+func NewConfig(cmd string, args []string, cexts []config.Configurer) (*config.Config, []string, error) {
+	c := config.New()
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	for _, cext := range cexts {
+		cext.RegisterFlags(fs, cmd, c)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	for _, cext := range cexts {
+		if err := cext.CheckFlags(fs, c); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, fs.Args(), nil
+}
+
+// Index is a dependency resolution index that may be shared by several calls
+// to Update. See Options.Index. The zero value is not usable; create one
+// with NewIndex.
+type Index struct {
+	mrslv *metaResolver
+	ix    *resolve.RuleIndex
+
+	// buildFiles maps packages indexed by earlier calls to Update to the
+	// paths of their build files. Packages whose build files are deleted are
+	// removed from the index, even if they aren't visited again.
+	buildFiles map[string]string
+}
+
+// NewIndex returns an empty Index.
+func NewIndex() *Index {
+	return &Index{
+		mrslv:      newMetaResolver(),
+		buildFiles: make(map[string]string),
+	}
+}
+
+// visitRecord stores information about about a directory visited with
+// walk.Walk.
+type visitRecord struct {
+	// pkgRel is the slash-separated path to the visited directory, relative to
+	// the repository root. "" for the repository root itself.
+	pkgRel string
+
+	// c is the configuration for the directory with directives applied.
+	c *config.Config
+
+	// rules is a list of generated Go rules.
+	rules []*rule.Rule
+
+	// imports contains opaque import information for each rule in rules.
+	imports []interface{}
+
+	// empty is a list of empty Go rules that may be deleted.
+	empty []*rule.Rule
+
+	// dir is the absolute path to the visited directory.
+	dir string
+
+	// file is the build file being processed.
+	file *rule.File
+
+	// frozen indicates file is a copy of a build file in a frozen directory.
+	frozen bool
+
+	// mappedKinds are mapped kinds used during this visit.
+	mappedKinds    []config.MappedKind
+	mappedKindInfo map[string]rule.KindInfo
+}
+
+// Update generates rules in the directories named by opts, merges them into
+// existing build files, resolves dependencies, and fixes load statements.
+// Updated files are returned in the order they were visited; they are not
+// written.
+func Update(opts Options) (files []UpdatedFile, err error) {
+	c := opts.Config
+	index := opts.Index
+	if index == nil {
+		index = NewIndex()
+	}
+	if index.ix == nil {
+		// Languages may resolve imports for each other, so the rule index is
+		// created when the languages are known.
+		exts := make([]interface{}, len(opts.Languages))
+		for i, lang := range opts.Languages {
+			exts[i] = lang
+		}
+		index.ix = resolve.NewRuleIndex(index.mrslv.Resolver, exts...)
+	}
+	mrslv, ruleIndex := index.mrslv, index.ix
+	lk, err := CollectKinds(opts.Languages, c.KindOwners)
+	if err != nil {
+		return nil, err
+	}
+	kinds, loads := lk.Kinds, lk.Loads
+	for kind, lang := range lk.Owners {
+		mrslv.AddBuiltin(kind, lang)
+	}
+
+	dirs := opts.Dirs
+	if len(dirs) == 0 {
+		dirs = []string{c.RepoRoot}
+	}
+	if opts.FS != nil {
+		walk.SetFS(c, opts.FS)
+	}
+	fsys := walk.GetFS(c)
+
+	// Forget packages indexed by an earlier call to Update whose build files
+	// have been deleted since.
+	for rel, path := range index.buildFiles {
+		if _, err := fsys.Stat(path); os.IsNotExist(err) {
+			ruleIndex.RemovePackage(rel)
+			mrslv.ClearMappedKinds(rel)
+			delete(index.buildFiles, rel)
+		}
+	}
+
+	report := opts.ReportProgress
+	if report == nil {
+		report = func(Progress) {}
+	}
+
+	// Visit all directories in the repository.
+	var visits []visitRecord
+	walked := 0
+	report(Progress{Phase: PhaseWalk})
+	walk.Walk(c, opts.Configurers, dirs, opts.Mode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+		defer func() {
+			walked++
+			report(Progress{Phase: PhaseWalk, Done: walked})
+		}()
+
+		// Forget anything indexed for this package by an earlier call to
+		// Update. The build file may have changed.
+		ruleIndex.RemovePackage(rel)
+		mrslv.ClearMappedKinds(rel)
+		delete(index.buildFiles, rel)
+
+		// Expand srcs globs written for large packages, so languages see the
+		// files they match when indexing and merging.
+		if f != nil && c.SrcsGlobThreshold > 0 {
+			expandSrcsGlobs(fsys, dir, f, kinds)
+		}
+
+		// If this file is ignored or if Gazelle was not asked to update this
+		// directory, just index the build file and move on.
+		if !update {
+			if c.IndexLibraries && f != nil {
+				for _, r := range f.Rules {
+					ruleIndex.AddRule(c, r, f)
+				}
+				index.buildFiles[rel] = f.Path
+			}
+			return
+		}
+
+		// If the directory is frozen, index the build file as it is. Rules are
+		// still generated and merged into a copy, so changes can be reported.
+		if c.Frozen && f != nil {
+			if c.IndexLibraries {
+				for _, r := range f.Rules {
+					ruleIndex.AddRule(c, r, f)
+				}
+				index.buildFiles[rel] = f.Path
+			}
+			cf, err := rule.LoadData(f.Path, f.Pkg, f.Format())
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			f = cf
+		}
+
+		// Fix any problems in the file.
+		if f != nil {
+			for _, l := range opts.Languages {
+				l.Fix(c, f)
+			}
+		}
+
+		// Generate rules.
+		var empty, gen []*rule.Rule
+		var imports []interface{}
+		for _, l := range opts.Languages {
+			res := l.GenerateRules(language.GenerateArgs{
+				Config:       c,
+				Dir:          dir,
+				Rel:          rel,
+				File:         f,
+				Subdirs:      subdirs,
+				RegularFiles: regularFiles,
+				GenFiles:     genFiles,
+				OtherEmpty:   empty,
+				OtherGen:     gen})
+			if len(res.Gen) != len(res.Imports) {
+				log.Panicf("%s: language %s generated %d rules but returned %d imports", rel, l.Name(), len(res.Gen), len(res.Imports))
+			}
+			empty = append(empty, res.Empty...)
+			gen = append(gen, res.Gen...)
+			imports = append(imports, res.Imports...)
+		}
+		if f == nil && len(gen) == 0 {
+			return
+		}
+
+		// Apply and record relevant kind mappings.
+		var (
+			mappedKinds    []config.MappedKind
+			mappedKindInfo = make(map[string]rule.KindInfo)
+		)
+		for _, r := range gen {
+			if repl, ok := c.KindMap[r.Kind()]; ok {
+				info := kinds[r.Kind()]
+				info.ReplacesKinds = append(info.ReplacesKinds[:len(info.ReplacesKinds):len(info.ReplacesKinds)], repl.FromKind)
+				mappedKindInfo[repl.KindName] = info
+				mappedKinds = append(mappedKinds, repl)
+				mrslv.MappedKind(rel, repl)
+				r.SetKind(repl.KindName)
+			}
+		}
+
+		// Insert or merge rules into the build file.
+		if f == nil {
+			f = rule.EmptyFile(filepath.Join(dir, c.DefaultBuildFileName()), rel)
+			for _, r := range gen {
+				r.Insert(f)
+			}
+		} else {
+			merger.MergeFileConfirm(f, empty, gen, merger.PreResolve,
+				unionKindInfoMaps(kinds, mappedKindInfo), confirmDeleteFunc(opts, c))
+		}
+		visits = append(visits, visitRecord{
+			pkgRel:         rel,
+			dir:            dir,
+			c:              c,
+			rules:          gen,
+			imports:        imports,
+			empty:          empty,
+			file:           f,
+			mappedKinds:    mappedKinds,
+			mappedKindInfo: mappedKindInfo,
+			frozen:         c.Frozen,
+		})
+
+		// Add library rules to the dependency resolution table.
+		if c.IndexLibraries && !c.Frozen {
+			for _, r := range f.Rules {
+				ruleIndex.AddRule(c, r, f)
+			}
+			index.buildFiles[rel] = f.Path
+		}
+	})
+
+	// Finish building the index for dependency resolution.
+	ruleIndex.Finish()
+
+	// Resolve dependencies.
+	rc, cleanupRc := repo.NewRemoteCache(opts.Repos)
+	defer func() {
+		if cerr := cleanupRc(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+	report(Progress{Phase: PhaseResolve, Total: len(visits)})
+	for n, v := range visits {
+		for i, r := range v.rules {
+			from := label.New(c.RepoName, v.pkgRel, r.Name())
+			mrslv.Resolver(r, v.pkgRel).Resolve(v.c, ruleIndex, rc, r, v.imports[i], from)
+		}
+		merger.MergeFileConfirm(v.file, v.empty, v.rules, merger.PostResolve,
+			unionKindInfoMaps(kinds, v.mappedKindInfo), confirmDeleteFunc(opts, v.c))
+		report(Progress{Phase: PhaseResolve, Done: n + 1, Total: len(visits)})
+	}
+
+	// Fix load statements.
+	files = make([]UpdatedFile, 0, len(visits))
+	for _, v := range visits {
+		if v.c.SrcsGlobThreshold > 0 {
+			globLargeSrcs(fsys, v.dir, v.file, v.c.SrcsGlobThreshold, unionKindInfoMaps(kinds, v.mappedKindInfo))
+		}
+		merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, loads))
+		files = append(files, UpdatedFile{Config: v.c, File: v.file, Frozen: v.frozen})
+	}
+	return files, nil
+}
+
+// expandSrcsGlobs replaces globs in srcs attributes of rules in f with the
+// files they match in dir. This undoes globLargeSrcs from an earlier run.
+// Only rules of kinds Gazelle generates are changed, and globs Match can't
+// evaluate are left alone.
+func expandSrcsGlobs(fsys walk.FS, dir string, f *rule.File, kinds map[string]rule.KindInfo) {
+	var dirFiles []string
+	for _, r := range f.Rules {
+		if _, ok := kinds[r.Kind()]; !ok {
+			continue
+		}
+		srcs := r.Attr("srcs")
+		if rule.ShouldKeep(srcs) {
+			continue
+		}
+		g, ok := rule.ParseGlobExpr(srcs)
+		if !ok {
+			continue
+		}
+		if dirFiles == nil {
+			dirFiles = listDirFiles(fsys, dir)
+		}
+		r.SetAttr("srcs", g.Match(dirFiles))
+	}
+}
+
+// globLargeSrcs replaces srcs attributes of rules in f that list more than
+// threshold files in dir with globs matching the same files. Large lists
+// slow down loading packages in Bazel. Lists that name generated files or
+// labels are left alone.
+func globLargeSrcs(fsys walk.FS, dir string, f *rule.File, threshold int, kinds map[string]rule.KindInfo) {
+	var dirFiles []string
+	for _, r := range f.Rules {
+		if _, ok := kinds[r.Kind()]; !ok {
+			continue
+		}
+		srcs := r.AttrStrings("srcs")
+		if len(srcs) <= threshold || rule.ShouldKeep(r.Attr("srcs")) {
+			continue
+		}
+		if dirFiles == nil {
+			dirFiles = listDirFiles(fsys, dir)
+		}
+		if g, ok := rule.FilesGlob(srcs, dirFiles); ok {
+			r.SetAttr("srcs", g)
+		}
+	}
+}
+
+// listDirFiles returns the names of files in dir that aren't directories.
+func listDirFiles(fsys walk.FS, dir string) []string {
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		log.Print(err)
+		return []string{}
+	}
+	files := []string{}
+	for _, info := range infos {
+		if !info.IsDir() {
+			files = append(files, info.Name())
+		}
+	}
+	return files
+}
+
+// confirmDeleteFunc adapts opts.ConfirmDelete for merger.MergeFileConfirm.
+// It returns nil if opts.ConfirmDelete is not set.
+func confirmDeleteFunc(opts Options, c *config.Config) func(*rule.File, *rule.Rule) bool {
+	if opts.ConfirmDelete == nil {
+		return nil
+	}
+	return func(f *rule.File, r *rule.Rule) bool {
+		return opts.ConfirmDelete(c, f, r)
+	}
+}
+
+// WriteFile formats a build file and writes it to the path returned by
+// OutputPath, creating parent directories if needed.
+func WriteFile(c *config.Config, f *rule.File) error {
+	outPath := OutputPath(c, f)
+	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outPath, f.Format(), 0666)
+}
+
+// OutputPath returns the path where a build file should be written. This is
+// usually the path the file was read from, but it may be different if
+// c.ReadBuildFilesDir or c.WriteBuildFilesDir is set.
+func OutputPath(c *config.Config, f *rule.File) string {
+	if c.ReadBuildFilesDir == "" && c.WriteBuildFilesDir == "" {
+		return f.Path
+	}
+	baseDir := c.WriteBuildFilesDir
+	if c.WriteBuildFilesDir == "" {
+		baseDir = c.RepoRoot
+	}
+	outputDir := filepath.Join(baseDir, filepath.FromSlash(f.Pkg))
+	defaultOutputPath := filepath.Join(outputDir, c.DefaultBuildFileName())
+	files, err := ioutil.ReadDir(outputDir)
+	if err != nil {
+		// Ignore error. Directory probably doesn't exist.
+		return defaultOutputPath
+	}
+	outputPath := rule.MatchBuildFileName(outputDir, c.ValidBuildFileNames, files)
+	if outputPath == "" {
+		return defaultOutputPath
+	}
+	return outputPath
+}
+
+func unionKindInfoMaps(a, b map[string]rule.KindInfo) map[string]rule.KindInfo {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	result := make(map[string]rule.KindInfo, len(a)+len(b))
+	for _, m := range []map[string]rule.KindInfo{a, b} {
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// applyKindMappings returns a copy of LoadInfo that includes c.KindMap.
+func applyKindMappings(mappedKinds []config.MappedKind, loads []rule.LoadInfo) []rule.LoadInfo {
+	if len(mappedKinds) == 0 {
+		return loads
+	}
+
+	// Add new RuleInfos or replace existing ones with merged ones.
+	mappedLoads := make([]rule.LoadInfo, len(loads))
+	copy(mappedLoads, loads)
+	for _, mappedKind := range mappedKinds {
+		mappedLoads = appendOrMergeKindMapping(mappedLoads, mappedKind)
+	}
+	return mappedLoads
+}
+
+// appendOrMergeKindMapping adds LoadInfo for the given replacement.
+func appendOrMergeKindMapping(mappedLoads []rule.LoadInfo, mappedKind config.MappedKind) []rule.LoadInfo {
+	// If mappedKind.KindLoad already exists in the list, create a merged copy.
+	for i, load := range mappedLoads {
+		if load.Name == mappedKind.KindLoad {
+			mappedLoads[i].Symbols = append(load.Symbols, mappedKind.KindName)
+			return mappedLoads
+		}
+	}
+
+	// Add a new LoadInfo.
+	return append(mappedLoads, rule.LoadInfo{
+		Name:    mappedKind.KindLoad,
+		Symbols: []string{mappedKind.KindName},
+	})
+}
@@ -0,0 +1,502 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	golang "github.com/bazelbuild/bazel-gazelle/language/go"
+	"github.com/bazelbuild/bazel-gazelle/language/proto"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+func TestUpdate(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/repo",
+		}, {
+			Path:    "hello/hello.go",
+			Content: "package hello\n\nimport _ \"example.com/repo/world\"\n",
+		}, {
+			Path:    "world/world.go",
+			Content: "package world\n",
+		}, {
+			Path: "world/BUILD.bazel",
+			Content: `
+go_library(
+    name = "go_default_library",
+    srcs = ["world.go"],
+    importpath = "example.com/repo/world",
+)
+`,
+		},
+	})
+	defer cleanup()
+
+	langs := []language.Language{proto.NewLanguage(), golang.NewLanguage()}
+	cexts := DefaultConfigurers()
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+	}
+	c, args, err := NewConfig("update", []string{"-repo_root", dir, "hello"}, cexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "hello" {
+		t.Errorf("args: got %q; want [hello]", args)
+	}
+
+	// Only the hello directory is updated, but world is indexed since
+	// it has a build file.
+	files, err := Update(Options{
+		Config:      c,
+		Configurers: cexts,
+		Languages:   langs,
+		Dirs:        []string{filepath.Join(dir, "hello")},
+		Mode:        walk.VisitAllUpdateDirsMode,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files; want 1", len(files))
+	}
+	for _, f := range files {
+		if err := WriteFile(f.Config, f.File); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "hello/BUILD.bazel",
+		Content: strings.TrimSpace(`
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["hello.go"],
+    importpath = "example.com/repo/hello",
+    visibility = ["//visibility:public"],
+    deps = ["//world:go_default_library"],
+)
+`),
+	}})
+}
+
+func TestUpdateWithIndex(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/repo",
+		}, {
+			Path:    "hello/hello.go",
+			Content: "package hello\n\nimport _ \"example.com/repo/world\"\n",
+		}, {
+			Path:    "world/world.go",
+			Content: "package world\n",
+		},
+	})
+	defer cleanup()
+
+	langs := []language.Language{proto.NewLanguage(), golang.NewLanguage()}
+	cexts := DefaultConfigurers()
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+	}
+	c, _, err := NewConfig("update", []string{"-repo_root", dir}, cexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := NewIndex()
+	update := func(mode walk.Mode, dirs ...string) {
+		t.Helper()
+		for i := range dirs {
+			dirs[i] = filepath.Join(dir, dirs[i])
+		}
+		files, err := Update(Options{
+			Config:      c,
+			Configurers: cexts,
+			Languages:   langs,
+			Dirs:        dirs,
+			Mode:        mode,
+			Index:       index,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if err := WriteFile(f.Config, f.File); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Index and update the whole repository.
+	update(walk.VisitAllUpdateSubdirsMode)
+
+	// Rename the library in world, then update only that directory.
+	worldBuild := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "world_lib",
+    srcs = ["world.go"],
+    importpath = "example.com/repo/world",
+    visibility = ["//visibility:public"],
+)
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "world", "BUILD.bazel"), []byte(worldBuild), 0666); err != nil {
+		t.Fatal(err)
+	}
+	update(walk.UpdateDirsMode, "world")
+
+	// Update hello without visiting world. The dependency is resolved with
+	// the rule indexed by the previous call.
+	update(walk.UpdateDirsMode, "hello")
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "hello/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["hello.go"],
+    importpath = "example.com/repo/hello",
+    visibility = ["//visibility:public"],
+    deps = ["//world:world_lib"],
+)
+`,
+	}})
+
+	// Delete world, then update hello without visiting it. The rules indexed
+	// for world are forgotten, since its build file no longer exists, so the
+	// import can't be resolved.
+	if err := os.RemoveAll(filepath.Join(dir, "world")); err != nil {
+		t.Fatal(err)
+	}
+	update(walk.UpdateDirsMode, "hello")
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "hello/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["hello.go"],
+    importpath = "example.com/repo/hello",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestUpdateReportsProgress(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/repo",
+		}, {
+			Path:    "hello/hello.go",
+			Content: "package hello\n",
+		}, {
+			Path:    "world/world.go",
+			Content: "package world\n",
+		},
+	})
+	defer cleanup()
+
+	langs := []language.Language{proto.NewLanguage(), golang.NewLanguage()}
+	cexts := DefaultConfigurers()
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+	}
+	c, _, err := NewConfig("update", []string{"-repo_root", dir}, cexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Progress
+	if _, err := Update(Options{
+		Config:         c,
+		Configurers:    cexts,
+		Languages:      langs,
+		ReportProgress: func(p Progress) { got = append(got, p) },
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Progress{
+		{Phase: PhaseWalk},
+		{Phase: PhaseWalk, Done: 1},
+		{Phase: PhaseWalk, Done: 2},
+		{Phase: PhaseWalk, Done: 3},
+		{Phase: PhaseResolve, Total: 3},
+		{Phase: PhaseResolve, Done: 1, Total: 3},
+		{Phase: PhaseResolve, Done: 2, Total: 3},
+		{Phase: PhaseResolve, Done: 3, Total: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+// ccLang is a minimal C++ extension. It owns cc_library and indexes rules
+// by their headers, but doesn't generate anything.
+type ccLang struct{}
+
+func (*ccLang) Name() string                                                 { return "cc" }
+func (*ccLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {}
+func (*ccLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error          { return nil }
+func (*ccLang) KnownDirectives() []string                                    { return nil }
+func (*ccLang) Configure(c *config.Config, rel string, f *rule.File)         {}
+func (*ccLang) Kinds() map[string]rule.KindInfo {
+	return map[string]rule.KindInfo{"cc_library": {}}
+}
+func (*ccLang) Loads() []rule.LoadInfo { return nil }
+func (*ccLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	return language.GenerateResult{}
+}
+func (*ccLang) Fix(c *config.Config, f *rule.File) {}
+func (*ccLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	var imps []resolve.ImportSpec
+	for _, hdr := range r.AttrStrings("hdrs") {
+		imps = append(imps, resolve.ImportSpec{Lang: "cc", Imp: hdr})
+	}
+	return imps
+}
+func (*ccLang) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
+func (*ccLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+}
+
+func TestUpdateCgoWithCcLanguage(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:prefix example.com/repo",
+		}, {
+			Path: "hello/hello.go",
+			Content: `package hello
+
+// #include "foo/foo.h"
+import "C"
+`,
+		}, {
+			Path: "foo/BUILD.bazel",
+			Content: `
+cc_library(
+    name = "foo",
+    hdrs = ["foo.h"],
+)
+`,
+		},
+	})
+	defer cleanup()
+
+	// Both the C++ extension and the Go extension know about cc_library.
+	// The C++ extension owns the kind, and the Go extension still indexes
+	// its headers for cgo.
+	langs := []language.Language{proto.NewLanguage(), golang.NewLanguage(), &ccLang{}}
+	cexts := DefaultConfigurers()
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+	}
+	c, _, err := NewConfig("update", []string{"-repo_root", dir}, cexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := Update(Options{
+		Config:      c,
+		Configurers: cexts,
+		Languages:   langs,
+		Dirs:        []string{filepath.Join(dir, "hello")},
+		Mode:        walk.VisitAllUpdateDirsMode,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := WriteFile(f.Config, f.File); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "hello/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["hello.go"],
+    cdeps = ["//foo"],
+    cgo = True,
+    importpath = "example.com/repo/hello",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestUpdateWithFS(t *testing.T) {
+	// The repository root exists on disk, but the files below exist only in
+	// memory. Configuration files in the repository are read through the
+	// file system, too.
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+	fs := memFS{
+		filepath.Join(dir, "go.work"):                                   "go 1.21\n\nuse ./a\n",
+		filepath.Join(dir, "a", "go.mod"):                               "module example.com/a\n",
+		filepath.Join(dir, "a", "a.go"):                                 "package a\n\nimport _ \"example.com/dep\"\n",
+		filepath.Join(dir, "vendor", "modules.txt"):                     "# example.com/dep v1.0.0\n## explicit\nexample.com/dep\n",
+		filepath.Join(dir, "vendor", "example.com", "dep", "dep.go"):    "package dep\n",
+		filepath.Join(dir, "vendor", "example.com", "dep", "x", "x.go"): "package x\n",
+		filepath.Join(dir, "protos", "buf.yaml"):                        "version: v1\n",
+		filepath.Join(dir, "protos", "foo", "foo.proto"):                "syntax = \"proto3\";\n\npackage foo;\n",
+	}
+
+	langs := []language.Language{proto.NewLanguage(), golang.NewLanguage()}
+	cexts := DefaultConfigurers()
+	for _, lang := range langs {
+		cexts = append(cexts, lang)
+	}
+	c, _, err := NewConfig("update", []string{"-repo_root", dir, "-go_prefix", "example.com/m", "-external", "vendored"}, cexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := Update(Options{
+		Config:      c,
+		Configurers: cexts,
+		Languages:   langs,
+		Mode:        walk.VisitAllUpdateSubdirsMode,
+		FS:          fs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, f := range files {
+		for _, r := range f.File.Rules {
+			key := f.File.Pkg + ":" + r.Kind()
+			switch r.Kind() {
+			case "go_library":
+				got[key] = r.AttrString("importpath") + " " + strings.Join(r.AttrStrings("deps"), " ")
+			case "proto_library":
+				got[key] = r.AttrString("strip_import_prefix")
+			}
+		}
+	}
+	want := map[string]string{
+		// The module path comes from go.work and a/go.mod.
+		"a:go_library": "example.com/a //vendor/example.com/dep:go_default_library",
+		// Only packages listed in vendor/modules.txt are generated.
+		"vendor/example.com/dep:go_library": "example.com/dep ",
+		// .proto files are imported relative to the Buf module in buf.yaml.
+		"protos/foo:proto_library": "/protos",
+		"protos/foo:go_library":    "example.com/m/protos/foo ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+// memFS is an in-memory walk.FS. Keys are file paths, and values are file
+// contents. Directories are implied by the files they contain.
+type memFS map[string]string
+
+func (fs memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := name + string(filepath.Separator)
+	seen := make(map[string]bool)
+	var infos []os.FileInfo
+	for p, content := range fs {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := p[len(prefix):]
+		base := rest
+		isDir := false
+		if i := strings.IndexRune(rest, filepath.Separator); i >= 0 {
+			base, isDir = rest[:i], true
+		}
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		infos = append(infos, memFileInfo{name: base, size: int64(len(content)), dir: isDir})
+	}
+	if len(infos) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs memFS) Stat(name string) (os.FileInfo, error) {
+	if content, ok := fs[name]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(content))}, nil
+	}
+	if _, err := fs.ReadDir(name); err == nil {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs memFS) ReadFile(name string) ([]byte, error) {
+	if content, ok := fs[name]; ok {
+		return []byte(content), nil
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs memFS) EvalSymlinks(name string) (string, error) { return name, nil }
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi memFileInfo) Name() string { return fi.name }
+func (fi memFileInfo) Size() int64  { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
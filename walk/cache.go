@@ -0,0 +1,165 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dirCacheVersion is stored in cache files. Files with a different version
+// are discarded.
+const dirCacheVersion = 1
+
+// dirCache is an on-disk cache of directory listings, set with the
+// -walk_cache flag. Each listing is keyed by the directory's modification
+// time, which changes when entries are added, removed, or renamed, so
+// repeated runs on a mostly unchanged tree only read directories that
+// changed.
+type dirCache struct {
+	path string
+
+	// start is when the cache was loaded. Directories modified since then
+	// aren't stored, since a later change within the file system's
+	// timestamp granularity wouldn't change their modification time.
+	start time.Time
+
+	dirs    map[string]cachedDir
+	changed bool
+}
+
+// cachedDir is the listing of a directory, stored by absolute path.
+type cachedDir struct {
+	ModTime int64         `json:"mtime"`
+	Entries []cachedEntry `json:"entries"`
+}
+
+// cachedEntry is a directory entry. Only names and modes are stored, which
+// is all Walk needs.
+type cachedEntry struct {
+	Name string      `json:"name"`
+	Mode os.FileMode `json:"mode"`
+}
+
+type dirCacheFile struct {
+	Version int                  `json:"version"`
+	Dirs    map[string]cachedDir `json:"dirs"`
+}
+
+// loadDirCache reads the cache file at path. If the file doesn't exist or
+// was written by a different version, an empty cache is returned.
+func loadDirCache(path string) (*dirCache, error) {
+	dc := &dirCache{path: path, start: time.Now(), dirs: make(map[string]cachedDir)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return dc, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var f dirCacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return dc, fmt.Errorf("%s: discarding invalid walk cache: %v", path, err)
+	}
+	if f.Version == dirCacheVersion && f.Dirs != nil {
+		dc.dirs = f.Dirs
+	}
+	return dc, nil
+}
+
+// save writes the cache to its file if any listing changed. The file is
+// replaced atomically, so a concurrent run reads either version.
+func (dc *dirCache) save() error {
+	if !dc.changed {
+		return nil
+	}
+	data, err := json.Marshal(dirCacheFile{Version: dirCacheVersion, Dirs: dc.dirs})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dc.path), 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dc.path), filepath.Base(dc.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dc.path)
+}
+
+// cachingFS is an FS that serves directory listings from a dirCache when
+// a directory's modification time hasn't changed. Each cached listing costs
+// one Stat instead of a ReadDir, which stats every entry.
+type cachingFS struct {
+	FS
+	cache *dirCache
+}
+
+func (fs *cachingFS) ReadDir(name string) ([]os.FileInfo, error) {
+	fi, err := fs.FS.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	modTime := fi.ModTime().UnixNano()
+	if d, ok := fs.cache.dirs[name]; ok && d.ModTime == modTime {
+		files := make([]os.FileInfo, len(d.Entries))
+		for i, e := range d.Entries {
+			files[i] = cachedFileInfo{e}
+		}
+		return files, nil
+	}
+
+	files, err := fs.FS.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.ModTime().Before(fs.cache.start.Truncate(time.Second)) {
+		d := cachedDir{ModTime: modTime, Entries: make([]cachedEntry, len(files))}
+		for i, f := range files {
+			d.Entries[i] = cachedEntry{Name: f.Name(), Mode: f.Mode()}
+		}
+		fs.cache.dirs[name] = d
+		fs.cache.changed = true
+	} else if _, ok := fs.cache.dirs[name]; ok {
+		delete(fs.cache.dirs, name)
+		fs.cache.changed = true
+	}
+	return files, nil
+}
+
+// cachedFileInfo is an os.FileInfo for a cached directory entry. Size and
+// modification time are not cached and are reported as zero.
+type cachedFileInfo struct {
+	e cachedEntry
+}
+
+func (fi cachedFileInfo) Name() string       { return fi.e.Name }
+func (fi cachedFileInfo) Size() int64        { return 0 }
+func (fi cachedFileInfo) Mode() os.FileMode  { return fi.e.Mode }
+func (fi cachedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi cachedFileInfo) IsDir() bool        { return fi.e.Mode.IsDir() }
+func (fi cachedFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,141 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestWalkCache(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "repo/BUILD.bazel"},
+		{Path: "repo/a/a.go"},
+		{Path: "repo/a/b/b.go"},
+		{Path: "repo/c/c.go"},
+	})
+	defer cleanup()
+	repoRoot := filepath.Join(dir, "repo")
+	cachePath := filepath.Join(dir, "cache", "walk.json")
+
+	// Directories modified during a run aren't cached, so make them older.
+	old := time.Now().Add(-time.Hour)
+	for _, rel := range []string{"", "a", "a/b", "c"} {
+		if err := os.Chtimes(filepath.Join(repoRoot, filepath.FromSlash(rel)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk := func() (files, readDirs []string) {
+		cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}
+		c := testtools.NewTestConfig(t, cexts, nil, []string{"-repo_root", repoRoot, "-walk_cache", cachePath})
+		cfs := GetFS(c).(*cachingFS)
+		counter := &readDirCounter{FS: cfs.FS}
+		cfs.FS = counter
+		Walk(c, cexts, []string{repoRoot}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+			for _, f := range regularFiles {
+				files = append(files, path.Join(rel, f))
+			}
+		})
+		sort.Strings(files)
+		for _, d := range counter.dirs {
+			rel, _ := filepath.Rel(repoRoot, d)
+			readDirs = append(readDirs, filepath.ToSlash(rel))
+		}
+		sort.Strings(readDirs)
+		return files, readDirs
+	}
+
+	files, readDirs := walk()
+	wantFiles := []string{"BUILD.bazel", "a/a.go", "a/b/b.go", "c/c.go"}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("first run: got files %q; want %q", files, wantFiles)
+	}
+	if want := []string{".", "a", "a/b", "c"}; !reflect.DeepEqual(readDirs, want) {
+		t.Errorf("first run: got reads %q; want %q", readDirs, want)
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("cache not written: %v", err)
+	}
+
+	// Only the directory that changed is read again.
+	if err := ioutil.WriteFile(filepath.Join(repoRoot, "a", "new.go"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	files, readDirs = walk()
+	wantFiles = []string{"BUILD.bazel", "a/a.go", "a/b/b.go", "a/new.go", "c/c.go"}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("second run: got files %q; want %q", files, wantFiles)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(readDirs, want) {
+		t.Errorf("second run: got reads %q; want %q", readDirs, want)
+	}
+
+	// Editing a build file doesn't change any listing, so the cache isn't
+	// written again.
+	before, err := os.Stat(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repoRoot, "BUILD.bazel"), []byte("# edited\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	walk()
+	if after, err := os.Stat(cachePath); err != nil {
+		t.Fatal(err)
+	} else if !os.SameFile(before, after) {
+		t.Errorf("third run: cache was rewritten")
+	}
+}
+
+func TestLoadDirCacheInvalid(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "invalid.json", Content: "{"},
+		{Path: "version.json", Content: `{"version":0,"dirs":{"/x":{"mtime":1}}}`},
+	})
+	defer cleanup()
+
+	if dc, err := loadDirCache(filepath.Join(dir, "invalid.json")); dc == nil || err == nil || len(dc.dirs) != 0 {
+		t.Errorf("invalid file: got %v, %v; want empty cache and error", dc, err)
+	}
+	if dc, err := loadDirCache(filepath.Join(dir, "version.json")); err != nil || len(dc.dirs) != 0 {
+		t.Errorf("old version: got %v, %v; want empty cache", dc, err)
+	}
+	if dc, err := loadDirCache(filepath.Join(dir, "missing.json")); err != nil || len(dc.dirs) != 0 {
+		t.Errorf("missing file: got %v, %v; want empty cache", dc, err)
+	}
+}
+
+// readDirCounter is an FS that records the directories it lists.
+type readDirCounter struct {
+	FS
+	dirs []string
+}
+
+func (fs *readDirCounter) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.dirs = append(fs.dirs, name)
+	return fs.FS.ReadDir(name)
+}
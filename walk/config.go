@@ -16,9 +16,14 @@ limitations under the License.
 package walk
 
 import (
+	"bufio"
+	"bytes"
 	"flag"
+	"fmt"
 	"log"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
@@ -35,6 +40,24 @@ type walkConfig struct {
 	excludes []string
 	ignore   bool
 	follow   []string
+	fs       FS
+
+	// ignorePaths are directories listed in the .bazelignore file at the
+	// repository root. Bazel doesn't look for packages in them or in their
+	// subdirectories, so neither does Gazelle.
+	ignorePaths []string
+
+	// gitignore is set with the -gitignore flag. When true, files and
+	// directories matched by .gitignore files are skipped.
+	gitignore bool
+
+	// gitignores are patterns read from .gitignore files in the current
+	// directory and its parents, in the order they apply.
+	gitignores []gitignorePattern
+
+	// cachePath is the file directory listings are cached in, set with the
+	// -walk_cache flag. See dirCache.
+	cachePath string
 }
 
 const walkName = "_walk"
@@ -48,6 +71,11 @@ func (wc *walkConfig) isExcluded(rel, base string) bool {
 		return true
 	}
 	f := path.Join(rel, base)
+	for _, p := range wc.ignorePaths {
+		if f == p || strings.HasPrefix(f, p+"/") {
+			return true
+		}
+	}
 	for _, x := range wc.excludes {
 		matched, err := doublestar.Match(x, f)
 		if err != nil {
@@ -64,15 +92,47 @@ func (wc *walkConfig) isExcluded(rel, base string) bool {
 	return false
 }
 
+// IsFollowed returns whether the directory rel is, or is inside, a symbolic
+// link listed with # gazelle:follow. c must be the configuration for rel.
+// Links into the repository are only followed when listed, so directories
+// reached through them are always reported.
+func IsFollowed(c *config.Config, rel string) bool {
+	wc, ok := c.Exts[walkName].(*walkConfig)
+	if !ok {
+		return false
+	}
+	for _, f := range wc.follow {
+		if rel == f || strings.HasPrefix(rel, f+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 type Configurer struct{}
 
 func (_ *Configurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	wc := &walkConfig{}
 	c.Exts[walkName] = wc
 	fs.Var(&gzflag.MultiFlag{Values: &wc.excludes}, "exclude", "pattern that should be ignored (may be repeated)")
+	fs.StringVar(&wc.cachePath, "walk_cache", "", "file where directory listings are cached between runs, keyed by modification time")
+	fs.BoolVar(&wc.gitignore, "gitignore", false, "when true, files and directories matched by .gitignore files are skipped")
 }
 
-func (_ *Configurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error { return nil }
+func (_ *Configurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	wc := getWalkConfig(c)
+	if wc.cachePath == "" {
+		return nil
+	}
+	cache, err := loadDirCache(wc.cachePath)
+	if cache == nil {
+		return err
+	} else if err != nil {
+		log.Print(err)
+	}
+	wc.fs = &cachingFS{FS: GetFS(c), cache: cache}
+	return nil
+}
 
 func (_ *Configurer) KnownDirectives() []string {
 	return []string{"exclude", "follow", "ignore"}
@@ -84,6 +144,28 @@ func (cr *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	*wcCopy = *wc
 	wcCopy.ignore = false
 
+	if rel == "" {
+		name := filepath.Join(c.RepoRoot, ".bazelignore")
+		if data, err := GetFS(c).ReadFile(name); err == nil {
+			var errs []error
+			wcCopy.ignorePaths, errs = parseBazelIgnore(name, data)
+			for _, err := range errs {
+				log.Print(err)
+			}
+		}
+	}
+
+	if wcCopy.gitignore {
+		name := filepath.Join(c.RepoRoot, filepath.FromSlash(rel), ".gitignore")
+		if data, err := GetFS(c).ReadFile(name); err == nil {
+			patterns, errs := parseGitignore(name, rel, data)
+			for _, err := range errs {
+				log.Print(err)
+			}
+			wcCopy.gitignores = append(wcCopy.gitignores[:len(wcCopy.gitignores):len(wcCopy.gitignores)], patterns...)
+		}
+	}
+
 	if f != nil {
 		for _, d := range f.Directives {
 			switch d.Key {
@@ -108,3 +190,36 @@ func checkPathMatchPattern(pattern string) error {
 	_, err := doublestar.Match(pattern, "x")
 	return err
 }
+
+// parseBazelIgnore parses the contents of a .bazelignore file, named name.
+// It returns the directories to ignore, relative to the repository root, and
+// errors describing entries that Bazel would reject or treat differently
+// than their authors likely expect.
+//
+// The semantics match Bazel's: each line is a path relative to the
+// repository root. Leading and trailing space and trailing slashes are
+// ignored, and lines that are empty or start with '#' are skipped. An entry
+// ignores the directory it names and everything under it. Globs are not
+// supported; '*' and other special characters match themselves.
+func parseBazelIgnore(name string, data []byte) (ignorePaths []string, errs []error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		entry := strings.TrimSpace(sc.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		clean := path.Clean(entry)
+		switch {
+		case path.IsAbs(clean):
+			errs = append(errs, fmt.Errorf("%s:%d: entry %q must be relative to the repository root; skipping it", name, line, entry))
+			continue
+		case clean == "." || clean == ".." || strings.HasPrefix(clean, "../"):
+			errs = append(errs, fmt.Errorf("%s:%d: entry %q does not name a directory in the repository; skipping it", name, line, entry))
+			continue
+		case strings.ContainsAny(clean, "*?["):
+			errs = append(errs, fmt.Errorf("%s:%d: entry %q looks like a glob, but .bazelignore doesn't support globs, so only a directory with this exact name is ignored. Use '# gazelle:exclude' in a build file for patterns that only Gazelle should skip", name, line, entry))
+		}
+		ignorePaths = append(ignorePaths, clean)
+	}
+	return ignorePaths, errs
+}
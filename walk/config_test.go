@@ -1,6 +1,8 @@
 package walk
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/bmatcuk/doublestar"
@@ -26,3 +28,35 @@ func TestCheckPathMatchPattern(t *testing.T) {
 		}
 	}
 }
+
+func TestParseBazelIgnore(t *testing.T) {
+	data := []byte(`# comment
+
+node_modules
+  out/
+./a//b/
+*.cache
+/abs
+../up
+.
+`)
+	got, errs := parseBazelIgnore(".bazelignore", data)
+	want := []string{"node_modules", "out", "a/b", "*.cache"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+	wantErrs := []string{
+		`.bazelignore:6: entry "*.cache" looks like a glob`,
+		`.bazelignore:7: entry "/abs" must be relative`,
+		`.bazelignore:8: entry "../up" does not name a directory`,
+		`.bazelignore:9: entry "." does not name a directory`,
+	}
+	if len(errs) != len(wantErrs) {
+		t.Fatalf("got errors %v; want %d errors", errs, len(wantErrs))
+	}
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), wantErrs[i]) {
+			t.Errorf("error %d: got %q; want prefix %q", i, err, wantErrs[i])
+		}
+	}
+}
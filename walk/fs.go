@@ -0,0 +1,75 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// FS is the file system Walk reads directories and build files from. The
+// default is OSFS, which reads the real disk. Other implementations may
+// serve a virtual file system, for example, a sparse checkout, a snapshot
+// of a repository in an archive, or an in-memory tree in tests.
+//
+// Names passed to FS methods are file system paths under c.RepoRoot (or
+// c.ReadBuildFilesDir), as constructed with path/filepath.
+//
+// The FS is carried by the configuration, so language extensions can read
+// source files through it with GetFS.
+type FS interface {
+	// ReadDir returns information about the entries in a directory, sorted
+	// by name. Symbolic links should be reported with os.ModeSymlink set.
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	// Stat returns information about a file, following symbolic links.
+	Stat(name string) (os.FileInfo, error)
+
+	// ReadFile returns the contents of a file.
+	ReadFile(name string) ([]byte, error)
+
+	// EvalSymlinks returns the path name after evaluating any symbolic links.
+	// It's used to avoid visiting the same tree twice through symbolic links.
+	EvalSymlinks(name string) (string, error)
+}
+
+// OSFS is an FS that reads the real file system through the os package.
+type OSFS struct{}
+
+func (OSFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+func (OSFS) ReadFile(name string) ([]byte, error) { return ioutil.ReadFile(name) }
+
+func (OSFS) EvalSymlinks(name string) (string, error) { return filepath.EvalSymlinks(name) }
+
+// SetFS sets the file system Walk reads from when called with c or a
+// configuration derived from c. c must have been initialized by Configurer.
+func SetFS(c *config.Config, fs FS) {
+	getWalkConfig(c).fs = fs
+}
+
+// GetFS returns the file system set with SetFS, or OSFS if none was set.
+func GetFS(c *config.Config) FS {
+	if wc, ok := c.Exts[walkName].(*walkConfig); ok && wc.fs != nil {
+		return wc.fs
+	}
+	return OSFS{}
+}
@@ -0,0 +1,116 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bmatcuk/doublestar"
+)
+
+// gitignorePattern is a pattern read from a .gitignore file.
+type gitignorePattern struct {
+	// dir is the directory containing the .gitignore file, relative to the
+	// repository root. The pattern only applies to files under it.
+	dir string
+
+	// pattern is a doublestar pattern. If anchored is true, it's matched
+	// against paths relative to dir. Otherwise, it's matched against base
+	// names.
+	pattern  string
+	anchored bool
+
+	// negate is true for patterns starting with '!', which re-include files
+	// excluded by earlier patterns.
+	negate bool
+
+	// dirOnly is true for patterns ending with '/', which only match
+	// directories.
+	dirOnly bool
+}
+
+// parseGitignore parses the contents of a .gitignore file, named name, in
+// the directory rel. It returns the patterns in order and errors describing
+// patterns that can't be parsed.
+//
+// The semantics follow git's: lines that are empty or start with '#' are
+// skipped, and trailing spaces are ignored unless escaped with '\'. A
+// pattern containing a '/' before its end is matched relative to the
+// directory of the .gitignore file; otherwise it's matched against base
+// names at any depth. '**' matches any number of directories.
+func parseGitignore(name, rel string, data []byte) (patterns []gitignorePattern, errs []error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		entry := strings.TrimRight(sc.Text(), "\r")
+		for strings.HasSuffix(entry, " ") && !strings.HasSuffix(entry, "\\ ") {
+			entry = entry[:len(entry)-1]
+		}
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		p := gitignorePattern{dir: rel}
+		if strings.HasPrefix(entry, "!") {
+			p.negate = true
+			entry = entry[1:]
+		}
+		if strings.HasSuffix(entry, "/") {
+			p.dirOnly = true
+			entry = strings.TrimRight(entry, "/")
+		}
+		if strings.Contains(entry, "/") {
+			p.anchored = true
+			entry = strings.TrimPrefix(entry, "/")
+		}
+		if entry == "" {
+			continue
+		}
+		if err := checkPathMatchPattern(entry); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: pattern %q is not valid: %v; skipping it", name, line, sc.Text(), err))
+			continue
+		}
+		p.pattern = entry
+		patterns = append(patterns, p)
+	}
+	return patterns, errs
+}
+
+// isGitignored returns whether the file or directory base in the directory
+// rel is ignored by patterns from .gitignore files. As in git, the last
+// matching pattern takes precedence.
+func (wc *walkConfig) isGitignored(rel, base string, isDir bool) bool {
+	f := path.Join(rel, base)
+	ignored := false
+	for _, p := range wc.gitignores {
+		if p.dirOnly && !isDir || !pathtools.HasPrefix(f, p.dir) {
+			continue
+		}
+		var matched bool
+		if p.anchored {
+			matched, _ = doublestar.Match(p.pattern, pathtools.TrimPrefix(f, p.dir))
+		} else {
+			matched, _ = doublestar.Match(p.pattern, base)
+		}
+		if matched {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,52 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGitignore(t *testing.T) {
+	data := []byte(`# comment
+
+*.log
+!keep.log
+out/
+/scratch
+a/**/b  
+trailing\ 
+\#hash
+[z-
+`)
+	got, errs := parseGitignore("sub/.gitignore", "sub", data)
+	want := []gitignorePattern{
+		{dir: "sub", pattern: "*.log"},
+		{dir: "sub", pattern: "keep.log", negate: true},
+		{dir: "sub", pattern: "out", dirOnly: true},
+		{dir: "sub", pattern: "scratch", anchored: true},
+		{dir: "sub", pattern: "a/**/b", anchored: true},
+		{dir: "sub", pattern: `trailing\ `},
+		{dir: "sub", pattern: `\#hash`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), `sub/.gitignore:10: pattern "[z-" is not valid`) {
+		t.Errorf("got errors %v; want one error for line 10", errs)
+	}
+}
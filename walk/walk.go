@@ -18,7 +18,6 @@ limitations under the License.
 package walk
 
 import (
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -72,7 +71,8 @@ const (
 // including excluded files.
 //
 // genFiles is a list of names of generated files, found by reading
-// "out" and "outs" attributes of rules in f.
+// "out" and "outs" attributes of rules in f. These are not filtered by
+// .gitignore files, since generated files are often ignored by git.
 type WalkFunc func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string)
 
 // Walk traverses the directory tree rooted at c.RepoRoot. Walk visits
@@ -109,8 +109,16 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 			knownDirectives[d] = true
 		}
 	}
+	schema, err := config.CollectSchema(cexts)
+	if err != nil {
+		log.Print(err)
+	}
+	for name := range schema {
+		knownDirectives[name] = true
+	}
 
-	symlinks := symlinkResolver{visited: []string{c.RepoRoot}}
+	fs := GetFS(c)
+	symlinks := symlinkResolver{fs: fs, visited: []string{c.RepoRoot}}
 
 	updateRels := buildUpdateRelMap(c.RepoRoot, dirs)
 
@@ -121,19 +129,19 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 		// TODO: OPT: ReadDir stats all the files, which is slow. We just care about
 		// names and modes, so we should use something like
 		// golang.org/x/tools/internal/fastwalk to speed this up.
-		files, err := ioutil.ReadDir(dir)
+		files, err := fs.ReadDir(dir)
 		if err != nil {
 			log.Print(err)
 			return
 		}
 
-		f, err := loadBuildFile(c, rel, dir, files)
+		f, err := loadBuildFile(fs, c, rel, dir, files)
 		if err != nil {
 			log.Print(err)
 			haveError = true
 		}
 
-		c = configure(cexts, knownDirectives, c, rel, f)
+		c = configure(cexts, knownDirectives, schema, c, rel, f)
 		wc := getWalkConfig(c)
 
 		if wc.isExcluded(rel, ".") {
@@ -144,7 +152,7 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 		for _, fi := range files {
 			base := fi.Name()
 			switch {
-			case base == "" || wc.isExcluded(rel, base):
+			case base == "" || wc.isExcluded(rel, base) || wc.isGitignored(rel, base, fi.IsDir()):
 				continue
 
 			case fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 && symlinks.follow(c, dir, rel, base):
@@ -169,6 +177,12 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 		}
 	}
 	visit(c, c.RepoRoot, "", false)
+
+	if cfs, ok := fs.(*cachingFS); ok {
+		if err := cfs.cache.save(); err != nil {
+			log.Print(err)
+		}
+	}
 }
 
 // buildUpdateRelMap builds a table of prefixes, used to determine which
@@ -228,13 +242,13 @@ func shouldVisit(rel string, mode Mode, updateRels map[string]bool) bool {
 	return ok
 }
 
-func loadBuildFile(c *config.Config, pkg, dir string, files []os.FileInfo) (*rule.File, error) {
+func loadBuildFile(fs FS, c *config.Config, pkg, dir string, files []os.FileInfo) (*rule.File, error) {
 	var err error
 	readDir := dir
 	readFiles := files
 	if c.ReadBuildFilesDir != "" {
 		readDir = filepath.Join(c.ReadBuildFilesDir, filepath.FromSlash(pkg))
-		readFiles, err = ioutil.ReadDir(readDir)
+		readFiles, err = fs.ReadDir(readDir)
 		if err != nil {
 			return nil, err
 		}
@@ -243,10 +257,14 @@ func loadBuildFile(c *config.Config, pkg, dir string, files []os.FileInfo) (*rul
 	if path == "" {
 		return nil, nil
 	}
-	return rule.LoadFile(path, pkg)
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return rule.LoadData(path, pkg, data)
 }
 
-func configure(cexts []config.Configurer, knownDirectives map[string]bool, c *config.Config, rel string, f *rule.File) *config.Config {
+func configure(cexts []config.Configurer, knownDirectives map[string]bool, schema config.Schema, c *config.Config, rel string, f *rule.File) *config.Config {
 	if rel != "" {
 		c = c.Clone()
 	}
@@ -257,6 +275,7 @@ func configure(cexts []config.Configurer, knownDirectives map[string]bool, c *co
 			}
 		}
 	}
+	schema.Configure(c, rel, f)
 	for _, cext := range cexts {
 		cext.Configure(c, rel, f)
 	}
@@ -288,6 +307,7 @@ func findGenFiles(wc *walkConfig, f *rule.File) []string {
 }
 
 type symlinkResolver struct {
+	fs      FS
 	visited []string
 }
 
@@ -310,7 +330,7 @@ func (r *symlinkResolver) follow(c *config.Config, dir, rel, base string) bool {
 
 	// See if the symlink points to a tree that has been already visited.
 	fullpath := filepath.Join(dir, base)
-	dest, err := filepath.EvalSymlinks(fullpath)
+	dest, err := r.fs.EvalSymlinks(fullpath)
 	if err != nil {
 		return false
 	}
@@ -326,7 +346,7 @@ func (r *symlinkResolver) follow(c *config.Config, dir, rel, base string) bool {
 		}
 	}
 	r.visited = append(r.visited, dest)
-	stat, err := os.Stat(fullpath)
+	stat, err := r.fs.Stat(fullpath)
 	if err != nil {
 		return false
 	}
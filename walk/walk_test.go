@@ -16,12 +16,18 @@ limitations under the License.
 package walk
 
 import (
+	"bytes"
 	"flag"
+	"log"
+	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
@@ -48,6 +54,49 @@ func TestConfigureCallbackOrder(t *testing.T) {
 	}
 }
 
+func TestDirectiveSchema(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:x_count 2
+# gazelle:x_count two
+`,
+		},
+		{Path: "a/"},
+	})
+	defer cleanup()
+
+	var counts []interface{}
+	var keys []string
+	c, cexts := testConfig(t, dir)
+	cexts = append(cexts, &testSchemaConfigurer{
+		testConfigurer{func(c *config.Config, rel string, f *rule.File) {
+			n, _ := c.DirectiveValue("x_count")
+			counts = append(counts, n)
+			if f != nil {
+				for _, d := range f.Directives {
+					keys = append(keys, d.Key+" "+d.Value)
+				}
+			}
+		}},
+	})
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, _ string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {})
+
+	if want := []interface{}{2, 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got values %#v; want %#v", counts, want)
+	}
+	if want := []string{"x_count 2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got directives %#v; want %#v", keys, want)
+	}
+	if got := logBuf.String(); strings.Contains(got, "unknown directive") || !strings.Contains(got, "invalid directive gazelle:x_count") {
+		t.Errorf("unexpected log output:\n%s", got)
+	}
+}
+
 func TestUpdateDirs(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{Path: "update/sub/"},
@@ -214,6 +263,99 @@ gen(
 	}
 }
 
+func TestBazelIgnore(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    ".bazelignore",
+			Content: "# comment\nnode_modules\n  out/  \n./a/b\n*.cache\n/abs\n",
+		},
+		{Path: "BUILD.bazel"},
+		{Path: "node_modules/x/BUILD.bazel"}, // ignored
+		{Path: "node_modules_extra/BUILD.bazel"},
+		{Path: "out/BUILD.bazel"},   // ignored
+		{Path: "a/b/c/BUILD.bazel"}, // ignored
+		{Path: "a/bc/BUILD.bazel"},
+		{Path: "x/out/BUILD.bazel"}, // entries are relative to the root only
+		{Path: "y.cache/BUILD.bazel"},
+		{Path: "*.cache/BUILD.bazel"}, // ignored, since globs aren't expanded
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var rels []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, f *rule.File, _, _, _ []string) {
+		if f != nil {
+			rels = append(rels, rel)
+		}
+	})
+	sort.Strings(rels)
+	want := []string{"", "a/bc", "node_modules_extra", "x/out", "y.cache"}
+	if !reflect.DeepEqual(rels, want) {
+		t.Errorf("got %#v; want %#v", rels, want)
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    ".gitignore",
+			Content: "# comment\n*.log\n!keep.log\nout/\n/scratch\ndocs/**/*.tmp\n",
+		},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+gen(
+    name = "x",
+    out = "gen.log",
+)
+`,
+		},
+		{Path: "a.go"},
+		{Path: "a.log"},               // ignored by '*.log'
+		{Path: "keep.log"},            // re-included by '!keep.log'
+		{Path: "out/BUILD.bazel"},     // ignored by 'out/'
+		{Path: "scratch/BUILD.bazel"}, // ignored by '/scratch'
+		{Path: "docs/a/b/c.tmp"},      // ignored by 'docs/**/*.tmp'
+		{Path: "docs/d.tmp"},          // ignored by 'docs/**/*.tmp'
+		{Path: "sub/.gitignore", Content: "*.go\n!keep.log\n"},
+		{Path: "sub/scratch/a.proto"}, // '/scratch' is anchored to the root
+		{Path: "sub/out"},             // 'out/' only matches directories
+		{Path: "sub/b.go"},            // ignored by 'sub/.gitignore'
+		{Path: "sub/keep.log"},
+		{Path: "sub/c.log"}, // ignored by '*.log' from the root
+		{Path: "x.go"},
+	})
+	defer cleanup()
+
+	for _, enabled := range []bool{false, true} {
+		args := []string{"-repo_root", dir}
+		if enabled {
+			args = append(args, "-gitignore")
+		}
+		cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}
+		c := testtools.NewTestConfig(t, cexts, nil, args)
+		var files []string
+		Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, genFiles []string) {
+			for _, f := range regularFiles {
+				files = append(files, path.Join(rel, f))
+			}
+			for _, f := range genFiles {
+				files = append(files, path.Join(rel, f))
+			}
+		})
+		sort.Strings(files)
+		var want []string
+		if enabled {
+			want = []string{".gitignore", "BUILD.bazel", "a.go", "gen.log", "keep.log", "sub/.gitignore", "sub/keep.log", "sub/out", "sub/scratch/a.proto", "x.go"}
+		} else {
+			want = []string{".gitignore", "BUILD.bazel", "a.go", "a.log", "docs/a/b/c.tmp", "docs/d.tmp", "gen.log", "keep.log", "out/BUILD.bazel", "scratch/BUILD.bazel", "sub/.gitignore", "sub/b.go", "sub/c.log", "sub/keep.log", "sub/out", "sub/scratch/a.proto", "x.go"}
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("gitignore=%v: got %#v; want %#v", enabled, files, want)
+		}
+	}
+}
+
 func TestExcludeSelf(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{
@@ -445,6 +587,115 @@ func TestSymlinksFollow(t *testing.T) {
 	}
 }
 
+func TestFS(t *testing.T) {
+	// The repository root exists on disk, but the files below exist only in
+	// memory.
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+	fs := memFS{
+		filepath.Join(dir, "BUILD.bazel"):      "# gazelle:exclude skip",
+		filepath.Join(dir, "a", "BUILD.bazel"): `filegroup(name = "a")`,
+		filepath.Join(dir, "a", "a.go"):        "package a",
+		filepath.Join(dir, "a", "b", "b.go"):   "package b",
+		filepath.Join(dir, "skip", "c.go"):     "package c",
+	}
+
+	c, cexts := testConfig(t, dir)
+	SetFS(c, fs)
+	type visit struct {
+		rel          string
+		subdirs      []string
+		regularFiles []string
+		ruleNames    []string
+	}
+	var got []visit
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, f *rule.File, subdirs, regularFiles, _ []string) {
+		v := visit{rel: rel, subdirs: subdirs, regularFiles: regularFiles}
+		if f != nil {
+			for _, r := range f.Rules {
+				v.ruleNames = append(v.ruleNames, r.Name())
+			}
+		}
+		got = append(got, v)
+	})
+	want := []visit{
+		{rel: "a/b", regularFiles: []string{"b.go"}},
+		{rel: "a", subdirs: []string{"b"}, regularFiles: []string{"BUILD.bazel", "a.go"}, ruleNames: []string{"a"}},
+		{rel: "", subdirs: []string{"a"}, regularFiles: []string{"BUILD.bazel"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+// memFS is an in-memory FS. Keys are file paths, and values are file
+// contents. Directories are implied by the files they contain.
+type memFS map[string]string
+
+func (fs memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := name + string(filepath.Separator)
+	seen := make(map[string]bool)
+	var infos []os.FileInfo
+	for p, content := range fs {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := p[len(prefix):]
+		base := rest
+		isDir := false
+		if i := strings.IndexRune(rest, filepath.Separator); i >= 0 {
+			base, isDir = rest[:i], true
+		}
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		infos = append(infos, memFileInfo{name: base, size: int64(len(content)), dir: isDir})
+	}
+	if len(infos) == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs memFS) Stat(name string) (os.FileInfo, error) {
+	if content, ok := fs[name]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(content))}, nil
+	}
+	if _, err := fs.ReadDir(name); err == nil {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs memFS) ReadFile(name string) ([]byte, error) {
+	if content, ok := fs[name]; ok {
+		return []byte(content), nil
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs memFS) EvalSymlinks(name string) (string, error) { return name, nil }
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi memFileInfo) Name() string { return fi.name }
+func (fi memFileInfo) Size() int64  { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
 func testConfig(t *testing.T, dir string) (*config.Config, []config.Configurer) {
 	args := []string{"-repo_root", dir}
 	cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}
@@ -465,3 +716,11 @@ func (_ *testConfigurer) KnownDirectives() []string { return nil }
 func (tc *testConfigurer) Configure(c *config.Config, rel string, f *rule.File) {
 	tc.configure(c, rel, f)
 }
+
+type testSchemaConfigurer struct {
+	testConfigurer
+}
+
+func (_ *testSchemaConfigurer) DirectiveSchema() []config.DirectiveSchema {
+	return []config.DirectiveSchema{{Name: "x_count", Type: config.IntValue}}
+}